@@ -3,25 +3,163 @@ AST (Abstract Syntax Tree) nodes for Go-Extended
 """
 
 from abc import ABC, abstractmethod
-from typing import List, Optional, Any, Dict
-from dataclasses import dataclass
+from functools import lru_cache
+from typing import List, Optional, Any, Callable, Dict, Iterator, NamedTuple, Sequence, Set, Tuple, Union
+from dataclasses import dataclass, field, fields
 
+# Nodes have __slots__: a large file has hundreds of thousands of them, and none needs a __dict__. Trees hold no
+# reference cycles, so the nodes of a file are freed, by reference counting, as soon as nothing uses its AST
+
+@dataclass(slots=True)
 class ASTNode(ABC):
     """Base class for all AST nodes"""
-    pass
+    # Source position (set by the parser where it is reported; 0 when unknown)
+    line: int = field(default=0, kw_only=True, compare=False, repr=False)
+    column: int = field(default=0, kw_only=True, compare=False, repr=False)
+    file: Optional[str] = field(default=None, kw_only=True, compare=False, repr=False)  # go-plus source path
+    # Position just past the node's last token (set for declarations, members and statements)
+    end_line: int = field(default=0, kw_only=True, compare=False, repr=False)
+    end_column: int = field(default=0, kw_only=True, compare=False, repr=False)
+    # Comments of the source carried into the generated Go (set for declarations, members and statements):
+    # those on the lines above the node and the one after it on its last line. Most nodes have none: they share
+    # an empty tuple instead of holding an empty list each
+    comments: Sequence[str] = field(default=(), kw_only=True, compare=False, repr=False)
+    trailing_comment: Optional[str] = field(default=None, kw_only=True, compare=False, repr=False)
+
+@lru_cache(maxsize=None)
+def field_names(node_type: type) -> Tuple[str, ...]:
+    """Names of the fields of a node class, in declaration order"""
+    return tuple(f.name for f in fields(node_type))
+
+def node_fields(node: ASTNode) -> Iterator[Tuple[str, Any]]:
+    """Names and values of the fields of a node (what vars() gives for objects with a __dict__)"""
+    return ((name, getattr(node, name)) for name in field_names(type(node)))
+
+def field_values(node: ASTNode) -> Iterator[Any]:
+    """Values of the fields of a node, its children among them"""
+    return (getattr(node, name) for name in field_names(type(node)))
+
+# ============================================================================
+# Traversal (the API for tools outside the compiler: analyzers, code mods, documentation generators)
+# ============================================================================
+
+class Position(NamedTuple):
+    """A place in a go-plus source; line and column count from 1, and are 0 when unknown"""
+    file: Optional[str]
+    line: int
+    column: int
+
+    def __str__(self):
+        return f"{self.file or '<input>'}:{self.line}:{self.column}"
+
+def position(node: ASTNode) -> Position:
+    """Where a node starts"""
+    return Position(node.file, node.line, node.column)
+
+def end_position(node: ASTNode) -> Position:
+    """Where a node ends, just past its last token (line 0 for the nodes the parser gives no end)"""
+    return Position(node.file, node.end_line, node.end_column)
+
+def children(node: ASTNode) -> Iterator[ASTNode]:
+    """The nodes directly under a node, in field order (the items of list fields in list order)"""
+    for value in field_values(node):
+        if isinstance(value, ASTNode):
+            yield value
+        elif isinstance(value, list):
+            for item in value:
+                if isinstance(item, ASTNode):
+                    yield item
+                elif isinstance(item, tuple):
+                    # Pairs of a map literal, fields of a struct literal, updates of a with expression
+                    yield from (part for part in item if isinstance(part, ASTNode))
+
+class Visitor(ABC):
+    """What walk calls for each node"""
+
+    @abstractmethod
+    def visit(self, node: Optional[ASTNode]) -> Optional['Visitor']:
+        """Called with a node before its children: the visitor returned visits them (None skips them) and is then
+        called with None"""
+        pass
+
+def walk(visitor: Visitor, node: ASTNode) -> None:
+    """Traverses a tree depth-first, in source order: visitor.visit(node), then walk(w, child) for each child with
+    the visitor w it returned, then w.visit(None)"""
+    inner = visitor.visit(node)
+    if inner is None:
+        return
+    for child in children(node):
+        walk(inner, child)
+    inner.visit(None)
+
+class _Inspector(Visitor):
+    __slots__ = ('f',)
+
+    def __init__(self, f: Callable[[Optional[ASTNode]], bool]):
+        self.f = f
+
+    def visit(self, node: Optional[ASTNode]) -> Optional[Visitor]:
+        return self if self.f(node) else None
+
+def inspect(node: ASTNode, f: Callable[[Optional[ASTNode]], bool]) -> None:
+    """Traverses a tree depth-first, in source order, calling f(node) for each node: when it returns True the
+    node's children are inspected, followed by f(None)"""
+    walk(_Inspector(f), node)
+
+def find(node: ASTNode, predicate: Callable[[ASTNode], bool]) -> Optional[ASTNode]:
+    """The first node of a tree, in inspect order, that predicate accepts (None when there is none)"""
+    found = None
+
+    def visit(current: Optional[ASTNode]) -> bool:
+        nonlocal found
+        if found is None and current is not None and predicate(current):
+            found = current
+        return found is None
+
+    inspect(node, visit)
+    return found
+
+# Method names generated for overloaded operators (unary minus is lowered to Neg)
+OPERATOR_METHOD_NAMES = {
+    '+': 'Add',
+    '-': 'Sub',
+    '*': 'Mul',
+    '/': 'Div',
+    '%': 'Mod',
+    '==': 'Equals',
+    '!=': 'NotEquals',
+    '<': 'Less',
+    '<=': 'LessOrEqual',
+    '>': 'Greater',
+    '>=': 'GreaterOrEqual',
+}
 
 # ============================================================================
 # Program and Declarations
 # ============================================================================
 
-@dataclass
+@dataclass(slots=True)
 class Program(ASTNode):
     """Main program"""
     package: str
     imports: List['ImportDecl']
     declarations: List['Declaration']
-
-@dataclass
+    # Lines whose warnings a //goplus:nowarn comment suppresses -> the warning IDs it names (empty: all)
+    nowarn: Dict[int, List[str]] = field(default_factory=dict)
+    nested_hoisted: bool = field(default=False, compare=False, repr=False)  # nested classes moved to the top level
+    # Set once the function and method bodies of a transpiled file are released (in a project build)
+    bodies: Optional['BodySummary'] = field(default=None, compare=False, repr=False)
+
+@dataclass(slots=True)
+class BodySummary:
+    """What the other files of a package still read of the function and method bodies of a file once they are
+    released: the members they select, the words they refer to, and the classes they test with 'is' or cast to"""
+    members: Set[str]
+    words: Set[str]
+    type_checks: Set[str]
+    casts: Set[str]
+
+@dataclass(slots=True)
 class ImportDecl(ASTNode):
     """Import declaration"""
     path: str
@@ -33,53 +171,60 @@ class ImportDecl(ASTNode):
 
 class Declaration(ASTNode):
     """Base class for declarations"""
-    pass
+    __slots__ = ()
 
-@dataclass
+@dataclass(slots=True)
 class FuncDecl(Declaration):
     """Function declaration"""
     name: str
     params: List['Parameter']
     return_type: Optional[str]
     body: 'BlockStmt'
+    type_params: List['TypeParam'] = field(default_factory=list)
+    nullable_result: bool = False  # func find() Person?
 
-@dataclass
+@dataclass(slots=True)
 class VarDecl(Declaration):
     """Variable declaration"""
     name: str
     type: Optional[str]
     value: Optional['Expression']
+    nullable: bool = False
 
-@dataclass
+@dataclass(slots=True)
 class ConstDecl(Declaration):
     """Constant declaration"""
     name: str
     type: Optional[str]
     value: 'Expression'
 
-@dataclass
+@dataclass(slots=True)
 class TypeDecl(Declaration):
     """Type declaration"""
     name: str
     type: str
+    is_alias: bool = False  # type ID = string
+    variants: List[str] = field(default_factory=list)  # union (extension): type Number = int | float64
 
-@dataclass
+@dataclass(slots=True)
 class StructDecl(Declaration):
     """Struct declaration"""
     name: str
     fields: List['StructField']
 
-@dataclass
+@dataclass(slots=True)
 class InterfaceDecl(Declaration):
     """Interface declaration"""
     name: str
     methods: List['MethodSignature']
+    type_params: List['TypeParam'] = field(default_factory=list)
+    type_elements: List[str] = field(default_factory=list)  # constraint unions and embedded interfaces
 
 # ============================================================================
 # Extensions - Classes
 # ============================================================================
 
-@dataclass
+@dataclass(slots=True)
 class ClassDecl(Declaration):
     """Class declaration (extension)"""
     name: str
@@ -87,23 +232,112 @@ class ClassDecl(Declaration):
     fields: List['ClassField']
     methods: List['MethodDecl']
     constructor: Optional['ConstructorDecl']
+    implements: List[str] = field(default_factory=list)
+    type_params: List['TypeParam'] = field(default_factory=list)
+    is_data: bool = False  # data class: constructor, accessors, Equals, HashCode and String are generated
+    is_record: bool = False  # record: an immutable data class
+    mixins: List[str] = field(default_factory=list)  # class Student extends Person with Auditable
+    nested: List['ClassDecl'] = field(default_factory=list)  # classes declared in the class body
+    is_inner: bool = False  # inner class: holds a pointer to its outer instance
+    is_anonymous: bool = False  # body of new Base { ... }, named after its enclosing function
+    static_blocks: List['BlockStmt'] = field(default_factory=list)  # static { ... } / companion { ... }
+    init_blocks: List['BlockStmt'] = field(default_factory=list)  # init { ... }, inlined into every constructor
+    destructor: Optional['BlockStmt'] = None  # ~ClassName() { ... }, generated as Dispose()
+    annotations: List['Annotation'] = field(default_factory=list)  # @cloneable, ...
+    is_partial: bool = False  # partial class: one part of a class split across declarations
+    events: List['EventDecl'] = field(default_factory=list)  # event OnLowFuel(level float64)
+    overrides: List['MemberOverride'] = field(default_factory=list)  # override Audit from Auditable
+    constants: List['ConstDecl'] = field(default_factory=list)  # const Max = 10, emitted as BoxMax
+    conversions: List['ConversionDecl'] = field(default_factory=list)  # implicit operator convert(c *Celsius) ...
+    expanded: bool = field(default=False, compare=False, repr=False)  # members of its annotations generated
+    mixins_applied: bool = field(default=False, compare=False, repr=False)  # mixin members copied in
+
+@dataclass(slots=True)
+class Annotation(ASTNode):
+    """Annotation on a declaration (extension): @name or @name(args)"""
+    name: str
+    args: List['Expression'] = field(default_factory=list)
+
+@dataclass(slots=True)
+class AnnotationDecl(Declaration):
+    """Annotation declaration (extension): annotation route(method string, path string) makes @route("GET", "/")
+    usable on classes and methods; its constant arguments are recorded in the class metadata"""
+    name: str
+    params: List['Parameter'] = field(default_factory=list)
 
-@dataclass
+@dataclass(slots=True)
 class ClassField(ASTNode):
     """Class field"""
     name: str
     type: str
     value: Optional['Expression'] = None
+    accessors: List[str] = field(default_factory=list)  # age int get set
+    tags: List['Annotation'] = field(default_factory=list)  # name string @json("name") -> struct tag
+    nullable: bool = False  # boss Person?
+
+@dataclass(slots=True)
+class MemberOverride(ASTNode):
+    """Conflict resolution (extension): override Audit from Auditable picks the mixin or base class providing a member"""
+    member: str
+    source: str
+
+@dataclass(slots=True)
+class EventDecl(ASTNode):
+    """Event member (extension): subscribed with += / -=, raised by calling it"""
+    name: str
+    params: List['Parameter']
+
+@dataclass(slots=True)
+class ConversionDecl(ASTNode):
+    """Conversion operator (extension): operator convert(c *Celsius) *Fahrenheit, generated as a function
+    CelsiusToFahrenheit applied by 'as' or, when implicit, wherever the target type is expected"""
+    params: List['Parameter']
+    return_type: str
+    body: 'BlockStmt'
+    implicit: bool = False
 
-@dataclass
+@dataclass(slots=True)
 class MethodDecl(ASTNode):
     """Method declaration"""
     name: str
     params: List['Parameter']
     return_type: Optional[str]
     body: 'BlockStmt'
+    type_params: List['TypeParam'] = field(default_factory=list)
+    operator: Optional[str] = None  # set for operator overloads (operator +)
+    annotations: List['Annotation'] = field(default_factory=list)  # @deprecated, @route("GET", "/"), ...
+    nullable_result: bool = False
+
+@dataclass(slots=True)
+class ObjectDecl(Declaration):
+    """Singleton object declaration (extension): object Config { ... }"""
+    name: str
+    fields: List['ClassField']
+    methods: List['MethodDecl']
+    implements: List[str] = field(default_factory=list)
+
+@dataclass(slots=True)
+class MixinDecl(Declaration):
+    """Mixin declaration (extension): fields and methods flattened into the classes using it"""
+    name: str
+    fields: List['ClassField']
+    methods: List['MethodDecl']
+
+@dataclass(slots=True)
+class EnumDecl(Declaration):
+    """Enum declaration (extension), optionally with associated values per member"""
+    name: str
+    members: List['EnumMember']
+    methods: List['MethodDecl']
+    fields: List['Parameter'] = field(default_factory=list)  # associated value declarations
+
+@dataclass(slots=True)
+class EnumMember(ASTNode):
+    """Enum member with its associated values"""
+    name: str
+    args: List['Expression'] = field(default_factory=list)
 
-@dataclass
+@dataclass(slots=True)
 class ConstructorDecl(ASTNode):
     """Constructor declaration"""
     params: List['Parameter']
@@ -113,24 +347,32 @@ class ConstructorDecl(ASTNode):
 # Parameters and Fields
 # ============================================================================
 
-@dataclass
+@dataclass(slots=True)
 class Parameter(ASTNode):
-    """Function parameter"""
+    """Function parameter (the last one may be variadic: its type is spelled ...T)"""
     name: str
     type: str
+    nullable: bool = False  # declared Person?: may be nil, tested before member access
 
-@dataclass
+@dataclass(slots=True)
+class TypeParam(ASTNode):
+    """Generic type parameter with its constraint"""
+    name: str
+    constraint: str = 'any'
+
+@dataclass(slots=True)
 class StructField(ASTNode):
     """Struct field"""
     name: str
     type: str
 
-@dataclass
+@dataclass(slots=True)
 class MethodSignature(ASTNode):
     """Method signature (interface)"""
     name: str
     params: List['Parameter']
     return_type: Optional[str]
+    nullable_result: bool = False
 
 # ============================================================================
 # Statements
@@ -138,40 +380,48 @@ class MethodSignature(ASTNode):
 
 class Statement(ASTNode):
     """Base class for statements"""
-    pass
+    __slots__ = ()
 
-@dataclass
+@dataclass(slots=True)
 class BlockStmt(Statement):
     """Block of statements"""
     statements: List[Statement]
+    end_comments: List[str] = field(default_factory=list, compare=False, repr=False)  # after the last statement
 
-@dataclass
+@dataclass(slots=True)
 class ExpressionStmt(Statement):
     """Expression statement"""
     expression: 'Expression'
 
-@dataclass
+@dataclass(slots=True)
 class VarStmt(Statement):
     """Variable declaration statement"""
     name: str
     type: Optional[str]
     value: Optional['Expression']
+    nullable: bool = False  # var p Person? = find()
 
-@dataclass
+@dataclass(slots=True)
 class AssignStmt(Statement):
     """Assignment statement"""
     target: 'Expression'
     value: 'Expression'
     operator: str = '='
 
-@dataclass
+@dataclass(slots=True)
+class IncDecStmt(Statement):
+    """x++ / x--"""
+    target: 'Expression'
+    operator: str
+
+@dataclass(slots=True)
 class IfStmt(Statement):
     """If statement"""
     condition: 'Expression'
     then_stmt: Statement
     else_stmt: Optional[Statement] = None
 
-@dataclass
+@dataclass(slots=True)
 class ForStmt(Statement):
     """For statement"""
     init: Optional[Statement]
@@ -179,7 +429,22 @@ class ForStmt(Statement):
     update: Optional[Statement]
     body: Statement
 
-@dataclass
+@dataclass(slots=True)
+class DoWhileStmt(Statement):
+    """Do-while loop (extension): do { ... } while (cond) runs the body before checking the condition;
+    repeat { ... } until (cond) (until=True) is the same loop, ending once the condition holds"""
+    body: 'BlockStmt'
+    condition: 'Expression'
+    until: bool = False
+
+@dataclass(slots=True)
+class ForInStmt(Statement):
+    """For-in loop (extension): for item in items, for k, v in m"""
+    variables: List[str]
+    iterable: 'Expression'
+    body: Statement
+
+@dataclass(slots=True)
 class RangeStmt(Statement):
     """For range statement"""
     key: Optional[str]
@@ -187,45 +452,68 @@ class RangeStmt(Statement):
     iterable: 'Expression'
     body: Statement
 
-@dataclass
+@dataclass(slots=True)
 class SwitchStmt(Statement):
     """Switch statement"""
     expression: Optional['Expression']
     cases: List['CaseStmt']
     default_case: Optional['DefaultStmt']
 
-@dataclass
+@dataclass(slots=True)
 class CaseStmt(Statement):
     """Switch case"""
     values: List['Expression']
     body: List[Statement]
 
-@dataclass
+@dataclass(slots=True)
 class DefaultStmt(Statement):
     """Switch default"""
     body: List[Statement]
 
-@dataclass
+@dataclass(slots=True)
+class SendStmt(Statement):
+    """Channel send: ch <- v"""
+    channel: 'Expression'
+    value: 'Expression'
+
+@dataclass(slots=True)
+class SelectStmt(Statement):
+    """Select statement"""
+    cases: List['SelectCase']
+
+@dataclass(slots=True)
+class SelectCase(Statement):
+    """Select case: a send, a receive (<-ch, v := <-ch, v, ok = <-ch) or None for default"""
+    comm: Optional[Statement]
+    body: List[Statement]
+
+@dataclass(slots=True)
 class ReturnStmt(Statement):
     """Return statement"""
     value: Optional['Expression'] = None
 
-@dataclass
+@dataclass(slots=True)
 class BreakStmt(Statement):
-    """Break statement"""
-    pass
+    """Break statement (break or break outer)"""
+    label: Optional[str] = None
 
-@dataclass
+@dataclass(slots=True)
 class ContinueStmt(Statement):
-    """Continue statement"""
-    pass
+    """Continue statement (continue or continue outer)"""
+    label: Optional[str] = None
 
-@dataclass
+@dataclass(slots=True)
+class LabeledStmt(Statement):
+    """Labeled loop, switch or select: outer: for ... { ... }"""
+    label: str
+    statement: Statement
+
+@dataclass(slots=True)
 class GoStmt(Statement):
     """Go statement (goroutine)"""
     call: 'CallExpr'
 
-@dataclass
+@dataclass(slots=True)
 class DeferStmt(Statement):
     """Defer statement"""
     call: 'CallExpr'
@@ -234,94 +522,178 @@ class DeferStmt(Statement):
 # Extensions - Exception Handling
 # ============================================================================
 
-@dataclass
+@dataclass(slots=True)
 class TryStmt(Statement):
     """Try statement (extension)"""
     body: BlockStmt
     catch_blocks: List['CatchStmt']
     finally_block: Optional['FinallyStmt'] = None
 
-@dataclass
+@dataclass(slots=True)
 class CatchStmt(Statement):
     """Catch statement (extension)"""
     exception_type: Optional[str]
     exception_var: Optional[str]
     body: BlockStmt
 
-@dataclass
+@dataclass(slots=True)
 class FinallyStmt(Statement):
     """Finally statement (extension)"""
     body: BlockStmt
 
-@dataclass
+@dataclass(slots=True)
 class ThrowStmt(Statement):
     """Throw statement (extension)"""
     expression: 'Expression'
 
+@dataclass(slots=True)
+class YieldStmt(Statement):
+    """Yield statement in an iterator (extension): yield v / yield k, v"""
+    values: List['Expression']
+
+@dataclass(slots=True)
+class UsingStmt(Statement):
+    """Using statement (extension): using (f := open()) { ... } disposes f when the block ends"""
+    name: str
+    value: 'Expression'
+    body: 'BlockStmt'
+
+@dataclass(slots=True)
+class WithStmt(Statement):
+    """With statement (extension): with (mu.Lock()) { ... } releases what the expression acquired (mu.Unlock())
+    when the block ends; with (r := open()) { ... } binds a value released by its Unlock, Close or Dispose"""
+    value: 'Expression'
+    body: 'BlockStmt'
+    name: Optional[str] = None
+
+# ============================================================================
+# Internal nodes (generated code)
+# ============================================================================
+
+@dataclass(slots=True)
+class RawStmt(Statement):
+    """Verbatim Go code produced by code generators, with the imports it needs"""
+    code: str
+    imports: List[str] = field(default_factory=list)
+
 # ============================================================================
 # Expressions
 # ============================================================================
 
 class Expression(ASTNode):
     """Base class for expressions"""
-    pass
-
-@dataclass
+    __slots__ = ()
+
+# Go's binary operator precedence (higher binds tighter); every level is left-associative
+BINARY_PRECEDENCE = {
+    '||': 1,
+    '&&': 2,
+    '==': 3, '!=': 3, '<': 3, '<=': 3, '>': 3, '>=': 3,
+    '+': 4, '-': 4, '|': 4, '^': 4,
+    '*': 5, '/': 5, '%': 5, '<<': 5, '>>': 5, '&': 5, '&^': 5,
+}
+
+@dataclass(slots=True)
 class BinaryExpr(Expression):
     """Binary expression"""
     left: Expression
     operator: str
     right: Expression
 
-@dataclass
+@dataclass(slots=True)
 class UnaryExpr(Expression):
     """Unary expression"""
     operator: str
     operand: Expression
 
-@dataclass
+@dataclass(slots=True)
+class IncDecExpr(Expression):
+    """x++ / x-- where a value is expected (Go only allows them as statements, which the parser turns them into)"""
+    operand: Expression
+    operator: str
+
+@dataclass(slots=True)
 class CallExpr(Expression):
     """Function call"""
     function: Expression
     args: List[Expression]
+    spread: bool = False  # the last argument is a slice passed to a variadic parameter: f(parts...)
 
-@dataclass
+@dataclass(slots=True)
 class IndexExpr(Expression):
     """Index access (array/slice/map)"""
     object: Expression
     index: Expression
 
-@dataclass
+@dataclass(slots=True)
 class SelectorExpr(Expression):
     """Selector (obj.field)"""
     object: Expression
     field: str
+    optional: bool = False  # obj?.field (extension): nil when obj is nil
 
-@dataclass
+@dataclass(slots=True)
 class Identifier(Expression):
     """Identifier"""
     name: str
 
-@dataclass
+@dataclass(slots=True)
+class TypeExpr(Expression):
+    """Type used as an expression (make(map[K]V), []byte(s))"""
+    type: str
+
+@dataclass(slots=True)
 class Literal(Expression):
     """Literal (number, string, boolean)"""
     value: Any
     type: str  # 'int', 'float', 'string', 'bool'
+    raw: bool = False  # string written as `raw` or """text""", emitted as a Go raw string when possible
+    go_type: Optional[str] = None  # number with a type suffix (1.5f32 -> 'float32')
+    text: Optional[str] = None  # normalized spelling of a number (0b1010, 1000000)
+
+@dataclass(slots=True)
+class Interpolation(Expression):
+    """${expr} or ${expr:.2f} inside an interpolated string"""
+    expr: Expression
+    spec: Optional[str] = None  # fmt verb with flags, without the '%'
+
+@dataclass(slots=True)
+class InterpolatedString(Expression):
+    """Interpolated string (extension): "Hi ${name}" as string Literal and Interpolation parts"""
+    parts: List[Expression]
+
+@dataclass(slots=True)
+class CoalesceExpr(Expression):
+    """Null-coalescing (extension): value ?? fallback"""
+    left: Expression
+    right: Expression
+
+@dataclass(slots=True)
+class TernaryExpr(Expression):
+    """Conditional expression (extension): cond ? a : b"""
+    condition: Expression
+    then_expr: Expression
+    else_expr: Expression
 
-@dataclass
+@dataclass(slots=True)
+class TupleExpr(Expression):
+    """Expression list (a, b := f() / return a, b)"""
+    elements: List[Expression]
+
+@dataclass(slots=True)
 class ArrayLiteral(Expression):
-    """Array literal"""
+    """Slice or array literal: []int{1, 2}; without a type, braces nested in another literal ({1, 2})"""
     elements: List[Expression]
     type: Optional[str] = None
 
-@dataclass
+@dataclass(slots=True)
 class MapLiteral(Expression):
-    """Map literal"""
+    """Map literal: map[string]int{"a": 1}; without types, braces of pairs nested in another literal"""
     pairs: List[tuple[Expression, Expression]]
     key_type: Optional[str] = None
     value_type: Optional[str] = None
 
-@dataclass
+@dataclass(slots=True)
 class StructLiteral(Expression):
     """Struct literal"""
     type: str
@@ -331,18 +703,101 @@ class StructLiteral(Expression):
 # Extensions - Class Expressions
 # ============================================================================
 
-@dataclass
+@dataclass(slots=True)
+class WithExpr(Expression):
+    """Copy of a record with some fields replaced: p with { age: 30 } (extension)"""
+    target: Expression
+    updates: List[tuple[str, Expression]]
+
+@dataclass(slots=True)
 class NewExpr(Expression):
     """New expression (extension)"""
     class_name: str
     args: List[Expression]
+    type_args: List[str] = field(default_factory=list)
+    body: Optional['ClassDecl'] = None  # anonymous class: new ClickHandler { func OnClick() { ... } }
+    spread: bool = False  # new Logger(prefixes...)
+
+@dataclass(slots=True)
+class IsExpr(Expression):
+    """Type test (extension): obj is Student, true for subclasses too"""
+    expr: Expression
+    type: str
+
+@dataclass(slots=True)
+class CastExpr(Expression):
+    """Checked cast (extension): obj as Student throws ClassCastException, obj as? Student yields nil"""
+    expr: Expression
+    type: str
+    safe: bool = False
+
+@dataclass(slots=True)
+class LambdaExpr(Expression):
+    """Arrow lambda (extension): x -> x.age > 18, (a, b) -> a + b, (x int) -> { ... }"""
+    params: List['Parameter']  # type is None when inferred from the target signature
+    body: Union[Expression, 'BlockStmt']
+
+@dataclass(slots=True)
+class ComprehensionExpr(Expression):
+    """Comprehension (extension): [element for p in people if cond]; with a key, {key: element for ...} builds a map"""
+    element: Expression
+    variables: List[str]
+    iterable: Expression
+    condition: Optional[Expression] = None
+    key: Optional[Expression] = None
+
+@dataclass(slots=True)
+class MatchExpr(Expression):
+    """Pattern match (extension): match shape { Circle c -> c.Area(), _ -> 0.0 }"""
+    subject: Expression
+    arms: List['MatchArm']
+    is_switch: bool = False  # switch expression: switch level { case A -> 10.0; default -> 0.0 }
+
+@dataclass(slots=True)
+class MatchArm(ASTNode):
+    """One arm of a match: alternative patterns, an optional guard and a value (or a block)"""
+    patterns: List['Pattern']
+    body: Union[Expression, 'BlockStmt']
+    guard: Optional[Expression] = None  # Circle c if c.radius > 10 -> ...
+
+@dataclass(slots=True)
+class Pattern(ASTNode):
+    """Base class for match patterns"""
+    pass
+
+@dataclass(slots=True)
+class WildcardPattern(Pattern):
+    """_ matches anything"""
+    pass
+
+@dataclass(slots=True)
+class ValuePattern(Pattern):
+    """Literal, enum member or constant compared with ==; a bare type name (Circle) is a type test"""
+    value: Expression
+
+@dataclass(slots=True)
+class TypePattern(Pattern):
+    """Type test with a binding: Circle c"""
+    type: str
+    binding: Optional[str] = None
+
+@dataclass(slots=True)
+class DestructurePattern(Pattern):
+    """Data class fields matched by position: Point(0, y)"""
+    type: str
+    elements: List[Pattern]
+
+@dataclass(slots=True)
+class BindingPattern(Pattern):
+    """A name bound to a destructured field"""
+    name: str
 
-@dataclass
+@dataclass(slots=True)
 class ThisExpr(Expression):
     """This expression (extension)"""
     pass
 
-@dataclass
+@dataclass(slots=True)
 class SuperExpr(Expression):
     """Super expression (extension)"""
     pass
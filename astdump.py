@@ -0,0 +1,53 @@
+"""
+AST dumps for Go-Extended
+Prints an AST as an indented tree, to read, or as JSON, for tools: the parsed AST of a file, or the AST the
+transpiler emits Go from (classes expanded with their generated members, nested classes hoisted...)
+"""
+
+import json
+from typing import Any, List
+from ast_nodes import ASTNode, node_fields
+
+# Fields of every node giving its position, which the tree shows on the node's own line
+POSITION_FIELDS = ('line', 'column', 'file', 'end_line', 'end_column')
+
+def to_data(value: Any) -> Any:
+    """JSON-ready form of an AST: a node is an object with its type ("node"), its position and all its fields"""
+    if isinstance(value, ASTNode):
+        data = {'node': type(value).__name__}
+        data.update((name, to_data(field_value)) for name, field_value in node_fields(value))
+        return data
+    if isinstance(value, (list, tuple)):
+        return [to_data(item) for item in value]
+    if isinstance(value, dict):
+        return {str(key): to_data(item) for key, item in value.items()}
+    return value
+
+def dump_json(node: ASTNode) -> str:
+    return json.dumps(to_data(node), indent=2) + '\n'
+
+def dump_tree(node: ASTNode) -> str:
+    """The AST as an indented tree: a node per line with its position, then its fields, one per line; fields
+    holding nothing (None, False, empty lists) are left out"""
+    lines: List[str] = []
+    _tree('', node, 0, lines)
+    return '\n'.join(lines) + '\n'
+
+def _tree(label: str, node: ASTNode, depth: int, lines: List[str]) -> None:
+    position = f' ({node.line}:{node.column})' if node.line else ''
+    lines.append(f"{'  ' * depth}{label}{type(node).__name__}{position}")
+    for name, value in node_fields(node):
+        if name in POSITION_FIELDS or value is None or value is False or value == () or value == [] or value == {}:
+            continue
+        _field(f'{name}: ', value, depth + 1, lines)
+
+def _field(label: str, value: Any, depth: int, lines: List[str]) -> None:
+    """A field, or an item of one: a node or a scalar after the label, the items of a list under it"""
+    if isinstance(value, ASTNode):
+        _tree(label, value, depth, lines)
+    elif isinstance(value, (list, tuple)) and any(isinstance(item, (ASTNode, list, tuple)) for item in value):
+        lines.append(f"{'  ' * depth}{label.rstrip()}")
+        for i, item in enumerate(value):
+            _field(f'[{i}] ', item, depth + 1, lines)
+    else:
+        lines.append(f"{'  ' * depth}{label}{json.dumps(to_data(value), ensure_ascii=False)}")
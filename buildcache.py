@@ -0,0 +1,150 @@
+"""
+Build cache for Go-Extended projects
+Keeps the generated Go of each file under a key hashing everything it is generated from, so a build transpiles
+only the files whose sources, or the sources of the packages they depend on, changed
+Entries are JSON: reading one only ever builds strings, numbers, lists and AST nodes, never runs code
+"""
+
+import json
+import hashlib
+import ast_nodes
+from pathlib import Path
+from dataclasses import dataclass, field
+from typing import Any, Dict, List, Optional, Set
+from ast_nodes import ASTNode, node_fields
+from errors import Diagnostic
+
+# Version of the entry format: an entry of another version is not read, and its file transpiled again
+UNIT_VERSION = 1
+
+# Fields of every node giving its position, all a cached warning keeps of its node
+POSITION_FIELDS = ('line', 'column', 'file', 'end_line', 'end_column')
+
+# Modules whose code decides what a unit holds (the generated Go, its line origins, its warnings): a new version
+# of any of them invalidates the cache
+COMPILER_MODULES = ('tokens', 'lexer', 'directives', 'ast_nodes', 'parser', 'generators', 'goimports', 'literals',
+                    'transpiler', 'goformat', 'sourcemap', 'project_manager', 'buildcache', 'plugins', 'errors')
+
+@dataclass
+class CachedUnit:
+    """What transpiling a file produced: its formatted Go and line origins, its warnings, and the state later files
+    take from it (the classes of its package, whether it emitted the package's runtimes)"""
+    go_code: str
+    line_origins: List = field(default_factory=list)
+    warnings: List[str] = field(default_factory=list)
+    classes: Dict = field(default_factory=dict)
+    uses_class_metadata: bool = False
+    uses_string_runtime: bool = False
+
+def content_hash(*parts: str) -> str:
+    """SHA-256 of some strings, each one delimited so that no two lists of parts hash alike"""
+    digest = hashlib.sha256()
+    for part in parts:
+        data = part.encode('utf-8')
+        digest.update(len(data).to_bytes(8, 'big'))
+        digest.update(data)
+    return digest.hexdigest()
+
+def file_hash(path: Path) -> str:
+    """SHA-256 of a file, read a block at a time"""
+    with open(path, 'rb') as f:
+        return hashlib.file_digest(f, 'sha256').hexdigest()
+
+def compiler_hash() -> str:
+    """Hash of the transpiler's own sources"""
+    directory = Path(__file__).parent
+    return content_hash(*((directory / f'{module}.py').read_text(encoding='utf-8') for module in COMPILER_MODULES))
+
+def unit_to_data(unit: CachedUnit) -> Dict:
+    """JSON form of a unit; a warning keeps the position of its node, all that is reported of it"""
+    warnings = [{'text': str(warning), 'code': warning.code, 'severity': warning.severity,
+                 'message': warning.message, 'fix': warning.fix,
+                 'origin': {name: getattr(warning.origin, name) for name in POSITION_FIELDS}
+                 if warning.origin else None} if isinstance(warning, Diagnostic) else {'text': str(warning)}
+                for warning in unit.warnings]
+    return {'version': UNIT_VERSION, 'go_code': unit.go_code, 'line_origins': unit.line_origins,
+            'warnings': warnings, 'classes': _to_data(unit.classes),
+            'uses_class_metadata': unit.uses_class_metadata, 'uses_string_runtime': unit.uses_string_runtime}
+
+def unit_from_data(data: Dict) -> CachedUnit:
+    """The unit of a JSON form; raises ValueError (or KeyError, TypeError) when it is not one of this version"""
+    if not isinstance(data, dict) or data.get('version') != UNIT_VERSION:
+        raise ValueError('not a cached unit of this version')
+    warnings = []
+    for entry in data['warnings']:
+        if 'code' not in entry:
+            warnings.append(entry['text'])
+            continue
+        warning = Diagnostic(entry['text'])
+        warning.code, warning.severity, warning.message, warning.fix = \
+            entry['code'], entry['severity'], entry['message'], entry['fix']
+        warning.origin = ASTNode(**entry['origin']) if entry['origin'] else None
+        warnings.append(warning)
+    line_origins = [tuple(origin) if origin else None for origin in data['line_origins']]
+    return CachedUnit(str(data['go_code']), line_origins, warnings, _from_data(data['classes']),
+                      bool(data['uses_class_metadata']), bool(data['uses_string_runtime']))
+
+def _to_data(value: Any) -> Any:
+    """JSON form of a value of an AST: nodes, tuples and dicts are tagged, so they read back as they were"""
+    if isinstance(value, ASTNode):
+        return {'node': type(value).__name__,
+                'fields': {name: _to_data(field_value) for name, field_value in node_fields(value)}}
+    if isinstance(value, tuple):
+        return {'tuple': [_to_data(item) for item in value]}
+    if isinstance(value, list):
+        return [_to_data(item) for item in value]
+    if isinstance(value, dict):
+        return {'dict': {key: _to_data(item) for key, item in value.items()}}
+    return value
+
+def _from_data(data: Any) -> Any:
+    """The value of a JSON form of _to_data; a node type must be one of ast_nodes"""
+    if isinstance(data, list):
+        return [_from_data(item) for item in data]
+    if not isinstance(data, dict):
+        return data
+    if 'node' in data:
+        node_type = getattr(ast_nodes, data['node'], None)
+        if not (isinstance(node_type, type) and issubclass(node_type, ASTNode)):
+            raise ValueError(f"unknown AST node {data['node']!r}")
+        return node_type(**{name: _from_data(value) for name, value in data['fields'].items()})
+    if 'tuple' in data:
+        return tuple(_from_data(item) for item in data['tuple'])
+    return {key: _from_data(item) for key, item in data['dict'].items()}
+
+class BuildCache:
+    """Cached units of a project, one file per key in the cache directory"""
+
+    def __init__(self, directory: Path):
+        self.directory = directory
+        self.used: Set[str] = set()  # keys loaded or stored by the current build
+
+    def load(self, key: str) -> Optional[CachedUnit]:
+        """The unit cached under a key; None when there is none or it cannot be read (another format, a damaged
+        file), so its file is transpiled again"""
+        path = self.directory / f'{key}.unit'
+        try:
+            with open(path, 'r', encoding='utf-8') as f:
+                unit = unit_from_data(json.load(f))
+        except (OSError, ValueError, KeyError, TypeError, AttributeError):
+            return None
+        self.used.add(key)
+        return unit
+
+    def store(self, key: str, unit: CachedUnit) -> None:
+        """Caches a unit, replacing the file atomically so an interrupted build leaves no partial entry"""
+        self.directory.mkdir(parents=True, exist_ok=True)
+        path = self.directory / f'{key}.unit'
+        partial = path.with_suffix('.tmp')
+        with open(partial, 'w', encoding='utf-8') as f:
+            json.dump(unit_to_data(unit), f, separators=(',', ':'))
+        partial.replace(path)
+        self.used.add(key)
+
+    def prune(self) -> None:
+        """Deletes the entries the current build did not use: they belong to sources that no longer exist"""
+        if not self.directory.exists():
+            return
+        for path in self.directory.glob('*.unit'):
+            if path.stem not in self.used:
+                path.unlink()
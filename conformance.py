@@ -0,0 +1,99 @@
+#!/usr/bin/env python3
+"""
+Conformance suite of the go-plus semantics
+Each case of testdata/conformance is a program, name.gox, with the standard output it must print, name.out. The
+program is transpiled, built with the Go toolchain and run; its output and exit status must be the expected ones.
+A case expects exit status 0 unless a '// exit N' line at its top says otherwise (2 for an uncaught exception,
+which is a Go panic)
+"""
+
+import re
+import sys
+import difflib
+import argparse
+import tempfile
+import subprocess
+from pathlib import Path
+from concurrent.futures import ThreadPoolExecutor
+from typing import List, NamedTuple, Optional
+from lexer import Lexer, LexerError
+from parser import Parser, ParseError
+from transpiler import Transpiler, TranspilerError
+
+CASES = Path(__file__).parent / 'testdata' / 'conformance'
+EXIT_STATUS = re.compile(r'\A(?://[^\n]*\n)*?// exit (\d+)\n')
+
+class Case(NamedTuple):
+    """A program of the suite with the output and exit status it must have"""
+    name: str
+    source: str
+    output: str
+    status: int
+
+def load_cases(directory: Path = CASES, names: Optional[List[str]] = None) -> List[Case]:
+    """The cases of a directory, in name order: all of them, or the ones named"""
+    cases = []
+    for path in sorted(directory.glob('*.gox')):
+        if names and path.stem not in names:
+            continue
+        source = path.read_text(encoding='utf-8')
+        status = EXIT_STATUS.match(source)
+        cases.append(Case(path.stem, source, path.with_suffix('.out').read_text(encoding='utf-8'),
+                          int(status.group(1)) if status else 0))
+    return cases
+
+def run_case(case: Case, timeout: float = 60.0) -> Optional[str]:
+    """Transpiles, builds and runs a case; returns why it fails, None when it passes"""
+    try:
+        go_code = Transpiler().transpile(Parser(Lexer(case.source, set(), f'{case.name}.gox').stream(),
+                                                f'{case.name}.gox').parse())
+    except (LexerError, ParseError, TranspilerError) as e:
+        return f"transpilation failed: {e}"
+
+    with tempfile.TemporaryDirectory(prefix='goplus-conformance-') as work:
+        (Path(work) / 'main.go').write_text(go_code, encoding='utf-8')
+        # go run would report every failing exit status as 1: the program is built and run separately
+        build = subprocess.run(['go', 'build', '-o', 'prog', 'main.go'], cwd=work, capture_output=True, text=True,
+                               timeout=timeout)
+        if build.returncode != 0:
+            return f"go build failed:\n{build.stderr}"
+        try:
+            run = subprocess.run([str(Path(work) / 'prog')], cwd=work, capture_output=True, text=True,
+                                 timeout=timeout)
+        except subprocess.TimeoutExpired:
+            return f"timed out after {timeout:g}s"
+
+    problems = []
+    if run.stdout != case.output:
+        diff = difflib.unified_diff(case.output.splitlines(keepends=True), run.stdout.splitlines(keepends=True),
+                                    f'{case.name}.out', 'stdout')
+        problems.append(''.join(diff))
+    if run.returncode != case.status:
+        problems.append(f"exit status {run.returncode}, expected {case.status}\n{run.stderr}")
+    return '\n'.join(problems) or None
+
+def run(cases: List[Case], jobs: int = 4) -> List[str]:
+    """Runs cases in parallel; returns the failures, each one headed by the name of its case"""
+    with ThreadPoolExecutor(max_workers=jobs) as pool:
+        results = list(pool.map(run_case, cases))
+    return [f"{case.name}: {error}" for case, error in zip(cases, results) if error]
+
+def main():
+    parser = argparse.ArgumentParser(description='Run the go-plus conformance suite (needs the Go toolchain)')
+    parser.add_argument('names', nargs='*', help='Cases to run (default: all of them)')
+    parser.add_argument('-j', '--jobs', type=int, default=4, help='Cases built and run at once (default: 4)')
+    args = parser.parse_args()
+
+    cases = load_cases(names=args.names)
+    if not cases:
+        print(f"No cases in {CASES}")
+        sys.exit(1)
+    failures = run(cases, args.jobs)
+    for failure in failures:
+        print(failure)
+    print(f"{len(cases) - len(failures)}/{len(cases)} cases passed")
+    if failures:
+        sys.exit(1)
+
+if __name__ == "__main__":
+    main()
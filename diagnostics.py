@@ -0,0 +1,165 @@
+"""
+Diagnostics for Go-Extended
+Shows the warnings and errors of a run in the terminal, with the source lines they point at, or writes them as
+JSON or SARIF for CI systems and editors
+"""
+
+import os
+import sys
+import json
+from pathlib import Path
+from contextlib import contextmanager
+from typing import Dict, Iterator, List, Optional, Sequence
+from errors import ERRORS, Diagnostic, error_diagnostic, error_diagnostics, position
+from transpiler import WARNINGS, WarningError
+
+DIAGNOSTICS_FORMATS = ('text', 'json', 'sarif')
+
+# Names and severities of the errors and warnings, by ID
+CODES = {**ERRORS, **WARNINGS}
+
+# ANSI colors of the severities in a terminal
+COLORS = {'error': '31', 'warning': '33', 'info': '36'}
+
+# SARIF levels of the severities
+SARIF_LEVELS = {'error': 'error', 'warning': 'warning', 'info': 'note'}
+SARIF_SCHEMA = 'https://json.schemastore.org/sarif-2.1.0.json'
+
+def diagnostic_entries(diagnostics: Sequence[Diagnostic], source: Optional[str] = None) -> List[Dict]:
+    """One entry per diagnostic, with its ID, the span of the node it is at and its suggested fix. source is the
+    file of diagnostics at no node"""
+    entries = []
+    for diagnostic in diagnostics:
+        node = diagnostic.origin
+        entry = {'severity': diagnostic.severity, 'code': diagnostic.code, 'name': CODES[diagnostic.code][0],
+                 'message': diagnostic.message, 'file': (node.file if node else None) or source, 'range': None,
+                 'fix': diagnostic.fix}
+        if node and node.line:
+            end = (node.end_line, node.end_column) if node.end_line else (node.line, node.column)
+            entry['range'] = {'start': {'line': node.line, 'column': node.column},
+                              'end': {'line': end[0], 'column': end[1]}}
+        entries.append(entry)
+    return entries
+
+def use_color() -> bool:
+    """Whether diagnostics are colored: stdout is a terminal and NO_COLOR is not set"""
+    return sys.stdout.isatty() and 'NO_COLOR' not in os.environ
+
+def source_line(file: str, line: int, root: Optional[Path] = None) -> Optional[str]:
+    """A line of a source file, whose path is relative to root (default: the current directory)"""
+    path = Path(file) if root is None or Path(file).is_absolute() else Path(root) / file
+    try:
+        with open(path, 'r', encoding='utf-8') as f:
+            lines = f.read().split('\n')
+    except OSError:
+        return None
+    return lines[line - 1].rstrip() if 0 < line <= len(lines) else None
+
+def render_entry(entry: Dict, root: Optional[Path] = None, color: bool = False) -> str:
+    """A diagnostic as shown in a terminal: severity (with the warning ID) and message, its position, the source
+    line with its span underlined, and the suggested fix
+
+        warning[GP1002]: name shadows field Person.name
+          --> src/models/person.gox:17:9
+           |
+        17 |         name := n
+           |         ^^^^
+           = help: use this.name for the field
+    """
+    def paint(text: str, style: str) -> str:
+        return f'\033[{style}m{text}\033[0m' if color else text
+    
+    severity = entry['severity']
+    label = f"{severity}[{entry['code']}]"
+    lines = [paint(label, '1;' + COLORS[severity]) + paint(f": {entry['message']}", '1')]
+    start = entry['range']['start'] if entry['range'] else None
+    gutter = ' ' * len(str(start['line'])) if start else ' '
+    if entry['file']:
+        position = entry['file'] + (f":{start['line']}" if start else '') + \
+            (f":{start['column']}" if start and start['column'] else '')
+        lines.append(f"{gutter}{paint('-->', '1;34')} {position}")
+    text = source_line(entry['file'], start['line'], root) if entry['file'] and start else None
+    if text and text.strip():
+        bar = paint('|', '1;34')
+        lines += [f"{gutter} {bar}", f"{paint(str(start['line']), '1;34')} {bar} {text}"]
+        if start['column']:
+            # The underline keeps the tabs of the source line, so it stays under the span
+            indent = ''.join(c if c == '\t' else ' ' for c in text[:start['column'] - 1])
+            end = entry['range']['end']
+            if end and end['line'] == start['line']:
+                width = end['column'] - start['column']
+            elif end:
+                width = len(text) - start['column'] + 1  # to the end of the first line of the span
+            else:
+                width = 1
+            lines.append(f"{gutter} {bar} {indent}{paint('^' * max(width, 1), '1;' + COLORS[severity])}")
+    if entry['fix']:
+        lines.append(f"{gutter} {paint('=', '1;34')} {paint('help', '1')}: {entry['fix']}")
+    return '\n'.join(lines)
+
+def report_warnings(warnings: List[Diagnostic], source: str, root: Optional[Path] = None) -> None:
+    """Prints the warnings of a file, then fails when any of them has severity 'error'"""
+    color = use_color()
+    for entry in diagnostic_entries(warnings, source):
+        print(render_entry(entry, root, color))
+    errors = sum(warning.severity == 'error' for warning in warnings)
+    if errors:
+        message = f"{errors} warning{'s' if errors > 1 else ''} reported as error"
+        text = f"{source}: {message}"
+        raise WarningError(text, error_diagnostic(WarningError.code, message, position(source, 0, 0), text=text))
+
+def print_error(error: Exception, source: Optional[str] = None, root: Optional[Path] = None) -> None:
+    """Prints each diagnostic of an error at the source span it is at"""
+    color = use_color()
+    for entry in diagnostic_entries(error_diagnostics(error), source):
+        print(render_entry(entry, root, color))
+
+def json_document(entries: List[Dict]) -> str:
+    """The diagnostics as a JSON array"""
+    return json.dumps(entries, indent=2)
+
+def sarif_document(entries: List[Dict]) -> str:
+    """The diagnostics as a SARIF 2.1.0 log, with a rule for each error and warning ID reported"""
+    codes = sorted({entry['code'] for entry in entries})
+    rules = [{'id': code, 'name': CODES[code][0],
+              'defaultConfiguration': {'level': SARIF_LEVELS[CODES[code][1]]}} for code in codes]
+    results = []
+    for entry in entries:
+        # SARIF fixes are edits of the file: a suggestion in words belongs to the message
+        text = f"{entry['message']} ({entry['fix']})" if entry['fix'] else entry['message']
+        result = {'level': SARIF_LEVELS[entry['severity']], 'message': {'text': text}}
+        result = {'ruleId': entry['code'], 'ruleIndex': codes.index(entry['code']), **result}
+        if entry['file']:
+            location = {'artifactLocation': {'uri': entry['file'].replace('\\', '/')}}
+            if entry['range']:
+                start, end = entry['range']['start'], entry['range']['end']
+                location['region'] = {'startLine': start['line']}
+                if start['column']:
+                    location['region']['startColumn'] = start['column']
+                if end:
+                    location['region'].update(endLine=end['line'], endColumn=end['column'])
+            result['locations'] = [{'physicalLocation': location}]
+        results.append(result)
+    log = {'$schema': SARIF_SCHEMA, 'version': '2.1.0',
+           'runs': [{'tool': {'driver': {'name': 'goe2go', 'rules': rules}}, 'results': results}]}
+    return json.dumps(log, indent=2)
+
+def format_diagnostics(diagnostics: Sequence[Diagnostic], format: str, source: Optional[str] = None) -> str:
+    """The diagnostics as a json or sarif document"""
+    entries = diagnostic_entries(diagnostics, source)
+    return sarif_document(entries) if format == 'sarif' else json_document(entries)
+
+@contextmanager
+def structured_output(format: str, diagnostics: List[Diagnostic], source: Optional[str] = None) -> Iterator[None]:
+    """Runs a command whose diagnostics (warnings, then the error stopping it) are collected in diagnostics. Unless
+    the format is text, the command's own output goes to stderr and the diagnostics are written to stdout once it
+    ends, even when it exits"""
+    if format == 'text':
+        yield
+        return
+    stdout, sys.stdout = sys.stdout, sys.stderr
+    try:
+        yield
+    finally:
+        sys.stdout = stdout
+        print(format_diagnostics(diagnostics, format, source))
@@ -0,0 +1,165 @@
+"""
+Conditional compilation for Go-Extended
+Evaluates //goplus:build constraints and #if/#elif/#else/#endif blocks against a set of build tags
+"""
+
+import io
+import os
+import re
+import platform
+from typing import Dict, Iterable, Iterator, List, Optional, Set, TextIO, Tuple, Union
+from errors import CompileError, error_diagnostic, position
+
+class DirectiveError(CompileError):
+    """Malformed build constraint or conditional block"""
+    code = 'GP0001'
+
+def directive_error(message: str, line: int) -> DirectiveError:
+    """The error of the directive at a line"""
+    text = f"{message} at line {line}"
+    return DirectiveError(text, error_diagnostic(DirectiveError.code, message, position(None, line, 1), text=text))
+
+# Host platform names as Go spells them (GOOS, GOARCH)
+GOOS_NAMES = {'linux': 'linux', 'darwin': 'darwin', 'windows': 'windows', 'freebsd': 'freebsd'}
+GOARCH_NAMES = {'x86_64': 'amd64', 'amd64': 'amd64', 'aarch64': 'arm64', 'arm64': 'arm64', 'i386': '386',
+                'i686': '386', 'armv7l': 'arm'}
+
+BUILD_PREFIX = '//goplus:build'
+CONDITIONAL_DIRECTIVE = re.compile(r'^\s*#(if|elif|else|endif)\b(.*)$')
+CONSTRAINT_TOKEN = re.compile(r'\s*(&&|\|\||!|\(|\)|[A-Za-z0-9_.]+)')
+
+def default_tags(extra: Iterable[str] = ()) -> Set[str]:
+    """Tags of the target platform (GOOS and GOARCH, from the environment like go build, else the host)
+    plus the requested ones"""
+    goos = os.environ.get('GOOS') or GOOS_NAMES.get(platform.system().lower(), platform.system().lower())
+    goarch = os.environ.get('GOARCH') or GOARCH_NAMES.get(platform.machine().lower(), platform.machine().lower())
+    return {goos, goarch} | {tag for tag in extra if tag}
+
+def parse_tags(text: Optional[str]) -> List[str]:
+    """Splits a tag flag value: 'linux,debug' or 'linux debug'"""
+    return [tag for tag in re.split(r'[\s,]+', text or '') if tag]
+
+def evaluate(expression: str, tags: Set[str], line: int) -> bool:
+    """Evaluates a constraint (linux && !debug, (a || b) && c) against the tags"""
+    tokens = []
+    pos = 0
+    while expression[pos:].strip():
+        match = CONSTRAINT_TOKEN.match(expression, pos)
+        if not match:
+            raise directive_error(f"Invalid build constraint '{expression.strip()}'", line)
+        tokens.append(match.group(1))
+        pos = match.end()
+
+    index = 0
+
+    def fail() -> DirectiveError:
+        return directive_error(f"Invalid build constraint '{expression.strip()}'", line)
+
+    def parse_or() -> bool:
+        nonlocal index
+        value = parse_and()
+        while index < len(tokens) and tokens[index] == '||':
+            index += 1
+            value = parse_and() or value
+        return value
+
+    def parse_and() -> bool:
+        nonlocal index
+        value = parse_not()
+        while index < len(tokens) and tokens[index] == '&&':
+            index += 1
+            value = parse_not() and value
+        return value
+
+    def parse_not() -> bool:
+        nonlocal index
+        if index >= len(tokens):
+            raise fail()
+        token = tokens[index]
+        index += 1
+        if token == '!':
+            return not parse_not()
+        if token == '(':
+            value = parse_or()
+            if index >= len(tokens) or tokens[index] != ')':
+                raise fail()
+            index += 1
+            return value
+        if token in ('&&', '||', ')'):
+            raise fail()
+        return token in tags
+
+    result = parse_or()
+    if index != len(tokens):
+        raise fail()
+    return result
+
+def build_constraint(source: Union[str, TextIO]) -> Optional[Tuple[str, int]]:
+    """The //goplus:build expression of a file and its line; like //go:build it must come before the
+    package clause. source is the text of the file or the file itself, of which only the header is read"""
+    lines = source.split('\n') if isinstance(source, str) else source
+    for number, text in enumerate(lines, start=1):
+        stripped = text.strip()
+        if stripped.startswith(BUILD_PREFIX):
+            expression = stripped[len(BUILD_PREFIX):]
+            if expression[:1] not in (' ', '\t') or not expression.strip():
+                raise directive_error(f"Invalid build constraint '{stripped}'", number)
+            return expression.strip(), number
+        if stripped and not stripped.startswith('//'):
+            return None
+    return None
+
+def file_included(source: Union[str, TextIO], tags: Set[str]) -> bool:
+    """Whether the build constraint of a file (if any) holds for the tags"""
+    constraint = build_constraint(source)
+    return constraint is None or evaluate(constraint[0], tags, constraint[1])
+
+def preprocess(source: str, tags: Set[str]) -> str:
+    """Keeps the lines of the #if/#elif/#else branches whose condition holds. Directive lines and the
+    lines of inactive branches become empty, so tokens keep their line numbers"""
+    if '#' not in source:
+        return source
+    return ''.join(preprocess_lines(io.StringIO(source), tags))
+
+def preprocess_lines(lines: Iterable[str], tags: Set[str]) -> Iterator[str]:
+    """preprocess of a source read line by line (lines keep their '\\n'), so a file is never held whole"""
+    # Open #if blocks, innermost last
+    blocks: List[Dict] = []
+    for i, text in enumerate(lines):
+        match = CONDITIONAL_DIRECTIVE.match(text) if '#' in text else None
+        enclosing = all(block['active'] for block in blocks)
+        if not match:
+            yield text if enclosing else text[len(text.rstrip('\n')):]
+            continue
+
+        directive, condition = match.group(1), match.group(2).strip()
+        number = i + 1
+        if directive == 'if':
+            if not condition:
+                raise directive_error("#if without a condition", number)
+            active = enclosing and evaluate(condition, tags, number)
+            # taken: some branch of the block was active, so later ones are not
+            blocks.append({'active': active, 'taken': active, 'else': False, 'line': number})
+        elif not blocks:
+            raise directive_error(f"#{directive} without #if", number)
+        elif directive == 'endif':
+            blocks.pop()
+        else:
+            block = blocks[-1]
+            if block['else']:
+                raise directive_error(f"#{directive} after #else", number)
+            parent = all(outer['active'] for outer in blocks[:-1])
+            if directive == 'elif':
+                if not condition:
+                    raise directive_error("#elif without a condition", number)
+                block['active'] = parent and not block['taken'] and evaluate(condition, tags, number)
+            else:
+                if condition:
+                    raise directive_error(f"Unexpected '{condition}' after #else", number)
+                block['active'] = parent and not block['taken']
+                block['else'] = True
+            block['taken'] = block['taken'] or block['active']
+        yield text[len(text.rstrip('\n')):]
+
+    if blocks:
+        raise directive_error("Unterminated #if", blocks[-1]['line'])
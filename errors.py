@@ -0,0 +1,57 @@
+"""
+Errors of Go-Extended
+A diagnostic is a message with its ID, severity, suggested fix and the node it is at. Warnings are diagnostics, and
+every error of the lexer, parser, transpiler and Go check carries the diagnostics it reports, so tools take them as
+they are rather than from the text of the message
+"""
+
+from typing import List, Optional
+from ast_nodes import ASTNode
+
+# Errors by ID: (name, severity), as WARNINGS lists the warnings (GP1xxx, GP2xxx)
+ERRORS = {
+    'GP0001': ('syntax-error', 'error'),  # a source the lexer, the directives or the parser reject
+    'GP0002': ('undefined', 'error'),  # a name, member or package member that nothing declares
+    'GP0003': ('invalid-code', 'error'),  # code the transpiler cannot generate Go for
+    'GP0004': ('go-error', 'error'),  # generated Go that does not compile
+    'GP0005': ('build-error', 'error'),  # anything else stopping a build: a file, the settings, warnings as errors
+}
+
+class Diagnostic(str):
+    """A diagnostic as reported (message, suggested fix and position), with its ID, severity and the node it is at"""
+    code: str = ''
+    severity: str = 'warning'
+    message: str = ''
+    fix: Optional[str] = None
+    origin: Optional[ASTNode] = None
+
+def position(file: Optional[str], line: int, column: int, end_line: int = 0, end_column: int = 0) -> ASTNode:
+    """A node standing for a span of a source that is not one (a token, a directive line, a generated line)"""
+    return ASTNode(line=line, column=column, file=file, end_line=end_line, end_column=end_column)
+
+def error_diagnostic(code: str, message: str, node: Optional[ASTNode] = None, fix: Optional[str] = None,
+                     text: Optional[str] = None) -> Diagnostic:
+    """The diagnostic of an error; text is how the error reads (default: the message)"""
+    diagnostic = Diagnostic(text or message)
+    diagnostic.code, diagnostic.severity, diagnostic.message, diagnostic.fix, diagnostic.origin = \
+        code, 'error', message, fix, node
+    return diagnostic
+
+class CompileError(Exception):
+    """An error with the diagnostics it reports: those it is raised with, or else one holding its message, at no
+    position"""
+    code = 'GP0005'
+
+    def __init__(self, message: str = '', *diagnostics: Diagnostic):
+        super().__init__(message)
+        self.diagnostics: List[Diagnostic] = list(diagnostics) or [error_diagnostic(self.code, message)]
+
+    def __reduce__(self):
+        # Errors cross processes (the workers of -p) with their diagnostics
+        return type(self), (str(self), *self.diagnostics)
+
+def error_diagnostics(error: BaseException) -> List[Diagnostic]:
+    """The diagnostics an error reports; an exception that is no CompileError (an unreadable file...) reports its
+    message"""
+    return getattr(error, 'diagnostics', None) or \
+        [error_diagnostic(CompileError.code, str(error) or type(error).__name__)]
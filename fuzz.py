@@ -0,0 +1,149 @@
+#!/usr/bin/env python3
+"""
+Fuzzer for the Go-Extended front end
+Feeds random and mutated sources to the lexer and parser: each one must either parse or fail with a LexerError
+or ParseError, never with another exception (an IndexError, a RecursionError...)
+"""
+
+import re
+import sys
+import signal
+import random
+import argparse
+import hashlib
+import traceback
+from pathlib import Path
+from typing import List, Optional, Tuple
+from lexer import Lexer, LexerError
+from parser import Parser, ParseError
+from tokens import KEYWORDS, THREE_CHAR_OPERATORS, TWO_CHAR_OPERATORS, ONE_CHAR_OPERATORS
+
+# Pieces of go-plus the mutations insert: every keyword and operator, plus the starts of the constructs whose
+# ends the lexer and parser look for
+FRAGMENTS = [*KEYWORDS, *THREE_CHAR_OPERATORS, *TWO_CHAR_OPERATORS, *ONE_CHAR_OPERATORS,
+             '"', "'", '`', '"""', '${', '"${', '/*', '*/', '//', '\\', '\\x', '\\u', '\\U', '\\0', '0x', '0b',
+             '0o', '1e', '1.5f32', '255u8', '_', '#if linux', '#elif', '#else', '#endif', '//goplus:build',
+             '//goplus:nowarn', '@stringer', '@data', 'class A {', 'func f() {', '(', '[]', '{}', 'x', 'this.x',
+             ' ', '\n', '\t', '\r']
+
+# Openings of nested constructs, repeated by the nesting mutation
+NESTING = ['(', '[', '{', '-', '!', '*', '&', 'new ', 'x.', 'f(', '[]', 'map[', 'if x {', 'func() {', '"${',
+           'x ? ', 'class A {']
+
+# Sources mutated, besides random ones: the examples of the repository and the programs of its tests
+CORPUS_GLOBS = ('examples/*.gox', 'example_project/src/**/*.gox')
+TEST_PROGRAM = re.compile(r"'''\s*(package .*?)'''", re.S)
+
+def load_corpus(root: Path) -> List[str]:
+    """Sources of the examples and of the test programs"""
+    corpus = [path.read_text(encoding='utf-8') for pattern in CORPUS_GLOBS for path in sorted(root.glob(pattern))]
+    tests = root / 'test_transpiler.py'
+    if tests.exists():
+        corpus += TEST_PROGRAM.findall(tests.read_text(encoding='utf-8'))
+    return corpus
+
+def random_source(rng: random.Random) -> str:
+    """A sequence of fragments and arbitrary characters"""
+    parts = []
+    for _ in range(rng.randint(1, 60)):
+        if rng.random() < 0.8:
+            parts.append(rng.choice(FRAGMENTS))
+        else:
+            parts.append(chr(rng.choice([rng.randint(0, 0x7f), rng.randint(0x80, 0x10ffff)])))
+        if rng.random() < 0.5:
+            parts.append(' ')
+    return ''.join(parts)
+
+def mutate(source: str, rng: random.Random) -> str:
+    """A few random edits of a source: deleting, duplicating or moving a span, inserting a fragment, a character or
+    deeply nested code, truncating"""
+    for _ in range(rng.randint(1, 4)):
+        size = len(source)
+        start = rng.randint(0, size)
+        end = min(size, start + rng.choice([1, 2, 5, 20, 100]))
+        edit = rng.randrange(7)
+        if edit == 0:
+            source = source[:start] + source[end:]
+        elif edit == 1:
+            source = source[:end] + source[start:end] + source[end:]
+        elif edit == 2:
+            span, rest = source[start:end], source[:start] + source[end:]
+            at = rng.randint(0, len(rest))
+            source = rest[:at] + span + rest[at:]
+        elif edit == 3:
+            source = source[:start] + rng.choice(FRAGMENTS) + source[start:]
+        elif edit == 4:
+            source = source[:start] + chr(rng.randint(0, 0x7f)) + source[start:]
+        elif edit == 5:
+            source = source[:start] + rng.choice(NESTING) * rng.choice([10, 100, 1000]) + source[start:]
+        else:
+            source = source[:start]
+    return source
+
+class Hang(Exception):
+    """A source took longer than the time limit (a loop that makes no progress, most likely)"""
+    pass
+
+def _hang(signum, frame):
+    raise Hang()
+
+def check(source: str, timeout: float = 5.0) -> Optional[str]:
+    """The traceback of the crash a source causes, None when it parses or fails cleanly. Where there is SIGALRM,
+    taking more than timeout seconds is a crash too"""
+    alarm = hasattr(signal, 'SIGALRM')
+    if alarm:
+        previous = signal.signal(signal.SIGALRM, _hang)
+        signal.setitimer(signal.ITIMER_REAL, timeout)
+    try:
+        Parser(Lexer(source, set(), 'fuzz.gox').stream(), 'fuzz.gox').parse()
+    except (LexerError, ParseError):
+        pass
+    except Exception:
+        return traceback.format_exc()
+    finally:
+        if alarm:
+            signal.setitimer(signal.ITIMER_REAL, 0)
+            signal.signal(signal.SIGALRM, previous)
+    return None
+
+def fuzz(iterations: int, seed: int, corpus: List[str]) -> List[Tuple[str, str]]:
+    """Runs the fuzzer; returns the crashing sources with their tracebacks, one per distinct crash site"""
+    rng = random.Random(seed)
+    crashes = {}
+    for _ in range(iterations):
+        source = mutate(rng.choice(corpus), rng) if corpus and rng.random() < 0.7 else random_source(rng)
+        error = check(source)
+        if error:
+            # The crash site is the last frame of the traceback in the front end: the same bug found again is not
+            # kept
+            site = [line for line in error.split('\n')
+                    if line.strip().startswith('File ') and Path(__file__).name not in line][-1].strip()
+            if site not in crashes or len(source) < len(crashes[site][0]):
+                crashes[site] = (source, error)
+    return list(crashes.values())
+
+def main():
+    parser = argparse.ArgumentParser(description='Fuzz the Go-Extended lexer and parser')
+    parser.add_argument('-n', '--iterations', type=int, default=10000, help='Sources tried (default: 10000)')
+    parser.add_argument('--seed', type=int, default=0, help='Seed of the random generator, so a run can be repeated')
+    parser.add_argument('-o', '--output', default='fuzz-crashes',
+                        help='Directory the crashing sources are written to (default: fuzz-crashes)')
+    args = parser.parse_args()
+
+    root = Path(__file__).parent
+    crashes = fuzz(args.iterations, args.seed, load_corpus(root))
+    if not crashes:
+        print(f"No crashes in {args.iterations} sources (seed {args.seed})")
+        return
+
+    output = Path(args.output)
+    output.mkdir(parents=True, exist_ok=True)
+    for source, error in crashes:
+        path = output / f"crash-{hashlib.sha256(source.encode('utf-8', 'surrogatepass')).hexdigest()[:12]}.gox"
+        path.write_text(source, encoding='utf-8', errors='surrogatepass')
+        print(f"{path}:\n{error}")
+    print(f"{len(crashes)} crash{'es' if len(crashes) > 1 else ''} in {args.iterations} sources (seed {args.seed})")
+    sys.exit(1)
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,570 @@
+"""
+Code generators for Go-Extended classes
+Synthesizes the boilerplate members of data classes and similar declarations
+"""
+
+import re
+from typing import Dict, List, Optional, Tuple, Union
+from ast_nodes import *
+from literals import quote_string
+
+class GeneratorError(Exception):
+    """Invalid use of a generating annotation"""
+    pass
+
+class ClassGenerator:
+    """Adds generated members to classes (as MethodDecl nodes with RawStmt bodies)"""
+
+    # Class annotations handled by the generator (@deprecated only marks the generated Go declaration,
+    # @valueclass makes the transpiler hold instances by value)
+    ANNOTATIONS = {'cloneable', 'builder', 'accessors', 'json', 'stringer', 'deprecated', 'valueclass'}
+    # Method annotations built in (others must be declared: annotation route(path string))
+    METHOD_ANNOTATIONS = {'deprecated'}
+    # Field annotations handled by the generator (any other field annotation is a struct tag)
+    FIELD_ANNOTATIONS = {'observable'}
+
+    def __init__(self, classes: Dict[str, ClassDecl], embed_pointers: bool = False):
+        self.classes = classes
+        self.embed_pointers = embed_pointers  # base classes are embedded as *Base
+        self.builders: Dict[str, ClassDecl] = {}  # class name -> generated builder class
+
+    def expand(self, decl: ClassDecl) -> None:
+        """Adds the generated members of a class (idempotent)"""
+        if decl.expanded:
+            return
+        decl.expanded = True
+
+        if self.is_value_class(decl):
+            self._check_value_class(decl)
+        if decl.is_data:
+            self._expand_data_class(decl)
+        if decl.destructor:
+            self._expand_destructor(decl)
+        # Before accessors, so the notifying setter wins over a plain one
+        if any(t.name == 'observable' for f in decl.fields for t in f.tags):
+            self._expand_observable(decl)
+        if self.has_annotation(decl, 'accessors') or any(f.accessors for f in decl.fields):
+            self._expand_accessors(decl)
+        if self.has_annotation(decl, 'json'):
+            self._expand_json(decl)
+        if self.has_annotation(decl, 'stringer'):
+            self._expand_stringer(decl)
+        if self.has_annotation(decl, 'cloneable'):
+            self._expand_cloneable(decl)
+        if self.has_annotation(decl, 'builder'):
+            self._generate_builder(decl)
+        # Last, so serialization and cloning do not see the handler fields
+        if decl.events:
+            self._expand_events(decl)
+
+    def has_annotation(self, decl: Union[ClassDecl, MethodDecl], name: str) -> bool:
+        """Checks whether a class or method carries an annotation"""
+        return any(a.name == name for a in decl.annotations)
+
+    def is_value_class(self, decl: ClassDecl) -> bool:
+        """A @valueclass is held by value (Point) and gets value receivers"""
+        return self.has_annotation(decl, 'valueclass')
+
+    def annotation(self, decl: Union[ClassDecl, MethodDecl], name: str) -> Optional[Annotation]:
+        """Returns a class or method annotation by name"""
+        return next((a for a in decl.annotations if a.name == name), None)
+
+    def _expand_destructor(self, decl: ClassDecl) -> None:
+        """Turns ~ClassName() into Dispose(), which also cancels the finalizer set by the constructor"""
+        cancel = RawStmt('runtime.SetFinalizer(this, nil)', ['runtime'])
+        body = BlockStmt([cancel] + decl.destructor.statements)
+        decl.methods.append(MethodDecl('Dispose', [], None, body))
+
+    # ------------------------------------------------------------------------
+    # Data classes
+    # ------------------------------------------------------------------------
+
+    def _expand_data_class(self, decl: ClassDecl) -> None:
+        """Generates constructor, accessors, Equals, HashCode and String for a data class"""
+        receiver_type = self._receiver_type(decl)
+
+        if not decl.constructor:
+            params = [Parameter(f.name, f.type) for f in decl.fields]
+            body = [AssignStmt(SelectorExpr(ThisExpr(), f.name), Identifier(f.name)) for f in decl.fields]
+            decl.constructor = ConstructorDecl(params, BlockStmt(body))
+
+        for f in decl.fields:
+            accessor = 'Get' + f.name[:1].upper() + f.name[1:]
+            self._add_method(decl, accessor, [], f.type, f'return this.{f.name}')
+
+        # Values cannot be nil: no nil checks
+        value = self.is_value_class(decl)
+        comparisons = ' && '.join(self._field_equality(decl, f) for f in decl.fields)
+        imports = ['reflect'] if any(self._needs_deep_equal(decl, f.type) for f in decl.fields) else []
+        other_type = receiver_type if value else f'*{receiver_type}'
+        self._add_method(decl, 'Equals', [Parameter('other', other_type)], 'bool',
+                         ('' if value else
+                          'if this == nil || other == nil {\n'
+                          '    return this == other\n'
+                          '}\n') +
+                         f'return {comparisons}',
+                         imports, operator='==')
+
+        verbs = '|'.join('%v' for _ in decl.fields)
+        values = ', '.join(self._field_hash_value(f) for f in decl.fields)
+        self._add_method(decl, 'HashCode', [], 'uint64',
+                         ('' if value else
+                          'if this == nil {\n'
+                          '    return 0\n'
+                          '}\n') +
+                         'h := fnv.New64a()\n'
+                         f'fmt.Fprintf(h, "{verbs}", {values})\n'
+                         'return h.Sum64()',
+                         ['fmt', 'hash/fnv'])
+
+        template = ', '.join(f'{f.name}=%v' for f in decl.fields)
+        values = ', '.join(f'this.{f.name}' for f in decl.fields)
+        self._add_method(decl, 'String', [], 'string',
+                         f'return fmt.Sprintf("{decl.name}({template})", {values})',
+                         ['fmt'])
+
+        if decl.is_record and value:
+            # A value receiver is already a copy
+            self._add_method(decl, 'copyWith', [Parameter('set', f'func(*{receiver_type})')], receiver_type,
+                             'set(&this)\n'
+                             'return this')
+        elif decl.is_record:
+            # Backs the with expression: p with { age: 30 } -> p.copyWith(func(c *Person) { c.age = 30 })
+            self._add_method(decl, 'copyWith', [Parameter('set', f'func(*{receiver_type})')], f'*{receiver_type}',
+                             'copy := *this\n'
+                             'set(&copy)\n'
+                             'return &copy')
+
+    def _field_equality(self, decl: ClassDecl, f: ClassField) -> str:
+        """Structural comparison of one field"""
+        if self._data_class_of(f.type):
+            return f'this.{f.name}.Equals(other.{f.name})'
+        if self._needs_deep_equal(decl, f.type):
+            return f'reflect.DeepEqual(this.{f.name}, other.{f.name})'
+        return f'this.{f.name} == other.{f.name}'
+
+    def _field_hash_value(self, f: ClassField) -> str:
+        """Value hashed for one field (nested data classes contribute their own hash)"""
+        if self._data_class_of(f.type):
+            return f'this.{f.name}.HashCode()'
+        return f'this.{f.name}'
+
+    def _needs_deep_equal(self, decl: ClassDecl, type_name: str) -> bool:
+        """Slices, maps, funcs and non-comparable type parameters cannot use =="""
+        type_param = next((tp for tp in decl.type_params if tp.name == type_name), None)
+        if type_param:
+            return type_param.constraint != 'comparable'
+        return type_name.startswith('[]') or type_name.startswith('map[') or type_name.startswith('func(')
+
+    def _data_class_of(self, type_name: str) -> Optional[ClassDecl]:
+        """Returns the data class behind a *Point field type (Point for a @valueclass)"""
+        cls = self.classes.get(type_name.lstrip('*').split('[')[0])
+        if not cls or not cls.is_data or type_name.startswith('*') == self.is_value_class(cls):
+            return None
+        return cls
+
+    # ------------------------------------------------------------------------
+    # Events
+    # ------------------------------------------------------------------------
+
+    def _expand_events(self, decl: ClassDecl) -> None:
+        """Generates a handler slice and Add/Remove/Raise methods per event, guarded by the class's event mutex"""
+        lock = self.events_lock(decl)
+        for event in decl.events:
+            handlers = f'{event.name[:1].lower()}{event.name[1:]}Handlers'
+            handler_type = 'func(' + ', '.join(f'{p.name} {p.type}' for p in event.params) + ')'
+            args = ', '.join(p.name + ('...' if p.type.startswith('...') else '') for p in event.params)
+            decl.fields.append(ClassField(handlers, f'[]{handler_type}'))
+
+            self._add_method(decl, f'Add{event.name}', [Parameter('handler', handler_type)], None,
+                             f'{lock}.Lock()\n'
+                             f'defer {lock}.Unlock()\n'
+                             f'this.{handlers} = append(this.{handlers}, handler)',
+                             ['sync'])
+            # Functions are not comparable: handlers are matched by code pointer, most recent first
+            self._add_method(decl, f'Remove{event.name}', [Parameter('handler', handler_type)], None,
+                             f'{lock}.Lock()\n'
+                             f'defer {lock}.Unlock()\n'
+                             'target := reflect.ValueOf(handler).Pointer()\n'
+                             f'for i := len(this.{handlers}) - 1; i >= 0; i-- {{\n'
+                             f'    if reflect.ValueOf(this.{handlers}[i]).Pointer() == target {{\n'
+                             f'        this.{handlers} = append(this.{handlers}[:i:i], this.{handlers}[i+1:]...)\n'
+                             '        return\n'
+                             '    }\n'
+                             '}',
+                             ['sync', 'reflect'])
+            # Handlers run outside the lock, so they may subscribe or unsubscribe
+            self._add_method(decl, f'Raise{event.name}', list(event.params), None,
+                             f'{lock}.Lock()\n'
+                             f'handlers := this.{handlers}\n'
+                             f'{lock}.Unlock()\n'
+                             'for _, handler := range handlers {\n'
+                             f'    handler({args})\n'
+                             '}',
+                             ['sync'])
+
+    def events_lock(self, decl: ClassDecl) -> str:
+        """Package-level mutex guarding the event handlers of a class (a struct field would be copied by embedding)"""
+        return f'{decl.name[:1].lower()}{decl.name[1:]}Events'
+
+    # ------------------------------------------------------------------------
+    # Value classes (@valueclass)
+    # ------------------------------------------------------------------------
+
+    def _check_value_class(self, decl: ClassDecl) -> None:
+        """Rejects what needs a shared instance: inheritance, identity (destructor, events) and members that
+        modify the receiver, which is a copy in a value class"""
+        if self.annotation(decl, 'valueclass').args:
+            raise GeneratorError(f"@valueclass on class {decl.name} takes no arguments")
+        if decl.extends:
+            raise GeneratorError(f"Value class {decl.name} cannot extend {decl.extends}")
+        subclass = next((c for c in self.classes.values()
+                         if c.extends and c.extends.split('[')[0] == decl.name), None)
+        if subclass:
+            raise GeneratorError(f"Value class {decl.name} cannot be extended by {subclass.name}")
+        if decl.is_inner:
+            raise GeneratorError(f"Inner class {decl.name} cannot be a value class")
+        if decl.destructor or decl.events:
+            member = 'a destructor' if decl.destructor else 'events'
+            raise GeneratorError(f"Value class {decl.name} cannot declare {member}: its instances are copied")
+        # @accessors generates setters too
+        for name in ('accessors', 'cloneable', 'builder', 'json'):
+            if self.has_annotation(decl, name):
+                raise GeneratorError(f"@{name} cannot be used on value class {decl.name}")
+        for f in decl.fields:
+            if 'set' in f.accessors or any(t.name == 'observable' for t in f.tags):
+                raise GeneratorError(f"Value class {decl.name} cannot generate a setter for {f.name}: "
+                                     f"it would modify a copy")
+
+    # ------------------------------------------------------------------------
+    # Accessors (@accessors, get/set field flags)
+    # ------------------------------------------------------------------------
+
+    def _expand_accessors(self, decl: ClassDecl) -> None:
+        """Generates GetX/SetX for every field of an @accessors class, or for the flagged fields"""
+        all_fields = self.has_annotation(decl, 'accessors')
+        for f in decl.fields:
+            flags = f.accessors or (['get', 'set'] if all_fields else [])
+            suffix = f.name[:1].upper() + f.name[1:]
+            if 'get' in flags:
+                self._add_method(decl, 'Get' + suffix, [], f.type, f'return this.{f.name}')
+            # Records are immutable: only getters are generated
+            if 'set' in flags and not decl.is_record:
+                lines = [f'this.{f.name} = {f.name}']
+                # Optional hook: validateAge(age int) runs first and may throw to reject the value
+                if any(m.name == 'validate' + suffix for m in decl.methods):
+                    lines.insert(0, f'this.validate{suffix}({f.name})')
+                self._add_method(decl, 'Set' + suffix, [Parameter(f.name, f.type)], None, '\n'.join(lines))
+
+    # ------------------------------------------------------------------------
+    # Observable fields (@observable)
+    # ------------------------------------------------------------------------
+
+    def _expand_observable(self, decl: ClassDecl) -> None:
+        """Adds an OnXChanged(oldValue, newValue) event per @observable field, raised by its generated setter"""
+        if decl.is_record:
+            raise GeneratorError(f"Record {decl.name} is immutable: its fields cannot be @observable")
+        for f in decl.fields:
+            tag = next((t for t in f.tags if t.name == 'observable'), None)
+            if not tag:
+                continue
+            if tag.args:
+                raise GeneratorError(f"@observable on field {decl.name}.{f.name} takes no arguments")
+            suffix = f.name[:1].upper() + f.name[1:]
+            event = f'On{suffix}Changed'
+            for member in [e.name for e in decl.events] + [m.name for m in decl.methods]:
+                if member in (event, 'Set' + suffix):
+                    raise GeneratorError(f"@observable field {decl.name}.{f.name} conflicts with member {member}")
+            decl.events.append(EventDecl(event, [Parameter('oldValue', f.type), Parameter('newValue', f.type)]))
+
+            if self._needs_deep_equal(decl, f.type):
+                changed, imports = f'!reflect.DeepEqual(oldValue, {f.name})', ['reflect']
+            else:
+                changed, imports = f'oldValue != {f.name}', []
+            lines = [f'oldValue := this.{f.name}',
+                     f'this.{f.name} = {f.name}',
+                     f'if {changed} {{',
+                     f'    this.Raise{event}(oldValue, {f.name})',
+                     '}']
+            if any(m.name == 'validate' + suffix for m in decl.methods):
+                lines.insert(0, f'this.validate{suffix}({f.name})')
+            self._add_method(decl, 'Set' + suffix, [Parameter(f.name, f.type)], None, '\n'.join(lines), imports)
+
+    # ------------------------------------------------------------------------
+    # JSON (@json)
+    # ------------------------------------------------------------------------
+
+    def _expand_json(self, decl: ClassDecl) -> None:
+        """Generates MarshalJSON/UnmarshalJSON covering private and inherited fields, plus ToJSON/ParseJSON"""
+        fields = [item for item in self._class_fields(decl, 'this', {}) if self._json_key(item[1]) != '-']
+        members = []
+        used = set()
+        for path, f, field_type in fields:
+            member = f.name[:1].upper() + f.name[1:]
+            while member in used:
+                member += '_'
+            used.add(member)
+            members.append((member, path, field_type, self._json_key(f)))
+
+        shadow = ['var fields struct {']
+        shadow.extend(f'    {member} {field_type} `json:"{key}"`' for member, _, field_type, key in members)
+        shadow.append('}')
+        load = [f'fields.{member} = {path}' for member, path, _, _ in members]
+        store = [f'{path} = fields.{member}' for member, path, _, _ in members]
+
+        self._add_method(decl, 'MarshalJSON', [], '([]byte, error)',
+                         '\n'.join(shadow + load + ['return json.Marshal(fields)']),
+                         ['encoding/json'])
+        # Keys missing from the input keep their current values (the constructor's defaults)
+        self._add_method(decl, 'UnmarshalJSON', [Parameter('data', '[]byte')], 'error',
+                         '\n'.join(shadow + load +
+                                    ['if err := json.Unmarshal(data, &fields); err != nil {',
+                                     f'    return NewException("FormatException", fmt.Sprintf("cannot decode {decl.name}: %v", err))',
+                                     '}'] +
+                                    store + ['return nil']),
+                         ['encoding/json', 'fmt'])
+        self._add_method(decl, 'ToJSON', [], 'string',
+                         'data, err := this.MarshalJSON()\n'
+                         'if err != nil {\n'
+                         f'    panic(NewException("FormatException", fmt.Sprintf("cannot encode {decl.name}: %v", err)))\n'
+                         '}\n'
+                         'return string(data)',
+                         ['fmt'])
+        self._add_method(decl, 'ParseJSON', [Parameter('data', 'string')], None,
+                         'if err := this.UnmarshalJSON([]byte(data)); err != nil {\n'
+                         '    panic(err)\n'
+                         '}')
+
+    def _json_key(self, f: ClassField) -> str:
+        """JSON key and options of a field: its @json tag, or the field name"""
+        tag = next((t for t in f.tags if t.name == 'json'), None)
+        if tag and tag.args and all(isinstance(a, Literal) for a in tag.args):
+            key = ','.join(str(a.value) for a in tag.args)
+            # @json(",omitempty") keeps the field name as key
+            return f.name + key if key.startswith(',') else key
+        return f.name
+
+    # ------------------------------------------------------------------------
+    # Stringer (@stringer)
+    # ------------------------------------------------------------------------
+
+    def _expand_stringer(self, decl: ClassDecl) -> None:
+        """Generates String() listing the fields (inherited ones first), or from @stringer("{name} ({age})")"""
+        annotation = self.annotation(decl, 'stringer')
+        fields = self._class_fields(decl, 'this', {})
+
+        if not annotation.args:
+            template = ', '.join(f'{f.name}=%v' for _, f, _ in fields)
+            values = [path for path, _, _ in fields]
+            format_string = f'{decl.name}({template})'
+        else:
+            template = annotation.args[0]
+            if len(annotation.args) > 1 or not (isinstance(template, Literal) and template.type == 'string'):
+                raise GeneratorError(f"@stringer on class {decl.name} expects a single format string")
+            paths = {f.name: path for path, f, _ in fields}
+            values = []
+
+            def placeholder(match) -> str:
+                name = match.group(1)
+                if name not in paths:
+                    raise GeneratorError(f"@stringer on class {decl.name} refers to unknown field {name}")
+                values.append(paths[name])
+                return '%v'
+            format_string = re.sub(r'\{(\w+)\}', placeholder, template.value.replace('%', '%%'))
+
+        arguments = ''.join(f', {value}' for value in values)
+        self._add_method(decl, 'String', [], 'string', f'return fmt.Sprintf({quote_string(format_string)}{arguments})', ['fmt'])
+
+    # ------------------------------------------------------------------------
+    # Cloning (@cloneable)
+    # ------------------------------------------------------------------------
+
+    def _expand_cloneable(self, decl: ClassDecl) -> None:
+        """Generates Clone(), a deep copy that preserves shared references and cycles"""
+        receiver_type = self._receiver_type(decl)
+        self._add_method(decl, 'Clone', [], f'*{receiver_type}', 'return this.cloneWith(make(map[any]any))')
+        self._add_method(decl, 'cloneWith', [Parameter('seen', 'map[any]any')], f'*{receiver_type}',
+                         'if this == nil {\n'
+                         '    return nil\n'
+                         '}\n'
+                         'if copied, ok := seen[this]; ok {\n'
+                         f'    return copied.(*{receiver_type})\n'
+                         '}\n'
+                         f'c := &{receiver_type}{{}}\n'
+                         'seen[this] = c\n'
+                         'this.cloneFields(c, seen)\n'
+                         'return c')
+        self._add_clone_fields(decl)
+
+    def _add_clone_fields(self, decl: ClassDecl) -> None:
+        """Generates cloneFields, which copies a class's own fields and then its base class's"""
+        lines = []
+        if decl.extends:
+            base_name = decl.extends.split('[')[0]
+            embedded = base_name.split('.')[-1]
+            base = self.classes.get(base_name)
+            if base and '.' not in base_name:
+                self._add_clone_fields(base)
+                if self.embed_pointers:
+                    lines.append(f'c.{embedded} = &{decl.extends}{{}}')
+                    lines.append(f'this.{embedded}.cloneFields(c.{embedded}, seen)')
+                else:
+                    lines.append(f'this.{embedded}.cloneFields(&c.{embedded}, seen)')
+            elif self.embed_pointers:
+                # Classes from other packages cannot get generated methods: copied as a value
+                lines.append(f'c.{embedded} = new({decl.extends})')
+                lines.append(f'*c.{embedded} = *this.{embedded}')
+            else:
+                # Classes from other packages cannot get generated methods: copied as a value
+                lines.append(f'c.{embedded} = this.{embedded}')
+
+        for f in decl.fields:
+            lines.extend(self._clone_field(f))
+
+        receiver_type = self._receiver_type(decl)
+        self._add_method(decl, 'cloneFields', [Parameter('c', f'*{receiver_type}'), Parameter('seen', 'map[any]any')],
+                         None, '\n'.join(lines))
+
+    def _clone_field(self, f: ClassField) -> List[str]:
+        """Deep copy of one field into c"""
+        if self._cloneable_class_of(f.type):
+            return [f'c.{f.name} = this.{f.name}.cloneWith(seen)']
+
+        if f.type.startswith('[]'):
+            element_type = f.type[2:]
+            if not self._cloneable_class_of(element_type):
+                return [f'c.{f.name} = append({f.type}(nil), this.{f.name}...)']
+            return [f'if this.{f.name} != nil {{',
+                    f'    c.{f.name} = make({f.type}, len(this.{f.name}))',
+                    f'    for i, item := range this.{f.name} {{',
+                    f'        c.{f.name}[i] = item.cloneWith(seen)',
+                    '    }',
+                    '}']
+
+        if f.type.startswith('map['):
+            value_type = self._map_value_type(f.type)
+            value = 'value.cloneWith(seen)' if self._cloneable_class_of(value_type) else 'value'
+            return [f'if this.{f.name} != nil {{',
+                    f'    c.{f.name} = make({f.type}, len(this.{f.name}))',
+                    f'    for key, value := range this.{f.name} {{',
+                    f'        c.{f.name}[key] = {value}',
+                    '    }',
+                    '}']
+
+        return [f'c.{f.name} = this.{f.name}']
+
+    def _cloneable_class_of(self, type_name: str) -> Optional[ClassDecl]:
+        """Returns the @cloneable class behind a pointer type"""
+        if not type_name.startswith('*'):
+            return None
+        cls = self.classes.get(type_name[1:].split('[')[0])
+        return cls if cls and self.has_annotation(cls, 'cloneable') else None
+
+    def _map_value_type(self, map_type: str) -> str:
+        """Value type of map[K]V (K may itself contain brackets)"""
+        depth = 0
+        for i, char in enumerate(map_type[3:], start=3):
+            if char == '[':
+                depth += 1
+            elif char == ']':
+                depth -= 1
+                if depth == 0:
+                    return map_type[i + 1:]
+        return ''
+
+    # ------------------------------------------------------------------------
+    # Builders (@builder)
+    # ------------------------------------------------------------------------
+
+    def _generate_builder(self, decl: ClassDecl) -> None:
+        """Generates PersonBuilder with one With method per constructor parameter (or field) and Build"""
+        receiver_type = self._receiver_type(decl)
+        builder_name = f'{decl.name}Builder'
+        builder_type = builder_name + receiver_type[len(decl.name):]
+        builder = ClassDecl(builder_name, None, [], [], None, type_params=list(decl.type_params))
+        type_args = receiver_type[len(decl.name):]
+
+        if decl.constructor:
+            # A variadic parameter is held as a slice and spread into the constructor
+            builder.fields = [ClassField(p.name, '[]' + p.type[3:] if p.type.startswith('...') else p.type,
+                                         nullable=p.nullable) for p in decl.constructor.params]
+            args = ', '.join(f'this.{p.name}' + ('...' if p.type.startswith('...') else '')
+                             for p in decl.constructor.params)
+            # The real constructor runs, so its validation and exceptions apply to Build too
+            build = f'return New{decl.name}{type_args}({args})'
+        else:
+            builder.fields = [ClassField(f.name, f.type, f.value, nullable=f.nullable) for f in decl.fields]
+            lines = [f'obj := New{decl.name}{type_args}()']
+            lines.extend(f'obj.{f.name} = this.{f.name}' for f in decl.fields)
+            lines.append('return obj')
+            build = '\n'.join(lines)
+
+        params = decl.constructor.params if decl.constructor else builder.fields
+        for f, param in zip(builder.fields, params):
+            setter = 'With' + f.name[:1].upper() + f.name[1:]
+            self._add_method(builder, setter, [Parameter(f.name, param.type)], f'*{builder_type}',
+                             f'this.{f.name} = {f.name}\n'
+                             'return this')
+        self._add_method(builder, 'Build', [], f'*{receiver_type}', build)
+
+        builder.expanded = True
+        self.builders[decl.name] = builder
+
+    # ------------------------------------------------------------------------
+    # Helpers
+    # ------------------------------------------------------------------------
+
+    def _receiver_type(self, decl: ClassDecl) -> str:
+        """Class type as seen from its own methods (Point, Pair[K, V])"""
+        if decl.type_params:
+            return f'{decl.name}[' + ', '.join(tp.name for tp in decl.type_params) + ']'
+        return decl.name
+
+    def _class_fields(self, decl: ClassDecl, path: str,
+                      mapping: Dict[str, str]) -> List[Tuple[str, ClassField, str]]:
+        """Fields as (Go access path, field, type): base class fields first, then the class's own"""
+        fields = []
+        own = {f.name for f in decl.fields}
+        if decl.extends:
+            base_name, type_args = self._split_type_args(decl.extends)
+            base = self.classes.get(base_name)
+            # Private fields of classes from other packages are not accessible
+            if base and '.' not in base_name:
+                base_mapping = {tp.name: self._substitute(arg, mapping)
+                                for tp, arg in zip(base.type_params, type_args)}
+                inherited = self._class_fields(base, f'{path}.{base_name}', base_mapping)
+                fields.extend(item for item in inherited if item[1].name not in own)
+        for f in decl.fields:
+            fields.append((f'{path}.{f.name}', f, self._substitute(f.type, mapping)))
+        return fields
+
+    def _split_type_args(self, type_name: str) -> Tuple[str, List[str]]:
+        """Splits 'Box[int, map[K]V]' into ('Box', ['int', 'map[K]V'])"""
+        if '[' not in type_name or not type_name.endswith(']'):
+            return type_name, []
+        base, inner = type_name[:type_name.index('[')], type_name[type_name.index('[') + 1:-1]
+        args, depth, current = [], 0, ''
+        for char in inner:
+            if char == ',' and depth == 0:
+                args.append(current.strip())
+                current = ''
+                continue
+            depth += char == '['
+            depth -= char == ']'
+            current += char
+        args.append(current.strip())
+        return base, args
+
+    def _substitute(self, type_name: str, mapping: Dict[str, str]) -> str:
+        """Replaces type parameters in a type string"""
+        if not mapping:
+            return type_name
+        return re.sub(r'\b\w+\b', lambda m: mapping.get(m.group(0), m.group(0)), type_name)
+
+    def _add_method(self, decl: ClassDecl, name: str, params: List[Parameter], return_type: Optional[str],
+                    code: str, imports: Optional[List[str]] = None, operator: Optional[str] = None) -> None:
+        """Adds a generated method unless the class already declares one with that name"""
+        if any(m.name == name for m in decl.methods):
+            return
+        body = BlockStmt([RawStmt(code, imports or [])] if code else [])
+        decl.methods.append(MethodDecl(name, params, return_type, body, operator=operator))
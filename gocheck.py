@@ -0,0 +1,86 @@
+"""
+Go toolchain check for Go-Extended
+Compiles the generated Go in a scratch module before it is written, reporting failures at the go-plus source
+"""
+
+import os
+import re
+import shutil
+import subprocess
+import tempfile
+from pathlib import Path
+from typing import Dict, List, Optional, Tuple
+from sourcemap import Origin
+from errors import CompileError, Diagnostic, error_diagnostic, position
+
+class GoCheckError(CompileError):
+    """Generated Go that does not compile: an internal error of the transpiler"""
+    code = 'GP0004'
+
+# path.go:12:5: message, as the Go compiler reports errors
+GO_DIAGNOSTIC = re.compile(r'^(?P<path>[^\s:]+\.go):(?P<line>\d+)(?::(?P<column>\d+))?: (?P<message>.*)$')
+
+# Errors of imports the check cannot resolve (a module missing from go.mod or go.sum, or not downloaded): they say
+# nothing of the generated Go, which the user's go get or go mod tidy makes buildable
+UNRESOLVED_IMPORT = re.compile(r'no required module provides package|missing go\.sum entry|'
+                               r'cannot find module providing package|is not in std|cannot find package')
+
+def go_available() -> bool:
+    """Whether the go command is on the PATH"""
+    return shutil.which('go') is not None
+
+def find_module(directory: Path) -> Optional[Path]:
+    """The root of the Go module a directory belongs to (the nearest one with a go.mod), None outside modules"""
+    for candidate in (directory, *directory.parents):
+        if (candidate / 'go.mod').is_file():
+            return candidate
+    return None
+
+def unresolved_imports(errors: List[Diagnostic]) -> List[Diagnostic]:
+    """The errors of check_files that come from imports it could not resolve rather than from the generated Go"""
+    return [error for error in errors if UNRESOLVED_IMPORT.search(error)]
+
+def check_files(files: Dict[str, Tuple[str, List[Origin]]], go_mod: str,
+                support: Optional[Dict[str, str]] = None) -> List[Diagnostic]:
+    """Type-checks and compiles generated files (path in the module -> code and line origins) with go build in a
+    scratch module, along with support files (go.sum, the shared exceptions package). Returns the compiler's
+    errors, each led by the go-plus position of the failing line and followed by the generated one"""
+    with tempfile.TemporaryDirectory(prefix='goplus-check-') as scratch:
+        root = Path(scratch)
+        sources = {**(support or {}), **{path: code for path, (code, _) in files.items()}, 'go.mod': go_mod}
+        for path, code in sources.items():
+            (root / path).parent.mkdir(parents=True, exist_ok=True)
+            (root / path).write_text(code, encoding='utf-8')
+        # -e: every error rather than the first ten; GOTOOLCHAIN=local: never download a toolchain for the check
+        result = subprocess.run(['go', 'build', '-gcflags=all=-e', '-o', os.devnull, './...'], cwd=root,
+                                capture_output=True, text=True, env={**os.environ, 'GOTOOLCHAIN': 'local'})
+    if result.returncode == 0:
+        return []
+
+    errors = []
+    for line in result.stderr.splitlines():
+        match = GO_DIAGNOSTIC.match(line.strip())
+        if not match:
+            continue
+        path = Path(match.group('path')).as_posix()
+        generated = f"{path}:{match.group('line')}" + (f":{match.group('column')}" if match.group('column') else '')
+        _, origins = files.get(path, ('', []))
+        index = int(match.group('line')) - 1
+        origin = origins[index] if 0 <= index < len(origins) else None
+        source = next((o[0] for o in origins if o and o[0]), None)
+        message = match.group('message')
+        if origin and origin[0]:
+            errors.append(error_diagnostic(GoCheckError.code, message, position(*origin),
+                                           text=f"{origin[0]}:{origin[1]}:{origin[2]}: {message} ({generated})"))
+        elif source:
+            # A generated line (an import, a runtime helper): the file is not written, so the go-plus source is named
+            errors.append(error_diagnostic(GoCheckError.code, message, position(source, 0, 0),
+                                           text=f"{source}: {message} (generated {generated})"))
+        else:
+            errors.append(error_diagnostic(GoCheckError.code, message, text=f"{generated}: {message}"))
+    # Failures that name no Go position (a missing module, a broken toolchain) are passed on as they are
+    return errors or [error_diagnostic(GoCheckError.code, line) for line in result.stderr.splitlines() if line.strip()]
+
+def check_error(errors: List[Diagnostic]) -> GoCheckError:
+    """The error reporting generated Go that does not compile"""
+    return GoCheckError('the generated Go does not compile:\n' + '\n'.join(errors), *errors)
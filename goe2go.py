@@ -5,10 +5,45 @@ Supports single files and complete projects
 """
 
 import sys
+import difflib
 import argparse
+import contextlib
 from pathlib import Path
+from typing import Optional
+from plugins import PluginError, load_plugins
 from project_manager import ProjectManager
 from main import main as transpile_single_file
+from directives import DirectiveError, parse_tags, default_tags
+from goformat import FORMATTERS
+from transpiler import RECEIVER_STYLES, Transpiler, TranspilerError, WarningError, parse_warning_levels
+from lexer import Lexer, LexerError
+from parser import Parser, ParseError
+from printer import PrintError, format_source
+from astdump import dump_json, dump_tree
+from linter import Linter
+from diagnostics import DIAGNOSTICS_FORMATS, structured_output, report_warnings, print_error
+from errors import error_diagnostics
+
+TAGS_HELP = 'Build tags for //goplus:build and #if, besides GOOS/GOARCH (debug,linux)'
+LINE_DIRECTIVES_HELP = 'Write //line directives so Go errors and panics point at the source lines'
+SOURCE_MAP_HELP = 'Write a JSON source map of each generated file next to it (main.go.map)'
+NO_VERIFY_HELP = 'Write the generated Go without compiling it first'
+RECEIVER_HELP = "Receiver name of generated methods: this, self or the type's initial (default: this)"
+INLINE_ACCESSORS_HELP = 'Replace calls to trivial getters and setters with direct field access'
+WARNINGS_HELP = 'Severity of warnings by ID: error, warning, info or off (GP1003=error,GP1004=off)'
+RELEASE_HELP = 'Leave unreachable code, uncalled methods and unused classes out of the generated Go'
+FORMAT_HELP = 'Formatter of the generated Go (default: gofmt, or "format" in goe2go.json)'
+NO_CACHE_HELP = 'Transpile every file again, without taking unchanged ones from the build cache'
+DIAGNOSTICS_FORMAT_HELP = 'Write warnings and errors to stdout as json or sarif, other output to stderr'
+JOBS_HELP = 'Parse files and transpile packages with N worker processes (default: 1; 0: one per CPU)'
+PLUGIN_HELP = 'Code generation plugin: a module, or a .py file (repeatable; "plugins" in goe2go.json for projects)'
+
+def job_count(value: str) -> int:
+    """Value of -p: a number of worker processes, 0 for one per CPU"""
+    jobs = int(value)
+    if jobs < 0:
+        raise argparse.ArgumentTypeError(f"invalid job count: {value}")
+    return jobs
 
 def cmd_init(args):
     """Initialize a new project"""
@@ -19,31 +54,149 @@ def cmd_init(args):
     manager.init_project(args.name, go_mod)
 
 def cmd_build(args):
-    """Build the project"""
+    """Build the project; returns the manager, which maps the generated Go lines back to their sources"""
+    project_root = Path(args.directory) if args.directory else Path.cwd()
+    manager = ProjectManager(project_root, parse_tags(args.tags), args.line_directives, args.source_map,
+                             not args.no_verify, args.format, args.release, not args.no_cache,
+                             args.jobs)
+    
+    with structured_output(args.diagnostics_format, manager.diagnostics):
+        try:
+            manager.transpile_project()
+        except Exception as e:
+            if not isinstance(e, WarningError):
+                manager.diagnostics.extend(error_diagnostics(e))
+            print_error(e, root=manager.project_root)
+            if args.verbose:
+                import traceback
+                traceback.print_exc()
+            sys.exit(1)
+    return manager
+
+def cmd_info(args):
+    """Show project information"""
     project_root = Path(args.directory) if args.directory else Path.cwd()
     manager = ProjectManager(project_root)
     
     try:
-        manager.transpile_project()
+        manager.show_project_info()
     except Exception as e:
-        print(f"Error during build: {e}")
+        print(f"Error getting project information: {e}")
         if args.verbose:
             import traceback
             traceback.print_exc()
-        sys.exit(1)
 
-def cmd_info(args):
-    """Show project information"""
+def cmd_index(args):
+    """Update the exported symbol index of the project"""
     project_root = Path(args.directory) if args.directory else Path.cwd()
-    manager = ProjectManager(project_root)
+    manager = ProjectManager(project_root, parse_tags(args.tags))
     
     try:
-        manager.show_project_info()
+        manager.index_project()
     except Exception as e:
-        print(f"Error getting project information: {e}")
+        print_error(e, root=manager.project_root)
         if args.verbose:
             import traceback
             traceback.print_exc()
+        sys.exit(1)
+
+def cmd_lint(args):
+    """Lint the given files, or every file of the project"""
+    project_root = Path(args.directory) if args.directory else Path.cwd()
+    manager = ProjectManager(project_root, parse_tags(args.tags))
+    
+    with structured_output(args.diagnostics_format, manager.diagnostics):
+        try:
+            warning_levels = parse_warning_levels(args.warnings)
+            if args.files:
+                lint_files(args.files, default_tags(parse_tags(args.tags)), Linter(warning_levels),
+                           manager.diagnostics)
+            else:
+                manager.lint_project(warning_levels)
+        except Exception as e:
+            if not isinstance(e, WarningError):
+                manager.diagnostics.extend(error_diagnostics(e))
+            print_error(e, root=manager.project_root)
+            if args.verbose:
+                import traceback
+                traceback.print_exc()
+            sys.exit(1)
+
+def lint_files(paths, tags, linter, diagnostics):
+    """Lint single files, outside of any project; their warnings are added to diagnostics"""
+    failed = []
+    for path in paths:
+        with open(path, 'r', encoding='utf-8') as f:
+            program = Parser(Lexer(f, tags, path).stream(), path).parse()
+        warnings = linter.lint(program)
+        diagnostics.extend(warnings)
+        try:
+            report_warnings(warnings, path)
+        except WarningError as e:
+            failed.append(str(e))  # the remaining files are still linted
+    if failed:
+        raise WarningError('\n'.join(failed))
+
+def cmd_fmt(args):
+    """Format go-plus files in the canonical layout, like gofmt: print them, or with -l, -w and -d list, rewrite
+    and diff the files whose layout differs"""
+    if not args.paths:
+        if args.write:
+            print("Error: cannot use -w with standard input", file=sys.stderr)
+            sys.exit(1)
+        ok = format_file('<standard input>', sys.stdin.read(), args)
+    else:
+        files = []
+        for path in map(Path, args.paths):
+            files.extend(sorted(path.rglob('*.gox')) if path.is_dir() else [path])
+        ok = True
+        for path in files:
+            try:
+                source = path.read_text(encoding='utf-8')
+            except OSError as e:
+                print(f"Error: {e}", file=sys.stderr)
+                ok = False
+                continue
+            ok = format_file(str(path), source, args, path) and ok
+    if not ok:
+        sys.exit(1)
+
+def format_file(name: str, source: str, args, path: Optional[Path] = None) -> bool:
+    """Formats one source as the flags of fmt say; False when it cannot be formatted (the error is printed)"""
+    try:
+        formatted = format_source(source, name)
+    except (LexerError, ParseError, PrintError, DirectiveError) as e:
+        with contextlib.redirect_stdout(sys.stderr):
+            print_error(e, name)
+        return False
+    if not (args.list or args.write or args.diff):
+        sys.stdout.write(formatted)
+        return True
+    if formatted == source:
+        return True
+    if args.list:
+        print(name)
+    if args.write:
+        path.write_text(formatted, encoding='utf-8')
+    if args.diff:
+        sys.stdout.writelines(difflib.unified_diff(source.splitlines(keepends=True),
+                                                   formatted.splitlines(keepends=True), f'{name}.orig', name))
+    return True
+
+def cmd_ast(args):
+    """Print the AST of a file, as parsed or as the transpiler expands it before emitting Go"""
+    try:
+        with open(args.input, 'r', encoding='utf-8') as f:
+            program = Parser(Lexer(f, default_tags(parse_tags(args.tags)), args.input).stream(), args.input).parse()
+        if args.expanded:
+            transpiler = Transpiler(plugins=load_plugins(args.plugin, Path.cwd()))
+            transpiler.source_file = args.input
+            transpiler.transpile(program)
+    except (OSError, LexerError, ParseError, DirectiveError, TranspilerError, PluginError) as e:
+        with contextlib.redirect_stdout(sys.stderr):
+            print_error(e, args.input)
+        sys.exit(1)
+    sys.stdout.write(dump_json(program) if args.json else dump_tree(program))
 
 def cmd_transpile(args):
     """Transpile a single file"""
@@ -53,6 +206,30 @@ def cmd_transpile(args):
         sys.argv.extend(['-o', args.output])
     if args.verbose:
         sys.argv.append('-v')
+    if args.embed_pointers:
+        sys.argv.append('--embed-pointers')
+    if args.receiver:
+        sys.argv.extend(['--receiver', args.receiver])
+    if args.inline_accessors:
+        sys.argv.append('--inline-accessors')
+    if args.warnings:
+        sys.argv.extend(['--warnings', args.warnings])
+    if args.release:
+        sys.argv.append('--release')
+    if args.tags:
+        sys.argv.extend(['--tags', args.tags])
+    if args.line_directives:
+        sys.argv.append('--line-directives')
+    if args.source_map:
+        sys.argv.append('--source-map')
+    if args.no_verify:
+        sys.argv.append('--no-verify')
+    if args.format:
+        sys.argv.extend(['--format', args.format])
+    if args.diagnostics_format:
+        sys.argv.extend(['--diagnostics-format', args.diagnostics_format])
+    for plugin in args.plugin:
+        sys.argv.extend(['--plugin', plugin])
     
     transpile_single_file()
 
@@ -61,18 +238,17 @@ def cmd_run(args):
     import subprocess
     
     # First build
-    cmd_build(args)
+    manager = cmd_build(args)
     
     # Then run
-    project_root = Path(args.directory) if args.directory else Path.cwd()
-    manager = ProjectManager(project_root)
-    config = manager.load_config()
+    project_root = manager.project_root
+    config = manager.config
     
     build_dir = project_root / config.output_dir
     main_file = None
     
     # Search for main file
-    for go_file in build_dir.rglob("*.go"):
+    for go_file in sorted(build_dir.rglob("*.go")):
         with open(go_file, 'r') as f:
             content = f.read()
             if 'package main' in content and 'func main()' in content:
@@ -84,12 +260,14 @@ def cmd_run(args):
         sys.exit(1)
     
     print(f"Running {main_file.relative_to(project_root)}...")
-    try:
-        result = subprocess.run(['go', 'run', main_file.name], 
-                              cwd=main_file.parent, 
-                              check=True)
-    except subprocess.CalledProcessError as e:
-        print(f"Execution error: {e}")
+    tags = parse_tags(args.tags)
+    go_command = ['go', 'run'] + (['-tags', ','.join(tags)] if tags else []) + [main_file.name]
+    # Compile errors and panics point at the generated Go; report them at the go-plus source
+    process = subprocess.Popen(go_command, cwd=main_file.parent, stderr=subprocess.PIPE, text=True)
+    for line in process.stderr:
+        sys.stderr.write(manager.locate_go_output(line, main_file.parent))
+    if process.wait() != 0:
+        print(f"Execution error: {subprocess.CalledProcessError(process.returncode, go_command)}")
         sys.exit(1)
 
 def main():
@@ -110,8 +288,27 @@ Examples:
   # Transpile single file
   goe2go transpile input.gox -o output.go
   
+  # Build with tags for //goplus:build and #if
+  goe2go build --tags debug,linux
+  
+  # Lint project (or single files)
+  goe2go lint
+  goe2go lint input.gox --warnings GP2004=error
+  
+  # Show the files whose layout is not canonical, and the changes formatting makes
+  goe2go fmt -l -d src
+  
+  # Show the AST of a file, as parsed or as expanded for code generation
+  goe2go ast input.gox --expanded --json
+  
+  # Warnings and errors for CI systems and editors
+  goe2go build --diagnostics-format sarif > goplus.sarif
+  
   # Show project information
   goe2go info
+  
+  # Update the symbol index (goe2go-index.json) without building
+  goe2go index
         """
     )
     
@@ -127,13 +324,31 @@ Examples:
     build_parser = subparsers.add_parser('build', help='Build the project')
     build_parser.add_argument('-d', '--directory', help='Project directory')
     build_parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
+    build_parser.add_argument('--tags', help=TAGS_HELP)
+    build_parser.add_argument('--line-directives', action='store_true', help=LINE_DIRECTIVES_HELP)
+    build_parser.add_argument('--source-map', action='store_true', help=SOURCE_MAP_HELP)
+    build_parser.add_argument('--no-verify', action='store_true', help=NO_VERIFY_HELP)
+    build_parser.add_argument('--format', choices=[*FORMATTERS, 'none'], help=FORMAT_HELP)
+    build_parser.add_argument('--release', action='store_true', help=RELEASE_HELP)
+    build_parser.add_argument('--no-cache', action='store_true', help=NO_CACHE_HELP)
+    build_parser.add_argument('-p', '--jobs', type=job_count, default=1, metavar='N', help=JOBS_HELP)
+    build_parser.add_argument('--diagnostics-format', choices=DIAGNOSTICS_FORMATS, default='text',
+                              help=DIAGNOSTICS_FORMAT_HELP)
     build_parser.set_defaults(func=cmd_build)
     
     # Run command
     run_parser = subparsers.add_parser('run', help='Build and run the project')
     run_parser.add_argument('-d', '--directory', help='Project directory')
     run_parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
-    run_parser.set_defaults(func=cmd_run)
+    run_parser.add_argument('--tags', help=TAGS_HELP)
+    run_parser.add_argument('--line-directives', action='store_true', help=LINE_DIRECTIVES_HELP)
+    run_parser.add_argument('--source-map', action='store_true', help=SOURCE_MAP_HELP)
+    run_parser.add_argument('--no-verify', action='store_true', help=NO_VERIFY_HELP)
+    run_parser.add_argument('--format', choices=[*FORMATTERS, 'none'], help=FORMAT_HELP)
+    run_parser.add_argument('--release', action='store_true', help=RELEASE_HELP)
+    run_parser.add_argument('--no-cache', action='store_true', help=NO_CACHE_HELP)
+    run_parser.add_argument('-p', '--jobs', type=job_count, default=1, metavar='N', help=JOBS_HELP)
+    run_parser.set_defaults(func=cmd_run, diagnostics_format='text')
     
     # Info command
     info_parser = subparsers.add_parser('info', help='Show project information')
@@ -141,11 +356,66 @@ Examples:
     info_parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
     info_parser.set_defaults(func=cmd_info)
     
+    # Index command
+    index_parser = subparsers.add_parser('index', help='Update the exported symbol index for editors and tools')
+    index_parser.add_argument('-d', '--directory', help='Project directory')
+    index_parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
+    index_parser.add_argument('--tags', help=TAGS_HELP)
+    index_parser.set_defaults(func=cmd_index)
+    
+    # Lint command
+    lint_parser = subparsers.add_parser('lint', help='Report go-plus constructs that Go linters cannot see')
+    lint_parser.add_argument('files', nargs='*', help='Go-Extended files to lint (default: the whole project)')
+    lint_parser.add_argument('-d', '--directory', help='Project directory')
+    lint_parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
+    lint_parser.add_argument('--warnings', help=WARNINGS_HELP)
+    lint_parser.add_argument('--tags', help=TAGS_HELP)
+    lint_parser.add_argument('--diagnostics-format', choices=DIAGNOSTICS_FORMATS, default='text',
+                             help=DIAGNOSTICS_FORMAT_HELP)
+    lint_parser.set_defaults(func=cmd_lint)
+    
+    # Fmt command
+    fmt_parser = subparsers.add_parser('fmt', help='Format go-plus source in the canonical layout')
+    fmt_parser.add_argument('paths', nargs='*',
+                            help='Files, and directories searched for .gox files (default: standard input)')
+    fmt_parser.add_argument('-l', '--list', action='store_true',
+                            help='List the files whose formatting differs instead of printing them')
+    fmt_parser.add_argument('-w', '--write', action='store_true',
+                            help='Write the formatted source back to the files whose formatting differs')
+    fmt_parser.add_argument('-d', '--diff', action='store_true',
+                            help='Print the diffs of the files whose formatting differs')
+    fmt_parser.set_defaults(func=cmd_fmt)
+    
+    # Ast command
+    ast_parser = subparsers.add_parser('ast', help='Print the AST of a file, to debug the transpiler or a program')
+    ast_parser.add_argument('input', help='Go-Extended file')
+    ast_parser.add_argument('--json', action='store_true', help='Print the AST as JSON instead of an indented tree')
+    ast_parser.add_argument('--expanded', action='store_true',
+                            help='Print the AST the Go is emitted from: classes with their generated members, '
+                                 'nested classes hoisted, plugins applied')
+    ast_parser.add_argument('--tags', help=TAGS_HELP)
+    ast_parser.add_argument('--plugin', action='append', default=[], metavar='MODULE', help=PLUGIN_HELP)
+    ast_parser.set_defaults(func=cmd_ast)
+    
     # Transpile command (single file)
     transpile_parser = subparsers.add_parser('transpile', help='Transpile single file')
     transpile_parser.add_argument('input', help='Input Go-Extended file')
     transpile_parser.add_argument('-o', '--output', help='Output Go file')
     transpile_parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
+    transpile_parser.add_argument('--embed-pointers', action='store_true',
+                                  help='Embed base classes by pointer (*Person) instead of by value')
+    transpile_parser.add_argument('--receiver', choices=RECEIVER_STYLES, help=RECEIVER_HELP)
+    transpile_parser.add_argument('--inline-accessors', action='store_true', help=INLINE_ACCESSORS_HELP)
+    transpile_parser.add_argument('--warnings', help=WARNINGS_HELP)
+    transpile_parser.add_argument('--tags', help=TAGS_HELP)
+    transpile_parser.add_argument('--line-directives', action='store_true', help=LINE_DIRECTIVES_HELP)
+    transpile_parser.add_argument('--source-map', action='store_true', help=SOURCE_MAP_HELP)
+    transpile_parser.add_argument('--no-verify', action='store_true', help=NO_VERIFY_HELP)
+    transpile_parser.add_argument('--format', choices=[*FORMATTERS, 'none'], help=FORMAT_HELP)
+    transpile_parser.add_argument('--release', action='store_true', help=RELEASE_HELP)
+    transpile_parser.add_argument('--diagnostics-format', choices=DIAGNOSTICS_FORMATS,
+                                  help=DIAGNOSTICS_FORMAT_HELP)
+    transpile_parser.add_argument('--plugin', action='append', default=[], metavar='MODULE', help=PLUGIN_HELP)
     transpile_parser.set_defaults(func=cmd_transpile)
     
     args = parser.parse_args()
@@ -0,0 +1,27 @@
+"""
+Go formatting for Go-Extended
+Runs the generated Go through gofmt (or gofumpt) so builds of the same input are byte-identical
+"""
+
+import shutil
+import subprocess
+from typing import List, Tuple
+from sourcemap import Origin, realign
+
+# Formatters by name; 'none' writes the generator's layout as is
+FORMATTERS = {'gofmt': ['gofmt'], 'gofumpt': ['gofumpt']}
+
+def formatter_available(formatter: str) -> bool:
+    """Whether the formatter's command is on the PATH"""
+    return formatter in FORMATTERS and shutil.which(FORMATTERS[formatter][0]) is not None
+
+def format_code(go_code: str, origins: List[Origin], formatter: str = 'gofmt') -> Tuple[str, List[Origin]]:
+    """Formats generated code, carrying the go-plus origins of its lines over to the formatted lines. Code the
+    formatter cannot parse is returned as it is, for the compile check to report"""
+    if formatter == 'none' or not formatter_available(formatter):
+        return go_code, origins
+    result = subprocess.run(FORMATTERS[formatter], input=go_code, capture_output=True, text=True)
+    if result.returncode != 0:
+        return go_code, origins
+    formatted = result.stdout
+    return formatted, realign(go_code, formatted, origins)
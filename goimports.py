@@ -0,0 +1,67 @@
+"""
+Import management for Go-Extended
+Decides which imports a generated Go file needs from the code actually emitted
+"""
+
+import re
+from typing import Optional, Set
+
+# Comments and string, raw string and rune literals of Go code, in the order the Go scanner meets them
+GO_LITERAL = re.compile(r'//[^\n]*|/\*.*?\*/|"(?:\\.|[^"\\\n])*"|`[^`]*`|\'(?:\\.|[^\'\\\n])*\'', re.DOTALL)
+
+# Operand of a selector (fmt in fmt.Println); one following a dot is a field or method instead
+SELECTOR_OPERAND = re.compile(r'(?<![\w.])([A-Za-z_]\w*)\s*\.')
+
+# Identifier that is not a field or method name (x in x.y, not y)
+UNSELECTED_IDENTIFIER = re.compile(r'(?<![\w.])[A-Za-z_]\w*')
+
+# Last element of a versioned import path (github.com/x/y/v2 is package y)
+MAJOR_VERSION = re.compile(r'^v\d+$')
+
+def package_name(path: str, alias: Optional[str] = None) -> str:
+    """Name an import is referred to by in a file: its alias, else its package name as Go guesses it from the
+    path (the last element without a major version suffix or a .vN extension: gopkg.in/yaml.v3 is yaml)"""
+    if alias:
+        return alias
+    elements = path.strip('"').split('/')
+    name = elements[-1]
+    if MAJOR_VERSION.match(name) and len(elements) > 1:
+        name = elements[-2]
+    return re.sub(r'\.v\d+$', '', name).replace('-', '_')
+
+def referenced_names(go_code: str) -> Set[str]:
+    """Identifiers Go code selects members of (package names among them), outside comments and literals"""
+    code = GO_LITERAL.sub(lambda m: ' ' if m.group().startswith(('//', '/*')) else '""', go_code)
+    return set(SELECTOR_OPERAND.findall(code))
+
+def identifiers(go_code: str) -> Set[str]:
+    """Identifiers Go code refers to other than as field or method names, outside comments and literals"""
+    return set(UNSELECTED_IDENTIFIER.findall(GO_LITERAL.sub(' ', go_code)))
+
+def import_used(path: str, alias: Optional[str], names: Set[str]) -> bool:
+    """Whether an import is used by code referring to names; blank and dot imports always are (they are imported
+    for their side effects or without a qualifier)"""
+    if alias in ('_', '.'):
+        return True
+    return package_name(path, alias) in names
+
+def qualify(go_code: str, names: Set[str], package: str) -> str:
+    """Refers to names declared by another package through it (NewException -> exceptions.NewException);
+    selectors (x.NewException), comments and literals are left as they are"""
+    return _replace_names(go_code, names, rf'{package}.\1')
+
+def rename(go_code: str, old: str, new: str) -> str:
+    """Renames an identifier (this -> p), leaving selectors (x.this), comments and literals as they are"""
+    return _replace_names(go_code, {old}, new)
+
+def _replace_names(go_code: str, names: Set[str], replacement: str) -> str:
+    """Replaces references to names outside selectors, comments and literals"""
+    reference = re.compile(r'(?<![\w.])(' + '|'.join(re.escape(name) for name in sorted(names)) + r')\b')
+    parts = []
+    pos = 0
+    for literal in GO_LITERAL.finditer(go_code):
+        parts.append(reference.sub(replacement, go_code[pos:literal.start()]))
+        parts.append(literal.group())
+        pos = literal.end()
+    parts.append(reference.sub(replacement, go_code[pos:]))
+    return ''.join(parts)
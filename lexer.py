@@ -3,197 +3,546 @@ Lexer for Go-Extended
 Converts source code into tokens
 """
 
+import io
 import re
-from typing import List, Optional
-from tokens import Token, TokenType, KEYWORDS, TWO_CHAR_OPERATORS, ONE_CHAR_OPERATORS
+import sys
+from typing import Iterator, List, Optional, Pattern, Set, TextIO, Tuple, Union
+from tokens import Token, TokenType, KEYWORDS, THREE_CHAR_OPERATORS, TWO_CHAR_OPERATORS, ONE_CHAR_OPERATORS
+from directives import DirectiveError, default_tags, preprocess_lines
+from errors import CompileError, error_diagnostic, position
 
-class LexerError(Exception):
+class LexerError(CompileError):
     """Lexer error"""
-    pass
+    code = 'GP0001'
+
+# Single-character escape sequences (Go's, plus \$ for a literal $ before { in templates)
+ESCAPE_CHARS = {
+    'n': '\n',
+    't': '\t',
+    'r': '\r',
+    'a': '\a',
+    'b': '\b',
+    'f': '\f',
+    'v': '\v',
+    '\\': '\\',
+    '"': '"',
+    "'": "'",
+    '$': '$',
+}
+
+# Runs of characters read at once: whitespace, identifier characters (isalnum or _), a line comment, raw string text
+WHITESPACE = re.compile(r'[ \t\r]*')
+WORD = re.compile(r'\w*')
+LINE = re.compile(r'[^\n]*')
+RAW_TEXT = re.compile(r'[^`]*')
+
+# Hex escapes and their digit counts
+HEX_ESCAPES = {'x': 2, 'u': 4, 'U': 8}
+
+def decode_escape(text: str, i: int) -> Tuple[str, int]:
+    """Decodes the escape sequence starting at the backslash text[i]; returns the character and the index
+    just past it. \\xHH and \\NNN (octal) are bytes: values from 0x80 on are kept as the surrogate-escaped
+    characters U+DC80..U+DCFF, so the encoder writes them back as the same bytes."""
+    char = text[i + 1] if i + 1 < len(text) else ''
+    if char in ESCAPE_CHARS:
+        return ESCAPE_CHARS[char], i + 2
+    
+    if char in HEX_ESCAPES or char in '01234567' and char:
+        is_octal = char not in HEX_ESCAPES
+        start = i + 1 if is_octal else i + 2
+        width = 3 if is_octal else HEX_ESCAPES[char]
+        digits = text[start:start + width]
+        if len(digits) != width or not all(d in ('01234567' if is_octal else '0123456789abcdefABCDEF') for d in digits):
+            raise LexerError(f"Invalid escape \\{text[i + 1:start + width]}: expected {width} "
+                             f"{'octal' if is_octal else 'hex'} digits")
+        code = int(digits, 8 if is_octal else 16)
+        if is_octal or char == 'x':
+            if code > 0xff:
+                raise LexerError(f"Invalid escape \\{text[i + 1:start + width]}: a byte must be at most 255")
+            return (chr(code) if code < 0x80 else chr(0xDC00 + code)), start + width
+        if code > 0x10FFFF or 0xD800 <= code <= 0xDFFF:
+            raise LexerError(f"Invalid escape \\{text[i + 1:start + width]}: not a Unicode code point")
+        return chr(code), start + width
+    
+    raise LexerError(f"Unknown escape sequence \\{char} (write \\\\ for a backslash, or use a raw string)")
+
+def decode_string(text: str) -> str:
+    """Decodes the escape sequences of a string's text"""
+    value = ''
+    i = 0
+    while i < len(text):
+        if text[i] == '\\':
+            char, i = decode_escape(text, i)
+            value += char
+        else:
+            value += text[i]
+            i += 1
+    return value
+
+# Integer prefixes and their digits
+NUMBER_PREFIXES = {
+    '0x': '0123456789abcdef',
+    '0o': '01234567',
+    '0b': '01',
+}
+
+# Numeric literal type suffixes: 255u8 -> uint8(255)
+NUMBER_SUFFIXES = {
+    'i8': 'int8', 'i16': 'int16', 'i32': 'int32', 'i64': 'int64',
+    'u8': 'uint8', 'u16': 'uint16', 'u32': 'uint32', 'u64': 'uint64',
+    'f32': 'float32', 'f64': 'float64',
+}
+
+def split_number(text: str) -> Tuple[str, Optional[str]]:
+    """Splits a normalized number token into its digits and Go suffix type ('1.5f32' -> ('1.5', 'float32'))"""
+    for suffix, go_type in NUMBER_SUFFIXES.items():
+        # In hex, a trailing f32/f64 is digits (0xff32), not a suffix
+        if text.endswith(suffix) and text[:-len(suffix)] and not (text.startswith('0x') and suffix[0] == 'f'):
+            return text[:-len(suffix)], go_type
+    return text, None
+
+def interpolation_end(text: Union[str, 'SourceReader'], start: int) -> int:
+    """Returns the index just past the '}' closing the ${ at text[start], or -1 (nested braces and strings skipped)"""
+    depth = 0
+    quote = None
+    i = start + 2
+    while True:
+        try:
+            char = text[i]
+        except IndexError:
+            return -1
+        if quote:
+            if char == '\\':
+                i += 1
+            elif char == quote:
+                quote = None
+        elif char in '"\'`':
+            quote = char
+        elif char == '{':
+            depth += 1
+        elif char == '}':
+            if depth == 0:
+                return i + 1
+            depth -= 1
+        i += 1
+
+def split_template(raw: str) -> List[Tuple[str, str]]:
+    """Splits the raw text of a template string into ('text', decoded text) and ('expr', source) parts"""
+    parts = []
+    text = ''
+    i = 0
+    while i < len(raw):
+        if raw[i] == '\\':
+            char, i = decode_escape(raw, i)
+            text += char
+        elif raw.startswith('${', i):
+            end = interpolation_end(raw, i)
+            if text:
+                parts.append(('text', text))
+                text = ''
+            parts.append(('expr', raw[i + 2:end - 1]))
+            i = end
+        else:
+            text += raw[i]
+            i += 1
+    if text:
+        parts.append(('text', text))
+    return parts
+
+class SourceReader:
+    """Buffered view of a source: its text is read, and its #if blocks resolved, a chunk at a time as the lexer
+    needs it, and the text already tokenized is dropped, so a large file is never held whole"""
+    
+    CHUNK = 1 << 16  # characters read at a time, and kept behind the lexer at most
+    
+    def __init__(self, source: Union[str, TextIO], tags: Set[str]):
+        self.lines = preprocess_lines(io.StringIO(source) if isinstance(source, str) else source, tags)
+        self.buffer = ''
+        self.start = 0  # position of buffer[0] in the source
+        self.done = False  # the whole source is read
+    
+    def fill(self, pos: int) -> bool:
+        """Reads the source up to the character at pos; False when it ends before"""
+        while pos >= self.start + len(self.buffer):
+            if self.done:
+                return False
+            chunk, size = [], 0
+            try:
+                for line in self.lines:
+                    chunk.append(line)
+                    size += len(line)
+                    if size >= self.CHUNK:
+                        break
+                else:
+                    self.done = True
+            except DirectiveError as e:
+                raise LexerError(str(e), *e.diagnostics) from None
+            self.buffer += ''.join(chunk)
+        return True
+    
+    def char(self, pos: int) -> Optional[str]:
+        """The character at pos, or None past the end"""
+        return self.buffer[pos - self.start] if self.fill(pos) else None
+    
+    def __getitem__(self, pos: int) -> str:
+        """The character at pos; past the end, IndexError like a string"""
+        if not self.fill(pos):
+            raise IndexError(pos)
+        return self.buffer[pos - self.start]
+    
+    def startswith(self, text: str, pos: int) -> bool:
+        """Whether the source continues with text at pos"""
+        self.fill(pos + len(text) - 1)
+        return self.buffer.startswith(text, pos - self.start)
+    
+    def match(self, pattern: Pattern, pos: int) -> int:
+        """End of the match of a pattern (one that always matches) at pos, read further while it reaches the end of
+        what is buffered"""
+        self.fill(pos)
+        end = pattern.match(self.buffer, pos - self.start).end()
+        while end == len(self.buffer) and self.fill(self.start + end):
+            end = pattern.match(self.buffer, pos - self.start).end()
+        return self.start + end
+    
+    def end(self) -> int:
+        """Position just past the text read so far (the end of the source once char() returned None)"""
+        return self.start + len(self.buffer)
+    
+    def slice(self, start: int, end: int) -> str:
+        """The text from start to end (exclusive), cut short by the end of the source"""
+        self.fill(end - 1)
+        return self.buffer[start - self.start:end - self.start]
+    
+    def release(self, pos: int) -> None:
+        """Drops the text before pos, which the lexer is done with (once there is a chunk of it)"""
+        if pos - self.start >= self.CHUNK:
+            self.buffer = self.buffer[pos - self.start:]
+            self.start = pos
 
 class Lexer:
-    def __init__(self, source: str):
-        self.source = source
+    def __init__(self, source: Union[str, TextIO], tags: Optional[Set[str]] = None, file: Optional[str] = None):
+        self.file = file  # path of the source, leading error messages when known
+        self.tags = tags if tags is not None else default_tags()  # build tags for #if blocks
+        self.reader = SourceReader(source, self.tags)  # the source text, or an open file read as tokens are needed
         self.pos = 0
         self.line = 1
         self.column = 1
-        self.tokens: List[Token] = []
     
     def current_char(self) -> Optional[str]:
         """Returns the current character or None if end of file"""
-        if self.pos >= len(self.source):
-            return None
-        return self.source[self.pos]
+        return self.reader.char(self.pos)
     
     def peek_char(self, offset: int = 1) -> Optional[str]:
         """Peeks at the next character without advancing"""
-        peek_pos = self.pos + offset
-        if peek_pos >= len(self.source):
-            return None
-        return self.source[peek_pos]
+        return self.reader.char(self.pos + offset)
     
     def advance(self) -> None:
         """Advances to the next character"""
-        if self.pos < len(self.source):
-            if self.source[self.pos] == '\n':
-                self.line += 1
-                self.column = 1
-            else:
-                self.column += 1
-            self.pos += 1
+        if self.current_char() is not None:
+            self.take(self.pos + 1)
+    
+    def take(self, end: int) -> str:
+        """Advances to end, returning the text passed over"""
+        text = self.reader.slice(self.pos, end)
+        newlines = text.count('\n')
+        if newlines:
+            self.line += newlines
+            self.column = len(text) - text.rfind('\n')
+        else:
+            self.column += len(text)
+        self.pos += len(text)
+        return text
+    
+    def read_run(self, pattern: Pattern) -> str:
+        """Reads the run of characters a pattern matches from here on"""
+        return self.take(self.reader.match(pattern, self.pos))
     
     def skip_whitespace(self) -> None:
         """Skips whitespace (except newlines)"""
-        while self.current_char() and self.current_char() in ' \t\r':
-            self.advance()
+        self.read_run(WHITESPACE)
+    
+    def string_end(self, start: int, quote: str) -> int:
+        """Position of the quote closing the string whose text starts at start (escaped quotes skipped); -1 when
+        the source ends first"""
+        i = start
+        while not self.reader.startswith(quote, i):
+            char = self.reader.char(i)
+            if char is None:
+                return -1
+            i += 2 if char == '\\' and self.reader.char(i + 1) is not None else 1
+        return i
     
     def read_string(self, quote_char: str) -> str:
-        """Reads a string literal"""
-        value = ''
-        self.advance()  # Skip the opening quote
+        """Reads a string literal, decoding its escapes"""
+        start_line, start_column = self.line, self.column
+        end = self.string_end(self.pos + 1, quote_char)
+        if end < 0:
+            self.take(self.reader.end())
+            raise self.error("Unclosed string", start_line, start_column)
         
-        while self.current_char() and self.current_char() != quote_char:
-            if self.current_char() == '\\':
-                self.advance()
-                if self.current_char():
-                    # Basic escape sequences
-                    escape_chars = {
-                        'n': '\n',
-                        't': '\t',
-                        'r': '\r',
-                        '\\': '\\',
-                        '"': '"',
-                        "'": "'",
-                    }
-                    value += escape_chars.get(self.current_char(), self.current_char())
-                    self.advance()
-            else:
-                value += self.current_char()
-                self.advance()
+        text = self.take(end + 1)[1:-1]
+        try:
+            return decode_string(text)
+        except LexerError as e:
+            raise self.error(f"{e} in string", start_line, start_column)
+    
+    def read_raw_string(self) -> str:
+        """Reads a backtick raw string: no escapes, may span lines"""
+        start_line, start_column = self.line, self.column
+        self.advance()  # Skip the opening backtick
+        value = self.read_run(RAW_TEXT)
         
         if not self.current_char():
-            raise LexerError(f"Unclosed string at line {self.line}")
+            raise self.error("Unclosed raw string", start_line, start_column)
         
-        self.advance()  # Skip the closing quote
+        self.advance()  # Skip the closing backtick
         return value
     
-    def read_number(self) -> str:
-        """Reads a number (int or float)"""
-        value = ''
-        has_dot = False
+    def read_text_block(self) -> str:
+        """Reads a triple-quoted multi-line string. A line break right after the opening quotes and
+        the indentation shared by all lines (the closing line included) are not part of the value;
+        escapes are decoded as in other strings."""
+        start_line, start_column = self.line, self.column
+        end = self.string_end(self.pos + 3, '"""')
+        if end < 0:
+            raise self.error("Unclosed triple-quoted string", start_line, start_column)
+        raw = self.take(end + 3)[3:-3]
         
-        while self.current_char() and (self.current_char().isdigit() or self.current_char() == '.'):
-            if self.current_char() == '.':
-                if has_dot:
-                    break  # Second dot, stop reading
-                has_dot = True
-            value += self.current_char()
-            self.advance()
+        if raw.startswith('\n'):
+            raw = raw[1:]
+        lines = raw.split('\n')
+        closing_alone = len(lines) > 1 and not lines[-1].strip()
+        indents = [len(line) - len(line.lstrip(' \t')) for line in lines if line.strip()]
+        if closing_alone:
+            indents.append(len(lines[-1]))
+        margin = min(indents, default=0)
+        lines = [line[margin:] for line in lines]
+        if closing_alone:
+            lines.pop()
         
-        return value
+        try:
+            return decode_string('\n'.join(lines))
+        except LexerError as e:
+            raise self.error(f"{e} in string", start_line, start_column)
     
-    def read_identifier(self) -> str:
-        """Reads an identifier or keyword"""
-        value = ''
+    def is_template_string(self) -> bool:
+        """Checks whether the double-quoted string starting here contains an unescaped ${ interpolation"""
+        i = self.pos + 1
+        while (char := self.reader.char(i)) is not None and char != '"':
+            if char == '\\':
+                i += 2
+                continue
+            if char == '$' and self.reader.char(i + 1) == '{':
+                return True
+            i += 1
+        return False
+    
+    def read_template_string(self) -> str:
+        """Reads a string with ${...} interpolations, keeping its raw text (escapes included)"""
+        start_line, start_column = self.line, self.column
+        i = self.pos + 1
+        while (char := self.reader.char(i)) is not None and char != '"':
+            if char == '\\':
+                i += 2 if self.reader.char(i + 1) is not None else 1
+            elif char == '$' and self.reader.char(i + 1) == '{':
+                end = interpolation_end(self.reader, i)
+                if end < 0:
+                    self.take(i)
+                    raise self.error("Unclosed interpolation", self.line, self.column)
+                i = end
+            else:
+                i += 1
+        
+        if char is None:
+            raise self.error("Unclosed string", start_line, start_column)
+        
+        raw = self.take(i + 1)[1:-1]
+        try:
+            split_template(raw)  # reports bad escapes here, where the line is known
+        except LexerError as e:
+            raise self.error(f"{e} in string", start_line, start_column)
+        return raw
+    
+    def read_number(self) -> str:
+        """Reads a number: decimal (1_000_000, 2.5, 1e9) or 0x/0o/0b integer, with an optional type suffix
+        (255u8, 1.5f32). The result is normalized: separators dropped, prefix and exponent lowercased."""
+        start_line, start_column = self.line, self.column
+        text = ''
         
-        while (self.current_char() and 
-               (self.current_char().isalnum() or self.current_char() == '_')):
-            value += self.current_char()
+        def read_digits(digits: str) -> None:
+            nonlocal text
+            while self.current_char() and (self.current_char().lower() in digits or self.current_char() == '_'):
+                text += self.current_char()
+                self.advance()
+        
+        prefix = (self.current_char() + (self.peek_char() or '')).lower()
+        if prefix in NUMBER_PREFIXES:
+            text = prefix
+            self.advance()
             self.advance()
+            read_digits(NUMBER_PREFIXES[prefix])
+            pattern = r'0[xob]_?[0-9a-f]+(_[0-9a-f]+)*'
+        else:
+            read_digits('0123456789')
+            if self.current_char() == '.' and self.peek_char() and self.peek_char().isdigit():
+                text += '.'
+                self.advance()
+                read_digits('0123456789')
+            if self.current_char() in ('e', 'E') and (self.peek_char() or '').isdigit() or \
+                    self.current_char() in ('e', 'E') and self.peek_char() in ('+', '-') and \
+                    (self.peek_char(2) or '').isdigit():
+                text += 'e'
+                self.advance()
+                if self.current_char() in ('+', '-'):
+                    text += self.current_char()
+                    self.advance()
+                read_digits('0123456789')
+            pattern = r'\d+(_\d+)*(\.\d+(_\d+)*)?(e[+-]?\d+(_\d+)*)?'
         
-        return value
+        if not re.fullmatch(pattern, text.lower()):
+            raise self.error(f"Invalid numeric literal {text}", start_line, start_column, read=True)
+        text = text.lower().replace('_', '')
+        
+        suffix = ''
+        while self.current_char() and (self.current_char().isalnum() or self.current_char() == '_'):
+            suffix += self.current_char()
+            self.advance()
+        if suffix and suffix not in NUMBER_SUFFIXES:
+            raise self.error(f"Invalid suffix '{suffix}' on numeric literal {text} "
+                             f"(expected one of {', '.join(NUMBER_SUFFIXES)})", start_line, start_column, read=True)
+        return text + suffix
+    
+    def read_identifier(self) -> str:
+        """Reads an identifier or keyword, interned: a large file repeats the same few names"""
+        return sys.intern(self.read_run(WORD))
     
     def read_comment(self) -> str:
         """Reads a comment"""
-        value = ''
-        
-        if self.current_char() == '/' and self.peek_char() == '/':
+        if self.peek_char() == '/':
             # Line comment
-            while self.current_char() and self.current_char() != '\n':
-                value += self.current_char()
-                self.advance()
-        elif self.current_char() == '/' and self.peek_char() == '*':
-            # Block comment
-            self.advance()  # /
-            self.advance()  # *
-            value = '/*'
-            
-            while self.current_char():
-                if self.current_char() == '*' and self.peek_char() == '/':
-                    value += '*/'
-                    self.advance()  # *
-                    self.advance()  # /
-                    break
-                value += self.current_char()
-                self.advance()
-            else:
-                raise LexerError(f"Unclosed block comment at line {self.line}")
+            return self.read_run(LINE)
         
-        return value
+        # Block comment
+        start_line, start_column = self.line, self.column
+        end = self.pos + 2
+        while not self.reader.startswith('*/', end):
+            if self.reader.char(end) is None:
+                self.take(end)
+                raise self.error("Unclosed block comment", start_line, start_column)
+            end += 1
+        return self.take(end + 2)
     
     def tokenize(self) -> List[Token]:
+        """Tokenizes the source code; errors are led by the file name when it is known"""
+        return list(self.stream())
+    
+    def stream(self) -> Iterator[Token]:
+        """Tokenizes the source code as the tokens are consumed, reading no more of it than they need; errors are
+        led by the file name when it is known"""
+        try:
+            yield from self.scan()
+        except LexerError as e:
+            for diagnostic in e.diagnostics:
+                if diagnostic.origin:
+                    diagnostic.origin.file = self.file  # a directive's, read before the lexer knows its file
+            if self.file:
+                raise LexerError(f'{self.file}: {e}', *e.diagnostics) from None
+            raise
+    
+    def error(self, message: str, line: int, column: int, read: bool = False) -> LexerError:
+        """The error of a token starting at line:column: of its character there, or of all the text read since
+        (a literal that is not one)"""
+        end_line, end_column = (self.line, self.column) if read else (line, column + 1)
+        text = f"{message} at line {line}, column {column}"
+        return LexerError(text, error_diagnostic(LexerError.code, message,
+                                                 position(self.file, line, column, end_line, end_column), text=text))
+    
+    def token(self, token_type: TokenType, value: str, line: int, column: int) -> Token:
+        """A token read from line:column to the current position"""
+        return Token(token_type, value, line, column, self.line, self.column)
+    
+    def scan(self) -> Iterator[Token]:
         """Tokenizes the source code"""
-        self.tokens = []
-        
-        while self.current_char():
+        while char := self.current_char():
+            self.reader.release(self.pos)
             start_line = self.line
             start_column = self.column
             
             # Skip whitespace
-            if self.current_char() in ' \t\r':
+            if char in ' \t\r':
                 self.skip_whitespace()
                 continue
             
             # Newline
-            if self.current_char() == '\n':
-                self.tokens.append(Token(TokenType.NEWLINE, '\\n', start_line, start_column))
+            if char == '\n':
                 self.advance()
+                yield self.token(TokenType.NEWLINE, '\\n', start_line, start_column)
                 continue
             
             # Comments
-            if self.current_char() == '/' and self.peek_char() in ['/', '*']:
+            if char == '/' and self.peek_char() in ['/', '*']:
                 comment = self.read_comment()
-                self.tokens.append(Token(TokenType.COMMENT, comment, start_line, start_column))
+                yield self.token(TokenType.COMMENT, comment, start_line, start_column)
                 continue
             
             # Strings
-            if self.current_char() in ['"', "'"]:
-                quote_char = self.current_char()
-                string_value = self.read_string(quote_char)
-                self.tokens.append(Token(TokenType.STRING, string_value, start_line, start_column))
+            if self.reader.startswith('"""', self.pos):
+                text = self.read_text_block()
+                yield self.token(TokenType.RAW_STRING, text, start_line, start_column)
+                continue
+            if char == '`':
+                raw = self.read_raw_string()
+                yield self.token(TokenType.RAW_STRING, raw, start_line, start_column)
+                continue
+            if char == '"' and self.is_template_string():
+                template = self.read_template_string()
+                yield self.token(TokenType.TEMPLATE_STRING, template, start_line, start_column)
+                continue
+            if char == "'":
+                value = self.read_string("'")
+                if len(value) != 1:
+                    raise self.error(f"Character literal '{value}' must hold exactly one character "
+                                     f"(use \"...\" for strings)", start_line, start_column, read=True)
+                yield self.token(TokenType.CHAR, value, start_line, start_column)
+                continue
+            if char == '"':
+                string_value = self.read_string('"')
+                yield self.token(TokenType.STRING, string_value, start_line, start_column)
                 continue
             
             # Numbers
-            if self.current_char().isdigit():
-                number = self.read_number()
-                self.tokens.append(Token(TokenType.NUMBER, number, start_line, start_column))
+            if char.isdigit():
+                number = sys.intern(self.read_number())
+                yield self.token(TokenType.NUMBER, number, start_line, start_column)
                 continue
             
             # Identifiers and keywords
-            if self.current_char().isalpha() or self.current_char() == '_':
+            if char.isalpha() or char == '_':
                 identifier = self.read_identifier()
                 token_type = KEYWORDS.get(identifier, TokenType.IDENTIFIER)
-                self.tokens.append(Token(token_type, identifier, start_line, start_column))
+                yield self.token(token_type, identifier, start_line, start_column)
                 continue
             
-            # Two-character operators
-            two_char = self.current_char() + (self.peek_char() or '')
+            # Operators, interned like identifiers
+            three_char = self.reader.slice(self.pos, self.pos + 3)
+            if three_char in THREE_CHAR_OPERATORS:
+                self.take(self.pos + 3)
+                yield self.token(THREE_CHAR_OPERATORS[three_char], sys.intern(three_char), start_line, start_column)
+                continue
+            
+            two_char = three_char[:2]
             if two_char in TWO_CHAR_OPERATORS:
-                self.tokens.append(Token(TWO_CHAR_OPERATORS[two_char], two_char, start_line, start_column))
-                self.advance()
-                self.advance()
+                self.take(self.pos + 2)
+                yield self.token(TWO_CHAR_OPERATORS[two_char], sys.intern(two_char), start_line, start_column)
                 continue
             
-            # One-character operators
-            if self.current_char() in ONE_CHAR_OPERATORS:
-                char = self.current_char()
-                self.tokens.append(Token(ONE_CHAR_OPERATORS[char], char, start_line, start_column))
+            if char in ONE_CHAR_OPERATORS:
                 self.advance()
+                yield self.token(ONE_CHAR_OPERATORS[char], sys.intern(char), start_line, start_column)
                 continue
             
             # Unrecognized character
-            raise LexerError(f"Unrecognized character '{self.current_char()}' at line {self.line}, column {self.column}")
+            raise self.error(f"Unrecognized character '{char}'", self.line, self.column)
         
         # Add EOF token
-        self.tokens.append(Token(TokenType.EOF, '', self.line, self.column))
-        return self.tokens
+        yield self.token(TokenType.EOF, '', self.line, self.column)
@@ -0,0 +1,180 @@
+"""
+Linter for Go-Extended
+Checks go-plus constructs that Go linters only see after lowering: exception handling, constructors,
+class size and package state
+"""
+
+from typing import Dict, List, Optional, Set, Tuple
+from ast_nodes import *
+from errors import Diagnostic
+from transpiler import make_diagnostic, check_warning_levels, check_nowarn
+
+class Linter:
+    """Reports the lint rules (warnings GP2001-GP2005) of a parsed file"""
+
+    # A class above either size probably has more than one responsibility
+    MAX_METHODS = 20
+    MAX_FIELDS = 15
+
+    def __init__(self, warning_levels: Optional[Dict[str, str]] = None):
+        check_warning_levels(warning_levels)
+        self.warning_levels = dict(warning_levels or {})  # warning ID -> severity replacing its default
+        self.program: Optional[Program] = None
+        self.found: List[Tuple[int, int, Diagnostic]] = []  # warnings with the line and column they are at
+
+    def lint(self, program: Program) -> List[Diagnostic]:
+        """Returns the warnings of a file, in source order"""
+        check_nowarn(program)
+        self.program = program
+        self.found = []
+        variables = {decl.name for decl in program.declarations if isinstance(decl, VarDecl)}
+        modified: Set[str] = set()
+
+        for decl in program.declarations:
+            if isinstance(decl, FuncDecl):
+                self._check_body(decl.body)
+                if decl.name != 'init':
+                    self._check_global_writes(decl.name, decl.params, decl.body, variables, modified)
+            elif isinstance(decl, (ClassDecl, ObjectDecl)):
+                self._check_class(decl, variables, modified)
+
+        self.found.sort(key=lambda found: found[:2])
+        return [warning for _, _, warning in self.found]
+
+    def _warn(self, code: str, message: str, node: ASTNode, fix: Optional[str] = None) -> None:
+        """Records a warning unless it is turned off or suppressed by //goplus:nowarn"""
+        warning = make_diagnostic(code, message, node, self.program, self.warning_levels, fix)
+        if warning:
+            self.found.append((node.line, node.column, warning))
+
+    # ------------------------------------------------------------------------
+    # Classes
+    # ------------------------------------------------------------------------
+
+    def _check_class(self, decl, variables: Set[str], modified: Set[str]) -> None:
+        """Checks a class (or singleton) and the classes nested in it"""
+        methods = [m for m in decl.methods if not m.operator]
+        if len(methods) > self.MAX_METHODS or len(decl.fields) > self.MAX_FIELDS:
+            size = f'{len(methods)} methods' if len(methods) > self.MAX_METHODS else f'{len(decl.fields)} fields'
+            self._warn('GP2004', f"class {decl.name} has {size}: split its responsibilities into smaller classes",
+                       decl)
+
+        constructor = getattr(decl, 'constructor', None)
+        if constructor:
+            self._check_body(constructor.body)
+            self._check_constructor_throws(decl.name, constructor)
+            self._check_global_writes(f'{decl.name} constructor', constructor.params, constructor.body,
+                                      variables, modified)
+        for method in decl.methods:
+            self._check_body(method.body)
+            self._check_global_writes(f'{decl.name}.{method.name}', method.params, method.body, variables, modified)
+        for nested in getattr(decl, 'nested', []):
+            self._check_class(nested, variables, modified)
+
+    def _check_constructor_throws(self, class_name: str, constructor: ConstructorDecl) -> None:
+        """A constructor that throws should say so in its comment, since callers of new cannot tell"""
+        if any('throw' in comment.lower() for comment in constructor.comments):
+            return
+        throw = self._uncaught_throw(constructor.body)
+        if throw:
+            kind = self._exception_type(throw)
+            self._warn('GP2003', f"constructor of {class_name} throws {kind or 'an exception'} without documenting "
+                                 f"it", throw, "mention it in the constructor's comment")
+
+    def _uncaught_throw(self, node) -> Optional[ThrowStmt]:
+        """First throw of a subtree that no enclosing catch of the subtree handles (lambdas excluded)"""
+        if isinstance(node, ThrowStmt):
+            return node
+        if isinstance(node, LambdaExpr):
+            return None
+        if isinstance(node, TryStmt):
+            body = None if node.catch_blocks else self._uncaught_throw(node.body)
+            return body or self._uncaught_throw([node.catch_blocks, node.finally_block])
+        if isinstance(node, (list, tuple)):
+            return next((t for t in map(self._uncaught_throw, node) if t), None)
+        if isinstance(node, ASTNode):
+            return next((t for t in map(self._uncaught_throw, field_values(node)) if t), None)
+        return None
+
+    def _exception_type(self, throw: ThrowStmt) -> Optional[str]:
+        """Type of a thrown new Exception("InvalidAge", ...)"""
+        value = throw.expression
+        if isinstance(value, NewExpr) and value.args and isinstance(value.args[0], Literal) and \
+                value.args[0].type == 'string':
+            return value.args[0].value
+        return None
+
+    # ------------------------------------------------------------------------
+    # Exception handling
+    # ------------------------------------------------------------------------
+
+    def _check_body(self, node) -> None:
+        """Checks the try statements of a body, nested ones included"""
+        if isinstance(node, TryStmt):
+            self._check_try(node)
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._check_body(item)
+        elif isinstance(node, ASTNode) and not isinstance(node, ClassDecl):
+            for value in field_values(node):
+                self._check_body(value)
+
+    def _check_try(self, stmt: TryStmt) -> None:
+        """Empty catch bodies and catch-alls that hide the catches after them"""
+        for i, catch in enumerate(stmt.catch_blocks):
+            if not catch.body.statements and not catch.body.end_comments:
+                caught = catch.exception_type or 'exception'
+                self._warn('GP2001', f"empty catch ignores every {caught}", catch,
+                           "handle it, or say why in a comment")
+            if catch.exception_type in (None, 'Exception') and i < len(stmt.catch_blocks) - 1:
+                hidden = stmt.catch_blocks[i + 1]
+                name = f'catch ({hidden.exception_type})' if hidden.exception_type else 'another catch-all'
+                self._warn('GP2002', f"catch-all comes before {name}, which never runs", catch,
+                           "move the catch-all last")
+
+    # ------------------------------------------------------------------------
+    # Package state
+    # ------------------------------------------------------------------------
+
+    def _check_global_writes(self, function: str, params: List[Parameter], body: BlockStmt, variables: Set[str],
+                             modified: Set[str]) -> None:
+        """Package variables should be set where they are declared or in static blocks; each one modified
+        elsewhere is reported once, at its first modification"""
+        shadowed = {p.name for p in params} | self._declared_names(body)
+        for stmt in self._assignments(body):
+            targets = stmt.target.elements if isinstance(stmt.target, TupleExpr) else [stmt.target]
+            for target in targets:
+                root = target
+                while isinstance(root, (SelectorExpr, IndexExpr)):
+                    root = root.object
+                if isinstance(root, Identifier) and root.name in variables - shadowed - modified:
+                    modified.add(root.name)
+                    self._warn('GP2005', f"package variable {root.name} is modified by {function} (mutable "
+                                         f"global state: pass it explicitly or keep it in an object)", stmt)
+
+    def _assignments(self, node) -> List[Statement]:
+        """Assignments and increments of a subtree, in source order"""
+        found = [node] if isinstance(node, (AssignStmt, IncDecStmt)) else []
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                found.extend(self._assignments(item))
+        elif isinstance(node, ASTNode) and not isinstance(node, ClassDecl):
+            for value in field_values(node):
+                found.extend(self._assignments(value))
+        return found
+
+    def _declared_names(self, node) -> Set[str]:
+        """Locals a subtree declares (var x, x :=), which hide package variables of the same name"""
+        names = set()
+        if isinstance(node, VarStmt):
+            names.add(node.name)
+        elif isinstance(node, AssignStmt) and node.operator == ':=':
+            targets = node.target.elements if isinstance(node.target, TupleExpr) else [node.target]
+            names.update(t.name for t in targets if isinstance(t, Identifier))
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                names |= self._declared_names(item)
+        elif isinstance(node, ASTNode):
+            for value in field_values(node):
+                names |= self._declared_names(value)
+        return names
@@ -0,0 +1,89 @@
+"""
+Go literal encoding for Go-Extended
+Turns decoded string values back into valid Go source literals
+"""
+
+from typing import Optional
+
+# Escapes with a short Go form
+GO_ESCAPES = {
+    '\\': '\\\\',
+    '"': '\\"',
+    '\n': '\\n',
+    '\t': '\\t',
+    '\r': '\\r',
+    '\a': '\\a',
+    '\b': '\\b',
+    '\f': '\\f',
+    '\v': '\\v',
+}
+
+def is_raw_byte(char: str) -> bool:
+    """Whether a character stands for a raw byte (the lexer keeps \\x80..\\xff as U+DC80..U+DCFF)"""
+    return 0xDC80 <= ord(char) <= 0xDCFF
+
+def encode_char(char: str) -> str:
+    """Encodes one character the way strconv.Quote does: short escapes, printable characters as-is,
+    raw bytes and control characters as \\xHH and other non-printable characters as \\uXXXX/\\UXXXXXXXX"""
+    code = ord(char)
+    if char in GO_ESCAPES:
+        return GO_ESCAPES[char]
+    if is_raw_byte(char):
+        return f'\\x{code - 0xDC00:02x}'
+    if code < 0x20 or code == 0x7f:
+        return f'\\x{code:02x}'
+    if char.isprintable():
+        return char
+    if code <= 0xffff:
+        return f'\\u{code:04x}'
+    return f'\\U{code:08x}'
+
+def quote_string(value: str) -> str:
+    """Encodes a value as an interpreted Go string literal ("a\\nb")"""
+    return '"' + ''.join(encode_char(char) for char in value) + '"'
+
+def fits_raw_string(value: str) -> bool:
+    """Whether a Go raw string (`...`) can hold the value: no backtick, no CR (dropped by Go) and
+    only printable characters besides newline and tab"""
+    return all(char in '\n\t' or (char != '`' and char.isprintable() and not is_raw_byte(char)) for char in value)
+
+def string_literal(value: str, raw: bool = False) -> str:
+    """Encodes a string value; raw values keep the raw form (`...`) when it can hold them"""
+    if raw and fits_raw_string(value):
+        return f'`{value}`'
+    return quote_string(value)
+
+# Value ranges of the sized Go number types
+NUMBER_RANGES = {
+    'int8': (-2 ** 7, 2 ** 7 - 1),
+    'int16': (-2 ** 15, 2 ** 15 - 1),
+    'int32': (-2 ** 31, 2 ** 31 - 1),
+    'int64': (-2 ** 63, 2 ** 63 - 1),
+    'uint8': (0, 2 ** 8 - 1),
+    'uint16': (0, 2 ** 16 - 1),
+    'uint32': (0, 2 ** 32 - 1),
+    'uint64': (0, 2 ** 64 - 1),
+    'float32': (-3.4028234663852886e38, 3.4028234663852886e38),
+    'float64': (-1.7976931348623157e308, 1.7976931348623157e308),
+}
+
+# Platform-dependent and alias types, sized as on 64-bit targets
+SIZED_TYPES = {'int': 'int64', 'uint': 'uint64', 'uintptr': 'uint64', 'byte': 'uint8', 'rune': 'int32'}
+
+def fits_number(value, go_type: str) -> bool:
+    """Whether a constant is representable in a sized Go number type"""
+    low, high = NUMBER_RANGES[SIZED_TYPES.get(go_type, go_type)]
+    return low <= value <= high
+
+def number_literal(text: str, go_type: Optional[str] = None, negative: bool = False) -> str:
+    """Encodes a number from its normalized spelling; a type suffix becomes a conversion (255u8 -> uint8(255))"""
+    number = f'-{text}' if negative else text
+    return f'{go_type}({number})' if go_type else number
+
+def rune_literal(char: str) -> str:
+    """Encodes a character as a Go rune literal ('a', '\\n', '\\'')"""
+    if char == "'":
+        return "'\\''"
+    if char == '"':
+        return "'\"'"
+    return "'" + encode_char(char) + "'"
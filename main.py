@@ -9,13 +9,43 @@ import argparse
 from pathlib import Path
 from lexer import Lexer
 from parser import Parser
-from transpiler import Transpiler
+from transpiler import Transpiler, WarningError, RECEIVER_STYLES, parse_warning_levels
+from directives import default_tags, file_included, parse_tags
+from sourcemap import line_directives, write_source_map
+from gocheck import check_error, check_files, find_module, go_available, unresolved_imports
+from goformat import FORMATTERS, format_code, formatter_available
+from diagnostics import DIAGNOSTICS_FORMATS, structured_output, report_warnings, print_error
+from errors import error_diagnostics
+from plugins import load_plugins
 
 def main():
     parser = argparse.ArgumentParser(description='Go-Extended to Go Transpiler')
     parser.add_argument('input', help='Input Go-Extended file')
     parser.add_argument('-o', '--output', help='Output Go file (default: <input>.go)')
     parser.add_argument('-v', '--verbose', action='store_true', help='Verbose mode')
+    parser.add_argument('--embed-pointers', action='store_true',
+                        help='Embed base classes by pointer (*Person) instead of by value')
+    parser.add_argument('--receiver', choices=RECEIVER_STYLES, default='this',
+                        help="Receiver name of generated methods: this, self or the type's initial (default: this)")
+    parser.add_argument('--inline-accessors', action='store_true',
+                        help='Replace calls to trivial getters and setters with direct field access')
+    parser.add_argument('--warnings',
+                        help='Severity of warnings by ID: error, warning, info or off (GP1003=error,GP1004=off)')
+    parser.add_argument('--release', action='store_true',
+                        help='Leave unreachable code, uncalled methods and unused classes out of the generated Go')
+    parser.add_argument('--tags', help='Build tags for //goplus:build and #if, besides GOOS/GOARCH (debug,linux)')
+    parser.add_argument('--line-directives', action='store_true',
+                        help='Write //line directives so Go errors and panics point at the source lines')
+    parser.add_argument('--source-map', action='store_true',
+                        help='Write a JSON source map of the generated file next to it (<output>.go.map)')
+    parser.add_argument('--no-verify', action='store_true',
+                        help='Write the generated Go without compiling it first')
+    parser.add_argument('--format', choices=[*FORMATTERS, 'none'], default='gofmt',
+                        help='Formatter of the generated Go (default: gofmt)')
+    parser.add_argument('--diagnostics-format', choices=DIAGNOSTICS_FORMATS, default='text',
+                        help='Write warnings and errors to stdout as json or sarif, other output to stderr')
+    parser.add_argument('--plugin', action='append', default=[], metavar='MODULE',
+                        help='Code generation plugin: a module, or a .py file (repeatable)')
     
     args = parser.parse_args()
     
@@ -26,44 +56,83 @@ def main():
     
     output_file = Path(args.output) if args.output else input_file.with_suffix('.go')
     
-    try:
-        # Read source code
-        with open(input_file, 'r', encoding='utf-8') as f:
-            source_code = f.read()
+    diagnostics = []  # warnings, then the error stopping the transpilation
+    with structured_output(args.diagnostics_format, diagnostics, str(input_file)):
+        try:
+            if args.verbose:
+                print(f"Reading file: {input_file}")
+            
+            # Tokenize and parse, the file being read as the parser needs its tokens
+            tags = default_tags(parse_tags(args.tags))
+            with open(input_file, 'r', encoding='utf-8') as f:
+                if not file_included(f, tags):
+                    print(f"Skipped: {input_file} is excluded by its build constraint")
+                    return
+                f.seek(0)
+                parser = Parser(Lexer(f, tags, str(input_file)).stream(), str(input_file))
+                ast = parser.parse()
+            
+            if args.verbose:
+                print(f"Generated tokens: {len(parser.tokens)}")
+                print("AST generated successfully")
+            
+            # Transpile
+            transpiler = Transpiler(embed_pointers=args.embed_pointers, receiver=args.receiver, release=args.release,
+                                    inline_accessors=args.inline_accessors,
+                                    warning_levels=parse_warning_levels(args.warnings),
+                                    plugins=load_plugins(args.plugin, Path.cwd()))
+            transpiler.source_file = str(input_file)
+            go_code = transpiler.transpile(ast)
+            diagnostics.extend(transpiler.warnings)
+            report_warnings(transpiler.warnings, str(input_file))
+            
+            if args.format != 'none' and not formatter_available(args.format):
+                print(f"Warning: {args.format} not found, the generated Go is written unformatted")
+            go_code, transpiler.line_origins = format_code(go_code, transpiler.line_origins, args.format)
+            
+            # Nothing is written unless the generated Go compiles, checked in the module of the output file (with
+            # its go.mod and go.sum) when there is one
+            if not args.no_verify:
+                if go_available():
+                    module = find_module(output_file.resolve().parent)
+                    if module:
+                        path = output_file.resolve().relative_to(module).as_posix()
+                        go_mod = (module / 'go.mod').read_text(encoding='utf-8')
+                        support = {'go.sum': (module / 'go.sum').read_text(encoding='utf-8')} \
+                            if (module / 'go.sum').exists() else {}
+                    else:
+                        path, go_mod, support = output_file.name, 'module goplus/check\n\ngo 1.23\n', {}
+                    errors = check_files({path: (go_code, transpiler.line_origins)}, go_mod, support)
+                    unresolved = unresolved_imports(errors)
+                    if unresolved:
+                        print(f"Warning: the generated Go is written without being compiled, as its imports cannot "
+                              f"be resolved:\n{unresolved[0]}")
+                    elif errors:
+                        raise check_error(errors)
+                else:
+                    print("Warning: go not found, the generated Go is written without being compiled")
+            
+            if args.line_directives:
+                go_code, transpiler.line_origins = line_directives(go_code, transpiler.line_origins, output_file)
+            
+            # Write output file
+            with open(output_file, 'w', encoding='utf-8') as f:
+                f.write(go_code)
+            if args.source_map:
+                map_file = write_source_map(go_code, transpiler.line_origins, output_file)
+                if args.verbose:
+                    print(f"Source map saved at: {map_file}")
+            
+            print(f"Transpilation completed: {input_file} -> {output_file}")
         
-        if args.verbose:
-            print(f"Reading file: {input_file}")
-        
-        # Tokenize
-        lexer = Lexer(source_code)
-        tokens = lexer.tokenize()
-        
-        if args.verbose:
-            print(f"Generated tokens: {len(tokens)}")
-        
-        # Parse
-        parser = Parser(tokens)
-        ast = parser.parse()
-        
-        if args.verbose:
-            print("AST generated successfully")
-        
-        # Transpile
-        transpiler = Transpiler()
-        go_code = transpiler.transpile(ast)
-        
-        # Write output file
-        with open(output_file, 'w', encoding='utf-8') as f:
-            f.write(go_code)
-        
-        print(f"Transpilation completed: {input_file} -> {output_file}")
-        
-    except Exception as e:
-        print(f"Error during transpilation: {e}")
-        if args.verbose:
-            import traceback
-            traceback.print_exc()
-        sys.exit(1)
+        except Exception as e:
+            if not isinstance(e, WarningError):
+                diagnostics.extend(error_diagnostics(e))
+            print_error(e, str(input_file))
+            if args.verbose:
+                import traceback
+                traceback.print_exc()
+            sys.exit(1)
 
 if __name__ == '__main__':
     main()
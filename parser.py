@@ -3,46 +3,232 @@ Parser for Go-Extended
 Converts tokens into an AST (Abstract Syntax Tree)
 """
 
-from typing import List, Optional, Union
-from tokens import Token, TokenType
+import re
+from typing import Dict, Iterable, List, Optional, Tuple, Union
+from tokens import Token, TokenType, TWO_CHAR_OPERATORS, ONE_CHAR_OPERATORS
+from lexer import Lexer, split_template, split_number
 from ast_nodes import *
+from errors import CompileError, Diagnostic, error_diagnostic, position
 
-class ParseError(Exception):
-    """Parser error"""
-    pass
+# Suppresses warnings on its line, or on the next line when it stands alone: //goplus:nowarn GP1003, GP1005
+NOWARN_PREFIX = '//goplus:nowarn'
+
+class ParseError(CompileError):
+    """Parser error; the one raised by parse() lists every syntax error of the file, one per line"""
+    code = 'GP0001'
+
+# Operator spellings and their token types
+OPERATORS = {**TWO_CHAR_OPERATORS, **ONE_CHAR_OPERATORS}
+
+class TokenBuffer:
+    """The tokens of a stream but newlines and comments, by index, read as the parser reaches them; the comments
+    before each token and the //goplus:nowarn lines are recorded on the way. Tokens the parser is past are
+    released, so a file is never held whole: all but those it may go back to (from a held index on) and those where
+    what it is parsing starts (a kept index: the token, the one before it and its comments)"""
+    CHUNK = 1024  # tokens released at once
+    
+    def __init__(self, tokens: Iterable[Token]):
+        self.stream = iter(tokens)
+        self.start = 0  # index of the first token of the window
+        self.window: List[Token] = []  # the tokens read from start on
+        self.comments: Dict[int, List[Token]] = {}  # comments before a token, by the token's index
+        self.nowarn: Dict[int, List[str]] = {}  # lines with warnings suppressed -> their IDs (empty: all)
+        self.holds: Dict[int, int] = {}  # held index -> times held
+        self.keeps: Dict[int, int] = {}  # kept index -> times kept
+        self.saved: Dict[int, Token] = {}  # kept tokens released from the window
+        self.last: Optional[Token] = None  # the last token read
+    
+    def __len__(self) -> int:
+        """The number of tokens read (all of them once the parser is at the end)"""
+        return self.start + len(self.window)
+    
+    def __getitem__(self, index: int) -> Token:
+        if index < self.start:
+            if index not in self.saved:
+                raise IndexError(f'token {index} is released')
+            return self.saved[index]
+        if not self.read(index):
+            raise IndexError(f'no token {index}')
+        return self.window[index - self.start]
+    
+    def __setitem__(self, index: int, token: Token) -> None:
+        self.window[index - self.start] = token
+    
+    def read(self, index: int) -> bool:
+        """Reads the stream up to the token at index; whether there is one"""
+        while index >= len(self):
+            token = next(self.stream, None)
+            if token is None:
+                return False
+            if token.type == TokenType.COMMENT and token.value.startswith(NOWARN_PREFIX):
+                previous = self.last
+                line = token.line if previous and previous.end_line == token.line else token.line + 1
+                codes = token.value[len(NOWARN_PREFIX):].replace(',', ' ').split()
+                self.nowarn[line] = [] if not codes or self.nowarn.get(line) == [] else \
+                    self.nowarn.get(line, []) + codes
+            elif token.type == TokenType.COMMENT:
+                self.comments.setdefault(len(self), []).append(token)
+            elif token.type != TokenType.NEWLINE:
+                self.window.append(token)
+                self.last = token
+        return True
+    
+    def hold(self, index: int) -> None:
+        """Keeps the tokens from index on (and the one before, which positions look back to) until release()"""
+        self.holds[index] = self.holds.get(index, 0) + 1
+    
+    def release(self, index: int) -> None:
+        self.holds[index] -= 1
+        if not self.holds[index]:
+            del self.holds[index]
+    
+    def keep(self, index: int) -> None:
+        """Keeps the token at index, the one before it and its comments until drop()"""
+        self.keeps[index] = self.keeps.get(index, 0) + 1
+    
+    def drop(self, index: int) -> None:
+        self.keeps[index] -= 1
+        if self.keeps[index]:
+            return
+        del self.keeps[index]
+        if index < self.start:
+            self.comments.pop(index, None)
+            for i in (index - 1, index):
+                if i in self.saved and i not in self.keeps and i + 1 not in self.keeps:
+                    del self.saved[i]
+    
+    def discard(self, index: int) -> None:
+        """Releases the tokens before index, but for those held or kept"""
+        low = min(index, *(held - 1 for held in self.holds)) if self.holds else index
+        if low - self.start < self.CHUNK:
+            return
+        for kept in self.keeps:
+            for i in (kept - 1, kept):
+                if self.start <= i < low:
+                    self.saved[i] = self.window[i - self.start]
+        del self.window[:low - self.start]
+        for i in [i for i in self.comments if i < low and i not in self.keeps]:
+            del self.comments[i]
+        self.start = low
 
 class Parser:
-    def __init__(self, tokens: List[Token]):
-        self.tokens = [t for t in tokens if t.type not in [TokenType.COMMENT, TokenType.NEWLINE]]
+    def __init__(self, tokens: Iterable[Token], file: Optional[str] = None):
+        """tokens may be a list or Lexer.stream(): they are read as parsing reaches them, keeping neither newlines
+        nor comments, and released once parsed (see TokenBuffer)"""
+        self.file = file  # path of the go-plus source, recorded on every positioned node
+        self.tokens = TokenBuffer(tokens)
+        self.comments = self.tokens.comments
+        self.nowarn = self.tokens.nowarn
         self.pos = 0
-        self.current_token = self.tokens[0] if self.tokens else None
+        self.current_token = self.tokens[0] if self.tokens.read(0) else None
+        self.allow_lambda = True  # False where '->' ends the expression (switch cases, match guards)
+        self.errors: List[Diagnostic] = []  # syntax errors recovered from, reported together by parse()
+        self.speculating = 0  # depth of lookaheads that try a parse and backtrack (errors are not recovered)
     
     def advance(self) -> None:
-        """Advances to the next token"""
-        if self.pos < len(self.tokens) - 1:
+        """Advances to the next token, releasing those the parser no longer needs"""
+        if self.tokens.read(self.pos + 1):
             self.pos += 1
             self.current_token = self.tokens[self.pos]
+            self.tokens.discard(self.pos - 1)
         else:
             self.current_token = None
     
     def peek(self, offset: int = 1) -> Optional[Token]:
         """Peeks at the next token without advancing"""
         peek_pos = self.pos + offset
-        if peek_pos < len(self.tokens):
+        if self.tokens.read(peek_pos):
             return self.tokens[peek_pos]
         return None
     
+    def mark(self) -> int:
+        """The position, to go back to with reset() (or to give up with release()): the tokens from there on are
+        kept until then"""
+        self.tokens.hold(self.pos)
+        return self.pos
+    
+    def reset(self, mark: int) -> None:
+        """Goes back to a mark"""
+        self.tokens.release(mark)
+        self.pos = mark
+        self.current_token = self.tokens[mark]
+    
+    def release(self, mark: int) -> None:
+        """Goes on from a mark, which is not gone back to"""
+        self.tokens.release(mark)
+    
+    def start(self) -> int:
+        """The position where a declaration, member or statement starts, for document() and recover(): its token
+        is kept until finish()"""
+        self.tokens.keep(self.pos)
+        return self.pos
+    
+    def finish(self, start_pos: int) -> None:
+        """Ends what start() began"""
+        self.tokens.drop(start_pos)
+    
     def match(self, *token_types: TokenType) -> bool:
         """Checks if the current token is one of the specified types"""
         if not self.current_token:
             return False
         return self.current_token.type in token_types
     
+    def set_position(self, node: ASTNode, token: Token) -> ASTNode:
+        """Records the source span of a node: from its first token to the last one consumed"""
+        node.line = token.line
+        node.column = token.column
+        node.file = self.file
+        if self.pos > 0:
+            last = self.tokens[self.pos - 1]
+            node.end_line, node.end_column = last.end_line, last.end_column
+        return node
+    
+    def locate(self, node: ASTNode, token: Token) -> ASTNode:
+        """Positions a node at the token it starts with unless its parse recorded a more precise position"""
+        if node is not None and not node.line and token:
+            self.set_position(node, token)
+        return node
+    
+    def leading_comments(self, index: int) -> List[str]:
+        """Comments before the token at index that start a line of their own or follow an opening brace (a comment
+        after the previous token on its line is that token's trailing comment); block comments are dedented to
+        their first line"""
+        previous = self.tokens[index - 1] if 0 < index <= len(self.tokens) else None
+        comments = []
+        for comment in self.comments.get(index, []):
+            if previous and comment.line == previous.end_line and previous.type != TokenType.LBRACE:
+                continue
+            lines = comment.value.split('\n')
+            comments.append('\n'.join(
+                [lines[0]] + [line[min(comment.column - 1, len(line) - len(line.lstrip())):] for line in lines[1:]]))
+        return comments
+    
+    def document(self, node: ASTNode, start_pos: int) -> ASTNode:
+        """Attaches its comments to a declaration, member or statement parsed from start_pos: those above it and
+        one following its last token on the same line"""
+        if node is None:
+            return node
+        node.comments = self.leading_comments(start_pos)
+        end = self.pos if self.current_token else len(self.tokens)
+        if end > start_pos:
+            last = self.tokens[end - 1]
+            trailing = [c.value for c in self.comments.get(end, [])
+                        if c.line == last.end_line and last.type != TokenType.LBRACE]
+            node.trailing_comment = ' '.join(trailing) or None
+        return node
+    
+    def error(self, message: str, token: Optional[Token] = None) -> ParseError:
+        """The syntax error of a token (by default the current one, or the last at the end of the tokens)"""
+        token = token or self.current_token or self.tokens.last
+        text = f"{message} at line {token.line}, column {token.column}"
+        span = position(self.file, token.line, token.column, token.end_line, token.end_column)
+        return ParseError(text, error_diagnostic(ParseError.code, message, span, text=text))
+    
     def consume(self, token_type: TokenType, message: str = None) -> Token:
         """Consumes a token of the specified type or raises an error"""
         if not self.current_token or self.current_token.type != token_type:
             msg = message or f"Expected {token_type.name}, found {self.current_token.type.name if self.current_token else 'EOF'}"
-            raise ParseError(msg)
+            raise self.error(msg)
         
         token = self.current_token
         self.advance()
@@ -50,6 +236,9 @@ class Parser:
     
     def parse(self) -> Program:
         """Main parse method - returns the program"""
+        # The file's header comments (license, package doc); build constraints of go-plus are resolved here
+        header = [c for c in self.leading_comments(0) if not c.startswith('//goplus:')]
+        
         # package declaration
         self.consume(TokenType.PACKAGE, "Expected 'package'")
         package_name = self.consume(TokenType.IDENTIFIER, "Expected package name").value
@@ -57,19 +246,63 @@ class Parser:
         # imports
         imports = []
         while self.match(TokenType.IMPORT):
-            imports.append(self.parse_import())
+            imports.extend(self.parse_import_decl())
         
         # declarations
         declarations = []
         while self.current_token and not self.match(TokenType.EOF):
-            declarations.append(self.parse_declaration())
+            start_pos = self.start()
+            try:
+                declarations.append(self.document(self.locate(self.parse_declaration(), self.tokens[start_pos]),
+                                                  start_pos))
+            except ParseError as e:
+                self.recover(e, start_pos)
+            except RecursionError:
+                # The Python stack bounds how deeply code nests (go/parser has a maximum nesting depth too):
+                # parsing cannot go on from the middle of the nesting
+                self.errors.extend(self.error("Code nested too deeply").diagnostics)
+                break
+            finally:
+                self.finish(start_pos)
         
-        return Program(package_name, imports, declarations)
+        if self.errors:
+            raise ParseError('\n'.join(f'{self.file}: {error}' if self.file else error for error in self.errors),
+                             *self.errors)
+        return Program(package_name, imports, declarations, self.nowarn, comments=header, file=self.file)
+    
+    def recover(self, error: ParseError, start_pos: int) -> None:
+        """Records a syntax error and skips the declaration, member or statement that started at start_pos,
+        so parsing goes on with the next one: the next token that begins a line at its column (a sibling;
+        a '}' there closes the skipped code) or left of it (the brace closing the enclosing block)"""
+        if self.speculating:
+            raise error
+        # An error raised with no token is at the one parsing stopped at
+        self.errors.extend([d for d in error.diagnostics if d.origin] or self.error(str(error)).diagnostics)
+        
+        column = self.tokens[start_pos].column
+        if self.pos == start_pos:
+            self.advance()
+        while self.current_token and not self.match(TokenType.EOF):
+            if self.current_token.line != self.tokens[self.pos - 1].line and (
+                    self.current_token.column < column or
+                    self.current_token.column == column and not self.match(TokenType.RBRACE)):
+                return
+            self.advance()
     
-    def parse_import(self) -> ImportDecl:
-        """Parses an import declaration"""
+    def parse_import_decl(self) -> List[ImportDecl]:
+        """Parses an import declaration: one import or a group of them (import ( "fmt" "strings" ))"""
         self.consume(TokenType.IMPORT)
-        
+        if not self.match(TokenType.LPAREN):
+            return [self.parse_import_spec()]
+        self.consume(TokenType.LPAREN)
+        imports = []
+        while self.current_token and not self.match(TokenType.RPAREN, TokenType.EOF):
+            imports.append(self.parse_import_spec())
+        self.consume(TokenType.RPAREN, "Expected ')' to close the import group")
+        return imports
+    
+    def parse_import_spec(self) -> ImportDecl:
+        """Parses an imported path with its optional alias"""
         alias = None
         if self.match(TokenType.IDENTIFIER) and self.peek() and self.peek().type == TokenType.STRING:
             alias = self.current_token.value
@@ -80,7 +313,9 @@ class Parser:
     
     def parse_declaration(self) -> Declaration:
         """Parses a declaration"""
-        if self.match(TokenType.FUNC):
+        if self.match(TokenType.AT):
+            return self.parse_annotated_declaration()
+        elif self.match(TokenType.FUNC):
             return self.parse_func_decl()
         elif self.match(TokenType.VAR):
             return self.parse_var_decl()
@@ -94,13 +329,75 @@ class Parser:
             return self.parse_interface_decl()
         elif self.match(TokenType.CLASS):
             return self.parse_class_decl()
+        elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'data' and \
+                self.peek() and self.peek().type == TokenType.CLASS:
+            return self.parse_data_class_decl()
+        elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'record' and \
+                self.peek() and self.peek().type == TokenType.IDENTIFIER:
+            return self.parse_data_class_decl()
+        elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'partial' and \
+                self.peek() and self.peek().type == TokenType.CLASS:
+            return self.parse_partial_class_decl()
+        elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'mixin' and \
+                self.peek() and self.peek().type == TokenType.IDENTIFIER:
+            return self.parse_mixin_decl()
+        elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'object' and \
+                self.peek() and self.peek().type == TokenType.IDENTIFIER:
+            return self.parse_object_decl()
+        elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'annotation' and \
+                self.peek() and self.peek().type == TokenType.IDENTIFIER:
+            return self.parse_annotation_decl()
+        elif self.match(TokenType.ENUM):
+            return self.parse_enum_decl()
         else:
             raise ParseError(f"Unrecognized declaration: {self.current_token.value if self.current_token else 'EOF'}")
     
+    def parse_annotated_declaration(self) -> Declaration:
+        """Parses annotations (@cloneable) and the class declaration they apply to"""
+        annotations = []
+        while self.match(TokenType.AT):
+            annotations.append(self.parse_annotation())
+        
+        decl = self.parse_declaration()
+        if not isinstance(decl, ClassDecl):
+            raise ParseError(f"Annotation @{annotations[0].name} can only be applied to a class")
+        decl.annotations = annotations + decl.annotations
+        return decl
+    
+    def parse_annotation_decl(self) -> AnnotationDecl:
+        """Parses an annotation declaration: annotation route(method string, path string)"""
+        start = self.current_token
+        self.advance()  # 'annotation' (contextual)
+        name = self.consume(TokenType.IDENTIFIER, "Expected annotation name").value
+        params = []
+        if self.match(TokenType.LPAREN):
+            self.advance()
+            params = self.parse_parameter_list()
+            self.consume(TokenType.RPAREN)
+        return self.set_position(AnnotationDecl(name, params), start)
+    
+    def parse_annotation(self) -> Annotation:
+        """Parses @name or @name(args)"""
+        start = self.current_token
+        self.consume(TokenType.AT)
+        name = self.consume(TokenType.IDENTIFIER, "Expected annotation name").value
+        args = []
+        if self.match(TokenType.LPAREN):
+            self.advance()
+            while not self.match(TokenType.RPAREN) and self.current_token:
+                args.append(self.parse_expression())
+                if self.match(TokenType.COMMA):
+                    self.advance()
+                else:
+                    break
+            self.consume(TokenType.RPAREN)
+        return self.set_position(Annotation(name, args), start)
+    
     def parse_func_decl(self) -> FuncDecl:
         """Parses a function declaration"""
         self.consume(TokenType.FUNC)
         name = self.consume(TokenType.IDENTIFIER, "Expected function name").value
+        type_params = self.parse_type_params()
         
         self.consume(TokenType.LPAREN)
         params = self.parse_parameter_list()
@@ -108,48 +405,59 @@ class Parser:
         
         return_type = None
         if not self.match(TokenType.LBRACE):
-            return_type = self.consume(TokenType.IDENTIFIER, "Expected return type").value
+            return_type = self.parse_result_type()
         
         body = self.parse_block_stmt()
-        return FuncDecl(name, params, return_type, body)
+        return FuncDecl(name, params, return_type, body, type_params)
     
     def parse_var_decl(self) -> VarDecl:
         """Parses a variable declaration"""
-        self.consume(TokenType.VAR)
+        start = self.consume(TokenType.VAR)
         name = self.consume(TokenType.IDENTIFIER, "Expected variable name").value
         
         type_name = None
         if not self.match(TokenType.ASSIGN):
-            type_name = self.consume(TokenType.IDENTIFIER, "Expected variable type").value
+            type_name = self.parse_type("Expected variable type")
         
         value = None
         if self.match(TokenType.ASSIGN):
             self.advance()
             value = self.parse_expression()
         
-        return VarDecl(name, type_name, value)
+        return self.set_position(VarDecl(name, type_name, value), start)
     
     def parse_const_decl(self) -> ConstDecl:
         """Parses a constant declaration"""
+        start = self.current_token
         self.consume(TokenType.CONST)
         name = self.consume(TokenType.IDENTIFIER, "Expected constant name").value
         
         type_name = None
         if not self.match(TokenType.ASSIGN):
-            type_name = self.consume(TokenType.IDENTIFIER, "Expected constant type").value
+            type_name = self.parse_type("Expected constant type")
         
         self.consume(TokenType.ASSIGN)
         value = self.parse_expression()
         
-        return ConstDecl(name, type_name, value)
+        return self.set_position(ConstDecl(name, type_name, value), start)
     
     def parse_type_decl(self) -> TypeDecl:
-        """Parses a type declaration"""
+        """Parses a type declaration, an alias (type ID = string) or a union (type Number = int | float64)"""
+        start = self.current_token
         self.consume(TokenType.TYPE)
         name = self.consume(TokenType.IDENTIFIER, "Expected type name").value
-        type_def = self.consume(TokenType.IDENTIFIER, "Expected type definition").value
+        if self.match(TokenType.ASSIGN):
+            self.advance()
+            variants = [self.parse_type("Expected aliased type")]
+            while self.match(TokenType.BITWISE_OR):
+                self.advance()
+                variants.append(self.parse_type("Expected union variant type"))
+            if len(variants) > 1:
+                return self.set_position(TypeDecl(name, ' | '.join(variants), variants=variants), start)
+            return self.set_position(TypeDecl(name, variants[0], is_alias=True), start)
+        type_def = self.parse_type("Expected type definition")
         
-        return TypeDecl(name, type_def)
+        return self.set_position(TypeDecl(name, type_def), start)
     
     def parse_struct_decl(self) -> StructDecl:
         """Parses a struct declaration"""
@@ -160,9 +468,13 @@ class Parser:
         fields = []
         
         while not self.match(TokenType.RBRACE) and self.current_token:
-            field_name = self.consume(TokenType.IDENTIFIER, "Expected field name").value
-            field_type = self.consume(TokenType.IDENTIFIER, "Expected field type").value
-            fields.append(StructField(field_name, field_type))
+            start_pos = self.start()
+            try:
+                field_name = self.consume(TokenType.IDENTIFIER, "Expected field name").value
+                field_type = self.parse_type("Expected field type")
+                fields.append(self.document(StructField(field_name, field_type), start_pos))
+            finally:
+                self.finish(start_pos)
         
         self.consume(TokenType.RBRACE)
         return StructDecl(name, fields)
@@ -171,64 +483,366 @@ class Parser:
         """Parses an interface declaration"""
         self.consume(TokenType.INTERFACE)
         name = self.consume(TokenType.IDENTIFIER, "Expected interface name").value
+        type_params = self.parse_type_params()
         
         self.consume(TokenType.LBRACE)
         methods = []
+        type_elements = []
         
         while not self.match(TokenType.RBRACE) and self.current_token:
+            # Class-style 'func' prefix is accepted for symmetry with class methods
+            if self.match(TokenType.FUNC):
+                self.advance()
+            elif not (self.match(TokenType.IDENTIFIER) and self.peek() and self.peek().type == TokenType.LPAREN):
+                # Type element: a constraint union (~int | float64) or an embedded interface
+                type_elements.append(self.parse_constraint())
+                continue
+            
             method_name = self.consume(TokenType.IDENTIFIER, "Expected method name").value
             
             self.consume(TokenType.LPAREN)
             params = self.parse_parameter_list()
-            self.consume(TokenType.RPAREN)
+            rparen = self.consume(TokenType.RPAREN)
             
+            # The return type must start on the same line; otherwise it is the next member
             return_type = None
-            if not self.match(TokenType.RBRACE) and self.match(TokenType.IDENTIFIER):
-                return_type = self.current_token.value
-                self.advance()
+            if (self.starts_type() or self.match(TokenType.LPAREN)) and self.current_token.line == rparen.line:
+                return_type = self.parse_result_type()
             
             methods.append(MethodSignature(method_name, params, return_type))
         
         self.consume(TokenType.RBRACE)
-        return InterfaceDecl(name, methods)
+        return InterfaceDecl(name, methods, type_params, type_elements)
     
     def parse_class_decl(self) -> ClassDecl:
         """Parses a class declaration (extension)"""
+        start = self.current_token
         self.consume(TokenType.CLASS)
         name = self.consume(TokenType.IDENTIFIER, "Expected class name").value
+        type_params = self.parse_type_params()
         
         extends = None
         if self.match(TokenType.EXTENDS):
             self.advance()
-            extends = self.consume(TokenType.IDENTIFIER, "Expected parent class name").value
+            extends = self.parse_type("Expected parent class name")
+        
+        mixins = []
+        if self.match(TokenType.IDENTIFIER) and self.current_token.value == 'with':
+            self.advance()
+            mixins.append(self.consume(TokenType.IDENTIFIER, "Expected mixin name").value)
+            while self.match(TokenType.COMMA):
+                self.advance()
+                mixins.append(self.consume(TokenType.IDENTIFIER, "Expected mixin name").value)
+        
+        implements = []
+        if self.match(TokenType.IMPLEMENTS):
+            self.advance()
+            implements.append(self.parse_type("Expected interface name"))
+            
+            while self.match(TokenType.COMMA):
+                self.advance()
+                implements.append(self.parse_type("Expected interface name"))
         
         self.consume(TokenType.LBRACE)
         
         fields = []
         methods = []
+        nested = []
+        static_blocks = []
+        init_blocks = []
+        events = []
+        overrides = []
+        constants = []
+        conversions = []
         constructor = None
+        destructor = None
         
         while not self.match(TokenType.RBRACE) and self.current_token:
-            if self.match(TokenType.IDENTIFIER) and self.current_token.value == name:
-                # Constructor
-                constructor = self.parse_constructor()
-            elif self.match(TokenType.FUNC):
-                # Method
-                methods.append(self.parse_method_decl())
-            else:
-                # Field
-                field_name = self.consume(TokenType.IDENTIFIER, "Expected field name").value
-                field_type = self.consume(TokenType.IDENTIFIER, "Expected field type").value
-                
-                field_value = None
-                if self.match(TokenType.ASSIGN):
+            start_pos = self.start()
+            try:
+                if self.match(TokenType.IDENTIFIER) and self.current_token.value == name:
+                    # Constructor
+                    member = self.current_token
+                    constructor = self.document(self.set_position(self.parse_constructor(), member), start_pos)
+                elif self.match(TokenType.BITWISE_NOT) and self.peek() and self.peek().value == name:
+                    # Destructor: ~ClassName() { ... }
                     self.advance()
-                    field_value = self.parse_expression()
-                
-                fields.append(ClassField(field_name, field_type, field_value))
+                    self.advance()
+                    self.consume(TokenType.LPAREN)
+                    self.consume(TokenType.RPAREN, "Destructor takes no parameters")
+                    destructor = self.parse_block_stmt()
+                elif self.match(TokenType.CLASS):
+                    # Nested class
+                    nested.append(self.document(self.parse_class_decl(), start_pos))
+                elif self.match(TokenType.CONST):
+                    # Class constant
+                    constants.append(self.document(self.parse_const_decl(), start_pos))
+                elif self.match(TokenType.IDENTIFIER) and self.current_token.value in ('static', 'companion') and \
+                        self.peek() and self.peek().type == TokenType.LBRACE:
+                    # Static initializer, run once at package init
+                    member = self.current_token
+                    self.advance()
+                    static_blocks.append(self.set_position(self.parse_block_stmt(), member))
+                elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'init' and \
+                        self.peek() and self.peek().type == TokenType.LBRACE:
+                    # Instance initializer, run by every constructor
+                    member = self.current_token
+                    self.advance()
+                    init_blocks.append(self.set_position(self.parse_block_stmt(), member))
+                elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'inner' and \
+                        self.peek() and self.peek().type == TokenType.CLASS:
+                    # Inner class (bound to an instance of this class)
+                    self.advance()
+                    inner = self.document(self.parse_class_decl(), start_pos)
+                    inner.is_inner = True
+                    nested.append(inner)
+                elif self.is_conversion_decl():
+                    conversions.append(self.document(self.parse_conversion_decl(), start_pos))
+                elif self.match(TokenType.FUNC) or self.is_operator_decl():
+                    # Method or operator overload
+                    methods.append(self.document(self.parse_member_method(), start_pos))
+                elif self.match(TokenType.AT):
+                    # Annotations of the method that follows (@deprecated func Old())
+                    annotations = []
+                    while self.match(TokenType.AT):
+                        annotations.append(self.parse_annotation())
+                    if not (self.match(TokenType.FUNC) or self.is_operator_decl()):
+                        raise self.error(f"Annotation @{annotations[0].name} in class {name} must be followed by a "
+                                         "method")
+                    method = self.document(self.parse_member_method(), start_pos)
+                    method.annotations = annotations
+                    methods.append(method)
+                elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'event' and \
+                        self.peek() and self.peek().type == TokenType.IDENTIFIER and \
+                        self.peek(2) and self.peek(2).type == TokenType.LPAREN:
+                    events.append(self.document(self.parse_event_decl(), start_pos))
+                elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'override' and \
+                        self.peek() and self.peek().type == TokenType.IDENTIFIER and \
+                        self.peek(2) and self.peek(2).value == 'from':
+                    overrides.append(self.parse_member_override())
+                else:
+                    fields.append(self.document(self.parse_class_field(), start_pos))
+            except ParseError as e:
+                self.recover(e, start_pos)
+            finally:
+                self.finish(start_pos)
+        
+        self.consume(TokenType.RBRACE)
+        if destructor and any(m.name == 'Dispose' for m in methods):
+            raise ParseError(f"Class {name} declares both ~{name}() and Dispose()")
+        
+        decl = ClassDecl(name, extends, fields, methods, constructor, implements, type_params,
+                         mixins=mixins, nested=nested, static_blocks=static_blocks, init_blocks=init_blocks,
+                         destructor=destructor, events=events, overrides=overrides, constants=constants,
+                         conversions=conversions)
+        return self.set_position(decl, start)
+    
+    def parse_member_override(self) -> MemberOverride:
+        """Parses a conflict resolution: override Audit from Auditable"""
+        start = self.current_token
+        self.advance()  # 'override' (contextual)
+        member = self.consume(TokenType.IDENTIFIER, "Expected member name").value
+        self.advance()  # 'from' (contextual)
+        source = self.consume(TokenType.IDENTIFIER, "Expected mixin or base class name").value
+        if self.match(TokenType.DOT):
+            # Qualified base class: override Greet from models.Person
+            self.advance()
+            source += '.' + self.consume(TokenType.IDENTIFIER, "Expected base class name").value
+        return self.set_position(MemberOverride(member, source), start)
+    
+    def parse_event_decl(self) -> EventDecl:
+        """Parses an event member: event OnLowFuel(level float64)"""
+        start = self.current_token
+        self.advance()  # 'event' (contextual)
+        name = self.consume(TokenType.IDENTIFIER, "Expected event name").value
+        self.consume(TokenType.LPAREN)
+        params = self.parse_parameter_list()
+        self.consume(TokenType.RPAREN)
+        return self.set_position(EventDecl(name, params), start)
+    
+    def parse_partial_class_decl(self) -> ClassDecl:
+        """Parses one part of a partial class: partial class Person { ... }"""
+        start = self.current_token
+        self.advance()  # 'partial' (contextual)
+        decl = self.parse_class_decl()
+        decl.is_partial = True
+        return self.set_position(decl, start)
+    
+    def parse_class_field(self) -> ClassField:
+        """Parses a class field: name type [= value]"""
+        start = self.current_token
+        field_name = self.consume(TokenType.IDENTIFIER, "Expected field name").value
+        field_type = self.parse_type("Expected field type")
+        
+        field_value = None
+        if self.match(TokenType.ASSIGN):
+            self.advance()
+            field_value = self.parse_expression()
+        
+        # Accessor flags and struct tags must follow on the same line; otherwise they start the next member
+        accessors = []
+        tags = []
+        while self.current_token and self.current_token.line == self.tokens[self.pos - 1].line:
+            if self.match(TokenType.AT):
+                tags.append(self.parse_annotation())
+            elif self.match(TokenType.IDENTIFIER) and self.current_token.value in ('get', 'set'):
+                if self.current_token.value in accessors:
+                    raise ParseError(f"Duplicate accessor flag '{self.current_token.value}' on field {field_name}")
+                accessors.append(self.current_token.value)
+                self.advance()
+            else:
+                break
+        
+        return self.set_position(ClassField(field_name, field_type, field_value, accessors, tags), start)
+    
+    def parse_member_method(self) -> MethodDecl:
+        """Parses a method or an operator overload inside a class body"""
+        start = self.current_token
+        if self.is_operator_decl():
+            return self.set_position(self.parse_operator_decl(), start)
+        return self.set_position(self.parse_method_decl(), start)
+    
+    def parse_object_decl(self) -> ObjectDecl:
+        """Parses a singleton object: object Config [implements ...] { fields and methods }"""
+        start = self.current_token
+        self.advance()  # 'object' (contextual)
+        name = self.consume(TokenType.IDENTIFIER, "Expected object name").value
+        
+        implements = []
+        if self.match(TokenType.IMPLEMENTS):
+            self.advance()
+            implements.append(self.parse_type("Expected interface name"))
+            while self.match(TokenType.COMMA):
+                self.advance()
+                implements.append(self.parse_type("Expected interface name"))
+        
+        self.consume(TokenType.LBRACE)
+        fields = []
+        methods = []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            if self.match(TokenType.FUNC) or self.is_operator_decl():
+                methods.append(self.parse_member_method())
+            elif self.match(TokenType.IDENTIFIER) and self.current_token.value == name:
+                raise ParseError(f"Object {name} cannot declare a constructor")
+            else:
+                fields.append(self.parse_class_field())
         
         self.consume(TokenType.RBRACE)
-        return ClassDecl(name, extends, fields, methods, constructor)
+        return self.set_position(ObjectDecl(name, fields, methods, implements), start)
+    
+    def parse_mixin_decl(self) -> MixinDecl:
+        """Parses a mixin: mixin Auditable { fields and methods }"""
+        start = self.current_token
+        self.advance()  # 'mixin' (contextual)
+        name = self.consume(TokenType.IDENTIFIER, "Expected mixin name").value
+        self.consume(TokenType.LBRACE)
+        
+        fields = []
+        methods = []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            if self.match(TokenType.FUNC) or self.is_operator_decl():
+                methods.append(self.parse_member_method())
+            elif self.match(TokenType.IDENTIFIER) and self.current_token.value == name:
+                raise ParseError(f"Mixin {name} cannot declare a constructor")
+            else:
+                fields.append(self.parse_class_field())
+        
+        self.consume(TokenType.RBRACE)
+        return self.set_position(MixinDecl(name, fields, methods), start)
+    
+    def parse_data_class_decl(self) -> ClassDecl:
+        """Parses a data class (data class Point(x, y float64) { methods }) or a record"""
+        is_record = self.current_token.value == 'record'
+        self.advance()  # 'data' or 'record' (contextual)
+        if not is_record:
+            self.consume(TokenType.CLASS)
+        name = self.consume(TokenType.IDENTIFIER, "Expected class name").value
+        type_params = self.parse_type_params()
+        
+        self.consume(TokenType.LPAREN)
+        params = self.parse_parameter_list()
+        self.consume(TokenType.RPAREN)
+        
+        if not params:
+            kind = 'Record' if is_record else 'Data class'
+            raise ParseError(f"{kind} {name} must declare at least one field")
+        
+        implements = []
+        if self.match(TokenType.IMPLEMENTS):
+            self.advance()
+            implements.append(self.parse_type("Expected interface name"))
+            while self.match(TokenType.COMMA):
+                self.advance()
+                implements.append(self.parse_type("Expected interface name"))
+        
+        methods = []
+        if self.match(TokenType.LBRACE):
+            self.advance()
+            while not self.match(TokenType.RBRACE) and self.current_token:
+                methods.append(self.parse_member_method())
+            self.consume(TokenType.RBRACE)
+        
+        fields = [ClassField(p.name, p.type) for p in params]
+        return ClassDecl(name, None, fields, methods, None, implements, type_params,
+                         is_data=True, is_record=is_record)
+    
+    def parse_enum_decl(self) -> EnumDecl:
+        """Parses an enum declaration (extension)"""
+        self.consume(TokenType.ENUM)
+        name = self.consume(TokenType.IDENTIFIER, "Expected enum name").value
+        
+        # Associated values: enum Planet(mass float64) { Earth(5.97) }
+        fields = []
+        if self.match(TokenType.LPAREN):
+            self.advance()
+            fields = self.parse_parameter_list()
+            self.consume(TokenType.RPAREN)
+        
+        self.consume(TokenType.LBRACE)
+        
+        members = []
+        while self.match(TokenType.IDENTIFIER):
+            member_name = self.current_token.value
+            self.advance()
+            
+            args = []
+            if self.match(TokenType.LPAREN):
+                self.advance()
+                while not self.match(TokenType.RPAREN) and self.current_token:
+                    args.append(self.parse_expression())
+                    if self.match(TokenType.COMMA):
+                        self.advance()
+                    else:
+                        break
+                self.consume(TokenType.RPAREN)
+            
+            if len(args) != len(fields):
+                raise ParseError(f"Enum member {name}.{member_name} expects {len(fields)} value(s), got {len(args)}")
+            
+            members.append(EnumMember(member_name, args))
+            
+            if self.match(TokenType.COMMA):
+                self.advance()
+        
+        if not members:
+            raise ParseError(f"Enum {name} must declare at least one member")
+        
+        methods = []
+        while self.match(TokenType.FUNC):
+            methods.append(self.parse_method_decl())
+        
+        self.consume(TokenType.RBRACE)
+        return EnumDecl(name, members, methods, fields)
+    
+    def parse_qualified_name(self, message: str) -> str:
+        """Parses a possibly package-qualified name (pkg.Name)"""
+        name = self.consume(TokenType.IDENTIFIER, message).value
+        
+        if self.match(TokenType.DOT):
+            self.advance()
+            name += '.' + self.consume(TokenType.IDENTIFIER, message).value
+        
+        return name
     
     def parse_constructor(self) -> ConstructorDecl:
         """Parses a constructor"""
@@ -245,6 +859,34 @@ class Parser:
         """Parses a method declaration"""
         self.consume(TokenType.FUNC)
         name = self.consume(TokenType.IDENTIFIER, "Expected method name").value
+        type_params = self.parse_type_params()
+        
+        self.consume(TokenType.LPAREN)
+        params = self.parse_parameter_list()
+        self.consume(TokenType.RPAREN)
+        
+        return_type = None
+        if not self.match(TokenType.LBRACE):
+            return_type = self.parse_result_type()
+        
+        body = self.parse_block_stmt()
+        return MethodDecl(name, params, return_type, body, type_params)
+    
+    def is_operator_decl(self) -> bool:
+        """Checks for 'operator' followed by an overloadable operator ('operator' is contextual)"""
+        return (self.match(TokenType.IDENTIFIER) and self.current_token.value == 'operator' and
+                self.peek() is not None and (self.peek().value in OPERATOR_METHOD_NAMES or
+                                             self.peek().type == TokenType.LBRACKET))
+    
+    def parse_operator_decl(self) -> MethodDecl:
+        """Parses an operator overload (operator +(other *Money) *Money { ... })"""
+        self.advance()  # 'operator'
+        
+        if self.match(TokenType.LBRACKET):
+            return self.parse_indexer_decl()
+        
+        operator = self.current_token.value
+        self.advance()
         
         self.consume(TokenType.LPAREN)
         params = self.parse_parameter_list()
@@ -252,40 +894,340 @@ class Parser:
         
         return_type = None
         if not self.match(TokenType.LBRACE):
-            return_type = self.consume(TokenType.IDENTIFIER, "Expected return type").value
+            return_type = self.parse_result_type()
+        
+        if operator == '-' and not params:
+            name = 'Neg'
+        elif len(params) != 1:
+            raise ParseError(f"Operator {operator} must take exactly one operand")
+        else:
+            name = OPERATOR_METHOD_NAMES[operator]
+        
+        if operator in ('==', '!=', '<', '<=', '>', '>=') and return_type != 'bool':
+            raise ParseError(f"Operator {operator} must return bool")
         
         body = self.parse_block_stmt()
-        return MethodDecl(name, params, return_type, body)
+        return MethodDecl(name, params, return_type, body, operator=operator)
+    
+    def is_conversion_decl(self) -> bool:
+        """Checks for operator convert, optionally marked implicit or explicit (all contextual)"""
+        offset = 1 if self.match(TokenType.IDENTIFIER) and self.current_token.value in ('implicit', 'explicit') else 0
+        operator, convert = self.peek(offset), self.peek(offset + 1)
+        return operator is not None and operator.type == TokenType.IDENTIFIER and operator.value == 'operator' and \
+            convert is not None and convert.type == TokenType.IDENTIFIER and convert.value == 'convert'
+    
+    def parse_conversion_decl(self) -> ConversionDecl:
+        """Parses a conversion operator: [implicit | explicit] operator convert(c *Celsius) *Fahrenheit { ... }
+        (explicit, the default, is applied only by 'as')"""
+        start = self.current_token
+        implicit = False
+        if self.current_token.value in ('implicit', 'explicit'):
+            implicit = self.current_token.value == 'implicit'
+            self.advance()
+        self.advance()  # 'operator'
+        self.advance()  # 'convert'
+        
+        self.consume(TokenType.LPAREN)
+        params = self.parse_parameter_list()
+        self.consume(TokenType.RPAREN)
+        if len(params) != 1:
+            raise self.error("Conversion operator must take exactly one value", start)
+        if self.match(TokenType.LBRACE):
+            raise self.error("Conversion operator must declare the type it converts to", start)
+        return_type = self.parse_type("Expected conversion target type")
+        
+        body = self.parse_block_stmt()
+        return self.set_position(ConversionDecl(params, return_type, body, implicit), start)
+    
+    def parse_indexer_decl(self) -> MethodDecl:
+        """Parses an indexer: operator [](key K) V, operator [](key K) (V, bool) or operator []=(key K, value V)"""
+        self.consume(TokenType.LBRACKET)
+        self.consume(TokenType.RBRACKET)
+        
+        is_setter = False
+        if self.match(TokenType.ASSIGN):
+            self.advance()
+            is_setter = True
+        
+        self.consume(TokenType.LPAREN)
+        params = self.parse_parameter_list()
+        self.consume(TokenType.RPAREN)
+        
+        return_type = None
+        if not self.match(TokenType.LBRACE):
+            return_type = self.parse_result_type()
+        
+        if is_setter:
+            if len(params) != 2 or return_type:
+                raise ParseError("Index setter must take a key and a value and return nothing")
+            name, operator = 'IndexSet', '[]='
+        else:
+            if len(params) != 1 or not return_type:
+                raise ParseError("Index getter must take a key and return a value")
+            # A comma-ok getter ((V, bool)) gets a generated IndexGet that throws KeyNotFound
+            name = 'IndexLookup' if return_type.startswith('(') else 'IndexGet'
+            operator = '[]'
+        
+        body = self.parse_block_stmt()
+        return MethodDecl(name, params, return_type, body, operator=operator)
     
     def parse_parameter_list(self) -> List[Parameter]:
-        """Parses a parameter list"""
+        """Parses a parameter list (Go-style grouping such as 'x, y float64' is allowed)"""
         params = []
+        pending = []  # names waiting for a shared type
         
         while not self.match(TokenType.RPAREN) and self.current_token:
-            param_name = self.consume(TokenType.IDENTIFIER, "Expected parameter name").value
-            param_type = self.consume(TokenType.IDENTIFIER, "Expected parameter type").value
-            params.append(Parameter(param_name, param_type))
+            if params and params[-1].type.startswith('...'):
+                raise self.error(f"Only the last parameter can be variadic ({params[-1].name} {params[-1].type})")
+            param_token = self.consume(TokenType.IDENTIFIER, "Expected parameter name")
+            param_name = param_token.value
+            
+            if self.match(TokenType.COMMA, TokenType.RPAREN):
+                pending.append(param_token)
+            else:
+                param_type = self.parse_variadic_type("Expected parameter type")
+                if pending and param_type.startswith('...'):
+                    raise self.error(f"Only the last parameter can be variadic ({pending[0].value}, {param_name} "
+                                     f"{param_type})")
+                for token in pending:
+                    params.append(self.set_position(Parameter(token.value, param_type), token))
+                pending = []
+                params.append(self.set_position(Parameter(param_name, param_type), param_token))
             
             if self.match(TokenType.COMMA):
                 self.advance()
             else:
                 break
         
+        if pending:
+            raise ParseError(f"Expected type for parameter {pending[-1].value}")
+        
         return params
     
+    def parse_variadic_type(self, message: str) -> str:
+        """Parses a parameter type, which may be variadic (...string)"""
+        if self.match(TokenType.ELLIPSIS):
+            self.advance()
+            return '...' + self.parse_type(message)
+        return self.parse_type(message)
+    
+    def starts_type(self) -> bool:
+        """Checks if the current token can start a type"""
+        return self.match(TokenType.IDENTIFIER, TokenType.MULTIPLY, TokenType.LBRACKET, TokenType.MAP,
+                          TokenType.CHAN, TokenType.LEFT_ARROW, TokenType.FUNC, TokenType.INTERFACE)
+    
+    def parse_type(self, message: str = "Expected type") -> str:
+        """Parses a type and returns its Go spelling (Stack<int> becomes Stack[int])"""
+        if self.match(TokenType.MULTIPLY):
+            self.advance()
+            return '*' + self.parse_type(message)
+        
+        elif self.match(TokenType.LBRACKET):
+            self.advance()
+            size = ''
+            if not self.match(TokenType.RBRACKET):
+                size = self.parse_expression_text()
+            self.consume(TokenType.RBRACKET)
+            return f'[{size}]' + self.parse_type(message)
+        
+        elif self.match(TokenType.MAP):
+            self.advance()
+            self.consume(TokenType.LBRACKET)
+            key_type = self.parse_type(message)
+            self.consume(TokenType.RBRACKET)
+            return f'map[{key_type}]' + self.parse_type(message)
+        
+        elif self.match(TokenType.CHAN):
+            self.advance()
+            if self.match(TokenType.LEFT_ARROW):
+                # Send-only: chan<- T
+                self.advance()
+                return 'chan<- ' + self.parse_type(message)
+            return 'chan ' + self.parse_type(message)
+        
+        elif self.match(TokenType.LEFT_ARROW):
+            # Receive-only: <-chan T
+            self.advance()
+            self.consume(TokenType.CHAN, message)
+            return '<-chan ' + self.parse_type(message)
+        
+        elif self.match(TokenType.FUNC):
+            self.advance()
+            self.consume(TokenType.LPAREN)
+            param_types = []
+            while not self.match(TokenType.RPAREN) and self.current_token:
+                param_types.append(self.parse_variadic_type(message))
+                if self.match(TokenType.COMMA):
+                    self.advance()
+                else:
+                    break
+            rparen = self.consume(TokenType.RPAREN)
+            
+            func_type = f'func({", ".join(param_types)})'
+            if self.starts_type() and self.current_token.line == rparen.line:
+                func_type += ' ' + self.parse_type(message)
+            return func_type
+        
+        elif self.match(TokenType.INTERFACE):
+            self.advance()
+            self.consume(TokenType.LBRACE)
+            self.consume(TokenType.RBRACE)
+            return 'interface{}'
+        
+        name = self.parse_qualified_name(message)
+        
+        if self.match(TokenType.LT):
+            type_args = self.parse_type_args()
+            name += '[' + ', '.join(type_args) + ']'
+        
+        if self.is_nullable_marker():
+            # Person? (extension): resolved to a Go type by the transpiler
+            self.advance()
+            name += '?'
+        
+        return name
+    
+    # Tokens that can follow a nullable type; anything else after '?' continues a ternary (x is T ? a : b)
+    NULLABLE_FOLLOWERS = (TokenType.ASSIGN, TokenType.LBRACE, TokenType.RBRACE, TokenType.COMMA, TokenType.RPAREN,
+                          TokenType.GT, TokenType.RIGHT_SHIFT, TokenType.RBRACKET, TokenType.AT, TokenType.SEMICOLON)
+    
+    def is_nullable_marker(self) -> bool:
+        """Checks for the '?' of a nullable type right after its name"""
+        if not self.match(TokenType.QUESTION) or self.current_token.line != self.tokens[self.pos - 1].line:
+            return False
+        following = self.peek()
+        if not following or following.type == TokenType.EOF or following.line != self.current_token.line:
+            return True
+        return following.type in self.NULLABLE_FOLLOWERS or \
+            (following.type == TokenType.IDENTIFIER and following.value in ('get', 'set'))
+    
+    def parse_result_type(self) -> str:
+        """Parses a result type: a single type or a parenthesized list ((V, bool))"""
+        if self.match(TokenType.IDENTIFIER) and self.current_token.value == 'yield' and \
+                self.peek() and self.peek().line == self.current_token.line:
+            # Iterator: yield T -> iter.Seq[T], yield K, V -> iter.Seq2[K, V] (Go 1.23 range-over-func)
+            self.advance()
+            element_types = [self.parse_type("Expected element type")]
+            if self.match(TokenType.COMMA):
+                self.advance()
+                element_types.append(self.parse_type("Expected element type"))
+            seq = 'iter.Seq' if len(element_types) == 1 else 'iter.Seq2'
+            return f'{seq}[' + ', '.join(element_types) + ']'
+        
+        if not self.match(TokenType.LPAREN):
+            return self.parse_type("Expected return type")
+        
+        self.advance()
+        result_types = [self.parse_type("Expected return type")]
+        while self.match(TokenType.COMMA):
+            self.advance()
+            result_types.append(self.parse_type("Expected return type"))
+        self.consume(TokenType.RPAREN)
+        
+        if len(result_types) == 1:
+            return result_types[0]
+        return '(' + ', '.join(result_types) + ')'
+    
+    def parse_expression_text(self) -> str:
+        """Parses an array length (a number or a constant name)"""
+        if self.match(TokenType.NUMBER, TokenType.IDENTIFIER):
+            value = self.current_token.value
+            self.advance()
+            return value
+        raise ParseError(f"Expected array length, found {self.current_token.value if self.current_token else 'EOF'}")
+    
+    def parse_type_args(self) -> List[str]:
+        """Parses generic type arguments (<int, string>)"""
+        self.consume(TokenType.LT)
+        type_args = [self.parse_type("Expected type argument")]
+        
+        while self.match(TokenType.COMMA):
+            self.advance()
+            type_args.append(self.parse_type("Expected type argument"))
+        
+        self.consume_closing_angle()
+        return type_args
+    
+    def consume_closing_angle(self) -> None:
+        """Consumes '>' splitting '>>' when generic argument lists are nested"""
+        if self.match(TokenType.RIGHT_SHIFT):
+            token = self.current_token
+            self.tokens[self.pos] = Token(TokenType.GT, '>', token.line, token.column + 1)
+            self.current_token = self.tokens[self.pos]
+            return
+        
+        self.consume(TokenType.GT, "Expected '>' to close type argument list")
+    
+    def parse_type_params(self) -> List[TypeParam]:
+        """Parses generic type parameters (<T, K comparable>); the default constraint is any"""
+        if not self.match(TokenType.LT):
+            return []
+        
+        self.advance()
+        type_params = []
+        
+        while not self.match(TokenType.GT) and self.current_token:
+            param_name = self.consume(TokenType.IDENTIFIER, "Expected type parameter name").value
+            
+            constraint = 'any'
+            if not self.match(TokenType.COMMA, TokenType.GT):
+                constraint = self.parse_constraint()
+            
+            type_params.append(TypeParam(param_name, constraint))
+            
+            if self.match(TokenType.COMMA):
+                self.advance()
+            else:
+                break
+        
+        self.consume(TokenType.GT, "Expected '>' to close type parameter list")
+        return type_params
+    
+    def parse_constraint(self) -> str:
+        """Parses a constraint union such as ~int | ~float64 | Number"""
+        terms = []
+        
+        while True:
+            tilde = ''
+            if self.match(TokenType.BITWISE_NOT):
+                self.advance()
+                tilde = '~'
+            terms.append(tilde + self.parse_type("Expected constraint"))
+            
+            if not self.match(TokenType.BITWISE_OR):
+                break
+            self.advance()
+        
+        return ' | '.join(terms)
+    
     def parse_block_stmt(self) -> BlockStmt:
         """Parses a block of statements"""
         self.consume(TokenType.LBRACE)
         statements = []
         
         while not self.match(TokenType.RBRACE) and self.current_token:
-            statements.append(self.parse_statement())
+            start_pos = self.start()
+            try:
+                statements.append(self.parse_statement())
+            except ParseError as e:
+                self.recover(e, start_pos)
+            finally:
+                self.finish(start_pos)
         
+        end_comments = self.leading_comments(self.pos) if self.current_token else []
         self.consume(TokenType.RBRACE)
-        return BlockStmt(statements)
+        return BlockStmt(statements, end_comments)
     
     def parse_statement(self) -> Statement:
-        """Parses a statement"""
+        """Parses a statement, positioned at its first token when its kind records no position of its own"""
+        start, start_pos = self.current_token, self.start()
+        try:
+            return self.document(self.locate(self.parse_statement_kind(), start), start_pos)
+        finally:
+            self.finish(start_pos)
+    
+    def parse_statement_kind(self) -> Statement:
+        """Parses a statement by its leading token"""
         if self.match(TokenType.VAR):
             return self.parse_var_stmt()
         elif self.match(TokenType.IF):
@@ -294,14 +1236,18 @@ class Parser:
             return self.parse_for_stmt()
         elif self.match(TokenType.SWITCH):
             return self.parse_switch_stmt()
+        elif self.match(TokenType.SELECT):
+            return self.parse_select_stmt()
         elif self.match(TokenType.RETURN):
             return self.parse_return_stmt()
         elif self.match(TokenType.BREAK):
-            self.advance()
-            return BreakStmt()
+            start = self.consume(TokenType.BREAK)
+            return self.set_position(BreakStmt(self.parse_jump_label(start)), start)
         elif self.match(TokenType.CONTINUE):
-            self.advance()
-            return ContinueStmt()
+            start = self.consume(TokenType.CONTINUE)
+            return self.set_position(ContinueStmt(self.parse_jump_label(start)), start)
+        elif self.match(TokenType.IDENTIFIER) and self.peek() and self.peek().type == TokenType.COLON:
+            return self.parse_labeled_stmt()
         elif self.match(TokenType.GO):
             return self.parse_go_stmt()
         elif self.match(TokenType.DEFER):
@@ -310,36 +1256,101 @@ class Parser:
             return self.parse_try_stmt()
         elif self.match(TokenType.THROW):
             return self.parse_throw_stmt()
+        elif self.is_using_stmt():
+            return self.parse_using_stmt()
+        elif self.is_do_stmt():
+            return self.parse_do_stmt()
+        elif self.is_with_stmt():
+            return self.parse_with_stmt()
+        elif self.is_yield_stmt():
+            return self.parse_yield_stmt()
         elif self.match(TokenType.LBRACE):
             return self.parse_block_stmt()
         else:
             # Expression statement or assignment
-            expr = self.parse_expression()
-            
-            if self.match(TokenType.ASSIGN, TokenType.SHORT_ASSIGN, TokenType.PLUS_ASSIGN, TokenType.MINUS_ASSIGN,
-                         TokenType.MULT_ASSIGN, TokenType.DIV_ASSIGN, TokenType.MOD_ASSIGN):
-                op = self.current_token.value
-                self.advance()
-                value = self.parse_expression()
-                return AssignStmt(expr, value, op)
-            else:
-                return ExpressionStmt(expr)
+            start = self.current_token
+            return self.parse_simple_stmt(self.parse_expression_list(), start)
+    
+    def parse_jump_label(self, keyword: Token) -> Optional[str]:
+        """Parses the label after break/continue (on the same line), if any"""
+        if self.match(TokenType.IDENTIFIER) and self.current_token.line == keyword.line:
+            label = self.current_token.value
+            self.advance()
+            return label
+        return None
+    
+    def parse_labeled_stmt(self) -> LabeledStmt:
+        """Parses outer: for ... (labels mark loops, switches and selects for break/continue)"""
+        start = self.consume(TokenType.IDENTIFIER)
+        self.consume(TokenType.COLON)
+        if not self.match(TokenType.FOR, TokenType.SWITCH, TokenType.SELECT) and not self.is_do_stmt():
+            raise self.error(f"Label {start.value} must mark a for, do, repeat, switch or select statement", start)
+        return self.set_position(LabeledStmt(start.value, self.parse_statement()), start)
+    
+    # Assignment operators: = := += -= *= /= %= &= |= ^= <<= >>= &^=
+    ASSIGN_OPERATORS = (TokenType.ASSIGN, TokenType.SHORT_ASSIGN, TokenType.PLUS_ASSIGN, TokenType.MINUS_ASSIGN,
+                        TokenType.MULT_ASSIGN, TokenType.DIV_ASSIGN, TokenType.MOD_ASSIGN, TokenType.AND_ASSIGN,
+                        TokenType.OR_ASSIGN, TokenType.XOR_ASSIGN, TokenType.SHL_ASSIGN, TokenType.SHR_ASSIGN,
+                        TokenType.AND_NOT_ASSIGN)
+    
+    def parse_simple_stmt(self, expr: Expression, start: Token) -> Statement:
+        """Completes a statement starting with expr: an assignment, x++ / x--, a send (ch <- v), or an
+        expression statement"""
+        if self.match(TokenType.LEFT_ARROW) and self.current_token.line == self.tokens[self.pos - 1].line:
+            self.advance()
+            value = self.parse_expression()
+            self.reject_assignment_value()
+            return self.set_position(SendStmt(expr, value), start)
+        if self.match(*self.ASSIGN_OPERATORS):
+            op = self.current_token.value
+            self.advance()
+            value = self.parse_expression_list()
+            self.reject_assignment_value()
+            return self.set_position(AssignStmt(expr, value, op), start)
+        if isinstance(expr, IncDecExpr):
+            return self.set_position(IncDecStmt(expr.operand, expr.operator), start)
+        return ExpressionStmt(expr)
+    
+    def reject_assignment_value(self) -> None:
+        """Reports an assignment used as a value (f(x += 1), y := x = 2): Go only allows it as a statement"""
+        if self.match(*self.ASSIGN_OPERATORS) and self.current_token.line == self.tokens[self.pos - 1].line:
+            raise self.error(f"Assignment {self.current_token.value} is a statement in Go and cannot be used as a "
+                             "value")
+    
+    def is_yield_stmt(self) -> bool:
+        """Checks for 'yield' followed by a value on the same line ('yield' is contextual)"""
+        if not (self.match(TokenType.IDENTIFIER) and self.current_token.value == 'yield'):
+            return False
+        following = self.peek()
+        return following is not None and following.line == self.current_token.line and following.type not in (
+            *self.ASSIGN_OPERATORS, TokenType.INCREMENT, TokenType.DECREMENT, TokenType.DOT,
+            TokenType.COMMA, TokenType.LBRACKET, TokenType.RBRACE)
+    
+    def parse_yield_stmt(self) -> YieldStmt:
+        """Parses yield v or yield k, v"""
+        start = self.current_token
+        self.advance()  # 'yield' (contextual)
+        values = [self.parse_expression()]
+        while self.match(TokenType.COMMA):
+            self.advance()
+            values.append(self.parse_expression())
+        return self.set_position(YieldStmt(values), start)
     
     def parse_var_stmt(self) -> VarStmt:
         """Parses a variable statement"""
-        self.consume(TokenType.VAR)
+        start = self.consume(TokenType.VAR)
         name = self.consume(TokenType.IDENTIFIER, "Expected variable name").value
         
         type_name = None
         if not self.match(TokenType.ASSIGN):
-            type_name = self.consume(TokenType.IDENTIFIER, "Expected variable type").value
+            type_name = self.parse_type("Expected variable type")
         
         value = None
         if self.match(TokenType.ASSIGN):
             self.advance()
             value = self.parse_expression()
         
-        return VarStmt(name, type_name, value)
+        return self.set_position(VarStmt(name, type_name, value), start)
     
     def parse_if_stmt(self) -> IfStmt:
         """Parses an if statement"""
@@ -358,10 +1369,26 @@ class Parser:
         """Parses a for statement"""
         self.consume(TokenType.FOR)
         
+        # for item in items / for key, value in m ('in' is contextual)
+        in_offset = 3 if self.peek(1) and self.peek(1).type == TokenType.COMMA else 1
+        following = self.peek(in_offset)
+        if self.match(TokenType.IDENTIFIER) and following and following.type == TokenType.IDENTIFIER and \
+                following.value == 'in' and (in_offset == 1 or self.peek(2).type == TokenType.IDENTIFIER):
+            start = self.current_token
+            variables = [self.current_token.value]
+            self.advance()
+            if self.match(TokenType.COMMA):
+                self.advance()
+                variables.append(self.consume(TokenType.IDENTIFIER).value)
+            self.advance()  # 'in'
+            iterable = self.parse_expression()
+            body = self.parse_statement()
+            return self.set_position(ForInStmt(variables, iterable, body), start)
+        
         # Check if it's a for range
         if self.match(TokenType.IDENTIFIER):
             # Could be for range or normal for
-            checkpoint = self.pos
+            checkpoint = self.mark()
             
             try:
                 # Try to parse as range
@@ -373,19 +1400,18 @@ class Parser:
                     self.advance()
                     value = self.consume(TokenType.IDENTIFIER).value
                 
-                self.consume(TokenType.COLON)
-                self.consume(TokenType.ASSIGN)
+                self.consume(TokenType.SHORT_ASSIGN)
                 self.consume(TokenType.RANGE)
-                
-                iterable = self.parse_expression()
-                body = self.parse_statement()
-                
-                return RangeStmt(key, value, iterable, body)
             
             except ParseError:
                 # Go back to checkpoint and try normal for
-                self.pos = checkpoint
-                self.current_token = self.tokens[self.pos]
+                self.reset(checkpoint)
+            
+            else:
+                self.release(checkpoint)
+                iterable = self.parse_expression()
+                body = self.parse_statement()
+                return RangeStmt(key, value, iterable, body)
         
         # Normal for
         init = None
@@ -444,19 +1470,70 @@ class Parser:
                     body.append(self.parse_statement())
                 
                 default_case = DefaultStmt(body)
+            
+            else:
+                raise self.error("Expected 'case' or 'default' in switch")
         
         self.consume(TokenType.RBRACE)
         return SwitchStmt(expression, cases, default_case)
     
+    def parse_select_stmt(self) -> SelectStmt:
+        """Parses a select statement; each case is a send or a receive, optionally assigned"""
+        start = self.consume(TokenType.SELECT)
+        self.consume(TokenType.LBRACE)
+        
+        cases = []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            case_start = self.current_token
+            if self.match(TokenType.DEFAULT):
+                self.advance()
+                if any(case.comm is None for case in cases):
+                    raise self.error("Multiple defaults in select", case_start)
+                comm = None
+            else:
+                self.consume(TokenType.CASE, f"Expected 'case' or 'default' in select at line {case_start.line}")
+                comm_start = self.current_token
+                comm = self.parse_simple_stmt(self.parse_expression_list(), comm_start)
+                if not self.is_select_comm(comm):
+                    raise self.error("Select case must be a send (ch <- v) or a receive (<-ch, v := <-ch)", comm_start)
+            self.consume(TokenType.COLON)
+            
+            body = []
+            while not self.match(TokenType.CASE, TokenType.DEFAULT, TokenType.RBRACE) and self.current_token:
+                body.append(self.parse_statement())
+            
+            cases.append(self.set_position(SelectCase(comm, body), case_start))
+        
+        self.consume(TokenType.RBRACE)
+        return self.set_position(SelectStmt(cases), start)
+    
+    def is_select_comm(self, comm: Statement) -> bool:
+        """Checks for the statements a select case allows: ch <- v, <-ch, and <-ch assigned with = or :="""
+        if isinstance(comm, SendStmt):
+            return True
+        if isinstance(comm, ExpressionStmt):
+            value = comm.expression
+        elif isinstance(comm, AssignStmt) and comm.operator in ('=', ':='):
+            targets = comm.target.elements if isinstance(comm.target, TupleExpr) else [comm.target]
+            if len(targets) > 2:
+                return False
+            value = comm.value
+        else:
+            return False
+        return isinstance(value, UnaryExpr) and value.operator == '<-'
+    
     def parse_return_stmt(self) -> ReturnStmt:
         """Parses a return statement"""
-        self.consume(TokenType.RETURN)
+        start = self.consume(TokenType.RETURN)
         
         value = None
-        if not self.match(TokenType.RBRACE, TokenType.SEMICOLON) and self.current_token:
-            value = self.parse_expression()
+        # As in Go, a value starts on the line of the return: a bare return ends at the newline
+        if not self.match(TokenType.RBRACE, TokenType.SEMICOLON) and self.current_token and \
+                self.current_token.line == start.line:
+            value = self.parse_expression_list()
+            self.reject_assignment_value()
         
-        return ReturnStmt(value)
+        return self.set_position(ReturnStmt(value), start)
     
     def parse_go_stmt(self) -> GoStmt:
         """Parses a go statement"""
@@ -470,13 +1547,13 @@ class Parser:
     
     def parse_defer_stmt(self) -> DeferStmt:
         """Parses a defer statement"""
-        self.consume(TokenType.DEFER)
+        start = self.consume(TokenType.DEFER)
         call = self.parse_expression()
         
         if not isinstance(call, CallExpr):
             raise ParseError("Defer statement must be followed by a function call")
         
-        return DeferStmt(call)
+        return self.set_position(DeferStmt(call), start)
     
     def parse_try_stmt(self) -> TryStmt:
         """Parses a try statement (extension)"""
@@ -495,7 +1572,7 @@ class Parser:
     
     def parse_catch_stmt(self) -> CatchStmt:
         """Parses a catch statement (extension)"""
-        self.consume(TokenType.CATCH)
+        start = self.consume(TokenType.CATCH)
         
         exception_type = None
         exception_var = None
@@ -515,7 +1592,7 @@ class Parser:
             self.consume(TokenType.RPAREN)
         
         body = self.parse_block_stmt()
-        return CatchStmt(exception_type, exception_var, body)
+        return self.set_position(CatchStmt(exception_type, exception_var, body), start)
     
     def parse_finally_stmt(self) -> FinallyStmt:
         """Parses a finally statement (extension)"""
@@ -529,112 +1606,218 @@ class Parser:
         expression = self.parse_expression()
         return ThrowStmt(expression)
     
+    def is_using_stmt(self) -> bool:
+        """Checks for 'using (name :=' ('using' is contextual, so using(x) stays a call)"""
+        return (self.match(TokenType.IDENTIFIER) and self.current_token.value == 'using' and
+                self.peek() is not None and self.peek().type == TokenType.LPAREN and
+                self.peek(2) is not None and self.peek(2).type == TokenType.IDENTIFIER and
+                self.peek(3) is not None and self.peek(3).type == TokenType.SHORT_ASSIGN)
+    
+    def parse_using_stmt(self) -> UsingStmt:
+        """Parses a using statement (extension)"""
+        start = self.current_token
+        self.advance()  # 'using'
+        self.consume(TokenType.LPAREN)
+        name = self.consume(TokenType.IDENTIFIER, "Expected resource name").value
+        self.consume(TokenType.SHORT_ASSIGN)
+        value = self.parse_expression()
+        self.consume(TokenType.RPAREN, "Expected ')' after using resource")
+        body = self.parse_block_stmt()
+        return self.set_position(UsingStmt(name, value, body), start)
+    
+    def is_with_stmt(self) -> bool:
+        """Checks for 'with (...) {' ('with' is contextual, so a call with(x) stays a call)"""
+        if not (self.match(TokenType.IDENTIFIER) and self.current_token.value == 'with' and
+                self.peek() is not None and self.peek().type == TokenType.LPAREN):
+            return False
+        depth, offset = 0, 1
+        while self.peek(offset) is not None:
+            token = self.peek(offset)
+            if token.type == TokenType.LPAREN:
+                depth += 1
+            elif token.type == TokenType.RPAREN:
+                depth -= 1
+                if depth == 0:
+                    after = self.peek(offset + 1)
+                    return after is not None and after.type == TokenType.LBRACE and after.line == token.line
+            offset += 1
+        return False
+    
+    def parse_with_stmt(self) -> WithStmt:
+        """Parses a with statement (extension): with (mu.Lock()) { ... } or with (name := value) { ... }"""
+        start = self.current_token
+        self.advance()  # 'with'
+        self.consume(TokenType.LPAREN)
+        name = None
+        if self.match(TokenType.IDENTIFIER) and self.peek() and self.peek().type == TokenType.SHORT_ASSIGN:
+            name = self.consume(TokenType.IDENTIFIER).value
+            self.consume(TokenType.SHORT_ASSIGN)
+        value = self.parse_expression()
+        self.consume(TokenType.RPAREN, "Expected ')' after with expression")
+        body = self.parse_block_stmt()
+        return self.set_position(WithStmt(value, body, name), start)
+    
+    def is_do_stmt(self) -> bool:
+        """Checks for 'do {' or 'repeat {' (both contextual, so they stay usable as names)"""
+        return (self.match(TokenType.IDENTIFIER) and self.current_token.value in ('do', 'repeat') and
+                self.peek() is not None and self.peek().type == TokenType.LBRACE)
+    
+    def parse_do_stmt(self) -> DoWhileStmt:
+        """Parses a do-while loop (extension): do { ... } while (cond) or repeat { ... } until (cond)"""
+        start = self.current_token
+        self.advance()  # 'do' or 'repeat'
+        until = start.value == 'repeat'
+        closing = 'until' if until else 'while'
+        body = self.parse_block_stmt()
+        if not (self.match(TokenType.IDENTIFIER) and self.current_token.value == closing):
+            raise self.error(f"Expected '{closing}' after {start.value} block", start)
+        self.advance()
+        condition = self.parse_expression()
+        return self.set_position(DoWhileStmt(body, condition, until), start)
+    
     def parse_expression(self) -> Expression:
         """Parses an expression (lowest precedence)"""
-        return self.parse_logical_or()
+        return self.parse_ternary()
     
-    def parse_logical_or(self) -> Expression:
-        """Parses logical OR"""
-        expr = self.parse_logical_and()
-        
-        while self.match(TokenType.OR):
-            op = self.current_token.value
-            self.advance()
-            right = self.parse_logical_and()
-            expr = BinaryExpr(expr, op, right)
+    def parse_ternary(self) -> Expression:
+        """Parses cond ? a : b (extension, right-associative: a ? b : c ? d : e)"""
+        expr = self.parse_coalesce()
+        if not self.match(TokenType.QUESTION):
+            return expr
         
-        return expr
+        start = self.current_token
+        self.advance()
+        then_expr = self.parse_ternary()
+        self.consume(TokenType.COLON, "Expected ':' in conditional expression")
+        else_expr = self.parse_ternary()
+        return self.set_position(TernaryExpr(expr, then_expr, else_expr), start)
     
-    def parse_logical_and(self) -> Expression:
-        """Parses logical AND"""
-        expr = self.parse_equality()
-        
-        while self.match(TokenType.AND):
-            op = self.current_token.value
-            self.advance()
-            right = self.parse_equality()
-            expr = BinaryExpr(expr, op, right)
+    def parse_coalesce(self) -> Expression:
+        """Parses value ?? fallback (extension, right-associative)"""
+        expr = self.parse_binary()
+        if not self.match(TokenType.COALESCE):
+            return expr
         
-        return expr
+        start = self.current_token
+        self.advance()
+        return self.set_position(CoalesceExpr(expr, self.parse_coalesce()), start)
     
-    def parse_equality(self) -> Expression:
-        """Parses equality"""
-        expr = self.parse_comparison()
-        
-        while self.match(TokenType.EQ, TokenType.NE):
-            op = self.current_token.value
-            self.advance()
-            right = self.parse_comparison()
-            expr = BinaryExpr(expr, op, right)
-        
-        return expr
+    # fmt verb after the last ':' of an interpolation: ${price:.2f}, ${id:05d}, ${name:q}
+    FORMAT_SPEC = re.compile(r'(.*):([-+#0]*\d*(?:\.\d+)?[vTtbcdoOqxXUeEfFgGsp])$', re.S)
     
-    def parse_comparison(self) -> Expression:
-        """Parses comparison"""
-        expr = self.parse_addition()
-        
-        while self.match(TokenType.LT, TokenType.LE, TokenType.GT, TokenType.GE):
-            op = self.current_token.value
-            self.advance()
-            right = self.parse_addition()
-            expr = BinaryExpr(expr, op, right)
+    def parse_template_string(self) -> InterpolatedString:
+        """Parses "Hi ${name}, you owe ${total:.2f}" into string and Interpolation parts"""
+        token = self.current_token
+        self.advance()
         
-        return expr
+        parts = []
+        for kind, text in split_template(token.value):
+            if kind == 'text':
+                parts.append(Literal(text, 'string'))
+                continue
+            source, spec = text, None
+            match = self.FORMAT_SPEC.match(text)
+            if match:
+                source, spec = match.group(1), match.group(2)
+            if not source.strip():
+                raise self.error("Empty interpolation in string", token)
+            
+            # Positions inside the interpolation are reported at the string
+            lexer = Lexer(source, file=self.file)
+            lexer.line, lexer.column = token.line, token.column
+            parser = Parser(lexer.tokenize(), self.file)
+            try:
+                expr = parser.parse_expression()
+                if parser.errors:
+                    raise ParseError('; '.join(parser.errors), *parser.errors)
+                if not parser.match(TokenType.EOF):
+                    raise parser.error(f"Unexpected {parser.current_token.value}")
+            except ParseError as e:
+                problems = '; '.join(diagnostic.message for diagnostic in e.diagnostics)
+                raise self.error(f"Invalid interpolation ${{{text}}} in string: {problems}", token)
+            parts.append(Interpolation(expr, spec))
+        return self.set_position(InterpolatedString(parts), token)
     
-    def parse_addition(self) -> Expression:
-        """Parses addition/subtraction"""
-        expr = self.parse_multiplication()
+    def parse_expression_list(self) -> Expression:
+        """Parses one expression or a comma-separated list of them (as a TupleExpr)"""
+        expr = self.parse_expression()
+        if not self.match(TokenType.COMMA):
+            return expr
         
-        while self.match(TokenType.PLUS, TokenType.MINUS):
-            op = self.current_token.value
+        elements = [expr]
+        while self.match(TokenType.COMMA):
             self.advance()
-            right = self.parse_multiplication()
-            expr = BinaryExpr(expr, op, right)
-        
-        return expr
+            elements.append(self.parse_expression())
+        return TupleExpr(elements)
+    
+    def binary_operator(self) -> Optional[str]:
+        """Returns the binary operator at the current token; one starting a new line ends the expression, as in Go"""
+        token = self.current_token
+        if token.value not in BINARY_PRECEDENCE or OPERATORS.get(token.value) != token.type:
+            return None
+        return token.value if token.line == self.tokens[self.pos - 1].line else None
     
-    def parse_multiplication(self) -> Expression:
-        """Parses multiplication/division/modulo"""
+    def is_type_test(self) -> bool:
+        """Checks for the contextual type operators 'is', 'as' and 'as?'"""
+        return self.match(TokenType.IDENTIFIER) and self.current_token.value in ('is', 'as')
+    
+    # Type tests apply to operands at or above this level: a + b as T is (a + b) as T, x == y as T is x == (y as T)
+    TYPE_TEST_PRECEDENCE = 4
+    
+    def parse_binary(self, min_precedence: int = 1) -> Expression:
+        """Parses binary operators by precedence climbing (BINARY_PRECEDENCE); 'is' and 'as' bind looser than
+        + and tighter than comparisons"""
         expr = self.parse_unary()
         
-        while self.match(TokenType.MULTIPLY, TokenType.DIVIDE, TokenType.MODULO):
-            op = self.current_token.value
+        while True:
+            if self.is_type_test() and min_precedence <= self.TYPE_TEST_PRECEDENCE:
+                start = self.current_token
+                operator = start.value
+                self.advance()
+                if operator == 'is':
+                    expr = self.set_position(IsExpr(expr, self.parse_type("Expected type after 'is'")), start)
+                    continue
+                safe = self.match(TokenType.QUESTION)
+                if safe:
+                    self.advance()
+                expr = self.set_position(CastExpr(expr, self.parse_type("Expected type after 'as'"), safe), start)
+                continue
+            
+            op = self.binary_operator()
+            if not op or BINARY_PRECEDENCE[op] < min_precedence:
+                break
+            start = self.current_token
             self.advance()
-            right = self.parse_unary()
-            expr = BinaryExpr(expr, op, right)
+            right = self.parse_binary(BINARY_PRECEDENCE[op] + 1)
+            expr = self.set_position(BinaryExpr(expr, op, right), start)
         
         return expr
     
     def parse_unary(self) -> Expression:
-        """Parses unary expression"""
-        if self.match(TokenType.NOT, TokenType.MINUS, TokenType.PLUS):
-            op = self.current_token.value
+        """Parses unary expression (! - + ^, & and * for addresses and pointers, and <- for channel receives)"""
+        if self.match(TokenType.NOT, TokenType.MINUS, TokenType.PLUS, TokenType.BITWISE_XOR,
+                      TokenType.BITWISE_AND, TokenType.MULTIPLY, TokenType.LEFT_ARROW):
+            start = self.current_token
+            op = start.value
             self.advance()
             expr = self.parse_unary()
-            return UnaryExpr(op, expr)
+            return self.set_position(UnaryExpr(op, expr), start)
         
         return self.parse_postfix()
     
     def parse_postfix(self) -> Expression:
         """Parses postfix expression (calls, indexes, selectors)"""
-        expr = self.parse_primary()
+        start = self.current_token
+        expr = self.locate(self.parse_primary(), start)
         
         while True:
-            if self.match(TokenType.LPAREN):
+            # A '(' starting a new line begins the next statement ((a, b) := ...), not a call
+            if self.match(TokenType.LPAREN) and self.current_token.line == self.tokens[self.pos - 1].line:
                 # Function call
-                self.advance()
-                args = []
-                
-                while not self.match(TokenType.RPAREN) and self.current_token:
-                    args.append(self.parse_expression())
-                    
-                    if self.match(TokenType.COMMA):
-                        self.advance()
-                    else:
-                        break
-                
-                self.consume(TokenType.RPAREN)
-                expr = CallExpr(expr, args)
+                allow_lambda, self.allow_lambda = self.allow_lambda, True
+                args, spread = self.parse_call_args()
+                self.allow_lambda = allow_lambda
+                expr = self.set_position(CallExpr(expr, args, spread), start)
             
             elif self.match(TokenType.LBRACKET):
                 # Index access
@@ -645,36 +1828,117 @@ class Parser:
             
             elif self.match(TokenType.DOT):
                 # Selector
+                start = self.current_token
                 self.advance()
                 field = self.consume(TokenType.IDENTIFIER, "Expected field name").value
-                expr = SelectorExpr(expr, field)
+                expr = self.set_position(SelectorExpr(expr, field), start)
+            
+            elif self.match(TokenType.OPTIONAL_DOT):
+                # Optional chaining: student?.GetSchool()
+                start = self.current_token
+                self.advance()
+                field = self.consume(TokenType.IDENTIFIER, "Expected field name after '?.'").value
+                expr = self.set_position(SelectorExpr(expr, field, optional=True), start)
+            
+            elif self.match(TokenType.INCREMENT, TokenType.DECREMENT) and \
+                    self.current_token.line == self.tokens[self.pos - 1].line:
+                # x++ / x-- (a statement; parse_simple_stmt turns it into IncDecStmt)
+                start = self.current_token
+                self.advance()
+                expr = self.set_position(IncDecExpr(expr, start.value), start)
+            
+            elif self.match(TokenType.IDENTIFIER) and self.current_token.value == 'with' and \
+                    self.peek() and self.peek().type == TokenType.LBRACE:
+                # Record copy: p with { age: 30 }
+                self.advance()
+                self.consume(TokenType.LBRACE)
+                updates = []
+                while not self.match(TokenType.RBRACE) and self.current_token:
+                    name = self.consume(TokenType.IDENTIFIER, "Expected field name").value
+                    self.consume(TokenType.COLON, "Expected ':' after field name")
+                    updates.append((name, self.parse_expression()))
+                    if self.match(TokenType.COMMA):
+                        self.advance()
+                    else:
+                        break
+                self.consume(TokenType.RBRACE)
+                expr = WithExpr(expr, updates)
             
             else:
                 break
         
         return expr
     
+    def parse_call_args(self) -> Tuple[List[Expression], bool]:
+        """Parses (args) of a call or new; a trailing ... spreads the last argument (f(a, rest...))"""
+        self.consume(TokenType.LPAREN)
+        args, spread = [], False
+        while not self.match(TokenType.RPAREN) and self.current_token:
+            args.append(self.parse_expression())
+            self.reject_assignment_value()
+            if self.match(TokenType.ELLIPSIS):
+                spread = True
+                self.advance()
+                if self.match(TokenType.COMMA):
+                    self.advance()
+                if not self.match(TokenType.RPAREN):
+                    raise self.error("Only the last argument can be spread with ...")
+                break
+            
+            if self.match(TokenType.COMMA):
+                self.advance()
+            else:
+                break
+        self.consume(TokenType.RPAREN)
+        return args, spread
+    
     def parse_primary(self) -> Expression:
         """Parse primary expression"""
-        if self.match(TokenType.IDENTIFIER):
-            name = self.current_token.value
+        if self.is_match_expr():
+            return self.parse_match_expr()
+        
+        elif self.is_lambda():
+            return self.parse_lambda()
+        
+        elif self.match(TokenType.IDENTIFIER):
+            start = self.current_token
             self.advance()
-            return Identifier(name)
+            return self.set_position(Identifier(start.value), start)
         
         elif self.match(TokenType.NUMBER):
-            value = self.current_token.value
+            start = self.current_token
+            text, go_type = split_number(self.current_token.value)
             self.advance()
             
-            if '.' in value:
-                return Literal(float(value), 'float')
-            else:
-                return Literal(int(value), 'int')
+            if not text.startswith('0x') and ('.' in text or 'e' in text):
+                if go_type and not go_type.startswith('float'):
+                    raise self.error(f"Floating-point literal {start.value} cannot have an integer type suffix", start)
+                return self.set_position(Literal(float(text), 'float', go_type=go_type, text=text), start)
+            try:
+                # A leading 0 means octal, as in Go (0755)
+                value = int(text, 8) if re.fullmatch(r'0\d+', text) else int(text, 0)
+            except ValueError:
+                raise self.error(f"Invalid octal literal {start.value}", start)
+            return self.set_position(Literal(value, 'int', go_type=go_type, text=text), start)
         
         elif self.match(TokenType.STRING):
             value = self.current_token.value
             self.advance()
             return Literal(value, 'string')
         
+        elif self.match(TokenType.CHAR):
+            value = self.current_token.value
+            self.advance()
+            return Literal(value, 'char')
+        
+        elif self.match(TokenType.RAW_STRING):
+            value = self.current_token.value
+            self.advance()
+            return Literal(value, 'string', raw=True)
+        
+        elif self.match(TokenType.TEMPLATE_STRING):
+            return self.parse_template_string()
+        
         elif self.match(TokenType.BOOLEAN):
             value = self.current_token.value == 'true'
             self.advance()
@@ -683,38 +1947,389 @@ class Parser:
         elif self.match(TokenType.NEW):
             return self.parse_new_expr()
         
+        elif self.match(TokenType.SWITCH):
+            return self.parse_switch_expr()
+        
+        elif self.is_comprehension():
+            return self.parse_comprehension()
+        
+        elif self.match(TokenType.MAP, TokenType.CHAN, TokenType.LBRACKET):
+            start = self.current_token
+            key_type = None
+            if self.match(TokenType.MAP):
+                self.advance()
+                self.consume(TokenType.LBRACKET)
+                key_type = self.parse_type()
+                self.consume(TokenType.RBRACKET)
+                value_type = self.parse_type()
+                type_name = f'map[{key_type}]{value_type}'
+            else:
+                type_name = self.parse_type()
+            if not (self.match(TokenType.LBRACE) and self.current_token.line == self.tokens[self.pos - 1].line and
+                    type_name.startswith(('[', 'map['))):
+                return TypeExpr(type_name)
+            
+            # Composite literal: []int{1, 2}, map[string]int{"a": 1}
+            literal = self.parse_composite_literal()
+            if key_type is None and isinstance(literal, MapLiteral):
+                raise self.error(f"Literal of {type_name} lists key: value pairs, but only maps have keys", start)
+            if key_type is not None and isinstance(literal, ArrayLiteral):
+                if literal.elements:
+                    raise self.error(f"Literal of {type_name} must list key: value pairs", start)
+                literal = MapLiteral([])
+            if isinstance(literal, MapLiteral):
+                literal.key_type, literal.value_type = key_type, value_type
+            else:
+                literal.type = type_name
+            return self.set_position(literal, start)
+        
         elif self.match(TokenType.THIS):
-            self.advance()
-            return ThisExpr()
+            return self.set_position(ThisExpr(), self.consume(TokenType.THIS))
         
         elif self.match(TokenType.SUPER):
-            self.advance()
-            return SuperExpr()
+            return self.set_position(SuperExpr(), self.consume(TokenType.SUPER))
         
         elif self.match(TokenType.LPAREN):
+            start = self.current_token
             self.advance()
             expr = self.parse_expression()
+            if self.match(TokenType.COMMA):
+                # Tuple literal: (name, age) := p.NameAndAge() / return (name, age)
+                elements = [expr]
+                while self.match(TokenType.COMMA):
+                    self.advance()
+                    elements.append(self.parse_expression())
+                self.consume(TokenType.RPAREN, "Expected ')' to close tuple")
+                return self.set_position(TupleExpr(elements), start)
             self.consume(TokenType.RPAREN)
             return expr
         
         else:
             raise ParseError(f"Unrecognized expression: {self.current_token.value if self.current_token else 'EOF'}")
     
-    def parse_new_expr(self) -> NewExpr:
-        """Parse new expression (extension)"""
-        self.consume(TokenType.NEW)
-        class_name = self.consume(TokenType.IDENTIFIER, "Expected class name").value
+    def parse_composite_literal(self) -> Union[ArrayLiteral, MapLiteral]:
+        """Parses the braces of a composite literal: {1, 2} or {"a": 1}; elements may themselves be braces
+        without a type ([][]int{{1, 2}}, []*Person{{"Ann", 30}}), typed from the enclosing literal"""
+        start = self.consume(TokenType.LBRACE)
+        elements, pairs = [], []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            element = self.parse_composite_element()
+            if self.match(TokenType.COLON) and not elements:
+                self.advance()
+                pairs.append((element, self.parse_composite_element()))
+            elif pairs:
+                raise self.error("Expected ':' after key in literal")
+            else:
+                elements.append(element)
+            if self.match(TokenType.COMMA):
+                self.advance()
+            else:
+                break
+        self.consume(TokenType.RBRACE, f"Expected '}}' to close the literal opened at line {start.line}")
+        literal = MapLiteral(pairs) if pairs else ArrayLiteral(elements)
+        return self.set_position(literal, start)
+    
+    def parse_composite_element(self) -> Expression:
+        """Parses an element, key or value of a composite literal"""
+        if self.match(TokenType.LBRACE):
+            return self.parse_composite_literal()
+        return self.parse_expression()
+    
+    def is_match_expr(self) -> bool:
+        """Checks for 'match subject {' followed by a first arm ('match' is contextual, so match(x) stays a call)"""
+        if not (self.match(TokenType.IDENTIFIER) and self.current_token.value == 'match'):
+            return False
+        checkpoint = self.mark()
+        self.speculating += 1
+        try:
+            self.advance()
+            self.parse_expression()
+            self.consume(TokenType.LBRACE)
+            if self.match(TokenType.RBRACE):
+                return True
+            self.parse_pattern_list()
+            return self.match(TokenType.IF, TokenType.ARROW)
+        except ParseError:
+            return False
+        finally:
+            self.speculating -= 1
+            self.reset(checkpoint)
+    
+    def parse_match_expr(self) -> MatchExpr:
+        """Parses match subject { patterns [if guard] -> value or { block }, ... }"""
+        start = self.current_token
+        self.advance()  # 'match' (contextual)
+        subject = self.parse_expression()
+        self.consume(TokenType.LBRACE, "Expected '{' after match subject")
+        
+        arms = []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            arm_start = self.current_token
+            patterns = self.parse_pattern_list()
+            guard = None
+            if self.match(TokenType.IF):
+                self.advance()
+                guard = self.parse_without_lambda()
+            self.consume(TokenType.ARROW, f"Expected '->' after match pattern at line {arm_start.line}")
+            body = self.parse_block_stmt() if self.match(TokenType.LBRACE) else self.parse_expression()
+            arms.append(self.set_position(MatchArm(patterns, body, guard), arm_start))
+            
+            if self.match(TokenType.COMMA, TokenType.SEMICOLON):
+                self.advance()
         
-        self.consume(TokenType.LPAREN)
-        args = []
+        self.consume(TokenType.RBRACE, "Expected '}' to close match")
+        return self.set_position(MatchExpr(subject, arms), start)
+    
+    def parse_switch_expr(self) -> MatchExpr:
+        """Parses switch level { case A, B -> value; default -> value } (a match over value patterns)"""
+        start = self.current_token
+        self.consume(TokenType.SWITCH)
+        subject = self.parse_expression()
+        self.consume(TokenType.LBRACE, "Expected '{' after switch expression subject")
         
-        while not self.match(TokenType.RPAREN) and self.current_token:
-            args.append(self.parse_expression())
+        arms = []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            arm_start = self.current_token
+            if self.match(TokenType.DEFAULT):
+                self.advance()
+                patterns = [self.set_position(WildcardPattern(), arm_start)]
+            else:
+                self.consume(TokenType.CASE, "Expected 'case' or 'default' in switch expression")
+                patterns = [self.set_position(ValuePattern(self.parse_without_lambda()), arm_start)]
+                while self.match(TokenType.COMMA):
+                    self.advance()
+                    patterns.append(self.set_position(ValuePattern(self.parse_without_lambda()), arm_start))
+            self.consume(TokenType.ARROW, f"Expected '->' after case in switch expression at line {arm_start.line}")
+            arms.append(self.set_position(MatchArm(patterns, self.parse_expression()), arm_start))
             
-            if self.match(TokenType.COMMA):
+            if self.match(TokenType.SEMICOLON, TokenType.COMMA):
+                self.advance()
+        
+        self.consume(TokenType.RBRACE, "Expected '}' to close switch expression")
+        return self.set_position(MatchExpr(subject, arms, is_switch=True), start)
+    
+    def parse_without_lambda(self) -> Expression:
+        """Parses an expression followed by '->' that is not a lambda (case A -> ..., if guard -> ...)"""
+        allow_lambda, self.allow_lambda = self.allow_lambda, False
+        try:
+            return self.parse_expression()
+        finally:
+            self.allow_lambda = allow_lambda
+    
+    def is_comprehension(self) -> bool:
+        """Checks for [element for ...] or {key: element for ...} (so []int and [5]int stay types)"""
+        if not self.match(TokenType.LBRACKET, TokenType.LBRACE):
+            return False
+        is_map = self.match(TokenType.LBRACE)
+        checkpoint = self.mark()
+        self.speculating += 1
+        try:
+            self.advance()
+            if self.match(TokenType.RBRACKET, TokenType.RBRACE):
+                return False
+            self.parse_expression()
+            if is_map:
+                self.consume(TokenType.COLON)
+                self.parse_expression()
+            return self.match(TokenType.FOR)
+        except ParseError:
+            return False
+        finally:
+            self.speculating -= 1
+            self.reset(checkpoint)
+    
+    def parse_comprehension(self) -> ComprehensionExpr:
+        """Parses [element for x in xs if cond] or {key: value for k, v in m if cond}"""
+        start = self.current_token
+        is_map = self.match(TokenType.LBRACE)
+        self.advance()
+        key = None
+        element = self.parse_expression()
+        if is_map:
+            self.consume(TokenType.COLON)
+            key, element = element, self.parse_expression()
+        
+        self.consume(TokenType.FOR)
+        variables = [self.consume(TokenType.IDENTIFIER, "Expected variable after 'for' in comprehension").value]
+        if self.match(TokenType.COMMA):
+            self.advance()
+            variables.append(self.consume(TokenType.IDENTIFIER, "Expected second variable in comprehension").value)
+        if not (self.match(TokenType.IDENTIFIER) and self.current_token.value == 'in'):
+            raise self.error("Expected 'in' in comprehension")
+        self.advance()
+        iterable = self.parse_expression()
+        
+        condition = None
+        if self.match(TokenType.IF):
+            self.advance()
+            condition = self.parse_expression()
+        if is_map:
+            self.consume(TokenType.RBRACE, "Expected '}' to close map comprehension")
+        else:
+            self.consume(TokenType.RBRACKET, "Expected ']' to close list comprehension")
+        return self.set_position(ComprehensionExpr(element, variables, iterable, condition, key), start)
+    
+    def is_lambda(self) -> bool:
+        """Checks for x -> or (params) -> at the current token"""
+        if not self.allow_lambda:
+            return False
+        if self.match(TokenType.IDENTIFIER):
+            following = self.peek()
+            return following is not None and following.type == TokenType.ARROW
+        if not self.match(TokenType.LPAREN):
+            return False
+        depth = 0
+        offset = 0
+        while self.peek(offset):
+            token = self.peek(offset)
+            if token.type == TokenType.LPAREN:
+                depth += 1
+            elif token.type == TokenType.RPAREN:
+                depth -= 1
+                if depth == 0:
+                    following = self.peek(offset + 1)
+                    return following is not None and following.type == TokenType.ARROW
+            offset += 1
+        return False
+    
+    def parse_lambda(self) -> LambdaExpr:
+        """Parses x -> expr, (a, b) -> expr or (x int) -> { block }; omitted types come from the target"""
+        start = self.current_token
+        params = []
+        if self.match(TokenType.IDENTIFIER):
+            params.append(Parameter(self.current_token.value, None))
+            self.advance()
+        else:
+            self.consume(TokenType.LPAREN)
+            pending = []
+            while not self.match(TokenType.RPAREN) and self.current_token:
+                pending.append(self.consume(TokenType.IDENTIFIER, "Expected lambda parameter name").value)
+                if not self.match(TokenType.COMMA, TokenType.RPAREN):
+                    param_type = self.parse_variadic_type("Expected lambda parameter type")
+                    params.extend(Parameter(name, param_type) for name in pending)
+                    pending = []
+                if self.match(TokenType.COMMA):
+                    self.advance()
+                else:
+                    break
+            self.consume(TokenType.RPAREN, "Expected ')' after lambda parameters")
+            if pending and params:
+                raise self.error(f"Expected type for lambda parameter {pending[-1]}", start)
+            params.extend(Parameter(name, None) for name in pending)
+        
+        self.consume(TokenType.ARROW)
+        allow_lambda, self.allow_lambda = self.allow_lambda, True
+        if self.match(TokenType.LBRACE):
+            body = self.parse_block_stmt()
+        else:
+            # An assignment or x++ body is a statement: () -> count++ is () -> { count++ }
+            body_start = self.current_token
+            body = self.parse_expression()
+            if self.match(*self.ASSIGN_OPERATORS):
+                op = self.current_token.value
                 self.advance()
+                body = BlockStmt([self.set_position(AssignStmt(body, self.parse_expression(), op), body_start)])
+            elif isinstance(body, IncDecExpr):
+                body = BlockStmt([self.parse_simple_stmt(body, body_start)])
+        self.allow_lambda = allow_lambda
+        return self.set_position(LambdaExpr(params, body), start)
+    
+    def parse_pattern_list(self) -> List[Pattern]:
+        """Parses alternative patterns: 0, 1 -> ..."""
+        patterns = [self.parse_pattern()]
+        while self.match(TokenType.COMMA):
+            self.advance()
+            patterns.append(self.parse_pattern())
+        return patterns
+    
+    def parse_pattern(self, nested: bool = False) -> Pattern:
+        """Parses _, a literal, Color.Red, Circle, Circle c or Point(x, 0); inside Point(...) a bare name binds"""
+        start = self.current_token
+        if self.match(TokenType.IDENTIFIER) and self.current_token.value == '_':
+            self.advance()
+            return self.set_position(WildcardPattern(), start)
+        
+        if self.match(TokenType.NUMBER, TokenType.STRING, TokenType.RAW_STRING, TokenType.CHAR,
+                      TokenType.BOOLEAN, TokenType.MINUS):
+            return self.set_position(ValuePattern(self.parse_unary()), start)
+        
+        if not self.match(TokenType.IDENTIFIER):
+            found = self.current_token.value if self.current_token else 'EOF'
+            raise self.error(f"Expected a pattern, found {found}", start)
+        
+        name = self.parse_qualified_name("Expected pattern")
+        if self.match(TokenType.LPAREN):
+            self.advance()
+            elements = []
+            while not self.match(TokenType.RPAREN) and self.current_token:
+                elements.append(self.parse_pattern(nested=True))
+                if self.match(TokenType.COMMA):
+                    self.advance()
+                else:
+                    break
+            self.consume(TokenType.RPAREN, "Expected ')' after destructuring pattern")
+            return self.set_position(DestructurePattern(name, elements), start)
+        
+        if self.match(TokenType.IDENTIFIER) and self.current_token.value != '_':
+            binding = self.current_token.value
+            self.advance()
+            return self.set_position(TypePattern(name, binding), start)
+        
+        if nested and '.' not in name and name != 'nil':
+            return self.set_position(BindingPattern(name), start)
+        
+        value = self.set_position(Identifier(name), start)
+        if '.' in name:
+            owner, _, member = name.partition('.')
+            value = self.set_position(SelectorExpr(self.set_position(Identifier(owner), start), member), start)
+        return self.set_position(ValuePattern(value), start)
+    
+    def parse_new_expr(self) -> NewExpr:
+        """Parse new expression (extension)"""
+        start = self.consume(TokenType.NEW)
+        class_name = self.parse_qualified_name("Expected class name")
+        
+        type_args = []
+        if self.match(TokenType.LT):
+            type_args = self.parse_type_args()
+        
+        # Anonymous implementations may omit the argument list: new ClickHandler { ... }
+        args, spread = [], False
+        if not self.is_anonymous_class_body():
+            args, spread = self.parse_call_args()
+        
+        body = None
+        if self.is_anonymous_class_body():
+            body = self.parse_anonymous_class_body()
+        return self.set_position(NewExpr(class_name, args, type_args, body, spread), start)
+    
+    def is_anonymous_class_body(self) -> bool:
+        """Checks for '{' opening class members (and not, e.g., the block of an if statement)"""
+        if not self.match(TokenType.LBRACE) or not self.peek():
+            return False
+        member = self.peek()
+        if member.type in (TokenType.FUNC, TokenType.RBRACE):
+            return True
+        if member.type == TokenType.IDENTIFIER and member.value == 'operator':
+            return True
+        # Field declaration: name type
+        field_type = self.peek(2)
+        return (member.type == TokenType.IDENTIFIER and field_type is not None and
+                field_type.type in (TokenType.IDENTIFIER, TokenType.MULTIPLY, TokenType.LBRACKET,
+                                    TokenType.MAP, TokenType.CHAN))
+    
+    def parse_anonymous_class_body(self) -> ClassDecl:
+        """Parses the members of an anonymous class (named later by the transpiler)"""
+        start = self.current_token
+        self.consume(TokenType.LBRACE)
+        
+        fields = []
+        methods = []
+        while not self.match(TokenType.RBRACE) and self.current_token:
+            if self.match(TokenType.FUNC) or self.is_operator_decl():
+                methods.append(self.parse_member_method())
             else:
-                break
+                fields.append(self.parse_class_field())
         
-        self.consume(TokenType.RPAREN)
-        return NewExpr(class_name, args)
+        self.consume(TokenType.RBRACE)
+        return self.set_position(ClassDecl('', None, fields, methods, None, is_anonymous=True), start)
@@ -0,0 +1,118 @@
+"""
+Code generation plugins for Go-Extended
+A plugin sees the AST of each file the transpiler generates, with its classes collected and their generated members
+added, and may change it before any Go is emitted: add members to annotated classes (ORM mappings, say), wrap
+method bodies (metrics), add declarations
+"""
+
+import sys
+import weakref
+import importlib
+import importlib.util
+from pathlib import Path
+from typing import Dict, List, Optional, Sequence, Set, Union
+from ast_nodes import *
+
+class PluginError(Exception):
+    """A plugin refusing a declaration, or a plugin that cannot be loaded; node locates the refused declaration"""
+
+    def __init__(self, message: str, node: Optional[ASTNode] = None):
+        super().__init__(message)
+        self.node = node
+
+class PluginContext:
+    """What the transpiler knows of the file, for the hooks of plugins"""
+
+    def __init__(self, transpiler):
+        # The transpiler holds its context: through a proxy, the two make no cycle, and a transpiler is freed as soon
+        # as it is dropped rather than by the cycle collector, with the nodes of its output
+        self._transpiler = weakref.proxy(transpiler)
+
+    @property
+    def package(self) -> str:
+        return self._transpiler.current_package
+
+    @property
+    def file(self) -> Optional[str]:
+        """Path of the file being transpiled (None when unknown)"""
+        return self._transpiler.source_file
+
+    @property
+    def classes(self) -> Dict[str, ClassDecl]:
+        """Classes of the package and of the packages it imports, by name"""
+        return self._transpiler.classes
+
+    @property
+    def interfaces(self) -> Dict[str, InterfaceDecl]:
+        return self._transpiler.interfaces
+
+    def annotation(self, node: Union[ClassDecl, MethodDecl], name: str) -> Optional[Annotation]:
+        """The annotation of a class or method with a name, None when it has none"""
+        return next((a for a in node.annotations if a.name == name), None)
+
+    def go_expression(self, expr: Expression) -> str:
+        """The Go code of an expression outside any function (an argument of an annotation, say)"""
+        return self._transpiler._expr_to_string(expr)
+
+    def add_method(self, decl: ClassDecl, name: str, params: List[Parameter], return_type: Optional[str], code: str,
+                   imports: Sequence[str] = ()) -> bool:
+        """Adds a method whose body is Go code (the receiver is this) to a class, with the imports the code needs;
+        returns False, adding nothing, when the class already declares the method"""
+        if any(m.name == name for m in decl.methods):
+            return False
+        decl.methods.append(MethodDecl(name, params, return_type, BlockStmt([RawStmt(code, list(imports))])))
+        return True
+
+class Plugin:
+    """Base of the plugins: each hook does nothing unless overridden"""
+
+    # Annotations the plugin handles, allowed on classes and on methods without an 'annotation' declaration; the
+    # plugin checks their arguments itself
+    class_annotations: Set[str] = set()
+    method_annotations: Set[str] = set()
+
+    def expand_class(self, decl: ClassDecl, context: PluginContext) -> None:
+        """Called once for each class of the package, once its generated members (data class, @json...) are added
+        and before the checks of the transpiler, which see what the plugin adds"""
+        pass
+
+    def transform(self, program: Program, context: PluginContext) -> None:
+        """Called with the AST of the file once it is checked, right before the Go is emitted"""
+        pass
+
+def load_plugin(name: str, root: Path) -> Plugin:
+    """The plugin of a module, named like an import (metrics_plugin, tools.orm) or by its path ending in .py,
+    relative to root: the module's 'plugin' variable"""
+    try:
+        if name.endswith('.py'):
+            path = root / name
+            if not path.exists():
+                raise PluginError(f"Plugin {name} not found")
+            # Run again at each load, so a long-lived process sees the changes to the file, under a name of its own
+            # (a plugin file named parser.py must not replace the parser)
+            spec = importlib.util.spec_from_file_location(f'goplus_plugin_{path.stem}', path)
+            module = importlib.util.module_from_spec(spec)
+            sys.modules[spec.name] = module
+            spec.loader.exec_module(module)
+        else:
+            if str(root) not in sys.path:
+                sys.path.insert(0, str(root))
+            module = importlib.import_module(name)
+    except ImportError as e:
+        raise PluginError(f"Plugin {name} cannot be imported: {e}")
+    plugin = getattr(module, 'plugin', None)
+    if not isinstance(plugin, Plugin):
+        raise PluginError(f"Plugin module {name} has no 'plugin' variable holding a Plugin")
+    return plugin
+
+def load_plugins(names: Sequence[str], root: Path) -> List[Plugin]:
+    """The plugins of modules, in the order given (see load_plugin)"""
+    return [load_plugin(name, root) for name in names]
+
+def plugin_sources(plugins: Sequence[Plugin]) -> List[str]:
+    """Source code of the modules of plugins, so a change to one invalidates the Go cached from them"""
+    sources = []
+    for plugin in plugins:
+        path = getattr(sys.modules.get(type(plugin).__module__), '__file__', None)
+        sources.append(Path(path).read_text(encoding='utf-8') if path else type(plugin).__qualname__)
+    return sources
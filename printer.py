@@ -0,0 +1,758 @@
+"""
+Pretty-printer for Go-Extended
+Turns an AST back into go-plus source in a canonical layout: parsing what it prints yields the same AST, comments
+and //goplus:nowarn lines included
+"""
+
+import re
+from typing import Callable, Dict, List, Optional, Sequence, Set, Tuple, Union
+from ast_nodes import *
+from tokens import TokenType
+from lexer import Lexer, NUMBER_SUFFIXES
+from parser import NOWARN_PREFIX, ParseError, Parser
+from directives import BUILD_PREFIX, CONDITIONAL_DIRECTIVE, build_constraint
+from literals import encode_char, fits_raw_string, rune_literal
+
+class PrintError(Exception):
+    """AST node with no go-plus spelling (code generated by the transpiler)"""
+    pass
+
+# Type suffixes of numbers by the Go type they give (float32 -> f32)
+SUFFIXES = {go_type: suffix for suffix, go_type in NUMBER_SUFFIXES.items()}
+
+# Binding levels of expressions, loosest first: an operand that binds looser than its position needs parentheses
+LAMBDA, TERNARY, TYPE_TEST, COALESCE = 0, 1, 2, 3
+BINARY = 3  # plus the operator's precedence (|| = 4 ... * = 8)
+UNARY, POSTFIX, PRIMARY = 9, 10, 11
+
+def source_type(type_name: str) -> str:
+    """go-plus spelling of a type the parser recorded in Go's: type arguments go back to angle brackets
+    (map[string]Stack[int] -> map[string]Stack<int>)"""
+    spelled = []
+    generic = []  # whether each open bracket holds type arguments
+    for i, char in enumerate(type_name):
+        if char == '[':
+            word = re.search(r'\w+$', type_name[:i])
+            generic.append(bool(word) and word.group() != 'map')
+            spelled.append('<' if generic[-1] else '[')
+        elif char == ']':
+            spelled.append('>' if generic and generic.pop() else ']')
+        else:
+            spelled.append(char)
+    return ''.join(spelled)
+
+def result_type(type_name: str) -> str:
+    """go-plus spelling of a result type: iterators are written yield T or yield K, V"""
+    for seq in ('iter.Seq2[', 'iter.Seq['):
+        if type_name.startswith(seq) and closing_bracket(type_name, len(seq) - 1) == len(type_name) - 1:
+            return 'yield ' + source_type(type_name[len(seq):-1])
+    return source_type(type_name)
+
+def closing_bracket(text: str, start: int) -> int:
+    """Index of the ']' closing the '[' at text[start]"""
+    depth = 0
+    for i in range(start, len(text)):
+        if text[i] == '[':
+            depth += 1
+        elif text[i] == ']':
+            depth -= 1
+            if depth == 0:
+                return i
+    return -1
+
+def import_group(spec: ImportDecl) -> int:
+    """Group of an import in the canonical order: 0 for paths without a domain (the standard library and the
+    packages of the project), 1 for the others (github.com/...)"""
+    return int('.' in spec.path.split('/')[0])
+
+def string_source(value: str) -> str:
+    """A string value as a go-plus literal; $ before { is escaped, or the string would interpolate"""
+    return '"' + ''.join(encode_char(char) for char in value).replace('${', '\\${') + '"'
+
+class Printer:
+    """Prints go-plus source from ASTs. Layout is canonical (4-space indents, one statement per line, class
+    members grouped by kind); blank lines between statements and single-line declarations are kept where the
+    source had them"""
+
+    INDENT = '    '
+
+    def __init__(self):
+        self.lines: List[str] = []
+        self.depth = 0
+        self.nowarn: Dict[int, List[str]] = {}  # suppressions not printed yet, by the source line they apply to
+        self.suppressed: Set[int] = set()  # lines of all the suppressions
+
+    def program(self, program: Program) -> str:
+        """Source of a file"""
+        self.lines, self.depth = [], 0
+        self.nowarn = dict(program.nowarn)
+        self.suppressed = set(program.nowarn)
+        for comment in program.comments:
+            self.write(comment)
+        self.write(f'package {program.package}')
+
+        if len(program.imports) == 1:
+            self.lines.append('')
+            self.write(f'import {self.import_spec(program.imports[0])}')
+        elif program.imports:
+            self.lines.append('')
+            self.write('import (')
+            self.depth += 1
+            for i, spec in enumerate(program.imports):
+                if i and import_group(spec) != import_group(program.imports[i - 1]):
+                    self.lines.append('')
+                self.write(self.import_spec(spec))
+            self.depth -= 1
+            self.write(')')
+
+        previous = None
+        for decl in program.declarations:
+            if previous is None or self.multi_line(previous) or self.multi_line(decl) or self.gap(previous, decl):
+                self.lines.append('')
+            self.declaration(decl)
+            previous = decl
+        if self.nowarn:
+            # Suppressions of lines holding no declaration or statement (a closing brace) suppress nothing; they
+            # are kept at the end of the file
+            self.lines.append('')
+            for codes in self.nowarn.values():
+                self.suppression(codes)
+        return '\n'.join(self.lines) + '\n'
+
+    def import_spec(self, spec: ImportDecl) -> str:
+        return f'{spec.alias} {string_source(spec.path)}' if spec.alias else string_source(spec.path)
+
+    # ------------------------------------------------------------------------
+    # Output
+    # ------------------------------------------------------------------------
+
+    def write(self, text: str) -> None:
+        """Writes a line at the current depth; lines after the first (of comments, or of expressions holding
+        blocks) carry their own indentation"""
+        first, *rest = text.split('\n')
+        self.lines.append(self.INDENT * self.depth + first if first else '')
+        if rest and (first.startswith('/*') or first.startswith('//')):
+            self.lines.extend(self.INDENT * self.depth + line if line else '' for line in rest)
+        else:
+            self.lines.extend(rest)
+
+    def capture(self, write, *args) -> List[str]:
+        """Lines a write method produces, one level deeper than the current depth, instead of writing them"""
+        lines, self.lines = self.lines, []
+        self.depth += 1
+        try:
+            write(*args)
+            return self.lines
+        finally:
+            self.depth -= 1
+            self.lines = lines
+
+    def leading(self, node: ASTNode) -> None:
+        """Writes the comments above a node, then the //goplus:nowarn suppressing warnings on its line (after
+        its annotations, for nodes that have some)"""
+        for comment in node.comments:
+            self.write(comment)
+        if not getattr(node, 'annotations', None):
+            self.suppress(node.line)
+
+    def annotations(self, node: Union[ClassDecl, MethodDecl]) -> None:
+        """Writes the annotations of a class or method, a line each, then the suppression of its own line"""
+        for annotation in node.annotations:
+            self.suppress(annotation.line)
+            self.write(self.annotation(annotation))
+        self.suppress(node.line)
+
+    def suppress(self, line: int) -> None:
+        """Writes the suppression of a source line, if it has one not written yet"""
+        codes = self.nowarn.pop(line, None) if line else None
+        if codes is not None:
+            self.suppression(codes)
+
+    def suppression(self, codes: List[str]) -> None:
+        """A //goplus:nowarn line, which suppresses warnings on the line after it"""
+        self.write('//goplus:nowarn' + (' ' + ', '.join(codes) if codes else ''))
+
+    def trailing(self, node: ASTNode) -> None:
+        """Appends a node's trailing comment to its last line"""
+        if node.trailing_comment:
+            self.lines[-1] += ' ' + node.trailing_comment
+
+    def gap(self, previous: ASTNode, node: ASTNode) -> bool:
+        """Whether the source had a blank line between two siblings (above the comments of the second)"""
+        if not (previous.end_line and node.line):
+            return False
+        comment_lines = sum(comment.count('\n') + 1 for comment in node.comments)
+        if node.line in self.suppressed:
+            comment_lines += 1  # its //goplus:nowarn line
+        return node.line - comment_lines - previous.end_line > 1
+
+    def multi_line(self, node: ASTNode) -> bool:
+        """Whether a declaration or member spans lines (function and class bodies, which blank lines set apart)"""
+        return isinstance(node, (FuncDecl, ClassDecl, InterfaceDecl, StructDecl, EnumDecl, ObjectDecl, MixinDecl,
+                                 MethodDecl, ConstructorDecl, ConversionDecl, BlockStmt)) or \
+            node.end_line > node.line
+
+    # ------------------------------------------------------------------------
+    # Declarations
+    # ------------------------------------------------------------------------
+
+    def declaration(self, decl: Declaration) -> None:
+        self.leading(decl)
+        if isinstance(decl, FuncDecl):
+            self.write(f'func {decl.name}{self.type_params(decl.type_params)}({self.params(decl.params)})'
+                       f'{self.result(decl.return_type)} {self.block_text(decl.body)}')
+        elif isinstance(decl, VarDecl):
+            self.write(self.var('var', decl.name, decl.type, decl.value))
+        elif isinstance(decl, ConstDecl):
+            self.write(self.var('const', decl.name, decl.type, decl.value))
+        elif isinstance(decl, TypeDecl):
+            if decl.variants:
+                self.write(f'type {decl.name} = ' + ' | '.join(map(source_type, decl.variants)))
+            else:
+                self.write(f"type {decl.name} {'= ' if decl.is_alias else ''}{source_type(decl.type)}")
+        elif isinstance(decl, StructDecl):
+            self.members(f'struct {decl.name}', [
+                (decl.fields, lambda field: self.write(f'{field.name} {source_type(field.type)}'))])
+        elif isinstance(decl, InterfaceDecl):
+            self.interface_decl(decl)
+        elif isinstance(decl, ClassDecl):
+            self.class_decl(decl)
+        elif isinstance(decl, ObjectDecl):
+            implements = f" implements {', '.join(map(source_type, decl.implements))}" if decl.implements else ''
+            self.members(f'object {decl.name}{implements}', [(decl.fields, self.field), (decl.methods, self.method)])
+        elif isinstance(decl, MixinDecl):
+            self.members(f'mixin {decl.name}', [(decl.fields, self.field), (decl.methods, self.method)])
+        elif isinstance(decl, EnumDecl):
+            self.enum_decl(decl)
+        elif isinstance(decl, AnnotationDecl):
+            self.write(f'annotation {decl.name}' + (f'({self.params(decl.params)})' if decl.params else ''))
+        else:
+            raise PrintError(f"Cannot print {type(decl).__name__}")
+        self.trailing(decl)
+
+    def var(self, keyword: str, name: str, type_name: Optional[str], value: Optional[Expression]) -> str:
+        """var x int = 1, const Max = 10"""
+        text = f'{keyword} {name}'
+        if type_name:
+            text += ' ' + source_type(type_name)
+        if value is not None:
+            text += ' = ' + self.expr(value)
+        return text
+
+    def members(self, head: str, groups: List[Tuple[Sequence[ASTNode], Callable]]) -> None:
+        """Writes head { members }, each group of members with its write method"""
+        if not any(nodes for nodes, _ in groups):
+            self.write(head + ' {}')
+            return
+        self.write(head + ' {')
+        self.depth += 1
+        self.member_lines(groups)
+        self.depth -= 1
+        self.write('}')
+
+    def member_lines(self, groups: List[Tuple[Sequence[ASTNode], Callable]]) -> None:
+        """Members in groups: single-line members keep the source's blank lines between them, other members and
+        groups are set apart by one"""
+        previous = None
+        for nodes, write in groups:
+            for i, member in enumerate(nodes):
+                if previous is not None and (i == 0 or self.multi_line(previous) or self.multi_line(member) or
+                                             self.gap(previous, member)):
+                    self.lines.append('')
+                self.leading(member)
+                write(member)
+                self.trailing(member)
+                previous = member
+
+    def class_decl(self, decl: ClassDecl) -> None:
+        """Classes, data classes and records; members are written by kind: constants, fields, events, overrides,
+        initializers, constructor, destructor, conversions, methods, then nested classes"""
+        self.annotations(decl)
+        head = f'{decl.name}{self.type_params(decl.type_params)}'
+        implements = f" implements {', '.join(map(source_type, decl.implements))}" if decl.implements else ''
+        if decl.is_data:
+            params = [Parameter(field.name, field.type) for field in decl.fields]
+            keyword = 'record' if decl.is_record else 'data class'
+            head = f'{keyword} {head}({self.params(params)}){implements}'
+            if decl.methods:
+                self.members(head, [(decl.methods, self.method)])
+            else:
+                self.write(head)
+            return
+
+        head = f"{'partial ' if decl.is_partial else ''}{'inner ' if decl.is_inner else ''}class {head}"
+        if decl.extends:
+            head += f' extends {source_type(decl.extends)}'
+        if decl.mixins:
+            head += f" with {', '.join(decl.mixins)}"
+        constructor = [decl.constructor] if decl.constructor else []
+        destructor = [decl.destructor] if decl.destructor else []
+        self.members(head + implements, [
+            (decl.constants, lambda const: self.write(self.var('const', const.name, const.type, const.value))),
+            (decl.fields, self.field),
+            (decl.events, lambda event: self.write(f'event {event.name}({self.params(event.params)})')),
+            (decl.overrides, lambda override: self.write(f'override {override.member} from {override.source}')),
+            (decl.static_blocks, lambda block: self.write(f'static {self.block_text(block)}')),
+            (decl.init_blocks, lambda block: self.write(f'init {self.block_text(block)}')),
+            (constructor, lambda ctor: self.write(f'{decl.name}({self.params(ctor.params)}) '
+                                                  f'{self.block_text(ctor.body)}')),
+            (destructor, lambda block: self.write(f'~{decl.name}() {self.block_text(block)}')),
+            (decl.conversions, self.conversion),
+            (decl.methods, self.method),
+            (decl.nested, self.class_decl),
+        ])
+
+    def field(self, field: ClassField) -> None:
+        """name type [= value] [get] [set] [@tag]"""
+        text = f'{field.name} {source_type(field.type)}'
+        if field.value is not None:
+            text += ' = ' + self.expr(field.value)
+        for accessor in field.accessors:
+            text += ' ' + accessor
+        for tag in field.tags:
+            text += ' ' + self.annotation(tag)
+        self.write(text)
+
+    def conversion(self, conversion: ConversionDecl) -> None:
+        self.write(f"{'implicit ' if conversion.implicit else ''}operator convert({self.params(conversion.params)}) "
+                   f"{source_type(conversion.return_type)} {self.block_text(conversion.body)}")
+
+    def method(self, method: MethodDecl) -> None:
+        """Methods, operators (operator +(other T) T, operator -() T) and indexers (operator [](key K) V)"""
+        self.annotations(method)
+        if method.operator:
+            head = f'operator {method.operator}'
+        else:
+            head = f'func {method.name}{self.type_params(method.type_params)}'
+        self.write(f'{head}({self.params(method.params)}){self.result(method.return_type)} '
+                   f'{self.block_text(method.body)}')
+
+    def interface_decl(self, decl: InterfaceDecl) -> None:
+        """Type elements (~int | float64, embedded interfaces), then method signatures"""
+        head = f'interface {decl.name}{self.type_params(decl.type_params)}'
+        if not (decl.type_elements or decl.methods):
+            self.write(head + ' {}')
+            return
+        self.write(head + ' {')
+        self.depth += 1
+        for element in decl.type_elements:
+            self.write(source_type(element))
+        self.member_lines([(decl.methods, lambda method: self.write(
+            f'{method.name}({self.params(method.params)}){self.result(method.return_type)}'))])
+        self.depth -= 1
+        self.write('}')
+
+    def enum_decl(self, decl: EnumDecl) -> None:
+        """enum Planet(mass float64) { Mercury(3.3), Earth(5.9) methods }, a member per line"""
+        head = f'enum {decl.name}' + (f'({self.params(decl.fields)})' if decl.fields else '')
+        self.write(head + ' {')
+        self.depth += 1
+        for i, member in enumerate(decl.members):
+            args = f"({', '.join(self.expr(arg) for arg in member.args)})" if member.args else ''
+            self.write(member.name + args + (',' if i < len(decl.members) - 1 else ''))
+        for method in decl.methods:
+            self.lines.append('')
+            self.leading(method)
+            self.method(method)
+            self.trailing(method)
+        self.depth -= 1
+        self.write('}')
+
+    def annotation(self, annotation: Annotation) -> str:
+        args = f"({', '.join(self.expr(arg) for arg in annotation.args)})" if annotation.args else ''
+        return f'@{annotation.name}{args}'
+
+    def type_params(self, type_params: List[TypeParam]) -> str:
+        """<T, K comparable> (any, the default constraint, is left out)"""
+        if not type_params:
+            return ''
+        return '<' + ', '.join(p.name if p.constraint == 'any' else f'{p.name} {source_type(p.constraint)}'
+                               for p in type_params) + '>'
+
+    def params(self, params: Sequence[Parameter]) -> str:
+        """Parameters, those sharing a type grouped as Go writes them (x, y float64); lambda parameters may
+        have no type"""
+        groups: List[List[Parameter]] = []
+        for param in params:
+            if groups and param.type is not None and groups[-1][-1].type == param.type:
+                groups[-1].append(param)
+            else:
+                groups.append([param])
+        return ', '.join(', '.join(p.name for p in group) +
+                         (f' {source_type(group[0].type)}' if group[0].type is not None else '') for group in groups)
+
+    def result(self, return_type: Optional[str]) -> str:
+        return f' {result_type(return_type)}' if return_type else ''
+
+    # ------------------------------------------------------------------------
+    # Statements
+    # ------------------------------------------------------------------------
+
+    def block_text(self, block: BlockStmt) -> str:
+        """{ statements } as text whose lines after the first are indented; {} when empty"""
+        if not block.statements and not block.end_comments:
+            return '{}'
+        lines = self.capture(self.statements, block.statements, block.end_comments)
+        return '\n'.join(['{', *lines, self.INDENT * self.depth + '}'])
+
+    def statements(self, statements: List[Statement], end_comments: Sequence[str] = ()) -> None:
+        previous = None
+        for stmt in statements:
+            if previous is not None and self.gap(previous, stmt):
+                self.lines.append('')
+            self.statement(stmt)
+            previous = stmt
+        for comment in end_comments:
+            self.write(comment)
+
+    def statement(self, stmt: Statement) -> None:
+        self.leading(stmt)
+        self.write(self.statement_text(stmt))
+        self.trailing(stmt)
+
+    def statement_text(self, stmt: Statement) -> str:
+        """A statement as text, its nested blocks indented one level deeper than the current depth"""
+        if isinstance(stmt, BlockStmt):
+            return self.block_text(stmt)
+        if isinstance(stmt, IfStmt):
+            text = f'if {self.expr(stmt.condition, TERNARY)}{self.branch(stmt.then_stmt)}'
+            if stmt.else_stmt is not None:
+                separator = '\n' + self.INDENT * self.depth if not isinstance(stmt.then_stmt, BlockStmt) else ' '
+                text += f'{separator}else{self.branch(stmt.else_stmt, allow_if=True)}'
+            return text
+        if isinstance(stmt, ForStmt):
+            init = self.simple(stmt.init) if stmt.init else ''
+            condition = self.expr(stmt.condition, TERNARY) if stmt.condition else ''
+            update = self.simple(stmt.update) if stmt.update else ''
+            return f'for {init}; {condition}; {update}'.rstrip() + self.branch(stmt.body)
+        if isinstance(stmt, ForInStmt):
+            return f"for {', '.join(stmt.variables)} in {self.expr(stmt.iterable, TERNARY)}{self.branch(stmt.body)}"
+        if isinstance(stmt, RangeStmt):
+            names = stmt.key + (f', {stmt.value}' if stmt.value else '')
+            return f'for {names} := range {self.expr(stmt.iterable, TERNARY)}{self.branch(stmt.body)}'
+        if isinstance(stmt, DoWhileStmt):
+            keyword, closing = ('repeat', 'until') if stmt.until else ('do', 'while')
+            return f'{keyword} {self.block_text(stmt.body)} {closing} ({self.expr(stmt.condition)})'
+        if isinstance(stmt, SwitchStmt):
+            head = 'switch ' + (self.expr(stmt.expression, TERNARY) + ' ' if stmt.expression is not None else '')
+            clauses = [(f"case {', '.join(self.expr(value) for value in case.values)}:", case.body)
+                       for case in stmt.cases]
+            if stmt.default_case:
+                clauses.append(('default:', stmt.default_case.body))
+            return self.clauses(head, clauses)
+        if isinstance(stmt, SelectStmt):
+            clauses = [(f'case {self.simple(case.comm)}:' if case.comm else 'default:', case.body)
+                       for case in stmt.cases]
+            return self.clauses('select ', clauses)
+        if isinstance(stmt, LabeledStmt):
+            return f'{stmt.label}:\n' + self.INDENT * self.depth + self.statement_text(stmt.statement)
+        if isinstance(stmt, ReturnStmt):
+            return 'return' + (' ' + self.expr_list(stmt.value) if stmt.value is not None else '')
+        if isinstance(stmt, BreakStmt):
+            return 'break' + (f' {stmt.label}' if stmt.label else '')
+        if isinstance(stmt, ContinueStmt):
+            return 'continue' + (f' {stmt.label}' if stmt.label else '')
+        if isinstance(stmt, GoStmt):
+            return 'go ' + self.expr(stmt.call)
+        if isinstance(stmt, DeferStmt):
+            return 'defer ' + self.expr(stmt.call)
+        if isinstance(stmt, TryStmt):
+            text = 'try ' + self.block_text(stmt.body)
+            for catch in stmt.catch_blocks:
+                caught = ' '.join(part for part in (catch.exception_type, catch.exception_var) if part)
+                text += ' catch ' + (f'({caught}) ' if caught else '') + self.block_text(catch.body)
+            if stmt.finally_block:
+                text += ' finally ' + self.block_text(stmt.finally_block.body)
+            return text
+        if isinstance(stmt, ThrowStmt):
+            return 'throw ' + self.expr(stmt.expression)
+        if isinstance(stmt, YieldStmt):
+            return 'yield ' + ', '.join(self.expr(value) for value in stmt.values)
+        if isinstance(stmt, UsingStmt):
+            return f'using ({stmt.name} := {self.expr(stmt.value)}) {self.block_text(stmt.body)}'
+        if isinstance(stmt, WithStmt):
+            binding = f'{stmt.name} := ' if stmt.name else ''
+            return f'with ({binding}{self.expr(stmt.value)}) {self.block_text(stmt.body)}'
+        return self.simple(stmt)
+
+    def simple(self, stmt: Statement) -> str:
+        """Statements that fit in a for clause or a select case: assignments, x++, sends, expressions, var"""
+        if isinstance(stmt, ExpressionStmt):
+            return self.expr_list(stmt.expression)
+        if isinstance(stmt, AssignStmt):
+            return f'{self.expr_list(stmt.target)} {stmt.operator} {self.expr_list(stmt.value)}'
+        if isinstance(stmt, IncDecStmt):
+            return self.expr(stmt.target, POSTFIX) + stmt.operator
+        if isinstance(stmt, SendStmt):
+            return f'{self.expr(stmt.channel)} <- {self.expr(stmt.value)}'
+        if isinstance(stmt, VarStmt):
+            return self.var('var', stmt.name, stmt.type, stmt.value)
+        raise PrintError(f"Cannot print {type(stmt).__name__}")
+
+    def branch(self, stmt: Statement, allow_if: bool = False) -> str:
+        """Body of an if, else or loop after its head: a block, an else if, or a statement on the next line"""
+        if isinstance(stmt, BlockStmt) or allow_if and isinstance(stmt, IfStmt):
+            return ' ' + self.statement_text(stmt)
+        return '\n' + '\n'.join(self.capture(self.statement, stmt))
+
+    def clauses(self, head: str, clauses: List) -> str:
+        """switch/select: its case clauses at its own depth, their statements one level deeper"""
+        lines = [head + '{']
+        for label, body in clauses:
+            lines.append(self.INDENT * self.depth + label)
+            lines.extend(self.capture(self.statements, body))
+        lines.append(self.INDENT * self.depth + '}')
+        return '\n'.join(lines)
+
+    # ------------------------------------------------------------------------
+    # Expressions
+    # ------------------------------------------------------------------------
+
+    def level(self, expr: Expression) -> int:
+        """How tightly an expression binds"""
+        if isinstance(expr, LambdaExpr):
+            return LAMBDA
+        if isinstance(expr, TernaryExpr):
+            return TERNARY
+        if isinstance(expr, (IsExpr, CastExpr)):
+            return TYPE_TEST
+        if isinstance(expr, CoalesceExpr):
+            return COALESCE
+        if isinstance(expr, BinaryExpr):
+            return BINARY + BINARY_PRECEDENCE[expr.operator]
+        if isinstance(expr, UnaryExpr):
+            return UNARY
+        if isinstance(expr, (CallExpr, IndexExpr, SelectorExpr, IncDecExpr, WithExpr)):
+            return POSTFIX
+        return PRIMARY
+
+    def expr_list(self, expr: Expression) -> str:
+        """An expression where a list of them may stand (assignments, return): tuples without parentheses"""
+        if isinstance(expr, TupleExpr):
+            return ', '.join(self.expr(element) for element in expr.elements)
+        return self.expr(expr)
+
+    def expr(self, expr: Expression, level: int = LAMBDA) -> str:
+        """An expression, parenthesized when it binds looser than its position requires"""
+        text = self.expr_text(expr)
+        return f'({text})' if self.level(expr) < level else text
+
+    def operand(self, expr: Expression) -> str:
+        """Object of a call, index, selector or with: numbers are parenthesized, or their '.' would be a point"""
+        if isinstance(expr, Literal) and expr.type in ('int', 'float'):
+            return f'({self.expr_text(expr)})'
+        return self.expr(expr, POSTFIX)
+
+    def expr_text(self, expr: Expression) -> str:
+        if isinstance(expr, Identifier):
+            return expr.name
+        if isinstance(expr, Literal):
+            return self.literal(expr)
+        if isinstance(expr, BinaryExpr):
+            precedence = BINARY + BINARY_PRECEDENCE[expr.operator]
+            return f'{self.expr(expr.left, precedence)} {expr.operator} {self.expr(expr.right, precedence + 1)}'
+        if isinstance(expr, UnaryExpr):
+            return expr.operator + self.expr(expr.operand, POSTFIX)
+        if isinstance(expr, IncDecExpr):
+            return self.operand(expr.operand) + expr.operator
+        if isinstance(expr, CallExpr):
+            return f"{self.operand(expr.function)}({self.args(expr.args, expr.spread)})"
+        if isinstance(expr, IndexExpr):
+            return f'{self.operand(expr.object)}[{self.expr(expr.index)}]'
+        if isinstance(expr, SelectorExpr):
+            return f"{self.operand(expr.object)}{'?.' if expr.optional else '.'}{expr.field}"
+        if isinstance(expr, TypeExpr):
+            return source_type(expr.type)
+        if isinstance(expr, InterpolatedString):
+            return self.interpolated(expr)
+        if isinstance(expr, CoalesceExpr):
+            return f'{self.expr(expr.left, COALESCE + 1)} ?? {self.expr(expr.right, COALESCE)}'
+        if isinstance(expr, TernaryExpr):
+            return f'{self.expr(expr.condition, COALESCE)} ? {self.expr(expr.then_expr, TERNARY)} : ' \
+                   f'{self.expr(expr.else_expr, TERNARY)}'
+        if isinstance(expr, TupleExpr):
+            return '(' + ', '.join(self.expr(element) for element in expr.elements) + ')'
+        if isinstance(expr, ArrayLiteral):
+            elements = ', '.join(self.expr(element) for element in expr.elements)
+            return f"{source_type(expr.type) if expr.type else ''}{{{elements}}}"
+        if isinstance(expr, MapLiteral):
+            pairs = ', '.join(f'{self.expr(key)}: {self.expr(value)}' for key, value in expr.pairs)
+            map_type = f'map[{source_type(expr.key_type)}]{source_type(expr.value_type)}' if expr.key_type else ''
+            return f'{map_type}{{{pairs}}}'
+        if isinstance(expr, WithExpr):
+            updates = ', '.join(f'{name}: {self.expr(value)}' for name, value in expr.updates)
+            return f'{self.operand(expr.target)} with {{ {updates} }}'
+        if isinstance(expr, NewExpr):
+            type_args = f"<{', '.join(map(source_type, expr.type_args))}>" if expr.type_args else ''
+            text = f'new {expr.class_name}{type_args}({self.args(expr.args, expr.spread)})'
+            if expr.body:
+                lines = self.capture(self.class_body, expr.body)
+                text += ' {}' if not lines else '\n'.join([' {', *lines, self.INDENT * self.depth + '}'])
+            return text
+        if isinstance(expr, IsExpr):
+            return f'{self.expr(expr.expr, BINARY + 4)} is {source_type(expr.type)}'
+        if isinstance(expr, CastExpr):
+            return f"{self.expr(expr.expr, BINARY + 4)} {'as?' if expr.safe else 'as'} {source_type(expr.type)}"
+        if isinstance(expr, LambdaExpr):
+            if len(expr.params) == 1 and expr.params[0].type is None:
+                params = expr.params[0].name
+            else:
+                params = f'({self.params(expr.params)})'
+            body = self.block_text(expr.body) if isinstance(expr.body, BlockStmt) else self.expr(expr.body)
+            return f'{params} -> {body}'
+        if isinstance(expr, ComprehensionExpr):
+            element = self.expr(expr.element, TERNARY)
+            if expr.key is not None:
+                element = f'{self.expr(expr.key, TERNARY)}: {element}'
+            text = f"{element} for {', '.join(expr.variables)} in {self.expr(expr.iterable, TERNARY)}"
+            if expr.condition is not None:
+                text += f' if {self.expr(expr.condition, TERNARY)}'
+            return f'{{{text}}}' if expr.key is not None else f'[{text}]'
+        if isinstance(expr, MatchExpr):
+            return self.match(expr)
+        if isinstance(expr, ThisExpr):
+            return 'this'
+        if isinstance(expr, SuperExpr):
+            return 'super'
+        raise PrintError(f"Cannot print {type(expr).__name__}")
+
+    def args(self, args: List[Expression], spread: bool) -> str:
+        return ', '.join(self.expr(arg) for arg in args) + ('...' if spread else '')
+
+    def literal(self, literal: Literal) -> str:
+        if literal.type == 'string':
+            return self.raw_string(literal.value) if literal.raw else string_source(literal.value)
+        if literal.type == 'char':
+            return rune_literal(literal.value)
+        if literal.type == 'bool':
+            return 'true' if literal.value else 'false'
+        text = literal.text if literal.text is not None else repr(literal.value)
+        return text + SUFFIXES.get(literal.go_type, '') if literal.go_type else text
+
+    def raw_string(self, value: str) -> str:
+        """`text`, or a triple-quoted string when a backtick or an unprintable character keeps the value from
+        being raw: its lines and closing quotes are indented alike, so the lexer removes just that indentation"""
+        if fits_raw_string(value):
+            return f'`{value}`'
+        margin = self.INDENT * (self.depth + 1)
+        text = ''.join(char if char == '\n' else encode_char(char) for char in value)
+        lines = [margin + line if line else '' for line in text.split('\n')]
+        return '\n'.join(['"""', *lines, margin + '"""'])
+
+    def interpolated(self, string: InterpolatedString) -> str:
+        """"Hi ${name}, you owe ${total:.2f}\""""
+        parts = []
+        for part in string.parts:
+            if isinstance(part, Interpolation):
+                spec = f':{part.spec}' if part.spec else ''
+                parts.append(f'${{{self.expr(part.expr)}{spec}}}')
+            else:
+                parts.append(string_source(part.value)[1:-1])
+        return '"' + ''.join(parts) + '"'
+
+    def class_body(self, decl: ClassDecl) -> None:
+        """Members of an anonymous class"""
+        self.member_lines([(decl.fields, self.field), (decl.methods, self.method)])
+
+    def match(self, expr: MatchExpr) -> str:
+        """match subject { patterns [if guard] -> value }, or switch subject { case A -> value; default -> value }"""
+        keyword = 'switch' if expr.is_switch else 'match'
+        lines = [f'{keyword} {self.expr(expr.subject, TERNARY)} {{']
+        self.depth += 1
+        try:
+            for arm in expr.arms:
+                if expr.is_switch and isinstance(arm.patterns[0], WildcardPattern):
+                    head = 'default'
+                elif expr.is_switch:
+                    head = 'case ' + ', '.join(self.expr(pattern.value, TERNARY) for pattern in arm.patterns)
+                else:
+                    head = ', '.join(self.pattern(pattern) for pattern in arm.patterns)
+                if arm.guard is not None:
+                    head += f' if {self.expr(arm.guard, TERNARY)}'
+                body = self.block_text(arm.body) if isinstance(arm.body, BlockStmt) else self.expr(arm.body)
+                lines.append(self.INDENT * self.depth + f'{head} -> {body}')
+        finally:
+            self.depth -= 1
+        lines.append(self.INDENT * self.depth + '}')
+        return '\n'.join(lines)
+
+    def pattern(self, pattern: Pattern) -> str:
+        if isinstance(pattern, WildcardPattern):
+            return '_'
+        if isinstance(pattern, ValuePattern):
+            return self.expr(pattern.value, TERNARY)
+        if isinstance(pattern, TypePattern):
+            return f'{pattern.type} {pattern.binding}' if pattern.binding else pattern.type
+        if isinstance(pattern, DestructurePattern):
+            return f"{pattern.type}({', '.join(self.pattern(element) for element in pattern.elements)})"
+        if isinstance(pattern, BindingPattern):
+            return pattern.name
+        raise PrintError(f"Cannot print {type(pattern).__name__}")
+
+def print_program(program: Program) -> str:
+    """go-plus source of a parsed file"""
+    try:
+        return Printer().program(program)
+    except RecursionError:
+        # The parser nests a few levels deeper than the printer within the same stack
+        raise PrintError("Code nested too deeply to print") from None
+
+# Marker comments standing for the #if/#elif/#else/#endif lines while a file is formatted
+DIRECTIVE_MARKER = '//goplus:#'
+
+def format_source(source: str, file: Optional[str] = None) -> str:
+    """A go-plus file in the canonical layout: its AST printed, with the imports sorted by path in their groups and
+    the build constraint kept. Raises LexerError or ParseError for a file that does not parse, and PrintError for
+    one the AST cannot hold: comments inside expressions or import lists, #if blocks that do not hold whole
+    declarations or statements"""
+    # Syntax and directive errors are those of the file as it builds (with no tags)
+    program = Parser(Lexer(source, set(), file).stream(), file).parse()
+
+    # #if blocks are formatted with all their branches: each directive line becomes a marker comment, kept by the
+    # printer like any comment on a line of its own, and is a directive line again once printed (indented as the
+    # code it is in)
+    lines = source.split('\n')
+    directives = [number for number, line in enumerate(lines, start=1) if CONDITIONAL_DIRECTIVE.match(line)]
+    if directives:
+        source = '\n'.join(DIRECTIVE_MARKER + line.strip()[1:] if CONDITIONAL_DIRECTIVE.match(line) else line
+                           for line in lines)
+        try:
+            program = Parser(Lexer(source, set(), file).stream(), file).parse()
+        except ParseError:
+            raise PrintError(f"#if blocks must hold whole declarations or statements to be formatted "
+                             f"(line {directives[0]}:1)") from None
+
+    program.imports.sort(key=lambda spec: (import_group(spec), spec.path, spec.alias or ''))
+    formatted = print_program(program)
+    constraint = build_constraint(source)
+    if constraint:
+        formatted = f'{BUILD_PREFIX} {constraint[0]}\n\n{formatted}'
+    if directives:
+        # The directives after the last declaration (an #endif closing the file) follow it
+        tokens = list(Lexer(source, set(), file).stream())
+        code = [token for token in tokens if token.type not in (TokenType.COMMENT, TokenType.NEWLINE, TokenType.EOF)]
+        end = code[-1].end_line if code else 0
+        formatted += ''.join(f'{token.value}\n' for token in tokens if token.type == TokenType.COMMENT and
+                             token.line > end and token.value.startswith(DIRECTIVE_MARKER))
+
+    # Every comment must still be there (suppressions are checked by the round trip of the printer)
+    kept = [token.value for token in Lexer(formatted, set(), file).stream() if token.type == TokenType.COMMENT]
+    for token in Lexer(source, set(), file).stream():
+        if token.type == TokenType.COMMENT and not token.value.startswith(NOWARN_PREFIX):
+            if token.value not in kept:
+                if token.value.startswith(DIRECTIVE_MARKER):
+                    raise PrintError(f"#if blocks must hold whole declarations or statements to be formatted "
+                                     f"(line {token.line}:1)")
+                raise PrintError(f"Formatting would drop this comment, which is inside an expression or an import "
+                                 f"list (line {token.line}:{token.column})")
+            kept.remove(token.value)
+    if directives:
+        lines = [line.replace(DIRECTIVE_MARKER, '#', 1) if line.lstrip().startswith(DIRECTIVE_MARKER) else line
+                 for line in formatted.split('\n')]
+        # An #endif (#else, #elif) is a comment of the declaration after it: the blank line the printer puts
+        # before that declaration goes after the directives instead, which close the one before
+        for i in range(1, len(lines)):
+            if not lines[i - 1] and re.match(r'\s*#(endif|else|elif)\b', lines[i]):
+                lines[i - 1], lines[i] = lines[i], lines[i - 1]
+        formatted = '\n'.join(lines)
+    return formatted
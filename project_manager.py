@@ -4,14 +4,76 @@ Supports multiple files and dependency resolution
 """
 
 import os
+import re
 import json
 from pathlib import Path
-from typing import Dict, List, Set, Optional, Tuple
-from dataclasses import dataclass
-from lexer import Lexer
-from parser import Parser
-from transpiler import Transpiler
-from ast_nodes import Program, ImportDecl, ASTNode, TryStmt, ThrowStmt, CallExpr, Identifier
+from itertools import repeat
+from concurrent.futures import FIRST_COMPLETED, Future, ProcessPoolExecutor, wait
+from typing import Dict, List, Set, Optional, Sequence, Tuple
+from dataclasses import dataclass, field
+from lexer import Lexer, LexerError
+from directives import default_tags, file_included
+from parser import Parser, ParseError
+from transpiler import Transpiler, WarningError
+from diagnostics import report_warnings, print_error
+from errors import CompileError, Diagnostic, error_diagnostic, position
+from buildcache import BuildCache, CachedUnit, compiler_hash, content_hash, file_hash
+from plugins import load_plugins, plugin_sources
+from linter import Linter
+from symbols import file_symbols, load_index, write_index
+from sourcemap import line_directives, write_source_map
+from gocheck import check_error, check_files, go_available, unresolved_imports
+from goformat import format_code, formatter_available
+from ast_nodes import (Program, ImportDecl, ASTNode, TryStmt, ThrowStmt, GoStmt, CallExpr, Identifier, MethodDecl,
+                       CastExpr, ClassDecl, FuncDecl, find)
+
+# Shared exception runtime of a project, generated as the exceptions package
+EXCEPTIONS_SOURCE = '''package exceptions
+
+import (
+    "fmt"
+    "os"
+)
+
+// Exception types
+type Exception interface {
+    Error() string
+    Type() string
+}
+
+type BaseException struct {
+    message string
+    exType string
+}
+
+func (e *BaseException) Error() string {
+    return e.message
+}
+
+func (e *BaseException) Type() string {
+    return e.exType
+}
+
+func NewException(exType, message string) Exception {
+    return &BaseException{message: message, exType: exType}
+}
+
+// UncaughtExceptionHandler receives the exceptions that escape a goroutine (replace it to log or exit)
+var UncaughtExceptionHandler = func(ex Exception) {
+    fmt.Fprintf(os.Stderr, "uncaught exception in goroutine: %s: %s\\n", ex.Type(), ex.Error())
+}
+
+// RecoverGoroutine is deferred by go statements: it hands a panic to UncaughtExceptionHandler
+func RecoverGoroutine() {
+    if r := recover(); r != nil {
+        ex, ok := r.(Exception)
+        if !ok {
+            ex = NewException("RuntimeError", fmt.Sprintf("%v", r))
+        }
+        UncaughtExceptionHandler(ex)
+    }
+}
+'''
 
 @dataclass
 class ProjectFile:
@@ -21,6 +83,10 @@ class ProjectFile:
     imports: List[str]
     program: Optional[Program] = None
     transpiled: bool = False
+    # go-plus span (file, line, column, end line, end column) of each line of the generated Go file; None for
+    # generated code
+    line_origins: List[Optional[Tuple[Optional[str], int, int, int, int]]] = field(default_factory=list)
+    digest: str = ''  # hash of the source, for the build cache
 
 @dataclass 
 class ProjectConfig:
@@ -31,14 +97,41 @@ class ProjectConfig:
     source_dir: str = "src"
     output_dir: str = "build"
     go_mod_name: str = ""
+    embed_pointers: bool = False  # embed base classes as *Person instead of copying a Person value
+    receiver: str = 'this'  # receiver name of generated methods: this, self or initial (p for *Person)
+    inline_accessors: bool = False  # emit calls to trivial getters and setters as field accesses
+    tags: List[str] = field(default_factory=list)  # build tags for //goplus:build and #if, besides GOOS/GOARCH
+    line_directives: bool = False  # write //line directives pointing Go errors and panics at the .gox lines
+    source_maps: bool = False  # write a JSON source map next to each generated file (main.go.map)
+    verify: bool = True  # compile the generated Go (when go is installed) before writing it
+    format: str = 'gofmt'  # formatter of the generated Go: gofmt, gofumpt or none
+    release: bool = False  # leave unreachable code, uncalled methods and unused classes out of the generated Go
+    warnings: Dict[str, str] = field(default_factory=dict)  # warning ID -> error, warning, info or off
+    cache_dir: str = '.goe2go-cache'  # build cache of the generated Go, in the project; "" turns it off
+    plugins: List[str] = field(default_factory=list)  # code generation plugins: modules, or .py files in the project
+    index_file: str = 'goe2go-index.json'  # exported symbol index for editors and tools, in the project; "" turns it off
 
 class ProjectManager:
-    def __init__(self, project_root: Path):
+    def __init__(self, project_root: Path, tags: Sequence[str] = (), line_directives: bool = False,
+                 source_maps: bool = False, verify: bool = True, formatter: Optional[str] = None,
+                 release: bool = False, cache: bool = True, jobs: int = 1):
         self.project_root = project_root
+        self.requested_tags = list(tags)  # from the command line (--tags), added to the configured ones
+        self.requested_line_directives = line_directives  # from the command line, besides the configuration
+        self.requested_source_maps = source_maps  # likewise
+        self.requested_verify = verify  # False with --no-verify
+        self.requested_format = formatter  # --format, replacing the configured formatter
+        self.requested_release = release  # --release, besides the configuration
+        self.requested_cache = cache  # False with --no-cache
+        self.jobs = jobs or os.cpu_count() or 1  # worker processes parsing and transpiling (-p; 0: one per CPU)
+        self.tags: Set[str] = set()
+        self.excluded_files: List[Path] = []  # files whose build constraint does not hold
         self.config: Optional[ProjectConfig] = None
         self.files: Dict[str, ProjectFile] = {}  # path -> ProjectFile
         self.packages: Dict[str, List[ProjectFile]] = {}  # package -> files
         self.dependency_graph: Dict[str, Set[str]] = {}  # file -> dependencies
+        self.formatter = 'none'  # formatter of the current build, from --format or the configuration
+        self.diagnostics: List[Diagnostic] = []  # warnings and errors of the files, for --diagnostics-format
         
     def load_config(self) -> ProjectConfig:
         """Load project configuration"""
@@ -73,22 +166,29 @@ class ProjectManager:
         
         if not source_dir.exists():
             source_dir = self.project_root
-        
-        # Find all .gox files
-        for gox_file in source_dir.rglob("*.gox"):
-            self._analyze_file(gox_file)
+        self.tags = default_tags([*self.config.tags, *self.requested_tags])
+        
+        # Find all .gox files, in path order (directory listings are in no particular order), so repeated builds
+        # transpile, and write, the same way
+        paths = sorted(source_dir.rglob("*.gox"))
+        if self.jobs > 1 and len(paths) > 1:
+            with ProcessPoolExecutor(min(self.jobs, len(paths))) as pool:
+                parsed = list(pool.map(parse_source, paths, repeat(self.project_root), repeat(self.tags)))
+        else:
+            parsed = [parse_source(path, self.project_root, self.tags) for path in paths]
+        for gox_file, (program, digest, error) in zip(paths, parsed):
+            self._analyze_file(gox_file, program, digest, error)
     
-    def _analyze_file(self, file_path: Path) -> None:
-        """Analyze a file and extract basic information"""
+    def _analyze_file(self, file_path: Path, program: Optional[Program], digest: str,
+                      error: Optional[Exception]) -> None:
+        """Analyze a parsed file and extract basic information"""
         try:
-            with open(file_path, 'r', encoding='utf-8') as f:
-                content = f.read()
-            
-            # Tokenize and parse just to extract package and imports
-            lexer = Lexer(content)
-            tokens = lexer.tokenize()
-            parser = Parser(tokens)
-            program = parser.parse()
+            if error:
+                raise error
+            if not program:
+                self.excluded_files.append(file_path.relative_to(self.project_root))
+                return
+            rel_path = file_path.relative_to(self.project_root)
             
             # Extract local imports (non-stdlib)
             local_imports = []
@@ -99,12 +199,12 @@ class ProjectManager:
                     local_imports.append(import_path)
             
             # Create file entry
-            rel_path = file_path.relative_to(self.project_root)
             project_file = ProjectFile(
                 path=file_path,
                 package=program.package,
                 imports=local_imports,
-                program=program
+                program=program,
+                digest=digest
             )
             
             self.files[str(rel_path)] = project_file
@@ -114,7 +214,14 @@ class ProjectManager:
                 self.packages[program.package] = []
             self.packages[program.package].append(project_file)
             
+        except (LexerError, ParseError) as e:
+            # Syntax errors are already led by the file name
+            self.diagnostics.extend(e.diagnostics)
+            print_error(e, root=self.project_root)
         except Exception as e:
+            location = str(file_path.relative_to(self.project_root))
+            self.diagnostics.append(error_diagnostic(CompileError.code, str(e), position(location, 0, 0),
+                                                     text=f"{location}: {e}"))
             print(f"Error analyzing {file_path}: {e}")
     
     def _is_stdlib_import(self, import_path: str) -> bool:
@@ -137,14 +244,54 @@ class ProjectManager:
         for file_path, project_file in self.files.items():
             deps = set()
             
-            for import_path in project_file.imports:
-                # Find files that provide this import
+            for package in self.package_references(project_file):
+                # Find files that provide this package
                 for other_path, other_file in self.files.items():
-                    if other_file.package == import_path:
+                    if other_file.package == package:
                         deps.add(other_path)
             
             self.dependency_graph[file_path] = deps
     
+    def package_references(self, project_file: ProjectFile) -> Set[str]:
+        """Project packages a file uses: its imports and the packages of qualified base classes (models.Person)"""
+        referenced = set(project_file.imports)
+        for imp in project_file.program.imports:
+            import_path = imp.path.strip('"')
+            referenced.add(import_path)
+            if import_path.startswith(f"{self.config.go_mod_name}/"):
+                referenced.add(import_path.rsplit('/', 1)[-1])
+        for decl in project_file.program.declarations:
+            if isinstance(decl, ClassDecl) and decl.extends:
+                package = decl.extends.split('[')[0].rpartition('.')[0]
+                if package:
+                    referenced.add(package)
+        return {package for package in referenced if package in self.packages and package != project_file.package}
+    
+    # Settings that only change how the cached Go is written, not the Go itself
+    UNCACHED_SETTINGS = ('line_directives', 'source_maps', 'verify', 'cache_dir')
+    
+    def cache_key(self, file_path: str, global_exceptions: bool, compiler: str) -> str:
+        """Build cache key of a file: the transpiler (compiler, its hash), the settings of the build, and the
+        sources of the file's package and of every package it depends on, directly or not"""
+        project_file = self.files[file_path]
+        packages, pending = {project_file.package}, list(self.packages[project_file.package])
+        while pending:
+            for package in self.package_references(pending.pop()):
+                if package not in packages:
+                    packages.add(package)
+                    pending.extend(self.packages[package])
+        sources = sorted(f"{f.path.relative_to(self.project_root).as_posix()}:{f.digest}"
+                         for package in packages for f in self.packages[package])
+        settings = {name: value for name, value in self.config.__dict__.items() if name not in self.UNCACHED_SETTINGS}
+        settings.update(tags=sorted(self.tags), release=self.requested_release, formatter=self.formatter,
+                        exceptions=global_exceptions)
+        return content_hash(compiler, json.dumps(settings, sort_keys=True), file_path, *sources)
+    
+    def package_import_path(self, package: str) -> str:
+        """Import path of a generated package: the module path plus the package's directory"""
+        directory = self.packages[package][0].path.parent.relative_to(self.project_root)
+        return f"{self.config.go_mod_name}/{directory.as_posix()}"
+    
     def get_transpilation_order(self) -> List[str]:
         """Return transpilation order based on dependencies"""
         # Topological sort algorithm
@@ -161,8 +308,8 @@ class ProjectManager:
             
             temp_visited.add(file_path)
             
-            # Visit dependencies first
-            for dep in self.dependency_graph.get(file_path, set()):
+            # Visit dependencies first, in path order
+            for dep in sorted(self.dependency_graph.get(file_path, set())):
                 visit(dep)
             
             temp_visited.remove(file_path)
@@ -190,6 +337,10 @@ class ProjectManager:
         # Build dependency graph
         self.build_dependency_graph()
         
+        # The index describes the sources: it is updated before transpiling adds generated members to the ASTs
+        if self.config.index_file:
+            self.update_index()
+        
         # Get transpilation order
         try:
             order = self.get_transpilation_order()
@@ -201,31 +352,97 @@ class ProjectManager:
         output_dir = self.project_root / self.config.output_dir
         output_dir.mkdir(exist_ok=True)
         
+        # Output of a previous build with other tags would still be compiled by Go
+        for rel_path in self.excluded_files:
+            print(f"Skipping {rel_path} (excluded by its build constraint)")
+            stale = output_dir / rel_path.with_suffix('.go')
+            if stale.exists():
+                stale.unlink()
+        
         # Analyze global exception usage
         global_exceptions = self._analyze_global_exceptions()
         
-        # Generate exceptions file if needed
-        if global_exceptions:
-            self._generate_exceptions_file(output_dir)
+        self.formatter = self.requested_format or self.config.format
+        if self.formatter != 'none' and not formatter_available(self.formatter):
+            print(f"Warning: {self.formatter} not found, the generated Go is written unformatted")
+        
+        # Files whose sources (and those of the packages they use) are unchanged since a previous build are
+        # taken from the build cache
+        cache = BuildCache(self.project_root / self.config.cache_dir) \
+            if self.config.cache_dir and self.requested_cache else None
+        compiler = compiler_hash() if cache else ''
+        if cache and self.config.plugins:
+            compiler = content_hash(compiler, *plugin_sources(load_plugins(self.config.plugins, self.project_root)))
+        keys: Dict[str, str] = {}  # file -> its cache key
+        
+        cached: Dict[str, CachedUnit] = {}  # file -> its cached output
+        for file_path in order:
+            if cache:
+                keys[file_path] = self.cache_key(file_path, global_exceptions, compiler)
+                unit = cache.load(keys[file_path])
+                if unit:
+                    cached[file_path] = unit
+        
+        # With -p above 1, worker processes transpile the other files first; their output is taken below, in
+        # order, as if they were transpiled there
+        produced = self._transpile_packages(order, cached, global_exceptions) if self.jobs > 1 else {}
         
         # Transpile files in the correct order
         project_transpiler = ProjectTranspiler(self, global_exceptions)
+        generated: Dict[str, str] = {}  # file -> its Go code
         
         for file_path in order:
             project_file = self.files[file_path]
+            if file_path in cached:
+                print(f"Up to date: {file_path} (package {project_file.package})")
+                project_transpiler.restore(project_file, file_path, cached[file_path])
+                generated[file_path] = cached[file_path].go_code
+                continue
             print(f"Transpiling {file_path} (package {project_file.package})")
+            if file_path in produced:
+                project_transpiler.restore(project_file, file_path, produced[file_path])
+                project_transpiler.units[file_path] = produced[file_path]
+                generated[file_path] = produced[file_path].go_code
+                continue
+            
+            # Transpile with project context
+            go_code = project_transpiler.transpile_file(project_file, file_path)
+            generated[file_path], project_file.line_origins = format_code(go_code, project_file.line_origins,
+                                                                          self.formatter)
+        
+        # Nothing is written unless the generated Go compiles (cached Go compiled when it was cached)
+        if self.config.verify and self.requested_verify and project_transpiler.units:
+            self._verify_output(output_dir, generated, global_exceptions)
+        
+        if cache:
+            for file_path, unit in project_transpiler.units.items():
+                unit.go_code, unit.line_origins = generated[file_path], self.files[file_path].line_origins
+                cache.store(keys[file_path], unit)
+        
+        # Generate exceptions file if needed
+        if global_exceptions:
+            self._generate_exceptions_file(output_dir)
+        
+        for file_path, go_code in generated.items():
+            project_file = self.files[file_path]
             
             # Determine output path
             rel_path = Path(file_path)
             output_path = output_dir / rel_path.with_suffix('.go')
             output_path.parent.mkdir(parents=True, exist_ok=True)
             
-            # Transpile with project context
-            go_code = project_transpiler.transpile_file(project_file, file_path)
+            if self.config.line_directives or self.requested_line_directives:
+                go_code, project_file.line_origins = line_directives(go_code, project_file.line_origins,
+                                                                     output_path, self.project_root)
             
             # Save
             with open(output_path, 'w', encoding='utf-8') as f:
                 f.write(go_code)
+            map_path = output_path.with_name(output_path.name + '.map')
+            if self.config.source_maps or self.requested_source_maps:
+                write_source_map(go_code, project_file.line_origins, output_path, self.project_root)
+            elif map_path.exists():
+                map_path.unlink()  # from an earlier build, no longer matching the file
             
             project_file.transpiled = True
             print(f"Generated: {file_path} -> {output_path}")
@@ -233,18 +450,181 @@ class ProjectManager:
         # Generate go.mod if needed
         self._generate_go_mod(output_dir)
         
+        if cache:
+            cache.prune()
+        
+        # Nothing needs the ASTs any more: their nodes are freed now rather than with the manager
+        for project_file in self.files.values():
+            project_file.program = None
         print(f"Project successfully transpiled to {output_dir}")
     
+    def _transpile_packages(self, order: List[str], cached: Dict[str, CachedUnit],
+                            global_exceptions: bool) -> Dict[str, CachedUnit]:
+        """Transpiles the files that are not cached with self.jobs worker processes: the files of a package by
+        one worker, in order, once the packages they use are transpiled. Returns the formatted output of each
+        transpiled file"""
+        packages: Dict[str, List[str]] = {}  # package -> its files, in transpilation order
+        for file_path in order:
+            packages.setdefault(self.files[file_path].package, []).append(file_path)
+        uses = {package: set().union(*(self.package_references(self.files[f]) for f in files))
+                for package, files in packages.items()}
+        classes: Dict[str, Dict[str, ClassDecl]] = {}  # package -> its classes, once transpiled
+        produced: Dict[str, CachedUnit] = {}
+        
+        # Once a package fails, no other one starts: the files left are transpiled again in order by
+        # transpile_project, which reports the error where it would without -p
+        pool = ProcessPoolExecutor(min(self.jobs, len(packages)))
+        try:
+            running: Dict[Future, str] = {}
+            failed = False
+            while True:
+                for package, files in packages.items():
+                    if failed or package in classes or package in running.values() or not uses[package] <= set(classes):
+                        continue
+                    package_cache = {f: cached[f] for f in files if f in cached}
+                    running[pool.submit(transpile_package, self, global_exceptions, files, package_cache,
+                                        classes)] = package
+                if not running:
+                    break
+                done, _ = wait(running, return_when=FIRST_COMPLETED)
+                for future in done:
+                    package = running.pop(future)
+                    if future.exception():
+                        failed = True
+                        continue
+                    units = future.result()
+                    produced.update(units)
+                    last = packages[package][-1]
+                    classes[package] = (units.get(last) or cached[last]).classes
+        finally:
+            pool.shutdown(cancel_futures=True)
+        return produced
+    
+    # path.go:12 or path.go:12:5 in go build and go run output
+    GO_POSITION = re.compile(r'(?P<path>[\w./\\-]+\.go):(?P<line>\d+)(?::(?P<column>\d+))?')
+    
+    def locate_go_output(self, text: str, directory: Path) -> str:
+        """Rewrites the positions in output of the go tool run in directory (errors, panics) into the go-plus
+        positions the generated lines come from: build/main/main.go:40:2 -> src/main/main.gox:12:5 (main.go:40:2)"""
+        output_dir = (self.project_root / self.config.output_dir).resolve()
+        
+        def replace(match: re.Match) -> str:
+            path = Path(match.group('path'))
+            try:
+                generated = (path if path.is_absolute() else directory / path).resolve().relative_to(output_dir)
+            except ValueError:
+                return match.group(0)
+            project_file = self.files.get(str(generated.with_suffix('.gox')))
+            line = int(match.group('line'))
+            if not project_file or not 0 < line <= len(project_file.line_origins):
+                return match.group(0)
+            origin = project_file.line_origins[line - 1]
+            if not origin:
+                return match.group(0)
+            return f'{origin[0]}:{origin[1]}:{origin[2]} ({match.group(0)})'
+        
+        return self.GO_POSITION.sub(replace, text)
+    
     def _generate_go_mod(self, output_dir: Path) -> None:
         """Generate go.mod file"""
         go_mod_path = output_dir / "go.mod"
         
         if not go_mod_path.exists():
             with open(go_mod_path, 'w', encoding='utf-8') as f:
-                f.write(f"module {self.config.go_mod_name}\n\n")
-                f.write("go 1.19\n")
+                f.write(self._go_mod_source())
             print(f"Generated {go_mod_path}")
     
+    def _go_mod_source(self) -> str:
+        """Contents of the generated go.mod"""
+        # Iterators compile to range-over-func, available from Go 1.23
+        go_version = '1.23' if self._uses_iterators() else '1.19'
+        return f"module {self.config.go_mod_name}\n\ngo {go_version}\n"
+    
+    def _verify_output(self, output_dir: Path, generated: Dict[str, str], global_exceptions: bool) -> None:
+        """Compiles the generated files as they would be written (with the go.mod and go.sum of the output,
+        if any) and fails the build at the go-plus lines of any error"""
+        if not go_available():
+            print("Warning: go not found, the generated Go is written without being compiled")
+            return
+        files = {Path(file_path).with_suffix('.go').as_posix(): (go_code, self.files[file_path].line_origins)
+                 for file_path, go_code in generated.items()}
+        support = {'exceptions/exceptions.go': EXCEPTIONS_SOURCE} if global_exceptions else {}
+        if (output_dir / 'go.sum').exists():
+            support['go.sum'] = (output_dir / 'go.sum').read_text(encoding='utf-8')
+        go_mod_path = output_dir / 'go.mod'
+        go_mod = go_mod_path.read_text(encoding='utf-8') if go_mod_path.exists() else self._go_mod_source()
+        errors = check_files(files, go_mod, support)
+        unresolved = unresolved_imports(errors)
+        if unresolved:
+            print(f"Warning: the generated Go is written without being compiled, as its imports cannot be "
+                  f"resolved (add them to {go_mod_path.relative_to(self.project_root)}):\n{unresolved[0]}")
+        elif errors:
+            raise check_error(errors)
+    
+    def _uses_iterators(self) -> bool:
+        """Check if any file declares an iterator (yield T result)"""
+        def declares_iterator(node: ASTNode) -> bool:
+            return isinstance(node, (FuncDecl, MethodDecl)) and (node.return_type or '').startswith('iter.Seq')
+        return any(find(f.program, declares_iterator) for f in self.files.values() if f.program)
+    
+    def lint_project(self, warning_levels: Optional[Dict[str, str]] = None) -> None:
+        """Lint every file of the project, failing when a warning is set to severity error; warning_levels
+        override the "warnings" of goe2go.json"""
+        if not self.config:
+            self.load_config()
+        
+        self.discover_files()
+        linter = Linter({**self.config.warnings, **(warning_levels or {})})
+        failed: List[str] = []
+        
+        for file_path, project_file in self.files.items():
+            warnings = linter.lint(project_file.program)
+            self.diagnostics.extend(warnings)
+            try:
+                report_warnings(warnings, file_path, self.project_root)
+            except WarningError as e:
+                failed.append(str(e))  # the remaining files are still linted
+        
+        if failed:
+            raise WarningError('\n'.join(failed))
+        print(f"Linted {len(self.files)} .gox files")
+    
+    def index_project(self) -> None:
+        """Update the exported symbol index of the project without building it"""
+        if not self.config:
+            self.load_config()
+        if not self.config.index_file:
+            raise ValueError('The symbol index is turned off ("index_file" is empty in goe2go.json)')
+        
+        self.discover_files()
+        indexed = self.update_index()
+        print(f"Indexed {indexed} of {len(self.files)} .gox files in {self.config.index_file}")
+    
+    def update_index(self) -> int:
+        """Update the symbol index with the discovered files whose sources changed since it was written; returns
+        how many files were indexed again"""
+        index_path = self.project_root / self.config.index_file
+        previous = load_index(index_path)
+        entries: Dict[str, Dict] = {}
+        indexed = 0
+        
+        for file_path, project_file in self.files.items():
+            entry = previous.get(file_path)
+            if not entry or entry.get('digest') != project_file.digest:
+                entry = {'digest': project_file.digest, 'package': project_file.package,
+                         'symbols': file_symbols(project_file.program)}
+                indexed += 1
+            entries[file_path] = entry
+        
+        # A file that no longer parses keeps its last symbols for editors; deleted and excluded files lose theirs
+        excluded = {str(rel_path) for rel_path in self.excluded_files}
+        for file_path, entry in previous.items():
+            if file_path not in entries and file_path not in excluded and (self.project_root / file_path).is_file():
+                entries[file_path] = entry
+        
+        write_index(index_path, entries)
+        return indexed
+    
     def show_project_info(self) -> None:
         """Show project information"""
         if not self.config:
@@ -329,28 +709,29 @@ func main() {{
                 return True
         return False
     
-    def _file_uses_exceptions(self, node) -> bool:
+    def _file_uses_exceptions(self, program: Optional[Program]) -> bool:
         """Check if a file uses exceptions"""
+        return program is not None and find(program, self._uses_exceptions) is not None
+    
+    def _uses_exceptions(self, node: ASTNode) -> bool:
+        """Check if a node needs the exception runtime"""
         if isinstance(node, (TryStmt, ThrowStmt)):
             return True
+        elif isinstance(node, GoStmt):
+            # Goroutines hand uncaught exceptions to the global handler
+            return True
         elif isinstance(node, CallExpr) and isinstance(node.function, Identifier):
             if node.function.name == 'NewException':
                 return True
-        
-        # Recurse through all attributes
-        for attr_name in dir(node):
-            if attr_name.startswith('_'):
-                continue
-            attr = getattr(node, attr_name)
-            if isinstance(attr, list):
-                for item in attr:
-                    if hasattr(item, '__class__') and issubclass(item.__class__, ASTNode):
-                        if self._file_uses_exceptions(item):
-                            return True
-            elif hasattr(attr, '__class__') and issubclass(attr.__class__, ASTNode):
-                if self._file_uses_exceptions(attr):
-                    return True
-        
+        elif isinstance(node, MethodDecl) and node.name == 'IndexLookup':
+            # The generated IndexGet throws KeyNotFound
+            return True
+        elif isinstance(node, CastExpr) and not node.safe:
+            # Failed casts throw ClassCastException
+            return True
+        elif isinstance(node, ClassDecl) and any(a.name == 'json' for a in node.annotations):
+            # Generated JSON methods throw FormatException
+            return True
         return False
     
     def _generate_exceptions_file(self, output_dir: Path) -> None:
@@ -361,38 +742,42 @@ func main() {{
         exceptions_file = exceptions_dir / "exceptions.go"
         
         with open(exceptions_file, 'w', encoding='utf-8') as f:
-            f.write('''package exceptions
-
-import (
-    "fmt"
-    "errors"
-)
-
-// Exception types
-type Exception interface {
-    Error() string
-    Type() string
-}
-
-type BaseException struct {
-    message string
-    exType string
-}
+            f.write(format_code(EXCEPTIONS_SOURCE, [], self.formatter)[0])
+        
+        print(f"Generated exceptions file: {exceptions_file}")
 
-func (e *BaseException) Error() string {
-    return e.message
-}
 
-func (e *BaseException) Type() string {
-    return e.exType
-}
+def parse_source(file_path: Path, project_root: Path, tags: Sequence[str]) -> Tuple[Optional[Program], str,
+                                                                                     Optional[Exception]]:
+    """Parses a .gox file (in a worker process with -p): its program (None when its build constraint excludes
+    it), the digest of its source, and the error reading or parsing it"""
+    try:
+        rel_path = str(file_path.relative_to(project_root))
+        with open(file_path, 'r', encoding='utf-8') as f:
+            if not file_included(f, tags):
+                return None, '', None
+            f.seek(0)
+            program = Parser(Lexer(f, tags, rel_path).stream(), rel_path).parse()
+        return program, file_hash(file_path), None
+    except Exception as e:
+        return None, '', e
 
-func NewException(exType, message string) Exception {
-    return &BaseException{message: message, exType: exType}
-}
-''')
-        
-        print(f"Generated exceptions file: {exceptions_file}")
+def transpile_package(project_manager: ProjectManager, global_exceptions: bool, file_paths: List[str],
+                      cached: Dict[str, CachedUnit], package_classes: Dict[str, Dict[str, ClassDecl]]
+                      ) -> Dict[str, CachedUnit]:
+    """Transpiles and formats the files of a package in a worker process of -p, given the classes of the packages
+    they use; the cached files are only restored. Returns the output of the transpiled files"""
+    project_transpiler = ProjectTranspiler(project_manager, global_exceptions)
+    project_transpiler.package_classes.update(package_classes)
+    for file_path in file_paths:
+        project_file = project_manager.files[file_path]
+        if file_path in cached:
+            project_transpiler.record(project_file, cached[file_path])
+            continue
+        project_transpiler.transpile_file(project_file, file_path, report=False)
+        unit = project_transpiler.units[file_path]
+        unit.go_code, unit.line_origins = format_code(unit.go_code, unit.line_origins, project_manager.formatter)
+    return project_transpiler.units
 
 
 class ProjectTranspiler:
@@ -401,89 +786,103 @@ class ProjectTranspiler:
     def __init__(self, project_manager: ProjectManager, has_exceptions: bool):
         self.project_manager = project_manager
         self.has_exceptions = has_exceptions
+        self.class_runtime_packages: Set[str] = set()  # packages whose class registry is already emitted
+        self.string_runtime_packages: Set[str] = set()  # packages whose string helpers are already emitted
+        self.package_classes: Dict[str, Dict[str, ClassDecl]] = {}  # package -> its classes, once transpiled
+        self.units: Dict[str, CachedUnit] = {}  # file -> what transpiling it produced, for the build cache
+        # Loaded here rather than passed along, so worker processes of -p load their own
+        self.plugins = load_plugins(project_manager.config.plugins, project_manager.project_root)
     
-    def transpile_file(self, project_file: ProjectFile, file_path: str) -> str:
-        """Transpile a file in the context of the project"""
+    def transpile_file(self, project_file: ProjectFile, file_path: str, report: bool = True) -> str:
+        """Transpile a file in the context of the project; its warnings are reported unless report is False (in the
+        worker processes of -p, whose output is restored, warnings included, by the main one)"""
+        # The transpiler and its Go lines, which hold nodes of the bodies, are freed before the bodies are released
+        unit = self._transpile(project_file, file_path, report)
+        self.record(project_file, unit)
+        self.units[file_path] = unit
+        
+        project_file.line_origins = unit.line_origins
+        return unit.go_code
+    
+    def _transpile(self, project_file: ProjectFile, file_path: str, report: bool) -> CachedUnit:
+        """Transpiles a file in the context of the project, giving what it produced"""
         from transpiler import Transpiler
         
         # Create custom transpiler in project mode
-        transpiler = Transpiler(project_mode=True)
+        config = self.project_manager.config
+        transpiler = Transpiler(project_mode=True, embed_pointers=config.embed_pointers, receiver=config.receiver,
+                                release=config.release or self.project_manager.requested_release,
+                                inline_accessors=config.inline_accessors, warning_levels=config.warnings,
+                                plugins=self.plugins)
+        transpiler.emit_class_runtime = project_file.package not in self.class_runtime_packages
+        transpiler.emit_string_runtime = project_file.package not in self.string_runtime_packages
+        transpiler.source_file = file_path
+        
+        # Classes and interfaces declared in the files of the package, in package order
+        # (so every file agrees on which part of a partial class declares its type)
+        for sibling in self.project_manager.packages.get(project_file.package, []):
+            if sibling.program:
+                transpiler.register_declarations(sibling.program)
         
         # Transpile the program
         program = project_file.program
         
-        # Modify imports if necessary
-        if self.has_exceptions and self._program_uses_exceptions(program):
-            # Add import for exceptions if using exceptions
-            from ast_nodes import ImportDecl
-            go_mod_name = self.project_manager.config.go_mod_name
-            exceptions_import = ImportDecl(f"{go_mod_name}/exceptions")
-            program.imports.append(exceptions_import)
+        # Imported packages come first in the transpilation order, so their classes are complete
+        packages = self.project_manager.package_references(project_file)
+        for package in sorted(packages):
+            if package in self.package_classes:
+                transpiler.register_package_classes(package, self.package_classes[package])
+            # Symbol table of the package, so undefined references to it are reported with their position
+            files = self.project_manager.packages[package]
+            transpiler.register_package_symbols(package, [f.program for f in files if f.program],
+                                                self.package_classes.get(package))
+        self._resolve_package_imports(program, packages)
+        
+        # The exception runtime is declared once, by the exceptions package: references to it are qualified
+        # and the package imported (the import is dropped from files that do not refer to it)
+        if self.has_exceptions:
+            transpiler.exceptions_package = 'exceptions'
+            exceptions_path = f"{self.project_manager.config.go_mod_name}/exceptions"
+            if not any(imp.path.strip('"') == exceptions_path for imp in program.imports):
+                program.imports.append(ImportDecl(exceptions_path))
         
         # Transpile
         go_code = transpiler.transpile(program)
-        
-        # Remove duplicate exception definitions if present
-        if self.has_exceptions:
-            go_code = self._remove_exception_definitions(go_code)
-        
-        return go_code
+        if report:
+            self.project_manager.diagnostics.extend(transpiler.warnings)
+            report_warnings(transpiler.warnings, file_path, self.project_manager.project_root)
+        return CachedUnit(go_code, transpiler.line_origins, transpiler.warnings, transpiler.classes,
+                          transpiler.uses_class_metadata, transpiler.uses_string_runtime)
     
-    def _program_uses_exceptions(self, program) -> bool:
-        """Check if the program uses exceptions"""
-        return self.project_manager._file_uses_exceptions(program)
+    def restore(self, project_file: ProjectFile, file_path: str, unit: CachedUnit) -> None:
+        """Takes the output of a file from the build cache, as if it was transpiled again: its warnings are
+        reported again"""
+        self.project_manager.diagnostics.extend(unit.warnings)
+        report_warnings(unit.warnings, file_path, self.project_manager.project_root)
+        self.record(project_file, unit)
+        project_file.line_origins = unit.line_origins
     
-    def _remove_exception_definitions(self, go_code: str) -> str:
-        """Remove duplicate exception definitions"""
-        lines = go_code.split('\n')
-        filtered_lines = []
-        skip_block = False
-        in_import_block = False
-        
-        i = 0
-        while i < len(lines):
-            line = lines[i]
-            
-            # Detect start of exception block
-            if '// Exception types' in line:
-                skip_block = True
-                # Skip until finding a blank line after the block
-                while i < len(lines) and not (lines[i].strip() == '' and i + 1 < len(lines) and 
-                                             (lines[i + 1].startswith('type ') or lines[i + 1].startswith('func ') or 
-                                              lines[i + 1].strip() == '')):
-                    i += 1
-                skip_block = False
-                i += 1
-                continue
-            
-            # Detect import block
-            if line.strip() == 'import (':
-                in_import_block = True
-                # Collect all imports
-                import_lines = [line]
-                i += 1
-                while i < len(lines) and lines[i].strip() != ')':
-                    import_line = lines[i]
-                    # Remove fmt and errors imports if only for exceptions
-                    if import_line.strip() not in ['"fmt"', '"errors"']:
-                        import_lines.append(import_line)
-                    i += 1
-                
-                # Add closing parenthesis
-                if i < len(lines):
-                    import_lines.append(lines[i])  # )
-                
-                # Only add block if it contains more than fmt/errors
-                if len(import_lines) > 2:  # More than import( and )
-                    filtered_lines.extend(import_lines)
-                
-                in_import_block = False
-                i += 1
-                continue
-            
-            if not skip_block:
-                filtered_lines.append(line)
-            
-            i += 1
-        
-        return '\n'.join(filtered_lines)
+    def record(self, project_file: ProjectFile, unit: CachedUnit) -> None:
+        """Keeps what the files transpiled after this one need from it, and frees the function and method bodies
+        of its AST; unlike restore, reports nothing (for the cached files of a -p worker, whose warnings the main
+        process reports)"""
+        if unit.uses_class_metadata:
+            self.class_runtime_packages.add(project_file.package)
+        if unit.uses_string_runtime:
+            self.string_runtime_packages.add(project_file.package)
+        self.package_classes[project_file.package] = unit.classes
+        if project_file.program:
+            Transpiler(project_mode=True).release_bodies(project_file.program)
+    
+    def _resolve_package_imports(self, program: Program, packages: Set[str]) -> None:
+        """Imports project packages by module path, adding the ones only a base class refers to"""
+        paths = {package: self.project_manager.package_import_path(package) for package in packages}
+        for imp in program.imports:
+            import_path = imp.path.strip('"')
+            if import_path in paths:
+                imp.path = paths[import_path]
+        imported = {imp.path.strip('"') for imp in program.imports}
+        for package in sorted(packages):
+            if paths[package] not in imported:
+                program.imports.append(ImportDecl(paths[package]))
+
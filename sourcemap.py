@@ -0,0 +1,137 @@
+"""
+Source maps for Go-Extended
+Relates the lines of generated Go files to the go-plus lines they were generated from
+"""
+
+import os
+import re
+import json
+import difflib
+from pathlib import Path
+from typing import Dict, List, Optional, Tuple
+
+# go-plus span of a generated line: (file, line, column, end line, end column), or None for code with no
+# go-plus counterpart
+Origin = Optional[Tuple[Optional[str], int, int, int, int]]
+
+def directive_path(source: str, source_root: Path, output_file: Path) -> str:
+    """Path of a go-plus file as a //line directive names it: Go reads relative paths from the directory of the
+    file holding the directive"""
+    source_path = Path(os.path.abspath(source_root / source))
+    return Path(os.path.relpath(source_path, os.path.abspath(output_file.parent))).as_posix()
+
+def realign(old_code: str, new_code: str, origins: List[Origin]) -> List[Origin]:
+    """Carries the line origins of generated code over to a reformatted version of it: lines are matched by their
+    text with whitespace ignored (a formatter reindents, respaces and drops blank lines); unmatched lines get the
+    origin of the nearest matched line above them"""
+    def normalized(code: str) -> List[str]:
+        return [re.sub(r'\s+', '', line) for line in code.split('\n')]
+    
+    old_lines, new_lines = normalized(old_code), normalized(new_code)
+    result: List[Origin] = [None] * len(new_lines)
+    matcher = difflib.SequenceMatcher(None, old_lines, new_lines, autojunk=False)
+    for old_start, new_start, size in matcher.get_matching_blocks():
+        for offset in range(size):
+            old_index = old_start + offset
+            result[new_start + offset] = origins[old_index] if old_index < len(origins) else None
+    for index, line in enumerate(new_lines):
+        if result[index] is None and line and index > 0:
+            result[index] = result[index - 1]
+    return result
+
+def ends_in_raw_string(line: str, inside: bool) -> bool:
+    """Whether a raw string (`...`) is open at the end of a Go line, given whether one was open at its start"""
+    i = 0
+    while i < len(line):
+        char = line[i]
+        if inside:
+            inside = char != '`'
+        elif char == '`':
+            inside = True
+        elif char in '"\'':
+            # Interpreted string or rune: skip to its closing quote
+            i += 1
+            while i < len(line) and line[i] != char:
+                i += 2 if line[i] == '\\' else 1
+        elif line.startswith('//', i):
+            break
+        i += 1
+    return inside
+
+def line_directives(go_code: str, origins: List[Origin], output_file: Path,
+                    source_root: Path = Path('.')) -> Tuple[str, List[Origin]]:
+    """Inserts //line directives so Go compiler errors, panics and stack traces point at the go-plus source.
+    A directive is only written where the generated lines stop following the source line by line (the lines
+    generated from one statement count on from its line); generated code after mapped code is pointed back at
+    the Go file itself. Returns the code and the origins of its lines (None for the directives)"""
+    lines = go_code.split('\n')
+    result: List[str] = []
+    result_origins: List[Origin] = []
+    expected: Optional[Tuple[str, int]] = None  # position Go assigns to the next line
+    previous: Optional[Tuple[str, int]] = None  # go-plus line of the last mapped line
+    in_raw_string = False  # a directive inside a raw string would become part of its value
+    for index, line in enumerate(lines):
+        origin = origins[index] if index < len(origins) else None
+        if in_raw_string or not line.strip():
+            pass
+        elif origin and origin[0]:
+            position = (directive_path(origin[0], source_root, output_file), origin[1])
+            if position != previous and position != expected:
+                # The column is that of the first character of the line: the indentation is discounted so a
+                # line mirroring the source reports the columns of the source
+                indent = len(line) - len(line.lstrip())
+                result.append(f'//line {position[0]}:{position[1]}:{max(1, origin[2] - indent)}')
+                result_origins.append(None)
+                expected = position
+            previous = position
+        elif expected:
+            # Back to the generated file: the line after the directive is the next line of the output
+            result.append(f'//line {output_file.name}:{len(result) + 2}')
+            result_origins.append(None)
+            expected = previous = None
+        result.append(line)
+        result_origins.append(origin)
+        if expected:
+            expected = (expected[0], expected[1] + 1)
+        in_raw_string = ends_in_raw_string(line, in_raw_string)
+    return '\n'.join(result), result_origins
+
+def source_map(go_code: str, origins: List[Origin], output_file: Path, source_root: Path = Path('.')) -> Dict:
+    """Source map of a generated file: each run of lines generated from one go-plus node maps its generated span
+    to the node's span. Lines and columns are 1-based with exclusive ends; source paths are relative to the
+    directory of the generated file, where the map is written"""
+    sources: List[str] = []
+    mappings: List[Dict] = []
+    last: Origin = None  # origin of the last mapped line
+    for index, line in enumerate(go_code.split('\n')):
+        origin = origins[index] if index < len(origins) else None
+        if not line.strip():
+            continue
+        if not origin or not origin[0]:
+            last = None
+            continue
+        end = {'line': index + 1, 'column': len(line) + 1}
+        if last == origin:
+            # Another line of the same node (blank lines in between) extends its generated span
+            mappings[-1]['generated']['end'] = end
+        else:
+            path = directive_path(origin[0], source_root, output_file)
+            if path not in sources:
+                sources.append(path)
+            _, line_number, column, end_line, end_column = origin
+            mappings.append({
+                'generated': {'start': {'line': index + 1, 'column': len(line) - len(line.lstrip()) + 1},
+                              'end': end},
+                'source': sources.index(path),
+                'original': {'start': {'line': line_number, 'column': column},
+                             'end': {'line': end_line or line_number, 'column': end_column or column}},
+            })
+        last = origin
+    return {'version': 1, 'file': output_file.name, 'sources': sources, 'mappings': mappings}
+
+def write_source_map(go_code: str, origins: List[Origin], output_file: Path, source_root: Path = Path('.')) -> Path:
+    """Writes the source map of a generated file next to it (main.go -> main.go.map); returns its path"""
+    map_file = output_file.with_name(output_file.name + '.map')
+    with open(map_file, 'w', encoding='utf-8') as f:
+        json.dump(source_map(go_code, origins, output_file, source_root), f, indent=2)
+    return map_file
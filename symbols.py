@@ -0,0 +1,175 @@
+"""
+Exported symbol index of Go-Extended projects
+Lists what each file of a project exports (classes with their fields, methods and constructor, interfaces, enums,
+functions, constants, the exception types thrown), with positions and doc comments, as JSON for editors, search
+and documentation tools. Each file keeps its entries until its source changes
+"""
+
+import json
+from pathlib import Path
+from typing import Dict, Iterator, List, Optional
+from ast_nodes import *
+
+# Version of the index format: an index of another version is rebuilt from scratch
+INDEX_VERSION = 1
+
+def is_exported(name: str) -> bool:
+    return name[:1].isupper()
+
+def doc_comment(node: ASTNode) -> str:
+    """Text of the comments above a node, without their markers; directives (//goplus:...) are left out"""
+    lines = []
+    for comment in node.comments:
+        if comment.startswith('//goplus:'):
+            continue
+        if comment.startswith('//'):
+            lines.append(comment[2:].strip())
+        else:
+            lines.extend(line.strip().lstrip('*').strip() for line in comment[2:-2].strip().splitlines())
+    return '\n'.join(lines)
+
+def signature(name: str, params: List[Parameter], return_type: Optional[str], type_params: List[TypeParam] = (),
+              nullable_result: bool = False) -> str:
+    """Signature of a function or method as the source declares it: Find[T any](items []T, key string) T?"""
+    generics = f"[{', '.join(f'{p.name} {p.constraint}' for p in type_params)}]" if type_params else ''
+    parameters = ', '.join(f"{p.name} {p.type}{'?' if p.nullable else ''}" for p in params)
+    result = f" {return_type}{'?' if nullable_result else ''}" if return_type else ''
+    return f'{name}{generics}({parameters}){result}'
+
+def exception_type(throw: ThrowStmt) -> Optional[str]:
+    """Type of a thrown new Exception("InvalidAge", ...) or NewException("InvalidAge", ...); None for a rethrown
+    variable"""
+    value = throw.expression
+    if isinstance(value, CallExpr) and isinstance(value.function, Identifier) and \
+            value.function.name == 'NewException':
+        args = value.args
+    elif isinstance(value, NewExpr):
+        args = value.args
+    else:
+        return None
+    if args and isinstance(args[0], Literal) and args[0].type == 'string':
+        return args[0].value
+    return None
+
+def thrown(node, caught: frozenset = frozenset()) -> Iterator[ThrowStmt]:
+    """Throws of a subtree that escape it: not handled by a catch of the subtree for their type or by a catch-all
+    (lambdas and class bodies excluded)"""
+    if isinstance(node, ThrowStmt):
+        if 'Exception' not in caught and exception_type(node) not in caught:
+            yield node
+    elif isinstance(node, TryStmt):
+        kinds = {catch.exception_type or 'Exception' for catch in node.catch_blocks}
+        yield from thrown(node.body, caught | kinds)
+        yield from thrown([node.catch_blocks, node.finally_block], caught)
+    elif isinstance(node, (list, tuple)):
+        for item in node:
+            yield from thrown(item, caught)
+    elif isinstance(node, ASTNode) and not isinstance(node, (LambdaExpr, ClassDecl)):
+        for child in children(node):
+            yield from thrown(child, caught)
+
+def throws(body: Optional[BlockStmt]) -> List[str]:
+    """Exception types a body lets escape, in name order"""
+    return sorted({exception_type(t) for t in thrown(body) if exception_type(t)})
+
+def symbol(node: ASTNode, name: str, kind: str, **details) -> Dict:
+    entry = {'name': name, 'kind': kind, 'line': node.line, 'column': node.column, 'end_line': node.end_line,
+             'end_column': node.end_column, 'doc': doc_comment(node)}
+    entry.update(details)
+    return entry
+
+def member_symbols(decl) -> List[Dict]:
+    """Exported members of a class, object, mixin or enum, in declaration order"""
+    members = []
+    constructor = getattr(decl, 'constructor', None)
+    if constructor:
+        members.append(symbol(constructor, decl.name, 'constructor',
+                              signature=signature(f'new {decl.name}', constructor.params, None),
+                              throws=throws(constructor.body)))
+    for constant in getattr(decl, 'constants', []):
+        if is_exported(constant.name):
+            members.append(symbol(constant, constant.name, 'constant', type=constant.type))
+    for field_decl in decl.fields:
+        if is_exported(field_decl.name):
+            members.append(symbol(field_decl, field_decl.name, 'field', type=field_decl.type))
+    for member in getattr(decl, 'members', []):
+        members.append(symbol(member, member.name, 'enum member'))
+    for event in getattr(decl, 'events', []):
+        if is_exported(event.name):
+            members.append(symbol(event, event.name, 'event', signature=signature(event.name, event.params, None)))
+    for method in decl.methods:
+        if is_exported(method.name) and not method.operator:
+            members.append(symbol(method, method.name, 'method',
+                                  signature=signature(method.name, method.params, method.return_type,
+                                                      method.type_params, method.nullable_result),
+                                  throws=throws(method.body)))
+    return members
+
+def file_symbols(program: Program) -> List[Dict]:
+    """Exported symbols of a parsed file, in declaration order, then the exception types it throws"""
+    symbols = []
+    for decl in program.declarations:
+        name = getattr(decl, 'name', '')
+        if not is_exported(name):
+            continue
+        if isinstance(decl, ClassDecl):
+            kind = 'record' if decl.is_record else 'data class' if decl.is_data else 'class'
+            symbols.append(symbol(decl, name, kind, extends=decl.extends, implements=decl.implements,
+                                  members=member_symbols(decl)))
+        elif isinstance(decl, (ObjectDecl, MixinDecl, EnumDecl)):
+            kind = {ObjectDecl: 'object', MixinDecl: 'mixin', EnumDecl: 'enum'}[type(decl)]
+            symbols.append(symbol(decl, name, kind, members=member_symbols(decl)))
+        elif isinstance(decl, InterfaceDecl):
+            methods = [symbol(m, m.name, 'method', signature=signature(m.name, m.params, m.return_type,
+                                                                       nullable_result=m.nullable_result))
+                       for m in decl.methods]
+            symbols.append(symbol(decl, name, 'interface', members=methods))
+        elif isinstance(decl, StructDecl):
+            fields = [symbol(f, f.name, 'field', type=f.type) for f in decl.fields if is_exported(f.name)]
+            symbols.append(symbol(decl, name, 'struct', members=fields))
+        elif isinstance(decl, FuncDecl):
+            symbols.append(symbol(decl, name, 'func',
+                                  signature=signature(name, decl.params, decl.return_type, decl.type_params,
+                                                      decl.nullable_result),
+                                  throws=throws(decl.body)))
+        elif isinstance(decl, (ConstDecl, VarDecl, TypeDecl)):
+            kind = {ConstDecl: 'const', VarDecl: 'var', TypeDecl: 'type'}[type(decl)]
+            symbols.append(symbol(decl, name, kind, type=decl.type))
+        elif isinstance(decl, AnnotationDecl):
+            symbols.append(symbol(decl, name, 'annotation', signature=signature(name, decl.params, None)))
+
+    # Exception types are strings, not declarations: each one is located at its first throw in the source
+    first: Dict[str, ThrowStmt] = {}
+
+    def visit(node: Optional[ASTNode]) -> bool:
+        kind = exception_type(node) if isinstance(node, ThrowStmt) else None
+        if kind and (kind not in first or (node.line, node.column) < (first[kind].line, first[kind].column)):
+            first[kind] = node
+        return True
+
+    inspect(program, visit)
+    throws_in_order = sorted(first.items(), key=lambda item: (item[1].line, item[1].column))
+    symbols.extend(symbol(throw, kind, 'exception') for kind, throw in throws_in_order)
+    return symbols
+
+def load_index(path: Path) -> Dict[str, Dict]:
+    """Entries of an index file by source path; none when it is missing, unreadable or of another version"""
+    try:
+        with open(path, 'r', encoding='utf-8') as f:
+            index = json.load(f)
+    except (OSError, ValueError):
+        return {}
+    if not isinstance(index, dict) or index.get('version') != INDEX_VERSION:
+        return {}
+    return index.get('files', {})
+
+def write_index(path: Path, entries: Dict[str, Dict]) -> bool:
+    """Writes an index unless the file already holds it (editors watch it); True when it is written"""
+    text = json.dumps({'version': INDEX_VERSION, 'files': dict(sorted(entries.items()))}, indent=2,
+                      ensure_ascii=False) + '\n'
+    if path.exists() and path.read_text(encoding='utf-8') == text:
+        return False
+    partial = path.with_name(path.name + '.tmp')
+    partial.write_text(text, encoding='utf-8')
+    partial.replace(path)
+    return True
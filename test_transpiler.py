@@ -4,15 +4,20 @@ Tests for the Go-Extended transpiler
 """
 
 import os
+import json
 import sys
 from pathlib import Path
 
 # Adiciona o diretório atual ao path
 sys.path.insert(0, str(Path(__file__).parent))
 
-from lexer import Lexer
-from parser import Parser
-from transpiler import Transpiler
+from ast_nodes import BinaryExpr, CastExpr, Identifier, ImportDecl
+from tokens import TokenType
+from lexer import Lexer, LexerError
+from parser import Parser, ParseError
+from transpiler import Transpiler, TranspilerError, parse_warning_levels, close_names
+from linter import Linter
+from diagnostics import diagnostic_entries, format_diagnostics, render_entry, report_warnings
 
 def test_lexer():
     """Tests the lexer"""
@@ -120,6 +125,5932 @@ def test_transpiler():
     
     print("Transpiler OK!\n")
 
+def test_interfaces():
+    """Tests interface conformance checking"""
+    print("=== Testing Interfaces ===")
+    
+    code = '''
+    package main
+    
+    import "fmt"
+    
+    interface Printable {
+        Print()
+        Describe() string
+    }
+    
+    class Person implements Printable {
+        name string
+        
+        func Print() {
+            fmt.Println(this.name)
+        }
+        
+        func Describe() string {
+            return this.name
+        }
+    }
+    
+    class Student extends Person implements Printable {
+        school string
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'var _ Printable = (*Person)(nil)' in go_code
+    assert 'var _ Printable = (*Student)(nil)' in go_code
+    
+    broken = '''
+    package main
+    
+    interface Printable {
+        Print()
+    }
+    
+    class Person implements Printable {
+        func Print(prefix string) {
+        }
+    }
+    '''
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(broken).tokenize()).parse())
+        raise AssertionError("Expected conformance error")
+    except TranspilerError as e:
+        print(f"Conformance error: {e}")
+    
+    print("Interfaces OK!\n")
+
+def test_generics():
+    """Tests generic classes and functions"""
+    print("=== Testing Generics ===")
+    
+    code = '''
+    package main
+    
+    interface Container<T> {
+        Get(i int) T
+    }
+    
+    class Stack<T> implements Container<T> {
+        items []T
+        
+        func Push(item T) {
+            this.items = append(this.items, item)
+        }
+        
+        func Get(i int) T {
+            return this.items[i]
+        }
+    }
+    
+    class IntStack extends Stack<int> implements Container<int> {
+    }
+    
+    func Map<T, R any>(xs []T, f func(T) R) []R {
+        var out []R
+        return out
+    }
+    
+    func main() {
+        s := new Stack<Stack<int>>()
+        c := make(map[string]int)
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type Stack[T any] struct {' in go_code
+    assert 'func (this *Stack[T]) Push(item T) {' in go_code
+    assert 'var _ Container[T] = (*Stack[T])(nil)' in go_code
+    assert 'var _ Container[int] = (*IntStack)(nil)' in go_code
+    assert 'func Map[T any, R any](xs []T, f func(T) R) []R {' in go_code
+    assert 's := NewStack[Stack[int]]()' in go_code
+    assert 'c := make(map[string]int)' in go_code
+    
+    mismatch = code.replace('implements Container<int>', 'implements Container<string>')
+    try:
+        Transpiler().transpile(Parser(Lexer(mismatch).tokenize()).parse())
+        raise AssertionError("Expected conformance error")
+    except TranspilerError as e:
+        print(f"Conformance error: {e}")
+    
+    print("Generics OK!\n")
+
+def test_operator_overloading():
+    """Tests operator overloads on classes"""
+    print("=== Testing Operator Overloading ===")
+    
+    code = '''
+    package main
+    
+    class Money {
+        cents int
+        
+        Money(c int) {
+            this.cents = c
+        }
+        
+        operator +(other *Money) *Money {
+            return new Money(this.cents + other.cents)
+        }
+        
+        operator ==(other *Money) bool {
+            return this.cents == other.cents
+        }
+        
+        operator <(other *Money) bool {
+            return this.cents < other.cents
+        }
+    }
+    
+    func main() {
+        a := new Money(1)
+        b := new Money(2)
+        c := a + b
+        c += a
+        same := a == b
+        different := a != b
+        greater := a > b
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func (this *Money) Add(other *Money) *Money {' in go_code
+    assert 'c := a.Add(b)' in go_code
+    assert 'c = c.Add(a)' in go_code
+    assert 'same := a.Equals(b)' in go_code
+    assert 'different := !a.Equals(b)' in go_code
+    assert 'greater := b.Less(a)' in go_code
+    
+    undefined = code.replace('c += a', 'd := a * b')
+    try:
+        Transpiler().transpile(Parser(Lexer(undefined).tokenize()).parse())
+        raise AssertionError("Expected undefined operator error")
+    except TranspilerError as e:
+        print(f"Operator error: {e}")
+    
+    print("Operator Overloading OK!\n")
+
+def test_indexers():
+    """Tests index get/set operators on classes"""
+    print("=== Testing Indexers ===")
+    
+    code = '''
+    package main
+    
+    class Cache {
+        values map[string]int
+        
+        operator [](key string) (int, bool) {
+            value, ok := this.values[key]
+            return value, ok
+        }
+        
+        operator []=(key string, value int) {
+            this.values[key] = value
+        }
+    }
+    
+    func main() {
+        cache := new Cache()
+        cache["k"] = 1
+        cache["k"] += 2
+        x := cache["k"]
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func (this *Cache) IndexLookup(key string) (int, bool) {' in go_code
+    assert 'func (this *Cache) IndexGet(key string) int {' in go_code
+    assert 'panic(NewException("KeyNotFound"' in go_code
+    assert 'cache.IndexSet("k", 1)' in go_code
+    assert 'x := cache.IndexGet("k")' in go_code
+    
+    print("Indexers OK!\n")
+
+def test_enums():
+    """Tests enums, associated values and switch exhaustiveness"""
+    print("=== Testing Enums ===")
+    
+    code = '''
+    package main
+    
+    enum Color {
+        Red, Green, Blue
+        
+        func IsWarm() bool {
+            return this == Color.Red
+        }
+    }
+    
+    enum Planet(mass float64) {
+        Mercury(3.3),
+        Earth(5.9)
+        
+        func Mass() float64 {
+            return this.mass
+        }
+    }
+    
+    func describe(c Color) string {
+        switch c {
+        case Red:
+            return "warm"
+        case Color.Green, Blue:
+            return "cool"
+        }
+        return ""
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'ColorRed Color = iota' in go_code
+    assert 'func (this Color) String() string {' in go_code
+    assert 'func ColorValues() []Color {' in go_code
+    assert 'func ColorFromString(name string) (Color, bool) {' in go_code
+    assert 'return planetTable[this].mass' in go_code
+    assert 'case ColorGreen, ColorBlue:' in go_code
+    
+    partial = code.replace('case Color.Green, Blue:', 'case Color.Green:')
+    try:
+        Transpiler().transpile(Parser(Lexer(partial).tokenize()).parse())
+        raise AssertionError("Expected exhaustiveness error")
+    except TranspilerError as e:
+        print(f"Exhaustiveness error: {e}")
+    
+    print("Enums OK!\n")
+
+def test_data_classes():
+    """Tests data classes and their generated members"""
+    print("=== Testing Data Classes ===")
+    
+    code = '''
+    package main
+    
+    data class Point(x, y float64)
+    
+    data class Tagged(point *Point, tags []string) {
+        func String() string {
+            return "tagged"
+        }
+    }
+    
+    func same(a *Point, b *Point) bool {
+        return a == b
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func NewPoint(x float64, y float64) *Point {' in go_code
+    assert 'func (this *Point) GetX() float64 {' in go_code
+    assert 'func (this *Point) Equals(other *Point) bool {' in go_code
+    assert 'func (this *Point) HashCode() uint64 {' in go_code
+    assert 'return fmt.Sprintf("Point(x=%v, y=%v)", this.x, this.y)' in go_code
+    assert 'return a.Equals(b)' in go_code
+    assert 'this.point.Equals(other.point) && reflect.DeepEqual(this.tags, other.tags)' in go_code
+    assert '"hash/fnv"' in go_code and '"reflect"' in go_code
+    assert go_code.count('func (this *Tagged) String() string {') == 1
+    
+    print("Data classes OK!\n")
+
+def test_records():
+    """Tests immutable records and with expressions"""
+    print("=== Testing Records ===")
+    
+    code = '''
+    package main
+    
+    record Person(name string, age int)
+    
+    func birthday(p *Person) *Person {
+        return p with { age: p.GetAge() + 1 }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func NewPerson(name string, age int) *Person {' in go_code
+    assert 'func (this *Person) copyWith(set func(*Person)) *Person {' in go_code
+    assert 'return p.copyWith(func(c *Person) { c.age = p.GetAge() + 1 })' in go_code
+    
+    mutation = code.replace('return p with { age: p.GetAge() + 1 }', 'p.age = 30\n        return p')
+    try:
+        Transpiler().transpile(Parser(Lexer(mutation).tokenize()).parse())
+        raise AssertionError("Expected immutability error")
+    except TranspilerError as e:
+        print(f"Immutability error: {e}")
+    
+    print("Records OK!\n")
+
+def test_mixins():
+    """Tests mixins flattened into classes and conflict detection"""
+    print("=== Testing Mixins ===")
+    
+    code = '''
+    package main
+    
+    mixin Auditable {
+        createdBy string
+        
+        func Audit() string {
+            return this.createdBy
+        }
+    }
+    
+    mixin Serializable {
+        func Serialize() string {
+            return "{}"
+        }
+    }
+    
+    class Student with Serializable, Auditable {
+        school string
+        
+        func Audit() string {
+            return this.school
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'createdBy string' in go_code
+    assert 'func (this *Student) Serialize() string {' in go_code
+    assert go_code.count('func (this *Student) Audit() string {') == 1
+    assert 'return this.school' in go_code
+    
+    conflicting = code.replace('func Serialize() string', 'createdBy string\n        func Serialize() string')
+    try:
+        Transpiler().transpile(Parser(Lexer(conflicting).tokenize()).parse())
+        raise AssertionError("Expected mixin conflict error")
+    except TranspilerError as e:
+        assert 'line' in str(e)
+        print(f"Conflict error: {e}")
+    
+    inherited = code.replace('class Student with Serializable, Auditable {',
+                             'class Person with Auditable {\n        func Serialize() string {\n'
+                             '            return "person"\n        }\n    }\n    \n'
+                             '    class Student extends Person with Serializable, Auditable {')
+    try:
+        Transpiler().transpile(Parser(Lexer(inherited).tokenize()).parse())
+        raise AssertionError("Expected base class conflict error")
+    except TranspilerError as e:
+        assert "override Serialize from Person" in str(e)
+        print(f"Conflict error: {e}")
+    
+    resolved = inherited.replace('school string', 'school string\n        override Serialize from Serializable')
+    go_code = Transpiler().transpile(Parser(Lexer(resolved).tokenize()).parse())
+    assert 'func (this *Student) Serialize() string {\n    return "{}"' in go_code
+    # Auditable is inherited from Person (diamond), not flattened into Student again
+    assert go_code.count('createdBy string') == 1
+    
+    print("Mixins OK!\n")
+
+def test_nested_classes():
+    """Tests nested and inner classes"""
+    print("=== Testing Nested Classes ===")
+    
+    code = '''
+    package main
+    
+    class Car {
+        model string
+        
+        func Start() *Engine {
+            return new Engine()
+        }
+        
+        inner class Engine {
+            func Model() string {
+                return outer.model
+            }
+        }
+        
+        class Wheel {
+            size int
+        }
+    }
+    
+    func build(car *Car) *Car.Wheel {
+        engine := new car.Engine()
+        return new Car.Wheel()
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type CarEngine struct {\n    outer *Car' in go_code
+    assert 'func NewCarEngine(outer *Car) *CarEngine {' in go_code
+    assert 'func (this *Car) Start() *CarEngine {\n    return NewCarEngine(this)' in go_code
+    assert 'return this.outer.model' in go_code
+    assert 'func build(car *Car) *CarWheel {' in go_code
+    assert 'engine := NewCarEngine(car)' in go_code
+    assert 'return NewCarWheel()' in go_code
+    
+    unbound = code.replace('new car.Engine()', 'new Car.Engine()')
+    try:
+        Transpiler().transpile(Parser(Lexer(unbound).tokenize()).parse())
+        raise AssertionError("Expected outer instance error")
+    except TranspilerError as e:
+        print(f"Outer instance error: {e}")
+    
+    print("Nested classes OK!\n")
+
+def test_anonymous_classes():
+    """Tests anonymous classes implementing interfaces and extending classes"""
+    print("=== Testing Anonymous Classes ===")
+    
+    code = '''
+    package main
+    
+    interface ClickHandler {
+        OnClick()
+    }
+    
+    class Base {
+        name string
+        
+        Base(name string) {
+            this.name = name
+        }
+    }
+    
+    func main() {
+        listener := new ClickHandler {
+            clicks int = 0
+            
+            func OnClick() {
+                this.clicks = this.clicks + 1
+            }
+        }
+        other := new Base("x") {
+            func Name() string {
+                return this.name
+            }
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'listener := &mainClickHandler1{clicks: 0}' in go_code
+    assert 'type mainClickHandler1 struct {' in go_code
+    assert 'var _ ClickHandler = (*mainClickHandler1)(nil)' in go_code
+    assert 'other := &mainBase1{Base: *NewBase("x")}' in go_code
+    assert 'func (this *mainBase1) Name() string {' in go_code
+    assert 'func NewmainClickHandler1' not in go_code
+    
+    print("Anonymous classes OK!\n")
+
+def test_objects():
+    """Tests singleton object declarations"""
+    print("=== Testing Objects ===")
+    
+    code = '''
+    package main
+    
+    object Config {
+        values map[string]string = make(map[string]string)
+        
+        func Get(key string) string {
+            return this.values[key]
+        }
+    }
+    
+    func env() string {
+        return Config.Get("env")
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type ConfigObject struct {' in go_code
+    assert 'configOnce sync.Once' in go_code
+    assert 'func Config() *ConfigObject {' in go_code
+    assert 'configInstance.values = make(map[string]string)' in go_code
+    assert 'return Config().Get("env")' in go_code
+    assert '"sync"' in go_code
+    assert 'func NewConfigObject' not in go_code
+    
+    print("Objects OK!\n")
+
+def test_static_blocks():
+    """Tests static initializer blocks and their init() ordering"""
+    print("=== Testing Static Blocks ===")
+    
+    code = '''
+    package main
+    
+    var names []string
+    
+    class Student extends Person {
+        static {
+            names = append(names, "Student")
+        }
+    }
+    
+    class Person {
+        companion {
+            names = append(names, "Person")
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func studentStaticInit() {' in go_code
+    assert 'func personStaticInit() {' in go_code
+    assert 'func init() {\n    personStaticInit()\n    studentStaticInit()\n}' in go_code
+    
+    invalid = code.replace('names = append(names, "Person")', 'this.x = 1')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected static block error")
+    except TranspilerError as e:
+        print(f"Static block error: {e}")
+    
+    print("Static blocks OK!\n")
+
+def test_init_blocks():
+    """Tests init blocks inlined into constructors"""
+    print("=== Testing Init Blocks ===")
+    
+    code = '''
+    package main
+    
+    class Base {
+        id int
+        
+        Base(id int) {
+            this.id = id
+        }
+    }
+    
+    class Widget extends Base {
+        size int = 3
+        tags []string
+        
+        init {
+            this.tags = make([]string, 0, this.size)
+        }
+        
+        Widget(id int) {
+            super.Base(id)
+            this.tags = append(this.tags, "created")
+        }
+    }
+    
+    class Counter {
+        count int = 1
+        
+        init {
+            this.count = this.count * 10
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert ('obj := &Widget{size: 3}\n'
+            '    obj.Base = *NewBase(id)\n'
+            '    obj.tags = make([]string, 0, obj.size)\n'
+            '    obj.tags = append(obj.tags, "created")') in go_code
+    assert 'obj := &Counter{count: 1}\n    obj.count = obj.count * 10\n    return obj' in go_code
+    
+    print("Init blocks OK!\n")
+
+def test_disposal():
+    """Tests destructors, Dispose and using statements"""
+    print("=== Testing Disposal ===")
+    
+    code = '''
+    package main
+    
+    class FileHandle {
+        name string
+        
+        ~FileHandle() {
+            this.name = ""
+        }
+    }
+    
+    class Plain {
+        name string
+    }
+    
+    func main() {
+        using (f := new FileHandle()) {
+            f.name = "a.txt"
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'runtime.SetFinalizer(obj, (*FileHandle).Dispose)' in go_code
+    assert 'func (this *FileHandle) Dispose() {\n    runtime.SetFinalizer(this, nil)' in go_code
+    assert 'f := NewFileHandle()\n        defer f.Dispose()' in go_code
+    
+    invalid = code.replace('f := new FileHandle()', 'f := new Plain()')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected missing Dispose error")
+    except TranspilerError as e:
+        print(f"Using error: {e}")
+    
+    print("Disposal OK!\n")
+
+def test_cloneable():
+    """Tests @cloneable deep copies"""
+    print("=== Testing Cloneable ===")
+    
+    code = '''
+    package main
+    
+    class Entity {
+        tags []string
+    }
+    
+    @cloneable
+    class Node extends Entity {
+        next *Node
+        children []*Node
+        index map[string]*Node
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func (this *Node) Clone() *Node {\n    return this.cloneWith(make(map[any]any))' in go_code
+    assert 'if copied, ok := seen[this]; ok {' in go_code
+    assert 'this.Entity.cloneFields(&c.Entity, seen)' in go_code
+    assert 'c.tags = append([]string(nil), this.tags...)' in go_code
+    assert 'c.next = this.next.cloneWith(seen)' in go_code
+    assert 'c.children[i] = item.cloneWith(seen)' in go_code
+    assert 'c.index[key] = value.cloneWith(seen)' in go_code
+    
+    unknown = code.replace('@cloneable', '@clonable')
+    try:
+        Transpiler().transpile(Parser(Lexer(unknown).tokenize()).parse())
+        raise AssertionError("Expected unknown annotation error")
+    except TranspilerError as e:
+        print(f"Annotation error: {e}")
+    
+    print("Cloneable OK!\n")
+
+def test_builders():
+    """Tests @builder classes"""
+    print("=== Testing Builders ===")
+    
+    code = '''
+    package main
+    
+    @builder
+    class Person {
+        name string
+        age int
+        
+        Person(name string, age int) {
+            this.name = name
+            this.age = age
+        }
+    }
+    
+    func main() {
+        p := new PersonBuilder().WithName("Ann").WithAge(30).Build()
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type PersonBuilder struct {' in go_code
+    assert 'func NewPersonBuilder() *PersonBuilder {' in go_code
+    assert 'func (this *PersonBuilder) WithName(name string) *PersonBuilder {' in go_code
+    assert 'func (this *PersonBuilder) Build() *Person {\n    return NewPerson(this.name, this.age)' in go_code
+    assert 'p := NewPersonBuilder().WithName("Ann").WithAge(30).Build()' in go_code
+    
+    print("Builders OK!\n")
+
+def test_accessors():
+    """Tests @accessors classes and get/set field flags"""
+    print("=== Testing Accessors ===")
+    
+    code = '''
+    package main
+    
+    @accessors
+    class Person {
+        name string
+        age int
+        
+        func validateAge(age int) {
+            if age < 0 {
+                throw NewException("InvalidAge", "Age cannot be negative")
+            }
+        }
+        
+        func GetName() string {
+            return "Name: " + this.name
+        }
+    }
+    
+    class Account {
+        owner string get
+        balance float64 = 0 get set
+        secret string
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert go_code.count('func (this *Person) GetName() string {') == 1
+    assert 'func (this *Person) SetName(name string) {\n    this.name = name\n}' in go_code
+    assert 'func (this *Person) SetAge(age int) {\n    this.validateAge(age)\n    this.age = age\n}' in go_code
+    assert 'func (this *Account) GetOwner() string {' in go_code
+    assert 'SetOwner' not in go_code
+    assert 'func (this *Account) SetBalance(balance float64) {' in go_code
+    assert 'GetSecret' not in go_code
+    
+    print("Accessors OK!\n")
+
+def test_struct_tags():
+    """Tests struct tags from field annotations"""
+    print("=== Testing Struct Tags ===")
+    
+    code = '''
+    package main
+    
+    class User {
+        Name string @json("name") @db("full_name")
+        Email string = "none" @json("email", "omitempty")
+        Age int get @json("-")
+        note string
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'Name string `json:"name" db:"full_name"`' in go_code
+    assert 'Email string `json:"email,omitempty"`' in go_code
+    assert 'Age int `json:"-"`' in go_code
+    assert 'func (this *User) GetAge() int {' in go_code
+    assert '    note string\n}' in go_code
+    
+    invalid = code.replace('@json("-")', '@json(1)')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected struct tag error")
+    except TranspilerError as e:
+        print(f"Struct tag error: {e}")
+    
+    print("Struct tags OK!\n")
+
+def test_json_classes():
+    """Tests @json classes"""
+    print("=== Testing JSON Classes ===")
+    
+    code = '''
+    package main
+    
+    class Entity {
+        id int @json("id")
+    }
+    
+    @json
+    class Person extends Entity {
+        name string
+        age int = 18 @json("age", "omitempty")
+        password string @json("-")
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert '    id int\n}' in go_code
+    assert 'func (this *Person) MarshalJSON() ([]byte, error) {' in go_code
+    assert ('var fields struct {\n'
+            '        Id int `json:"id"`\n'
+            '        Name string `json:"name"`\n'
+            '        Age int `json:"age,omitempty"`\n'
+            '    }') in go_code
+    assert 'fields.Id = this.Entity.id' in go_code
+    assert 'Password' not in go_code
+    assert 'func (this *Person) UnmarshalJSON(data []byte) error {' in go_code
+    assert 'return NewException("FormatException", fmt.Sprintf("cannot decode Person: %v", err))' in go_code
+    assert 'this.name = fields.Name' in go_code
+    assert 'func (this *Person) ParseJSON(data string) {' in go_code
+    assert '"encoding/json"' in go_code
+    
+    print("JSON classes OK!\n")
+
+def test_stringer():
+    """Tests @stringer classes"""
+    print("=== Testing Stringer ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+        age int
+    }
+    
+    @stringer
+    class Student extends Person {
+        school string
+    }
+    
+    @stringer("{name} ({age}%)")
+    class Teacher extends Person {
+        subject string
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert ('return fmt.Sprintf("Student(name=%v, age=%v, school=%v)", '
+            'this.Person.name, this.Person.age, this.school)') in go_code
+    assert 'return fmt.Sprintf("%v (%v%%)", this.Person.name, this.Person.age)' in go_code
+    
+    invalid = code.replace('{age}', '{salary}')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected stringer error")
+    except TranspilerError as e:
+        print(f"Stringer error: {e}")
+    
+    print("Stringer OK!\n")
+
+def test_comparable_classes():
+    """Tests sort helpers generated for CompareTo"""
+    print("=== Testing Comparable Classes ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        age int
+        
+        func CompareTo(other *Person) int {
+            return this.age - other.age
+        }
+    }
+    
+    class Box<T any> {
+        rank int
+        
+        func CompareTo(other *Box<T>) int {
+            return this.rank - other.rank
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type PersonSlice []*Person' in go_code
+    assert 'func (s PersonSlice) Less(i, j int) bool { return s[i].CompareTo(s[j]) < 0 }' in go_code
+    assert 'func (s PersonSlice) Sort() { sort.Stable(s) }' in go_code
+    assert 'type BoxSlice[T any] []*Box[T]' in go_code
+    assert 'func (s BoxSlice[T]) Swap(i, j int) { s[i], s[j] = s[j], s[i] }' in go_code
+    assert '"sort"' in go_code
+    
+    invalid = code.replace('CompareTo(other *Person) int', 'CompareTo(other *Person) bool')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected CompareTo signature error")
+    except TranspilerError as e:
+        print(f"Comparable error: {e}")
+    
+    print("Comparable classes OK!\n")
+
+def test_is_operator():
+    """Tests the is operator and narrowing"""
+    print("=== Testing Is Operator ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+    }
+    
+    class Student extends Person {
+        school string
+    }
+    
+    func describe(x any) string {
+        if x is Person && x.name != "" {
+            return x.name
+        }
+        if x is string {
+            return "text"
+        }
+        return ""
+    }
+    
+    func check(x any) bool {
+        return x is Student
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func asPerson(value any) (*Person, bool) {' in go_code
+    assert 'case *Student:\n        return &v.Person, true' in go_code
+    assert 'if xPerson, ok := asPerson(x); ok && xPerson.name != "" {' in go_code
+    assert 'return xPerson.name' in go_code
+    assert 'if _, ok := x.(string); ok {' in go_code
+    assert 'return isStudent(x)' in go_code
+    
+    invalid = code.replace('func check(x any) bool', 'func check(x *Person) bool')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected is error")
+    except TranspilerError as e:
+        print(f"Is error: {e}")
+    
+    print("Is operator OK!\n")
+
+def test_casts():
+    """Tests checked casts with as and as?"""
+    print("=== Testing Casts ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+    }
+    
+    class Student extends Person {
+        school string
+    }
+    
+    func convert(x any, s *Student) {
+        student := x as Student
+        maybe := x as? Student
+        person := s as Person
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'student := castStudent(x)' in go_code
+    assert 'maybe := func() *Student { v, _ := asStudent(x); return v }()' in go_code
+    assert 'person := &s.Person' in go_code
+    assert 'panic(NewException("ClassCastException", fmt.Sprintf("cannot cast %T to Student", value)))' in go_code
+    
+    invalid = code.replace('s as Person', 's as Student\n        other := new Person() as Student')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected cast error")
+    except TranspilerError as e:
+        print(f"Cast error: {e}")
+    
+    print("Casts OK!\n")
+
+def test_class_metadata():
+    """Tests class descriptors and GetType()"""
+    print("=== Testing Class Metadata ===")
+    
+    code = '''
+    package main
+    
+    interface Named {
+        Name() string
+    }
+    
+    class Person implements Named {
+        name string
+        
+        func Name() string {
+            return this.name
+        }
+    }
+    
+    class Student extends Person {
+        school string
+    }
+    
+    class Box<T any> {
+        value T
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert go_code.count('type ClassInfo struct {') == 1
+    assert 'func LookupClass(name string) (*ClassInfo, bool) {' in go_code
+    assert 'var personClass = RegisterClass(&ClassInfo{Name: "Person", Package: "main", Interfaces: []string{"Named"}})' in go_code
+    assert 'var studentClass = RegisterClass(&ClassInfo{Name: "Student", Package: "main", BaseName: "Person", Base: personClass})' in go_code
+    assert 'func (this *Student) GetType() *ClassInfo {\n    return studentClass' in go_code
+    assert 'func (this *Box[T]) GetType() *ClassInfo {' in go_code
+    
+    plain = Transpiler().transpile(Parser(Lexer('package main\n\nfunc main() {\n}').tokenize()).parse())
+    assert 'ClassInfo' not in plain
+    
+    print("Class metadata OK!\n")
+
+def test_partial_classes():
+    """Tests partial classes merged into one Go type"""
+    print("=== Testing Partial Classes ===")
+    
+    code = '''
+    package main
+    
+    interface Named {
+        Name() string
+    }
+    
+    partial class Person {
+        name string
+        
+        Person(name string) {
+            this.name = name
+        }
+    }
+    
+    partial class Person implements Named {
+        age int
+        
+        func Name() string {
+            return this.name
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert go_code.count('type Person struct {') == 1
+    assert 'type Person struct {\n    name string\n    age int\n}' in go_code
+    assert go_code.count('func NewPerson(') == 1
+    assert 'func (this *Person) Name() string {' in go_code
+    assert 'var _ Named = (*Person)(nil)' in go_code
+    
+    duplicate = code.replace('age int', 'name string')
+    try:
+        Transpiler().transpile(Parser(Lexer(duplicate).tokenize()).parse())
+        raise AssertionError("Expected duplicate member error")
+    except TranspilerError as e:
+        assert 'Duplicate member in partial class Person' in str(e)
+        print(f"Partial class error: {e}")
+    
+    mixed = code.replace('partial class Person implements', 'class Person implements')
+    try:
+        Transpiler().transpile(Parser(Lexer(mixed).tokenize()).parse())
+        raise AssertionError("Expected partial/non-partial error")
+    except TranspilerError as e:
+        print(f"Partial class error: {e}")
+    
+    print("Partial classes OK!\n")
+
+def test_iterators():
+    """Tests yield-based iterators"""
+    print("=== Testing Iterators ===")
+    
+    code = '''
+    package main
+    
+    interface Sequence {
+        Items() yield int
+    }
+    
+    class Tree implements Sequence {
+        value int
+        left *Tree
+        
+        func Items() yield int {
+            if this.left != nil {
+                for v := range this.left.Items() {
+                    yield v
+                }
+            }
+            yield this.value
+        }
+    }
+    
+    func Pairs(names []string) yield int, string {
+        for i, n := range names {
+            yield i, n
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'Items() iter.Seq[int]' in go_code
+    assert 'func (this *Tree) Items() iter.Seq[int] {\n    return func(yield func(int) bool) {' in go_code
+    assert 'if !yield(this.value) {\n            return\n        }' in go_code
+    assert 'func Pairs(names []string) iter.Seq2[int, string] {' in go_code
+    assert 'if !yield(i, n) {' in go_code
+    assert '"iter"' in go_code
+    
+    invalid = code.replace('yield this.value', 'try {\n                yield this.value\n            } catch (e) {\n            }')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected yield placement error")
+    except TranspilerError as e:
+        print(f"Iterator error: {e}")
+    
+    print("Iterators OK!\n")
+
+def test_events():
+    """Tests class events"""
+    print("=== Testing Events ===")
+    
+    code = '''
+    package main
+    
+    class Sensor {
+        event OnReading(value float64)
+        
+        func Measure(value float64) {
+            this.OnReading(value)
+        }
+    }
+    
+    class Thermometer extends Sensor {
+    }
+    
+    func log(value float64) {
+    }
+    
+    func main() {
+        t := new Thermometer()
+        t.OnReading += log
+        t.Measure(21.5)
+        t.OnReading -= log
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'onReadingHandlers []func(value float64)' in go_code
+    assert 'var sensorEvents sync.Mutex' in go_code
+    assert 'func (this *Sensor) AddOnReading(handler func(value float64)) {' in go_code
+    assert 'func (this *Sensor) RemoveOnReading(handler func(value float64)) {' in go_code
+    assert 'func (this *Sensor) RaiseOnReading(value float64) {' in go_code
+    assert 'this.RaiseOnReading(value)' in go_code
+    assert 't.AddOnReading(log)' in go_code
+    assert 't.RemoveOnReading(log)' in go_code
+    
+    invalid = code.replace('t.OnReading -= log', 't.OnReading *= log')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected event operator error")
+    except TranspilerError as e:
+        print(f"Event error: {e}")
+    
+    print("Events OK!\n")
+
+def test_observable_fields():
+    """Tests @observable fields"""
+    print("=== Testing Observable Fields ===")
+    
+    code = '''
+    package main
+    
+    class Thermostat {
+        target float64 @observable get
+        modes []string @observable
+    }
+    
+    func main() {
+        t := new Thermostat()
+        t.OnTargetChanged += onChange
+        t.SetTarget(21)
+    }
+    
+    func onChange(oldValue float64, newValue float64) {
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'target float64\n' in go_code
+    assert 'func (this *Thermostat) SetTarget(target float64) {\n    oldValue := this.target' in go_code
+    assert 'if oldValue != target {\n        this.RaiseOnTargetChanged(oldValue, target)' in go_code
+    assert 'if !reflect.DeepEqual(oldValue, modes) {' in go_code
+    assert 'func (this *Thermostat) AddOnTargetChanged(handler func(oldValue float64, newValue float64)) {' in go_code
+    assert 'func (this *Thermostat) GetTarget() float64 {' in go_code
+    assert 't.AddOnTargetChanged(onChange)' in go_code
+    
+    invalid = code.replace('modes []string @observable', 'func SetTarget(target float64) {\n        }')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected observable conflict error")
+    except TranspilerError as e:
+        print(f"Observable error: {e}")
+    
+    print("Observable fields OK!\n")
+
+def test_cross_package_inheritance():
+    """Tests base classes from another package"""
+    print("=== Testing Cross-Package Inheritance ===")
+    
+    models = '''
+    package models
+    
+    class Person {
+        Name string
+        age int
+        
+        Person(n string, a int) {
+            this.Name = n
+            this.age = a
+        }
+        
+        func Age() int {
+            return this.age
+        }
+    }
+    '''
+    
+    code = '''
+    package main
+    
+    import "models"
+    
+    interface Aged {
+        Age() int
+    }
+    
+    class Student extends models.Person implements Aged {
+        school string
+        
+        Student(n string, a int, s string) {
+            super.Person(n, a)
+            this.school = s
+        }
+        
+        func Describe() string {
+            return this.Name + " at " + this.school
+        }
+    }
+    '''
+    
+    models_transpiler = Transpiler(project_mode=True)
+    models_transpiler.transpile(Parser(Lexer(models).tokenize()).parse())
+    
+    transpiler = Transpiler(project_mode=True)
+    transpiler.register_package_classes('models', models_transpiler.classes)
+    go_code = transpiler.transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type Student struct {\n    models.Person' in go_code
+    assert 'obj.Person = *models.NewPerson(n, a)' in go_code
+    
+    invalid = code.replace('this.Name + " at "', 'fmt.Sprint(this.age) + " at "')
+    try:
+        transpiler = Transpiler(project_mode=True)
+        transpiler.register_package_classes('models', models_transpiler.classes)
+        transpiler.transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected unexported member error")
+    except TranspilerError as e:
+        print(f"Cross-package error: {e}")
+    
+    print("Cross-package inheritance OK!\n")
+
+def test_pointer_embedding():
+    """Tests embedding base classes by pointer"""
+    print("=== Testing Pointer Embedding ===")
+    
+    code = '''
+    package main
+    
+    class Animal {
+        name string
+        
+        Animal(n string) {
+            this.name = n
+        }
+    }
+    
+    class Dog extends Animal {
+        Dog(n string) {
+            super.Animal(n)
+        }
+    }
+    
+    class Puppy extends Dog {
+        age int = 1
+    }
+    
+    func main() {
+        d := new Dog("Rex")
+        a := d as Animal
+    }
+    '''
+    
+    go_code = Transpiler(embed_pointers=True).transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type Dog struct {\n    *Animal' in go_code
+    assert 'obj.Animal = NewAnimal(n)' in go_code
+    assert 'obj.Dog = &Dog{}\n    obj.Dog.Animal = &Animal{}' in go_code
+    assert 'a := d.Animal' in go_code
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'obj.Animal = *NewAnimal(n)' in go_code
+    assert 'a := &d.Animal' in go_code
+    
+    print("Pointer embedding OK!\n")
+
+def test_string_interpolation():
+    """Tests interpolated strings"""
+    print("=== Testing String Interpolation ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+        age int
+        grade float64
+        
+        func GetInfo() string {
+            return "${this.name} (${this.age}) ${this.grade:.1f} 100% \\${raw} ${len("a}b")}"
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert ('return fmt.Sprintf("%s (%d) %.1f 100%% ${raw} %d", '
+            'this.name, this.age, this.grade, len("a}b"))') in go_code
+    assert '"fmt"' in go_code
+    
+    try:
+        Parser(Lexer('package main\nfunc f() string {\n    return "${1 +}"\n}').tokenize()).parse()
+        raise AssertionError("Expected interpolation error")
+    except ParseError as e:
+        print(f"Interpolation error: {e}")
+    
+    print("String interpolation OK!\n")
+
+def test_ternary():
+    """Tests conditional expressions"""
+    print("=== Testing Conditional Expressions ===")
+    
+    code = '''
+    package main
+    
+    func grade(score int) string {
+        return score >= 90 ? "A" : score >= 80 ? "B" : "C"
+    }
+    
+    func main() {
+        n := 4
+        rate := n > 2 ? 0.5 : 1
+        n = n > 3 ? n * 2 : n
+        fmt.Println(n % 2 == 0 ? "even" : "odd", rate)
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert '} else if score >= 80 {' in go_code
+    assert 'var rate float64' in go_code
+    assert 'rate = 0.5' in go_code
+    assert 'n = n * 2' in go_code and 'n = n\n' not in go_code
+    assert 'func() string { if n % 2 == 0 { return "even" }; return "odd" }()' in go_code
+    
+    for body, expected in [('x := 1 ? 2 : 3', 'must be bool'),
+                           ('x := 1 > 0 ? "a" : 1 > 2', 'mismatched types string'),
+                           ('x := 1 > 0 ? "a" : nil', 'in branches of ?: cannot be used as string'),
+                           ('x := 1 > 0 ? nil : nil', 'Cannot infer the type of x')]:
+        try:
+            Transpiler().transpile(Parser(Lexer(f'package main\nfunc main() {{\n    {body}\n}}').tokenize()).parse())
+            raise AssertionError(f"Expected error for {body}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Conditional expression error: {e}")
+    
+    print("Conditional expressions OK!\n")
+
+def test_optional_chaining():
+    """Tests ?. and ?? operators"""
+    print("=== Testing Optional Chaining ===")
+    
+    code = '''
+    package main
+    
+    class School {
+        name string
+    }
+    
+    class Student {
+        school *School
+        
+        func GetSchool() *School {
+            return this.school
+        }
+        
+        func Greet() {
+            fmt.Println("hi")
+        }
+    }
+    
+    func find(name string) *Student {
+        return nil
+    }
+    
+    func main() {
+        var s *Student = find("ann")
+        fmt.Println(s?.GetSchool()?.name ?? "none")
+        find("bob")?.Greet()
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert ('func() string { if s != nil { if recv := s.GetSchool(); recv != nil { return recv.name } }; '
+            'return "none" }()') in go_code
+    assert 'if recv := find("bob"); recv != nil {\n        recv.Greet()' in go_code
+    
+    for body, expected in [('func f(s Student) *School { return s?.school }', 'non-pointer type Student'),
+                           ('func f(n int) int { return n ?? 1 }', 'non-pointer type int'),
+                           ('func f(s *Student) string { return s?.school?.name ?? 0 }', 'cannot be used as string')]:
+        try:
+            program = Parser(Lexer(f'package main\nclass School {{\n    name string\n}}\n'
+                                   f'class Student {{\n    school *School\n}}\n{body}').tokenize()).parse()
+            Transpiler().transpile(program)
+            raise AssertionError(f"Expected error for {body}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Optional chaining error: {e}")
+    
+    print("Optional chaining OK!\n")
+
+def test_match():
+    """Tests match expressions with type, literal and destructuring patterns"""
+    print("=== Testing Match ===")
+    
+    code = '''
+    package main
+    
+    interface Shape {
+        Area() float64
+    }
+    
+    class Circle implements Shape {
+        radius float64
+        
+        func Area() float64 {
+            return 3.14 * this.radius * this.radius
+        }
+    }
+    
+    data class Rect(w, h float64) implements Shape {
+        func Area() float64 {
+            return this.w * this.h
+        }
+    }
+    
+    enum Color { Red, Green, Blue }
+    
+    func describe(s Shape) string {
+        return match s {
+            Circle c if c.radius > 10 -> "big circle"
+            Rect(w, 0) -> "flat"
+            Rect(w, h) -> "rect"
+            Circle -> "circle"
+        }
+    }
+    
+    func kind(s Shape) string {
+        return match s {
+            Circle c -> "circle"
+            Rect r -> "rect"
+        }
+    }
+    
+    func main() {
+        c := Color.Green
+        name := match c {
+            Red -> "red"
+            Green, Blue -> "cool"
+        }
+        fmt.Println(name, match len(name) { 0 -> "empty", _ -> "named" })
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'if c, ok := asCircle(s); ok && c.radius > 10 {' in go_code
+    assert '} else if rect, ok := asRect(s); ok && rect.h == 0 {' in go_code
+    assert 'panic(NewException("MatchError", fmt.Sprintf("no match arm for %v", s)))' in go_code
+    assert 'switch s.(type) {\n    case *Circle:' in go_code
+    assert 'var name string\n    switch c {' in go_code and 'case ColorGreen, ColorBlue:' in go_code
+    assert 'fmt.Println(name, func() string {\n        switch len(name) {' in go_code
+    
+    for body, expected in [('func f(c Color) int { return match c { Red -> 1, Green -> 2 } }', 'missing Blue'),
+                           ('func f(s Shape) int { return match s { Circle c -> 1 } }', 'missing Rect'),
+                           ('func f(n int) int { return match n { 1 -> 1 } }', 'add a _ arm'),
+                           ('func f(n int) int { return match n { _ -> 1, 2 -> 3 } }', 'Unreachable'),
+                           ('func f(s Shape) int { return match s { Circle(r) -> 1, _ -> 2 } }', 'not a data class'),
+                           ('func f(s Shape) int { return match s { Rect(w) -> 1, _ -> 2 } }', 'has 2 fields')]:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace('func main() {', body + '\nfunc unused() {')).tokenize()).parse())
+            raise AssertionError(f"Expected error for {body}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Match error: {e}")
+    
+    print("Match OK!\n")
+
+def test_switch_expressions():
+    """Tests switch used as an expression"""
+    print("=== Testing Switch Expressions ===")
+    
+    code = '''
+    package main
+    
+    enum Level { A, B, C }
+    
+    func points(level Level) float64 {
+        return switch level {
+            case A -> 10.0
+            case B, C -> 8.0
+        }
+    }
+    
+    func main() {
+        n := 3
+        grade := switch Level.B { case Level.A -> 10.0; default -> 5 }
+        fmt.Println(grade, switch n { case 1 -> "one"; default -> "many" })
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'switch level {\n    case LevelA:\n        return 10.0\n    case LevelB, LevelC:' in go_code
+    assert 'var grade float64\n    switch LevelB {' in go_code
+    assert 'fmt.Println(grade, func() string {\n        switch n {' in go_code
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('case B, C', 'case B')).tokenize()).parse())
+        raise AssertionError("Expected exhaustiveness error")
+    except TranspilerError as e:
+        assert 'switch on Level is not exhaustive: missing C' in str(e), e
+        print(f"Switch expression error: {e}")
+    
+    print("Switch expressions OK!\n")
+
+def test_lambdas():
+    """Tests arrow lambdas with parameter types taken from the target"""
+    print("=== Testing Lambdas ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+        age int
+    }
+    
+    class List<T> {
+        items []T
+        
+        func Filter(pred func(T) bool) []T {
+            return this.items
+        }
+    }
+    
+    func Map<T, R any>(xs []T, f func(T) R) []R {
+        var out []R
+        return out
+    }
+    
+    func main() {
+        people := new List<*Person>()
+        adults := people.Filter(p -> p.age > 18)
+        names := Map(adults, p -> p.name)
+        var twice func(int) int = x -> x * 2
+        sub := (a int, b int) -> {
+            return a - b
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'people.Filter(func(p *Person) bool { return p.age > 18 })' in go_code
+    assert 'Map(adults, func(p *Person) string { return p.name })' in go_code
+    assert 'var twice func(int) int = func(x int) int { return x * 2 }' in go_code
+    assert 'sub := func(a int, b int) int {\n        return a - b\n    }' in go_code
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('(a int, b int)', '(a, b)')).tokenize()).parse())
+        raise AssertionError("Expected lambda inference error")
+    except TranspilerError as e:
+        assert 'Cannot infer the type of lambda parameter a' in str(e), e
+        print(f"Lambda error: {e}")
+    
+    print("Lambdas OK!\n")
+
+def test_comprehensions():
+    """Tests list and map comprehensions"""
+    print("=== Testing Comprehensions ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+        age int
+        
+        func GetName() string {
+            return this.name
+        }
+    }
+    
+    func main() {
+        people := make([]*Person, 0)
+        names := [p.GetName() for p in people if p.age > 18]
+        ages := {p.name: p.age for p in people}
+        fmt.Println(len([k for k in ages]))
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert ('var names []string\n    for _, p := range people {\n        if p.age > 18 {\n'
+            '            names = append(names, p.GetName())') in go_code
+    assert 'ages := make(map[string]int)\n    for _, p := range people {\n        ages[p.name] = p.age' in go_code
+    assert 'fmt.Println(len(func() []string {\n        var result []string\n        for k := range ages {' in go_code
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('in ages', 'in 5')).tokenize()).parse())
+        raise AssertionError("Expected iteration error")
+    except TranspilerError as e:
+        assert 'Cannot iterate over 5 of type int' in str(e), e
+        print(f"Comprehension error: {e}")
+    
+    print("Comprehensions OK!\n")
+
+def test_for_in():
+    """Tests for-in loops over collections and iterable classes"""
+    print("=== Testing For-In Loops ===")
+    
+    code = '''
+    package main
+    
+    class Counter {
+        n int
+        
+        func HasNext() bool {
+            return this.n < 3
+        }
+        
+        func Next() int {
+            this.n = this.n + 1
+            return this.n
+        }
+    }
+    
+    class Range {
+        func Iterator() *Counter {
+            return new Counter()
+        }
+    }
+    
+    class Bag {
+        items []string
+        
+        func Iterator() yield string {
+            for _, it := range this.items {
+                yield it
+            }
+        }
+    }
+    
+    func main() {
+        xs := make([]string, 0)
+        m := make(map[string]int)
+        for x in xs {
+            fmt.Println(x)
+        }
+        for k, v in m {
+            fmt.Println(k, v)
+        }
+        for n in new Range() {
+            fmt.Println(n)
+        }
+        for s in new Bag() {
+            fmt.Println(s)
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'for _, x := range xs {' in go_code
+    assert 'for k, v := range m {' in go_code
+    assert 'for it := NewRange().Iterator(); it.HasNext(); {\n        n := it.Next()' in go_code
+    assert 'for s := range NewBag().Iterator() {' in go_code
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('func Iterator() *Counter', 'func Items() *Counter')).tokenize()).parse())
+        raise AssertionError("Expected iterable error")
+    except TranspilerError as e:
+        assert 'NewRange() of type *Range is not iterable' in str(e), e
+        print(f"For-in error: {e}")
+    
+    print("For-in loops OK!\n")
+
+def test_tuples():
+    """Tests tuple returns and destructuring assignment"""
+    print("=== Testing Tuples ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+        age int
+        
+        func NameAndAge() (string, int) {
+            return (this.name, this.age)
+        }
+    }
+    
+    func main() {
+        p := new Person()
+        (name, age) := p.NameAndAge()
+        (name, age) = ("Bob", 12)
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'return this.name, this.age' in go_code
+    assert 'p := NewPerson()\n    name, age := p.NameAndAge()' in go_code
+    assert 'name, age = "Bob", 12' in go_code
+    
+    errors = [
+        ('(name, age) := p.NameAndAge()', '(name, age, x) := p.NameAndAge()',
+         'Assignment mismatch: 3 variable(s) but p.NameAndAge() returns 2 value(s)'),
+        ('("Bob", 12)', '(12, "Bob")', 'Cannot use 12 as string in assignment'),
+        ('return (this.name, this.age)', 'return this.name', 'Wrong number of return values: have 1, want 2'),
+    ]
+    for old, new, message in errors:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace(old, new)).tokenize()).parse())
+            raise AssertionError(f"Expected error: {message}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Tuple error: {e}")
+    
+    print("Tuples OK!\n")
+
+def test_raw_strings():
+    """Tests raw and triple-quoted strings"""
+    print("=== Testing Raw Strings ===")
+    
+    code = '''
+    package main
+    
+    func main() {
+        path := `C:\\temp\\new`
+        query := """
+            SELECT *
+              FROM "users"
+            """
+        tick := """a ` b\tc"""
+    }
+    '''
+    
+    tokens = Lexer(code).tokenize()
+    raw = [t.value for t in tokens if t.type == TokenType.RAW_STRING]
+    assert raw == ['C:\\temp\\new', 'SELECT *\n  FROM "users"', 'a ` b\tc'], raw
+    
+    go_code = Transpiler().transpile(Parser(tokens).parse())
+    assert 'path := `C:\\temp\\new`' in go_code
+    assert 'query := `SELECT *\n  FROM "users"`' in go_code
+    assert 'tick := "a ` b\\tc"' in go_code
+    
+    print("Raw strings OK!\n")
+
+def test_numeric_literals():
+    """Tests binary/hex literals, digit separators and type suffixes"""
+    print("=== Testing Numeric Literals ===")
+    
+    code = '''
+    package main
+    
+    func main() {
+        mask := 0b1010_1010
+        big := 1_000_000
+        hex := 0xFF
+        f := 1.5f32
+        small := -128i8
+        b := 255u8
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    for line in ['mask := 0b10101010', 'big := 1000000', 'hex := 0xff', 'f := float32(1.5)',
+                 'small := int8(-128)', 'b := uint8(255)']:
+        assert line in go_code, line
+    
+    for old, new, message in [('255u8', '256u8', 'Constant 256 overflows uint8'),
+                              ('1.5f32', '1.5i32', 'cannot have an integer type suffix'),
+                              ('1_000_000', '1__000', 'Invalid numeric literal 1__000')]:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace(old, new)).tokenize()).parse())
+            raise AssertionError(f"Expected error: {message}")
+        except Exception as e:
+            assert message in str(e), e
+            print(f"Numeric literal error: {e}")
+    
+    print("Numeric literals OK!\n")
+
+def test_characters():
+    """Tests char literals and character-based string operations"""
+    print("=== Testing Characters ===")
+    
+    code = '''
+    package main
+    
+    func main() {
+        s := "héllo"
+        c := 'é'
+        quote := '\\''
+        same := s.CharAt(1) == c
+        n := s.Length() + len(s.Chars())
+        t := s + '!'
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert "c := 'é'" in go_code
+    assert "quote := '\\''" in go_code
+    assert 'same := CharAt(s, 1) == c' in go_code
+    assert 'n := utf8.RuneCountInString(s) + len([]rune(s))' in go_code
+    assert "t := s + string('!')" in go_code
+    assert 'func CharAt(s string, i int) rune {' in go_code
+    assert 'panic(NewException("IndexOutOfRange"' in go_code
+    assert '"unicode/utf8"' in go_code
+    
+    try:
+        Lexer("x := 'ab'").tokenize()
+        raise AssertionError("Expected character literal error")
+    except Exception as e:
+        assert 'must hold exactly one character' in str(e), e
+        print(f"Character error: {e}")
+    
+    print("Characters OK!\n")
+
+def test_escapes():
+    """Tests that emitted string and rune literals are valid Go whatever their content"""
+    print("=== Testing Escapes ===")
+    
+    code = '''
+    package main
+    
+    @stringer("Point \\"{x}\\"\\n{y} 100%")
+    class Point {
+        x int
+        y int
+    }
+    
+    func main() {
+        s := "bell\\a nul\\x00 byte\\xff oct\\101 \\u00e9\\U0001F600 zw\\u200b price\\$"
+        b := '\\xff'
+        name := "x"
+        t := "hi ${name}\\n\\"q\\" \\$5"
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'return fmt.Sprintf("Point \\"%v\\"\\n%v 100%%", this.x, this.y)' in go_code
+    assert 's := "bell\\a nul\\x00 byte\\xff octA é😀 zw\\u200b price$"' in go_code
+    assert "b := '\\xff'" in go_code
+    assert 't := fmt.Sprintf("hi %s\\n\\"q\\" $5", name)' in go_code
+    
+    for source, message in [('x := "a\\qb"', 'Unknown escape sequence \\q'),
+                            ('x := "\\x4"', 'expected 2 hex digits'),
+                            ('x := "\\uD800"', 'not a Unicode code point'),
+                            ('x := "\\400"', 'at most 255'),
+                            ('x := "${y}\\w"', 'Unknown escape sequence \\w')]:
+        try:
+            Lexer(source).tokenize()
+            raise AssertionError(f"Expected escape error for {source}")
+        except Exception as e:
+            assert message in str(e) and 'at line 1' in str(e), e
+            print(f"Escape error: {e}")
+    
+    print("Escapes OK!\n")
+
+def test_operators():
+    """Tests operator precedence and the parentheses emitted for it"""
+    print("=== Testing Operators ===")
+    
+    code = '''
+    package main
+    
+    func main() {
+        x := 6
+        y := 3
+        a := (x + y) * 2 - (x - (y - 1))
+        b := x - y - 1
+        flags := x & y | y << 4 ^ x &^ 2
+        ok := !(x > 1 && y > 1) || x == y
+        p := &x
+        *p = ^x + -(x + y)
+        z := x +
+            y
+        -y
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'a := (x + y) * 2 - (x - (y - 1))' in go_code
+    assert 'b := x - y - 1' in go_code
+    assert 'flags := x & y | y << 4 ^ x &^ 2' in go_code
+    assert 'ok := !(x > 1 && y > 1) || x == y' in go_code
+    assert 'p := &x\n    *p = ^x + -(x + y)' in go_code
+    assert 'z := x + y\n    -y' in go_code
+    
+    expr = Parser(Lexer('a | b & c == d || e').tokenize()).parse_expression()
+    assert expr == BinaryExpr(BinaryExpr(BinaryExpr(Identifier('a'), '|', BinaryExpr(Identifier('b'), '&', Identifier('c'))),
+                                         '==', Identifier('d')), '||', Identifier('e'))
+    expr = Parser(Lexer('x == y as T').tokenize()).parse_expression()
+    assert expr == BinaryExpr(Identifier('x'), '==', CastExpr(Identifier('y'), 'T', False))
+    
+    print("Operators OK!\n")
+
+def test_increments():
+    """Tests ++, -- and compound assignments on locals, fields, map and indexer elements and in lambdas"""
+    print("=== Testing Increments ===")
+    
+    code = '''
+    package main
+    
+    class Grid {
+        cells []int
+        operator [](i int) int {
+            return this.cells[i]
+        }
+        operator []=(i int, v int) {
+            this.cells[i] = v
+        }
+    }
+    
+    class Tank {
+        fuel int
+        mask uint8
+        func Fill(amount int) {
+            this.fuel++
+            this.mask <<= 3
+            this.mask &^= 8
+        }
+    }
+    
+    func main() {
+        total := 0
+        for i := 0; i < 5; i++ {
+            total += i
+        }
+        counts := make(map[string]int)
+        counts["a"]--
+        bump := () -> total++
+        add := (n int) -> total += n
+        g := new Grid()
+        g[1]++
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'this.fuel++\n    this.mask <<= 3\n    this.mask &^= 8' in go_code
+    assert 'for i := 0; i < 5; i++ {' in go_code
+    assert 'counts["a"]--' in go_code
+    assert 'bump := func() {\n        total++\n    }' in go_code
+    assert 'add := func(n int) {\n        total += n\n    }' in go_code
+    assert 'g.IndexSet(1, g.IndexGet(1) + 1)' in go_code
+    
+    for statement, message in [('y := x++', 'x++ is a statement in Go and cannot be used as a value'),
+                               ('fmt.Println(x += 1)', 'Assignment += is a statement in Go'),
+                               ('s := "a"\n        s--', 'Invalid operation: s-- (non-numeric type string)')]:
+        try:
+            source = f'package main\nfunc main() {{\n        x := 1\n        {statement}\n}}'
+            Transpiler().transpile(Parser(Lexer(source).tokenize()).parse())
+            raise AssertionError(f"Expected error for {statement}")
+        except (ParseError, TranspilerError) as e:
+            assert message in str(e), e
+            print(f"Increment error: {e}")
+    
+    print("Increments OK!\n")
+
+def test_defer():
+    """Tests defer in methods and its order relative to the defers generated for try/catch/finally"""
+    print("=== Testing Defer ===")
+    
+    code = '''
+    package main
+    
+    class File {
+        name string
+        File(name string) {
+            this.name = name
+            defer this.Log("opened")
+        }
+        func Log(msg string) {
+            fmt.Println(this.name, msg)
+        }
+        func Close() {
+            fmt.Println("closing")
+        }
+        func Process() {
+            try {
+                defer this.Close()
+                fmt.Println("processing")
+            } catch (IOError e) {
+                fmt.Println("caught", e.Error())
+            } finally {
+                fmt.Println("finally")
+            }
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'obj.name = name\n    defer obj.Log("opened")' in go_code
+    finally_at = go_code.index('fmt.Println("finally")')
+    recover_at = go_code.index('if r := recover(); r != nil {')
+    close_at = go_code.index('defer this.Close()')
+    assert finally_at < recover_at < close_at, "finally must be deferred first so that catch runs before it"
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('fmt.Println("finally")', 'defer this.Close()')).tokenize()).parse())
+        raise AssertionError("Expected defer placement error")
+    except TranspilerError as e:
+        assert 'defer in a finally block would run when the exception handler returns' in str(e), e
+        print(f"Defer error: {e}")
+    
+    print("Defer OK!\n")
+
+def test_goroutines():
+    """Tests go statements: eagerly evaluated operands and exceptions routed to the global handler"""
+    print("=== Testing Goroutines ===")
+    
+    code = '''
+    package main
+    
+    class Worker {
+        name string
+        func Process(item int, label string) {
+            fmt.Println(this.name, item, label)
+        }
+        func Run() {
+            for i := 0; i < 3; i++ {
+                go this.Process(i, "run")
+            }
+        }
+    }
+    
+    func main() {
+        w := new Worker()
+        go w.Process(7, w.name + "!")
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert ('go func(i int) {\n            defer RecoverGoroutine()\n            this.Process(i, "run")\n'
+            '        }(i)') in go_code
+    assert 'go func(w *Worker, arg1 string) {\n        defer RecoverGoroutine()\n        w.Process(7, arg1)\n    }(w, w.name + "!")' in go_code
+    assert 'var UncaughtExceptionHandler = func(ex Exception) {' in go_code
+    assert 'func RecoverGoroutine() {\n    if r := recover(); r != nil {' in go_code
+    assert '"os"' in go_code
+    
+    print("Goroutines OK!\n")
+
+def test_channels():
+    """Tests channel fields, send/receive and select in methods, with throws in cases reaching an enclosing try"""
+    print("=== Testing Channels ===")
+    
+    code = '''
+    package main
+    
+    class Pipeline {
+        jobs chan int
+        results chan<- string
+        Pipeline(results chan<- string) {
+            this.jobs = make(chan int, 4)
+            this.results = results
+        }
+        func Submit(n int) {
+            this.jobs <- n
+        }
+        func Next() int {
+            select {
+            case n, ok := <-this.jobs:
+                if !ok {
+                    return 0
+                }
+                this.results <- fmt.Sprint(n)
+                return n
+            case <-time.After(time.Second):
+                throw new Exception("Timeout", "no job")
+            default:
+            }
+            return -1
+        }
+    }
+    
+    func Tail(results <-chan string) {
+        for r in results {
+            fmt.Println(r)
+        }
+    }
+    
+    func main() {
+        p := new Pipeline(make(chan string, 1))
+        try {
+            p.Next()
+        } catch (Timeout e) {
+            fmt.Println(e.Error())
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'jobs chan int' in go_code and 'results chan<- string' in go_code
+    assert 'func NewPipeline(results chan<- string) *Pipeline {' in go_code
+    assert 'this.jobs <- n' in go_code
+    assert 'select {\n    case n, ok := <-this.jobs:\n        if !ok {' in go_code
+    assert 'this.results <- fmt.Sprint(n)' in go_code
+    assert 'case <-time.After(time.Second):\n        panic(NewException("Timeout", "no job"))' in go_code
+    assert 'default:\n    }\n    return -1' in go_code
+    assert 'func Tail(results <-chan string) {\n    for r := range results {' in go_code
+    
+    errors = [
+        ('this.jobs <- n', 'this.results <- n', 'Cannot use n (int) as string in send'),
+        ('this.jobs <- n', 'n <- 1', 'cannot send to non-channel n (int)'),
+        ('fmt.Println(r)', 'results <- r', 'cannot send to receive-only channel results (<-chan string)'),
+        ('case n, ok := <-this.jobs:', 'case n, ok := <-this.results:', 'cannot receive from send-only channel'),
+    ]
+    for old, new, message in errors:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace(old, new)).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Channel error: {e}")
+    
+    try:
+        Parser(Lexer(code.replace('case <-time.After(time.Second):', 'case time.After(time.Second):')).tokenize()).parse()
+        raise AssertionError("Expected select case error")
+    except ParseError as e:
+        assert 'Select case must be a send (ch <- v) or a receive' in str(e), e
+        print(f"Select error: {e}")
+    
+    print("Channels OK!\n")
+
+def test_composite_literals():
+    """Tests slice, array and map literals, with braces of constructor arguments for class elements"""
+    print("=== Testing Composite Literals ===")
+    
+    code = '''
+    package main
+    
+    struct Point {
+        X int
+        Y int
+    }
+    
+    class Person {
+        name string
+        age int
+        Person(name string, age int) {
+            this.name = name
+            this.age = age
+        }
+    }
+    
+    class Box<T> {
+        value T
+        Box(value T) {
+            this.value = value
+        }
+    }
+    
+    func main() {
+        people := []*Person{
+            new Person("Ann", 30),
+            {"Bob", 25},
+        }
+        byName := map[string]*Person{"carl": {"Carl", 41}}
+        values := []Person{{"Eve", 50}}
+        grid := [][]int{{1, 2}, {3}}
+        points := []Point{{1, 2}, {X: 3, Y: 4}}
+        boxes := []*Box<int>{{1}}
+        empty := map[string]int{}
+        for p in []string{"x", "y"} {
+            fmt.Println(p)
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'people := []*Person{NewPerson("Ann", 30), NewPerson("Bob", 25)}' in go_code
+    assert 'byName := map[string]*Person{"carl": NewPerson("Carl", 41)}' in go_code
+    assert 'values := []Person{*NewPerson("Eve", 50)}' in go_code
+    assert 'grid := [][]int{{1, 2}, {3}}' in go_code
+    assert 'points := []Point{{1, 2}, {X: 3, Y: 4}}' in go_code
+    assert 'boxes := []*Box[int]{NewBox[int](1)}' in go_code
+    assert 'empty := map[string]int{}' in go_code
+    assert 'for _, p := range []string{"x", "y"} {' in go_code
+    
+    errors = [
+        ('{"Bob", 25}', '{"Bob"}', 'Wrong number of arguments to new Person: have 1, want 2 (string, int)'),
+        ('new Person("Ann", 30)', 'new Person("Ann", "30")', 'Cannot use "30" (string) as int in argument to new Person'),
+        ('{"Eve", 50}', 'new Person("Eve", 50)', 'class instances are pointers, declare the elements as *Person'),
+        ('{"Carl", 41}', '{name: "Carl"}', 'are passed to its constructor; list the arguments'),
+        ('{1, 2}, {3}', '{1, "2"}', 'Cannot use "2" (string) as int in slice literal'),
+    ]
+    for old, new, message in errors:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace(old, new, 1)).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Literal error: {e}")
+    
+    print("Composite literals OK!\n")
+
+def test_variadics():
+    """Tests variadic methods and constructors, forwarding with ... and the checks on spread arguments"""
+    print("=== Testing Variadics ===")
+    
+    code = '''
+    package main
+    
+    class Logger {
+        prefix string
+        tags []string
+        Logger(prefix string, tags ...string) {
+            this.prefix = prefix
+            this.tags = tags
+        }
+        func Log(parts ...string) {
+            fmt.Println(this.prefix, strings.Join(parts, " "))
+        }
+        func Logf(format string, args ...interface{}) {
+            fmt.Printf(format, args...)
+        }
+        func Forward(parts ...string) {
+            this.Log(parts...)
+            this.Log()
+        }
+    }
+    
+    func main() {
+        l := new Logger("[app]", "a", "b")
+        l.Log("hello", "world")
+        tags := []string{"t1"}
+        m := new Logger("[m]", tags...)
+        m.Logf("%d %s", 1, "x")
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func NewLogger(prefix string, tags ...string) *Logger {' in go_code
+    assert 'func (this *Logger) Log(parts ...string) {' in go_code
+    assert 'fmt.Printf(format, args...)' in go_code
+    assert 'this.Log(parts...)\n    this.Log()' in go_code
+    assert 'l := NewLogger("[app]", "a", "b")' in go_code
+    assert 'm := NewLogger("[m]", tags...)' in go_code
+    
+    errors = [
+        ('l.Log("hello", "world")', 'l.Log("hello", 2)', 'Cannot use 2 as string in argument to l.Log'),
+        ('new Logger("[app]", "a", "b")', 'new Logger()', 'Wrong number of arguments to new Logger: have 0, want at least 1'),
+        ('m := new Logger("[m]", tags...)', 'm := new Logger(tags...)', 'Wrong number of arguments to new Logger: have 1, want 2'),
+        ('this.Log(parts...)', 'this.Log("x", parts...)', 'parts... must be the only value for the variadic parameter parts'),
+        ('this.Log(parts...)', 'this.Logf(parts...)', 'Wrong number of arguments to this.Logf'),
+        ('this.Log(parts...)', 'this.Forward(this.prefix...)', 'Cannot use this.prefix (string) as []string in spread argument'),
+        ('tags := []string{"t1"}', 'tags := []int{1}', 'Cannot use tags ([]int) as []string in spread argument to new Logger'),
+        ('this.Log()', 'this.Log(this.prefix...)', 'Cannot use this.prefix (string) as []string in spread argument'),
+    ]
+    for old, new, message in errors:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace(old, new, 1)).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Variadic error: {e}")
+    
+    for old, new, message in [
+        ('func Log(parts ...string)', 'func Log(parts ...string, n int)', 'Only the last parameter can be variadic'),
+        ('tags...)', 'tags..., "x")', 'Only the last argument can be spread with ...'),
+    ]:
+        try:
+            Parser(Lexer(code.replace(old, new, 1)).tokenize()).parse()
+            raise AssertionError(f"Expected parse error for {new}")
+        except ParseError as e:
+            assert message in str(e), e
+            print(f"Variadic parse error: {e}")
+    
+    print("Variadics OK!\n")
+
+def test_labels():
+    """Tests labeled loops and break/continue leaving the function literal of a try statement"""
+    print("=== Testing Labels ===")
+    
+    code = '''
+    package main
+    
+    func main() {
+        outer:
+        for i := 0; i < 3; i++ {
+            for j := 0; j < 3; j++ {
+                try {
+                    if j == 1 {
+                        continue outer
+                    }
+                    if i == 2 {
+                        break outer
+                    }
+                    fmt.Println(i, j)
+                } catch (Exception e) {
+                    fmt.Println(e.Error())
+                    break
+                }
+            }
+        }
+        rows:
+        for k := 0; k < 5; k++ {
+            try {
+                fmt.Println(k)
+            } finally {
+                fmt.Println("done")
+            }
+            switch k {
+            case 3:
+                break rows
+            }
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert '    outer:\n    for i := 0; i < 3; i++ {' in go_code
+    assert 'if jump := func() (jump int) {' in go_code
+    # The catch handler is deferred: it sets the literal's result instead of returning it
+    assert 'fmt.Println(e.Error())\n                        jump = 1\n                        return\n' in go_code
+    assert 'return 2\n' in go_code and 'return 3\n' in go_code
+    assert 'fmt.Println(i, j)\n                return 0\n' in go_code
+    assert ('}(); jump == 1 {\n                break\n'
+            '            } else if jump == 2 {\n                continue outer\n'
+            '            } else if jump == 3 {\n                break outer\n') in go_code
+    # No jump leaves the second try: it stays a plain function literal
+    assert go_code.count('func() (jump int) {') == 1
+    assert '    rows:\n    for k := 0; k < 5; k++ {' in go_code and 'break rows' in go_code
+    
+    errors = [
+        ('continue outer', 'continue inner', 'Undefined label inner'),
+        ('break rows', 'continue rows', None),
+        ('break rows', 'fmt.Println(k)', 'Label rows is defined and not used'),
+        ('for j := 0', 'outer:\n            for j := 0', 'Label outer is already defined by an enclosing statement'),
+        ('rows:\n        for k', 'rows:\n        switch {\n        case true:\n            continue rows\n        }\n        for k',
+         'Invalid continue label rows: it marks a switch, not a loop'),
+        ('outer:\n        for i', 'break\n        outer:\n        for i', 'break is not in a loop, switch or select'),
+    ]
+    for old, new, message in errors:
+        broken = code.replace(old, new, 1)
+        if message is None:
+            # Still valid: only checks that the jump is accepted
+            Transpiler().transpile(Parser(Lexer(broken).tokenize()).parse())
+            continue
+        try:
+            Transpiler().transpile(Parser(Lexer(broken).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Label error: {e}")
+    
+    try:
+        Parser(Lexer(code.replace('rows:\n        for k', 'rows:\n        fmt.Println(0)\n        for k', 1)).tokenize()).parse()
+        raise AssertionError("Expected parse error for a label on a call")
+    except ParseError as e:
+        assert 'Label rows must mark a for, do, repeat, switch or select statement' in str(e), e
+        print(f"Label parse error: {e}")
+    
+    print("Labels OK!\n")
+
+def test_do_while():
+    """Tests do-while and repeat-until loops, continue jumping to the condition and jumps out of a try in the body"""
+    print("=== Testing Do-While ===")
+    
+    code = '''
+    package main
+    
+    func main() {
+        i := 0
+        do {
+            i++
+            if i == 2 {
+                continue
+            }
+            fmt.Println(i)
+        } while (i < 4)
+        outer:
+        do {
+            i++
+            for j := 0; j < 3; j++ {
+                try {
+                    if j == 1 {
+                        continue outer
+                    }
+                    if i > 6 {
+                        break outer
+                    }
+                } catch (Exception e) {
+                    fmt.Println(e.Error())
+                }
+            }
+        } while i < 10 && i > 0
+        do := 1
+        fmt.Println(do)
+        repeat {
+            i--
+            if i == 5 {
+                continue
+            }
+        } until i <= 3 || i == 7
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    # The body's block declares nothing, so it loses its braces
+    assert 'for {\n        i++\n        if i == 2 {' in go_code
+    assert 'goto next\n' in go_code
+    assert '        next:\n        if !(i < 4) {\n            break\n        }\n    }' in go_code
+    # continue outer leaves the try through its jump code, then jumps to the condition
+    assert '}(); jump == 1 {\n                goto next2\n' in go_code and 'break outer\n' in go_code
+    assert '    outer:\n    for {' in go_code
+    assert 'next2:\n        if !(i < 10 && i > 0) {' in go_code
+    assert 'do := 1' in go_code
+    # repeat-until breaks once the condition holds
+    assert 'i--' in go_code and 'goto next3\n' in go_code
+    assert 'next3:\n        if i <= 3 || i == 7 {\n            break\n        }' in go_code
+    
+    # A label that only continue uses is dropped: the continue became a goto
+    unlabeled = Transpiler().transpile(Parser(Lexer(code.replace('break outer', 'break', 1)).tokenize()).parse())
+    assert 'outer:' not in unlabeled and 'goto next2' in unlabeled
+    
+    try:
+        Parser(Lexer(code.replace('} while (i < 4)', '}', 1)).tokenize()).parse()
+        raise AssertionError("Expected parse error for a do block without while")
+    except ParseError as e:
+        assert "Expected 'while' after do block" in str(e), e
+        print(f"Do-while parse error: {e}")
+    
+    try:
+        Parser(Lexer(code.replace('} until i <= 3', '} while i <= 3', 1)).tokenize()).parse()
+        raise AssertionError("Expected parse error for a repeat block closed by while")
+    except ParseError as e:
+        assert "Expected 'until' after repeat block" in str(e), e
+        print(f"Repeat-until parse error: {e}")
+    
+    print("Do-While OK!\n")
+
+def test_with():
+    """Tests with statements pairing Lock/RLock with their release and releasing values by Close"""
+    print("=== Testing With ===")
+    
+    code = '''
+    package main
+    
+    class Conn {
+        name string
+        func Close() {
+            fmt.Println("closed")
+        }
+    }
+    
+    class Counter {
+        mu sync.Mutex
+        rw sync.RWMutex
+        n int
+        func Inc() {
+            with (this.mu.Lock()) {
+                this.n++
+            }
+            with (this.rw.RLock()) {
+                fmt.Println(this.n)
+            }
+        }
+    }
+    
+    func main() {
+        with (conn := new Conn()) {
+            fmt.Println(conn.name)
+        }
+        for i := 0; i < 3; i++ {
+            with (new Conn()) {
+                if i == 1 {
+                    break
+                }
+            }
+        }
+        with := 1
+        fmt.Println(with)
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func() {\n        this.mu.Lock()\n        defer this.mu.Unlock()\n        this.n++\n    }()' in go_code
+    assert 'this.rw.RLock()\n        defer this.rw.RUnlock()' in go_code
+    assert 'conn := NewConn()\n        defer conn.Close()\n        fmt.Println(conn.name)' in go_code
+    # break leaves the function literal through its jump code
+    assert 'if jump := func() (jump int) {\n            defer NewConn().Close()' in go_code
+    assert 'with := 1' in go_code
+    
+    invalid = code.replace('func Close()', 'func Shutdown()')
+    try:
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected missing release error")
+    except TranspilerError as e:
+        assert 'with requires a Lock()/RLock() call or a value with an Unlock(), Close() or Dispose() method (*Conn has none)' in str(e), e
+        print(f"With error: {e}")
+    
+    print("With OK!\n")
+
+def test_annotations():
+    """Tests declared annotations on classes and methods, @deprecated and the annotations in class descriptors"""
+    print("=== Testing Annotations ===")
+    
+    code = '''
+    package main
+    
+    annotation route(method string, path string)
+    annotation weight(n int)
+    
+    @deprecated("use Member instead")
+    class Person {
+        name string
+        
+        @route("GET", "/people")
+        @weight(-3)
+        func List() string {
+            return this.name
+        }
+        
+        @deprecated
+        func Old() {
+        }
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert '// Deprecated: use Member instead\ntype Person struct {' in go_code
+    assert '// Deprecated: do not use.\nfunc (this *Person) Old() {' in go_code
+    assert ('var personClass = RegisterClass(&ClassInfo{Name: "Person", Package: "main", '
+            'Annotations: []AnnotationInfo{{Name: "deprecated", Args: []interface{}{"use Member instead"}}}, '
+            'MethodAnnotations: map[string][]AnnotationInfo{"List": {{Name: "route", Args: []interface{}{"GET", "/people"}}, '
+            '{Name: "weight", Args: []interface{}{-3}}}, "Old": {{Name: "deprecated"}}}})') in go_code
+    assert 'func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {' in go_code
+    
+    errors = [
+        ('@weight(-3)', '@size(3)', 'Unknown annotation @size on method Person.List'),
+        ('@deprecated("use Member instead")', '@route("/")', 'Wrong number of arguments to @route on class Person: have 1, want 2 (string, string)'),
+        ('@weight(-3)', '@weight("3")', 'Cannot use "3" (string) as int in argument to @weight'),
+        ('@weight(-3)', '@weight(len(this.name))', 'Argument len(this.name) to @weight must be a constant'),
+        ('@deprecated\n', '@deprecated(1)\n', 'Cannot use 1 as string in argument to @deprecated'),
+        ('annotation weight(n int)', 'annotation json(n int)', 'Annotation @json is already declared'),
+        ('@deprecated("use Member instead")', '@cloneable\n    @weight(1)', None),
+    ]
+    for old, new, message in errors:
+        changed = code.replace(old, new, 1)
+        if message is None:
+            # Declared annotations also apply to classes, next to built-in ones
+            go_code = Transpiler().transpile(Parser(Lexer(changed).tokenize()).parse())
+            assert 'Annotations: []AnnotationInfo{{Name: "cloneable"}, {Name: "weight", Args: []interface{}{1}}}' in go_code
+            continue
+        try:
+            Transpiler().transpile(Parser(Lexer(changed).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Annotation error: {e}")
+    
+    try:
+        Parser(Lexer(code.replace('@deprecated\n        func Old()', '@deprecated\n        age int\n        func Old()', 1)).tokenize()).parse()
+        raise AssertionError("Expected parse error for an annotated field line")
+    except ParseError as e:
+        assert 'Annotation @deprecated in class Person must be followed by a method' in str(e), e
+        print(f"Annotation parse error: {e}")
+    
+    print("Annotations OK!\n")
+
+def test_conditional_compilation():
+    """Tests #if/#elif/#else blocks and //goplus:build constraints evaluated against build tags"""
+    print("=== Testing Conditional Compilation ===")
+    from directives import DirectiveError, evaluate, file_included, parse_tags
+    
+    code = '''//goplus:build linux && !windows
+    
+    package main
+    
+    func main() {
+    #if debug
+        fmt.Println("debug")
+    #elif verbose || (trace && !quiet)
+        fmt.Println("verbose")
+    #else
+        fmt.Println("release")
+    #endif
+        value := "# not a directive"
+    }
+    '''
+    
+    def transpile(tags):
+        return Transpiler().transpile(Parser(Lexer(code, set(tags)).tokenize()).parse())
+    
+    assert 'fmt.Println("debug")' in transpile(['debug']) and 'release' not in transpile(['debug'])
+    assert 'fmt.Println("verbose")' in transpile(['trace'])
+    assert 'fmt.Println("release")' in transpile(['trace', 'quiet'])
+    assert 'value := "# not a directive"' in transpile([])
+    # Removed lines keep their place: later tokens report their own line
+    tokens = Lexer(code, set()).tokenize()
+    assert next(t for t in tokens if t.value == 'value').line == 13
+    
+    assert file_included(code, {'linux'}) and not file_included(code, {'linux', 'windows'})
+    assert file_included('package main\n', set())
+    assert evaluate('!(a || b) && c', {'c'}, 1) and not evaluate('a && b || !c', {'a', 'c'}, 1)
+    assert parse_tags('debug, linux trace') == ['debug', 'linux', 'trace']
+    
+    for source, message in [
+        ('#if debug\npackage main\n', 'Unterminated #if at line 1'),
+        ('package main\n#endif\n', '#endif without #if at line 2'),
+        ('#if a\n#else\n#elif b\n#endif\n', '#elif after #else at line 3'),
+        ('#if a &&\n#endif\n', "Invalid build constraint 'a &&' at line 1"),
+        ('#if\n#endif\n', '#if without a condition at line 1'),
+    ]:
+        try:
+            Lexer(source, set()).tokenize()
+            raise AssertionError(f"Expected error for {source!r}")
+        except LexerError as e:
+            assert message in str(e), e
+            print(f"Directive error: {e}")
+    try:
+        file_included('//goplus:build linux ||\npackage main\n', {'linux'})
+        raise AssertionError("Expected error for an incomplete build constraint")
+    except DirectiveError as e:
+        assert "Invalid build constraint 'linux ||' at line 1" in str(e), e
+    
+    print("Conditional compilation OK!\n")
+
+def test_constants():
+    """Tests compile-time evaluation of constants, class constants, field defaults and enum values"""
+    print("=== Testing Constants ===")
+    
+    code = '''
+    package main
+    
+    const Size = 4 * 1024
+    const Name = "app" + "-" + "v2"
+    const Mode = Size > 1000 ? "big" : "small"
+    const Small int8 = 100 + 27
+    const Ratio = Size / 3.0
+    const Half = -7 / 2
+    const Timeout = math.Pi * 2
+    
+    enum Planet(mass float64, label string) {
+        Earth(5.97 * 2, "e" + "arth"),
+        Mars(0.642 + 1, "mars")
+    }
+    
+    class Box {
+        const Max = Size / 4
+        const Label string = Name + "-box"
+        n int = 2 * 3 + 1
+        k int = Max * 2
+        
+        func Limit() int {
+            return Max + Box.Max
+        }
+    }
+    
+    func main() {
+        Max := 3
+        fmt.Println(Max, Box.Label, Mode)
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'const Size = 4096' in go_code
+    assert 'const Name = "app-v2"' in go_code
+    assert 'const Mode = "big"' in go_code
+    assert 'const Small int8 = 127' in go_code
+    assert 'const Ratio = 1365.3333333333333' in go_code
+    # Integer division truncates toward zero
+    assert 'const Half = -3' in go_code
+    # Not constant for the transpiler: left to the Go compiler
+    assert 'const Timeout = math.Pi * 2' in go_code
+    assert '{11.94, "earth"},' in go_code
+    assert 'const BoxMax = 1024\nconst BoxLabel string = "app-v2-box"' in go_code
+    assert 'obj := &Box{n: 7, k: 2048}' in go_code
+    assert 'return 2048' in go_code
+    # Variables shadow class constants
+    assert 'fmt.Println(Max, BoxLabel, Mode)' in go_code
+    
+    for old, new, message in [
+        ('const Small int8 = 100 + 27', 'const Small int8 = 100 + 28', 'Constant 128 overflows int8 (line 7:'),
+        ('const Half = -7 / 2', 'const Half = Size / (Size - 4096)', 'Division by zero in constant expression'),
+        ('const Size = 4 * 1024', 'const Size = Ratio * 3', 'Constant Size refers to itself'),
+    ]:
+        invalid = code.replace(old, new)
+        try:
+            Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new!r}")
+        except TranspilerError as e:
+            assert message in str(e), e
+            print(f"Constant error: {e}")
+    
+    parts = 'package main\npartial class Box {\n    const Max = 1\n}\npartial class Box {\n    const Max = 2\n}\n'
+    try:
+        Transpiler().transpile(Parser(Lexer(parts).tokenize()).parse())
+        raise AssertionError("Expected duplicate constant error")
+    except TranspilerError as e:
+        assert 'Duplicate member in partial class Box: Max is declared at line 3:5 and line 6:5' in str(e), e
+    
+    print("Constants OK!\n")
+
+def test_unions():
+    """Tests type aliases and union types checked at assignments and match sites"""
+    print("=== Testing Unions ===")
+    
+    code = '''
+    package main
+    
+    type ID = string
+    type Number = int | float64
+    
+    class Circle {
+        r float64
+    }
+    
+    type Shape = Circle | string
+    
+    class Stats {
+        total Number = 0
+    }
+    
+    func describe(n Number) string {
+        return match n {
+            int i -> "int",
+            float64 f -> "float"
+        }
+    }
+    
+    func half(n Number) Number {
+        if n is int {
+            return n / 2
+        }
+        return n.AsFloat64() / 2
+    }
+    
+    func lookup(id ID) string {
+        return id
+    }
+    
+    func read() any {
+        return 1
+    }
+    
+    func main() {
+        var n Number = 5
+        var m Number = 2.5
+        var s Shape = new Circle()
+        var wrapped Number = NumberOf(read())
+        fmt.Println(describe(n), n is int, n as int, s is Circle, lookup("a1"), wrapped)
+        values := []Number{1, 2.5}
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'type ID = string' in go_code
+    assert '// Number holds a value of type int or float64\ntype Number struct {\n    value any\n}' in go_code
+    assert 'func NumberOf(value any) Number {\n    switch value.(type) {\n    case int, float64:\n        return Number{value}' in go_code
+    assert 'panic(NewException("InvalidVariant", fmt.Sprintf("%T is not a variant of Number", value)))' in go_code
+    assert 'func (this Number) IsInt() bool {' in go_code
+    assert 'func (this Number) AsFloat64() float64 {' in go_code
+    # Class variants are held by pointer
+    assert 'func (this Shape) AsCircle() *Circle {' in go_code
+    assert 'obj := &Stats{total: Number{0}}' in go_code
+    assert 'var n Number = Number{5}' in go_code
+    assert 'var m Number = Number{2.5}' in go_code
+    assert 'var s Shape = Shape{NewCircle()}' in go_code
+    assert 'var wrapped Number = NumberOf(read())' in go_code
+    assert 'switch n.Value().(type) {\n    case int:\n        return "int"' in go_code
+    assert 'if nInt, ok := n.Value().(int); ok {' in go_code
+    assert 'return Number{nInt / 2}' in go_code
+    assert 'return Number{n.AsFloat64() / 2}' in go_code
+    assert 'fmt.Println(describe(n), n.IsInt(), n.AsInt(), s.IsCircle(), lookup("a1"), wrapped)' in go_code
+    assert 'values := []Number{Number{1}, Number{2.5}}' in go_code
+    
+    for body, expected in [('var bad Number = "x"', 'Cannot use "x" as Number (variants: int, float64)'),
+                           ('var bad Number = true', 'Cannot use true as Number'),
+                           ('x := match n { int i -> 1 }', 'match on Number is not exhaustive: missing float64'),
+                           ('b := n is string', 'string is not a variant of Number (variants: int, float64)'),
+                           ('x := s as int', 'int is not a variant of Shape (variants: *Circle, string)'),
+                           ('var bad Number = f', 'Cannot use f (float32) as Number')]:
+        try:
+            invalid = code.replace('values := []Number{1, 2.5}', 'var f float32 = 1\n        ' + body)
+            Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+            raise AssertionError(f"Expected error for {body}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Union error: {e}")
+    
+    # An alias is the type it names
+    try:
+        Transpiler().transpile(Parser(Lexer('package main\ntype ID = string\nfunc f() ID {\n    return 5\n}\n').tokenize()).parse())
+        raise AssertionError("Expected alias mismatch error")
+    except TranspilerError as e:
+        assert 'Cannot use 5 as ID in return' in str(e), e
+    
+    for decl, expected in [('type Bad = []int | string', 'Variant []int of union Bad must be a named type'),
+                           ('type Bad = a.Person | b.Person',
+                            'Variants a.Person and b.Person of union Bad would both generate AsPerson()')]:
+        try:
+            Transpiler().transpile(Parser(Lexer(f'package main\n{decl}\n').tokenize()).parse())
+            raise AssertionError(f"Expected error for {decl}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+    
+    print("Unions OK!\n")
+
+def test_nullable():
+    """Tests nullable types: member access needs a nil test first, nil in non-nullable fields warns"""
+    print("=== Testing Nullable Types ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        name string
+        boss Person? get
+        mentor *Person
+        
+        func BossName() string {
+            if this.boss == nil {
+                return "none"
+            }
+            return this.boss.name
+        }
+    }
+    
+    func find(name string) Person? {
+        return nil
+    }
+    
+    func describe(p Person?) string {
+        if p != nil && p.name != "" {
+            return p.name
+        }
+        return p == nil ? "nobody" : "anonymous"
+    }
+    
+    func chain(head Person?) []string {
+        names := []string{}
+        for p := head; p != nil; p = p.boss {
+            names = append(names, p.name)
+        }
+        return names
+    }
+    
+    func main() {
+        found := find("bob")
+        if found == nil {
+            return
+        }
+        fmt.Println(found.name, found?.boss, describe(nil), chain(found))
+        var mentor Person? = new Person()
+        mentor.mentor = nil
+        people := []Person?{mentor}
+        var value any = found
+        fmt.Println(len(people), value is Person ? 1 : 2)
+    }
+    '''
+    
+    transpiler = Transpiler()
+    go_code = transpiler.transpile(Parser(Lexer(code).tokenize()).parse())
+    assert '    boss *Person\n' in go_code
+    assert 'func find(name string) *Person {' in go_code
+    assert 'func describe(p *Person) string {' in go_code
+    assert 'if p != nil && p.name != "" {' in go_code
+    assert 'for p := head; p != nil; p = p.boss {' in go_code
+    assert 'var mentor *Person = NewPerson()' in go_code
+    assert 'people := []*Person{mentor}' in go_code
+    # Declared nullable but proven non-nil by its value; nil in a field that is not nullable is only a warning
+    assert transpiler.warnings == [
+        'nil assigned to non-nullable field Person.mentor (declare it mentor Person? to allow nil) (line 43:9)']
+    
+    for body, expected in [('fmt.Println(find("x").name)', 'find("x") may be nil: test it (find("x") != nil) before using .name'),
+                           ('p := find("x")\n        fmt.Println(p.name)', 'p may be nil'),
+                           ('p := find("x")\n        if p != nil || true { fmt.Println(p.name) }', 'p may be nil'),
+                           ('p := found\n        p = nil\n        fmt.Println(p.name)', 'p may be nil'),
+                           ('fmt.Println(found.boss.name)', 'found.boss may be nil: test it (found.boss != nil) '
+                                                             'before using .name, or use found.boss?.name (line '),
+                           ('var n int? = 1', 'int? is not allowed: int values cannot be nil')]:
+        try:
+            invalid = code.replace('people := []Person?{mentor}', body + '\n        people := []Person?{mentor}')
+            Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+            raise AssertionError(f"Expected error for {body}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Nullable error: {e}")
+    
+    print("Nullable OK!\n")
+
+def test_conversions():
+    """Tests conversion operators applied implicitly at typed sites or explicitly by 'as'"""
+    print("=== Testing Conversion Operators ===")
+    
+    code = '''
+    package main
+    
+    class Celsius {
+        degrees float64
+        
+        Celsius(degrees float64) {
+            this.degrees = degrees
+        }
+        
+        implicit operator convert(degrees float64) *Celsius {
+            return new Celsius(degrees)
+        }
+        
+        operator convert(c *Celsius) *Fahrenheit {
+            return new Fahrenheit(c.degrees * 9 / 5 + 32)
+        }
+        
+        implicit operator convert(c *Celsius) string {
+            return fmt.Sprintf("%.1f C", c.degrees)
+        }
+    }
+    
+    class Fahrenheit {
+        degrees float64
+        
+        Fahrenheit(degrees float64) {
+            this.degrees = degrees
+        }
+    }
+    
+    func show(label string) {
+        fmt.Println(label)
+    }
+    
+    func warm() *Celsius {
+        return 30
+    }
+    
+    func main() {
+        var c *Celsius = 21.5
+        f := c as Fahrenheit
+        show(c)
+        fmt.Println(f.degrees, c as string)
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert '// Float64ToCelsius is the implicit conversion operator from float64 to *Celsius\nfunc Float64ToCelsius(degrees float64) *Celsius {' in go_code
+    assert 'func CelsiusToFahrenheit(c *Celsius) *Fahrenheit {\n    return NewFahrenheit(c.degrees * 9 / 5 + 32)' in go_code
+    assert 'func CelsiusToString(c *Celsius) string {' in go_code
+    # Untyped constants convert through the operator of the numeric type they fit
+    assert 'return Float64ToCelsius(30)' in go_code
+    assert 'var c *Celsius = Float64ToCelsius(21.5)' in go_code
+    assert 'f := CelsiusToFahrenheit(c)' in go_code
+    assert 'show(CelsiusToString(c))' in go_code
+    assert 'fmt.Println(f.degrees, CelsiusToString(c))' in go_code
+    
+    for body, expected in [('var bad *Fahrenheit = c', 'Cannot use c (*Celsius) as *Fahrenheit: the conversion operator '
+                                                         'is explicit; write c as Fahrenheit'),
+                           ('bad := c as? Fahrenheit', 'c as? Fahrenheit cannot fail: it applies the conversion operator '
+                                                       'CelsiusToFahrenheit; use as')]:
+        try:
+            invalid = code.replace('f := c as Fahrenheit', body + '\n        f := c as Fahrenheit')
+            Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+            raise AssertionError(f"Expected error for {body}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Conversion error: {e}")
+    
+    for member, expected in [('operator convert(c *Celsius) *Fahrenheit { return nil }',
+                              'Ambiguous conversion from *Celsius to *Fahrenheit: operators of Celsius (line 15:9) '
+                              'and Celsius (line 23:9)'),
+                             ('operator convert(n int) string { return "" }',
+                              'Conversion operator of Celsius must convert from or to Celsius (have int to string)'),
+                             ('implicit operator convert(n int) *Celsius { return nil }',
+                              'Ambiguous conversion of 30 to *Celsius: operators from float64 and int both apply')]:
+        try:
+            invalid = code.replace('    class Fahrenheit {', f'        {member}\n    }}\n    \n    class Fahrenheit {{', 1)
+            invalid = invalid.replace('fmt.Sprintf("%.1f C", c.degrees)\n        }\n    }', 'fmt.Sprintf("%.1f C", c.degrees)\n        }')
+            Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+            raise AssertionError(f"Expected error for {member}")
+        except TranspilerError as e:
+            assert expected in str(e), e
+            print(f"Conversion error: {e}")
+    
+    print("Conversion operators OK!\n")
+
+def test_name_resolution():
+    """Tests that undefined names are reported before any code is generated"""
+    print("=== Testing Name Resolution ===")
+    
+    code = '''
+    package main
+    
+    enum Color {
+        Red, Green
+    }
+    
+    class Shape {
+        name string
+    }
+    
+    class Square extends Shape {
+        const Sides = 4
+        
+        func Describe(c Color) string {
+            switch c {
+            case Red:
+                return super.name
+            default:
+            }
+            labels := [s + "!" for s in []string{"a"}]
+            count := len(labels) + Sides
+            apply := (x int) -> x * count
+            for i, label in labels {
+                fmt.Println(i, label, apply(i))
+            }
+            try {
+                fmt.Println(strings.ToUpper(this.name))
+            } catch (Exception e) {
+                fmt.Println(e.Error())
+            }
+            return ColorValues()[0].String()
+        }
+    }
+    
+    func main() {
+        value := match 3 {
+            1 -> "one",
+            _ -> "some"
+        }
+        fmt.Println(value, new Square().Describe(Color.Green))
+    }
+    '''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    # Standard packages used without an import are imported
+    assert '    "strings"\n' in go_code
+    assert 'count := len(labels) + SquareSides' in go_code
+    
+    try:
+        invalid = code.replace('fmt.Println(value, ', '{\n            hidden := 1\n        }\n        fmt.Println(hidden, total, this, ')
+        invalid = invalid.replace('new Square()', 'new Circle()').replace('super.name', 'super.name + missing')
+        invalid = invalid.replace('class Square extends Shape', 'class Square')
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected undefined names")
+    except TranspilerError as e:
+        # Every undefined name is reported, in source order, then every undefined member (this.name, once Square
+        # no longer extends Shape)
+        assert str(e).split('\n') == ["'super' used in a class without a base class (line 18:24)",
+                                       'undefined: missing (line 18:37)', 'undefined: hidden (line 44:21)',
+                                       'undefined: total (line 44:29)',
+                                       "'this' used outside a class method (line 44:36)",
+                                       'undefined: Circle (line 44:42)', 'undefined: Square.name (line 28:50)'], e
+        print(f"Resolution error: {e}")
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('switch c {', 'switch cc {')).tokenize()).parse())
+        raise AssertionError("Expected undefined name")
+    except TranspilerError as e:
+        assert str(e) == 'undefined: cc (did you mean c?) (line 16:20)', e
+    
+    print("Name resolution OK!\n")
+    
+def test_package_symbols():
+    """Tests that references into other project packages are checked against their declarations"""
+    print("=== Testing Package Symbols ===")
+    
+    models = '''
+    package models
+    
+    class Person {
+        Name string
+    
+        Person(n string) {
+            this.Name = n
+        }
+    
+        func Greet() string {
+            return "Hi " + this.Name
+        }
+    }
+    
+    func helper() int {
+        return 1
+    }
+    '''
+    
+    code = '''
+    package main
+    
+    import "fmt"
+    import "models"
+    
+    func main() {
+        p := new models.Person("Ann")
+        q := models.NewPerson("Bob")
+        fmt.Println(p.Greet(), q.Name)
+    }
+    '''
+    
+    models_program = Parser(Lexer(models).tokenize()).parse()
+    models_transpiler = Transpiler(project_mode=True)
+    models_transpiler.transpile(models_program)
+    
+    def transpile(source: str) -> str:
+        transpiler = Transpiler(project_mode=True)
+        transpiler.source_file = 'src/main/main.gox'
+        transpiler.register_package_classes('models', models_transpiler.classes)
+        transpiler.register_package_symbols('models', [models_program], models_transpiler.classes)
+        return transpiler.transpile(Parser(Lexer(source).tokenize()).parse())
+    
+    go_code = transpile(code)
+    assert 'p := models.NewPerson("Ann")' in go_code
+    assert 'fmt.Println(p.Greet(), q.Name)' in go_code
+    
+    for old, new, expected in [
+        ('new models.Person("Ann")', 'new Person("Ann")',
+         'src/main/main.gox:8:14: undefined: Person (declared in package models: write models.Person)'),
+        ('models.NewPerson("Bob")', 'NewPerson("Bob")',
+         'src/main/main.gox:9:14: undefined: NewPerson (declared in package models: write models.NewPerson)'),
+        ('new models.Person("Ann")', 'new models.Teacher("Ann")', 'src/main/main.gox:8:14: undefined: models.Teacher'),
+        ('models.NewPerson("Bob")', 'models.helper()',
+         'src/main/main.gox:9:21: models.helper is not exported by package models'),
+        ('p.Greet()', 'p.Great()', 'src/main/main.gox:10:23: undefined: models.Person.Great (did you mean Greet?)'),
+        ('q.Name', 'p.Nam', 'src/main/main.gox:10:34: undefined: models.Person.Nam (did you mean Name?)'),
+    ]:
+        try:
+            transpile(code.replace(old, new))
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert str(e) == expected, e
+            print(f"Symbol error: {e}")
+    
+    print("Package symbols OK!\n")
+    
+def test_parse_recovery():
+    """Tests that the parser reports every syntax error of a file, resuming at the next statement or member"""
+    print("=== Testing Parse Error Recovery ===")
+    
+    code = '''
+    package main
+    
+    class Person {
+        age int = 
+    
+        func Greet() {
+            fmt.Println("hi" +)
+            count := 1
+        }
+    
+        func Count() {
+            for i := 0; i < ; i++ {
+                fmt.Println(i)
+            }
+            fmt.Println(1)
+        }
+    }
+    
+    func main() {
+        if x > {
+            y := 1
+        } else {
+            y := 2
+        }
+        p := new Person(
+        fmt.Println(p)
+    }
+    
+    func valid() int {
+        return 1
+    }
+    '''
+    
+    try:
+        Parser(Lexer(code).tokenize()).parse()
+        raise AssertionError("Expected syntax errors")
+    except ParseError as e:
+        assert str(e).split('\n') == ['Unrecognized expression: func at line 7, column 9',
+                                       'Unrecognized expression: ) at line 8, column 31',
+                                       'Unrecognized expression: ; at line 13, column 29',
+                                       'Unrecognized expression: { at line 21, column 16',
+                                       'Expected RPAREN, found RBRACE at line 28, column 5'], e
+        print(f"Recovered errors:\n{e}")
+    
+    # A valid file parses as before
+    program = Parser(Lexer(code.replace('age int = ', 'age int = 1').replace('"hi" +)', '"hi")')
+                           .replace('i < ;', 'i < 3;').replace('x > {', 'true {')
+                           .replace('new Person(', 'new Person()')).tokenize()).parse()
+    assert [getattr(d, 'name', None) for d in program.declarations] == ['Person', 'main', 'valid']
+    
+    print("Parse error recovery OK!\n")
+    
+def test_source_positions():
+    """Tests that diagnostics and generated lines carry the go-plus file, line and column they come from"""
+    print("=== Testing Source Positions ===")
+    from project_manager import ProjectManager, ProjectConfig, ProjectFile
+    
+    code = '''package main
+
+import "fmt"
+
+class Counter {
+    count int
+
+    func Add(n int) {
+        this.count += n
+    }
+}
+
+func main() {
+    c := new Counter()
+    c.Add(2)
+    fmt.Println(c.count)
+}
+'''
+    
+    # Syntax errors are led by the file
+    try:
+        Parser(Lexer(code.replace('c.Add(2)', 'c.Add(2'), file='src/app.gox').tokenize(), 'src/app.gox').parse()
+        raise AssertionError("Expected a syntax error")
+    except ParseError as e:
+        assert str(e).startswith('src/app.gox: Expected'), e
+    try:
+        Lexer(code.replace('fmt.Println(c.count)', 'fmt.Println("open)'), file='src/app.gox').tokenize()
+        raise AssertionError("Expected a lexer error")
+    except LexerError as e:
+        # At the opening quote, not where the file ends
+        assert str(e) == 'src/app.gox: Unclosed string at line 16, column 17', e
+    
+    # Checker diagnostics use file:line:column
+    program = Parser(Lexer(code.replace('c.Add(2)', 'this.count = 1')).tokenize(), 'src/app.gox').parse()
+    try:
+        Transpiler().transpile(program)
+        raise AssertionError("Expected 'this' to be rejected")
+    except TranspilerError as e:
+        assert str(e) == "'this' used outside a class method (src/app.gox:15:5)", e
+    
+    # Every generated line of a statement or declaration maps back to it
+    program = Parser(Lexer(code).tokenize(), 'src/app.gox').parse()
+    transpiler = Transpiler()
+    lines = transpiler.transpile(program).split('\n')
+    assert len(transpiler.line_origins) == len(lines)
+    origins = {line.strip(): transpiler.line_origins[i] for i, line in enumerate(lines)}
+    assert origins['this.count += n'] == ('src/app.gox', 9, 9, 9, 24), origins
+    assert origins['c.Add(2)'] == ('src/app.gox', 15, 5, 15, 13), origins
+    assert origins['func (this *Counter) Add(n int) {'] == ('src/app.gox', 8, 5, 10, 6), origins
+    assert origins['package main'] is None
+    
+    # Without a parser file the transpiler's source file is used
+    program = Parser(Lexer(code).tokenize()).parse()
+    transpiler = Transpiler()
+    transpiler.source_file = 'app.gox'
+    transpiler.transpile(program)
+    assert ('app.gox', 15, 5, 15, 13) in transpiler.line_origins
+    
+    # go build and go run output is reported at the go-plus source
+    manager = ProjectManager(Path('/project'))
+    manager.config = ProjectConfig(name='app')
+    origins = [None] * 40 + [('src/main/main.gox', 16, 5, 16, 27)]
+    manager.files['src/main/main.gox'] = ProjectFile(Path('/project/src/main/main.gox'), 'main', [],
+                                                     line_origins=origins)
+    output = manager.locate_go_output('./main.go:41:21: cannot use "text" as int value\n',
+                                      Path('/project/build/src/main'))
+    assert output == 'src/main/main.gox:16:5 (./main.go:41:21): cannot use "text" as int value\n', output
+    assert manager.locate_go_output('./main.go:3:1: x\n', Path('/project/build/src/main')) == './main.go:3:1: x\n'
+    assert manager.locate_go_output('/usr/lib/go/fmt.go:9: y', Path('/project/build/src/main')) == \
+        '/usr/lib/go/fmt.go:9: y'
+    
+    print("Source positions OK!\n")
+    
+def test_line_directives():
+    """Tests that //line directives point the generated Go at the go-plus lines, only where they diverge"""
+    print("=== Testing Line Directives ===")
+    from sourcemap import line_directives
+    
+    code = '''package main
+
+import "fmt"
+
+func main() {
+    total := 0
+    total += 3
+    text := `first
+second`
+    fmt.Println(total, text)
+}
+'''
+    
+    program = Parser(Lexer(code).tokenize(), 'src/app.gox').parse()
+    transpiler = Transpiler()
+    go_code, origins = line_directives(transpiler.transpile(program), transpiler.line_origins,
+                                       Path('build/src/app.go'))
+    lines = go_code.split('\n')
+    assert len(origins) == len(lines)
+    directives = [(i, line) for i, line in enumerate(lines) if line.startswith('//line ')]
+    # The function's closing brace has no line of its own and goes back to the function's
+    assert [line for _, line in directives] == ['//line ../../src/app.gox:5:1', '//line ../../src/app.gox:5:1'], \
+        directives
+    # Mirrored lines follow the directive without one of their own, raw strings are left intact
+    index = directives[0][0]
+    assert lines[index + 1:index + 7] == ['func main() {', '    total := 0', '    total += 3', '    text := `first',
+                                          'second`', '    fmt.Println(total, text)'], lines
+    
+    # A line generated away from its source line gets a directive; generated code after it is pointed back
+    go_code, _ = line_directives('package main\n\nfunc a() {\n}\n\nvar helper = 1',
+                                 [None, None, ('src/app.gox', 3, 1, 9, 2), ('src/app.gox', 9, 1, 9, 2), None, None],
+                                 Path('build/src/app.go'))
+    assert go_code.split('\n') == ['package main', '', '//line ../../src/app.gox:3:1', 'func a() {',
+                                   '//line ../../src/app.gox:9:1', '}', '', '//line app.go:9',
+                                   'var helper = 1'], go_code
+    
+    print("Line directives OK!\n")
+    
+def test_source_maps():
+    """Tests the JSON source map relating generated spans to go-plus spans"""
+    print("=== Testing Source Maps ===")
+    from sourcemap import source_map
+    
+    code = '''package main
+
+import "fmt"
+
+func main() {
+    total := 0
+    if total == 0 {
+        total = 8
+    }
+    fmt.Println(total)
+}
+'''
+    
+    program = Parser(Lexer(code).tokenize(), 'src/app.gox').parse()
+    transpiler = Transpiler()
+    go_code = transpiler.transpile(program)
+    mapping = source_map(go_code, transpiler.line_origins, Path('build/src/app.go'))
+    assert mapping['file'] == 'app.go' and mapping['sources'] == ['../../src/app.gox'], mapping
+    
+    lines = go_code.split('\n')
+    spans = {lines[m['generated']['start']['line'] - 1].strip(): m for m in mapping['mappings']}
+    statement = spans['total := 0']
+    assert statement['generated']['start']['column'] == 5
+    assert statement['generated']['end'] == {'line': statement['generated']['start']['line'], 'column': 15}
+    assert statement['original'] == {'start': {'line': 6, 'column': 5}, 'end': {'line': 6, 'column': 15}}, statement
+    # A statement spanning lines maps all of its span
+    assert spans['if total == 0 {']['original'] == {'start': {'line': 7, 'column': 5},
+                                                     'end': {'line': 9, 'column': 6}}, spans
+    assert spans['fmt.Println(total)']['original']['start'] == {'line': 10, 'column': 5}
+    # Generated code with no go-plus counterpart is left out
+    assert 'package main' not in spans and 'import (' not in spans
+    
+    print("Source maps OK!\n")
+    
+def test_go_check():
+    """Tests that generated Go is compiled before it is written, with failures reported at the go-plus lines"""
+    print("=== Testing Go Check ===")
+    from gocheck import check_files, go_available
+    if not go_available():
+        print("go not found, skipped\n")
+        return
+    
+    code = '''package main
+
+import "fmt"
+
+func main() {
+    total := 1
+    var label int = "total"
+    fmt.Println(total, label)
+}
+'''
+    
+    go_mod = 'module goplus/check\n\ngo 1.23\n'
+    program = Parser(Lexer(code).tokenize(), 'src/app.gox').parse()
+    transpiler = Transpiler()
+    go_code = transpiler.transpile(program)
+    errors = check_files({'app.go': (go_code, transpiler.line_origins)}, go_mod)
+    assert len(errors) == 1 and errors[0].startswith('src/app.gox:7:5: cannot use "total"'), errors
+    line = go_code.split('\n').index('    var label int = "total"') + 1
+    assert errors[0].endswith(f'(app.go:{line}:21)'), errors
+    
+    program = Parser(Lexer(code.replace('"total"', '2')).tokenize(), 'src/app.gox').parse()
+    transpiler = Transpiler()
+    assert check_files({'app.go': (transpiler.transpile(program), transpiler.line_origins)}, go_mod) == []
+    
+    # An import of a module the check cannot resolve does not block the output: main.py warns and writes it
+    import io
+    import os
+    import sys
+    import tempfile
+    import contextlib
+    import main as single_file
+    from gocheck import find_module, unresolved_imports
+    
+    uses_uuid = ('package main\n\nimport "fmt"\nimport "github.com/google/uuid"\n\n'
+                 'func main() {\n    fmt.Println(uuid.New())\n}\n')
+    saved = {name: os.environ.get(name) for name in ('GOFLAGS', 'GOPROXY')}
+    os.environ.update(GOFLAGS='-mod=readonly', GOPROXY='off')  # no module is added or downloaded
+    try:
+        with tempfile.TemporaryDirectory() as scratch:
+            root = Path(scratch)
+            (root / 'cmd').mkdir()
+            (root / 'go.mod').write_text('module example.com/app\n\ngo 1.23\n', encoding='utf-8')
+            (root / 'cmd' / 'tool.gox').write_text(uses_uuid, encoding='utf-8')
+            assert find_module(root / 'cmd') == root
+            
+            program = Parser(Lexer(uses_uuid).tokenize(), 'cmd/tool.gox').parse()
+            transpiler = Transpiler()
+            errors = check_files({'cmd/tool.go': (transpiler.transpile(program), transpiler.line_origins)},
+                                 'module example.com/app\n\ngo 1.23\n')
+            assert len(errors) == 1 and unresolved_imports(errors) == errors, errors
+            # The import is generated code: the error names the go-plus file, not the unwritten Go file first
+            assert errors[0].startswith('cmd/tool.gox: ') and '(generated cmd/tool.go:' in errors[0], errors
+            
+            output = io.StringIO()
+            argv = sys.argv
+            sys.argv = ['main.py', str(root / 'cmd' / 'tool.gox'), '--format', 'none']
+            try:
+                with contextlib.redirect_stdout(output):
+                    single_file.main()
+            finally:
+                sys.argv = argv
+            assert 'Warning: the generated Go is written without being compiled' in output.getvalue(), output.getvalue()
+            assert 'uuid.New()' in (root / 'cmd' / 'tool.go').read_text(encoding='utf-8')
+    finally:
+        for name, value in saved.items():
+            if value is None:
+                os.environ.pop(name, None)
+            else:
+                os.environ[name] = value
+    
+    print("Go check OK!\n")
+    
+def test_formatting():
+    """Tests that generated Go is formatted with gofmt and keeps the go-plus origins of its lines"""
+    print("=== Testing Formatting ===")
+    from goformat import format_code, formatter_available
+    from sourcemap import realign
+    
+    # Origins follow the lines through reindenting, respacing and dropped blank lines
+    old = 'func main() {\n    x := 1\n\n\n    fmt.Println(x+1)\n}'
+    new = 'func main() {\n\tx := 1\n\n\tfmt.Println(x + 1)\n}\n'
+    origins = [('a.gox', 1, 1, 4, 2), ('a.gox', 2, 5, 2, 11), None, None, ('a.gox', 3, 5, 3, 21), ('a.gox', 1, 1, 4, 2)]
+    assert realign(old, new, origins) == [('a.gox', 1, 1, 4, 2), ('a.gox', 2, 5, 2, 11), None,
+                                          ('a.gox', 3, 5, 3, 21), ('a.gox', 1, 1, 4, 2), None]
+    
+    if not formatter_available('gofmt'):
+        print("gofmt not found, skipped\n")
+        return
+    
+    code = '''package main
+
+import "fmt"
+
+func main() {
+    total := 0
+    try {
+        total = 1
+    } catch (IOError e) {
+        fmt.Println(e)
+    } catch (Exception e) {
+        fmt.Println(e)
+    }
+    fmt.Println(total)
+}
+'''
+    
+    program = Parser(Lexer(code).tokenize(), 'src/app.gox').parse()
+    transpiler = Transpiler()
+    go_code, origins = format_code(transpiler.transpile(program), transpiler.line_origins)
+    lines = go_code.split('\n')
+    assert len(origins) == len(lines) and go_code.endswith('}\n')
+    assert '\tfmt.Println(total)' in lines
+    assert origins[lines.index('\tfmt.Println(total)')][:3] == ('src/app.gox', 14, 5)
+    # Catch clauses chain as if, else if and a final else for the catch-all, which gofmt leaves as it is
+    assert '\t\t\t\tif ex.Type() == "IOError" {' in lines and '\t\t\t\t} else {' in lines, go_code
+    assert format_code(go_code, origins)[0] == go_code
+    # Code the formatter cannot parse is left for the compile check
+    assert format_code('package main\nfunc (', [None, None]) == ('package main\nfunc (', [None, None])
+    
+    print("Formatting OK!\n")
+    
+def test_comments():
+    """Tests that comments of the source are carried into the generated Go"""
+    print("=== Testing Comments ===")
+    
+    code = '''// Copyright notice
+//goplus:build !nothing
+package main
+
+import "fmt"
+
+// Counter counts things.
+class Counter {
+    count int // current value
+    
+    /* Step between
+       two counts */
+    step int
+    
+    // Counter starts at zero.
+    Counter() {
+        this.step = 1
+    }
+    
+    // Add adds n.
+    @deprecated("use Inc")
+    func Add(n int) {
+        this.count += n
+    }
+}
+
+// Point is a plain struct.
+struct Point {
+    // Horizontal
+    X int
+    Y int // vertical
+}
+
+func main() { // entry point
+    c := NewCounter()
+    // Add twice
+    c.Add(1) // once
+    c.Add(2)
+    if c.count > 0 {
+        fmt.Println(c.count)
+        // nothing else
+    }
+}
+'''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    lines = [line.strip() for line in go_code.split('\n')]
+    assert lines[0] == '// Copyright notice' and lines[1] == 'package main'
+    assert '//goplus:build !nothing' not in go_code
+    assert lines[lines.index('type Counter struct {') - 1] == '// Counter counts things.'
+    assert 'count int // current value' in lines
+    assert lines[lines.index('step int') - 2:lines.index('step int')] == ['/* Step between', 'two counts */']
+    assert lines[lines.index('func NewCounter() *Counter {') - 1] == '// Counter starts at zero.'
+    add = lines.index('func (this *Counter) Add(n int) {')
+    assert lines[add - 3:add] == ['// Add adds n.', '//', '// Deprecated: use Inc']
+    assert lines[lines.index('type Point struct {') - 1] == '// Point is a plain struct.'
+    assert lines[lines.index('X int') - 1] == '// Horizontal' and 'Y int // vertical' in lines
+    assert lines[lines.index('c := NewCounter()') - 1] == '// entry point'
+    assert lines[lines.index('c.Add(1) // once') - 1] == '// Add twice'
+    assert lines[lines.index('fmt.Println(c.count)') + 1] == '// nothing else'
+    
+    print("Comments OK!\n")
+    
+def test_deterministic_order():
+    """Tests that project files are discovered and transpiled in path order, so builds are byte-identical"""
+    print("=== Testing Deterministic Order ===")
+    import io
+    import tempfile
+    import contextlib
+    from project_manager import ProjectManager
+    
+    sources = {
+        'src/shapes/square.gox': 'package shapes\n\n@stringer\nclass Square {\n    side int\n}\n',
+        'src/shapes/circle.gox': 'package shapes\n\n@stringer\nclass Circle {\n    radius int\n}\n',
+        'src/main/main.gox': 'package main\n\nimport "fmt"\nimport "shapes"\n\n'
+                             'func main() {\n    fmt.Println(shapes.NewSquare(), shapes.NewCircle())\n}\n',
+    }
+    builds = []
+    for names in (list(sources), list(reversed(sources))):
+        with tempfile.TemporaryDirectory() as scratch:
+            root = Path(scratch) / 'app'
+            for name in names:
+                (root / name).parent.mkdir(parents=True, exist_ok=True)
+                (root / name).write_text(sources[name], encoding='utf-8')
+            manager = ProjectManager(root, verify=False, formatter='none')
+            with contextlib.redirect_stdout(io.StringIO()):
+                manager.load_config()
+                manager.transpile_project()
+            assert list(manager.files) == sorted(sources), list(manager.files)
+            order = manager.get_transpilation_order()
+            assert order == ['src/shapes/circle.gox', 'src/shapes/square.gox', 'src/main/main.gox'], order
+            build = root / manager.config.output_dir
+            builds.append({p.relative_to(build).as_posix(): p.read_bytes()
+                           for p in sorted(build.rglob('*')) if p.is_file()})
+    assert builds[0] == builds[1]
+    # The class runtime of the package goes to its first file by path
+    assert b'type ClassInfo struct' in builds[0]['src/shapes/circle.go']
+    assert b'type ClassInfo struct' not in builds[0]['src/shapes/square.go']
+    
+    print("Deterministic order OK!\n")
+    
+def test_unused_imports():
+    """Tests that generated files import only the packages their code refers to"""
+    print("=== Testing Unused Imports ===")
+    from goimports import package_name
+    
+    code = '''package main
+
+import "fmt"
+import "os"
+import "strings"
+import str "strings"
+import _ "embed"
+
+func main() {
+    try {
+        fmt.Println(str.ToUpper("os.Exit"))  // os.Exit
+    } catch (Exception e) {
+        fmt.Println(e.Error())
+    }
+}
+'''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    block = go_code[go_code.index('import ('):go_code.index(')', go_code.index('import ('))]
+    assert block.split('\n')[1:] == ['    _ "embed"', '    "fmt"', '    str "strings"', ''], block
+    assert '"errors"' not in go_code and '"os"' not in go_code
+    
+    # A file referring to no package has no import block
+    go_code = Transpiler().transpile(Parser(Lexer('package main\n\nimport "fmt"\n\nfunc main() {\n}\n').tokenize()).parse())
+    assert 'import' not in go_code
+    
+    assert package_name('gopkg.in/yaml.v3') == 'yaml'
+    assert package_name('github.com/jackc/pgx/v5') == 'pgx'
+    assert package_name('github.com/user/app/src/models') == 'models'
+    assert package_name('strings', 'str') == 'str'
+    
+    print("Unused imports OK!\n")
+    
+def test_import_management():
+    """Tests grouped imports, automatic standard imports and references to the shared exceptions package"""
+    print("=== Testing Import Management ===")
+    from goimports import qualify
+    
+    code = '''package main
+
+import (
+    "fmt"
+    str "strings"
+)
+import "fmt"
+
+func main() {
+    fmt.Println(str.ToUpper("a"), strconv.Itoa(1))
+}
+'''
+    
+    program = Parser(Lexer(code).tokenize()).parse()
+    assert [(imp.path, imp.alias) for imp in program.imports] == [('fmt', None), ('strings', 'str'), ('fmt', None)]
+    go_code = Transpiler().transpile(program)
+    assert go_code.count('import') == 1 and go_code.count('"fmt"') == 1, go_code
+    assert '"strconv"' in go_code and 'str "strings"' in go_code
+    try:
+        Parser(Lexer('package main\n\nimport (\n    "fmt"\n\nfunc main() {\n}\n').tokenize()).parse()
+        raise AssertionError("Expected an unclosed import group to be rejected")
+    except ParseError as e:
+        assert 'Expected import path' in str(e), e
+    
+    # In a project the exception runtime lives in the exceptions package
+    code = '''package models
+
+func check(age int) {
+    if age < 0 {
+        throw NewException("InvalidAge", "Age cannot be negative")
+    }
+    try {
+        check(1)
+    } catch (Exception e) {
+        fmt.Println(e.Error())
+    }
+}
+'''
+    
+    program = Parser(Lexer(code).tokenize()).parse()
+    program.imports.append(ImportDecl('github.com/user/app/exceptions'))
+    transpiler = Transpiler(project_mode=True)
+    transpiler.exceptions_package = 'exceptions'
+    go_code = transpiler.transpile(program)
+    assert 'panic(exceptions.NewException("InvalidAge", "Age cannot be negative"))' in go_code
+    assert 'ex, _ := r.(exceptions.Exception)' in go_code and 'if true' not in go_code and 'ex.Type()' not in go_code
+    assert '"github.com/user/app/exceptions"' in go_code and 'type BaseException' not in go_code
+    assert len(transpiler.line_origins) == len(go_code.split('\n'))
+    
+    assert qualify('x := NewException("NewException") // NewException\nerr.NewException()', {'NewException'},
+                   'exceptions') == 'x := exceptions.NewException("NewException") // NewException\nerr.NewException()'
+    
+    print("Import management OK!\n")
+    
+def test_constructor_defaults():
+    """Tests that constructors fold constant field defaults into the allocation and skip overwritten ones"""
+    print("=== Testing Constructor Defaults ===")
+    
+    code = '''package main
+
+import "fmt"
+
+var created = 0
+
+func nextID() int {
+    created++
+    return created
+}
+
+class Account {
+    owner string = "nobody"
+    balance float64 = 0.0
+    limit int = 500
+    id int = nextID()
+    active bool = false
+    note string = "new"
+    level int = 1
+    
+    Account(o string, l int) {
+        if l < 0 {
+            throw NewException("InvalidLimit", "limit cannot be negative")
+        }
+        prefix := "acct-"
+        this.owner = prefix + o
+        this.id = l
+        this.Describe()
+        this.limit = l
+        this.level = this.limit
+    }
+    
+    func Describe() {
+        fmt.Println(this.owner, this.note)
+    }
+}
+
+class Flag {
+    on bool = true
+    count int = 0
+}
+'''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    # Zero values are left to the allocation; the owner is overwritten before anything can read it
+    assert 'obj := &Account{limit: 500, note: "new", level: 1}\n    obj.id = nextID()\n    if l < 0 {' in go_code, go_code
+    assert 'obj.owner' not in go_code.split('obj.owner = prefix + o')[0].split('func NewAccount')[1]
+    # After this.Describe() the stores are kept, as are defaults with effects (nextID is called once per object)
+    assert 'obj.Describe()\n    obj.limit = l\n    obj.level = obj.limit' in go_code
+    assert 'obj := &Flag{on: true}\n    return obj' in go_code
+    
+    print("Constructor defaults OK!\n")
+    
+def test_minimal_emission():
+    """Tests that generated Go has parentheses only where precedence needs them and no braces that scope nothing"""
+    print("=== Testing Minimal Emission ===")
+    
+    code = '''package main
+
+import "fmt"
+
+func main() {
+    a := 3
+    b := (a + 1) * 2
+    c := -(-a)
+    d := ((a * 2)) + (a - (b - 1))
+    {
+        fmt.Println(a)
+        {
+            k := 2
+            fmt.Println(k)
+        }
+    }
+    {
+        var n int = b
+        fmt.Println(n)
+    }
+    if (c < d) {
+        throw NewException("Order", "c < d")
+    }
+}
+'''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'b := (a + 1) * 2\n    c := -(-a)\n    d := a * 2 + (a - (b - 1))' in go_code, go_code
+    # The outer block declares nothing; the inner ones keep their braces for k and n
+    assert 'd := a * 2 + (a - (b - 1))\n    fmt.Println(a)\n    {\n        k := 2' in go_code
+    assert '    }\n    {\n        var n int = b\n        fmt.Println(n)\n    }' in go_code
+    assert 'if c < d {\n        panic(NewException("Order", "c < d"))\n    }' in go_code
+    
+    print("Minimal emission OK!\n")
+    
+def test_receiver_names():
+    """Tests the configurable receiver name of generated methods and its collisions with names they use"""
+    print("=== Testing Receiver Names ===")
+    
+    code = '''package main
+
+import "fmt"
+
+@cloneable
+class Cell {
+    value int
+    
+    func Merge(c Cell) int {
+        return this.value + c.value
+    }
+    
+    func Show() {
+        fmt.Println("this cell", this.value) // this is kept in comments
+    }
+}
+
+class Person {
+    name string
+    
+    func Greet() string {
+        return "Hi " + this.name
+    }
+}
+
+enum Color { Red, Green }
+'''
+    
+    def transpile(receiver):
+        return Transpiler(receiver=receiver).transpile(Parser(Lexer(code).tokenize()).parse())
+    
+    assert 'func (this *Person) Greet() string {\n    return "Hi " + this.name' in transpile('this')
+    
+    go_code = transpile('self')
+    assert 'func (self *Person) Greet() string {\n    return "Hi " + self.name' in go_code
+    assert 'func (self Color) String() string {' in go_code
+    assert 'this.' not in go_code and 'func (this' not in go_code
+    
+    # The parameter c and the clone's local c are taken, so every method of Cell uses c2
+    go_code = transpile('initial')
+    assert 'func (c2 *Cell) Merge(c Cell) int {\n    return c2.value + c.value' in go_code, go_code
+    assert 'fmt.Println("this cell", c2.value) // this is kept in comments' in go_code
+    assert 'func (c2 *Cell) Clone() *Cell {' in go_code
+    assert 'func (p *Person) Greet() string {\n    return "Hi " + p.name' in go_code
+    assert 'func (c Color) String() string {' in go_code
+    
+    try:
+        Transpiler(receiver='me')
+        raise AssertionError("Expected error for an unknown receiver name")
+    except TranspilerError as e:
+        assert "Unknown receiver name 'me'" in str(e), e
+        print(f"Receiver error: {e}")
+    
+    print("Receiver names OK!\n")
+    
+def test_diagnostics():
+    """Tests the warnings about shadowed fields, locals never read and unused private fields"""
+    print("=== Testing Diagnostics ===")
+    
+    code = '''package main
+
+import "fmt"
+
+data class Point(x, y int)
+
+class Person {
+    name string
+    nickname string
+    Age int
+    
+    Person(name string) {
+        this.name = name
+    }
+    
+    func Rename(n string) {
+        name := n + "!"
+        this.name = name
+    }
+    
+    func Count(items []string) int {
+        total := 0
+        total = len(items)
+        seen := 0
+        seen++
+        for item in items {
+            fmt.Println(item)
+        }
+        apply := () -> fmt.Println(total)
+        apply()
+        return 0
+    }
+}
+
+func main() {
+    p := new Person("Ann")
+    q := Point(1, 2)
+    r, _ := 1, 2
+    p.Rename("Bo")
+    fmt.Println(p.name, q, p.Count(nil))
+}
+'''
+    
+    transpiler = Transpiler()
+    transpiler.transpile(Parser(Lexer(code).tokenize()).parse())
+    assert transpiler.warnings == [
+        'name shadows field Person.name (use this.name for the field) (line 17:9)',
+        'seen is assigned but never read (line 24:9)',
+        'r is assigned but never read (line 38:5)',
+        'field Person.nickname is never used (line 9:5)'], transpiler.warnings
+    
+    print("Diagnostics OK!\n")
+    
+def test_dead_code():
+    """Tests the warnings about dead code and its removal in release mode"""
+    print("=== Testing Dead Code ===")
+    
+    code = '''package main
+
+import "fmt"
+
+class helper {
+    func Help() string {
+        return "help"
+    }
+}
+
+class report {
+    h helper
+    
+    func Print() {
+        fmt.Println(this.h.Help())
+    }
+}
+
+class Counter {
+    n int
+    
+    func Inc() {
+        this.n++
+    }
+    
+    func reset() {
+        this.n = 0
+    }
+    
+    func String() string {
+        return fmt.Sprint(this.n)
+    }
+    
+    func Twice() int {
+        return this.n * 2
+    }
+}
+
+func check(n int) int {
+    if n < 0 {
+        throw NewException("Negative", "n < 0")
+        fmt.Println("never")
+    }
+    switch n {
+    case 1:
+        return 1
+        n++
+    }
+    return n
+}
+
+func main() {
+    c := new Counter()
+    c.Inc()
+    fmt.Println(c, check(2))
+    fmt.Fprintf(new Buf(), "%d", c.n)
+    stop()
+}
+
+class Buf {
+    n int
+    
+    func Write(p []byte) (int, error) {
+        this.n += len(p)
+        return len(p), nil
+    }
+}
+
+func stop() {
+    return
+    fmt.Println("after")
+}
+'''
+    
+    transpiler = Transpiler()
+    go_code = transpiler.transpile(Parser(Lexer(code).tokenize()).parse())
+    assert transpiler.warnings == [
+        'unreachable code (line 42:9)',
+        'unreachable code (line 47:9)',
+        'unreachable code (line 71:5)',
+        'method Counter.reset is never called (line 26:5)',
+        'class helper is never used (line 5:1)',
+        'class report is never used (line 11:1)'], transpiler.warnings
+    # Without release mode everything is still generated
+    assert 'func (this *Counter) reset() {' in go_code and 'type helper struct' in go_code
+    assert 'fmt.Println("never")' in go_code
+    
+    transpiler = Transpiler(release=True)
+    go_code = transpiler.transpile(Parser(Lexer(code).tokenize()).parse())
+    assert len(transpiler.warnings) == 6
+    assert 'helper' not in go_code and 'type report' not in go_code and 'reset' not in go_code
+    # Exported methods stay: fmt calls String, and Write makes a Buf the io.Writer of Fprintf
+    assert 'func (this *Counter) String() string {' in go_code and 'func (this *Counter) Twice() int {' in go_code
+    assert 'func (this *Buf) Write(p []byte) (int, error) {' in go_code
+    assert 'panic(NewException("Negative", "n < 0"))\n    }' in go_code and '"never"' not in go_code
+    assert 'case 1:\n            return 1\n    }' in go_code
+    # A bare return ends at its line: the call after it is unreachable, not its value
+    assert 'func stop() {\n    return\n}' in go_code and '"after"' not in go_code
+    
+    from gocheck import check_files, go_available
+    if go_available():
+        errors = check_files({'main.go': (go_code, transpiler.line_origins)}, 'module goplus/check\n\ngo 1.23\n')
+        assert not errors, errors
+    
+    print("Dead code OK!\n")
+    
+def test_constant_folding():
+    """Tests that operations on constants are folded where they are used"""
+    print("=== Testing Constant Folding ===")
+    
+    code = '''package main
+
+import "fmt"
+
+const Distance = 250
+const Prefix = "fuel"
+const Limit int8 = 100
+
+class Trip {
+    const Stops = 4
+    
+    func Cost(price float64) float64 {
+        return price * (Distance * 0.1) / Stops
+    }
+}
+
+func main() {
+    name := "car"
+    message := "Trip of " + "a " + name + " (" + Prefix + ") " + "done"
+    big := Limit + 20
+    Distance := 3
+    fmt.Println(message, big, Distance * 2, -(Distance - 5), Distance > 100 == (Limit > 50))
+}
+'''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'return price * 25.0 / TripStops' in go_code
+    assert 'message := "Trip of a " + name + " (fuel) done"' in go_code
+    assert 'big := int8(120)' in go_code
+    # A local named like a constant is not folded
+    assert 'fmt.Println(message, big, Distance * 2, -(Distance - 5), Distance > 100 == true)' in go_code
+    
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('Limit + 20', 'Limit + 28')).tokenize()).parse())
+        raise AssertionError("Expected overflow error")
+    except TranspilerError as e:
+        assert 'Constant 128 overflows int8' in str(e), e
+    
+    print("Constant folding OK!\n")
+    
+def test_inline_accessors():
+    """Tests that --inline-accessors replaces calls to trivial accessors with field access"""
+    print("=== Testing Inline Accessors ===")
+    
+    code = '''package main
+
+import "fmt"
+
+interface Shape {
+    Area() float64
+}
+
+class Circle implements Shape {
+    radius float64
+    
+    func Area() float64 {
+        return 3.14 * this.radius * this.radius
+    }
+}
+
+@accessors
+class Car {
+    name string
+    fuelLevel int
+    shape *Circle
+    
+    func validateFuelLevel(level int) {
+        if level < 0 {
+            throw new Exception("InvalidFuel", "Fuel cannot be negative")
+        }
+    }
+    
+    func GetShape() Shape {
+        return this.shape
+    }
+    
+    func Label() string {
+        return this.GetName()
+    }
+}
+
+class RaceCar extends Car {
+    name string
+}
+
+func main() {
+    car := new Car()
+    car.SetName("Beetle")
+    car.SetFuelLevel(10)
+    race := new RaceCar()
+    fmt.Println(car.GetName(), car.GetFuelLevel(), car.GetShape(), race.GetName(), car.Label())
+}
+'''
+    
+    program = Parser(Lexer(code).tokenize()).parse()
+    go_code = Transpiler(inline_accessors=True).transpile(program)
+    assert 'car.name = "Beetle"' in go_code
+    assert 'return this.name' in go_code  # the getter itself is kept
+    assert 'func (this *Car) Label() string {\n    return this.name\n}' in go_code
+    # The setter runs the validation hook and the getter returns an interface: both stay calls
+    assert 'car.SetFuelLevel(10)' in go_code
+    assert 'fmt.Println(car.name, car.fuelLevel, car.GetShape(), race.GetName(), car.Label())' in go_code
+    # RaceCar.name shadows the Car.name the getter reads
+    assert 'race.GetName()' in go_code
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'car.SetName("Beetle")' in go_code and 'car.GetName(), car.GetFuelLevel()' in go_code
+    
+    print("Inline accessors OK!\n")
+    
+def test_value_classes():
+    """Tests @valueclass: instances held by value with value receivers"""
+    print("=== Testing Value Classes ===")
+    
+    code = '''package main
+
+import "fmt"
+
+interface Shape {
+    Area() float64
+}
+
+@valueclass
+class Point implements Shape {
+    x float64
+    y float64
+    
+    Point(x, y float64) {
+        this.x = x
+        this.y = y
+    }
+    
+    func Add(other Point) Point {
+        return new Point(this.x + other.x, this.y + other.y)
+    }
+    
+    func Area() float64 {
+        return 0
+    }
+}
+
+@valueclass
+data class Money(cents int64, currency string)
+
+@valueclass
+record Size(w, h int)
+
+func main() {
+    p := new Point(1, 2).Add(new Point(3, 4))
+    m := new Money(100, "EUR")
+    s := new Size(1, 2) with { h: 5 }
+    fmt.Println(p, m == new Money(100, "EUR"), s)
+}
+'''
+    
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func NewPoint(x float64, y float64) Point {\n    obj := Point{}' in go_code
+    assert 'func (this Point) Add(other Point) Point {' in go_code
+    assert 'func (this Point) GetType() *ClassInfo {' in go_code
+    assert 'var _ Shape = Point{}' in go_code
+    assert 'func (this Money) Equals(other Money) bool {\n    return this.cents == other.cents' in go_code
+    assert 'func (this Size) copyWith(set func(*Size)) Size {\n    set(&this)\n    return this' in go_code
+    assert 's := NewSize(1, 2).copyWith(func(c *Size) { c.h = 5 })' in go_code
+    
+    errors = [
+        (code.replace('return 0', 'this.x = 0\n        return 0'),
+         'Cannot assign to this.x in a method of value class Point: its receiver is a copy'),
+        (code + 'class Point3 extends Point {\n    z float64\n}\n', 'Value class Point cannot be extended by Point3'),
+        (code.replace('@valueclass\nrecord', '@valueclass @json\nrecord'), '@json cannot be used on value class Size'),
+    ]
+    for source, message in errors:
+        try:
+            Transpiler().transpile(Parser(Lexer(source).tokenize()).parse())
+            raise AssertionError(f"Expected error: {message}")
+        except TranspilerError as e:
+            assert message in str(e), e
+    
+    print("Value classes OK!\n")
+    
+def test_warning_settings():
+    """Tests warning IDs, severities and their suppression by settings and //goplus:nowarn"""
+    print("=== Testing Warning Settings ===")
+    
+    code = '''package main
+
+import "fmt"
+
+class Person {
+    name string
+    nickname string //goplus:nowarn GP1004
+    
+    func Rename(n string) {
+        name := n //goplus:nowarn
+        this.name = name
+        seen := 0
+        seen++
+        //goplus:nowarn GP1002
+        total := 1
+        total = 2
+    }
+}
+
+func main() {
+    p := new Person()
+    p.Rename("Bo")
+    fmt.Println(p.name)
+}
+'''
+    
+    transpiler = Transpiler()
+    transpiler.transpile(Parser(Lexer(code).tokenize()).parse())
+    assert transpiler.warnings == ['seen is assigned but never read (line 12:9)',
+                                   'total is assigned but never read (line 15:9)'], transpiler.warnings
+    assert [(w.code, w.severity) for w in transpiler.warnings] == [('GP1003', 'warning'), ('GP1003', 'warning')]
+    
+    transpiler = Transpiler(warning_levels=parse_warning_levels('GP1003=error GP1004=off'))
+    go_code = transpiler.transpile(Parser(Lexer(code.replace(' //goplus:nowarn GP1004', '')).tokenize()).parse())
+    assert '//goplus:nowarn' not in go_code
+    assert [w.severity for w in transpiler.warnings] == ['error', 'error']
+    try:
+        report_warnings(transpiler.warnings, 'person.gox')
+        raise AssertionError("Expected the build to fail")
+    except TranspilerError as e:
+        assert str(e) == 'person.gox: 2 warnings reported as error', e
+    
+    for levels, message in [({'GP9999': 'error'}, 'Unknown warning GP9999 in the warning settings'),
+                            ({'GP1003': 'fatal'}, "Unknown severity 'fatal' for warning GP1003")]:
+        try:
+            Transpiler(warning_levels=levels)
+            raise AssertionError(f"Expected error: {message}")
+        except TranspilerError as e:
+            assert message in str(e), e
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('GP1004', 'GP1040')).tokenize()).parse())
+        raise AssertionError("Expected unknown warning error")
+    except TranspilerError as e:
+        assert 'Unknown warning GP1040 in //goplus:nowarn (line 7)' in str(e), e
+    
+    print("Warning settings OK!\n")
+    
+def test_lint():
+    """Tests the lint rules for go-plus constructs"""
+    print("=== Testing Lint ===")
+    
+    code = '''package main
+
+import "fmt"
+
+var counter int = 0
+var limit int = 10
+
+class Person {
+    age int
+    
+    Person(age int) {
+        if age < 0 {
+            throw new Exception("InvalidAge", "age cannot be negative")
+        }
+        this.age = age
+    }
+    
+    func Load() {
+        try {
+            fmt.Println(this.age)
+        } catch {
+            fmt.Println("failed")
+        } catch (IOError e) {
+        }
+        try {
+            counter++
+        } catch (IOError e) {
+            // the value is optional
+        }
+    }
+    
+    func Limit(limit int) int {
+        limit = limit + 1
+        return limit
+    }
+}
+
+class Account {
+    // Throws InvalidBalance for a negative balance
+    Account(balance int) {
+        if balance < 0 {
+            throw new Exception("InvalidBalance", "balance cannot be negative")
+        }
+        try {
+            throw new Exception("Unused", "caught here")
+        } catch (e) {
+            fmt.Println(e)
+        }
+    }
+}
+
+func init() {
+    limit = 20
+}
+
+func main() {
+    counter = counter + 1
+    fmt.Println(new Person(1), new Account(2))
+}
+'''
+    
+    warnings = Linter().lint(Parser(Lexer(code).tokenize()).parse())
+    assert [w.code for w in warnings] == ['GP2003', 'GP2002', 'GP2001', 'GP2005'], warnings
+    assert warnings[0] == ("constructor of Person throws InvalidAge without documenting it (mention it in the "
+                           "constructor's comment) (line 13:13)"), warnings[0]
+    assert warnings[1] == ("catch-all comes before catch (IOError), which never runs (move the catch-all last) "
+                           "(line 21:11)"), warnings[1]
+    assert warnings[2] == ("empty catch ignores every IOError (handle it, or say why in a comment) "
+                           "(line 23:11)"), warnings[2]
+    assert warnings[3] == ("package variable counter is modified by Person.Load (mutable global state: pass it "
+                           "explicitly or keep it in an object) (line 26:13)"), warnings[3]
+    
+    fields = '\n'.join(f'    f{i} int' for i in range(16))
+    warnings = Linter({'GP2004': 'error', 'GP2005': 'off'}).lint(
+        Parser(Lexer(code.replace('    age int\n', fields + '\n    age int\n')).tokenize()).parse())
+    assert warnings[0] == 'class Person has 17 fields: split its responsibilities into smaller classes (line 8:1)'
+    assert warnings[0].severity == 'error' and 'GP2005' not in [w.code for w in warnings]
+    
+    suppressed = code.replace('        } catch {', '        } catch { //goplus:nowarn GP2002')
+    warnings = Linter().lint(Parser(Lexer(suppressed).tokenize()).parse())
+    assert [w.code for w in warnings] == ['GP2003', 'GP2001', 'GP2005'], warnings
+    
+    # catch (Exception e) catches everything too
+    warnings = Linter().lint(Parser(Lexer(code.replace('        } catch {', '        } catch (Exception e) {'))
+                                    .tokenize()).parse())
+    assert warnings[1] == ("catch-all comes before catch (IOError), which never runs (move the catch-all last) "
+                           "(line 21:11)"), warnings[1]
+    
+    print("Lint OK!\n")
+    
+def test_diagnostics_format():
+    """Tests JSON and SARIF diagnostics of warnings and errors"""
+    print("=== Testing Diagnostics Format ===")
+    from errors import error_diagnostic, error_diagnostics, position
+    from gocheck import check_error
+    
+    code = '''package main
+
+import "fmt"
+
+class Person {
+    name string
+    
+    func Rename(n string) {
+        name := n
+        this.name = name
+    }
+}
+
+func main() {
+    p := new Person()
+    p.Rename("Bo")
+    fmt.Println(p.name)
+}
+'''
+    
+    transpiler = Transpiler(warning_levels={'GP1002': 'error'})
+    transpiler.transpile(Parser(Lexer(code, file='person.gox').tokenize(), 'person.gox').parse())
+    # Errors carry their diagnostics: the span of the node, token or generated line they are at, their ID and fix
+    errors = []
+    try:
+        Transpiler().transpile(Parser(Lexer(code.replace('p.name)', 'p.nmae)'), file='person.gox').tokenize(),
+                                      'person.gox').parse())
+    except TranspilerError as e:
+        errors += e.diagnostics
+    try:
+        Parser(Lexer(code.replace('class Person', 'class'), file='person.gox').tokenize(), 'person.gox').parse()
+    except ParseError as e:
+        errors += e.diagnostics
+    errors += check_error([error_diagnostic('GP0004', 'declared and not used: name',
+                                            position('person.gox', 9, 9, 9, 18),
+                                            text='person.gox:9:9: declared and not used: name (person.go:12:2)')
+                           ]).diagnostics
+    errors += error_diagnostics(ValueError("Unknown receiver name 'me'"))
+    entries = diagnostic_entries(transpiler.warnings + errors, 'person.gox')
+    assert entries[0] == {'severity': 'error', 'code': 'GP1002', 'name': 'shadowed-field',
+                          'message': 'name shadows field Person.name', 'file': 'person.gox',
+                          'range': {'start': {'line': 9, 'column': 9}, 'end': {'line': 9, 'column': 13}},
+                          'fix': 'use this.name for the field'}, entries[0]
+    assert entries[1] == {'severity': 'error', 'code': 'GP0002', 'name': 'undefined',
+                          'message': 'undefined: Person.nmae', 'file': 'person.gox',
+                          'range': {'start': {'line': 17, 'column': 19}, 'end': {'line': 17, 'column': 23}},
+                          'fix': 'did you mean name?'}, entries[1]
+    assert [(e['code'], e['file'], e['range'], e['message']) for e in entries[2:]] == [
+        ('GP0001', 'person.gox', {'start': {'line': 5, 'column': 7}, 'end': {'line': 5, 'column': 8}},
+         'Expected class name'),
+        ('GP0004', 'person.gox', {'start': {'line': 9, 'column': 9}, 'end': {'line': 9, 'column': 18}},
+         'declared and not used: name'),
+        ('GP0005', 'person.gox', None, "Unknown receiver name 'me'")], entries
+    
+    sarif = json.loads(format_diagnostics(transpiler.warnings + errors[:1], 'sarif'))
+    run = sarif['runs'][0]
+    assert sarif['version'] == '2.1.0'
+    assert run['tool']['driver']['rules'] == [{'id': 'GP0002', 'name': 'undefined',
+                                               'defaultConfiguration': {'level': 'error'}},
+                                              {'id': 'GP1002', 'name': 'shadowed-field',
+                                               'defaultConfiguration': {'level': 'warning'}}]
+    assert run['results'][0]['ruleId'] == 'GP1002' and run['results'][0]['ruleIndex'] == 1
+    assert run['results'][0]['level'] == 'error'
+    assert run['results'][0]['message']['text'] == 'name shadows field Person.name (use this.name for the field)'
+    assert run['results'][0]['locations'][0]['physicalLocation'] == {
+        'artifactLocation': {'uri': 'person.gox'},
+        'region': {'startLine': 9, 'startColumn': 9, 'endLine': 9, 'endColumn': 13}}
+    assert run['results'][1]['ruleId'] == 'GP0002' and run['results'][1]['ruleIndex'] == 0
+    assert run['results'][1]['message']['text'] == 'undefined: Person.nmae (did you mean name?)'
+    assert run['results'][1]['locations'][0]['physicalLocation']['region'] == {
+        'startLine': 17, 'startColumn': 19, 'endLine': 17, 'endColumn': 23}
+    
+    # Errors cross the processes of parallel builds with their diagnostics
+    import pickle
+    error = pickle.loads(pickle.dumps(TranspilerError(str(errors[0]), errors[0])))
+    assert str(error) == str(errors[0]) and error.diagnostics[0].fix == 'did you mean name?'
+    assert error.diagnostics[0].origin.line == 17
+    
+    print("Diagnostics format OK!\n")
+    
+def test_terminal_diagnostics():
+    """Tests warnings and errors shown with their source line and underlined span"""
+    print("=== Testing Terminal Diagnostics ===")
+    import io
+    import tempfile
+    import contextlib
+    
+    code = 'package main\n\nimport "fmt"\n\nclass Person {\n    name string\n    \n' \
+           '    func Rename(n string) {\n\t\tname := n\n        this.name = name\n    }\n}\n\n' \
+           'func main() {\n    p := new Person()\n    p.Rename("Bo")\n    fmt.Println(p.name)\n}\n'
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        (Path(scratch) / 'person.gox').write_text(code, encoding='utf-8')
+        transpiler = Transpiler(warning_levels={'GP1002': 'error'})
+        transpiler.transpile(Parser(Lexer(code, file='person.gox').tokenize(), 'person.gox').parse())
+        output = io.StringIO()
+        try:
+            with contextlib.redirect_stdout(output):
+                report_warnings(transpiler.warnings, 'person.gox', Path(scratch))
+            raise AssertionError("Expected the build to fail")
+        except TranspilerError as e:
+            assert str(e) == 'person.gox: 1 warning reported as error', e
+            failure = e
+        # The underline keeps the tabs indenting the line
+        assert output.getvalue() == ('error[GP1002]: name shadows field Person.name\n'
+                                     ' --> person.gox:9:3\n'
+                                     '  |\n'
+                                     '9 | \t\tname := n\n'
+                                     '  | \t\t^^^^\n'
+                                     '  = help: use this.name for the field\n'), output.getvalue()
+        
+        code = code.replace('p.name)', 'p.nmae)')
+        (Path(scratch) / 'person.gox').write_text(code, encoding='utf-8')
+        try:
+            Transpiler().transpile(Parser(Lexer(code, file='person.gox').tokenize(), 'person.gox').parse())
+            raise AssertionError("Expected an undefined member")
+        except TranspilerError as e:
+            entry = diagnostic_entries(e.diagnostics)[0]
+        assert render_entry(entry, Path(scratch)) == ('error[GP0002]: undefined: Person.nmae\n'
+                                                      '  --> person.gox:17:19\n'
+                                                      '   |\n'
+                                                      '17 |     fmt.Println(p.nmae)\n'
+                                                      '   |                   ^^^^\n'
+                                                      '   = help: did you mean name?'), render_entry(entry, Path(scratch))
+        colored = render_entry(entry, Path(scratch), color=True)
+        assert colored.startswith('\033[1;31merror[GP0002]\033[0m\033[1m: undefined: Person.nmae\033[0m'), colored
+        
+        # A syntax error underlines the token it is at
+        (Path(scratch) / 'bad.gox').write_text('package main\n\nclass 1234 {\n}\n', encoding='utf-8')
+        try:
+            Parser(Lexer('package main\n\nclass 1234 {\n}\n', file='bad.gox').tokenize(), 'bad.gox').parse()
+            raise AssertionError("Expected a syntax error")
+        except ParseError as e:
+            assert render_entry(diagnostic_entries(e.diagnostics)[0], Path(scratch)) == (
+                'error[GP0001]: Expected class name\n --> bad.gox:3:7\n  |\n3 | class 1234 {\n  |       ^^^^'), e
+        
+        # Without the source, or a position, only what is known is shown
+        assert render_entry(entry) == ('error[GP0002]: undefined: Person.nmae\n  --> person.gox:17:19\n'
+                                       '   = help: did you mean name?')
+        assert render_entry(diagnostic_entries(failure.diagnostics)[0]) == \
+            'error[GP0005]: 1 warning reported as error\n --> person.gox'
+    
+    print("Terminal diagnostics OK!\n")
+    
+def test_did_you_mean():
+    """Tests the names suggested for undefined identifiers, members and package members"""
+    print("=== Testing Did You Mean ===")
+    
+    code = '''package main
+
+import "fmt"
+
+class Person {
+    Name string
+    age int
+    
+    func Greet(greeting string) string {
+        return greeting + " " + this.Name
+    }
+}
+
+func main() {
+    total := 3
+    p := new Person()
+    fmt.Println(total, p.Greet("Hi"))
+}
+'''
+    
+    for old, new, expected in [
+        ('fmt.Println(total', 'fmt.Println(totl', 'undefined: totl (did you mean total?) (line 17:17)'),
+        ('greeting + " "', 'greting + " "', 'undefined: greting (did you mean greeting?) (line 10:16)'),
+        ('new Person()', 'new Preson()', 'undefined: Preson (did you mean Person?) (line 16:10)'),
+        ('fmt.Println(total', 'fmtt.Println(total', 'undefined: fmtt (did you mean fmt?) (line 17:5)'),
+        ('fmt.Println(total', 'fmt.Println(strngs.ToUpper("a"), total',
+         'undefined: strngs (did you mean strings or string?) (line 17:17)'),
+        ('p.Greet("Hi")', 'p.Gret("Hi")', 'undefined: Person.Gret (did you mean Greet?) (line 17:26)'),
+        ('p.Greet("Hi")', 'p.NAME', 'undefined: Person.NAME (did you mean Name?) (line 17:26)'),
+        ('fmt.Println(total', 'fmt.Println(quantity', 'undefined: quantity (line 17:17)'),
+    ]:
+        try:
+            Transpiler().transpile(Parser(Lexer(code.replace(old, new)).tokenize()).parse())
+            raise AssertionError(f"Expected error for {new}")
+        except TranspilerError as e:
+            assert str(e) == expected, e
+    
+    # Undefined members are all reported in one run, after the undefined names
+    try:
+        invalid = code.replace('this.Name', 'this.Nmae').replace('p.Greet("Hi")', 'p.Gret("Hi"), totl')
+        Transpiler().transpile(Parser(Lexer(invalid).tokenize()).parse())
+        raise AssertionError("Expected undefined members")
+    except TranspilerError as e:
+        assert str(e).split('\n') == ['undefined: totl (did you mean total?) (line 17:38)',
+                                      'undefined: Person.Nmae (did you mean Name?) (line 10:38)',
+                                      'undefined: Person.Gret (did you mean Greet?) (line 17:26)'], e
+        # Each one is a diagnostic of its own, the suggested names its fix
+        assert [(d.code, d.message, d.fix, d.origin.line) for d in e.diagnostics] == [
+            ('GP0002', 'undefined: totl', 'did you mean total?', 17),
+            ('GP0002', 'undefined: Person.Nmae', 'did you mean Name?', 10),
+            ('GP0002', 'undefined: Person.Gret', 'did you mean Greet?', 17)], e.diagnostics
+    
+    assert close_names('Nmae', ['Name', 'age', 'Names']) == ['Name']
+    assert close_names('x', ['y', 'xs']) == []
+    assert close_names('counter', ['count', 'counters', 'encounter', 'Counter', 'c', 'd', 'e']) == \
+        ['Counter', 'counters', 'count']
+    
+    print("Did you mean OK!\n")
+    
+def test_build_cache():
+    """Tests that unchanged files are taken from the build cache, and files depending on changed ones are not"""
+    print("=== Testing Build Cache ===")
+    import io
+    import pickle
+    import tempfile
+    import contextlib
+    from project_manager import ProjectManager
+    
+    sources = {
+        'src/shapes/square.gox': 'package shapes\n\n@stringer\nclass Square {\n    side int\n}\n',
+        'src/text/text.gox': 'package text\n\nfunc Shout(s string) string {\n    unused := 1\n    unused = 2\n'
+                             '    return s + "!"\n}\n',
+        'src/main/main.gox': 'package main\n\nimport "fmt"\nimport "shapes"\n\n'
+                             'func main() {\n    fmt.Println(shapes.NewSquare())\n}\n',
+    }
+    
+    def build(root: Path, cache: bool = True):
+        manager = ProjectManager(root, verify=False, formatter='none', cache=cache)
+        output = io.StringIO()
+        with contextlib.redirect_stdout(output):
+            manager.load_config()
+            manager.transpile_project()
+        build_dir = root / manager.config.output_dir
+        files = {p.relative_to(build_dir).as_posix(): p.read_bytes() for p in sorted(build_dir.rglob('*.go'))}
+        return output.getvalue(), files
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch) / 'app'
+        for name, source in sources.items():
+            (root / name).parent.mkdir(parents=True, exist_ok=True)
+            (root / name).write_text(source, encoding='utf-8')
+        
+        output, first = build(root)
+        assert 'Up to date' not in output
+        output, second = build(root)
+        assert second == first
+        assert output.count('Up to date: ') == 3, output
+        # Warnings of cached files are reported again
+        assert 'warning[GP1003]: unused is assigned but never read' in output, output
+        
+        # A changed package is transpiled again, with the packages that use it
+        (root / 'src/shapes/square.gox').write_text(sources['src/shapes/square.gox'] + '\n// side in cm\n',
+                                                    encoding='utf-8')
+        output, _ = build(root)
+        assert 'Transpiling src/shapes/square.gox' in output and 'Transpiling src/main/main.gox' in output
+        assert 'Up to date: src/text/text.gox' in output, output
+        
+        # Entries of the old square.gox are gone; --no-cache neither reads nor changes the cache
+        entries = sorted((root / '.goe2go-cache').glob('*.unit'))
+        assert len(entries) == 3, entries
+        output, uncached = build(root, cache=False)
+        assert 'Up to date' not in output and uncached == first
+        assert sorted((root / '.goe2go-cache').glob('*.unit')) == entries
+        
+        # Entries are JSON, read without running code: one naming a type that is no AST node, or in another
+        # format (the pickles of earlier versions), is not read and its file transpiled again
+        build(root)
+        entries = sorted((root / '.goe2go-cache').glob('*.unit'))
+        assert all(json.loads(entry.read_text(encoding='utf-8'))['version'] == 1 for entry in entries)
+        tampered = next(entry for entry in entries if '"ClassDecl"' in entry.read_text(encoding='utf-8'))
+        tampered.write_text(tampered.read_text(encoding='utf-8').replace('"ClassDecl"', '"Visitor"'),
+                            encoding='utf-8')
+        output, rebuilt = build(root)
+        assert output.count('Up to date: ') == 2 and rebuilt == uncached, output
+        entries[0].write_bytes(pickle.dumps({'go_code': 'package main\n'}))
+        output, rebuilt = build(root)
+        assert output.count('Up to date: ') == 2 and rebuilt == uncached, output
+    
+    # Every module the code making a unit imports (lexing to formatting and line realignment) is in its key
+    import ast
+    import buildcache
+    directory = Path(buildcache.__file__).parent
+    
+    def imported(module: str, seen: set) -> set:
+        path = directory / f'{module}.py'
+        if module in seen or not path.exists():
+            return seen
+        seen.add(module)
+        for node in ast.walk(ast.parse(path.read_text(encoding='utf-8'))):
+            if isinstance(node, ast.ImportFrom) and node.module:
+                imported(node.module, seen)
+            elif isinstance(node, ast.Import):
+                for alias in node.names:
+                    imported(alias.name, seen)
+        return seen
+    
+    producers = set()
+    for module in ('parser', 'transpiler', 'goformat', 'buildcache'):
+        imported(module, producers)
+    assert producers <= set(buildcache.COMPILER_MODULES), producers - set(buildcache.COMPILER_MODULES)
+    
+    print("Build cache OK!\n")
+    
+def test_parallel_build():
+    """Tests that building with worker processes (-p) gives the output, messages and errors of a sequential build"""
+    print("=== Testing Parallel Build ===")
+    import io
+    import tempfile
+    import contextlib
+    from project_manager import ProjectManager
+    
+    sources = {
+        'src/shapes/square.gox': 'package shapes\n\n@stringer\nclass Square {\n    side int\n}\n',
+        'src/shapes/circle.gox': 'package shapes\n\n@stringer\nclass Circle {\n    radius int\n}\n',
+        'src/text/text.gox': 'package text\n\nfunc Shout(s string) string {\n    unused := 1\n    unused = 2\n'
+                             '    return s + "!"\n}\n',
+        'src/main/main.gox': 'package main\n\nimport "fmt"\nimport "shapes"\nimport "text"\n\n'
+                             'func main() {\n    fmt.Println(shapes.NewSquare(), shapes.NewCircle(), '
+                             'text.Shout("hi"))\n}\n',
+    }
+    
+    def build(root: Path, jobs: int, cache: bool = False):
+        manager = ProjectManager(root, verify=False, formatter='none', cache=cache, jobs=jobs)
+        output = io.StringIO()
+        error = None
+        with contextlib.redirect_stdout(output):
+            manager.load_config()
+            try:
+                manager.transpile_project()
+            except Exception as e:
+                error = str(e)
+        build_dir = root / manager.config.output_dir
+        files = {p.relative_to(build_dir).as_posix(): p.read_bytes() for p in sorted(build_dir.rglob('*.go'))}
+        return output.getvalue(), files, error
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch) / 'app'
+        for name, source in sources.items():
+            (root / name).parent.mkdir(parents=True, exist_ok=True)
+            (root / name).write_text(source, encoding='utf-8')
+        
+        build(root, 1)  # writes go.mod, which later builds keep
+        sequential = build(root, 1)
+        assert sequential[2] is None and len(sequential[1]) == 4, sequential
+        assert build(root, 2) == sequential
+        assert build(root, 0) == sequential
+        
+        # Cached packages are only restored by the workers
+        build(root, 1, cache=True)
+        (root / 'src/text/text.gox').write_text(sources['src/text/text.gox'] + '\n// loud\n', encoding='utf-8')
+        output, files, error = build(root, 2, cache=True)
+        assert 'Up to date: src/shapes/square.gox' in output and 'Transpiling src/text/text.gox' in output, output
+        assert files == sequential[1] and error is None
+        
+        # An error stops both builds at the same file, with the same message
+        (root / 'src/text/text.gox').write_text(sources['src/text/text.gox'].replace('s + "!"', 'ss + "!"'),
+                                                encoding='utf-8')
+        output, _, error = build(root, 2)
+        assert error and 'ss' in error and (output, error) == build(root, 1)[::2], (output, error)
+    
+    print("Parallel build OK!\n")
+    
+def test_streaming_lexer():
+    """Tests that lexing a file as its tokens are consumed, across chunk boundaries, matches lexing its text"""
+    print("=== Testing Streaming Lexer ===")
+    import io
+    from lexer import SourceReader
+    
+    code = '''package main
+
+#if linux
+// Greeting is "${name}"-free
+const greeting = "hi ${"there"}"
+#else
+const greeting = `bye`
+#endif
+
+func main() {
+    s := """
+        multi
+        line
+        """
+    /* block
+       comment */
+    x := 0x_ff + 1_000u16
+    println(greeting, s, x, 'c')
+}
+'''
+    expected = Lexer(code, {'linux'}).tokenize()
+    chunk = SourceReader.CHUNK
+    try:
+        for size in (1, 2, 5, 64):
+            SourceReader.CHUNK = size
+            streamed = list(Lexer(io.StringIO(code), {'linux'}).stream())
+            assert streamed == expected, size
+            assert [(t.end_line, t.end_column) for t in streamed] == [(t.end_line, t.end_column) for t in expected]
+            program = Parser(Lexer(io.StringIO(code), {'linux'}).stream()).parse()
+            assert [d.name for d in program.declarations] == ['greeting', 'main']
+    finally:
+        SourceReader.CHUNK = chunk
+    
+    # Names and operators are shared between tokens; errors are raised where the stream reaches them
+    a, b = [t for t in expected if t.value == 'greeting']
+    assert a.value is b.value
+    tokens = Lexer(io.StringIO('package main\n#if linux\nx := 1\n'), {'linux'}, 'm.gox').stream()
+    try:
+        list(tokens)
+        assert False, "unterminated #if"
+    except LexerError as e:
+        assert str(e) == 'm.gox: Unterminated #if at line 2', e
+    
+    print("Streaming lexer OK!\n")
+    
+def test_parser_memory():
+    """Tests that the parser releases the tokens it is past, going back only over those it holds, so parsing a
+    stream holds a bounded part of its tokens"""
+    print("=== Testing Parser Memory ===")
+    import io
+    import fuzz
+    import tracemalloc
+    from parser import TokenBuffer
+    from astdump import dump_json
+    
+    # Releasing after every token parses the corpus (lookaheads, recovered errors, comments, nowarn lines) as the
+    # whole token list does
+    chunk = TokenBuffer.CHUNK
+    try:
+        TokenBuffer.CHUNK = 1
+        for source in fuzz.load_corpus(Path(__file__).parent):
+            try:
+                expected = Parser(Lexer(source).tokenize()).parse()
+            except (LexerError, ParseError) as e:
+                try:
+                    Parser(Lexer(source).stream()).parse()
+                    raise AssertionError(f"Expected {e}")
+                except (LexerError, ParseError) as streamed:
+                    assert str(streamed) == str(e), (streamed, e)
+                continue
+            parser = Parser(Lexer(source).stream())
+            program = parser.parse()
+            assert dump_json(program) == dump_json(expected) and program.nowarn == expected.nowarn
+            assert len(parser.tokens.window) <= 2 and not parser.tokens.holds and not parser.tokens.keeps
+    finally:
+        TokenBuffer.CHUNK = chunk
+    
+    # Beyond the AST, parsing a stream allocates a small part of what its tokens take
+    source = 'package main\n\n' + ''.join(
+        f'// f{i} scales a\nfunc f{i}(a int, b string) int {{\n    x := a * {i} + len(b)\n'
+        f'    for k, v := range b {{\n        x += k + int(v)\n    }}\n    return x\n}}\n\n' for i in range(1000))
+    tracemalloc.start()
+    try:
+        tokens = Lexer(source).tokenize()
+        size = tracemalloc.get_traced_memory()[0]
+        del tokens
+        tracemalloc.reset_peak()
+        program = Parser(Lexer(io.StringIO(source)).stream()).parse()
+        current, peak = tracemalloc.get_traced_memory()
+    finally:
+        tracemalloc.stop()
+    assert len(program.declarations) == 1000
+    assert peak - current < size / 10, (peak - current, size)
+    
+    print("Parser memory OK!\n")
+    
+def test_node_memory():
+    """Tests that AST nodes and tokens hold no __dict__, share their empty comments and are freed without the
+    cycle collector"""
+    print("=== Testing Node Memory ===")
+    import gc
+    from ast_nodes import ASTNode, node_fields, field_values
+    
+    code = '''package main
+
+// Point of the plane
+class Point {
+    x int
+    y int
+}
+
+func main() {
+    p := new Point()
+    println(p.x + 1)
+}
+'''
+    tokens = Lexer(code).tokenize()
+    assert not hasattr(tokens[0], '__dict__')
+    program = Parser(tokens).parse()
+    point, main = program.declarations
+    assert not hasattr(program, '__dict__') and not hasattr(point, '__dict__')
+    assert point.comments == ['// Point of the plane']
+    call = main.body.statements[1].expression
+    assert call.comments == () and call.comments is main.body.statements[1].expression.args[0].comments
+    assert [name for name, _ in node_fields(point)][:3] == ['line', 'column', 'file']
+    assert point.name in list(field_values(point))
+    
+    # Flags the transpiler sets on nodes are fields too
+    Transpiler().transpile(program)
+    assert program.nested_hoisted
+    
+    # Trees hold no cycles: dropping the AST frees every node at once
+    gc.collect()
+    gc.disable()
+    try:
+        del tokens, program, point, main, call
+        assert not any(isinstance(o, ASTNode) for o in gc.get_objects())
+    finally:
+        gc.enable()
+    
+    print("Node memory OK!\n")
+    
+def test_released_bodies():
+    """Tests that a project build frees the function and method bodies of each file once it is transpiled, without
+    changing what the files after it generate and report, and that its peak memory drops"""
+    print("=== Testing Released Bodies ===")
+    import io
+    import tempfile
+    import contextlib
+    import tracemalloc
+    from project_manager import ProjectManager, ProjectTranspiler
+    
+    # b.gox only uses what the bodies of a.gox, transpiled first, use of it: the 'is' test, the method, the class
+    # and its field, which release mode would otherwise leave out
+    sources = {
+        'src/shapes/a.gox': '''package shapes
+
+class Square {
+    side int
+    
+    func GetSide() int {
+        return this.side
+    }
+}
+
+func Describe(s any) string {
+    if s is Circle {
+        t := new tracker()
+        t.count = 1
+        return "circle"
+    }
+    c := new Circle()
+    c.scale()
+    return "other"
+}
+''',
+        'src/shapes/b.gox': '''package shapes
+
+class Circle {
+    radius int
+    
+    func scale() {
+        this.radius = this.radius * 2
+    }
+}
+
+class tracker {
+    count int
+}
+
+func Side(sq *Square) int {
+    return sq.GetSide() + 1
+}
+''',
+    }
+    
+    @contextlib.contextmanager
+    def bodies_kept():
+        """Builds keeping the bodies, as before they were released"""
+        release_bodies = Transpiler.release_bodies
+        Transpiler.release_bodies = lambda transpiler, program: None
+        try:
+            yield
+        finally:
+            Transpiler.release_bodies = release_bodies
+    
+    def build(root: Path):
+        manager = ProjectManager(root, verify=False, formatter='none', cache=False, release=True)
+        manager.load_config()
+        manager.config.inline_accessors = True
+        with contextlib.redirect_stdout(io.StringIO()):
+            manager.transpile_project()
+        build_dir = root / manager.config.output_dir
+        files = {p.relative_to(build_dir).as_posix(): p.read_text() for p in sorted(build_dir.rglob('*.go'))}
+        return files, [str(d) for d in manager.diagnostics]
+    
+    def transpile(root: Path):
+        """Memory held once the files of a project are transpiled, and its peak while they are (parsing every file
+        first peaks on its own)"""
+        manager = ProjectManager(root, verify=False, formatter='none', cache=False)
+        manager.load_config()
+        tracemalloc.start()
+        try:
+            with contextlib.redirect_stdout(io.StringIO()):
+                manager.discover_files()
+                tracemalloc.reset_peak()
+                project_transpiler = ProjectTranspiler(manager, False)
+                for file_path in manager.get_transpilation_order():
+                    project_transpiler.transpile_file(manager.files[file_path], file_path)
+            return tracemalloc.get_traced_memory()
+        finally:
+            tracemalloc.stop()
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch) / 'app'
+        for name, source in sources.items():
+            (root / name).parent.mkdir(parents=True, exist_ok=True)
+            (root / name).write_text(source, encoding='utf-8')
+        files, diagnostics = build(root)
+        with bodies_kept():
+            assert build(root) == (files, diagnostics)
+        circle = files['src/shapes/b.go']
+        assert 'func isCircle(' in circle and 'func (this *Circle) scale()' in circle, circle
+        assert 'type tracker struct' in circle and 'return sq.side + 1' in circle, circle
+        assert diagnostics == [], diagnostics
+    
+    # Bodies are most of what the ASTs hold: files of growing size peak with the last, once the bodies of the
+    # others are released
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch) / 'app'
+        (root / 'src/numbers').mkdir(parents=True)
+        for i in range(4):
+            functions = ''.join(f'''
+func f{i}_{j}(n int) int {{
+    total := 0
+    for k := 0; k < n; k++ {{
+        if k % 3 == 0 {{
+            total += k * {j}
+        }} else {{
+            total -= k
+        }}
+    }}
+    return total
+}}
+''' for j in range(4 * (i + 1)))
+            (root / f'src/numbers/n{i}.gox').write_text(f'''package numbers
+
+class Counter{i} {{
+    count int
+    
+    func Add(n int) {{
+        this.count += f{i}_0(n)
+    }}
+}}
+{functions}''', encoding='utf-8')
+        transpile(root)  # modules and caches loaded by the first build are not counted
+        with bodies_kept():
+            kept = transpile(root)
+        released = transpile(root)
+        assert released[0] < kept[0] / 2 and released[1] < kept[1] * 0.9, (released, kept)
+    
+    print("Released bodies OK!\n")
+    
+def test_fuzz():
+    """Tests that the front end fails cleanly on the inputs the fuzzer found crashes with, and on a short run"""
+    print("=== Testing Fuzzer ===")
+    import fuzz
+    
+    # A token other than case or default in a switch looped forever
+    try:
+        Parser(Lexer('package main\nfunc f(c int) {\n    switch c {\n    caseRed:\n    }\n}\n').tokenize()).parse()
+        assert False, "statement outside of a case"
+    except ParseError as e:
+        assert "Expected 'case' or 'default' in switch at line 4" in str(e), e
+    
+    # Nesting deeper than the Python stack overflowed it
+    try:
+        Parser(Lexer('package main\nvar x = ' + '(' * 5000 + '1' + ')' * 5000 + '\n', file='deep.gox').tokenize(),
+               'deep.gox').parse()
+        assert False, "deep nesting"
+    except ParseError as e:
+        assert str(e).startswith('deep.gox: Code nested too deeply at line 2, column '), e
+    
+    crashes = fuzz.fuzz(300, 0, fuzz.load_corpus(Path(__file__).parent))
+    assert not crashes, crashes[0][1]
+    
+    print("Fuzzer OK!\n")
+    
+def test_golden(update=False):
+    """Tests the Go generated for the fixtures of testdata/golden against the .go files checked in beside them, so
+    a change to the emitted code shows up as a diff; with update (--update), the .go files are rewritten instead"""
+    print("=== Testing Golden Files ===")
+    import difflib
+    root = Path(__file__).parent
+    
+    failures = []
+    fixtures = sorted((root / 'testdata' / 'golden').glob('*.gox'))
+    assert fixtures, "no golden fixtures"
+    for fixture in fixtures:
+        name = fixture.relative_to(root).as_posix()
+        with open(fixture, 'r', encoding='utf-8') as f:
+            go_code = Transpiler().transpile(Parser(Lexer(f, file=name).stream(), name).parse())
+        golden = fixture.with_suffix('.go')
+        if update:
+            golden.write_text(go_code, encoding='utf-8')
+            print(f"Updated {golden.relative_to(root).as_posix()}")
+            continue
+        expected = golden.read_text(encoding='utf-8') if golden.exists() else ''
+        if go_code != expected:
+            failures.append(''.join(difflib.unified_diff(expected.splitlines(True), go_code.splitlines(True),
+                                                         golden.relative_to(root).as_posix(), name)))
+    
+    assert not failures, '\n'.join(failures) + "\nGenerated Go differs from the golden files; if the change is " \
+                                              "intended, run python3 test_transpiler.py --update"
+    print(f"Golden files OK! ({len(fixtures)} fixtures)\n")
+    
+def test_round_trip():
+    """Tests the pretty-printer by property: printing a parsed file and parsing the print gives the same AST (and
+    the same comments and suppressions), and printing it again gives the same source. Checked on every construct
+    of the grammar, the examples, the test programs, the golden fixtures and mutations of them that still parse"""
+    print("=== Testing Round Trip ===")
+    import random
+    import fuzz
+    from ast_nodes import ASTNode, field_values
+    from printer import PrintError, print_program
+    root = Path(__file__).parent
+    
+    grammar = '''// Header comment
+package main
+
+import (
+    "fmt"
+    str "strings"
+)
+
+const Max = 10
+var cache map[string]Stack<int> = map[string]Stack<int>{}
+
+type Number = int | float64
+type ID = string
+
+struct Point {
+    x int // trailing
+    y int
+}
+
+interface Container<T> {
+    ~int | float64
+    Get(k string) (T, bool)
+    All() yield int, T
+}
+
+annotation route(method, path string)
+
+@route("GET", "/box")
+class Box<T any, K comparable> extends Base with Auditable implements Sized {
+    const Limit = 5
+    // the name
+    name string = "box" get set @json("name")
+    boss Person? get
+    event OnChange(before, after int)
+    override Audit from Auditable
+    static {
+        names = append(names, "Box")
+    }
+    Box(name string, xs ...int) {
+        super.Base(name)
+    }
+    ~Box() {
+        fmt.Println("bye")
+    }
+    implicit operator convert(c *Celsius) *Box {
+        return nil
+    }
+    //goplus:nowarn GP1004
+    @deprecated
+    func Get<U>(k K) (T, bool) {
+        return this.items[k]
+    }
+    operator -() *Box {
+        return this
+    }
+    operator []=(k K, v T) {
+        this.items[k] = v
+    }
+    func Iter() yield K, T {
+        for k, v := range this.items {
+            yield k, v
+        }
+    }
+    inner class Cursor {
+        i int
+    }
+}
+
+data class P(x, y float64) implements Shape {
+    func Area() float64 {
+        return this.x * this.y
+    }
+}
+
+record R<T>(v T)
+
+enum Planet(mass float64) {
+    Mercury(3.3e23), Earth(5.97e24)
+    func Heavy() bool {
+        return this.mass > 1e24
+    }
+}
+
+object Config {
+    debug bool = false
+}
+
+func main() {
+    x := -(-1)
+    s := "tab\\there \\"q\\" $x \\${y} ${x > 0 ? "a" : "b"} ${total:.2f}"
+    r := """
+        has ` backtick
+        """
+    f := 0x1F + 1_000 + 1.5f32 + 255u8 + (1).String() + '\\''
+    m := (x is Student) && (y as? Student) != nil || a ?? b ?? c
+    k := (a ? b : c) ? (p -> p * 2) : (a - (b - c)) * c
+    lam := (a int, b string) -> {
+        return a
+    }
+    comp := {k: v * 2 for k, v in m if v > 1}
+    area := match shape {
+        Circle c if c.r > 10 -> "big"
+        Rect(w, 0), Point(_, y) -> {
+            return "flat"
+        }
+        _ -> 0.0
+    }
+    level := switch n {
+        case 1, 2 -> 10.0
+        default -> 0.0
+    }
+    anon := new Handler<int>(xs...) {
+        count int
+        func OnClick() {
+            this.count++
+        }
+    }
+    older := p with { age: 30 }
+    name := p?.boss?.name
+    grid := [][]int{{1}, {2, 3}}
+
+    if x > 0
+        x++
+    else if x < 0 {
+        x--
+    }
+outer:
+    for i := 0; i < 3; i++ {
+        break outer
+    }
+    for v in xs {}
+    repeat {
+        x++
+    } until (x == 10)
+    switch x {
+    case 1, 2:
+        fmt.Println("a")
+    default:
+    }
+    select {
+    case v := <-ch:
+        fmt.Println(v)
+    case ch <- 1:
+    }
+    try {
+        risky()
+    } catch (IOError e) {
+        throw e
+    } catch {
+        // ignored
+    } finally {
+        cleanup()
+    }
+    using (fh := open("x")) {
+        fh.Read()
+    }
+    // end of main
+}
+'''
+    
+    def parse(source):
+        return Parser(Lexer(source, set(), 'round_trip.gox').stream(), 'round_trip.gox').parse()
+    
+    def comments(node):
+        if isinstance(node, (list, tuple)):
+            return [comment for item in node for comment in comments(item)]
+        if not isinstance(node, ASTNode):
+            return []
+        found = [*node.comments, *getattr(node, 'end_comments', [])]
+        if node.trailing_comment:
+            found.append(node.trailing_comment)
+        return found + comments(list(field_values(node)))
+    
+    def round_trip(source):
+        """Checks a source that parses; returns its print"""
+        program = parse(source)
+        printed = print_program(program)
+        reparsed = parse(printed)
+        # Class members are printed grouped by kind, so comments and suppressions may come in another order
+        assert sorted(comments(reparsed)) == sorted(comments(program)), printed
+        assert sorted(reparsed.nowarn.values()) == sorted(program.nowarn.values()), printed
+        program.nowarn = reparsed.nowarn
+        assert reparsed == program, f"{source}\n---- printed as ----\n{printed}"
+        assert print_program(reparsed) == printed, printed
+        return printed
+    
+    printed = round_trip(grammar)
+    for spelling in ('// Header comment\npackage main\n', 'var cache map[string]Stack<int> = map[string]Stack<int>{}',
+                     '    All() yield int, T\n', '    x int // trailing\n', '    Mercury(3.3e23),\n',
+                     '    x := -(-1)\n', "    f := 0x1f + 1000 + 1.5f32 + 255u8 + (1).String() + '\\''\n",
+                     '    s := "tab\\there \\"q\\" $x \\${y} ${x > 0 ? "a" : "b"} ${total:.2f}"\n',
+                     '    //goplus:nowarn GP1004\n    @deprecated\n    func Get<U>(k K) (T, bool) {\n',
+                     '    r := """\n        has ` backtick\n        """\n',
+                     '    grid := [][]int{{1}, {2, 3}}\n\n    if x > 0\n        x++\n    else if x < 0 {\n',
+                     '    } catch {\n        // ignored\n    } finally {\n'):
+        assert spelling in printed, f"{spelling!r} not in\n{printed}"
+    
+    corpus = fuzz.load_corpus(root) + [path.read_text(encoding='utf-8')
+                                       for path in sorted((root / 'testdata' / 'golden').glob('*.gox'))]
+    checked = 0
+    for source in corpus:
+        try:
+            parse(source)
+        except (LexerError, ParseError):
+            continue  # programs of the tests of syntax errors
+        round_trip(source)
+        checked += 1
+    
+    rng = random.Random(0)
+    mutations = 0
+    for _ in range(1500):
+        source = fuzz.mutate(rng.choice(corpus), rng)
+        try:
+            parse(source)
+        except (LexerError, ParseError):
+            continue
+        try:
+            round_trip(source)
+        except PrintError as e:
+            assert 'nested too deeply' in str(e), e
+        mutations += 1
+    
+    print(f"Round trip OK! ({checked} sources, {mutations} mutations)\n")
+    
+def test_exception_dispatch():
+    """Tests catch dispatch: catch (Exception e) takes any exception, one that no catch matches propagates to the
+    enclosing try, and super.Speak() calls the base class method rather than the override"""
+    print("=== Testing Exception Dispatch ===")
+    import tempfile
+    import subprocess
+    from gocheck import go_available
+    
+    code = '''package main
+
+import "fmt"
+
+class Animal {
+    func Speak() string {
+        return "..."
+    }
+}
+
+class Dog extends Animal {
+    func Speak() string {
+        return "Woof and " + super.Speak()
+    }
+}
+
+func typed() {
+    try {
+        throw new Exception("IOError", "disk")
+    } catch (ParseError e) {
+        fmt.Println("parse", e.Error())
+    }
+}
+
+func main() {
+    try {
+        typed()
+    } catch (IOError e) {
+        fmt.Println("io", e.Error())
+    } catch (Exception e) {
+        fmt.Println("other")
+    }
+    fmt.Println(new Dog().Speak())
+}
+'''
+    go_code = Transpiler().transpile(Parser(Lexer(code).tokenize()).parse())
+    typed = go_code[go_code.index('func typed()'):go_code.index('func main()')]
+    main = go_code[go_code.index('func main()'):]
+    # Only typed catches: the exception goes on when none matches
+    assert 'ex.Type() == "ParseError"' in typed and 'panic(r)' in typed, typed
+    # catch (Exception e) is the catch-all: no type test, no re-panic
+    assert 'ex.Type() == "IOError"' in main and 'ex.Type() == "Exception"' not in main, main
+    assert 'panic(r)' not in main, main
+    assert '"Woof and " + this.Animal.Speak()' in go_code, go_code
+    
+    if not go_available():
+        print("Skipped running: go not found\n")
+        return
+    with tempfile.TemporaryDirectory() as work:
+        (Path(work) / 'main.go').write_text(go_code, encoding='utf-8')
+        run = subprocess.run(['go', 'run', 'main.go'], cwd=work, capture_output=True, text=True)
+    assert run.returncode == 0 and run.stdout == 'io disk\nWoof and ...\n', (run.stdout, run.stderr)
+    
+    print("Exception dispatch OK!\n")
+    
+def test_conformance():
+    """Tests the semantics of the generated programs: each case of testdata/conformance is transpiled, built and
+    run, and must print its .out file and exit with its status. Skipped without the Go toolchain"""
+    print("=== Testing Conformance ===")
+    import conformance
+    from gocheck import go_available
+    
+    cases = conformance.load_cases()
+    assert {'exception_dispatch', 'finally_order', 'constructors', 'inheritance_dispatch'} <= \
+        {case.name for case in cases}, cases
+    if not go_available():
+        print(f"Skipped: go not found ({len(cases)} cases)\n")
+        return
+    
+    failures = conformance.run(cases)
+    assert not failures, '\n'.join(failures)
+    
+    # A wrong output or exit status is reported, with a diff
+    case = next(case for case in cases if case.name == 'uncaught_exception')
+    assert case.status == 2, case
+    failures = conformance.run([case._replace(output=case.output.replace('outer', 'other'), status=0)])
+    assert len(failures) == 1 and failures[0].startswith('uncaught_exception: ---'), failures
+    assert '-other finally\n+outer finally' in failures[0] and 'exit status 2, expected 0' in failures[0], failures
+    print(f"Conformance OK! ({len(cases)} cases)\n")
+    
+def test_ast_api():
+    """Tests the traversal API for tools: children, walk, inspect, find and node positions, on every example and
+    test program"""
+    print("=== Testing AST API ===")
+    import fuzz
+    from ast_nodes import (ASTNode, ClassDecl, FuncDecl, MethodDecl, Position, Visitor, children, end_position,
+                           field_values, find, inspect, position, walk)
+    
+    code = '''package main
+
+import "fmt"
+
+class Greeter {
+    name string
+    
+    func Greet() {
+        fmt.Println("hi", this.name)
+    }
+}
+
+func main() {
+    g := new Greeter()
+    g.Greet()
+}
+'''
+    program = Parser(Lexer(code, set(), 'greet.gox').stream(), 'greet.gox').parse()
+    greeter, main = program.declarations
+    assert list(children(program)) == [program.imports[0], greeter, main]
+    assert str(position(greeter)) == 'greet.gox:5:1' and position(greeter) == Position('greet.gox', 5, 1)
+    assert end_position(greeter).line == 11, end_position(greeter)
+    
+    class Tracer(Visitor):
+        """Records the node types entered and left, and skips method bodies"""
+        def __init__(self, events):
+            self.events = events
+        
+        def visit(self, node):
+            if node is None:
+                self.events.append('end')
+                return None
+            self.events.append(type(node).__name__)
+            return None if isinstance(node, MethodDecl) else Tracer(self.events)
+    
+    events = []
+    walk(Tracer(events), greeter)
+    assert events == ['ClassDecl', 'ClassField', 'end', 'MethodDecl', 'end'], events
+    
+    names = []
+    def outside_functions(node):
+        if node is not None and not isinstance(node, FuncDecl):
+            names.append(type(node).__name__)
+        return not isinstance(node, FuncDecl)
+    
+    inspect(program, outside_functions)
+    assert names == ['Program', 'ImportDecl', 'ClassDecl', 'ClassField', 'MethodDecl', 'BlockStmt', 'ExpressionStmt',
+                     'CallExpr', 'SelectorExpr', 'Identifier', 'Literal', 'SelectorExpr', 'ThisExpr'], names
+    assert find(program, lambda node: isinstance(node, FuncDecl)) is main
+    assert find(greeter, lambda node: isinstance(node, FuncDecl)) is None
+    
+    def every_node(value):
+        """All the nodes of any field, by brute force"""
+        if isinstance(value, ASTNode):
+            return [value] + every_node(list(field_values(value)))
+        if isinstance(value, (list, tuple)):
+            return [node for item in value for node in every_node(item)]
+        if isinstance(value, dict):
+            return every_node(list(value.values()))
+        return []
+    
+    checked = 0
+    for source in fuzz.load_corpus(Path(__file__).parent):
+        try:
+            program = Parser(Lexer(source, set(), 'api.gox').stream(), 'api.gox').parse()
+        except (LexerError, ParseError):
+            continue
+        visited = []
+        inspect(program, lambda node: node is None or visited.append(node) is None)
+        assert list(map(id, visited)) == list(map(id, every_node(program))), source
+        checked += 1
+    
+    print(f"AST API OK! ({checked} sources)\n")
+    
+def test_plugins():
+    """Tests code generation plugins: members added to annotated classes, method bodies rewritten before emission,
+    their errors, and plugins of a project, whose changes invalidate the build cache"""
+    print("=== Testing Plugins ===")
+    import io
+    import tempfile
+    import contextlib
+    from project_manager import ProjectManager
+    from plugins import Plugin, PluginError, load_plugins
+    from ast_nodes import ClassDecl, RawStmt
+    
+    class Orm(Plugin):
+        """Table mappings of the classes annotated @table("name")"""
+        class_annotations = {'table'}
+        
+        def expand_class(self, decl, context):
+            table = context.annotation(decl, 'table')
+            if not table:
+                return
+            if len(table.args) != 1:
+                raise PluginError("@table takes the name of the table", table)
+            columns = ', '.join(f'"{f.name}"' for f in decl.fields)
+            context.add_method(decl, 'TableName', [], 'string', f'return {context.go_expression(table.args[0])}')
+            context.add_method(decl, 'Columns', [], '[]string', f'return []string{{{columns}}}')
+    
+    class Metrics(Plugin):
+        """Timing of the methods annotated @timed"""
+        method_annotations = {'timed'}
+        
+        def transform(self, program, context):
+            for decl in program.declarations:
+                if not isinstance(decl, ClassDecl):
+                    continue
+                for method in decl.methods:
+                    if context.annotation(method, 'timed'):
+                        method.body.statements.insert(0, RawStmt(
+                            f'defer func(start time.Time) {{ observe("{decl.name}.{method.name}", '
+                            f'time.Since(start)) }}(time.Now())', ['time']))
+    
+    code = '''package main
+
+import "fmt"
+
+@table("users")
+class User {
+    id int
+    name string
+    
+    @timed
+    func Save() {
+        fmt.Println("saving", this.id, this.name)
+    }
+}
+
+func observe(name string, elapsed any) {
+    fmt.Println("observed", name)
+}
+
+func main() {
+    u := new User()
+    fmt.Println(u.TableName(), u.Columns())
+    u.Save()
+}
+'''
+    
+    go_code = Transpiler(plugins=[Orm(), Metrics()]).transpile(Parser(Lexer(code).tokenize()).parse())
+    assert 'func (this *User) TableName() string {\n    return "users"\n}' in go_code, go_code
+    assert 'func (this *User) Columns() []string {\n    return []string{"id", "name"}\n}' in go_code, go_code
+    assert '    defer func(start time.Time) { observe("User.Save", time.Since(start)) }(time.Now())\n' \
+           '    fmt.Println("saving", this.id, this.name)' in go_code, go_code
+    assert '"time"' in go_code
+    
+    # Without the plugin its annotations are unknown; the plugin's own errors point at the source
+    for plugins, source, error in (([], code, "Unknown annotation @table on class User (line 5:1)"),
+                                   ([Orm()], code, "Unknown annotation @timed on method User.Save (line 10:5)"),
+                                   ([Orm(), Metrics()], code.replace('@table("users")', '@table()'),
+                                    "@table takes the name of the table (line 5:1)")):
+        try:
+            Transpiler(plugins=plugins).transpile(Parser(Lexer(source).tokenize()).parse())
+            raise AssertionError(f"Expected {error!r}")
+        except TranspilerError as e:
+            assert str(e) == error, e
+    
+    plugin_source = '''from plugins import Plugin
+
+class Version(Plugin):
+    def expand_class(self, decl, context):
+        context.add_method(decl, 'Version', [], 'int', 'return 1')
+
+plugin = Version()
+'''
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch) / 'app'
+        (root / 'src' / 'main').mkdir(parents=True)
+        (root / 'src' / 'main' / 'main.gox').write_text(
+            'package main\n\nimport "fmt"\n\nclass Box {\n}\n\nfunc main() {\n    fmt.Println(new Box().Version())\n}\n',
+            encoding='utf-8')
+        (root / 'version_plugin.py').write_text(plugin_source, encoding='utf-8')
+        (root / 'goe2go.json').write_text(json.dumps({'name': 'app', 'go_mod_name': 'example.com/app',
+                                                      'plugins': ['version_plugin.py']}), encoding='utf-8')
+        
+        def build():
+            manager = ProjectManager(root, verify=False, formatter='none')
+            output = io.StringIO()
+            with contextlib.redirect_stdout(output):
+                manager.load_config()
+                manager.transpile_project()
+            return output.getvalue(), (root / 'build' / 'src' / 'main' / 'main.go').read_text(encoding='utf-8')
+        
+        output, go_code = build()
+        assert 'func (this *Box) Version() int {\n    return 1\n}' in go_code, go_code
+        assert 'Up to date: src/main/main.gox' in build()[0]
+        # A changed plugin invalidates the Go cached from it
+        (root / 'version_plugin.py').write_text(plugin_source.replace('return 1', 'return 2'), encoding='utf-8')
+        output, go_code = build()
+        assert 'Up to date' not in output and 'return 2' in go_code, output
+        
+        try:
+            load_plugins(['missing.py'], root)
+            raise AssertionError("Expected a missing plugin to be reported")
+        except PluginError as e:
+            assert str(e) == "Plugin missing.py not found", e
+    
+    print("Plugins OK!\n")
+    
+def test_format():
+    """Tests goe2go fmt: the canonical layout of a file (imports sorted and grouped, build constraint kept), its
+    idempotence, the files it refuses, and the -l, -w and -d flags"""
+    print("=== Testing Format ===")
+    import io
+    import argparse
+    import tempfile
+    import contextlib
+    import fuzz
+    import goe2go
+    from printer import PrintError, format_source
+    
+    messy = '''//goplus:build linux && !debug
+// Tool entry point
+package main
+import (
+  "github.com/acme/log"
+  "strings"
+  f "fmt"
+)
+func main()  {
+  if true { f.Println(strings.ToUpper("x")) }   // shout
+  log.Info("done")
+}
+'''
+    canonical = '''//goplus:build linux && !debug
+
+// Tool entry point
+package main
+
+import (
+    f "fmt"
+    "strings"
+
+    "github.com/acme/log"
+)
+
+func main() {
+    if true {
+        f.Println(strings.ToUpper("x"))
+    } // shout
+    log.Info("done")
+}
+'''
+    assert format_source(messy) == canonical, format_source(messy)
+    assert format_source(canonical) == canonical
+    
+    checked = 0
+    for source in fuzz.load_corpus(Path(__file__).parent):
+        try:
+            formatted = format_source(source)
+        except (LexerError, ParseError):
+            continue
+        except PrintError as e:
+            assert '#if blocks must hold whole declarations or statements' in str(e), e
+            continue
+        assert format_source(formatted) == formatted, formatted
+        checked += 1
+    
+    # #if blocks keep all their branches, their directives indented as the code they are in
+    conditional = '''package main
+#if debug
+const Level = 1
+#else
+const Level = 0
+#endif
+func main() {
+#if linux
+  println("linux")
+    #elif windows
+  println("windows")
+#endif
+  println(Level)
+}
+#if trace
+func trace() {}
+#endif
+'''
+    expected = '''package main
+
+#if debug
+const Level = 1
+#else
+const Level = 0
+#endif
+
+func main() {
+    #if linux
+    println("linux")
+    #elif windows
+    println("windows")
+    #endif
+    println(Level)
+}
+
+#if trace
+func trace() {}
+#endif
+'''
+    assert format_source(conditional) == expected, format_source(conditional)
+    assert format_source(expected) == expected
+    
+    # What the AST does not hold is refused rather than lost
+    for source, error in (('package main\n\nfunc f(\n#if debug\n    level int,\n#endif\n) {}\n',
+                           "#if blocks must hold whole declarations or statements to be formatted (line 4:1)"),
+                          ('package main\n\nfunc main() {\n    println(1, /* one */ 2)\n}\n',
+                           "Formatting would drop this comment, which is inside an expression or an import list "
+                           "(line 4:16)")):
+        try:
+            format_source(source)
+            raise AssertionError(f"Expected {error!r}")
+        except PrintError as e:
+            assert str(e) == error, e
+    
+    def fmt(*argv):
+        parser = argparse.ArgumentParser()
+        parser.add_argument('paths', nargs='*')
+        for flag in ('list', 'write', 'diff'):
+            parser.add_argument(f'-{flag[0]}', f'--{flag}', action='store_true')
+        output = io.StringIO()
+        with contextlib.redirect_stdout(output), contextlib.redirect_stderr(output):
+            try:
+                goe2go.cmd_fmt(parser.parse_args(argv))
+            except SystemExit as e:
+                return output.getvalue(), e.code
+        return output.getvalue(), 0
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch)
+        (root / 'src').mkdir()
+        (root / 'src' / 'messy.gox').write_text(messy, encoding='utf-8')
+        (root / 'src' / 'tidy.gox').write_text(canonical, encoding='utf-8')
+        (root / 'broken.gox').write_text('package main\n\nfunc (\n', encoding='utf-8')
+        
+        assert fmt(str(root / 'src' / 'messy.gox')) == (canonical, 0)
+        output, status = fmt('-l', '-d', str(root / 'src'))
+        assert status == 0 and output.startswith(f"{root / 'src' / 'messy.gox'}\n--- {root / 'src' / 'messy.gox'}.orig\n")
+        assert '\n-  if true { f.Println(strings.ToUpper("x")) }   // shout\n' in output, output
+        assert '\n+    } // shout\n' in output, output
+        assert 'tidy.gox' not in output
+        
+        assert fmt('-w', str(root / 'src')) == ('', 0)
+        assert (root / 'src' / 'messy.gox').read_text(encoding='utf-8') == canonical
+        assert fmt('-l', str(root / 'src')) == ('', 0)
+        
+        output, status = fmt('-l', str(root / 'broken.gox'), str(root / 'src'))
+        assert status == 1 and 'Expected function name' in output, output
+    
+    print(f"Format OK! ({checked} sources)\n")
+    
+def test_ast_dump():
+    """Tests goe2go ast: the tree and JSON dumps of a parsed AST, and of the AST expanded for code generation"""
+    print("=== Testing AST Dump ===")
+    import io
+    import json
+    import argparse
+    import tempfile
+    import contextlib
+    import fuzz
+    import goe2go
+    from astdump import dump_json, dump_tree
+    
+    code = '''package main
+
+data class Point(x, y int)
+
+func main() {
+    p := Point(1, 2)
+    println(p.x, "é")
+}
+'''
+    program = Parser(Lexer(code, set(), 'p.gox').stream(), 'p.gox').parse()
+    tree = dump_tree(program)
+    assert tree.startswith('Program\n  package: "main"\n  declarations:\n    [0] ClassDecl (3:1)\n'), tree
+    assert '\n          [0] AssignStmt (6:5)\n            target: Identifier (6:5)\n' in tree, tree
+    assert '[1] Literal (7:18)\n' in tree and 'value: "é"' in tree, tree
+    assert 'line:' not in tree and 'imports' not in tree
+    
+    data = json.loads(dump_json(program))
+    point = data['declarations'][0]
+    assert point['node'] == 'ClassDecl' and point['name'] == 'Point' and point['line'] == 3 and point['file'] == 'p.gox'
+    assert point['methods'] == [] and point['is_data'] is True
+    
+    # Every node of every corpus program is dumped
+    for source in fuzz.load_corpus(Path(__file__).parent):
+        try:
+            parsed = Parser(Lexer(source).tokenize()).parse()
+        except (LexerError, ParseError):
+            continue
+        assert json.loads(dump_json(parsed))['node'] == 'Program'
+        dump_tree(parsed)
+    
+    def ast(*argv):
+        parser = argparse.ArgumentParser()
+        parser.add_argument('input')
+        parser.add_argument('--json', action='store_true')
+        parser.add_argument('--expanded', action='store_true')
+        parser.add_argument('--tags')
+        parser.add_argument('--plugin', action='append', default=[])
+        output = io.StringIO()
+        with contextlib.redirect_stdout(output), contextlib.redirect_stderr(output):
+            try:
+                goe2go.cmd_ast(parser.parse_args(argv))
+            except SystemExit as e:
+                return output.getvalue(), e.code
+        return output.getvalue(), 0
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        path = Path(scratch) / 'p.gox'
+        path.write_text(code, encoding='utf-8')
+        assert ast(str(path)) == (dump_tree(Parser(Lexer(code, set(), str(path)).stream(), str(path)).parse()), 0)
+        
+        # The expanded AST holds the members generated for the data class
+        output, status = ast('--expanded', '--json', str(path))
+        methods = [m['name'] for m in json.loads(output)['declarations'][0]['methods']]
+        assert status == 0 and 'String' in methods and 'Equals' in methods, methods
+        
+        path.write_text('package main\n\nfunc (\n', encoding='utf-8')
+        output, status = ast(str(path))
+        assert status == 1 and 'Expected function name' in output, output
+    
+    print("AST dump OK!\n")
+    
+def test_symbol_index():
+    """Tests the exported symbol index: the symbols of each file with their positions, doc comments and exceptions,
+    written by builds and goe2go index, and updated only for the files whose sources changed"""
+    print("=== Testing Symbol Index ===")
+    import io
+    import json
+    import tempfile
+    import contextlib
+    from project_manager import ProjectManager
+    from symbols import file_symbols
+    
+    shapes = '''package shapes
+
+// Square is a shape with four equal sides
+class Square {
+    side int
+    Label string
+
+    // Square needs a positive side
+    Square(side int) {
+        if side <= 0 {
+            throw new Exception("InvalidSide", "side must be positive")
+        }
+        this.side = side
+    }
+
+    func Area() int {
+        return this.side * this.side
+    }
+
+    func Scale(factor int) {
+        try {
+            this.side = checked(this.side * factor)
+        } catch (Overflow e) {
+            throw NewException("TooLarge", "cannot scale")
+        }
+    }
+
+    func grow() {}
+}
+
+data class Point(x, y int)
+
+func checked(n int) int {
+    return n
+}
+
+/* Unit is the side of the smallest square */
+const Unit = 1
+'''
+    program = Parser(Lexer(shapes, set(), 'shapes.gox').stream(), 'shapes.gox').parse()
+    symbols = file_symbols(program)
+    assert [(s['kind'], s['name']) for s in symbols] == [('class', 'Square'), ('data class', 'Point'),
+                                                         ('const', 'Unit'), ('exception', 'InvalidSide'),
+                                                         ('exception', 'TooLarge')], symbols
+    square = symbols[0]
+    assert (square['line'], square['column'], square['end_line'], square['end_column']) == (4, 1, 29, 2)
+    assert square['doc'] == 'Square is a shape with four equal sides'
+    members = {m['name']: m for m in square['members']}
+    assert list(members) == ['Square', 'Label', 'Area', 'Scale'], members
+    assert members['Square']['signature'] == 'new Square(side int)' and members['Square']['throws'] == ['InvalidSide']
+    assert members['Square']['doc'] == 'Square needs a positive side'
+    assert members['Label'] == {'name': 'Label', 'kind': 'field', 'line': 6, 'column': 5, 'end_line': 6,
+                                'end_column': 17, 'doc': '', 'type': 'string'}, members['Label']
+    assert members['Area']['signature'] == 'Area() int' and members['Area']['throws'] == []
+    assert members['Scale']['throws'] == ['TooLarge']
+    assert symbols[2]['doc'] == 'Unit is the side of the smallest square' and symbols[3]['line'] == 11
+    
+    def run(root: Path, build: bool = False):
+        manager = ProjectManager(root, verify=False, formatter='none')
+        output = io.StringIO()
+        with contextlib.redirect_stdout(output):
+            manager.load_config()
+            if build:
+                manager.transpile_project()
+            else:
+                manager.index_project()
+        return output.getvalue(), json.loads((root / 'goe2go-index.json').read_text(encoding='utf-8'))
+    
+    with tempfile.TemporaryDirectory() as scratch:
+        root = Path(scratch) / 'app'
+        (root / 'src' / 'shapes').mkdir(parents=True)
+        (root / 'src' / 'shapes' / 'shapes.gox').write_text(shapes, encoding='utf-8')
+        (root / 'src' / 'shapes' / 'circle.gox').write_text('package shapes\n\nclass Circle {\n    radius int\n}\n',
+                                                          encoding='utf-8')
+        
+        # The index describes the sources, not the members the build generates (the accessors of Point)
+        _, index = run(root, build=True)
+        assert index['version'] == 1 and list(index['files']) == ['src/shapes/circle.gox', 'src/shapes/shapes.gox']
+        entry = index['files']['src/shapes/shapes.gox']
+        assert entry['package'] == 'shapes' and entry['symbols'] == json.loads(json.dumps(symbols))
+        
+        output, again = run(root)
+        assert output == 'Indexed 0 of 2 .gox files in goe2go-index.json\n' and again == index, output
+        
+        # Only the changed file is indexed again; a file that stops parsing keeps its symbols, a deleted one does not
+        (root / 'src' / 'shapes' / 'circle.gox').write_text('package shapes\n\nclass Circle {\n', encoding='utf-8')
+        (root / 'src' / 'shapes' / 'shapes.gox').write_text(shapes.replace('Label string', 'Name string'),
+                                                          encoding='utf-8')
+        output, index = run(root)
+        assert 'Indexed 1 of 1 .gox files' in output, output
+        assert index['files']['src/shapes/circle.gox']['symbols'][0]['name'] == 'Circle'
+        assert 'Name' in [m['name'] for m in index['files']['src/shapes/shapes.gox']['symbols'][0]['members']]
+        (root / 'src' / 'shapes' / 'circle.gox').unlink()
+        _, index = run(root)
+        assert list(index['files']) == ['src/shapes/shapes.gox']
+    
+    print("Symbol index OK!\n")
+    
 def test_file_example():
     """Tests with example file"""
     print("=== Testing with Example File ===")
@@ -164,6 +6095,106 @@ if __name__ == '__main__':
         test_lexer()
         test_parser()
         test_transpiler()
+        test_interfaces()
+        test_generics()
+        test_operator_overloading()
+        test_indexers()
+        test_enums()
+        test_data_classes()
+        test_records()
+        test_mixins()
+        test_nested_classes()
+        test_anonymous_classes()
+        test_objects()
+        test_static_blocks()
+        test_init_blocks()
+        test_disposal()
+        test_cloneable()
+        test_builders()
+        test_accessors()
+        test_struct_tags()
+        test_json_classes()
+        test_stringer()
+        test_comparable_classes()
+        test_is_operator()
+        test_casts()
+        test_class_metadata()
+        test_partial_classes()
+        test_iterators()
+        test_events()
+        test_observable_fields()
+        test_cross_package_inheritance()
+        test_pointer_embedding()
+        test_string_interpolation()
+        test_ternary()
+        test_optional_chaining()
+        test_match()
+        test_switch_expressions()
+        test_lambdas()
+        test_comprehensions()
+        test_for_in()
+        test_tuples()
+        test_raw_strings()
+        test_numeric_literals()
+        test_characters()
+        test_escapes()
+        test_operators()
+        test_increments()
+        test_defer()
+        test_goroutines()
+        test_channels()
+        test_composite_literals()
+        test_variadics()
+        test_labels()
+        test_do_while()
+        test_with()
+        test_annotations()
+        test_conditional_compilation()
+        test_constants()
+        test_unions()
+        test_nullable()
+        test_conversions()
+        test_name_resolution()
+        test_package_symbols()
+        test_parse_recovery()
+        test_source_positions()
+        test_line_directives()
+        test_source_maps()
+        test_go_check()
+        test_formatting()
+        test_comments()
+        test_deterministic_order()
+        test_unused_imports()
+        test_import_management()
+        test_constructor_defaults()
+        test_minimal_emission()
+        test_receiver_names()
+        test_diagnostics()
+        test_dead_code()
+        test_constant_folding()
+        test_inline_accessors()
+        test_value_classes()
+        test_warning_settings()
+        test_lint()
+        test_diagnostics_format()
+        test_terminal_diagnostics()
+        test_did_you_mean()
+        test_build_cache()
+        test_parallel_build()
+        test_streaming_lexer()
+        test_parser_memory()
+        test_node_memory()
+        test_released_bodies()
+        test_fuzz()
+        test_golden('--update' in sys.argv[1:])
+        test_round_trip()
+        test_exception_dispatch()
+        test_conformance()
+        test_ast_api()
+        test_plugins()
+        test_format()
+        test_ast_dump()
+        test_symbol_index()
         test_file_example()
         
         print("All tests passed!")
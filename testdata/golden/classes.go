@@ -0,0 +1,170 @@
+package main
+
+import (
+    "fmt"
+)
+
+// Exception types
+type Exception interface {
+    Error() string
+    Type() string
+}
+
+type BaseException struct {
+    message string
+    exType string
+}
+
+func (e *BaseException) Error() string {
+    return e.message
+}
+
+func (e *BaseException) Type() string {
+    return e.exType
+}
+
+func NewException(exType, message string) Exception {
+    return &BaseException{message: message, exType: exType}
+}
+
+// Class metadata
+type ClassInfo struct {
+    Name              string
+    Package           string
+    BaseName          string
+    Base              *ClassInfo
+    Interfaces        []string
+    Annotations       []AnnotationInfo
+    MethodAnnotations map[string][]AnnotationInfo
+}
+
+// AnnotationInfo is an annotation of a class or method with its constant arguments
+type AnnotationInfo struct {
+    Name string
+    Args []interface{}
+}
+
+// Annotation finds an annotation of the class by name
+func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.Annotations, name)
+}
+
+// MethodAnnotation finds an annotation of one of the class's methods by name
+func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.MethodAnnotations[method], name)
+}
+
+func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {
+    for _, annotation := range annotations {
+        if annotation.Name == name {
+            return annotation, true
+        }
+    }
+    return AnnotationInfo{}, false
+}
+
+// IsSubclassOf reports whether the class is other or derives from it
+func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {
+    for current := c; current != nil; current = current.Base {
+        if current == other {
+            return true
+        }
+    }
+    return false
+}
+
+var classRegistry = map[string]*ClassInfo{}
+
+// RegisterClass records a class descriptor under its qualified name (package.Name)
+func RegisterClass(info *ClassInfo) *ClassInfo {
+    classRegistry[info.Package+"."+info.Name] = info
+    return info
+}
+
+// LookupClass finds a registered class by qualified name
+func LookupClass(name string) (*ClassInfo, bool) {
+    info, ok := classRegistry[name]
+    return info, ok
+}
+
+type Describable interface {
+    Describe() string
+}
+
+type Person struct {
+    name string
+    age int
+}
+
+// Constructor
+func NewPerson(n string, a int) *Person {
+    obj := &Person{}
+    obj.name = n
+    obj.age = a
+    return obj
+}
+
+func (this *Person) Describe() string {
+    return fmt.Sprintf("%s (%d)", this.name, this.age)
+}
+
+func (this *Person) SetAge(a int) {
+    if a < 0 {
+        panic(NewException("InvalidAge", "Age cannot be negative"))
+    }
+    this.age = a
+}
+
+var personClass = RegisterClass(&ClassInfo{Name: "Person", Package: "main", Interfaces: []string{"Describable"}})
+
+func (this *Person) GetType() *ClassInfo {
+    return personClass
+}
+
+var _ Describable = (*Person)(nil)
+
+
+type Student struct {
+    Person
+    school string
+}
+
+func NewStudent(n string, a int, s string) *Student {
+    obj := &Student{}
+    obj.Person = *NewPerson(n, a)
+    obj.school = s
+    return obj
+}
+
+func (this *Student) Study() {
+    fmt.Printf("%s is studying at %s\n", this.name, this.school)
+}
+
+var studentClass = RegisterClass(&ClassInfo{Name: "Student", Package: "main", BaseName: "Person", Base: personClass})
+
+func (this *Student) GetType() *ClassInfo {
+    return studentClass
+}
+
+
+func main() {
+    func() {
+        defer func() {
+            fmt.Println("Cleanup")
+        }()
+        defer func() {
+            if r := recover(); r != nil {
+                ex, _ := r.(Exception)
+                if ex == nil {
+                    ex = NewException("RuntimeError", fmt.Sprintf("%v", r))
+                }
+
+                e := ex
+                fmt.Println("Error:", e.Error())
+            }
+        }()
+        s := NewStudent("Ann", 20, "MIT")
+        s.Study()
+        s.SetAge(-1)
+    }()
+}
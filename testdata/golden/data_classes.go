@@ -0,0 +1,177 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+)
+
+// Class metadata
+type ClassInfo struct {
+    Name              string
+    Package           string
+    BaseName          string
+    Base              *ClassInfo
+    Interfaces        []string
+    Annotations       []AnnotationInfo
+    MethodAnnotations map[string][]AnnotationInfo
+}
+
+// AnnotationInfo is an annotation of a class or method with its constant arguments
+type AnnotationInfo struct {
+    Name string
+    Args []interface{}
+}
+
+// Annotation finds an annotation of the class by name
+func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.Annotations, name)
+}
+
+// MethodAnnotation finds an annotation of one of the class's methods by name
+func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.MethodAnnotations[method], name)
+}
+
+func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {
+    for _, annotation := range annotations {
+        if annotation.Name == name {
+            return annotation, true
+        }
+    }
+    return AnnotationInfo{}, false
+}
+
+// IsSubclassOf reports whether the class is other or derives from it
+func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {
+    for current := c; current != nil; current = current.Base {
+        if current == other {
+            return true
+        }
+    }
+    return false
+}
+
+var classRegistry = map[string]*ClassInfo{}
+
+// RegisterClass records a class descriptor under its qualified name (package.Name)
+func RegisterClass(info *ClassInfo) *ClassInfo {
+    classRegistry[info.Package+"."+info.Name] = info
+    return info
+}
+
+// LookupClass finds a registered class by qualified name
+func LookupClass(name string) (*ClassInfo, bool) {
+    info, ok := classRegistry[name]
+    return info, ok
+}
+
+type Point struct {
+    x float64
+    y float64
+}
+
+func NewPoint(x float64, y float64) *Point {
+    obj := &Point{}
+    obj.x = x
+    obj.y = y
+    return obj
+}
+
+func (this *Point) GetX() float64 {
+    return this.x
+}
+
+func (this *Point) GetY() float64 {
+    return this.y
+}
+
+func (this *Point) Equals(other *Point) bool {
+    if this == nil || other == nil {
+        return this == other
+    }
+    return this.x == other.x && this.y == other.y
+}
+
+func (this *Point) HashCode() uint64 {
+    if this == nil {
+        return 0
+    }
+    h := fnv.New64a()
+    fmt.Fprintf(h, "%v|%v", this.x, this.y)
+    return h.Sum64()
+}
+
+func (this *Point) String() string {
+    return fmt.Sprintf("Point(x=%v, y=%v)", this.x, this.y)
+}
+
+var pointClass = RegisterClass(&ClassInfo{Name: "Point", Package: "main"})
+
+func (this *Point) GetType() *ClassInfo {
+    return pointClass
+}
+
+
+type Person struct {
+    name string
+    age int
+}
+
+func NewPerson(name string, age int) *Person {
+    obj := &Person{}
+    obj.name = name
+    obj.age = age
+    return obj
+}
+
+func (this *Person) GetName() string {
+    return this.name
+}
+
+func (this *Person) GetAge() int {
+    return this.age
+}
+
+func (this *Person) Equals(other *Person) bool {
+    if this == nil || other == nil {
+        return this == other
+    }
+    return this.name == other.name && this.age == other.age
+}
+
+func (this *Person) HashCode() uint64 {
+    if this == nil {
+        return 0
+    }
+    h := fnv.New64a()
+    fmt.Fprintf(h, "%v|%v", this.name, this.age)
+    return h.Sum64()
+}
+
+func (this *Person) String() string {
+    return fmt.Sprintf("Person(name=%v, age=%v)", this.name, this.age)
+}
+
+func (this *Person) copyWith(set func(*Person)) *Person {
+    copy := *this
+    set(&copy)
+    return &copy
+}
+
+var personClass = RegisterClass(&ClassInfo{Name: "Person", Package: "main"})
+
+func (this *Person) GetType() *ClassInfo {
+    return personClass
+}
+
+
+func birthday(p *Person) *Person {
+    return p.copyWith(func(c *Person) { c.age = p.GetAge() + 1 })
+}
+
+func main() {
+    a := NewPoint(1, 2)
+    b := NewPoint(1, 2)
+    fmt.Println(a, a.Equals(b))
+    fmt.Println(birthday(NewPerson("Ann", 30)))
+}
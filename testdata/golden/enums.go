@@ -0,0 +1,97 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+)
+
+type Color int
+
+const (
+    ColorRed Color = iota
+    ColorGreen
+    ColorBlue
+)
+
+var colorNames = [...]string{"Red", "Green", "Blue"}
+
+func (this Color) String() string {
+    if this < 0 || int(this) >= len(colorNames) {
+        return "Color(" + strconv.Itoa(int(this)) + ")"
+    }
+    return colorNames[this]
+}
+
+func ColorValues() []Color {
+    return []Color{ColorRed, ColorGreen, ColorBlue}
+}
+
+func ColorFromString(name string) (Color, bool) {
+    for i, n := range colorNames {
+        if n == name {
+            return Color(i), true
+        }
+    }
+    return 0, false
+}
+
+func (this Color) IsWarm() bool {
+    return this == ColorRed
+}
+
+type Planet int
+
+const (
+    PlanetMercury Planet = iota
+    PlanetEarth
+)
+
+var planetNames = [...]string{"Mercury", "Earth"}
+
+var planetTable = [...]struct {
+    mass float64
+}{
+    {3.3},
+    {5.9},
+}
+
+func (this Planet) String() string {
+    if this < 0 || int(this) >= len(planetNames) {
+        return "Planet(" + strconv.Itoa(int(this)) + ")"
+    }
+    return planetNames[this]
+}
+
+func PlanetValues() []Planet {
+    return []Planet{PlanetMercury, PlanetEarth}
+}
+
+func PlanetFromString(name string) (Planet, bool) {
+    for i, n := range planetNames {
+        if n == name {
+            return Planet(i), true
+        }
+    }
+    return 0, false
+}
+
+func (this Planet) Mass() float64 {
+    return planetTable[this].mass
+}
+
+func describe(c Color) string {
+    switch c {
+        case ColorRed:
+            return "warm"
+        case ColorGreen, ColorBlue:
+            return "cool"
+    }
+    return ""
+}
+
+func main() {
+    for _, c := range ColorValues() {
+        fmt.Println(c, describe(c), c.IsWarm())
+    }
+    fmt.Println(PlanetEarth.Mass())
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+)
+
+// Exception types
+type Exception interface {
+    Error() string
+    Type() string
+}
+
+type BaseException struct {
+    message string
+    exType string
+}
+
+func (e *BaseException) Error() string {
+    return e.message
+}
+
+func (e *BaseException) Type() string {
+    return e.exType
+}
+
+func NewException(exType, message string) Exception {
+    return &BaseException{message: message, exType: exType}
+}
+
+// Class metadata
+type ClassInfo struct {
+    Name              string
+    Package           string
+    BaseName          string
+    Base              *ClassInfo
+    Interfaces        []string
+    Annotations       []AnnotationInfo
+    MethodAnnotations map[string][]AnnotationInfo
+}
+
+// AnnotationInfo is an annotation of a class or method with its constant arguments
+type AnnotationInfo struct {
+    Name string
+    Args []interface{}
+}
+
+// Annotation finds an annotation of the class by name
+func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.Annotations, name)
+}
+
+// MethodAnnotation finds an annotation of one of the class's methods by name
+func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.MethodAnnotations[method], name)
+}
+
+func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {
+    for _, annotation := range annotations {
+        if annotation.Name == name {
+            return annotation, true
+        }
+    }
+    return AnnotationInfo{}, false
+}
+
+// IsSubclassOf reports whether the class is other or derives from it
+func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {
+    for current := c; current != nil; current = current.Base {
+        if current == other {
+            return true
+        }
+    }
+    return false
+}
+
+var classRegistry = map[string]*ClassInfo{}
+
+// RegisterClass records a class descriptor under its qualified name (package.Name)
+func RegisterClass(info *ClassInfo) *ClassInfo {
+    classRegistry[info.Package+"."+info.Name] = info
+    return info
+}
+
+// LookupClass finds a registered class by qualified name
+func LookupClass(name string) (*ClassInfo, bool) {
+    info, ok := classRegistry[name]
+    return info, ok
+}
+
+type Shape interface {
+    Area() float64
+}
+
+type Circle struct {
+    radius float64
+}
+
+func NewCircle() *Circle {
+    obj := &Circle{}
+    return obj
+}
+
+func (this *Circle) Area() float64 {
+    return 3.14 * this.radius * this.radius
+}
+
+var circleClass = RegisterClass(&ClassInfo{Name: "Circle", Package: "main", Interfaces: []string{"Shape"}})
+
+func (this *Circle) GetType() *ClassInfo {
+    return circleClass
+}
+
+// asCircle returns the Circle part of value when it is a Circle or a subclass
+func asCircle(value any) (*Circle, bool) {
+    switch v := value.(type) {
+    case *Circle:
+        return v, true
+    }
+    return nil, false
+}
+
+func isCircle(value any) bool {
+    _, ok := asCircle(value)
+    return ok
+}
+
+var _ Shape = (*Circle)(nil)
+
+
+type Rect struct {
+    w float64
+    h float64
+}
+
+func NewRect(w float64, h float64) *Rect {
+    obj := &Rect{}
+    obj.w = w
+    obj.h = h
+    return obj
+}
+
+func (this *Rect) Area() float64 {
+    return this.w * this.h
+}
+
+func (this *Rect) GetW() float64 {
+    return this.w
+}
+
+func (this *Rect) GetH() float64 {
+    return this.h
+}
+
+func (this *Rect) Equals(other *Rect) bool {
+    if this == nil || other == nil {
+        return this == other
+    }
+    return this.w == other.w && this.h == other.h
+}
+
+func (this *Rect) HashCode() uint64 {
+    if this == nil {
+        return 0
+    }
+    h := fnv.New64a()
+    fmt.Fprintf(h, "%v|%v", this.w, this.h)
+    return h.Sum64()
+}
+
+func (this *Rect) String() string {
+    return fmt.Sprintf("Rect(w=%v, h=%v)", this.w, this.h)
+}
+
+var rectClass = RegisterClass(&ClassInfo{Name: "Rect", Package: "main", Interfaces: []string{"Shape"}})
+
+func (this *Rect) GetType() *ClassInfo {
+    return rectClass
+}
+
+// asRect returns the Rect part of value when it is a Rect or a subclass
+func asRect(value any) (*Rect, bool) {
+    switch v := value.(type) {
+    case *Rect:
+        return v, true
+    }
+    return nil, false
+}
+
+func isRect(value any) bool {
+    _, ok := asRect(value)
+    return ok
+}
+
+var _ Shape = (*Rect)(nil)
+
+
+func describe(s Shape) string {
+    if c, ok := asCircle(s); ok && c.radius > 10 {
+        return "big circle"
+    } else if rect, ok := asRect(s); ok && rect.h == 0 {
+        return "flat"
+    } else if _, ok := asRect(s); ok {
+        return "rect"
+    } else if _, ok := asCircle(s); ok {
+        return "circle"
+    }
+    panic(NewException("MatchError", fmt.Sprintf("no match arm for %v", s)))
+}
+
+func find(name string) *Circle {
+    return nil
+}
+
+func main() {
+    circles := []*Circle{NewCircle()}
+    var areas []float64
+    for _, c := range circles {
+        if c.radius > 1 {
+            areas = append(areas, c.Area())
+        }
+    }
+    var sizes string
+    if len(areas) > 0 {
+        sizes = "some"
+    } else {
+        sizes = "none"
+    }
+    fmt.Println(describe(NewRect(1, 2)), areas, sizes)
+    fmt.Println(func() float64 { if recv := find("c"); recv != nil { return recv.radius }; return 0.0 }())
+}
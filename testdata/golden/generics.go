@@ -0,0 +1,112 @@
+package main
+
+import (
+    "fmt"
+)
+
+// Class metadata
+type ClassInfo struct {
+    Name              string
+    Package           string
+    BaseName          string
+    Base              *ClassInfo
+    Interfaces        []string
+    Annotations       []AnnotationInfo
+    MethodAnnotations map[string][]AnnotationInfo
+}
+
+// AnnotationInfo is an annotation of a class or method with its constant arguments
+type AnnotationInfo struct {
+    Name string
+    Args []interface{}
+}
+
+// Annotation finds an annotation of the class by name
+func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.Annotations, name)
+}
+
+// MethodAnnotation finds an annotation of one of the class's methods by name
+func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.MethodAnnotations[method], name)
+}
+
+func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {
+    for _, annotation := range annotations {
+        if annotation.Name == name {
+            return annotation, true
+        }
+    }
+    return AnnotationInfo{}, false
+}
+
+// IsSubclassOf reports whether the class is other or derives from it
+func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {
+    for current := c; current != nil; current = current.Base {
+        if current == other {
+            return true
+        }
+    }
+    return false
+}
+
+var classRegistry = map[string]*ClassInfo{}
+
+// RegisterClass records a class descriptor under its qualified name (package.Name)
+func RegisterClass(info *ClassInfo) *ClassInfo {
+    classRegistry[info.Package+"."+info.Name] = info
+    return info
+}
+
+// LookupClass finds a registered class by qualified name
+func LookupClass(name string) (*ClassInfo, bool) {
+    info, ok := classRegistry[name]
+    return info, ok
+}
+
+type Container[T any] interface {
+    Get(i int) T
+}
+
+type Stack[T any] struct {
+    items []T
+}
+
+func NewStack[T any]() *Stack[T] {
+    obj := &Stack[T]{}
+    return obj
+}
+
+func (this *Stack[T]) Push(item T) {
+    this.items = append(this.items, item)
+}
+
+func (this *Stack[T]) Get(i int) T {
+    return this.items[i]
+}
+
+var stackClass = RegisterClass(&ClassInfo{Name: "Stack", Package: "main", Interfaces: []string{"Container"}})
+
+func (this *Stack[T]) GetType() *ClassInfo {
+    return stackClass
+}
+
+func _[T any]() {
+    var _ Container[T] = (*Stack[T])(nil)
+}
+
+
+func Map[T any, R any](xs []T, f func(T) R) []R {
+    var out []R
+    for _, x := range xs {
+        out = append(out, f(x))
+    }
+    return out
+}
+
+func main() {
+    s := NewStack[int]()
+    s.Push(1)
+    s.Push(2)
+    fmt.Println(Map(s.items, func(x int) int { return x * 2 }), s.Get(0))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "fmt"
+    "iter"
+)
+
+// Class metadata
+type ClassInfo struct {
+    Name              string
+    Package           string
+    BaseName          string
+    Base              *ClassInfo
+    Interfaces        []string
+    Annotations       []AnnotationInfo
+    MethodAnnotations map[string][]AnnotationInfo
+}
+
+// AnnotationInfo is an annotation of a class or method with its constant arguments
+type AnnotationInfo struct {
+    Name string
+    Args []interface{}
+}
+
+// Annotation finds an annotation of the class by name
+func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.Annotations, name)
+}
+
+// MethodAnnotation finds an annotation of one of the class's methods by name
+func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.MethodAnnotations[method], name)
+}
+
+func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {
+    for _, annotation := range annotations {
+        if annotation.Name == name {
+            return annotation, true
+        }
+    }
+    return AnnotationInfo{}, false
+}
+
+// IsSubclassOf reports whether the class is other or derives from it
+func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {
+    for current := c; current != nil; current = current.Base {
+        if current == other {
+            return true
+        }
+    }
+    return false
+}
+
+var classRegistry = map[string]*ClassInfo{}
+
+// RegisterClass records a class descriptor under its qualified name (package.Name)
+func RegisterClass(info *ClassInfo) *ClassInfo {
+    classRegistry[info.Package+"."+info.Name] = info
+    return info
+}
+
+// LookupClass finds a registered class by qualified name
+func LookupClass(name string) (*ClassInfo, bool) {
+    info, ok := classRegistry[name]
+    return info, ok
+}
+
+type Bag struct {
+    items []string
+}
+
+func NewBag() *Bag {
+    obj := &Bag{}
+    return obj
+}
+
+func (this *Bag) Iterator() iter.Seq[string] {
+    return func(yield func(string) bool) {
+        for _, it := range this.items {
+            if !yield(it) {
+                return
+            }
+        }
+    }
+}
+
+var bagClass = RegisterClass(&ClassInfo{Name: "Bag", Package: "main"})
+
+func (this *Bag) GetType() *ClassInfo {
+    return bagClass
+}
+
+
+func Pairs(names []string) iter.Seq2[int, string] {
+    return func(yield func(int, string) bool) {
+        for i, n := range names {
+            if !yield(i, n) {
+                return
+            }
+        }
+    }
+}
+
+func main() {
+    m := map[string]int{"a": 1}
+    for k, v := range m {
+        fmt.Println(k, v)
+    }
+    for s := range NewBag().Iterator() {
+        fmt.Println(s)
+    }
+    for i, n := range Pairs([]string{"x", "y"}) {
+        fmt.Println(i, n)
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+)
+
+// Class metadata
+type ClassInfo struct {
+    Name              string
+    Package           string
+    BaseName          string
+    Base              *ClassInfo
+    Interfaces        []string
+    Annotations       []AnnotationInfo
+    MethodAnnotations map[string][]AnnotationInfo
+}
+
+// AnnotationInfo is an annotation of a class or method with its constant arguments
+type AnnotationInfo struct {
+    Name string
+    Args []interface{}
+}
+
+// Annotation finds an annotation of the class by name
+func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.Annotations, name)
+}
+
+// MethodAnnotation finds an annotation of one of the class's methods by name
+func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {
+    return findAnnotation(c.MethodAnnotations[method], name)
+}
+
+func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {
+    for _, annotation := range annotations {
+        if annotation.Name == name {
+            return annotation, true
+        }
+    }
+    return AnnotationInfo{}, false
+}
+
+// IsSubclassOf reports whether the class is other or derives from it
+func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {
+    for current := c; current != nil; current = current.Base {
+        if current == other {
+            return true
+        }
+    }
+    return false
+}
+
+var classRegistry = map[string]*ClassInfo{}
+
+// RegisterClass records a class descriptor under its qualified name (package.Name)
+func RegisterClass(info *ClassInfo) *ClassInfo {
+    classRegistry[info.Package+"."+info.Name] = info
+    return info
+}
+
+// LookupClass finds a registered class by qualified name
+func LookupClass(name string) (*ClassInfo, bool) {
+    info, ok := classRegistry[name]
+    return info, ok
+}
+
+type Money struct {
+    cents int
+}
+
+func NewMoney(c int) *Money {
+    obj := &Money{}
+    obj.cents = c
+    return obj
+}
+
+func (this *Money) Add(other *Money) *Money {
+    return NewMoney(this.cents + other.cents)
+}
+
+func (this *Money) Equals(other *Money) bool {
+    return this.cents == other.cents
+}
+
+func (this *Money) Less(other *Money) bool {
+    return this.cents < other.cents
+}
+
+var moneyClass = RegisterClass(&ClassInfo{Name: "Money", Package: "main"})
+
+func (this *Money) GetType() *ClassInfo {
+    return moneyClass
+}
+
+
+func main() {
+    a := NewMoney(1)
+    b := NewMoney(2)
+    c := a.Add(b)
+    c = c.Add(a)
+    fmt.Println(c.cents, a.Equals(b), !a.Equals(b), b.Less(a))
+}
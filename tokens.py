@@ -3,7 +3,7 @@ Token definitions for Go-Extended
 """
 
 from enum import Enum, auto
-from dataclasses import dataclass
+from dataclasses import dataclass, field
 from typing import Any, Optional
 
 class TokenType(Enum):
@@ -11,6 +11,9 @@ class TokenType(Enum):
     IDENTIFIER = auto()
     NUMBER = auto()
     STRING = auto()
+    TEMPLATE_STRING = auto()  # "Hi ${name}": raw text, split into parts by the parser
+    RAW_STRING = auto()       # `raw` or """multi-line""" (value already decoded)
+    CHAR = auto()             # 'a' (value is the decoded character)
     BOOLEAN = auto()
     
     # Keywords Go standard
@@ -44,6 +47,8 @@ class TokenType(Enum):
     THIS = auto()
     SUPER = auto()
     EXTENDS = auto()
+    IMPLEMENTS = auto()
+    ENUM = auto()
     
     # Extensions - Exceptions
     TRY = auto()
@@ -60,6 +65,12 @@ class TokenType(Enum):
     MULT_ASSIGN = auto()     # *=
     DIV_ASSIGN = auto()      # /=
     MOD_ASSIGN = auto()      # %=
+    AND_ASSIGN = auto()      # &=
+    OR_ASSIGN = auto()       # |=
+    XOR_ASSIGN = auto()      # ^=
+    SHL_ASSIGN = auto()      # <<=
+    SHR_ASSIGN = auto()      # >>=
+    AND_NOT_ASSIGN = auto()  # &^=
     
     PLUS = auto()            # +
     MINUS = auto()           # -
@@ -84,6 +95,7 @@ class TokenType(Enum):
     BITWISE_NOT = auto()     # ~
     LEFT_SHIFT = auto()      # <<
     RIGHT_SHIFT = auto()     # >>
+    BIT_CLEAR = auto()       # &^
     
     INCREMENT = auto()       # ++
     DECREMENT = auto()       # --
@@ -101,19 +113,28 @@ class TokenType(Enum):
     DOT = auto()             # .
     COLON = auto()           # :
     DOUBLE_COLON = auto()    # ::
+    ELLIPSIS = auto()        # ... (variadic parameters and spread arguments)
     ARROW = auto()           # ->
+    LEFT_ARROW = auto()      # <- (channel send and receive)
+    AT = auto()              # @ (annotations)
+    QUESTION = auto()        # ? (as?, cond ? a : b)
+    OPTIONAL_DOT = auto()    # ?.
+    COALESCE = auto()        # ??
     
     # Specials
     NEWLINE = auto()
     EOF = auto()
     COMMENT = auto()
 
-@dataclass
+@dataclass(slots=True)  # a large file has millions of tokens: no __dict__ per token
 class Token:
     type: TokenType
     value: str
     line: int
     column: int
+    # Position just past the token's last character, set by the lexer
+    end_line: int = field(default=0, compare=False)
+    end_column: int = field(default=0, compare=False)
     
     def __str__(self):
         return f"Token({self.type.name}, '{self.value}', {self.line}:{self.column})"
@@ -156,6 +177,8 @@ KEYWORDS = {
     'this': TokenType.THIS,
     'super': TokenType.SUPER,
     'extends': TokenType.EXTENDS,
+    'implements': TokenType.IMPLEMENTS,
+    'enum': TokenType.ENUM,
     
     # Extensions - Exceptions
     'try': TokenType.TRY,
@@ -165,6 +188,14 @@ KEYWORDS = {
     'exception': TokenType.EXCEPTION,
 }
 
+# Three-character operators
+THREE_CHAR_OPERATORS = {
+    '<<=': TokenType.SHL_ASSIGN,
+    '>>=': TokenType.SHR_ASSIGN,
+    '&^=': TokenType.AND_NOT_ASSIGN,
+    '...': TokenType.ELLIPSIS,
+}
+
 # Two-character operators
 TWO_CHAR_OPERATORS = {
     '==': TokenType.EQ,
@@ -175,6 +206,7 @@ TWO_CHAR_OPERATORS = {
     '||': TokenType.OR,
     '<<': TokenType.LEFT_SHIFT,
     '>>': TokenType.RIGHT_SHIFT,
+    '&^': TokenType.BIT_CLEAR,
     '++': TokenType.INCREMENT,
     '--': TokenType.DECREMENT,
     ':=': TokenType.SHORT_ASSIGN,
@@ -183,8 +215,14 @@ TWO_CHAR_OPERATORS = {
     '*=': TokenType.MULT_ASSIGN,
     '/=': TokenType.DIV_ASSIGN,
     '%=': TokenType.MOD_ASSIGN,
+    '&=': TokenType.AND_ASSIGN,
+    '|=': TokenType.OR_ASSIGN,
+    '^=': TokenType.XOR_ASSIGN,
     '::': TokenType.DOUBLE_COLON,
     '->': TokenType.ARROW,
+    '<-': TokenType.LEFT_ARROW,
+    '?.': TokenType.OPTIONAL_DOT,
+    '??': TokenType.COALESCE,
 }
 
 # One-character operators
@@ -212,4 +250,6 @@ ONE_CHAR_OPERATORS = {
     ',': TokenType.COMMA,
     '.': TokenType.DOT,
     ':': TokenType.COLON,
+    '@': TokenType.AT,
+    '?': TokenType.QUESTION,
 }
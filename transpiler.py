@@ -3,44 +3,4496 @@ Go-Extended to Go transpiler
 Converts Go-Extended AST to standard Go code
 """
 
-from typing import List, Dict, Set, Optional
+import re
+import copy
+from fractions import Fraction
+from typing import List, Dict, Set, Iterable, Optional, Sequence, Tuple
 from ast_nodes import *
+from errors import CompileError, Diagnostic, error_diagnostic, position
+from generators import ClassGenerator, GeneratorError
+from plugins import Plugin, PluginContext, PluginError
+from goimports import referenced_names, identifiers, import_used, package_name, qualify, rename
+from literals import (string_literal, quote_string, number_literal, fits_number, rune_literal, NUMBER_RANGES,
+                      SIZED_TYPES)
 
-class TranspilerError(Exception):
+# A line declaring a name in the scope it is in: x := 1, a, b := f(), var/const/type declarations and labels
+PLAIN_BLOCK_DECLARATION = re.compile(r'(?:[A-Za-z_]\w*\s*,\s*)*[A-Za-z_]\w*\s*:=|(?:var|const|type)\b|[A-Za-z_]\w*:$')
+
+# Names of the receiver of generated methods: this, self, or the type's initial (p for *Person)
+RECEIVER_STYLES = ('this', 'self', 'initial')
+
+# Header of a generated method, with the receiver type's name
+METHOD_HEADER = re.compile(r'func \(this \*?([A-Za-z_]\w*)')
+
+# Warnings by ID: (name, default severity). A project's "warnings" setting changes the severity of an ID
+# ('error' fails the build) or turns it 'off'; //goplus:nowarn suppresses them on one line.
+# GP1xxx are reported by the transpiler, GP2xxx by the linter (goe2go lint)
+WARNINGS = {
+    'GP1001': ('nil-field', 'warning'),  # nil assigned to a non-nullable field
+    'GP1002': ('shadowed-field', 'warning'),  # a local or parameter hides a field of the class
+    'GP1003': ('unread-local', 'warning'),  # a local is assigned but never read
+    'GP1004': ('unused-field', 'info'),  # a private field is never used
+    'GP1005': ('uncalled-method', 'info'),  # an unexported method is never called
+    'GP1006': ('unused-class', 'info'),  # a private class is never used
+    'GP1007': ('unreachable-code', 'warning'),  # statements after a return, throw, break or continue
+    'GP2001': ('empty-catch', 'warning'),  # a catch whose body is empty, without a comment saying why
+    'GP2002': ('catch-all-first', 'warning'),  # a catch-all before other catches, which never run
+    'GP2003': ('undocumented-throw', 'warning'),  # a constructor throws and its comment does not say so
+    'GP2004': ('large-class', 'info'),  # a class with too many methods or fields
+    'GP2005': ('mutable-global', 'warning'),  # a package variable modified by functions or methods
+}
+SEVERITIES = ('error', 'warning', 'info', 'off')
+
+class TranspilerError(CompileError):
     """Transpiler error"""
-    pass
+    code = 'GP0003'
+
+class WarningError(TranspilerError):
+    """Warnings with severity 'error', which stop the build once all of them are reported"""
+    code = 'GP0005'
+
+def source_position(node: ASTNode) -> str:
+    """Formats a node's source position for diagnostics"""
+    if not node.line:
+        return 'unknown position'
+    return f'{node.file}:{node.line}:{node.column}' if node.file else f'line {node.line}:{node.column}'
+
+def close_names(name: str, candidates: Iterable[str], limit: int = 3) -> List[str]:
+    """The candidates a misspelled name is closest to, at most one edit (an insertion, deletion, substitution or
+    swap of adjacent characters, ignoring case) per three characters away; single characters get none"""
+    if len(name) < 2:
+        return []
+    allowed = max(1, len(name) // 3)
+    scored = []
+    for candidate in set(candidates) - {name, '_'}:
+        distance = edit_distance(name.lower(), candidate.lower()) or 1
+        if distance <= allowed:
+            scored.append((distance, candidate))
+    return [candidate for _, candidate in sorted(scored)[:limit]]
+
+def edit_distance(a: str, b: str) -> int:
+    """Edits turning a into b, a swap of adjacent characters counting as one"""
+    if abs(len(a) - len(b)) > 3:
+        return abs(len(a) - len(b))
+    previous, row = None, list(range(len(b) + 1))
+    for i in range(1, len(a) + 1):
+        before, previous, row = previous, row, [i] + [0] * len(b)
+        for j in range(1, len(b) + 1):
+            row[j] = min(previous[j] + 1, row[j - 1] + 1, previous[j - 1] + (a[i - 1] != b[j - 1]))
+            if i > 1 and j > 1 and a[i - 1] == b[j - 2] and a[i - 2] == b[j - 1]:
+                row[j] = min(row[j], before[j - 2] + 1)
+    return row[-1]
+
+def did_you_mean(name: str, candidates: Iterable[str]) -> Optional[str]:
+    """'did you mean total?' when some candidates are close to a misspelled name, None otherwise"""
+    names = close_names(name, candidates)
+    if not names:
+        return None
+    return f"did you mean {' or '.join([', '.join(names[:-1]), names[-1]] if len(names) > 1 else names)}?"
+
+def make_diagnostic(code: str, message: str, node: ASTNode, program: Optional[Program], levels: Dict[str, str],
+                    fix: Optional[str] = None) -> Optional[Diagnostic]:
+    """The warning a node gets, with its severity from levels; None when its ID is turned off or a
+    //goplus:nowarn comment of the program suppresses it on the node's line"""
+    severity = levels.get(code, WARNINGS[code][1])
+    suppressed = program.nowarn.get(node.line) if program and node.file == program.file else None
+    if severity == 'off' or (suppressed is not None and (not suppressed or code in suppressed)):
+        return None
+    warning = Diagnostic(f"{message} ({fix}) ({source_position(node)})" if fix else
+                         f"{message} ({source_position(node)})")
+    warning.code, warning.severity, warning.message, warning.fix, warning.origin = code, severity, message, fix, node
+    return warning
+
+def check_warning_levels(levels: Optional[Dict[str, str]]) -> None:
+    """Rejects unknown IDs and severities in warning settings"""
+    for code, severity in (levels or {}).items():
+        if code not in WARNINGS:
+            raise TranspilerError(f"Unknown warning {code} in the warning settings")
+        if severity not in SEVERITIES:
+            raise TranspilerError(f"Unknown severity {severity!r} for warning {code} (use {', '.join(SEVERITIES)})")
+
+def check_nowarn(program: Program) -> None:
+    """Rejects unknown IDs in the //goplus:nowarn comments of a file"""
+    for line, codes in program.nowarn.items():
+        unknown = next((code for code in codes if code not in WARNINGS), None)
+        if unknown:
+            raise TranspilerError(f"Unknown warning {unknown} in //goplus:nowarn "
+                                  f"({program.file + ':' if program.file else 'line '}{line})")
+
+def parse_warning_levels(text: Optional[str]) -> Dict[str, str]:
+    """Splits a warning flag value: 'GP1003=error,GP1004=off' -> {'GP1003': 'error', 'GP1004': 'off'}"""
+    levels = {}
+    for item in re.split(r'[\s,]+', text or ''):
+        if item:
+            code, _, severity = item.partition('=')
+            levels[code] = severity
+    return levels
+
+class GoLine(str):
+    """A generated line of Go code that remembers the go-plus node it was generated from"""
+    origin: Optional[ASTNode] = None
 
 class Transpiler:
-    def __init__(self, project_mode=False):
+    def __init__(self, project_mode=False, embed_pointers=False, receiver='this', release=False,
+                 inline_accessors=False, warning_levels: Optional[Dict[str, str]] = None,
+                 plugins: Sequence[Plugin] = ()):
+        if receiver not in RECEIVER_STYLES:
+            raise TranspilerError(f"Unknown receiver name {receiver!r} (use {', '.join(RECEIVER_STYLES)})")
+        check_warning_levels(warning_levels)
         self.output = []
         self.indent_level = 0
         self.classes: Dict[str, ClassDecl] = {}
+        self.interfaces: Dict[str, InterfaceDecl] = {}
+        self.enums: Dict[str, EnumDecl] = {}
+        self.mixins: Dict[str, MixinDecl] = {}
+        self.annotation_decls: Dict[str, AnnotationDecl] = {}  # annotations declared with 'annotation route(...)'
+        self.constants: Dict[str, ConstDecl] = {}  # package-level constants
+        self.unions: Dict[str, TypeDecl] = {}  # type Number = int | float64
+        self.type_aliases: Dict[str, str] = {}  # type ID = string: alias -> aliased type
+        self.conversions: Dict[Tuple[str, str], Tuple[ClassDecl, ConversionDecl]] = {}  # (from, to) -> operator
+        self.constant_values: Dict[int, Optional[Tuple]] = {}  # folded value of each constant declaration, by id
+        self.evaluating_constants: Set[int] = set()  # constants being evaluated (cycle detection)
+        self.partial_classes: Dict[str, ClassDecl] = {}  # partial class name -> consolidated class
+        self.partial_parts: Dict[str, List[ClassDecl]] = {}  # partial class name -> its parts, in registration order
+        self.registered_programs: List[Program] = []
+        self.foreign_classes: Dict[str, str] = {}  # class of an imported package (models.Person) -> its package
+        self.package_symbols: Dict[str, Set[str]] = {}  # imported project package -> names it declares
+        self.source_file: Optional[str] = None  # path of the transpiled file, shown in undefined-name errors
+        self.origin: Optional[ASTNode] = None  # declaration or statement whose Go code is being emitted
+        # go-plus span (file, line, column, end line, end column) of each line of the last transpiled file;
+        # None for generated code
+        self.line_origins: List[Optional[Tuple[Optional[str], int, int, int, int]]] = []
+        self.objects: Dict[str, ClassDecl] = {}  # singleton name -> backing class (Config -> ConfigObject)
+        self.nested_types: Dict[str, str] = {}  # Car.Engine -> CarEngine
+        self.inner_classes: Dict[str, str] = {}  # CarEngine -> Car (inner classes only)
+        self.type_checked: Set[str] = set()  # classes used with 'is' or 'as' anywhere in the package
+        self.cast_classes: Set[str] = set()  # classes used with a throwing 'as'
+        self.aliases: Dict[str, str] = {}  # variables renamed while narrowed by 'is'
+        self.current_enum = None
+        self.required_imports: Set[str] = set()  # imports needed by generated helpers
+        self.functions: Dict[str, FuncDecl] = {}
+        self.scopes: List[Dict[str, str]] = [{}]  # variable name -> Go type, innermost last
+        self.nil_states: List[Dict[str, Optional[bool]]] = [{}]  # p, this.boss -> may be nil, alongside scopes
+        self.warnings: List[Diagnostic] = []  # diagnostics that do not stop the transpilation
+        self.warning_levels = dict(warning_levels or {})  # warning ID -> severity replacing its default
         self.exception_types: Set[str] = set()
         self.current_class = None
         self.current_receiver = 'this'
+        self.current_iterator: Optional[List[str]] = None  # element types while emitting an iterator body
+        self.current_return_type: Optional[str] = None  # result type of the function body being emitted
+        self.current_handler: Optional[str] = None  # 'catch' or 'finally' while emitting an exception handler body
+        self.breakables: List[Dict] = []  # enclosing loops, switches and selects of the function being emitted
+        self.jump_frames: List[Dict] = []  # enclosing try/using bodies (function literals) and the jumps leaving them
+        self.pending_label: Optional[LabeledStmt] = None  # label for the loop, switch or select emitted next
+        self.do_labels = 0  # goto labels emitted for continue in do-while loops
+        self.value_context = False  # True while emitting the arms of a match that yields a value
         self.project_mode = project_mode  # If True, does not generate exception types
+        self.exceptions_package: Optional[str] = None  # package declaring the exception runtime in project mode
+        self.embed_pointers = embed_pointers  # If True, base classes are embedded as *Base (no base struct copies)
+        self.release = release  # If True, unreachable code, uncalled methods and unused classes are left out
+        self.receiver = receiver  # receiver name of generated methods, one of RECEIVER_STYLES
+        self.inline_accessors = inline_accessors  # If True, calls to trivial getters and setters become field accesses
+        self.emit_class_runtime = True  # False when a sibling file already declares the class registry
+        self.uses_class_metadata = False
+        self.emit_string_runtime = True  # False when a sibling file already declares the string helpers
+        self.uses_string_runtime = False
+        self.uses_goroutine_runtime = False
+        self.current_package = 'main'
+        self.current_program = None
+        self.generator = ClassGenerator(self.classes, embed_pointers)
+        self.plugins = list(plugins)  # code generation plugins, their hooks called in this order
+        self.plugin_context = PluginContext(self)
+        
+    def transpile(self, program: Program) -> str:
+        """Transpiles the program to Go"""
+        self.output = []
+        self.origin = None
+        self.indent_level = 0
+        self.scopes = [{}]
+        self.nil_states = [{}]
+        self.warnings = []
+        self.required_imports = set()
+        self.uses_class_metadata = False
+        self.uses_string_runtime = False
+        self.uses_goroutine_runtime = False
+        self.current_package = program.package
+        self.current_program = program
+        check_nowarn(program)
+        
+        # First pass: collect class information
+        self._collect_classes(program)
+        
+        # Verify declared interface conformance before generating anything
+        self._verify_interfaces(program)
+        
+        # Undefined names are collected here, undefined members (whose class is only known from the types of
+        # the generated code) while generating it: all of them are reported together once it is generated
+        self.unresolved = []
+        self._resolve_names(program)
+        
+        if not self.unresolved:
+            # Suspicious declarations are reported at the go-plus lines, not left to go vet on the generated code
+            self._diagnose(program)
+            self._eliminate_dead_code(program)
+            
+            # Plugins see the checked AST last
+            for plugin in self.plugins:
+                try:
+                    plugin.transform(program, self.plugin_context)
+                except PluginError as e:
+                    raise self._error(str(e), e.node or program)
+        
+        # Second pass: generate code
+        try:
+            self._emit_program(program)
+        except TranspilerError:
+            # Code using undefined names can fail to generate further on: the names are the error to report
+            if not self.unresolved:
+                raise
+        if self.unresolved:
+            raise TranspilerError('\n'.join(self.unresolved), *self.unresolved)
+        
+        self.line_origins = self.origins(self.output)
+        return '\n'.join(self.output)
+    
+    def origins(self, lines: List[str]) -> List[Optional[Tuple[Optional[str], int, int, int, int]]]:
+        """The go-plus span each generated line comes from, one entry per line of '\\n'.join(lines)"""
+        spans = []
+        for line in lines:
+            origin = getattr(line, 'origin', None)
+            span = (origin.file or self.source_file, origin.line, origin.column, origin.end_line,
+                    origin.end_column) if origin else None
+            spans.extend([span] * (line.count('\n') + 1))
+        return spans
+    
+    def _collect_classes(self, program: Program) -> None:
+        """Collects information about classes and exceptions"""
+        self.register_declarations(program)
+        if self.nested_types:
+            self._rewrite_types(program, self.nested_types)
+        self._hoist_anonymous_classes(program)
+        for registered in self.registered_programs:
+            self._resolve_nullable_types(registered)
+        
+        # Mixin and generated members (data classes, ...) must exist before any check or inference
+        # (classes of imported packages were already expanded by their own package)
+        local_classes = [decl for decl in self.classes.values() if decl.name not in self.foreign_classes]
+        for decl in local_classes:
+            self._apply_mixins(decl)
+        for decl in local_classes:
+            self._check_annotations(decl)
+            # Classes of sibling files were already expanded, by the plugins too, when a file before this one was
+            # transpiled
+            expanded = decl.expanded
+            try:
+                self.generator.expand(decl)
+                for plugin in self.plugins if not expanded else []:
+                    plugin.expand_class(decl, self.plugin_context)
+            except GeneratorError as e:
+                raise self._error(str(e), decl)
+            except PluginError as e:
+                raise self._error(str(e), e.node or decl)
+        for builder in self.generator.builders.values():
+            self.classes.setdefault(builder.name, builder)
+        self._register_conversions(local_classes)
+        
+        # Detect exception usage
+        self._detect_exceptions(program)
+    
+    def register_declarations(self, program: Program) -> None:
+        """Registers classes and interfaces (also used for sibling files of a package)"""
+        if any(registered is program for registered in self.registered_programs):
+            return
+        self.registered_programs.append(program)
+        self._hoist_nested_classes(program)
+        if program.bodies:
+            self.type_checked |= program.bodies.type_checks
+            self.cast_classes |= program.bodies.casts
+        else:
+            self._collect_type_checks(program, self.type_checked, self.cast_classes)
+        
+        for decl in program.declarations:
+            if isinstance(decl, ClassDecl) and decl.is_partial:
+                self._register_partial(decl)
+            elif isinstance(decl, ClassDecl):
+                if decl.name in self.partial_classes:
+                    raise TranspilerError(
+                        f"Class {decl.name} is declared both partial and non-partial (every part must be partial)")
+                self.classes[decl.name] = decl
+            elif isinstance(decl, InterfaceDecl):
+                self.interfaces[decl.name] = decl
+            elif isinstance(decl, FuncDecl):
+                self.functions[decl.name] = decl
+            elif isinstance(decl, EnumDecl):
+                self.enums[decl.name] = decl
+            elif isinstance(decl, ConstDecl):
+                self.constants[decl.name] = decl
+            elif isinstance(decl, TypeDecl) and decl.variants:
+                self.unions[decl.name] = decl
+            elif isinstance(decl, TypeDecl) and decl.is_alias:
+                self.type_aliases[decl.name] = decl.type
+            elif isinstance(decl, MixinDecl):
+                self.mixins[decl.name] = decl
+            elif isinstance(decl, AnnotationDecl):
+                if decl.name in ClassGenerator.ANNOTATIONS | ClassGenerator.FIELD_ANNOTATIONS or \
+                        decl.name in self.annotation_decls:
+                    raise self._error(f"Annotation @{decl.name} is already declared", decl)
+                self.annotation_decls[decl.name] = decl
+            elif isinstance(decl, ObjectDecl):
+                backing = self.objects.get(decl.name) or \
+                    ClassDecl(f'{decl.name}Object', None, decl.fields, decl.methods, None, decl.implements)
+                self.objects[decl.name] = backing
+                self.classes[backing.name] = backing
+    
+    def release_bodies(self, program: Program) -> None:
+        """Frees the bodies of the functions, methods and constructors of a file once it is transpiled in a project
+        build: only its own Go is generated from them. What the other files of the package read of them is kept in
+        program.bodies, and one-statement methods are kept whole, for --inline-accessors. Mixins are copied into
+        the classes using them, and a part of a partial class emits members of the other parts, so they keep theirs"""
+        if program.bodies:
+            return
+        members: Set[str] = set()
+        self._collect_field_uses(program, members)
+        checked: Set[str] = set()
+        casts: Set[str] = set()
+        self._collect_type_checks(program, checked, casts)
+        for decl in program.declarations:
+            if isinstance(decl, ClassDecl):
+                self._collect_generated_uses(decl, members)
+        program.bodies = BodySummary(members, self._referenced_words(program.declarations), checked, casts)
+        
+        for decl in program.declarations:
+            if isinstance(decl, FuncDecl):
+                decl.body = BlockStmt([])
+            if not isinstance(decl, (ClassDecl, ObjectDecl, EnumDecl)):
+                continue
+            for method in decl.methods:
+                if len(method.body.statements) != 1:
+                    method.body = BlockStmt([])
+            if isinstance(decl, ClassDecl) and not decl.is_partial:
+                if decl.constructor:
+                    decl.constructor.body = BlockStmt([])
+                for conversion in decl.conversions:
+                    conversion.body = BlockStmt([])
+    
+    def _check_annotations(self, decl: ClassDecl) -> None:
+        """Rejects unknown annotations on a class and its methods and checks the arguments of declared ones
+        (built-in generating annotations and those of plugins check their own)"""
+        class_annotations = ClassGenerator.ANNOTATIONS.union(*(p.class_annotations for p in self.plugins))
+        method_annotations = ClassGenerator.METHOD_ANNOTATIONS.union(*(p.method_annotations for p in self.plugins))
+        for annotation in decl.annotations:
+            self._check_annotation(annotation, class_annotations, f'class {decl.name}')
+        for method in decl.methods:
+            for annotation in method.annotations:
+                self._check_annotation(annotation, method_annotations, f'method {decl.name}.{method.name}')
+    
+    def _check_annotation(self, annotation: Annotation, built_in: Set[str], target: str) -> None:
+        """Checks one annotation against the built-in ones for its target and the declared ones"""
+        if annotation.name == 'deprecated':
+            params = [Parameter('message', 'string')]
+            if not annotation.args:
+                return
+        elif annotation.name in built_in:
+            return
+        elif annotation.name in self.annotation_decls:
+            params = self.annotation_decls[annotation.name].params
+        else:
+            raise self._error(f"Unknown annotation @{annotation.name} on {target}", annotation)
+        
+        if len(annotation.args) != len(params):
+            wanted = ', '.join(p.type for p in params)
+            raise self._error(f"Wrong number of arguments to @{annotation.name} on {target}: "
+                              f"have {len(annotation.args)}, want {len(params)} ({wanted})", annotation)
+        for arg, param in zip(annotation.args, params):
+            # Arguments end up in the class descriptor, initialized at package level
+            constant = arg.operand if isinstance(arg, UnaryExpr) and arg.operator == '-' else arg
+            if not isinstance(constant, Literal):
+                raise self._error(f"Argument {self._expr_to_string(arg)} to @{annotation.name} "
+                                  f"must be a constant", annotation)
+            self._check_assignable(arg, self._infer_type(arg), param.type, f'argument to @{annotation.name}', annotation)
+    
+    def register_package_classes(self, package: str, classes: Dict[str, ClassDecl]) -> None:
+        """Makes the classes of an imported package known by qualified name (models.Person), e.g. as base classes"""
+        for name, decl in classes.items():
+            if '.' in name:
+                continue  # classes that package imports itself
+            foreign = copy.copy(decl)
+            foreign.name = f'{package}.{name}'
+            # Its own base class is named relative to its package
+            if decl.extends and '.' not in self._split_type_args(decl.extends)[0]:
+                foreign.extends = f'{package}.{decl.extends}'
+            self.classes[foreign.name] = foreign
+            self.foreign_classes[foreign.name] = package
+    
+    def _check_exported(self, expr: SelectorExpr, object_type: Optional[str]) -> None:
+        """Rejects access to an unexported member declared by a class of another package (this.age on models.Person)"""
+        if expr.field[:1].isupper():
+            return
+        info = self._class_info(object_type)
+        if not info:
+            return
+        for cls, _ in self._class_chain(info[0].name, info[1]):
+            if any(member.name == expr.field for member in cls.fields + cls.methods):
+                if cls.name in self.foreign_classes:
+                    raise TranspilerError(f"{cls.name}.{expr.field} is not exported and cannot be accessed "
+                                          f"from package {self.current_package}")
+                return
+    
+    def _verify_interfaces(self, program: Program) -> None:
+        """Checks that every class implements the interfaces it declares"""
+        for decl in program.declarations:
+            if not isinstance(decl, ClassDecl):
+                continue
+            
+            for iface_name in decl.implements:
+                base_name, type_args = self._split_type_args(iface_name)
+                
+                # Interfaces from other packages are checked by the Go compiler via the static assertion
+                if '.' in base_name:
+                    continue
+                
+                iface = self.interfaces.get(base_name)
+                if not iface:
+                    raise TranspilerError(f"Class {decl.name} implements undefined interface {iface_name}")
+                
+                iface_mapping = self._type_mapping(iface.type_params, type_args)
+                methods = self._class_method_set(decl.name)
+                for sig in iface.methods:
+                    if sig.name not in methods:
+                        raise TranspilerError(
+                            f"Class {decl.name} does not implement {iface_name} (missing method {sig.name})")
+                    
+                    method, mapping = methods[sig.name]
+                    have = self._signature_string(method.params, method.return_type, mapping)
+                    want = self._signature_string(sig.params, sig.return_type, iface_mapping)
+                    if have != want:
+                        raise TranspilerError(
+                            f"Class {decl.name} does not implement {iface_name} (wrong type for method {sig.name})\n"
+                            f"    have {sig.name}{have}\n"
+                            f"    want {sig.name}{want}")
+    
+    def _class_chain(self, class_name: str,
+                     mapping: Optional[Dict[str, str]] = None) -> List[Tuple[ClassDecl, Dict[str, str]]]:
+        """Returns a class and its ancestors, each with the type arguments it is instantiated with"""
+        chain = []
+        visited = set()
+        current = self.classes.get(class_name)
+        mapping = mapping or {}
+        while current and current.name not in visited:
+            visited.add(current.name)
+            chain.append((current, mapping))
+            if not current.extends:
+                break
+            
+            parent_name, type_args = self._split_type_args(current.extends)
+            current = self.classes.get(parent_name)
+            if current:
+                type_args = [self._substitute_type(arg, mapping) for arg in type_args]
+                mapping = self._type_mapping(current.type_params, type_args)
+        return chain
+    
+    def _class_method_set(self, class_name: str,
+                          mapping: Optional[Dict[str, str]] = None) -> Dict[str, Tuple[MethodDecl, Dict[str, str]]]:
+        """Returns the methods of a class (with the type arguments of the class that declares them),
+        including the ones promoted from its parents"""
+        methods: Dict[str, Tuple[MethodDecl, Dict[str, str]]] = {}
+        for cls, cls_mapping in reversed(self._class_chain(class_name, mapping)):
+            for method in cls.methods:
+                methods[method.name] = (method, cls_mapping)
+        return methods
+    
+    def _signature_string(self, params: List[Parameter], return_type: Optional[str],
+                          mapping: Optional[Dict[str, str]] = None) -> str:
+        """Formats a method signature (parameter types and return type) for comparison"""
+        mapping = mapping or {}
+        signature = '(' + ', '.join(self._substitute_type(p.type, mapping) for p in params) + ')'
+        if return_type:
+            signature += f' {self._substitute_type(return_type, mapping)}'
+        return signature
+    
+    # ------------------------------------------------------------------------
+    # Name resolution
+    # ------------------------------------------------------------------------
+    
+    # Predeclared Go identifiers: builtin functions, constants and types (int(x), string(b))
+    GO_PREDECLARED = {'append', 'cap', 'clear', 'close', 'complex', 'copy', 'delete', 'imag', 'len', 'make', 'max',
+                      'min', 'new', 'panic', 'print', 'println', 'real', 'recover', 'nil', 'true', 'false', 'iota',
+                      'any', 'bool', 'byte', 'comparable', 'complex64', 'complex128', 'error', 'float32', 'float64',
+                      'int', 'int8', 'int16', 'int32', 'int64', 'rune', 'string', 'uint', 'uint8', 'uint16',
+                      'uint32', 'uint64', 'uintptr'}
+    
+    # Declarations of the emitted runtime that go-plus code may use
+    RUNTIME_NAMES = {'Exception', 'BaseException', 'NewException', 'RecoverGoroutine', 'ClassInfo', 'AnnotationInfo',
+                     'RegisterClass', 'LookupClass'}
+    
+    # Declarations of the exception runtime, qualified with the exceptions package in project mode
+    EXCEPTION_RUNTIME_NAMES = {'Exception', 'BaseException', 'NewException', 'RecoverGoroutine',
+                               'UncaughtExceptionHandler'}
+    
+    # Standard packages imported when a file uses them without an import, by package name
+    STANDARD_PACKAGES = {name: name for name in (
+        'bufio', 'bytes', 'cmp', 'context', 'errors', 'flag', 'fmt', 'io', 'iter', 'log', 'maps', 'math', 'net',
+        'os', 'path', 'reflect', 'regexp', 'runtime', 'slices', 'sort', 'strconv', 'strings', 'sync', 'time',
+        'unicode')}
+    STANDARD_PACKAGES.update({
+        'atomic': 'sync/atomic', 'base64': 'encoding/base64', 'big': 'math/big', 'bits': 'math/bits',
+        'csv': 'encoding/csv', 'exec': 'os/exec', 'filepath': 'path/filepath', 'hex': 'encoding/hex',
+        'http': 'net/http', 'json': 'encoding/json', 'rand': 'math/rand', 'sha256': 'crypto/sha256',
+        'signal': 'os/signal', 'url': 'net/url', 'utf8': 'unicode/utf8'})
+    
+    def _resolve_names(self, program: Program) -> None:
+        """Checks that every identifier refers to a declaration (a local, a package member, an imported
+        package or a predeclared name), so no Go code with undefined names is emitted. A standard package used
+        without an import (fmt.Println) is imported; unresolved names are collected in self.unresolved"""
+        names = self._package_names(program)
+        if names is None:
+            return
+        for decl in program.declarations:
+            self._resolve_declaration(decl, [names])
+    
+    def _package_names(self, program: Program) -> Optional[Set[str]]:
+        """Names visible at package level: declarations of every file of the package, the packages this
+        file imports and the predeclared ones. None when an import hides its names (import . "pkg") or its
+        package name cannot be told from the path"""
+        names = set(self.GO_PREDECLARED) | self.RUNTIME_NAMES
+        for imp in program.imports:
+            name = package_name(imp.path, imp.alias)
+            if name == '.' or not re.fullmatch(r'[A-Za-z_]\w*', name):
+                return None
+            names.add(name)
+        
+        names |= self.declared_names(self.registered_programs)
+        # Classes the package generates (builders, anonymous and hoisted nested classes) included
+        names.update(self.classes, self.interfaces, self.enums, self.objects, self.unions, self.type_aliases)
+        names.update(f'New{name}' for name in self.classes)
+        return names
+    
+    @staticmethod
+    def declared_names(programs: Sequence[Program]) -> Set[str]:
+        """Package-level names declared by the files of a package, with the generated functions code can
+        call by their Go name: constructors (NewPerson), enum helpers (ColorValues) and union wrappers
+        (NumberOf)"""
+        names = set()
+        for program in programs:
+            for decl in program.declarations:
+                name = getattr(decl, 'name', None)
+                if not name:
+                    continue
+                names.add(name)
+                if isinstance(decl, ClassDecl):
+                    names.add(f'New{name}')
+                elif isinstance(decl, EnumDecl):
+                    names.update((f'{name}Values', f'{name}FromString'))
+                elif isinstance(decl, TypeDecl) and decl.variants:
+                    names.add(f'{name}Of')
+        return names
+    
+    def register_package_symbols(self, package: str, programs: Sequence[Program],
+                                 classes: Optional[Dict[str, ClassDecl]] = None) -> None:
+        """Makes the names an imported project package declares known, so models.Techer or an
+        unqualified NewValidator() is reported here rather than by go build"""
+        names = self.declared_names(programs)
+        for name in classes or {}:
+            if '.' not in name:
+                names.update((name, f'New{name}'))
+        self.package_symbols[package] = names
+    
+    def _undefined(self, node: ASTNode, message: str, fix: Optional[str] = None, code: str = 'GP0002') -> Diagnostic:
+        """An undefined-name diagnostic, which reads led by file:line:column when the source file is known"""
+        text = f'{message} ({fix})' if fix else message
+        source = node.file or self.source_file
+        text = f'{source}:{node.line}:{node.column}: {text}' if source and node.line else \
+            f'{text} ({self._position(node)})'
+        return error_diagnostic(code, message, node, fix, text)
+    
+    def _undefined_name(self, node: ASTNode, name: str, scopes: List[Set[str]]) -> Diagnostic:
+        """Diagnostic for an undeclared name, pointing at the imported package that declares it (NewValidator
+        is utils.NewValidator), or else at the visible names closest to it (locals, members, package
+        declarations and packages)"""
+        owners = sorted(package for package, names in self.package_symbols.items() if name in names)
+        if owners and name[:1].isupper():
+            return self._undefined(node, f'undefined: {name}',
+                                   f'declared in package {owners[0]}: write {owners[0]}.{name}')
+        visible = set(self.STANDARD_PACKAGES).union(*scopes)
+        return self._undefined(node, f'undefined: {name}', did_you_mean(name, visible))
+    
+    def _undefined_package_member(self, node: ASTNode, package: str, name: str) -> Diagnostic:
+        """Diagnostic for a name an imported project package does not declare, with its closest exported names"""
+        exported = {n for n in self.package_symbols[package] if n[:1].isupper()}
+        return self._undefined(node, f'undefined: {package}.{name}', did_you_mean(name, exported))
+    
+    def _member_span(self, expr: SelectorExpr) -> ASTNode:
+        """The span of the member name of a selector (Nmae of p.Nmae), where errors about the member point"""
+        if not expr.end_line or expr.end_column <= len(expr.field):
+            return expr
+        return position(expr.file, expr.end_line, expr.end_column - len(expr.field), expr.end_line, expr.end_column)
+    
+    def _resolve_package_member(self, node: SelectorExpr) -> None:
+        """Checks that pkg.Name names an exported declaration of an imported project package"""
+        package, name = node.object.name, node.field
+        if name not in self.package_symbols[package]:
+            self.unresolved.append(self._undefined_package_member(self._member_span(node), package, name))
+        elif not name[:1].isupper():
+            self.unresolved.append(self._undefined(self._member_span(node),
+                                                   f'{package}.{name} is not exported by package {package}',
+                                                   code='GP0003'))
+    
+    def _check_member(self, expr: SelectorExpr, object_type: Optional[str]) -> None:
+        """Reports a field or method that the class of a value does not have (p.Nmae on *Person) with the undefined
+        names; classes whose base is not a known class (an embedded Go type) are not checked"""
+        info = self._class_info(object_type)
+        if not info:
+            return
+        chain = self._class_chain(info[0].name, info[1])
+        if chain[-1][0].extends:
+            return
+        members = set()
+        for cls, _ in chain:
+            members |= self._member_names(cls)
+        if expr.field in members:
+            return
+        error = self._undefined(self._member_span(expr), f'undefined: {info[0].name}.{expr.field}',
+                                did_you_mean(expr.field, members))
+        if error not in self.unresolved:  # a member expression can be generated more than once
+            self.unresolved.append(error)
+    
+    def _member_names(self, cls: ClassDecl) -> Set[str]:
+        """Fields and methods of a class itself (not its bases), including the generated ones"""
+        names = {member.name for member in cls.fields + cls.methods} | {'GetType'}
+        names.update(c.name for c in cls.constants)
+        if any(m.name == 'IndexLookup' and m.operator for m in cls.methods):
+            names.add('IndexGet')
+        for event in cls.events:
+            names.update(prefix + event.name for prefix in ('', 'Add', 'Remove', 'Raise'))
+        if cls.extends:
+            names.add(self._split_type_args(cls.extends)[0].split('.')[-1])
+        return names
+    
+    def _resolve_declaration(self, decl: Declaration, scopes: List[Set[str]]) -> None:
+        """Resolves the names used by a package-level declaration"""
+        if isinstance(decl, FuncDecl):
+            self._resolve_function(decl.params, decl.body, scopes + [{p.name for p in decl.type_params}])
+        elif isinstance(decl, (VarDecl, ConstDecl)):
+            self._resolve_node(decl.value, scopes)
+        elif isinstance(decl, (ClassDecl, ObjectDecl, EnumDecl)):
+            self._resolve_class(decl, scopes)
+    
+    def _resolve_class(self, decl, scopes: List[Set[str]]) -> None:
+        """Resolves the names used by the members of a class, object or enum; 'this' is available in
+        methods, 'super' when there is a base class, the class constants and 'outer' (inner classes) by
+        their bare names"""
+        members = {'this'}
+        if getattr(decl, 'extends', None):
+            members.add('super')
+        members.update(c.name for c in getattr(decl, 'constants', []))
+        members.update(p.name for p in getattr(decl, 'type_params', []))
+        if decl.name in self.inner_classes:
+            members.add('outer')
+        class_scopes = scopes + [members]
+        
+        # Field values of an anonymous class are evaluated where the instance is created
+        if not getattr(decl, 'is_anonymous', False):
+            for f in decl.fields:
+                if isinstance(f, ClassField):
+                    self._resolve_node(f.value, class_scopes)
+        for constant in getattr(decl, 'constants', []):
+            self._resolve_node(constant.value, class_scopes)
+        for member in getattr(decl, 'members', []):
+            self._resolve_node(member.args, scopes)
+        for method in decl.methods:
+            self._resolve_function(method.params, method.body, class_scopes + [{p.name for p in method.type_params}])
+        constructor = getattr(decl, 'constructor', None)
+        if constructor:
+            self._resolve_function(constructor.params, constructor.body, class_scopes)
+        for conversion in getattr(decl, 'conversions', []):
+            self._resolve_function(conversion.params, conversion.body, scopes)
+        for block in getattr(decl, 'init_blocks', []):
+            self._resolve_node(block, class_scopes)
+        if getattr(decl, 'destructor', None):
+            self._resolve_node(decl.destructor, class_scopes)
+        # 'this' in a static block is reported by _emit_static_block
+        for block in getattr(decl, 'static_blocks', []):
+            self._resolve_node(block, class_scopes)
+    
+    def _resolve_function(self, params: List[Parameter], body, scopes: List[Set[str]]) -> None:
+        """Resolves a function body with its parameters in scope"""
+        self._resolve_node(body, scopes + [{p.name for p in params}])
+    
+    def _resolve_node(self, node, scopes: List[Set[str]]) -> None:
+        """Resolves the names of a statement or expression subtree; statements declaring names (:=, var,
+        for, catch, ...) extend the innermost scope"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._resolve_node(item, scopes)
+            return
+        if not isinstance(node, ASTNode):
+            return
+        
+        if isinstance(node, Identifier):
+            self._resolve_identifier(node, scopes)
+        elif isinstance(node, (ThisExpr, SuperExpr)):
+            keyword = 'this' if isinstance(node, ThisExpr) else 'super'
+            if not any(keyword in scope for scope in scopes):
+                where = 'outside a class method' if keyword == 'this' else 'in a class without a base class'
+                message = f"'{keyword}' used {where}"
+                self.unresolved.append(error_diagnostic('GP0003', message, node,
+                                                        text=f"{message} ({self._position(node)})"))
+        elif isinstance(node, BlockStmt):
+            self._resolve_node(node.statements, scopes + [set()])
+        elif isinstance(node, (CaseStmt, DefaultStmt, SelectCase)):
+            self._resolve_case(node, scopes)
+        elif isinstance(node, VarStmt):
+            self._resolve_node(node.value, scopes)
+            scopes[-1].add(node.name)
+        elif isinstance(node, AssignStmt):
+            self._resolve_node(node.value, scopes)
+            if node.operator == ':=':
+                targets = node.target.elements if isinstance(node.target, TupleExpr) else [node.target]
+                scopes[-1].update(t.name for t in targets if isinstance(t, Identifier))
+            else:
+                self._resolve_node(node.target, scopes)
+        elif isinstance(node, ForStmt):
+            inner = scopes + [set()]
+            self._resolve_node([node.init, node.condition, node.update, node.body], inner)
+        elif isinstance(node, (ForInStmt, RangeStmt)):
+            self._resolve_node(node.iterable, scopes)
+            variables = node.variables if isinstance(node, ForInStmt) else [node.key, node.value]
+            self._resolve_node(node.body, scopes + [set(variables) - {None}])
+        elif isinstance(node, CatchStmt):
+            self._resolve_node(node.body, scopes + [{node.exception_var} - {None}])
+        elif isinstance(node, (UsingStmt, WithStmt)):
+            self._resolve_node(node.value, scopes)
+            self._resolve_node(node.body, scopes + [{node.name} - {None}])
+        elif isinstance(node, LambdaExpr):
+            self._resolve_function(node.params, node.body, scopes)
+        elif isinstance(node, ComprehensionExpr):
+            self._resolve_node(node.iterable, scopes)
+            self._resolve_node([node.element, node.condition, node.key], scopes + [set(node.variables)])
+        elif isinstance(node, MatchArm):
+            bound = set()
+            for pattern in node.patterns:
+                self._resolve_pattern_names(pattern, scopes, bound)
+            self._resolve_node([node.guard, node.body], scopes + [bound])
+        elif isinstance(node, SelectorExpr) and isinstance(node.object, Identifier) and \
+                node.object.name in self.STANDARD_PACKAGES and not any(node.object.name in s for s in scopes):
+            self.required_imports.add(self.STANDARD_PACKAGES[node.object.name])
+        elif isinstance(node, SelectorExpr) and isinstance(node.object, Identifier) and \
+                node.object.name in self.package_symbols and not any(node.object.name in s for s in scopes[1:]):
+            self._resolve_package_member(node)
+        elif isinstance(node, MapLiteral) and node.key_type is None:
+            # The keys of a literal whose type is elided ({X: 3, Y: 4}) may be struct field names
+            for key, value in node.pairs:
+                if not isinstance(key, Identifier):
+                    self._resolve_node(key, scopes)
+                self._resolve_node(value, scopes)
+        elif isinstance(node, NewExpr):
+            package, _, name = self._split_type_args(node.class_name)[0].rpartition('.')
+            if not self._type_name_resolves(node.class_name, scopes):
+                self.unresolved.append(self._undefined_name(node, node.class_name, scopes))
+            elif package in self.package_symbols and not any(package in s for s in scopes[1:]) and \
+                    name not in self.package_symbols[package]:
+                self.unresolved.append(self._undefined_package_member(node, package, name))
+            self._resolve_node(node.args, scopes)
+            if node.body:
+                self._resolve_node([f.value for f in node.body.fields], scopes)
+        elif not isinstance(node, (RawStmt, ClassDecl)):
+            for attr in field_values(node):
+                self._resolve_node(attr, scopes)
+    
+    def _resolve_case(self, node, scopes: List[Set[str]]) -> None:
+        """Resolves a switch or select case; its body is one scope, which a received value (case v := <-ch)
+        is declared in. Case values may name enum members bare"""
+        inner = scopes + [set()]
+        for value in getattr(node, 'values', []):
+            if not (isinstance(value, Identifier) and self._is_enum_member(value.name)):
+                self._resolve_node(value, scopes)
+        self._resolve_node(getattr(node, 'comm', None), inner)
+        self._resolve_node(node.body, inner)
+    
+    def _resolve_pattern_names(self, pattern: Pattern, scopes: List[Set[str]], bound: Set[str]) -> None:
+        """Collects the names a pattern binds and resolves the values it compares with"""
+        if isinstance(pattern, TypePattern) and pattern.binding:
+            bound.add(pattern.binding)
+        elif isinstance(pattern, BindingPattern):
+            bound.add(pattern.name)
+        elif isinstance(pattern, DestructurePattern):
+            for element in pattern.elements:
+                self._resolve_pattern_names(element, scopes, bound)
+        elif isinstance(pattern, ValuePattern):
+            if not (isinstance(pattern.value, Identifier) and self._is_enum_member(pattern.value.name)):
+                self._resolve_node(pattern.value, scopes)
+    
+    def _is_enum_member(self, name: str) -> bool:
+        """Whether a name is a member of some enum (bare members are allowed in cases and match arms)"""
+        return any(member.name == name for enum in self.enums.values() for member in enum.members)
+    
+    def _type_name_resolves(self, name: str, scopes: List[Set[str]]) -> bool:
+        """Whether the class of a new expression is declared (models.Person needs the models import)"""
+        base = self._split_type_args(name)[0]
+        return any(base.split('.')[0] in scope for scope in scopes)
+    
+    def _resolve_identifier(self, node: Identifier, scopes: List[Set[str]]) -> None:
+        """Reports an identifier declared in no enclosing scope"""
+        if node.name == '_' or any(node.name in scope for scope in scopes):
+            return
+        self.unresolved.append(self._undefined_name(node, node.name, scopes))
+    
+    # ------------------------------------------------------------------------
+    # Diagnostics
+    # ------------------------------------------------------------------------
+    
+    def _diagnose(self, program: Program) -> None:
+        """Warns about locals shadowing a field of their class, locals assigned but never read and unexported
+        fields nothing uses, at their go-plus positions (go vet would only see them in the generated code)"""
+        for decl in program.declarations:
+            if isinstance(decl, FuncDecl):
+                self._diagnose_function(decl.params, decl.body)
+            elif isinstance(decl, EnumDecl):
+                for method in decl.methods:
+                    self._diagnose_function(method.params, method.body)
+            elif isinstance(decl, (ClassDecl, ObjectDecl)):
+                cls = self.objects[decl.name] if isinstance(decl, ObjectDecl) else decl
+                fields = {f.name: cls for cls, _ in reversed(self._class_chain(cls.name)) for f in cls.fields}
+                for method in decl.methods:
+                    self._diagnose_function(method.params, method.body, fields)
+                constructor = getattr(decl, 'constructor', None)
+                if constructor:
+                    self._diagnose_function(constructor.params, constructor.body, fields)
+                for block in getattr(decl, 'init_blocks', []) + [getattr(decl, 'destructor', None)]:
+                    if block:
+                        self._diagnose_function([], block, fields)
+                for block in getattr(decl, 'static_blocks', []):
+                    self._diagnose_function([], block)
+                for conversion in getattr(decl, 'conversions', []):
+                    self._diagnose_function(conversion.params, conversion.body)
+        self._check_unused_fields(program)
+    
+    # Exported methods Go or the generated code calls without naming them (fmt, sort, using, with, for-in, ...)
+    def _eliminate_dead_code(self, program: Program) -> None:
+        """Warns about statements after a return, throw, break or continue, unexported methods nothing calls and
+        unexported classes nothing refers to; in release mode they are also left out of the generated code.
+        Exported methods are always kept: a type satisfies Go interfaces implicitly (a Write method makes it an
+        io.Writer for fmt.Fprintf), so nothing here can tell they are not called"""
+        self._strip_unreachable(program.declarations)
+        
+        called = self._package_member_uses()
+        called.update(m.name for interface in self.interfaces.values() for m in interface.methods)
+        for decl in program.declarations:
+            if not isinstance(decl, (ClassDecl, ObjectDecl)):
+                continue
+            kept = []
+            for method in decl.methods:
+                if method.line and not method.operator and not method.annotations and method.name not in called \
+                        and not method.name[:1].isupper():
+                    self._warn('GP1005', f"method {self._display_name(decl.name)}.{method.name} is never called",
+                               method)
+                    if self.release:
+                        continue
+                kept.append(method)
+            decl.methods = kept
+        
+        # A class only referred to by unused classes is unused too
+        words = {id(decl): self._referenced_words(decl) for decl in program.declarations}
+        for registered in self.registered_programs:
+            if registered is not program:
+                words[id(registered)] = registered.bodies.words if registered.bodies else \
+                    self._referenced_words(registered.declarations)
+        unused = set()
+        changed = True
+        while changed:
+            changed = False
+            for decl in program.declarations:
+                if isinstance(decl, ClassDecl) and id(decl) not in unused and not decl.name[:1].isupper() and \
+                        not decl.is_anonymous and not decl.static_blocks and decl.line and \
+                        not any(decl.name in names for key, names in words.items() if key != id(decl) and key not in unused):
+                    unused.add(id(decl))
+                    changed = True
+        for decl in program.declarations:
+            if id(decl) in unused:
+                self._warn('GP1006', f"class {self._display_name(decl.name)} is never used", decl)
+        if self.release:
+            program.declarations = [decl for decl in program.declarations if id(decl) not in unused]
+    
+    def _strip_unreachable(self, node) -> None:
+        """Warns about the statements of a block or case that follow one leaving it (return, throw, break,
+        continue), removing them in release mode"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._strip_unreachable(item)
+            return
+        if not isinstance(node, ASTNode) or isinstance(node, RawStmt):
+            return
+        holder = 'statements' if isinstance(node, BlockStmt) else \
+            'body' if isinstance(node, (CaseStmt, DefaultStmt, SelectCase)) else None
+        if holder:
+            statements = getattr(node, holder)
+            end = next((i + 1 for i, stmt in enumerate(statements) if self._leaves_block(stmt)), len(statements))
+            if end < len(statements):
+                self._warn('GP1007', "unreachable code", statements[end])
+                if self.release:
+                    setattr(node, holder, statements[:end])
+        for attr in field_values(node):
+            self._strip_unreachable(attr)
+    
+    def _referenced_words(self, node) -> Set[str]:
+        """Names a subtree may refer to: its identifiers and the words of the types, classes and members it
+        spells out"""
+        words = set()
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                words |= self._referenced_words(item)
+        elif isinstance(node, str):
+            words.update(re.findall(r'[A-Za-z_]\w*', node))
+        elif isinstance(node, ASTNode):
+            for attr in field_values(node):
+                words |= self._referenced_words(attr)
+        return words
+    
+    def _diagnose_function(self, params: List[Parameter], body, fields: Optional[Dict[str, ClassDecl]] = None) -> None:
+        """Diagnoses the locals of a function body; fields are those of the class whose member it is"""
+        self._diagnose_scope(body, [{p.name: [None, True] for p in params}], fields)
+    
+    def _diagnose_scope(self, nodes, scopes: List[Dict[str, list]], fields: Optional[Dict[str, ClassDecl]],
+                        names: Sequence[str] = ()) -> None:
+        """Diagnoses nodes in a new scope holding names (loop variables, bound names, ...), then warns about the
+        locals declared in it that were never read"""
+        scope = {name: [None, True] for name in names if name}
+        self._diagnose_node(nodes, scopes + [scope], fields)
+        for name, (node, read) in scope.items():
+            if node and not read:
+                self._warn('GP1003', f"{name} is assigned but never read", node)
+    
+    def _diagnose_node(self, node, scopes: List[Dict[str, list]], fields: Optional[Dict[str, ClassDecl]]) -> None:
+        """Diagnoses a statement or expression subtree: declarations (:=, var) add a local, each scope is
+        [declaring node or None, whether it was read] by name. Assigning (x = 1, x += 1, x++) is not reading"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._diagnose_node(item, scopes, fields)
+            return
+        if not isinstance(node, ASTNode):
+            return
+        
+        if isinstance(node, Identifier):
+            local = next((scope[node.name] for scope in reversed(scopes) if node.name in scope), None)
+            if local:
+                local[1] = True
+        elif isinstance(node, BlockStmt):
+            self._diagnose_scope(node.statements, scopes, fields)
+        elif isinstance(node, VarStmt):
+            self._diagnose_node(node.value, scopes, fields)
+            self._declare_local(node.name, node, scopes, fields)
+        elif isinstance(node, (AssignStmt, IncDecStmt)):
+            self._diagnose_node(getattr(node, 'value', None), scopes, fields)
+            targets = node.target.elements if isinstance(node.target, TupleExpr) else [node.target]
+            for target in targets:
+                if not isinstance(target, Identifier):
+                    self._diagnose_node(target, scopes, fields)
+                elif node.operator == ':=' and target.name not in scopes[-1]:
+                    self._declare_local(target.name, target if target.line else node, scopes, fields)
+        elif isinstance(node, ForStmt):
+            self._diagnose_scope([node.init, node.condition, node.update, node.body], scopes, fields)
+        elif isinstance(node, (ForInStmt, RangeStmt)):
+            self._diagnose_node(node.iterable, scopes, fields)
+            variables = node.variables if isinstance(node, ForInStmt) else [node.key, node.value]
+            self._diagnose_scope(node.body, scopes, fields, variables)
+        elif isinstance(node, CatchStmt):
+            self._diagnose_scope(node.body, scopes, fields, [node.exception_var])
+        elif isinstance(node, (UsingStmt, WithStmt)):
+            self._diagnose_node(node.value, scopes, fields)
+            self._diagnose_scope(node.body, scopes, fields, [node.name])
+        elif isinstance(node, LambdaExpr):
+            self._diagnose_scope(node.body, scopes, fields, [p.name for p in node.params])
+        elif isinstance(node, ComprehensionExpr):
+            self._diagnose_node(node.iterable, scopes, fields)
+            self._diagnose_scope([node.element, node.condition, node.key], scopes, fields, node.variables)
+        elif isinstance(node, MatchArm):
+            self._diagnose_node(node.patterns, scopes, fields)
+            bound = [name for pattern in node.patterns for name in self._pattern_bindings(pattern)]
+            self._diagnose_scope([node.guard, node.body], scopes, fields, bound)
+        elif isinstance(node, (CaseStmt, DefaultStmt, SelectCase)):
+            self._diagnose_node(getattr(node, 'values', []), scopes, fields)
+            self._diagnose_scope([getattr(node, 'comm', None), node.body], scopes, fields)
+        elif isinstance(node, NewExpr):
+            self._diagnose_node(node.args, scopes, fields)
+            if node.body:
+                self._diagnose_node([f.value for f in node.body.fields], scopes, fields)
+        elif not isinstance(node, (RawStmt, ClassDecl)):
+            for attr in field_values(node):
+                self._diagnose_node(attr, scopes, fields)
+    
+    def _pattern_bindings(self, pattern: Pattern) -> List[str]:
+        """Names a pattern binds (Circle c, Point(x, _))"""
+        if isinstance(pattern, TypePattern):
+            return [pattern.binding] if pattern.binding else []
+        if isinstance(pattern, BindingPattern):
+            return [pattern.name]
+        if isinstance(pattern, DestructurePattern):
+            return [name for element in pattern.elements for name in self._pattern_bindings(element)]
+        return []
+    
+    def _declare_local(self, name: str, node: ASTNode, scopes: List[Dict[str, list]],
+                       fields: Optional[Dict[str, ClassDecl]]) -> None:
+        """Adds a local to the innermost scope, warning when it hides a field of the class"""
+        if name == '_':
+            return
+        if fields and name in fields:
+            self._warn('GP1002', f"{name} shadows field {self._display_name(fields[name].name)}.{name}", node,
+                       f"use this.{name} for the field")
+        scopes[-1][name] = [node, False]
+    
+    def _check_unused_fields(self, program: Program) -> None:
+        """Warns about unexported fields of the program's classes that no code of the package uses (generated
+        members, such as the Equals of a data class, count)"""
+        used = self._package_member_uses()
+        for decl in program.declarations:
+            if not isinstance(decl, (ClassDecl, ObjectDecl)):
+                continue
+            for f in decl.fields:
+                if f.line and not f.name[:1].isupper() and f.name not in used:
+                    self._warn('GP1004', f"field {self._display_name(decl.name)}.{f.name} is never used", f)
+    
+    def _package_member_uses(self) -> Set[str]:
+        """Member names the code of the package uses (see _collect_field_uses), and those the generated methods of
+        its classes select"""
+        used: Set[str] = set()
+        for registered in self.registered_programs:
+            if registered.bodies:
+                used |= registered.bodies.members
+            else:
+                self._collect_field_uses(registered, used)
+        for cls in self.classes.values():
+            self._collect_generated_uses(cls, used)
+        return used
+    
+    @staticmethod
+    def _collect_generated_uses(cls: ClassDecl, used: Set[str]) -> None:
+        """Collects the member names the generated methods of a class (their Go code) select"""
+        for method in cls.methods:
+            for stmt in method.body.statements:
+                if isinstance(stmt, RawStmt):
+                    used.update(re.findall(r'\.([A-Za-z_]\w*)', stmt.code))
+    
+    def _collect_field_uses(self, node, used: Set[str]) -> None:
+        """Collects the member names a subtree selects (p.name), sets in literals ({name: v}) or replaces (with)"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._collect_field_uses(item, used)
+            return
+        if not isinstance(node, ASTNode):
+            return
+        if isinstance(node, SelectorExpr):
+            used.add(node.field)
+        elif isinstance(node, MapLiteral):
+            used.update(key.name for key, _ in node.pairs if isinstance(key, Identifier))
+        elif isinstance(node, WithExpr):
+            used.update(name for name, _ in node.updates)
+        for attr in field_values(node):
+            self._collect_field_uses(attr, used)
+    
+    # ------------------------------------------------------------------------
+    # Generics helpers
+    # ------------------------------------------------------------------------
+    
+    def _split_type_args(self, type_name: str) -> Tuple[str, List[str]]:
+        """Splits 'Name[A, map[K]V]' into ('Name', ['A', 'map[K]V'])"""
+        if not type_name.endswith(']') or '[' not in type_name or type_name.startswith('['):
+            return type_name, []
+        
+        base, inner = type_name[:type_name.index('[')], type_name[type_name.index('[') + 1:-1]
+        args = []
+        depth = 0
+        current = ''
+        for char in inner:
+            if char in '[(':
+                depth += 1
+            elif char in '])':
+                depth -= 1
+            if char == ',' and depth == 0:
+                args.append(current.strip())
+                current = ''
+            else:
+                current += char
+        if current.strip():
+            args.append(current.strip())
+        return base, args
+    
+    def _type_mapping(self, type_params: List[TypeParam], type_args: List[str]) -> Dict[str, str]:
+        """Maps type parameter names to the type arguments of an instantiation"""
+        return {tp.name: arg for tp, arg in zip(type_params, type_args)}
+    
+    def _substitute_type(self, type_name: str, mapping: Dict[str, str]) -> str:
+        """Replaces type parameters inside a type by their arguments"""
+        if not mapping:
+            return type_name
+        return re.sub(r'\b[A-Za-z_]\w*\b', lambda m: mapping.get(m.group(0), m.group(0)), type_name)
+    
+    def _type_params_string(self, type_params: List[TypeParam]) -> str:
+        """Formats a type parameter list ([T any, K comparable])"""
+        if not type_params:
+            return ''
+        return '[' + ', '.join(f'{tp.name} {tp.constraint}' for tp in type_params) + ']'
+    
+    def _class_type(self, class_name: str) -> str:
+        """Returns the receiver type of a class (Stack[T] for generic classes)"""
+        cls = self.classes.get(class_name)
+        if cls and cls.type_params:
+            return f'{class_name}[' + ', '.join(tp.name for tp in cls.type_params) + ']'
+        return class_name
+    
+    def _is_value_class(self, class_name: Optional[str]) -> bool:
+        """Checks for a @valueclass, whose instances are held by value (Point) instead of by pointer"""
+        cls = self.classes.get(class_name) if class_name else None
+        return bool(cls and any(a.name == 'valueclass' for a in cls.annotations))
+    
+    def _instance_type(self, class_name: str) -> str:
+        """Go type holding an instance of a class: *Stack[T], or Point for a @valueclass"""
+        class_type = self._class_type(class_name)
+        return class_type if self._is_value_class(class_name) else f'*{class_type}'
+    
+    def _zero_instance(self, class_name: str) -> str:
+        """Typed zero value of an instance, as used by static interface assertions: (*Person)(nil), Point{}"""
+        if self._is_value_class(class_name):
+            return f'{self._class_type(class_name)}{{}}'
+        return f'(*{self._class_type(class_name)})(nil)'
+    
+    def _constructor_name(self, class_type: str) -> str:
+        """Returns the constructor for a class type (pkg.Stack[int] -> pkg.NewStack[int])"""
+        base, type_args = self._split_type_args(class_type)
+        package, _, name = base.rpartition('.')
+        constructor = f'{package}.New{name}' if package else f'New{name}'
+        if type_args:
+            constructor += '[' + ', '.join(type_args) + ']'
+        return constructor
+    
+    # ------------------------------------------------------------------------
+    # Type inference (best effort, used for lowering decisions)
+    # ------------------------------------------------------------------------
+    
+    def _push_scope(self) -> None:
+        """Opens a variable scope"""
+        self.scopes.append({})
+        self.nil_states.append({})
+    
+    def _pop_scope(self) -> None:
+        """Closes the innermost variable scope"""
+        self.scopes.pop()
+        self.nil_states.pop()
+    
+    def _declare(self, name: str, type_name: Optional[str], may_be_nil: Optional[bool] = None) -> None:
+        """Records the type of a variable in the innermost scope, and whether it may be nil
+        (None: not tracked, so an outer nullable variable of the same name no longer applies)"""
+        if type_name and type_name.startswith('...'):
+            type_name = '[]' + type_name[3:]  # a variadic parameter is a slice inside the function
+        if type_name and name != '_':
+            self.scopes[-1][name] = type_name
+        if name != '_':
+            self.nil_states[-1][name] = may_be_nil
+    
+    def _lookup(self, name: str) -> Optional[str]:
+        """Finds the type of a variable, innermost scope first"""
+        for scope in reversed(self.scopes):
+            if name in scope:
+                return scope[name]
+        return None
+    
+    def _class_info(self, type_name: Optional[str]) -> Optional[Tuple[ClassDecl, Dict[str, str]]]:
+        """Resolves a (pointer to a) class type to its declaration and type arguments"""
+        if not type_name:
+            return None
+        base, type_args = self._split_type_args(type_name.lstrip('*'))
+        cls = self.classes.get(base)
+        if not cls:
+            return None
+        return cls, self._type_mapping(cls.type_params, type_args)
+    
+    def _class_field_type(self, class_name: str, field_name: str,
+                          mapping: Optional[Dict[str, str]] = None) -> Optional[str]:
+        """Returns the type of a field declared on a class or one of its parents"""
+        for cls, cls_mapping in self._class_chain(class_name, mapping):
+            for field in cls.fields:
+                if field.name == field_name:
+                    return self._substitute_type(field.type, cls_mapping)
+        return None
+    
+    def _infer_type(self, expr: Expression) -> Optional[str]:
+        """Infers the Go type of an expression, or None when it is unknown"""
+        if isinstance(expr, Literal):
+            if expr.go_type:
+                return expr.go_type
+            return {'int': 'int', 'float': 'float64', 'string': 'string', 'bool': 'bool', 'char': 'rune'}.get(expr.type)
+        
+        elif isinstance(expr, InterpolatedString):
+            return 'string'
+        
+        elif isinstance(expr, TernaryExpr):
+            return self._ternary_type(expr)
+        
+        elif isinstance(expr, CoalesceExpr):
+            return self._coalesce_type(expr)
+        
+        elif isinstance(expr, MatchExpr):
+            return self._match_type(expr)
+        
+        elif isinstance(expr, LambdaExpr):
+            return self._lambda_type(expr)
+        
+        elif isinstance(expr, ComprehensionExpr):
+            return self._comprehension_type(expr)
+        
+        elif isinstance(expr, ArrayLiteral):
+            return expr.type
+        
+        elif isinstance(expr, MapLiteral):
+            return f'map[{expr.key_type}]{expr.value_type}' if expr.key_type else None
+        
+        elif isinstance(expr, Identifier):
+            if self._is_outer_reference(expr):
+                return f'*{self.inner_classes[self.current_class]}'
+            if expr.name in self.objects and not self._lookup(expr.name):
+                return f'*{self.objects[expr.name].name}'
+            return self._lookup(expr.name)
+        
+        elif isinstance(expr, (ThisExpr, SuperExpr)):
+            if self.current_enum:
+                return self.current_enum
+            if isinstance(expr, SuperExpr) and not getattr(self.classes.get(self.current_class), 'extends', None):
+                return None  # super without a base class, reported with the undefined names
+            return self._instance_type(self.current_class) if self.current_class else None
+        
+        elif isinstance(expr, NewExpr):
+            if expr.body:
+                return f'*{expr.body.name}'
+            pointer = '' if self._is_value_class(expr.class_name) else '*'
+            if expr.type_args:
+                return f'{pointer}{expr.class_name}[' + ', '.join(expr.type_args) + ']'
+            if '.' in expr.class_name and self._lookup(expr.class_name.split('.')[0]):
+                inner = self._inner_construction(expr)
+                return f'*{inner[0]}' if inner else None
+            return f'{pointer}{expr.class_name}'
+        
+        elif isinstance(expr, WithExpr):
+            return self._infer_type(expr.target)
+        
+        elif isinstance(expr, IsExpr):
+            return 'bool'
+        
+        elif isinstance(expr, CastExpr):
+            conversion = self._cast_conversion(expr)
+            if conversion:
+                return conversion[1]
+            if expr.type.lstrip('*') in self.classes:
+                return self._instance_type(expr.type.lstrip('*'))
+            return expr.type
+        
+        elif isinstance(expr, SelectorExpr):
+            enum = self._enum_reference(expr.object)
+            if enum:
+                return enum.name
+            object_type = self._infer_type(expr.object)
+            if object_type in self.enums:
+                associated = next((f for f in self.enums[object_type].fields if f.name == expr.field), None)
+                return associated.type if associated else None
+            info = self._class_info(object_type)
+            if info:
+                return self._class_field_type(info[0].name, expr.field, info[1])
+            return None
+        
+        elif isinstance(expr, CallExpr):
+            return self._infer_call_type(expr)
+        
+        elif isinstance(expr, IndexExpr):
+            container = self._infer_type(expr.object)
+            if not container:
+                return None
+            getter = self._indexer(container, 'get')
+            if getter:
+                method, mapping = getter
+                return self._substitute_type(self._split_result_types(method.return_type)[0], mapping)
+            if container.startswith('[]'):
+                return container[2:]
+            if container.startswith('map['):
+                _, value_type = self._split_map_type(container)
+                return value_type
+            if container == 'string':
+                return 'byte'
+            return None
+        
+        elif isinstance(expr, BinaryExpr):
+            if expr.operator in ('==', '!=', '<', '<=', '>', '>=', '&&', '||'):
+                return 'bool'
+            operator_method = self._operator_method(self._infer_type(expr.left), expr.operator)
+            if operator_method:
+                method, mapping = operator_method
+                return self._substitute_type(method.return_type, mapping) if method.return_type else None
+            if expr.operator == '+' and 'string' in (self._infer_type(expr.left), self._infer_type(expr.right)):
+                return 'string'
+            if expr.operator in ('<<', '>>'):
+                return self._infer_type(expr.left)
+            return self._infer_type(expr.left) or self._infer_type(expr.right)
+        
+        elif isinstance(expr, UnaryExpr):
+            if expr.operator == '!':
+                return 'bool'
+            operand_type = self._infer_type(expr.operand)
+            if expr.operator == '&':
+                return f'*{operand_type}' if operand_type else None
+            if expr.operator == '*':
+                return operand_type[1:] if operand_type and operand_type.startswith('*') else None
+            if expr.operator == '<-':
+                return self._channel_element(operand_type)
+            if expr.operator == '-':
+                operator_method = self._operator_method(operand_type, 'neg')
+                if operator_method and operator_method[0].return_type:
+                    return self._substitute_type(operator_method[0].return_type, operator_method[1])
+            return operand_type
+        
+        return None
+    
+    def _infer_call_type(self, expr: CallExpr) -> Optional[str]:
+        """Infers the result type of a call (methods, functions and common builtins)"""
+        if isinstance(expr.function, SelectorExpr):
+            enum = self._enum_reference(expr.function.object)
+            if enum and expr.function.field == 'Values':
+                return f'[]{enum.name}'
+            if enum and expr.function.field == 'FromString':
+                return f'({enum.name}, bool)'
+            
+            receiver_type = self._infer_type(expr.function.object)
+            if receiver_type == 'string' and expr.function.field in self.STRING_METHODS:
+                return self.STRING_METHODS[expr.function.field]
+            if receiver_type in self.enums:
+                if expr.function.field == 'String':
+                    return 'string'
+                method = next((m for m in self.enums[receiver_type].methods if m.name == expr.function.field), None)
+                return method.return_type if method else None
+            if receiver_type in self.unions:
+                # Generated accessors: Value(), IsInt(), AsInt()
+                field = expr.function.field
+                if field == 'Value':
+                    return 'any'
+                variant = next((v for v in self._union_variants(self.unions[receiver_type])
+                                if field in (f'Is{self._variant_accessor(v)}', f'As{self._variant_accessor(v)}')), None)
+                return None if not variant else 'bool' if field.startswith('Is') else variant
+            
+            info = self._class_info(receiver_type)
+            if info:
+                methods = self._class_method_set(info[0].name, info[1])
+                if expr.function.field in methods:
+                    method, mapping = methods[expr.function.field]
+                    return self._substitute_type(method.return_type, mapping) if method.return_type else None
+            return None
+        
+        if isinstance(expr.function, Identifier):
+            name = expr.function.name
+            if name == 'len' or name == 'cap':
+                return 'int'
+            if name.endswith('Of') and name[:-2] in self.unions and not self.functions.get(name):
+                return name[:-2]  # the checked constructor of a union
+            if name in ('make', 'append') and expr.args:
+                if isinstance(expr.args[0], TypeExpr):
+                    return expr.args[0].type
+                return self._infer_type(expr.args[0])
+            func = self.functions.get(name)
+            if func and func.return_type and not func.type_params:
+                return func.return_type
+            if func and func.return_type:
+                _, result, mapping, names = self._call_signature(expr)
+                result = self._substitute_type(result, mapping)
+                return None if self._unbound(result, names) else result
+        
+        return None
+    
+    def _split_map_type(self, map_type: str) -> Tuple[str, str]:
+        """Splits 'map[K]V' into ('K', 'V')"""
+        depth = 0
+        for i, char in enumerate(map_type[3:], start=3):
+            if char == '[':
+                depth += 1
+            elif char == ']':
+                depth -= 1
+                if depth == 0:
+                    return map_type[4:i], map_type[i + 1:]
+        return '', ''
+    
+    # ------------------------------------------------------------------------
+    # Operator precedence
+    # ------------------------------------------------------------------------
+    
+    def _binary_to_string(self, expr: BinaryExpr) -> Tuple[str, Optional[int]]:
+        """Converts a binary expression without outer parentheses; returns the code and its precedence
+        (None when the operator was lowered to a call, which never needs parentheses)"""
+        lowered = self._lower_operator(expr)
+        if lowered:
+            return lowered, None
+        
+        # Operations on constants are emitted as their value: Distance * 0.1 -> 25.0, "a" + "b" -> "ab"
+        owner = self.classes.get(self.current_class)
+        constant = self._constant_value(expr, owner)
+        if constant:
+            return self._constant_literal(constant, expr), None
+        # s + "a" + "b" -> s + "ab": concatenation is associative
+        if expr.operator == '+' and isinstance(expr.left, BinaryExpr) and expr.left.operator == '+':
+            inner, outer = self._constant_value(expr.left.right, owner), self._constant_value(expr.right, owner)
+            if inner and outer and inner[1:] == outer[1:] == ('string', None):
+                joined = Literal(inner[0] + outer[0], 'string', line=expr.right.line, column=expr.right.column,
+                                 file=expr.right.file)
+                return self._binary_to_string(BinaryExpr(expr.left.left, '+', joined, line=expr.line,
+                                                         column=expr.column, file=expr.file))
+        
+        precedence = BINARY_PRECEDENCE[expr.operator]
+        concat = self._lower_rune_concat(expr)
+        if concat:
+            return concat, precedence
+        left = self._operand_to_string(expr.left, precedence)
+        # p != nil && p.name != "" evaluates p.name only when p is not nil (and likewise p == nil || ...)
+        tests = self._nil_tests(expr.left, holds=expr.operator == '&&') if expr.operator in ('&&', '||') else []
+        right = self._with_nil_tests(tests, lambda: self._operand_to_string(expr.right, precedence + 1))
+        return f'{left} {expr.operator} {right}', precedence
+    
+    def _operand_to_string(self, expr: Expression, precedence: int) -> str:
+        """Converts an operand of a binary operator, parenthesized only when it binds looser than precedence
+        (the right operand asks for one level more: a - (b - c))"""
+        if isinstance(expr, BinaryExpr) and not self._innermost_optional(expr):
+            code, own = self._binary_to_string(expr)
+            return f'({code})' if own is not None and own < precedence else code
+        return self._expr_to_string(expr)
+    
+    def _unparenthesized(self, expr: Expression) -> str:
+        """Converts an expression where it needs no parentheses of its own: a statement, argument, index or condition"""
+        return self._operand_to_string(expr, 0)
+    
+    def _lower_inc_dec(self, stmt: IncDecStmt) -> str:
+        """x++ stays x++; on an indexer element or a class with an overloaded + or - it is x += 1"""
+        target_type = self._infer_type(stmt.target)
+        indexer = isinstance(stmt.target, IndexExpr) and self._class_info(self._infer_type(stmt.target.object))
+        if indexer or self._class_info(target_type):
+            compound = AssignStmt(stmt.target, Literal(1, 'int'), stmt.operator[0] + '=', line=stmt.line,
+                                  column=stmt.column, file=stmt.file)
+            return self._stmt_to_string(compound)
+        
+        target = self._expr_to_string(stmt.target)
+        if target_type in ('string', 'bool') or (target_type or '').startswith(('[]', 'map[', '*', 'func(', 'chan ')):
+            raise self._error(f"Invalid operation: {target}{stmt.operator} (non-numeric type {target_type})", stmt)
+        self._check_record_assignment(stmt.target)
+        self._check_value_receiver_assignment(stmt.target)
+        if self._innermost_optional(stmt.target):
+            raise self._error(
+                "Cannot assign to an optional chain", self._innermost_optional(stmt.target))
+        return f'{target}{stmt.operator}'
+    
+    # ------------------------------------------------------------------------
+    # Operator overloading
+    # ------------------------------------------------------------------------
+    
+    def _operator_method(self, type_name: Optional[str],
+                         operator: str) -> Optional[Tuple[MethodDecl, Dict[str, str]]]:
+        """Finds the overload of an operator on a class type ('neg' selects unary minus)"""
+        info = self._class_info(type_name)
+        if not info:
+            return None
+        
+        for method, mapping in self._class_method_set(info[0].name, info[1]).values():
+            if not method.operator:
+                continue
+            is_unary = not method.params
+            if operator == 'neg' and method.operator == '-' and is_unary:
+                return method, mapping
+            if operator == method.operator and not is_unary:
+                return method, mapping
+        return None
+    
+    def _lower_operator(self, expr: BinaryExpr) -> Optional[str]:
+        """Lowers a binary operator on class operands to a call of its overload"""
+        left_type = self._infer_type(expr.left)
+        info = self._class_info(left_type)
+        if not info or expr.operator in ('&&', '||'):
+            return None
+        
+        class_name = info[0].name
+        left = self._expr_to_string(expr.left)
+        right = self._unparenthesized(expr.right)
+        
+        overload = self._operator_method(left_type, expr.operator)
+        if overload:
+            method, mapping = overload
+            self._check_operand(class_name, expr.operator, method.params[0].type, mapping, expr.right)
+            return f'{left}.{method.name}({right})'
+        
+        # Derived forms: != from ==, and >, <=, >= from <
+        right_info = self._class_info(self._infer_type(expr.right))
+        same_class = right_info is not None and right_info[0].name == class_name
+        equals = self._operator_method(left_type, '==')
+        less = self._operator_method(left_type, '<')
+        
+        if expr.operator == '!=' and equals:
+            return f'!{left}.{equals[0].name}({right})'
+        if expr.operator in ('>', '<=', '>=') and less and same_class:
+            if expr.operator == '>':
+                return f'{right}.{less[0].name}({left})'
+            if expr.operator == '<=':
+                return f'!{right}.{less[0].name}({left})'
+            return f'!{left}.{less[0].name}({right})'
+        
+        # Without overloads == and != keep Go's pointer identity semantics
+        if expr.operator in ('==', '!='):
+            return None
+        
+        raise TranspilerError(f"Operator {expr.operator} is not defined for class {class_name}")
+    
+    def _indexer(self, type_name: Optional[str], kind: str) -> Optional[Tuple[MethodDecl, Dict[str, str]]]:
+        """Finds the index getter ('get') or setter ('set') of a class type"""
+        info = self._class_info(type_name)
+        if not info:
+            return None
+        
+        methods = self._class_method_set(info[0].name, info[1])
+        names = ['IndexSet'] if kind == 'set' else ['IndexGet', 'IndexLookup']
+        for name in names:
+            if name in methods and methods[name][0].operator:
+                return methods[name]
+        return None
+    
+    def _split_result_types(self, result_type: str) -> List[str]:
+        """Splits a result list '(V, bool)' into its types"""
+        if not result_type.startswith('('):
+            return [result_type]
+        _, types = self._split_type_args(f'_[{result_type[1:-1]}]')
+        return types
+    
+    def _lower_index_get(self, expr: IndexExpr) -> Optional[str]:
+        """Lowers obj[key] on a class with an indexer to obj.IndexGet(key)"""
+        object_type = self._infer_type(expr.object)
+        info = self._class_info(object_type)
+        if not info:
+            return None
+        
+        if not self._indexer(object_type, 'get'):
+            raise TranspilerError(f"Class {info[0].name} does not define an index getter (operator [])")
+        
+        obj = self._expr_to_string(expr.object)
+        index = self._unparenthesized(expr.index)
+        return f'{obj}.IndexGet({index})'
+    
+    def _lower_index_set(self, stmt: AssignStmt) -> Optional[str]:
+        """Lowers obj[key] = value (and compound forms) on a class with an indexer to obj.IndexSet"""
+        if not isinstance(stmt.target, IndexExpr):
+            return None
+        
+        object_type = self._infer_type(stmt.target.object)
+        info = self._class_info(object_type)
+        if not info:
+            return None
+        
+        if not self._indexer(object_type, 'set'):
+            raise TranspilerError(f"Class {info[0].name} does not define an index setter (operator []=)")
+        if stmt.operator == ':=':
+            raise TranspilerError("Cannot use := with an indexer target")
+        
+        obj = self._expr_to_string(stmt.target.object)
+        index = self._unparenthesized(stmt.target.index)
+        value = self._unparenthesized(stmt.value)
+        if stmt.operator != '=':
+            current = self._lower_index_get(stmt.target)
+            value = self._unparenthesized(BinaryExpr(Identifier(current), stmt.operator[:-1], stmt.value))
+        return f'{obj}.IndexSet({index}, {value})'
+    
+    def _emit_key_not_found_getter(self, decl: ClassDecl) -> None:
+        """Generates IndexGet for a comma-ok indexer, throwing KeyNotFound on a missing key"""
+        lookup = next((m for m in decl.methods if m.name == 'IndexLookup' and m.operator), None)
+        if not lookup or any(m.name == 'IndexGet' for m in decl.methods):
+            return
+        
+        key = lookup.params[0]
+        value_type = self._split_result_types(lookup.return_type)[0]
+        self._emit_line(f'func (this {self._instance_type(decl.name)}) IndexGet({key.name} {key.type}) {value_type} {{')
+        self._indent()
+        self._emit_line(f'value, ok := this.IndexLookup({key.name})')
+        self._emit_line('if !ok {')
+        self._indent()
+        self._emit_line(f'panic(NewException("KeyNotFound", fmt.Sprintf("key not found: %v", {key.name})))')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('return value')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+    
+    def _check_operand(self, class_name: str, operator: str, param_type: str,
+                       mapping: Dict[str, str], operand: Expression) -> None:
+        """Checks the right operand of an overloaded operator against the overload's parameter"""
+        operand_type = self._infer_type(operand)
+        if not operand_type or isinstance(operand, Literal):
+            return
+        
+        expected = self._substitute_type(param_type, mapping)
+        if operand_type != expected:
+            raise TranspilerError(
+                f"Cannot apply operator {operator} to {class_name} and {operand_type} (expected {expected})")
+    
+    # ------------------------------------------------------------------------
+    # Conversion operators
+    # ------------------------------------------------------------------------
+    
+    def _register_conversions(self, classes: List[ClassDecl]) -> None:
+        """Indexes the conversion operators of the local classes by source and target type; two operators
+        between the same types would make every conversion between them ambiguous"""
+        self.conversions = {}
+        for decl in classes:
+            for conversion in decl.conversions:
+                source, target = conversion.params[0].type, conversion.return_type
+                where = f"({self._position(conversion)})"
+                if decl.type_params:
+                    raise TranspilerError(f"Conversion operators are not supported on generic class {decl.name} {where}")
+                if not all(re.fullmatch(r'\*?[A-Za-z_][\w.]*', t) for t in (source, target)):
+                    raise TranspilerError(f"Conversion operator of {decl.name} must convert between named types "
+                                          f"(have {source} to {target}) {where}")
+                if decl.name not in (source.lstrip('*'), target.lstrip('*')):
+                    raise TranspilerError(f"Conversion operator of {decl.name} must convert from or to {decl.name} "
+                                          f"(have {source} to {target}) {where}")
+                if source == target:
+                    raise TranspilerError(f"Conversion operator of {decl.name} converts {source} to itself {where}")
+                if (source, target) in self.conversions:
+                    other, first = self.conversions[(source, target)]
+                    raise TranspilerError(f"Ambiguous conversion from {source} to {target}: operators of {other.name} "
+                                          f"({self._position(first)}) and {decl.name} {where}")
+                name = self._conversion_name(source, target)
+                if name in self.functions:
+                    raise TranspilerError(f"Conversion operator from {source} to {target} generates {name}, "
+                                          f"which is already declared as a function {where}")
+                self.conversions[(source, target)] = (decl, conversion)
+    
+    def _conversion_name(self, source: str, target: str) -> str:
+        """Generated function of a conversion: *Celsius to *Fahrenheit -> CelsiusToFahrenheit"""
+        return f'{self._variant_accessor(source)}To{self._variant_accessor(target)}'
+    
+    def _conversion_key(self, expr: Expression, targets: List[str]) -> Optional[Tuple[str, str]]:
+        """Finds the conversion from the type of a value to one of the targets. An untyped number
+        converts through an operator of any numeric type it fits, which must then be the only one."""
+        constant = self._untyped_constant(expr)
+        if constant not in ('int', 'float'):
+            source = self._infer_type(expr)
+            return next(((source, target) for target in targets if (source, target) in self.conversions), None)
+        
+        numeric = {'float32', 'float64'} if constant == 'float' else self.INTEGER_TYPES | {'float32', 'float64'}
+        candidates = [key for key in self.conversions if key[0] in numeric and key[1] in targets]
+        if len(candidates) > 1:
+            sources = ' and '.join(sorted(key[0] for key in candidates))
+            raise TranspilerError(f"Ambiguous conversion of {self._expr_to_string(expr)} to {candidates[0][1]}: "
+                                  f"operators from {sources} both apply; convert the value first, e.g. "
+                                  f"{candidates[0][0]}({self._expr_to_string(expr)}) ({self._position(expr)})")
+        return candidates[0] if candidates else None
+    
+    def _cast_conversion(self, expr: CastExpr) -> Optional[Tuple[str, str]]:
+        """The conversion operator an 'as' applies (c as Fahrenheit converts to *Fahrenheit for a class)"""
+        if not self.conversions:
+            return None
+        targets = [expr.type]
+        if expr.type in self.classes:
+            targets.append(f'*{expr.type}')
+        return self._conversion_key(expr.expr, targets)
+    
+    def _implicit_conversion(self, expr: Expression, expected: Optional[str]) -> Optional[str]:
+        """Applies an implicit conversion operator to a value of another type than the expected one:
+        var f *Fahrenheit = c -> var f *Fahrenheit = CelsiusToFahrenheit(c)"""
+        if not self.conversions or not expected or self._infer_type(expr) == expected:
+            return None
+        key = self._conversion_key(expr, [expected])
+        if not key:
+            return None
+        value = self._unparenthesized(expr)
+        if not self.conversions[key][1].implicit:
+            raise self._error(f"Cannot use {value} ({key[0]}) as {expected}: the conversion operator is explicit; "
+                              f"write {value} as {expected.lstrip('*')}", expr)
+        return f'{self._conversion_name(*key)}({value})'
+    
+    def _emit_conversion(self, conversion: ConversionDecl) -> None:
+        """Emits a conversion operator as a package function (it has no receiver)"""
+        source, target = conversion.params[0].type, conversion.return_type
+        name = self._conversion_name(source, target)
+        kind = 'implicit' if conversion.implicit else 'explicit'
+        self._emit_line(f'// {name} is the {kind} conversion operator from {source} to {target}')
+        current_class, self.current_class = self.current_class, None
+        func = FuncDecl(name, conversion.params, target, conversion.body, line=conversion.line, column=conversion.column,
+                        file=conversion.file)
+        self._emit_func_decl(func)
+        self.current_class = current_class
+    
+    # ------------------------------------------------------------------------
+    # Nested and inner classes
+    # ------------------------------------------------------------------------
+    
+    TYPE_ATTRIBUTES = ('type', 'return_type', 'class_name', 'extends', 'key_type', 'value_type', 'constraint')
+    TYPE_LIST_ATTRIBUTES = ('implements', 'type_args', 'type_elements')
+    
+    def _hoist_nested_classes(self, program: Program) -> None:
+        """Lifts nested classes to top-level Go types: Car.Engine -> CarEngine"""
+        if program.nested_hoisted:
+            return
+        program.nested_hoisted = True
+        
+        declarations = []
+        for decl in program.declarations:
+            declarations.append(decl)
+            if isinstance(decl, ClassDecl) and decl.nested:
+                self._register_nested_names(decl, decl.name)
+                declarations.extend(self._lift_nested(decl, {}))
+        program.declarations = declarations
+    
+    def _register_nested_names(self, decl: ClassDecl, path: str) -> None:
+        """Records the Go name of every class nested (at any depth) in decl"""
+        prefix = self.nested_types.get(path, path)
+        for nested in decl.nested:
+            self.nested_types[f'{path}.{nested.name}'] = prefix + nested.name
+            self._register_nested_names(nested, f'{path}.{nested.name}')
+    
+    def _lift_nested(self, decl: ClassDecl, outer_names: Dict[str, str]) -> List[ClassDecl]:
+        """Renames the classes nested in decl, rewrites references to them and returns them flattened"""
+        names = dict(outer_names)
+        for nested in decl.nested:
+            names[nested.name] = decl.name + nested.name
+        names.update(self.nested_types)
+        
+        lifted = []
+        for nested in decl.nested:
+            nested.name = decl.name + nested.name
+            if nested.is_inner:
+                self._bind_outer(nested, decl)
+        
+        # The outer class body sees its nested classes by their short names
+        nested, decl.nested = decl.nested, []
+        self._rewrite_types(decl, names)
+        
+        for inner in nested:
+            lifted.append(inner)
+            lifted.extend(self._lift_nested(inner, names))
+        return lifted
+    
+    def _bind_outer(self, inner: ClassDecl, outer: ClassDecl) -> None:
+        """Adds the outer pointer field and constructor parameter of an inner class"""
+        if outer.type_params:
+            raise TranspilerError(f"Inner class {inner.name} of generic class {outer.name} is not supported")
+        
+        self.inner_classes[inner.name] = outer.name
+        inner.fields.insert(0, ClassField('outer', f'*{outer.name}'))
+        if not inner.constructor:
+            inner.constructor = ConstructorDecl([], BlockStmt([]))
+        inner.constructor.params.insert(0, Parameter('outer', f'*{outer.name}'))
+        inner.constructor.body.statements.insert(0, AssignStmt(SelectorExpr(ThisExpr(), 'outer'), Identifier('outer')))
+    
+    def _rewrite_types(self, node, names: Dict[str, str]) -> None:
+        """Replaces class names inside every type string of a subtree"""
+        if not names or isinstance(node, Literal):
+            return
+        pattern = re.compile(r'(?<![\w.])(' + '|'.join(re.escape(n) for n in sorted(names, key=len, reverse=True)) + r')(?!\w)')
+        self._rewrite_node_types(node, pattern, names)
+    
+    def _rewrite_node_types(self, node, pattern, names: Dict[str, str]) -> None:
+        """Walks a subtree applying a compiled class name pattern"""
+        if isinstance(node, list):
+            for item in node:
+                self._rewrite_node_types(item, pattern, names)
+            return
+        if isinstance(node, tuple):
+            for item in node:
+                self._rewrite_node_types(item, pattern, names)
+            return
+        if not isinstance(node, ASTNode) or isinstance(node, Literal):
+            return
+        
+        for attr_name, attr in node_fields(node):
+            if attr_name in self.TYPE_ATTRIBUTES and isinstance(attr, str):
+                setattr(node, attr_name, pattern.sub(lambda m: names[m.group(1)], attr))
+            elif attr_name in self.TYPE_LIST_ATTRIBUTES and isinstance(attr, list):
+                setattr(node, attr_name, [pattern.sub(lambda m: names[m.group(1)], t) for t in attr])
+            elif attr_name != 'nested':
+                self._rewrite_node_types(attr, pattern, names)
+    
+    def _is_outer_reference(self, expr: Identifier) -> bool:
+        """Checks for the outer instance ('outer') used inside an inner class"""
+        return expr.name == 'outer' and self.current_class in self.inner_classes and self._lookup('outer') is None
+    
+    def _display_name(self, class_name: str) -> str:
+        """Source spelling of a (possibly nested) class: CarEngine -> Car.Engine"""
+        return next((source for source, go_name in self.nested_types.items() if go_name == class_name), class_name)
+    
+    def _inner_construction(self, expr: NewExpr) -> Optional[Tuple[str, str]]:
+        """Resolves the class and outer instance of a new expression creating an inner class"""
+        if expr.class_name in self.inner_classes:
+            outer = self.inner_classes[expr.class_name]
+            if self.current_class == outer:
+                return expr.class_name, self.current_receiver
+            if self.current_class in self.inner_classes and self.inner_classes[self.current_class] == outer:
+                return expr.class_name, f'{self.current_receiver}.outer'
+            raise TranspilerError(
+                f"Inner class {self._display_name(expr.class_name)} needs an outer {outer} instance "
+                f"(use new {self._lower_first(outer)}.{expr.class_name[len(outer):]}(...))")
+        
+        # new car.Engine(...) where car is a variable holding the outer instance
+        if '.' in expr.class_name:
+            variable, name = expr.class_name.split('.', 1)
+            info = self._class_info(self._lookup(variable))
+            if info and info[0].name + name in self.inner_classes:
+                return info[0].name + name, variable
+        return None
+    
+    # ------------------------------------------------------------------------
+    # Anonymous classes
+    # ------------------------------------------------------------------------
+    
+    def _hoist_anonymous_classes(self, program: Program) -> None:
+        """Turns every new Base { ... } body into a top-level class named after its enclosing function"""
+        declarations = []
+        counters: Dict[str, int] = {}
+        pending = list(program.declarations)
+        while pending:
+            decl = pending.pop(0)
+            declarations.append(decl)
+            
+            found = []
+            if isinstance(decl, FuncDecl):
+                self._find_anonymous_classes(decl.body, decl.name, counters, found)
+            elif isinstance(decl, VarDecl):
+                self._find_anonymous_classes(decl.value, decl.name, counters, found)
+            elif isinstance(decl, ClassDecl):
+                owner = self._lower_first(decl.name)
+                for f in decl.fields:
+                    self._find_anonymous_classes(f.value, owner, counters, found)
+                if decl.constructor:
+                    self._find_anonymous_classes(decl.constructor.body, f'new{decl.name}', counters, found)
+                for method in decl.methods:
+                    self._find_anonymous_classes(method.body, owner + method.name, counters, found)
+            
+            # Anonymous classes may themselves contain anonymous classes
+            pending[0:0] = found
+        program.declarations = declarations
+    
+    def _find_anonymous_classes(self, node, enclosing: str, counters: Dict[str, int], found: List[ClassDecl]) -> None:
+        """Names and registers the anonymous classes of a subtree"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._find_anonymous_classes(item, enclosing, counters, found)
+            return
+        if not isinstance(node, ASTNode):
+            return
+        
+        if isinstance(node, NewExpr) and node.body and not node.body.name:
+            base = node.class_name
+            if node.type_args:
+                base += '[' + ', '.join(node.type_args) + ']'
+            
+            short_base = node.class_name.split('.')[-1]
+            counters[enclosing + short_base] = counters.get(enclosing + short_base, 0) + 1
+            body = node.body
+            body.name = f'{enclosing}{short_base}{counters[enclosing + short_base]}'
+            
+            if node.class_name in self.interfaces:
+                if node.args:
+                    raise TranspilerError(f"Anonymous implementation of interface {node.class_name} takes no arguments")
+                body.implements = [base]
+            elif node.class_name in self.classes or '.' in node.class_name:
+                body.extends = base
+            else:
+                raise TranspilerError(f"Anonymous class base {node.class_name} is not a known class or interface")
+            
+            self.classes[body.name] = body
+            found.append(body)
+            self._find_anonymous_classes(node.args, enclosing, counters, found)
+            return
+        
+        for attr in field_values(node):
+            self._find_anonymous_classes(attr, enclosing, counters, found)
+    
+    def _anonymous_instance(self, expr: NewExpr) -> str:
+        """Composite literal creating an anonymous class: &mainClickHandler1{Base: *NewBase(args)}"""
+        body = expr.body
+        values = []
+        if body.extends:
+            embedded = body.extends.split('[')[0].split('.')[-1]
+            args = self._args_to_string(expr)
+            deref = '' if self.embed_pointers else '*'
+            values.append(f'{embedded}: {deref}{self._constructor_name(body.extends)}({args})')
+        for f in body.fields:
+            if f.value:
+                values.append(f'{f.name}: {self._unparenthesized(f.value)}')
+        return f'&{body.name}{{' + ', '.join(values) + '}'
+    
+    # ------------------------------------------------------------------------
+    # Type tests (is)
+    # ------------------------------------------------------------------------
+    
+    def _collect_type_checks(self, node, checked: Set[str], casts: Set[str]) -> None:
+        """Records the classes tested with 'is' in checked and those cast to with 'as' in casts, so their helpers
+        are emitted next to them"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._collect_type_checks(item, checked, casts)
+        elif isinstance(node, ASTNode):
+            if isinstance(node, (IsExpr, CastExpr)):
+                checked.add(node.type.lstrip('*'))
+            if isinstance(node, CastExpr) and not node.safe:
+                casts.add(node.type.lstrip('*'))
+            if isinstance(node, (TypePattern, DestructurePattern)):
+                checked.add(node.type.lstrip('*'))
+            if isinstance(node, ValuePattern) and isinstance(node.value, Identifier):
+                checked.add(node.value.name)
+            for attr in field_values(node):
+                self._collect_type_checks(attr, checked, casts)
+    
+    def _tested_class(self, expr) -> Optional[ClassDecl]:
+        """Returns the class of a type test or cast (None for other types, checked with a plain assertion)"""
+        operand_type = self._infer_type(expr.expr)
+        if self._class_info(operand_type):
+            operator = 'is' if isinstance(expr, IsExpr) else 'as'
+            raise TranspilerError(
+                f"'{operator}' requires an interface value, but {self._expr_to_string(expr.expr)} "
+                f"has class type {operand_type}")
+        
+        cls = self.classes.get(expr.type.lstrip('*'))
+        if cls and cls.type_params:
+            raise TranspilerError(f"'is' does not support generic class {cls.name}")
+        # A value class has no subclasses: a plain assertion tests it
+        return None if cls and self._is_value_class(cls.name) else cls
+    
+    def _subclass_paths(self, decl: ClassDecl) -> List[Tuple[str, str]]:
+        """Returns (class, embedded path) for a class and every known subclass: (Student, .Person)"""
+        paths = []
+        for cls in self.classes.values():
+            path = ''
+            current = cls
+            while current and current is not decl and current.extends:
+                base = current.extends.split('[')[0]
+                path += '.' + base.split('.')[-1]
+                current = self.classes.get(base) if '.' not in base else None
+            if current is decl and not cls.type_params:
+                paths.append((cls.name, path))
+        return paths
+    
+    def _emit_type_check_helpers(self, decl: ClassDecl) -> None:
+        """Emits asX/isX, which accept the class and its subclasses (unlike a plain type assertion)"""
+        as_name = f'as{decl.name}'
+        self._emit_line(f'// {as_name} returns the {decl.name} part of value when it is a {decl.name} or a subclass')
+        self._emit_line(f'func {as_name}(value any) (*{decl.name}, bool) {{')
+        self._indent()
+        self._emit_line('switch v := value.(type) {')
+        for class_name, path in self._subclass_paths(decl):
+            self._emit_line(f'case *{class_name}:')
+            self._indent()
+            address = '' if self.embed_pointers else '&'
+            self._emit_line(f'return {address}v{path}, true' if path else 'return v, true')
+            self._dedent()
+        self._emit_line('}')
+        self._emit_line('return nil, false')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line(f'func is{decl.name}(value any) bool {{')
+        self._indent()
+        self._emit_line(f'_, ok := {as_name}(value)')
+        self._emit_line('return ok')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        if decl.name in self.cast_classes:
+            self.required_imports.add('fmt')
+            self._emit_line(f'func cast{decl.name}(value any) *{decl.name} {{')
+            self._indent()
+            self._emit_line(f'if v, ok := {as_name}(value); ok {{')
+            self._indent()
+            self._emit_line('return v')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line(f'panic(NewException("ClassCastException", '
+                            f'fmt.Sprintf("cannot cast %T to {decl.name}", value)))')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line()
+    
+    def _narrowing(self, condition: Expression) -> Optional[Tuple[IsExpr, Optional[Expression]]]:
+        """Splits 'x is T && rest' (leftmost conjunct) so x can be narrowed in rest and the then branch"""
+        if isinstance(condition, IsExpr) and isinstance(condition.expr, Identifier):
+            return condition, None
+        if isinstance(condition, BinaryExpr) and condition.operator == '&&':
+            inner = self._narrowing(condition.left)
+            if inner:
+                test, rest = inner
+                return test, BinaryExpr(rest, '&&', condition.right) if rest else condition.right
+        return None
+    
+    def _references(self, node, name: str) -> bool:
+        """Checks whether a subtree uses a variable"""
+        if isinstance(node, Identifier):
+            return node.name == name
+        if isinstance(node, (list, tuple)):
+            return any(self._references(item, name) for item in node)
+        if isinstance(node, ASTNode):
+            return any(self._references(attr, name) for attr in field_values(node))
+        return False
+    
+    def _unused_name(self, name: str, node, taken: Set[str]) -> str:
+        """name, followed by as many _ as it takes not to be a variable the subtree uses or one of taken"""
+        while name in taken or self._references(node, name):
+            name += '_'
+        return name
+    
+    def _emit_narrowed_if(self, stmt: IfStmt, test: IsExpr, rest: Optional[Expression]) -> None:
+        """if x is Student { ... } -> if xStudent, ok := asStudent(x); ok { ... } with x renamed inside"""
+        variable = test.expr.name
+        union = self._union_operand(test.expr, test.type, test)
+        cls = self._tested_class(test) if not union or union[1].startswith('*') else None
+        if union and cls:
+            check = f'as{cls.name}({union[0]})'
+            narrowed_type = f'*{cls.name}'
+        elif union:
+            check = f'{union[0]}.({union[1]})'
+            narrowed_type = union[1]
+        elif cls:
+            check = f'as{cls.name}({self._unparenthesized(test.expr)})'
+            narrowed_type = f'*{cls.name}'
+        else:
+            check = f'{self._expr_to_string(test.expr)}.({test.type})'
+            narrowed_type = test.type
+        
+        alias = variable + re.sub(r'\W', '', test.type.split('.')[-1]).capitalize()
+        if cls:
+            alias = variable + cls.name
+        name = alias if self._references([rest, stmt.then_stmt], variable) else '_'
+        
+        old_alias = self.aliases.get(variable)
+        self.aliases[variable] = alias
+        self._push_scope()
+        self._declare(variable, narrowed_type)
+        
+        condition = f'ok && {self._operand_to_string(rest, BINARY_PRECEDENCE["&&"] + 1)}' if rest else 'ok'
+        self._emit_line(f'if {name}, ok := {check}; {condition} {{')
+        self._indent()
+        self._emit_nested_body(stmt.then_stmt)
+        self._dedent()
+        
+        self._pop_scope()
+        if old_alias:
+            self.aliases[variable] = old_alias
+        else:
+            del self.aliases[variable]
+        
+        if stmt.else_stmt:
+            self._emit_line('} else {')
+            self._indent()
+            self._emit_nested_body(stmt.else_stmt)
+            self._dedent()
+        self._emit_line('}')
+    
+    def _lower_cast(self, expr: CastExpr) -> str:
+        """obj as Student -> castStudent(obj); obj as? Student yields nil when the type does not match"""
+        conversion = self._cast_conversion(expr)
+        if conversion:
+            operand = self._unparenthesized(expr.expr)
+            if expr.safe:
+                raise self._error(f"{operand} as? {expr.type} cannot fail: it applies the conversion operator "
+                                  f"{self._conversion_name(*conversion)}; use as", expr)
+            return f'{self._conversion_name(*conversion)}({operand})'
+        
+        upcast = self._lower_upcast(expr)
+        if upcast:
+            return upcast
+        
+        # A union: n as int -> n.AsInt() (throws InvalidVariant)
+        union = self._union_operand(expr.expr, expr.type, expr)
+        if union and expr.safe:
+            return f'func() {union[1]} {{ v, _ := {union[0]}.({union[1]}); return v }}()'
+        if union:
+            return f'{self._expr_to_string(expr.expr)}.As{self._variant_accessor(union[1])}()'
+        
+        operand = self._expr_to_string(expr.expr)
+        cls = self._tested_class(expr)
+        if cls and expr.safe:
+            return f'func() *{cls.name} {{ v, _ := as{cls.name}({operand}); return v }}()'
+        if cls:
+            return f'cast{cls.name}({operand})'
+        if expr.safe:
+            return f'func() {expr.type} {{ v, _ := {operand}.({expr.type}); return v }}()'
+        self.required_imports.add('fmt')
+        return (f'func() {expr.type} {{ v, ok := {operand}.({expr.type}); if !ok {{ '
+                f'panic(NewException("ClassCastException", fmt.Sprintf("cannot cast %T to {expr.type}", {operand}))) '
+                f'}}; return v }}()')
+    
+    def _lower_upcast(self, expr: CastExpr) -> Optional[str]:
+        """student as Person -> &student.Person (a class value converted to one of its base classes)"""
+        info = self._class_info(self._infer_type(expr.expr))
+        target = self.classes.get(expr.type.lstrip('*'))
+        if not info or not target:
+            return None
+        
+        path = dict(self._subclass_paths(target)).get(info[0].name)
+        if path is None:
+            raise TranspilerError(
+                f"Cannot cast {self._expr_to_string(expr.expr)} of class type *{info[0].name} to {target.name}: "
+                f"{info[0].name} does not extend {target.name}")
+        operand = self._expr_to_string(expr.expr)
+        if self.embed_pointers:
+            return f'{operand}{path}'
+        return f'&{operand}{path}' if path else operand
+    
+    # ------------------------------------------------------------------------
+    # Conditional expressions
+    # ------------------------------------------------------------------------
+    
+    # Go types (besides the integer ones) that nil does not convert to
+    NON_NILLABLE_TYPES = {'string', 'bool', 'float32', 'float64', 'complex64', 'complex128', 'byte', 'rune'}
+    
+    def _ternary_branches(self, expr: TernaryExpr) -> List[Tuple[Optional[Expression], Expression]]:
+        """Flattens a ? b : c ? d : e into [(a, b), (c, d), (None, e)]"""
+        branches = []
+        while isinstance(expr, TernaryExpr):
+            branches.append((expr.condition, expr.then_expr))
+            expr = expr.else_expr
+        branches.append((None, expr))
+        return branches
+    
+    def _untyped_constant(self, expr: Expression) -> Optional[str]:
+        """Returns 'int', 'float' or 'nil' for constants that take the type of the other branch"""
+        if isinstance(expr, UnaryExpr) and expr.operator == '-':
+            expr = expr.operand
+        if isinstance(expr, Literal) and expr.type in ('int', 'float') and not expr.go_type:
+            return expr.type
+        if isinstance(expr, Identifier) and expr.name == 'nil' and not self._lookup('nil'):
+            return 'nil'
+        return None
+    
+    def _ternary_type(self, expr: TernaryExpr, expected: Optional[str] = None) -> Optional[str]:
+        """Checks the condition and unifies the branch types of cond ? a : b.
+        Untyped constants (1, 2.5, nil) adapt to the other branch; an expected type (the declared
+        variable or result type) wins, leaving assignability of each branch to the Go compiler."""
+        for condition, _ in self._ternary_branches(expr):
+            condition_type = self._infer_type(condition) if condition is not None else None
+            if condition_type and condition_type != 'bool':
+                raise self._error(f"Condition of ?: must be bool, not {condition_type}", expr)
+        if expected:
+            return expected
+        values = [value for _, value in self._ternary_branches(expr)]
+        return self._unify_types(values, 'Branches of ?:', expr, "; declare the variable type, e.g. var x T = ...")
+    
+    def _unify_types(self, values: List[Expression], operands: str, expr: Expression, hint: str = '',
+                     types: Optional[List[Optional[str]]] = None) -> Optional[str]:
+        """Common type of the operands of ?:, ?? or match (types, when given, are already inferred);
+        untyped constants take the type of the others"""
+        constants = {}
+        typed = {}
+        for i, value in enumerate(values):
+            constant = self._untyped_constant(value)
+            if constant:
+                constants.setdefault(constant, self._unparenthesized(value))
+                continue
+            value_type = types[i] if types is not None else self._infer_type(value)
+            if value_type:
+                typed.setdefault(value_type, self._unparenthesized(value))
+        
+        if len(typed) > 1:
+            (first, first_value), (second, second_value) = list(typed.items())[:2]
+            raise self._error(
+                f"{operands} have mismatched types {first} ({first_value}) and {second} ({second_value})"
+                f"{hint}", expr)
+        if typed:
+            result = next(iter(typed))
+            mismatched = None
+            if 'nil' in constants and (result in self.NON_NILLABLE_TYPES or result in self.INTEGER_TYPES):
+                mismatched = constants['nil']
+            elif result in ('string', 'bool'):
+                mismatched = constants.get('int') or constants.get('float')
+            if mismatched:
+                raise self._error(
+                    f"{mismatched} in {operands.lower()} cannot be used as {result}", expr)
+            return result
+        if 'float' in constants:
+            return 'float64'
+        if 'int' in constants:
+            return 'int'
+        return None
+    
+    def _emit_ternary(self, expr: TernaryExpr, emit_value, unchanged=None) -> None:
+        """Emits cond ? a : b as an if/else chain; emit_value emits the statement for the selected branch.
+        Branches for which unchanged(value) holds (x = c ? y : x) emit nothing."""
+        branches = self._ternary_branches(expr)
+        if unchanged and len(branches) > 1 and unchanged(branches[-1][1]):
+            branches.pop()
+        failed = []  # values proven non-nil by the conditions of the earlier branches failing
+        for i, (condition, value) in enumerate(branches):
+            if unchanged and unchanged(value):
+                value = None
+            if condition is None:
+                self._emit_line('} else {')
+            else:
+                keyword = 'if' if i == 0 else '} else if'
+                code = self._with_nil_tests(failed, lambda: self._unparenthesized(condition))
+                self._emit_line(f'{keyword} {code} {{')
+            self._indent()
+            tests = failed + (self._nil_tests(condition) if condition is not None else [])
+            if isinstance(value, TernaryExpr):
+                self._with_nil_tests(tests, lambda: self._emit_ternary(value, emit_value, unchanged))
+            elif value is not None:
+                self._with_nil_tests(tests, lambda: emit_value(value))
+            self._dedent()
+            if condition is not None:
+                failed = failed + self._nil_tests(condition, holds=False)
+        self._emit_line('}')
+    
+    def _emit_conditional_assignment(self, target: Expression, expr: Expression, declared: Optional[str]) -> None:
+        """x := c ? a : b -> var x T; if c { x = a } else { x = b } (and likewise for match)
+        (declared is the variable type, '' to infer it, or None for a plain assignment)"""
+        is_match = isinstance(expr, MatchExpr)
+        result_type = self._match_type if is_match else self._ternary_type
+        unchanged = None
+        if declared is None:
+            result_type(expr, self._infer_type(target))
+            target_code = self._expr_to_string(target)
+            unchanged = lambda value: (isinstance(value, (Identifier, SelectorExpr)) and
+                                       self._expr_to_string(value) == target_code)
+        else:
+            var_type = result_type(expr, declared or None)
+            if not var_type:
+                construct = 'match arms' if is_match else '?: branches'
+                raise TranspilerError(
+                    f"Cannot infer the type of {target.name} from its {construct}; declare it as var {target.name} T = ... "
+                    f"({self._position(expr)})")
+            self._emit_line(f'var {target.name} {var_type}')
+            self._declare(target.name, var_type)
+        
+        assign = lambda value: self._emit_line(self._stmt_to_string(AssignStmt(target, value)))
+        if is_match:
+            self._emit_match(expr, assign)
+        else:
+            self._emit_ternary(expr, assign, unchanged)
+    
+    def _lower_ternary(self, expr: TernaryExpr) -> str:
+        """Nested cond ? a : b -> func() T { if cond { return a }; return b }()"""
+        result_type = self._ternary_type(expr)
+        if not result_type:
+            raise self._error("Cannot infer the type of ?: expression; convert a branch, e.g. T(x)", expr)
+        
+        cases = []
+        failed = []
+        for condition, value in self._ternary_branches(expr):
+            tests = failed + (self._nil_tests(condition) if condition is not None else [])
+            result = self._with_nil_tests(tests, lambda: self._unparenthesized(value))
+            if condition is None:
+                cases.append(f'return {result}')
+                continue
+            code = self._with_nil_tests(failed, lambda: self._unparenthesized(condition))
+            cases.append(f'if {code} {{ return {result} }}')
+            failed = failed + self._nil_tests(condition, holds=False)
+        return f'func() {result_type} {{ ' + '; '.join(cases) + ' }()'
+    
+    # ------------------------------------------------------------------------
+    # Optional chaining and null-coalescing
+    # ------------------------------------------------------------------------
+    
+    def _nillable(self, type_name: Optional[str]) -> Optional[bool]:
+        """Whether values of a type can be nil (None when the type is unknown)"""
+        if not type_name:
+            return None
+        if type_name.startswith(('*', '[]', 'map[', 'chan ', 'func(', 'interface{')) or \
+                type_name in ('error', 'any'):
+            return True
+        base, _ = self._split_type_args(type_name)
+        if base in self.interfaces:
+            return True
+        if base in self.classes or base in self.enums or type_name in self.NON_NILLABLE_TYPES or \
+                type_name in self.INTEGER_TYPES:
+            return False
+        return None
+    
+    def _zero_value(self, type_name: str) -> str:
+        """Go zero value of a type, as returned by a short-circuited optional chain"""
+        if self._nillable(type_name):
+            return 'nil'
+        if type_name == 'string':
+            return '""'
+        if type_name == 'bool':
+            return 'false'
+        if type_name in self.INTEGER_TYPES or type_name in self.NON_NILLABLE_TYPES:
+            return '0'
+        return f'*new({type_name})'
+    
+    def _spine(self, expr: Expression) -> Optional[Expression]:
+        """The operand a postfix expression applies to (a.b -> a, f(x) -> f, a[i] -> a)"""
+        if isinstance(expr, CallExpr):
+            return expr.function
+        if isinstance(expr, (SelectorExpr, IndexExpr)):
+            return expr.object
+        return None
+    
+    def _innermost_optional(self, expr: Expression) -> Optional[SelectorExpr]:
+        """Finds the first ?. evaluated in a chain (a?.b?.c -> a?.b)"""
+        found = None
+        while expr is not None:
+            if isinstance(expr, SelectorExpr) and expr.optional:
+                found = expr
+            expr = self._spine(expr)
+        return found
+    
+    def _replace_in_chain(self, expr: Expression, old: Expression, new: Expression) -> Expression:
+        """Copies a chain with one of its operands replaced"""
+        if expr is old:
+            return new
+        expr = copy.copy(expr)
+        attr = 'function' if isinstance(expr, CallExpr) else 'object'
+        setattr(expr, attr, self._replace_in_chain(getattr(expr, attr), old, new))
+        return expr
+    
+    def _optional_guards(self, expr: Expression) -> Tuple[List[str], Expression]:
+        """Splits a?.b?.c() into nil checks (if a != nil, if v := a.b; v != nil) and the access they guard.
+        Receivers other than plain variables are evaluated once, into a temporary."""
+        guards = []
+        while True:
+            selector = self._innermost_optional(expr)
+            if not selector:
+                return guards, expr
+            
+            receiver_type = self._infer_type(selector.object)
+            receiver = self._expr_to_string(selector.object)
+            if self._nillable(receiver_type) is False:
+                raise self._error(
+                    f"?. applied to {receiver} of non-pointer type {receiver_type}, which is never nil; "
+                    "use . instead", selector)
+            
+            if re.fullmatch(r'[A-Za-z_]\w*', receiver):
+                name = receiver
+                guards.append(f'if {name} != nil')
+                self._assume_not_nil([name])
+            else:
+                name, suffix = 'recv', 1
+                while self._lookup(name) or f'if {name} :=' in ' '.join(guards):
+                    suffix += 1
+                    name = f'recv{suffix}'
+                self._declare(name, receiver_type)
+                guards.append(f'if {name} := {receiver}; {name} != nil')
+            expr = self._replace_in_chain(expr, selector, SelectorExpr(Identifier(name), selector.field))
+    
+    def _optional_chain_body(self, expr: Expression, skip_nil: bool = False) -> str:
+        """if a != nil { if v := a.b; v != nil { return v.c } } (the body of the lowered function);
+        with skip_nil, a nil result also falls through to what follows"""
+        guards, access = self._optional_guards(expr)
+        body = f'return {self._unparenthesized(access)}'
+        if skip_nil:
+            body = f'if v := {self._unparenthesized(access)}; v != nil {{ return v }}'
+        for guard in reversed(guards):
+            body = f'{guard} {{ {body} }}'
+        return body
+    
+    def _lower_optional_chain(self, expr: Expression) -> str:
+        """student?.GetSchool() -> func() string { if student != nil { return student.GetSchool() }; return "" }()"""
+        result_type = self._infer_type(expr)
+        if not result_type or result_type.startswith('('):
+            raise self._error(f"Cannot infer the type of optional chain ending in {self._chain_end(expr)}; "
+                              "use an explicit nil check", self._innermost_optional(expr))
+        self._push_scope()
+        body = self._optional_chain_body(expr)
+        self._pop_scope()
+        return f'func() {result_type} {{ {body}; return {self._zero_value(result_type)} }}()'
+    
+    def _chain_end(self, expr: Expression) -> str:
+        """Names the last member of a chain for diagnostics"""
+        while isinstance(expr, (CallExpr, IndexExpr)):
+            expr = self._spine(expr)
+        return expr.field if isinstance(expr, SelectorExpr) else type(expr).__name__
+    
+    def _emit_optional_stmt(self, expr: Expression) -> None:
+        """listener?.OnEvent(e) used as a statement -> if listener != nil { listener.OnEvent(e) }"""
+        self._push_scope()
+        guards, access = self._optional_guards(expr)
+        for guard in guards:
+            self._emit_line(f'{guard} {{')
+            self._indent()
+        self._emit_line(self._unparenthesized(access))
+        for _ in guards:
+            self._dedent()
+            self._emit_line('}')
+        self._pop_scope()
+    
+    def _coalesce_type(self, expr: CoalesceExpr) -> Optional[str]:
+        """value ?? fallback has the type of value; the fallback must match it"""
+        return self._unify_types([expr.left, expr.right], 'Operands of ??', expr)
+    
+    def _lower_coalesce(self, expr: CoalesceExpr) -> str:
+        """name ?? "none" -> func() T { if name != nil { return name }; return "none" }()"""
+        result_type = self._coalesce_type(expr)
+        if not result_type:
+            raise self._error("Cannot infer the type of ?? expression", expr)
+        
+        if self._innermost_optional(expr.left):
+            self._push_scope()
+            body = self._optional_chain_body(expr.left, skip_nil=bool(self._nillable(result_type)))
+            self._pop_scope()
+        else:
+            if self._nillable(result_type) is False:
+                raise self._error(
+                    f"?? applied to {self._expr_to_string(expr.left)} of non-pointer type {result_type}, "
+                    "which is never nil", expr)
+            value = self._expr_to_string(expr.left)
+            if re.fullmatch(r'[A-Za-z_]\w*', value):
+                body = f'if {value} != nil {{ return {value} }}'
+            else:
+                body = f'if v := {value}; v != nil {{ return v }}'
+        return f'func() {result_type} {{ {body}; return {self._unparenthesized(expr.right)} }}()'
+    
+    # ------------------------------------------------------------------------
+    # Nullable types
+    # ------------------------------------------------------------------------
+    
+    def _resolve_nullable_types(self, node) -> None:
+        """Replaces T? by its Go type in every type string of a subtree, flagging the parameters, fields,
+        variables and results declared nullable"""
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._resolve_nullable_types(item)
+            return
+        if not isinstance(node, ASTNode) or isinstance(node, Literal):
+            return
+        
+        for attr_name, attr in node_fields(node):
+            if attr_name in self.TYPE_ATTRIBUTES and isinstance(attr, str):
+                if '?' in attr:
+                    go_type, nullable = self._nullable_go_type(attr, node)
+                    setattr(node, attr_name, go_type)
+                    flag = 'nullable_result' if attr_name == 'return_type' else 'nullable'
+                    if nullable and attr_name in ('type', 'return_type') and hasattr(node, flag):
+                        setattr(node, flag, True)
+            elif attr_name in self.TYPE_LIST_ATTRIBUTES and isinstance(attr, list):
+                setattr(node, attr_name, [self._nullable_go_type(t, node)[0] if isinstance(t, str) and '?' in t else t
+                                          for t in attr])
+            else:
+                self._resolve_nullable_types(attr)
+    
+    def _nullable_go_type(self, type_name: str, node) -> Tuple[str, bool]:
+        """Go spelling of a type with nullable names and whether the whole type is nullable
+        (Person? -> *Person, True; []Person? -> []*Person, False; Shape? -> Shape, True for an interface)"""
+        whole = False
+        while '?' in type_name:
+            end = type_name.index('?')
+            start = end
+            if start and type_name[start - 1] == ']':
+                # Type arguments: Box[int]?
+                depth = 0
+                while start:
+                    start -= 1
+                    depth += {']': 1, '[': -1}.get(type_name[start], 0)
+                    if depth == 0:
+                        break
+            while start and (type_name[start - 1].isalnum() or type_name[start - 1] in '_.'):
+                start -= 1
+            if start and type_name[start - 1] == '*':
+                start -= 1
+            named = type_name[start:end]
+            if not re.match(r'\*?[A-Za-z_]', named):
+                raise self._error(f"Invalid nullable type {type_name}: only a named type can be followed by ?", node)
+            
+            base, _ = self._split_type_args(named.lstrip('*'))
+            target = self.type_aliases.get(base, base)
+            if named.startswith('*') or base in self.classes:
+                go_type = '*' + named.lstrip('*')
+            elif self._nillable(target) or base in self.interfaces:
+                go_type = named  # interfaces, error, any, and aliases of pointers, slices and maps already hold nil
+            elif base in self.enums or base in self.unions or self._nillable(target) is False or \
+                    target in self.NON_NILLABLE_TYPES:
+                raise self._error(f"{named}? is not allowed: {named} values cannot be nil (only classes, pointers "
+                                  "and interfaces can be nullable)", node)
+            else:
+                go_type = '*' + named  # a struct of another package: time.Time? -> *time.Time
+            whole = start == 0 and end == len(type_name) - 1
+            type_name = type_name[:start] + go_type + type_name[end + 1:]
+        return type_name, whole
+    
+    def _nil_key(self, expr: Expression) -> Optional[str]:
+        """Names a value whose nil state is tracked: p, this, p.boss (None for other expressions)"""
+        if isinstance(expr, Identifier):
+            return expr.name
+        if isinstance(expr, ThisExpr):
+            return 'this'
+        if isinstance(expr, SelectorExpr) and not expr.optional:
+            owner = self._nil_key(expr.object)
+            return f'{owner}.{expr.field}' if owner else None
+        return None
+    
+    def _nil_state(self, key: str) -> Optional[bool]:
+        """Whether a tracked value may be nil here, innermost scope first (None when it is not tracked)"""
+        for states in reversed(self.nil_states):
+            if key in states:
+                return states[key]
+        return None
+    
+    def _nullable_member(self, type_name: Optional[str], name: str):
+        """The field, method or interface method a member access on a type refers to (None when unknown)"""
+        info = self._class_info(type_name)
+        if info:
+            for cls, _ in self._class_chain(info[0].name, info[1]):
+                member = next((f for f in cls.fields if f.name == name), None)
+                if member:
+                    return member
+            methods = self._class_method_set(info[0].name, info[1])
+            return methods[name][0] if name in methods else None
+        interface = self.interfaces.get(self._split_type_args(type_name or '')[0])
+        if interface:
+            return next((m for m in interface.methods if m.name == name), None)
+        return None
+    
+    def _may_be_nil(self, expr: Expression) -> bool:
+        """Whether a value may be nil where it is used: nil itself, as?, or a nullable variable, field or call
+        result that no enclosing test has proven non-nil"""
+        key = self._nil_key(expr)
+        if key and self._nil_state(key) is not None:
+            return self._nil_state(key)
+        return self._nullable_value(expr)
+    
+    def _nullable_value(self, expr: Expression) -> bool:
+        """Whether a value is nullable, whatever tests proved about it"""
+        key = self._nil_key(expr)
+        if key and self._nil_state(key) is not None:
+            return True
+        if self._untyped_constant(expr) == 'nil' or (isinstance(expr, CastExpr) and expr.safe):
+            return True
+        if isinstance(expr, SelectorExpr):
+            member = self._nullable_member(self._infer_type(expr.object), expr.field)
+            return isinstance(member, ClassField) and member.nullable
+        if isinstance(expr, CallExpr) and isinstance(expr.function, Identifier):
+            func = self.functions.get(expr.function.name)
+            return bool(func and func.nullable_result and not self._lookup(expr.function.name))
+        if isinstance(expr, CallExpr) and isinstance(expr.function, SelectorExpr):
+            member = self._nullable_member(self._infer_type(expr.function.object), expr.function.field)
+            return bool(getattr(member, 'nullable_result', False))
+        return False
+    
+    def _declared_nil_state(self, decl) -> Optional[bool]:
+        """Nil state of a new variable: tracked when it is declared nullable or starts with a nullable value
+        (var p Person?, p := find()), None otherwise"""
+        if decl.nullable:
+            return decl.value is None or self._may_be_nil(decl.value)
+        if not decl.type and decl.value is not None:
+            return self._inferred_nil_state(decl.value)
+        return None
+    
+    def _inferred_nil_state(self, value: Expression) -> Optional[bool]:
+        """Nil state of a variable declared with :=, which is nullable when its value is"""
+        return self._may_be_nil(value) if self._nullable_value(value) else None
+    
+    def _nil_tests(self, condition: Expression, holds: bool = True) -> List[str]:
+        """Values a condition proves non-nil when it holds (or, with holds False, when it fails):
+        p != nil, p == nil failing, both sides of && holding, both sides of || failing, and !c"""
+        if isinstance(condition, UnaryExpr) and condition.operator == '!':
+            return self._nil_tests(condition.operand, not holds)
+        if not isinstance(condition, BinaryExpr):
+            return []
+        if condition.operator == ('&&' if holds else '||'):
+            return self._nil_tests(condition.left, holds) + self._nil_tests(condition.right, holds)
+        if condition.operator == ('!=' if holds else '=='):
+            for value, other in ((condition.left, condition.right), (condition.right, condition.left)):
+                if self._untyped_constant(other) == 'nil' and self._nil_key(value):
+                    return [self._nil_key(value)]
+        return []
+    
+    def _assume_not_nil(self, keys: List[str]) -> None:
+        """Records values proven non-nil for the rest of the innermost scope"""
+        for key in keys:
+            self.nil_states[-1][key] = False
+    
+    def _with_nil_tests(self, keys: List[str], emit):
+        """Runs emit (returning its result) in a scope where the values are known not to be nil"""
+        if not keys:
+            return emit()
+        self._push_scope()
+        self._assume_not_nil(keys)
+        result = emit()
+        self._pop_scope()
+        return result
+    
+    def _leaves_block(self, stmt: Statement) -> bool:
+        """Whether a statement always ends with return, throw, break or continue"""
+        if isinstance(stmt, BlockStmt):
+            return bool(stmt.statements) and self._leaves_block(stmt.statements[-1])
+        return isinstance(stmt, (ReturnStmt, ThrowStmt, BreakStmt, ContinueStmt))
+    
+    def _track_assignment(self, target: Expression, value: Expression) -> None:
+        """Updates nil states after target = value: tests of the old value no longer hold, a value that may be nil
+        makes the target nullable again wherever it was tested, and a non-nil one proves it for the current scope"""
+        key = self._nil_key(target)
+        if not key:
+            return
+        may_be_nil = self._may_be_nil(value)
+        member = self._nullable_member(self._infer_type(target.object), target.field) \
+            if isinstance(target, SelectorExpr) else None
+        tracked = self._nil_state(key) is not None or (isinstance(member, ClassField) and member.nullable)
+        for states in self.nil_states:
+            for derived in [k for k in states if k.startswith(key + '.')]:
+                del states[derived]
+            if tracked and may_be_nil and key in states:
+                states[key] = True
+        if tracked and not may_be_nil:
+            self.nil_states[-1][key] = False
+    
+    def _check_nil_access(self, expr: SelectorExpr) -> None:
+        """Rejects obj.member on a nullable obj that no enclosing test has proven non-nil"""
+        if expr.optional or not self._may_be_nil(expr.object):
+            return
+        obj = self._expr_to_string(expr.object)
+        raise self._error(f"{obj} may be nil: test it ({obj} != nil) before using .{expr.field}, or use "
+                          f"{obj}?.{expr.field}", expr)
+    
+    def _check_nil_assignment(self, target: Expression, value: Expression, node) -> None:
+        """Warns about nil stored in a pointer field that is not declared nullable"""
+        if not isinstance(target, SelectorExpr) or self._untyped_constant(value) != 'nil':
+            return
+        info = self._class_info(self._infer_type(target.object))
+        if not info:
+            return
+        for cls, _ in self._class_chain(info[0].name, info[1]):
+            field = next((f for f in cls.fields if f.name == target.field), None)
+            if field:
+                self._warn_nil_field(cls.name, field, node)
+                return
+    
+    def _check_nil_default(self, field: ClassField, class_name: str) -> None:
+        """Warns about a nil default for a pointer field that is not declared nullable"""
+        if field.value is not None and self._untyped_constant(field.value) == 'nil':
+            self._warn_nil_field(class_name, field, field)
+    
+    def _warn_nil_field(self, class_name: str, field: ClassField, node) -> None:
+        """Warns when a class-typed field gets nil without being declared nullable"""
+        if field.nullable or not field.type.startswith('*'):
+            return
+        self._warn('GP1001', f"nil assigned to non-nullable field {self._display_name(class_name)}.{field.name}",
+                   node, f"declare it {field.name} {field.type[1:]}? to allow nil")
+    
+    def _warn(self, code: str, message: str, node, fix: Optional[str] = None) -> None:
+        """Records a warning once, with the position of the node, unless it is turned off or suppressed"""
+        warning = make_diagnostic(code, message, node, self.current_program, self.warning_levels, fix)
+        if warning and warning not in self.warnings:
+            self.warnings.append(warning)
+    
+    # ------------------------------------------------------------------------
+    # Lambdas
+    # ------------------------------------------------------------------------
+    
+    def _split_func_type(self, type_name: Optional[str]) -> Optional[Tuple[List[str], Optional[str]]]:
+        """Splits 'func(A, B) R' into (['A', 'B'], 'R'); None for other types"""
+        if not type_name or not type_name.startswith('func('):
+            return None
+        depth = 0
+        for i, char in enumerate(type_name):
+            if char in '([':
+                depth += 1
+            elif char in ')]':
+                depth -= 1
+                if depth == 0:
+                    break
+        params = self._split_type_args(f'f[{type_name[5:i]}]')[1] if type_name[5:i].strip() else []
+        result = type_name[i + 1:].strip() or None
+        return params, result
+    
+    def _bind_type_params(self, pattern: str, actual: Optional[str], names: Set[str], mapping: Dict[str, str]) -> None:
+        """Binds type parameters by matching a parameter type against an argument type ([]T with []*Person)"""
+        if not actual:
+            return
+        if pattern in names:
+            mapping.setdefault(pattern, actual)
+            return
+        for prefix in ('[]', '*', 'chan '):
+            if pattern.startswith(prefix) and actual.startswith(prefix):
+                self._bind_type_params(pattern[len(prefix):], actual[len(prefix):], names, mapping)
+                return
+        if pattern.startswith('map[') and actual.startswith('map['):
+            for p, a in zip(self._split_map_type(pattern), self._split_map_type(actual)):
+                self._bind_type_params(p, a, names, mapping)
+            return
+        pattern_func, actual_func = self._split_func_type(pattern), self._split_func_type(actual)
+        if pattern_func and actual_func:
+            for p, a in zip(pattern_func[0], actual_func[0]):
+                self._bind_type_params(p, a, names, mapping)
+            if pattern_func[1] and actual_func[1]:
+                self._bind_type_params(pattern_func[1], actual_func[1], names, mapping)
+            return
+        pattern_base, pattern_args = self._split_type_args(pattern)
+        actual_base, actual_args = self._split_type_args(actual)
+        if pattern_args and pattern_base == actual_base:
+            for p, a in zip(pattern_args, actual_args):
+                self._bind_type_params(p, a, names, mapping)
+    
+    def _call_signature(self, expr: Expression) -> Optional[Tuple[List[Parameter], Optional[str], Dict[str, str], Set[str]]]:
+        """Parameters, result type, type arguments and type parameter names of the callee of a call or new"""
+        if isinstance(expr, NewExpr):
+            cls = self.classes.get(expr.class_name)
+            if not cls or not cls.constructor:
+                return None
+            return cls.constructor.params, None, self._type_mapping(cls.type_params, expr.type_args), set()
+        
+        if isinstance(expr.function, SelectorExpr):
+            info = self._class_info(self._infer_type(expr.function.object))
+            methods = self._class_method_set(info[0].name, info[1]) if info else {}
+            if expr.function.field not in methods:
+                return None
+            method, mapping = methods[expr.function.field]
+            return method.params, method.return_type, mapping, set()
+        
+        if isinstance(expr.function, Identifier) and expr.function.name in self.functions:
+            func = self.functions[expr.function.name]
+            names = {tp.name for tp in func.type_params}
+            mapping: Dict[str, str] = {}
+            # Bind type parameters from the other arguments first, then from the lambdas' parameter-free results
+            param_types = self._argument_types(func.params, {}, expr)
+            for param_type, arg in zip(param_types, expr.args):
+                if not isinstance(arg, LambdaExpr):
+                    self._bind_type_params(param_type, self._infer_type(arg), names, mapping)
+            for param_type, arg in zip(param_types, expr.args):
+                if isinstance(arg, LambdaExpr):
+                    self._bind_type_params(param_type, self._lambda_type(arg, param_type, mapping, names), names, mapping)
+            return func.params, func.return_type, mapping, names
+        return None
+    
+    def _unbound(self, type_name: str, names: Set[str]) -> bool:
+        """Whether a type still mentions a type parameter that no argument determined"""
+        return any(word in names for word in re.findall(r'\b[A-Za-z_]\w*\b', type_name))
+    
+    def _lambda_signature(self, expr: LambdaExpr, expected: Optional[str], mapping: Dict[str, str],
+                          names: Set[str]) -> Tuple[List[Parameter], Optional[str], bool]:
+        """Parameter types (declared or from the target func type) and the target result type;
+        the flag tells whether the result is unknown and must be inferred from the body"""
+        target = self._split_func_type(self._substitute_type(expected, mapping) if expected else None)
+        if target and len(target[0]) != len(expr.params):
+            raise self._error(
+                f"Lambda has {len(expr.params)} parameters, but {expected} expects {len(target[0])}", expr)
+        
+        params = []
+        for i, param in enumerate(expr.params):
+            param_type = param.type or (target[0][i] if target else None)
+            if not param_type or self._unbound(param_type, names):
+                raise self._error(
+                    f"Cannot infer the type of lambda parameter {param.name}; "
+                    f"declare it, e.g. ({param.name} int) -> ...", expr)
+            params.append(Parameter(param.name, param_type))
+        
+        if not target:
+            return params, None, True
+        result = target[1]
+        return params, result, bool(result and self._unbound(result, names))
+    
+    def _lambda_type(self, expr: LambdaExpr, expected: Optional[str] = None, mapping: Optional[Dict[str, str]] = None,
+                     names: Optional[Set[str]] = None) -> Optional[str]:
+        """Go func type of a lambda, or None when its parameter types are unknown"""
+        try:
+            params, result, infer = self._lambda_signature(expr, expected, mapping or {}, names or set())
+        except TranspilerError:
+            return None
+        if infer:
+            result = self._lambda_result(expr, params)
+        signature = f'func({", ".join(p.type for p in params)})'
+        return f'{signature} {result}' if result else signature
+    
+    def _lambda_result(self, expr: LambdaExpr, params: List[Parameter]) -> Optional[str]:
+        """Infers the result type of a lambda from its body (a block body from its first valued return)"""
+        body = expr.body
+        if isinstance(body, BlockStmt):
+            returns = [stmt for stmt in self._lambda_returns(body) if stmt.value]
+            if not returns:
+                return None
+            body = returns[0].value
+        self._push_scope()
+        for param in params:
+            self._declare(param.name, param.type, param.nullable or None)
+        result = self._infer_type(body)
+        self._pop_scope()
+        if not result and isinstance(expr.body, BlockStmt):
+            raise self._error("Cannot infer the result type of lambda; declare it with a typed target, "
+                              "e.g. var f func(int) int = ...", expr)
+        return result
+    
+    def _lambda_returns(self, stmt: Statement) -> List[ReturnStmt]:
+        """Return statements of a lambda body, not descending into nested lambdas"""
+        if isinstance(stmt, ReturnStmt):
+            return [stmt]
+        if isinstance(stmt, BlockStmt):
+            return [ret for inner in stmt.statements for ret in self._lambda_returns(inner)]
+        returns = []
+        for name in ('then_stmt', 'else_stmt', 'body'):
+            inner = getattr(stmt, name, None)
+            if isinstance(inner, Statement):
+                returns.extend(self._lambda_returns(inner))
+        return returns
+    
+    def _lower_lambda(self, expr: LambdaExpr, expected: Optional[str] = None, mapping: Optional[Dict[str, str]] = None,
+                      names: Optional[Set[str]] = None) -> str:
+        """x -> x.age > 18 with target func(*Person) bool -> func(x *Person) bool { return (x.age > 18) }"""
+        params, result, infer = self._lambda_signature(expr, expected, mapping or {}, names or set())
+        if infer:
+            result = self._lambda_result(expr, params)
+            if not result and not isinstance(expr.body, BlockStmt) and not isinstance(expr.body, CallExpr):
+                raise self._error("Cannot infer the result type of lambda", expr)
+        
+        signature = 'func(' + ', '.join(f'{p.name} {p.type}' for p in params) + ')' + (f' {result}' if result else '')
+        self._push_scope()
+        for param in params:
+            self._declare(param.name, param.type, param.nullable or None)
+        
+        if not isinstance(expr.body, BlockStmt):
+            body = self._value_to_string(expr.body, result)
+            self._pop_scope()
+            return f'{signature} {{ return {body} }}' if result else f'{signature} {{ {body} }}'
+        
+        outer_output, outer_return_type, outer_iterator = self.output, self.current_return_type, self.current_iterator
+        outer_handler, outer_breakables, outer_frames = self.current_handler, self.breakables, self.jump_frames
+        self.output, self.current_return_type, self.current_iterator, self.current_handler = [], result, None, None
+        self.breakables, self.jump_frames = [], []
+        self._indent()
+        self._emit_block_stmt(expr.body)
+        self._dedent()
+        lines = self.output
+        self.output, self.current_return_type, self.current_iterator = outer_output, outer_return_type, outer_iterator
+        self.current_handler, self.breakables, self.jump_frames = outer_handler, outer_breakables, outer_frames
+        self._pop_scope()
+        return f'{signature} {{\n' + '\n'.join(lines) + '\n' + '    ' * self.indent_level + '}'
+    
+    def _value_to_string(self, expr: Expression, expected: Optional[str], mapping: Optional[Dict[str, str]] = None,
+                         names: Optional[Set[str]] = None) -> str:
+        """Converts a value whose target type is known (lambdas take their parameter types from it,
+        values assigned to a union are wrapped)"""
+        if isinstance(expr, LambdaExpr):
+            return self._lower_lambda(expr, expected, mapping, names)
+        converted = self._implicit_conversion(expr, expected)
+        if converted:
+            return converted
+        if expected in self.unions:
+            return self._union_value(expr, self.unions[expected])
+        return self._unparenthesized(expr)
+    
+    def _args_to_string(self, expr: Expression) -> str:
+        """Converts call or constructor arguments, typing lambda arguments from the callee's parameters"""
+        signature = self._call_signature(expr) if self.unions or self.conversions or \
+            any(isinstance(a, LambdaExpr) for a in expr.args) else None
+        if not signature:
+            return ', '.join(self._unparenthesized(arg) for arg in expr.args) + ('...' if expr.spread else '')
+        params, _, mapping, names = signature
+        values = [self._value_to_string(arg, param_type, mapping, names)
+                  for arg, param_type in zip(expr.args, self._argument_types(params, {}, expr))]
+        if expr.spread:
+            values[-1] += '...'
+        return ', '.join(values)
+    
+    # ------------------------------------------------------------------------
+    # Characters and string helpers
+    # ------------------------------------------------------------------------
+    
+    # Character-based methods available on strings, and their result types
+    STRING_METHODS = {'CharAt': 'rune', 'Chars': '[]rune', 'Length': 'int'}
+    
+    def _lower_string_method(self, expr: CallExpr) -> Optional[str]:
+        """s.CharAt(i) -> CharAt(s, i) (throws IndexOutOfRange), s.Chars() -> []rune(s),
+        s.Length() -> utf8.RuneCountInString(s): strings indexed by character rather than byte"""
+        if not isinstance(expr.function, SelectorExpr) or expr.function.field not in self.STRING_METHODS or \
+                self._infer_type(expr.function.object) != 'string':
+            return None
+        method = expr.function.field
+        expected = 1 if method == 'CharAt' else 0
+        if len(expr.args) != expected:
+            raise self._error(f"string.{method} takes {expected} argument(s), got {len(expr.args)}", expr)
+        
+        text = self._unparenthesized(expr.function.object)
+        if method == 'CharAt':
+            self.uses_string_runtime = True
+            self.exception_types.add('Exception')
+            self.required_imports.add('fmt')
+            self.required_imports.add('unicode/utf8')
+            return f'CharAt({text}, {self._unparenthesized(expr.args[0])})'
+        if method == 'Chars':
+            return f'[]rune({text})'
+        self.required_imports.add('unicode/utf8')
+        return f'utf8.RuneCountInString({text})'
+    
+    def _lower_rune_concat(self, expr: BinaryExpr) -> Optional[str]:
+        """s + 'a' -> s + string('a'): Go does not add runes to strings"""
+        if expr.operator != '+':
+            return None
+        types = [self._infer_type(expr.left), self._infer_type(expr.right)]
+        if 'string' not in types or 'rune' not in types:
+            return None
+        precedence = BINARY_PRECEDENCE['+']
+        sides = [f'string({self._unparenthesized(side)})' if side_type == 'rune' else self._operand_to_string(side, level)
+                 for side, side_type, level in zip((expr.left, expr.right), types, (precedence, precedence + 1))]
+        return f'{sides[0]} + {sides[1]}'
+    
+    def _emit_string_runtime(self) -> None:
+        """Emits the character-based string helpers"""
+        self._emit_line('// CharAt returns the character (not byte) at index i, throwing IndexOutOfRange when there is none')
+        self._emit_line('func CharAt(s string, i int) rune {')
+        self._indent()
+        self._emit_line('n := 0')
+        self._emit_line('for _, c := range s {')
+        self._indent()
+        self._emit_line('if n == i {')
+        self._indent()
+        self._emit_line('return c')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('n++')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('panic(NewException("IndexOutOfRange", fmt.Sprintf("index %d out of range for a string of %d characters", '
+                        'i, utf8.RuneCountInString(s))))')
+        self._dedent()
+        self._emit_line('}')
+    
+    # ------------------------------------------------------------------------
+    # Tuples (multiple values)
+    # ------------------------------------------------------------------------
+    
+    def _check_assignable(self, value: Expression, value_type: Optional[str], target_type: Optional[str],
+                          context: str, node: ASTNode, label: Optional[str] = None) -> None:
+        """Reports values that can never be assigned to the target type: mismatched basic types
+        (string to int) and untyped constants that do not convert (nil to string, 1 to bool)"""
+        if not target_type:
+            return
+        basic = self.NON_NILLABLE_TYPES | self.INTEGER_TYPES
+        # An alias is the same type as the one it names
+        source = self.type_aliases.get(value_type, value_type)
+        target = self.type_aliases.get(target_type, target_type)
+        constant = self._untyped_constant(value)
+        if constant == 'nil':
+            mismatched = target in basic
+        elif constant:
+            mismatched = target in ('string', 'bool')
+        elif not isinstance(value, (Identifier, SelectorExpr, CallExpr, IndexExpr, Literal, InterpolatedString)):
+            return  # arithmetic on untyped constants is only inferred approximately
+        else:
+            mismatched = source in basic and target in basic and source != target
+        if mismatched:
+            described = label or self._expr_to_string(value)
+            if value_type and not constant:
+                described += f' ({value_type})'
+            raise self._error(f"Cannot use {described} as {target_type} in {context}", node)
+    
+    def _tuple_values(self, value: Expression) -> Optional[List[Tuple[Expression, Optional[str], Optional[str]]]]:
+        """Values, types and labels on the right of a multi-value assignment or return: the elements
+        of a tuple, or the results of a call (None when the count is unknown)"""
+        if isinstance(value, TupleExpr):
+            for element in value.elements:
+                if isinstance(element, TupleExpr):
+                    raise self._error("Nested tuples are not supported", element)
+            return [(element, self._infer_type(element), None) for element in value.elements]
+        if isinstance(value, CallExpr):
+            result = self._infer_type(value)
+            if not result:
+                return None
+            types = self._split_result_types(result)
+            call = self._expr_to_string(value)
+            return [(value, t, f'result {i + 1} of {call}' if len(types) > 1 else None) for i, t in enumerate(types)]
+        return None
+    
+    def _check_tuple_assignment(self, stmt: AssignStmt) -> None:
+        """Checks (name, age) := p.NameAndAge(): as many variables as values, and assignable types for ="""
+        targets = stmt.target.elements if isinstance(stmt.target, TupleExpr) else [stmt.target]
+        if len(targets) == 1 and not isinstance(stmt.value, TupleExpr):
+            return
+        values = self._tuple_values(stmt.value)
+        if values is None:
+            return
+        if len(values) != len(targets):
+            if isinstance(stmt.value, TupleExpr):
+                source = f'{len(values)} values'
+            else:
+                source = f'{self._expr_to_string(stmt.value)} returns {len(values)} value(s)'
+            raise self._error(
+                f"Assignment mismatch: {len(targets)} variable(s) but {source}", stmt)
+        if stmt.operator == '=':
+            for target, (value, value_type, label) in zip(targets, values):
+                if not (isinstance(target, Identifier) and target.name == '_'):
+                    self._check_assignable(value, value_type, self._infer_type(target), 'assignment', stmt, label)
+    
+    def _check_return_values(self, stmt: ReturnStmt) -> None:
+        """Checks return (name, age) against the declared result types"""
+        if not self.current_return_type or self.current_iterator is not None:
+            return
+        results = self._split_result_types(self.current_return_type)
+        values = self._tuple_values(stmt.value)
+        if values is None:
+            values = [(stmt.value, self._infer_type(stmt.value), None)]
+        if len(values) != len(results):
+            raise self._error(
+                f"Wrong number of return values: have {len(values)}, want {len(results)} "
+                f"({self.current_return_type})", stmt)
+        for (value, value_type, label), result in zip(values, results):
+            self._check_assignable(value, value_type, result, 'return', stmt, label)
+    
+    # ------------------------------------------------------------------------
+    # for ... in loops
+    # ------------------------------------------------------------------------
+    
+    def _next_element(self, type_name: Optional[str]) -> Optional[str]:
+        """Element type of a class with HasNext() bool and Next() T, or None"""
+        info = self._class_info(type_name)
+        if not info:
+            return None
+        methods = self._class_method_set(info[0].name, info[1])
+        has_next, next_method = methods.get('HasNext'), methods.get('Next')
+        if not has_next or not next_method or has_next[0].params or next_method[0].params or \
+                has_next[0].return_type != 'bool' or not next_method[0].return_type:
+            return None
+        return self._substitute_type(next_method[0].return_type, next_method[1])
+    
+    def _iteration_source(self, iterable_type: Optional[str], iterable: str,
+                          node: ASTNode) -> Tuple[str, str, Optional[str]]:
+        """What 'for x in iterable' loops over: ('range', code, type) for slices, maps, strings, channels and
+        iterators, or ('next', code, element type) for a HasNext()/Next() object; a class is iterable through
+        an Iterator() method returning either, or by being a HasNext()/Next() object itself"""
+        info = self._class_info(iterable_type)
+        if not info:
+            return 'range', iterable, iterable_type
+        
+        methods = self._class_method_set(info[0].name, info[1])
+        if 'Iterator' in methods and not methods['Iterator'][0].params and methods['Iterator'][0].return_type:
+            method, mapping = methods['Iterator']
+            result = self._substitute_type(method.return_type, mapping)
+            if result.startswith('iter.Seq'):
+                return 'range', f'{iterable}.Iterator()', result
+            if self._next_element(result):
+                return 'next', f'{iterable}.Iterator()', self._next_element(result)
+        if self._next_element(iterable_type):
+            return 'next', iterable, self._next_element(iterable_type)
+        raise self._error(
+            f"{iterable} of type {iterable_type} is not iterable; declare Iterator() returning an iterator, "
+            "or HasNext() bool and Next() T", node)
+    
+    def _for_in_types(self, variables: List[str], iterable: Expression, node: ASTNode) -> List[Optional[str]]:
+        """Types of the variables of 'for vars in iterable'"""
+        kind, source, source_type = self._iteration_source(self._infer_type(iterable),
+                                                           self._expr_to_string(iterable), node)
+        if kind == 'range':
+            return self._range_bindings(variables, source_type, source, node)[1]
+        if len(variables) != 1:
+            raise self._error(f"{source} yields one value per iteration, "
+                              f"but {len(variables)} variables are given", node)
+        return [source_type]
+    
+    def _emit_for_in(self, variables: List[str], iterable: Expression, node: ASTNode,
+                     emit_body, used: Optional[Sequence[ASTNode]] = None) -> None:
+        """for p in people -> for _, p := range people { ... }; over a HasNext()/Next() object,
+        for it := tree.Iterator(); it.HasNext(); { p := it.Next(); ... } (with used, unread variables become blanks)"""
+        code = self._expr_to_string(iterable)
+        kind, source, source_type = self._iteration_source(self._infer_type(iterable), code, node)
+        types = self._for_in_types(variables, iterable, node)
+        self._push_scope()
+        for name, var_type in zip(variables, types):
+            self._declare(name, var_type)
+        
+        self._push_breakable('loop', node)
+        if kind == 'range':
+            names = self._range_bindings(variables, source_type, source, node)[0]
+            if used is not None:
+                names = [name if self._references(used, name) else '_' for name in names]
+            while names and names[-1] == '_':
+                names.pop()
+            self._emit_line(f'for {", ".join(names)} := range {source} {{' if names else f'for range {source} {{')
+            self._indent()
+        else:
+            iterator = source
+            if not re.fullmatch(r'[A-Za-z_]\w*', source):
+                iterator, suffix = 'it', 1
+                while self._lookup(iterator) or self._references(node, iterator):
+                    suffix += 1
+                    iterator = f'it{suffix}'
+                self._emit_line(f'for {iterator} := {source}; {iterator}.HasNext(); {{')
+            else:
+                self._emit_line(f'for {source}.HasNext() {{')
+            self._indent()
+            if used is None or self._references(used, variables[0]):
+                self._emit_line(f'{variables[0]} := {iterator}.Next()')
+            else:
+                self._emit_line(f'{iterator}.Next()')
+        
+        emit_body()
+        self._dedent()
+        self._emit_line('}')
+        self._pop_breakable()
+        self._pop_scope()
+    
+    # ------------------------------------------------------------------------
+    # Composite literals
+    # ------------------------------------------------------------------------
+    
+    def _composite_to_string(self, literal: Expression, type_name: Optional[str] = None) -> str:
+        """[]*Person{new Person("Ann", 30), {"Bob", 25}} -> []*Person{NewPerson("Ann", 30), NewPerson("Bob", 25)}:
+        elements are checked against the element type; type_name is the type of nested braces, which Go
+        receives without it ([][]int{{1, 2}})"""
+        literal_type = type_name or self._infer_type(literal)
+        code = ''
+        if not type_name:
+            code = literal_type
+        if isinstance(literal, MapLiteral):
+            key_type, value_type = self._split_map_type(literal_type) if literal_type.startswith('map[') else (None, None)
+            items = []
+            for key, value in literal.pairs:
+                if key_type is None and isinstance(key, Identifier):
+                    key_code = key.name  # field of a Go struct: Point{X: 1}
+                else:
+                    key_code = self._composite_element(key, key_type, 'map literal', literal)
+                items.append(f'{key_code}: {self._composite_element(value, value_type, "map literal", literal)}')
+        else:
+            element_type, context = None, None
+            if literal_type.startswith('['):
+                element_type = literal_type[literal_type.index(']') + 1:]
+                context = 'slice literal' if literal_type.startswith('[]') else 'array literal'
+            items = [self._composite_element(element, element_type, context, literal) for element in literal.elements]
+        return code + '{' + ', '.join(items) + '}'
+    
+    def _composite_element(self, element: Expression, element_type: Optional[str], context: Optional[str],
+                           literal: Expression) -> str:
+        """An element, key or value of a composite literal; braces take their type from the literal, and stand for
+        a constructor call when it is a class ({"Bob", 25} in a []*Person -> NewPerson("Bob", 25))"""
+        elided = isinstance(element, ArrayLiteral) and not element.type or \
+            isinstance(element, MapLiteral) and not element.key_type
+        if elided:
+            if not element_type:
+                raise self._error("Braces without a type must be elements of a slice, array or map literal", element)
+            if self._class_info(element_type):
+                return self._elided_construction(element, element_type)
+            return self._composite_to_string(element, element_type)
+        
+        value_type = self._infer_type(element)
+        if element_type and value_type == f'*{element_type}' and self._class_info(element_type):
+            raise self._error(
+                f"Cannot use {self._expr_to_string(element)} ({value_type}) as {element_type} in {context}; "
+                f"class instances are pointers, declare the elements as *{element_type}", element)
+        self._check_assignable(element, value_type, element_type, context, literal)
+        return self._value_to_string(element, element_type)
+    
+    def _elided_construction(self, element: Expression, element_type: str) -> str:
+        """Braces of constructor arguments for a class element: {"Bob", 25} -> NewPerson("Bob", 25)
+        (dereferenced for elements held by value)"""
+        if isinstance(element, MapLiteral):
+            raise self._error(
+                f"Braces for a {element_type} element are passed to its constructor; list the arguments instead of "
+                "key: value pairs", element)
+        base, type_args = self._split_type_args(element_type.lstrip('*'))
+        construction = NewExpr(base, element.elements, type_args, line=element.line, column=element.column,
+                               file=element.file)
+        code = self._expr_to_string(construction)
+        return code if element_type.startswith('*') else f'*{code}'
+    
+    def _check_arguments(self, expr: Expression) -> None:
+        """Checks the arguments of new Person(...) against the constructor's parameters, and those of calls
+        passing a variadic parameter or spreading a slice (f(parts...)) against the callee's"""
+        signature = self._call_signature(expr)
+        if not signature:
+            return
+        if isinstance(expr, NewExpr):
+            if self.classes[expr.class_name].is_inner:
+                return  # inner classes also take their outer instance
+            callee = f'new {expr.class_name}'
+        else:
+            callee = self._expr_to_string(expr.function)
+        params, _, mapping, names = signature
+        variadic = bool(params) and params[-1].type.startswith('...')
+        if isinstance(expr, CallExpr) and not variadic and not expr.spread:
+            return
+        if expr.spread and not variadic:
+            raise self._error(f"Cannot use ... in call to non-variadic {callee}", expr)
+        
+        fixed = len(params) - 1 if variadic else len(params)
+        if expr.spread and len(expr.args) > len(params):
+            raise self._error(
+                f"{self._expr_to_string(expr.args[-1])}... must be the only value for the variadic parameter "
+                f"{params[-1].name} of {callee}", expr)
+        if len(expr.args) < fixed or (len(expr.args) > fixed and not variadic) or (expr.spread and len(expr.args) <= fixed):
+            wanted = ', '.join(self._substitute_type(param.type, mapping) for param in params)
+            minimum = f'at least {fixed}' if variadic and not expr.spread else str(len(params))
+            raise self._error(f"Wrong number of arguments to {callee}: have {len(expr.args)}, want {minimum} "
+                              f"({wanted})", expr)
+        
+        for i, (arg, param_type) in enumerate(zip(expr.args, self._argument_types(params, mapping, expr))):
+            if isinstance(arg, LambdaExpr) or self._unbound(param_type, names):
+                continue
+            arg_type = self._infer_type(arg)
+            if expr.spread and i == len(expr.args) - 1:
+                # The slice is passed as is, so its type must be exactly []T
+                element = arg_type[2:] if arg_type and arg_type.startswith('[]') else None
+                basic = self.NON_NILLABLE_TYPES | self.INTEGER_TYPES
+                if arg_type and (element is None or element != param_type[2:] and
+                                 (element in basic or param_type[2:] in basic)):
+                    raise self._error(
+                        f"Cannot use {self._expr_to_string(arg)} ({arg_type}) as {param_type} in spread argument to "
+                        f"{callee}", expr)
+                continue
+            self._check_assignable(arg, arg_type, param_type, f'argument to {callee}', expr)
+    
+    def _argument_types(self, params: List[Parameter], mapping: Dict[str, str], expr: Expression) -> List[Optional[str]]:
+        """Types expected for the arguments of a call or new: a variadic ...T takes each remaining argument as T,
+        or a spread slice as []T (None past the parameters)"""
+        types = [self._substitute_type(param.type, mapping) for param in params]
+        if types and types[-1].startswith('...'):
+            element = types.pop()[3:]
+            if expr.spread:
+                types.append(f'[]{element}')
+            else:
+                types += [element] * max(len(expr.args) - len(types), 0)
+        return types[:len(expr.args)] + [None] * (len(expr.args) - len(types))
+    
+    # ------------------------------------------------------------------------
+    # Comprehensions
+    # ------------------------------------------------------------------------
+    
+    def _range_bindings(self, variables: List[str], iterable_type: Optional[str], iterable: str,
+                        node: ASTNode) -> Tuple[List[str], List[Optional[str]]]:
+        """Go range variables and element types for 'for vars in iterable': a single variable takes the
+        elements of slices, strings, channels and iterators and the keys of maps (for p in people -> _, p)"""
+        if not iterable_type:
+            raise self._error(f"Cannot infer the type of {iterable} to iterate over it", node)
+        
+        if re.match(r'\[\d*\]', iterable_type) or iterable_type == 'string':
+            element = 'rune' if iterable_type == 'string' else iterable_type[iterable_type.index(']') + 1:]
+            types, single_is_value = ['int', element], True
+        elif iterable_type.startswith('map['):
+            types, single_is_value = list(self._split_map_type(iterable_type)), False
+        elif iterable_type.startswith(('chan ', '<-chan ')):
+            types, single_is_value = [iterable_type.split(' ', 1)[1]], False
+        elif iterable_type.startswith('iter.Seq'):
+            types, single_is_value = self._split_type_args(iterable_type)[1], False
+        else:
+            raise self._error(f"Cannot iterate over {iterable} of type {iterable_type}", node)
+        
+        if len(variables) > len(types):
+            raise self._error(
+                f"{iterable} yields {len(types)} value(s) per iteration, "
+                f"but {len(variables)} variables are given", node)
+        if len(variables) == 1 and single_is_value:
+            return ['_', variables[0]], [types[1]]
+        return list(variables), types[:len(variables)]
+    
+    def _comprehension_type(self, expr: ComprehensionExpr) -> Optional[str]:
+        """[]E for a list comprehension, map[K]E for a map comprehension (None when the element type is unknown)"""
+        if not self._infer_type(expr.iterable):
+            return None
+        types = self._for_in_types(expr.variables, expr.iterable, expr)
+        self._push_scope()
+        for name, var_type in zip(expr.variables, types):
+            self._declare(name, var_type)
+        element = self._infer_type(expr.element)
+        key = self._infer_type(expr.key) if expr.key else None
+        self._pop_scope()
+        if not element or (expr.key and not key):
+            return None
+        return f'map[{key}]{element}' if expr.key else f'[]{element}'
+    
+    def _checked_comprehension_type(self, expr: ComprehensionExpr) -> str:
+        """Comprehension type, reporting an iterable that cannot be ranged over or an unknown element type"""
+        result_type = self._comprehension_type(expr)
+        if not result_type:
+            self._for_in_types(expr.variables, expr.iterable, expr)
+            raise self._error("Cannot infer the element type of comprehension; convert the element, e.g. T(x)", expr)
+        return result_type
+    
+    def _emit_comprehension_loop(self, expr: ComprehensionExpr, result: str) -> None:
+        """for _, p := range people { if cond { result = append(result, element) } }"""
+        def emit_body():
+            if expr.condition:
+                self._emit_line(f'if {self._unparenthesized(expr.condition)} {{')
+                self._indent()
+            element = self._unparenthesized(expr.element)
+            if expr.key:
+                self._emit_line(f'{result}[{self._unparenthesized(expr.key)}] = {element}')
+            else:
+                self._emit_line(f'{result} = append({result}, {element})')
+            if expr.condition:
+                self._dedent()
+                self._emit_line('}')
+        
+        # Variables the comprehension never reads become blanks, as Go rejects unused ones
+        self._emit_for_in(expr.variables, expr.iterable, expr, emit_body, [expr.element, expr.key, expr.condition])
+    
+    def _emit_comprehension_declaration(self, name: str, expr: ComprehensionExpr, declared: Optional[str]) -> None:
+        """names := [p.name for p in people] -> var names []string followed by the loop appending to it"""
+        var_type = declared or self._checked_comprehension_type(expr)
+        if var_type.startswith('map['):
+            self._emit_line(f'{name} := make({var_type})')
+        else:
+            self._emit_line(f'var {name} {var_type}')
+        self._emit_comprehension_loop(expr, name)
+        self._declare(name, var_type)
+    
+    def _lower_comprehension(self, expr: ComprehensionExpr) -> str:
+        """A comprehension nested in an expression -> func() []E { var result []E; <loop>; return result }()"""
+        result_type = self._checked_comprehension_type(expr)
+        result, suffix = 'result', 1
+        while self._references(expr, result) or result in expr.variables:
+            suffix += 1
+            result = f'result{suffix}'
+        
+        outer_output = self.output
+        self.output = []
+        self._indent()
+        if result_type.startswith('map['):
+            self._emit_line(f'{result} := make({result_type})')
+        else:
+            self._emit_line(f'var {result} {result_type}')
+        self._emit_comprehension_loop(expr, result)
+        self._emit_line(f'return {result}')
+        self._dedent()
+        lines, self.output = self.output, outer_output
+        return f'func() {result_type} {{\n' + '\n'.join(lines) + '\n' + '    ' * self.indent_level + '}()'
+    
+    # ------------------------------------------------------------------------
+    # Pattern matching (match)
+    # ------------------------------------------------------------------------
+    
+    def _resolve_pattern(self, pattern: Pattern) -> Pattern:
+        """A bare type name (Circle, string, models.Person) in a value position is a type test"""
+        if not isinstance(pattern, ValuePattern):
+            return pattern
+        name = None
+        if isinstance(pattern.value, Identifier) and not self._lookup(pattern.value.name):
+            name = pattern.value.name
+        elif isinstance(pattern.value, SelectorExpr) and isinstance(pattern.value.object, Identifier):
+            name = f'{pattern.value.object.name}.{pattern.value.field}'
+        if name and (name in self.classes or name in self.interfaces or name in ('error', 'any') or
+                     name in self.NON_NILLABLE_TYPES or name in self.INTEGER_TYPES):
+            resolved = TypePattern(name)
+            resolved.line, resolved.column, resolved.file = pattern.line, pattern.column, pattern.file
+            return resolved
+        return pattern
+    
+    def _match_keyword(self, expr: MatchExpr) -> str:
+        """'match' or 'switch', for diagnostics"""
+        return 'switch' if expr.is_switch else 'match'
+    
+    def _match_catch_all(self, expr: MatchExpr) -> str:
+        """The arm matching everything: '_ arm' or 'default'"""
+        return 'default' if expr.is_switch else '_ arm'
+    
+    def _match_subject(self, expr: MatchExpr) -> Tuple[str, Optional[str]]:
+        """Go code and type of the matched value (the wrapped value of a union)"""
+        subject_type = self._infer_type(expr.subject)
+        if subject_type in self.unions:
+            return f'{self._expr_to_string(expr.subject)}.Value()', subject_type
+        return self._expr_to_string(expr.subject), subject_type
+    
+    def _pattern_type(self, pattern: Pattern, subject_type: Optional[str]) -> Tuple[str, Optional[ClassDecl]]:
+        """Go type tested by a type or destructuring pattern, with its class (if any)"""
+        union = self.unions.get(subject_type)
+        if union and not self._union_variant(union, pattern.type):
+            raise self._error(f"{pattern.type} is not a variant of {union.name} "
+                              f"(variants: {', '.join(self._union_variants(union))})", pattern)
+        cls = self.classes.get(pattern.type.lstrip('*'))
+        subject_class = self._class_info(subject_type)
+        if subject_class and (not cls or cls.name != subject_class[0].name):
+            raise self._error(
+                "Type patterns require an interface value, "
+                f"but the match subject has class type {subject_type}", pattern)
+        if cls and cls.type_params:
+            raise self._error(f"match does not support generic class {cls.name}", pattern)
+        return (self._instance_type(cls.name) if cls else pattern.type), cls
+    
+    def _value_pattern_code(self, pattern: ValuePattern, subject_type: Optional[str]) -> str:
+        """Bare enum members are allowed when matching an enum, as in switch cases"""
+        enum = self.enums.get(subject_type)
+        value = pattern.value
+        if enum and isinstance(value, Identifier) and not self._lookup(value.name) and \
+                any(m.name == value.name for m in enum.members):
+            return f'{enum.name}{value.name}'
+        return self._expr_to_string(value)
+    
+    def _destructure(self, pattern: DestructurePattern, cls: ClassDecl, value: str,
+                     conditions: List[str], bindings: Dict[str, Tuple[str, str]]) -> None:
+        """Point(0, y) on value -> conditions [value.x == 0] and bindings {y: value.y}"""
+        if not cls.is_data:
+            raise self._error(f"{cls.name} is not a data class and cannot be destructured", pattern)
+        if len(pattern.elements) != len(cls.fields):
+            raise self._error(
+                f"Pattern {cls.name}(...) has {len(pattern.elements)} elements, but {cls.name} has "
+                f"{len(cls.fields)} fields", pattern)
+        
+        for element, field in zip(pattern.elements, cls.fields):
+            path = f'{value}.{field.name}'
+            element = self._resolve_pattern(element)
+            if isinstance(element, BindingPattern):
+                bindings[element.name] = (path, field.type)
+            elif isinstance(element, ValuePattern):
+                conditions.append(f'{path} == {self._value_pattern_code(element, field.type)}')
+            elif isinstance(element, DestructurePattern):
+                nested = self._class_info(field.type)
+                if not nested or nested[0].name != element.type:
+                    raise self._error(
+                        f"Field {field.name} of {cls.name} has type {field.type}, not {element.type}", element)
+                conditions.append(f'{path} != nil')
+                self._destructure(element, nested[0], path, conditions, bindings)
+            elif isinstance(element, TypePattern):
+                raise self._error(
+                    "Type patterns are only supported at the top level of a match arm", element)
+    
+    def _irrefutable(self, pattern: Pattern) -> bool:
+        """Whether a pattern matches every value of the type it tests (no literals or nested checks)"""
+        if isinstance(pattern, DestructurePattern):
+            return all(isinstance(e, (BindingPattern, WildcardPattern)) for e in pattern.elements)
+        return isinstance(pattern, (TypePattern, WildcardPattern))
+    
+    def _analyze_arm(self, arm: MatchArm, subject: str, subject_type: Optional[str]) -> dict:
+        """Computes the test of one arm: an optional init (v, ok := asCircle(s)), conditions and bindings"""
+        patterns = [self._resolve_pattern(p) for p in arm.patterns]
+        info = {'arm': arm, 'patterns': patterns, 'init': None, 'conditions': [], 'bindings': {},
+                'wildcard': any(isinstance(p, WildcardPattern) for p in patterns)}
+        if len(patterns) > 1:
+            if any(not isinstance(p, ValuePattern) for p in patterns):
+                raise self._error(
+                    "Alternative patterns (a, b -> ...) must be values", arm)
+            codes = [f'{subject} == {self._value_pattern_code(p, subject_type)}' for p in patterns]
+            info['conditions'].append('(' + ' || '.join(codes) + ')')
+            info['always'] = False
+            return info
+        
+        pattern = patterns[0]
+        if isinstance(pattern, ValuePattern):
+            info['conditions'].append(f'{subject} == {self._value_pattern_code(pattern, subject_type)}')
+        elif isinstance(pattern, (TypePattern, DestructurePattern)):
+            tested_type, cls = self._pattern_type(pattern, subject_type)
+            info['type'] = tested_type
+            used = [arm.guard, arm.body]
+            if isinstance(pattern, TypePattern):
+                name = pattern.binding if pattern.binding and self._references(used, pattern.binding) else '_'
+                if pattern.binding:
+                    info['bindings'][pattern.binding] = (pattern.binding, tested_type)
+            else:
+                name, suffix = self._lower_first(cls.name.rpartition('.')[2]), 1
+                while self._lookup(name):
+                    suffix += 1
+                    name = f'{self._lower_first(cls.name.rpartition(".")[2])}{suffix}'
+            
+            if self._class_info(subject_type):
+                name = subject  # destructuring the subject itself: no type test
+            elif cls and cls.name not in self.foreign_classes and not self._is_value_class(cls.name):
+                info['init'] = f'{name}, ok := as{cls.name}({subject})'
+            else:
+                info['init'] = f'{name}, ok := {subject}.({tested_type})'
+            if isinstance(pattern, TypePattern) and name == subject and pattern.binding:
+                info['bindings'][pattern.binding] = (subject, tested_type)
+            
+            if isinstance(pattern, DestructurePattern):
+                self._destructure(pattern, cls, name, info['conditions'], info['bindings'])
+                if info['init'] and not info['conditions'] and \
+                        not any(self._references(used, b) for b in info['bindings']):
+                    info['init'] = info['init'].replace(f'{name}, ok', '_, ok', 1)
+            if info['init']:
+                info['conditions'].insert(0, 'ok')
+        info['always'] = not info['conditions'] and not arm.guard
+        return info
+    
+    def _bind_arm(self, info: dict) -> Dict[str, Optional[str]]:
+        """Makes the names bound by an arm visible (as aliases of the matched parts); returns the previous aliases"""
+        self._push_scope()
+        previous = {}
+        for name, (code, type_name) in info['bindings'].items():
+            previous[name] = self.aliases.get(name)
+            self.aliases[name] = code
+            self.scopes[-1][name] = type_name
+        return previous
+    
+    def _unbind_arm(self, previous: Dict[str, Optional[str]]) -> None:
+        """Restores the aliases replaced by _bind_arm"""
+        for name, alias in previous.items():
+            if alias is None:
+                self.aliases.pop(name, None)
+            else:
+                self.aliases[name] = alias
+        self._pop_scope()
+    
+    def _analyze_match(self, expr: MatchExpr) -> Tuple[str, Optional[str], List[dict]]:
+        """Analyzes every arm and checks reachability and exhaustiveness"""
+        subject, subject_type = self._match_subject(expr)
+        if not expr.arms:
+            raise self._error(f"{self._match_keyword(expr)} has no arms", expr)
+        
+        arms = [self._analyze_arm(arm, subject, subject_type) for arm in expr.arms]
+        for info, following in zip(arms, arms[1:]):
+            if info['always']:
+                raise self._error(f"Unreachable {self._match_keyword(expr)} arm after "
+                                  f"{self._match_catch_all(expr)}", following['arm'])
+        self._check_match_exhaustive(expr, subject_type, arms)
+        return subject, subject_type, arms
+    
+    def _check_match_exhaustive(self, expr: MatchExpr, subject_type: Optional[str], arms: List[dict]) -> None:
+        """A match must cover every enum member, both booleans, every class implementing the matched
+        interface, or end with _"""
+        unguarded = [info for info in arms if not info['arm'].guard]
+        if any(info['wildcard'] for info in unguarded):
+            return
+        
+        covered_values = set()
+        covered_types = set()
+        for info in unguarded:
+            for pattern in info['patterns']:
+                if isinstance(pattern, ValuePattern):
+                    covered_values.add(self._value_pattern_code(pattern, subject_type))
+                elif self._irrefutable(pattern):
+                    covered_types.add(pattern.type.lstrip('*'))
+        
+        if subject_type in self.enums:
+            enum = self.enums[subject_type]
+            missing = [m.name for m in enum.members if f'{enum.name}{m.name}' not in covered_values]
+        elif subject_type == 'bool':
+            missing = [v for v in ('true', 'false') if v not in covered_values]
+        elif subject_type in self.unions:
+            missing = [v for v in self._union_variants(self.unions[subject_type]) if v.lstrip('*') not in covered_types]
+        elif self._class_info(subject_type):
+            missing = [] if self._class_info(subject_type)[0].name in covered_types else ['_']
+        elif subject_type in self.interfaces:
+            implementors = [cls for cls in self.classes.values() if not cls.type_params and not cls.is_anonymous and
+                            any(subject_type in [self._split_type_args(i)[0] for i in c.implements]
+                                for c, _ in self._class_chain(cls.name))]
+            missing = [cls.name for cls in implementors
+                       if not any(c.name in covered_types for c, _ in self._class_chain(cls.name))]
+            if not implementors:
+                missing = ['_']
+        else:
+            missing = ['_']
+        
+        what = subject_type or 'a value of unknown type'
+        if missing == ['_']:
+            raise self._error(f"{self._match_keyword(expr)} on {what} is not exhaustive "
+                              f"(add a {self._match_catch_all(expr)})", expr)
+        if missing:
+            raise self._error(
+                f"{self._match_keyword(expr)} on {what} is not exhaustive: missing {', '.join(missing)} "
+                f"(add the cases or a {self._match_catch_all(expr)})", expr)
+    
+    def _match_type(self, expr: MatchExpr, expected: Optional[str] = None) -> Optional[str]:
+        """Unifies the arm values of a match (the expected type, when known, wins)"""
+        subject, subject_type, arms = self._analyze_match(expr)
+        if expected:
+            return expected
+        
+        # Bindings are only visible inside their arm, so each value is inferred with its arm's names bound
+        values = []
+        types = []
+        for info in arms:
+            if isinstance(info['arm'].body, BlockStmt):
+                return None
+            previous = self._bind_arm(info)
+            types.append(self._infer_type(info['arm'].body))
+            values.append(info['arm'].body)
+            self._unbind_arm(previous)
+        return self._unify_types(values, f'Arms of {self._match_keyword(expr)}', expr, "; declare the variable type, e.g. var x T = ...",
+                                 types)
+    
+    def _match_form(self, subject_type: Optional[str], arms: List[dict]) -> str:
+        """'value' (switch s), 'type' (switch v := s.(type)) or 'chain' (if/else if with comma-ok tests)"""
+        if any(info['arm'].guard for info in arms):
+            return 'chain'
+        if all(isinstance(p, (ValuePattern, WildcardPattern)) for info in arms for p in info['patterns']):
+            return 'value'
+        if self._class_info(subject_type):
+            return 'chain'
+        for info in arms:
+            pattern = info['patterns'][0]
+            if isinstance(pattern, WildcardPattern):
+                continue
+            if not self._irrefutable(pattern) or isinstance(pattern, ValuePattern):
+                return 'chain'
+            cls = self.classes.get(pattern.type.lstrip('*'))
+            if cls and cls.name not in self.foreign_classes and len(self._subclass_paths(cls)) > 1:
+                return 'chain'  # asX also matches subclasses, a type switch case would not
+        return 'type'
+    
+    def _emit_arm_body(self, info: dict, emit_value, bindings: Optional[Dict[str, Tuple[str, str]]] = None) -> None:
+        """Emits the value (through emit_value) or block of an arm with its names bound"""
+        if bindings is not None:
+            info = dict(info, bindings=bindings)
+        previous = self._bind_arm(info)
+        body = info['arm'].body
+        if isinstance(body, BlockStmt):
+            if self.value_context:
+                raise self._error(
+                    "A match used as a value needs an expression after '->', not a block", info['arm'])
+            self._emit_block_stmt(body)
+        else:
+            emit_value(body)
+        self._unbind_arm(previous)
+    
+    def _emit_match(self, expr: MatchExpr, emit_value, value_needed: bool = True, terminate: bool = False) -> None:
+        """Emits a match as a switch, a type switch or an if/else chain; emit_value emits each arm's value.
+        With terminate, a match without _ ends in a panic so the enclosing function has a terminating statement."""
+        subject, subject_type, arms = self._analyze_match(expr)
+        outer_context = self.value_context
+        self.value_context = value_needed
+        form = self._match_form(subject_type, arms)
+        exhausted = arms[-1]['always']
+        failure = lambda value: f'panic(NewException("MatchError", fmt.Sprintf("no match arm for %v", {value})))'
+        
+        if form == 'value':
+            self._emit_line(f'switch {subject} {{')
+            for info in arms:
+                if info['wildcard']:
+                    self._emit_line('default:')
+                else:
+                    codes = [self._value_pattern_code(p, subject_type) for p in info['patterns']]
+                    self._emit_line(f'case {", ".join(codes)}:')
+                self._indent()
+                self._emit_arm_body(info, emit_value)
+                self._dedent()
+            if terminate and not exhausted:
+                self.required_imports.add('fmt')
+                self._emit_line('default:')
+                self._indent()
+                self._emit_line(failure(subject))
+                self._dedent()
+            self._emit_line('}')
+        
+        elif form == 'type':
+            bound = [info for info in arms if any(self._references([info['arm'].body], b) for b in info['bindings'])]
+            names = {p.binding for info in bound for p in info['patterns'] if isinstance(p, TypePattern)}
+            variable = names.pop() if len(names) == 1 and not any(
+                isinstance(info['patterns'][0], DestructurePattern) for info in bound) else 'value'
+            header = f'switch {variable} := {subject}.(type) {{' if bound else f'switch {subject}.(type) {{'
+            self._emit_line(header)
+            for info in arms:
+                pattern = info['patterns'][0]
+                bindings = {}
+                if info['wildcard']:
+                    self._emit_line('default:')
+                else:
+                    self._emit_line(f'case {info["type"]}:')
+                    if isinstance(pattern, TypePattern) and pattern.binding:
+                        bindings[pattern.binding] = (variable, info['type'])
+                    elif isinstance(pattern, DestructurePattern):
+                        cls = self.classes.get(pattern.type.lstrip('*'))
+                        self._destructure(pattern, cls, variable, [], bindings)
+                self._indent()
+                self._emit_arm_body(info, emit_value, bindings)
+                self._dedent()
+            if terminate and not exhausted:
+                self.required_imports.add('fmt')
+                self._emit_line('default:')
+                self._indent()
+                self._emit_line(failure(subject))
+                self._dedent()
+            self._emit_line('}')
+        
+        else:
+            wrapped = not re.fullmatch(r'[A-Za-z_]\w*', subject)
+            if wrapped:
+                # The subject is evaluated once, into a temporary the arms test
+                name, suffix = 'subject', 1
+                while self._lookup(name):
+                    suffix += 1
+                    name = f'subject{suffix}'
+                self._emit_line('{')
+                self._indent()
+                self._emit_line(f'{name} := {subject}')
+                self._push_scope()
+                self._declare(name, subject_type)
+                temporary = MatchExpr(Identifier(name), expr.arms, expr.is_switch)
+                temporary.line, temporary.column, temporary.file = expr.line, expr.column, expr.file
+                subject, subject_type, arms = self._analyze_match(temporary)
+            
+            for i, info in enumerate(arms):
+                previous = self._bind_arm(info)
+                conditions = list(info['conditions'])
+                if info['arm'].guard:
+                    conditions.append(self._operand_to_string(info['arm'].guard, BINARY_PRECEDENCE['&&'] + 1))
+                self._unbind_arm(previous)
+                
+                condition = ' && '.join(conditions) or 'true'
+                test = f'{info["init"]}; {condition}' if info['init'] else condition
+                keyword = 'if' if i == 0 else '} else if'
+                if info['always']:
+                    self._emit_line('} else {' if i else '{')
+                else:
+                    self._emit_line(f'{keyword} {test} {{')
+                self._indent()
+                self._emit_arm_body(info, emit_value)
+                self._dedent()
+            self._emit_line('}')
+            if terminate and not exhausted:
+                self.required_imports.add('fmt')
+                self._emit_line(failure(subject))
+            
+            if wrapped:
+                self._pop_scope()
+                self._dedent()
+                self._emit_line('}')
+        
+        self.value_context = outer_context
+    
+    def _lower_match(self, expr: MatchExpr) -> str:
+        """A match nested in an expression -> func() T { <match returning each arm's value> }()"""
+        result_type = self._match_type(expr)
+        if not result_type:
+            raise self._error(f"Cannot infer the type of {self._match_keyword(expr)} expression; "
+                              "convert an arm, e.g. T(x)", expr)
+        
+        outer_output = self.output
+        self.output = []
+        self._indent()
+        self._emit_match(expr, lambda value: self._emit_line(f'return {self._unparenthesized(value)}'), terminate=True)
+        self._dedent()
+        lines, self.output = self.output, outer_output
+        return f'func() {result_type} {{\n' + '\n'.join(lines) + '\n' + '    ' * self.indent_level + '}()'
+    
+    def _lower_is(self, expr: IsExpr) -> str:
+        """Type test used as a value"""
+        union = self._union_operand(expr.expr, expr.type, expr)
+        if union:
+            return f'{self._expr_to_string(expr.expr)}.Is{self._variant_accessor(union[1])}()'
+        operand = self._expr_to_string(expr.expr)
+        cls = self._tested_class(expr)
+        if cls:
+            return f'is{cls.name}({operand})'
+        return f'func() bool {{ _, ok := {operand}.({expr.type}); return ok }}()'
+    
+    # ------------------------------------------------------------------------
+    # Type aliases and unions
+    # ------------------------------------------------------------------------
+    
+    def _union_variants(self, union: TypeDecl) -> List[str]:
+        """Variant types of a union; classes are held by pointer, value classes by value"""
+        return [self._instance_type(variant) if variant in self.classes else variant for variant in union.variants]
+    
+    def _union_variant(self, union: TypeDecl, type_name: Optional[str]) -> Optional[str]:
+        """The variant of a union a type selects (Circle and *Circle select the class variant)"""
+        if not type_name:
+            return None
+        type_name = self.type_aliases.get(type_name, type_name)
+        return next((v for v in self._union_variants(union) if v == type_name or
+                     (v.startswith('*') and v[1:] == type_name.lstrip('*'))), None)
+    
+    def _variant_accessor(self, variant: str) -> str:
+        """Name suffix of the accessors of a variant: int -> IsInt()/AsInt(), *models.Person -> AsPerson()"""
+        name = variant.lstrip('*').rpartition('.')[2]
+        return name[0].upper() + name[1:]
+    
+    def _check_union(self, decl: TypeDecl) -> None:
+        """Variants must be distinct named types, each with its own accessors"""
+        accessors = {}
+        for variant in decl.variants:
+            if not re.fullmatch(r'\*?[A-Za-z_][\w.]*', variant):
+                raise self._error(f"Variant {variant} of union {decl.name} must be a named type", decl)
+            accessor = self._variant_accessor(variant)
+            if accessor in accessors:
+                raise self._error(f"Variants {accessors[accessor]} and {variant} of union {decl.name} would both "
+                                  f"generate As{accessor}()", decl)
+            accessors[accessor] = variant
+    
+    def _union_value(self, expr: Expression, union: TypeDecl) -> str:
+        """Wraps a value assigned to a union: 5 -> Number{5}. A value whose type is not known is checked
+        at run time (NumberOf(v) throws InvalidVariant); one of another known type is an error"""
+        code = self._unparenthesized(expr)
+        value_type = self._infer_type(expr)
+        if value_type == union.name:
+            return code
+        
+        variants = self._union_variants(union)
+        constant = self._constant_value(expr)
+        if constant and not constant[2]:
+            # An untyped constant takes its default type, else the first variant of a compatible kind
+            default = {'int': 'int', 'float': 'float64', 'string': 'string', 'bool': 'bool'}[constant[1]]
+            if default in variants:
+                return f'{union.name}{{{code}}}'
+            kinds = ('int', 'float') if constant[1] == 'int' else (constant[1],)
+            variant = next((v for v in variants if self.CONSTANT_KINDS.get(v) in kinds), None)
+            if variant:
+                return f'{union.name}{{{variant}({code})}}'
+        elif self._union_variant(union, value_type):
+            return f'{union.name}{{{code}}}'
+        
+        known = value_type in self.CONSTANT_KINDS or value_type in self.unions or value_type in self.enums or \
+            self._class_info(value_type) or self._untyped_constant(expr) == 'nil' or constant
+        if known:
+            described = self._expr_to_string(expr)
+            if value_type and not isinstance(expr, Literal):
+                described += f' ({value_type})'
+            raise self._error(f"Cannot use {described} as {union.name} (variants: {', '.join(variants)})", expr)
+        return f'{union.name}Of({code})'
+    
+    def _union_operand(self, expr: Expression, type_name: str, node: ASTNode) -> Optional[Tuple[str, str]]:
+        """For a type test or cast of a union value: the wrapped value (n.Value()) and the tested variant"""
+        union = self.unions.get(self._infer_type(expr))
+        if not union:
+            return None
+        variant = self._union_variant(union, type_name)
+        if not variant:
+            raise self._error(f"{type_name} is not a variant of {union.name} "
+                              f"(variants: {', '.join(self._union_variants(union))})", node)
+        return f'{self._expr_to_string(expr)}.Value()', variant
+    
+    def _emit_union_decl(self, decl: TypeDecl) -> None:
+        """Emits a union as a struct wrapping one value of a variant type, with a checked constructor
+        and an IsX()/AsX() accessor pair per variant"""
+        self._check_union(decl)
+        self.exception_types.add('Exception')
+        self.required_imports.add('fmt')
+        variants = self._union_variants(decl)
+        listed = ', '.join(variants[:-1]) + f' or {variants[-1]}'
+        
+        self._emit_line(f'// {decl.name} holds a value of type {listed}')
+        self._emit_line(f'type {decl.name} struct {{')
+        self._indent()
+        self._emit_line('value any')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line(f'// {decl.name}Of wraps a value, throwing InvalidVariant unless it has type {listed}')
+        self._emit_line(f'func {decl.name}Of(value any) {decl.name} {{')
+        self._indent()
+        self._emit_line('switch value.(type) {')
+        self._emit_line(f'case {", ".join(variants)}:')
+        self._indent()
+        self._emit_line(f'return {decl.name}{{value}}')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line(f'panic(NewException("InvalidVariant", fmt.Sprintf("%T is not a variant of {decl.name}", value)))')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('// Value returns the wrapped value')
+        self._emit_line(f'func (this {decl.name}) Value() any {{')
+        self._indent()
+        self._emit_line('return this.value')
+        self._dedent()
+        self._emit_line('}')
+        
+        for variant in variants:
+            accessor = self._variant_accessor(variant)
+            self._emit_line()
+            self._emit_line(f'// Is{accessor} reports whether the value has type {variant}')
+            self._emit_line(f'func (this {decl.name}) Is{accessor}() bool {{')
+            self._indent()
+            self._emit_line(f'_, ok := this.value.({variant})')
+            self._emit_line('return ok')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line()
+            self._emit_line(f'// As{accessor} returns the {variant} value, throwing InvalidVariant when it has another type')
+            self._emit_line(f'func (this {decl.name}) As{accessor}() {variant} {{')
+            self._indent()
+            self._emit_line(f'value, ok := this.value.({variant})')
+            self._emit_line('if !ok {')
+            self._indent()
+            self._emit_line(f'panic(NewException("InvalidVariant", fmt.Sprintf("{decl.name} holds %T, not {variant}", '
+                            f'this.value)))')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line('return value')
+            self._dedent()
+            self._emit_line('}')
+    
+    # ------------------------------------------------------------------------
+    # Comparable classes (CompareTo)
+    # ------------------------------------------------------------------------
+    
+    def _compare_to(self, decl: ClassDecl) -> Optional[MethodDecl]:
+        """Returns the CompareTo(other *T) int method a class declares, checking its signature"""
+        method = next((m for m in decl.methods if m.name == 'CompareTo'), None)
+        if not method:
+            return None
+        
+        instance_type = self._instance_type(decl.name)
+        if len(method.params) != 1 or method.params[0].type != instance_type or method.return_type != 'int':
+            raise self._error(
+                f"{decl.name}.CompareTo must have the signature CompareTo(other {instance_type}) int", method)
+        return method
+    
+    def _emit_sort_helpers(self, decl: ClassDecl) -> None:
+        """Emits PersonSlice, a sort.Interface ordering []*Person by CompareTo"""
+        self.required_imports.add('sort')
+        slice_name = f'{decl.name}Slice'
+        type_params = self._type_params_string(decl.type_params)
+        slice_type = slice_name + self._class_type(decl.name)[len(decl.name):]
+        
+        element_type = self._instance_type(decl.name)
+        self._emit_line(f'// {slice_name} attaches the methods of sort.Interface to []{element_type.split("[")[0]}, '
+                        f'sorting in increasing CompareTo order')
+        self._emit_line(f'type {slice_name}{type_params} []{element_type}')
+        self._emit_line()
+        self._emit_line(f'func (s {slice_type}) Len() int {{ return len(s) }}')
+        self._emit_line(f'func (s {slice_type}) Less(i, j int) bool {{ return s[i].CompareTo(s[j]) < 0 }}')
+        self._emit_line(f'func (s {slice_type}) Swap(i, j int) {{ s[i], s[j] = s[j], s[i] }}')
+        self._emit_line()
+        self._emit_line('// Sort sorts the slice in place; elements that compare equal keep their order')
+        self._emit_line(f'func (s {slice_type}) Sort() {{ sort.Stable(s) }}')
+        self._emit_line()
+    
+    # ------------------------------------------------------------------------
+    # String interpolation
+    # ------------------------------------------------------------------------
+    
+    INTEGER_TYPES = {'int', 'int8', 'int16', 'int32', 'int64', 'uint', 'uint8', 'uint16', 'uint32', 'uint64',
+                     'uintptr', 'byte'}
+    
+    def _number_literal(self, expr: Literal, negative: bool = False) -> str:
+        """Emits a number, checking that a suffixed one fits its type (300u8 is an error)"""
+        value = -expr.value if negative else expr.value
+        if expr.go_type and not fits_number(value, expr.go_type):
+            raise self._error(f"Constant {'-' if negative else ''}{expr.text} overflows {expr.go_type}", expr)
+        if expr.type == 'float' and value in (float('inf'), float('-inf')):
+            raise self._error(f"Constant {expr.text} overflows float64", expr)
+        return number_literal(expr.text or str(expr.value), expr.go_type, negative)
+    
+    def _lower_interpolation(self, expr: InterpolatedString) -> str:
+        """"Hi ${name}, ${age}" -> fmt.Sprintf("Hi %s, %d", name, age), with verbs from the static types"""
+        layout = ''
+        args = []
+        for part in expr.parts:
+            if isinstance(part, Literal):
+                layout += part.value.replace('%', '%%')
+            else:
+                layout += '%' + (part.spec or self._format_verb(self._infer_type(part.expr)))
+                args.append(self._unparenthesized(part.expr))
+        
+        self.required_imports.add('fmt')
+        return f'fmt.Sprintf({self._expr_to_string(Literal(layout, "string"))}, {", ".join(args)})'
+    
+    def _format_verb(self, type_name: Optional[str]) -> str:
+        """fmt verb for a value of a static type (%v when unknown)"""
+        if type_name == 'string':
+            return 's'
+        if type_name == 'bool':
+            return 't'
+        if type_name in self.INTEGER_TYPES:
+            return 'd'
+        if type_name in ('float32', 'float64'):
+            return 'g'
+        return 'v'
+    
+    # ------------------------------------------------------------------------
+    # Constant evaluation
+    # ------------------------------------------------------------------------
+    
+    # Kind of the values of each basic type a typed constant can have
+    CONSTANT_KINDS = {**{name: 'int' for name in INTEGER_TYPES | {'rune'}}, 'float32': 'float', 'float64': 'float',
+                      'string': 'string', 'bool': 'bool'}
+    
+    def _constant_value(self, expr: Expression, owner: Optional[ClassDecl] = None,
+                        scoped: bool = True) -> Optional[Tuple]:
+        """Evaluates a constant expression the way the Go compiler does: exact arithmetic (floats as
+        fractions), string concatenation, comparisons, logic and cond ? a : b with a constant condition.
+        Returns (value, kind, type) with kind 'int', 'float', 'string' or 'bool' and the Go type of a typed
+        constant, or None when the expression is not constant (left to the Go compiler).
+        Names resolve to constants of the owner class, then to package constants, unless a variable shadows them"""
+        if isinstance(expr, Literal):
+            if expr.type in ('string', 'bool'):
+                return (expr.value, expr.type, None)
+            if expr.type == 'int':
+                return (expr.value, 'int', expr.go_type)
+            if expr.type == 'float':
+                try:
+                    return (Fraction(expr.text), 'float', expr.go_type)
+                except (TypeError, ValueError):
+                    return (Fraction(expr.value), 'float', expr.go_type)
+            return None
+        
+        if isinstance(expr, Identifier):
+            if scoped and self._lookup(expr.name):
+                return None
+            constant = owner and next((c for c in owner.constants if c.name == expr.name), None)
+            if constant:
+                return self._declared_constant(constant, owner)
+            if expr.name in self.constants:
+                return self._declared_constant(self.constants[expr.name])
+            return None
+        
+        if isinstance(expr, SelectorExpr):
+            reference = self._class_constant(expr, scoped)
+            return self._declared_constant(reference[1], reference[0]) if reference else None
+        
+        if isinstance(expr, CallExpr):
+            # Conversions between basic types and len of a constant string
+            if not isinstance(expr.function, Identifier) or len(expr.args) != 1 or \
+                    (scoped and self._lookup(expr.function.name)):
+                return None
+            arg = self._constant_value(expr.args[0], owner, scoped)
+            if arg and expr.function.name == 'len' and arg[1] == 'string':
+                return (len(arg[0].encode('utf-8', 'surrogateescape')), 'int', None)
+            if arg and expr.function.name in self.CONSTANT_KINDS:
+                return self._converted_constant(arg, expr.function.name)
+            return None
+        
+        if isinstance(expr, TernaryExpr):
+            condition = self._constant_value(expr.condition, owner, scoped)
+            if not condition or condition[1] != 'bool':
+                return None
+            return self._constant_value(expr.then_expr if condition[0] else expr.else_expr, owner, scoped)
+        
+        if isinstance(expr, UnaryExpr):
+            operand = self._constant_value(expr.operand, owner, scoped)
+            if not operand:
+                return None
+            value, kind, go_type = operand
+            if expr.operator == '!' and kind == 'bool':
+                return (not value, 'bool', None)
+            if expr.operator in ('-', '+') and kind in ('int', 'float'):
+                return (-value if expr.operator == '-' else value, kind, go_type)
+            if expr.operator == '^' and kind == 'int':
+                # Bitwise complement: all bits of an unsigned type flipped, -x-1 otherwise
+                if go_type and self.CONSTANT_KINDS.get(go_type) == 'int' and \
+                        SIZED_TYPES.get(go_type, go_type).startswith('uint'):
+                    return (NUMBER_RANGES[SIZED_TYPES.get(go_type, go_type)][1] - value, 'int', go_type)
+                return (-value - 1, 'int', go_type)
+            return None
+        
+        if isinstance(expr, BinaryExpr):
+            left = self._constant_value(expr.left, owner, scoped)
+            right = self._constant_value(expr.right, owner, scoped) if left else None
+            if not right:
+                return None
+            return self._binary_constant(expr, left, right)
+        
+        return None
+    
+    def _binary_constant(self, expr: BinaryExpr, left: Tuple, right: Tuple) -> Optional[Tuple]:
+        """Applies a binary operator to two constants"""
+        operator = expr.operator
+        (a, left_kind, left_type), (b, right_kind, right_type) = left, right
+        if operator in ('<<', '>>'):
+            # The shift count stays untyped; the result has the type of the shifted operand
+            if left_kind != 'int' or right_kind != 'int' or not 0 <= b <= 512:
+                return None
+            return (a << b if operator == '<<' else a >> b, 'int', left_type)
+        
+        if left_type and right_type and left_type != right_type:
+            return None
+        go_type = left_type or right_type
+        kind = left_kind
+        if left_kind != right_kind:
+            # Untyped numbers take the kind of the other operand (an int becomes a float)
+            if {left_kind, right_kind} != {'int', 'float'}:
+                return None
+            kind = self.CONSTANT_KINDS.get(go_type, 'float')
+            if kind == 'int':
+                if (left_kind == 'float' and a.denominator != 1) or (right_kind == 'float' and b.denominator != 1):
+                    return None
+                a, b = int(a), int(b)
+            else:
+                a, b = Fraction(a), Fraction(b)
+        
+        if operator in ('==', '!=', '<', '<=', '>', '>='):
+            if kind == 'bool' and operator not in ('==', '!='):
+                return None
+            results = {'==': a == b, '!=': a != b, '<': a < b, '<=': a <= b, '>': a > b, '>=': a >= b}
+            return (results[operator], 'bool', None)
+        if kind == 'bool':
+            if operator not in ('&&', '||'):
+                return None
+            return (a and b if operator == '&&' else a or b, 'bool', None)
+        if kind == 'string':
+            return (a + b, 'string', go_type) if operator == '+' else None
+        
+        if operator in ('/', '%') and b == 0:
+            raise self._error("Division by zero in constant expression", expr)
+        if operator in ('+', '-', '*'):
+            value = a + b if operator == '+' else a - b if operator == '-' else a * b
+        elif operator == '/' and kind == 'float':
+            value = a / b
+        elif kind != 'int':
+            return None
+        elif operator in ('/', '%'):
+            # Integer division truncates toward zero, as in Go
+            quotient = abs(a) // abs(b) * (1 if (a < 0) == (b < 0) else -1)
+            value = quotient if operator == '/' else a - b * quotient
+        elif operator in ('&', '|', '^', '&^'):
+            value = a & b if operator == '&' else a | b if operator == '|' else a ^ b if operator == '^' else a & ~b
+        else:
+            return None
+        return (value, kind, go_type)
+    
+    def _converted_constant(self, constant: Tuple, go_type: str) -> Optional[Tuple]:
+        """Gives a constant a basic type (float64(3), a typed const declaration), if it is representable"""
+        value, kind, _ = constant
+        target = self.CONSTANT_KINDS.get(go_type)
+        if target == kind:
+            return (value, kind, go_type)
+        if target == 'float' and kind == 'int':
+            return (Fraction(value), 'float', go_type)
+        if target == 'int' and kind == 'float' and value.denominator == 1:
+            return (int(value), 'int', go_type)
+        return None
+    
+    def _declared_constant(self, decl: ConstDecl, owner: Optional[ClassDecl] = None) -> Optional[Tuple]:
+        """Value of a package or class constant, evaluated once"""
+        key = id(decl)
+        if key in self.constant_values:
+            return self.constant_values[key]
+        if key in self.evaluating_constants:
+            raise self._error(f"Constant {decl.name} refers to itself", decl)
+        self.evaluating_constants.add(key)
+        try:
+            # The declaration is evaluated in its own scope, not in the function that refers to it
+            constant = self._constant_value(decl.value, owner, scoped=False)
+        finally:
+            self.evaluating_constants.discard(key)
+        if constant and decl.type:
+            constant = self._converted_constant(constant, decl.type)
+        self.constant_values[key] = constant
+        return constant
+    
+    def _class_constant(self, expr: Expression, scoped: bool = True) -> Optional[Tuple[ClassDecl, ConstDecl]]:
+        """Resolves Box.Max, or Max inside a method of Box, to the class constant"""
+        if isinstance(expr, SelectorExpr) and isinstance(expr.object, Identifier) and \
+                expr.object.name in self.classes and not (scoped and self._lookup(expr.object.name)):
+            owner, name = self.classes[expr.object.name], expr.field
+        elif isinstance(expr, Identifier) and self.current_class in self.classes and not self._lookup(expr.name):
+            owner, name = self.classes[self.current_class], expr.name
+        else:
+            return None
+        constant = next((c for c in owner.constants if c.name == name), None)
+        return (owner, constant) if constant else None
+    
+    def _constant_literal(self, constant: Tuple, node: ASTNode, converted: bool = True) -> str:
+        """Emits a constant value as a Go literal; typed numbers become conversions (int64(50)) unless the
+        declaration carries the type"""
+        value, kind, go_type = constant
+        if kind == 'string':
+            return quote_string(value)
+        if kind == 'bool':
+            return 'true' if value else 'false'
+        if kind == 'int':
+            text = str(value)
+        else:
+            try:
+                number = float(value)
+            except OverflowError:
+                number = float('inf')
+            if number in (float('inf'), float('-inf')):
+                raise self._error(f"Constant overflows {go_type or 'float64'}", node)
+            text = repr(number)
+        if go_type and not fits_number(value, go_type):
+            raise self._error(f"Constant {text} overflows {go_type}", node)
+        return f'{go_type}({text})' if go_type and converted else text
+    
+    def _folded(self, expr: Expression, owner: Optional[ClassDecl] = None) -> str:
+        """Emits an operation on constants as its value (Size / 2 -> 50, Debug ? "dev" : "prod" -> "dev");
+        literals, names and other expressions as usual"""
+        if isinstance(expr, (BinaryExpr, UnaryExpr, TernaryExpr)):
+            constant = self._constant_value(expr, owner or self.classes.get(self.current_class))
+            if constant:
+                return self._constant_literal(constant, expr)
+        return self._unparenthesized(expr)
+    
+    # ------------------------------------------------------------------------
+    # Events
+    # ------------------------------------------------------------------------
+    
+    def _event_of(self, expr: Expression) -> Optional[EventDecl]:
+        """Returns the event a selector refers to (car.OnLowFuel), including events of base classes"""
+        if not isinstance(expr, SelectorExpr):
+            return None
+        object_type = self._infer_type(expr.object)
+        if not object_type or not object_type.startswith('*'):
+            return None
+        for cls, _ in self._class_chain(self._split_type_args(object_type[1:])[0]):
+            event = next((e for e in cls.events if e.name == expr.field), None)
+            if event:
+                return event
+        return None
+    
+    def _lower_event_subscription(self, stmt: AssignStmt, event: EventDecl) -> str:
+        """car.OnLowFuel += handler -> car.AddOnLowFuel(handler), -= -> RemoveOnLowFuel"""
+        methods = {'+=': 'Add', '-=': 'Remove'}
+        if stmt.operator not in methods:
+            raise TranspilerError(
+                f"Event {event.name} can only be subscribed with += or unsubscribed with -= (found {stmt.operator})")
+        obj = self._expr_to_string(stmt.target.object)
+        return f'{obj}.{methods[stmt.operator]}{event.name}({self._unparenthesized(stmt.value)})'
+    
+    # ------------------------------------------------------------------------
+    # Accessor inlining (--inline-accessors)
+    # ------------------------------------------------------------------------
+    
+    def _inlined_accessor(self, expr: CallExpr) -> Optional[Tuple[str, str]]:
+        """Returns ('get', field) or ('set', field) when a call is to a trivial accessor that may be replaced
+        by the field itself: p.GetName() -> p.name, p.SetName(n) -> p.name = n"""
+        if not self.inline_accessors or not isinstance(expr.function, SelectorExpr) or expr.function.optional:
+            return None
+        info = self._class_info(self._infer_type(expr.function.object))
+        if not info or expr.spread:
+            return None
+        chain = self._class_chain(info[0].name, info[1])
+        owner = next(((cls, mapping) for cls, mapping in chain
+                      if any(m.name == expr.function.field for m in cls.methods)), None)
+        if not owner:
+            return None
+        cls, mapping = owner
+        method = next(m for m in cls.methods if m.name == expr.function.field)
+        if method.annotations or method.type_params or method.operator or len(method.body.statements) != 1:
+            return None
+    
+        stmt = method.body.statements[0]
+        if isinstance(stmt, RawStmt):
+            code = stmt.code
+        elif isinstance(stmt, ReturnStmt) and isinstance(stmt.value, SelectorExpr) and \
+                isinstance(stmt.value.object, ThisExpr):
+            code = f'return this.{stmt.value.field}'
+        elif isinstance(stmt, AssignStmt) and stmt.operator == '=' and isinstance(stmt.target, SelectorExpr) and \
+                isinstance(stmt.target.object, ThisExpr) and isinstance(stmt.value, Identifier):
+            code = f'this.{stmt.target.field} = {stmt.value.name}'
+        else:
+            return None
+        getter = re.fullmatch(r'return this\.(\w+)', code)
+        setter = re.fullmatch(r'this\.(\w+) = (\w+)', code)
+        if getter and not method.params and method.return_type:
+            kind, field_name, value_type = 'get', getter.group(1), method.return_type
+        elif setter and len(method.params) == 1 and method.params[0].name == setter.group(2) and not method.return_type:
+            kind, field_name, value_type = 'set', setter.group(1), method.params[0].type
+        else:
+            return None
+    
+        # The field the accessor reads must be the one the caller would reach, with the same type
+        # (GetShape() Shape returning a *Circle field stays a call), and visible from this package
+        declaring = next((c for c, _ in chain[chain.index(owner):] if any(f.name == field_name for f in c.fields)), None)
+        reached = next((c for c, _ in chain if any(f.name == field_name for f in c.fields)), None)
+        if not declaring or declaring is not reached:
+            return None
+        if self._class_field_type(cls.name, field_name, mapping) != self._substitute_type(value_type, mapping):
+            return None
+        if declaring.name in self.foreign_classes and not field_name[:1].isupper():
+            return None
+        return kind, field_name
+    
+    def _lower_setter_call(self, expr: Expression) -> Optional[str]:
+        """p.SetName(n) -> p.name = n with --inline-accessors, for a call used as a statement"""
+        if not isinstance(expr, CallExpr):
+            return None
+        accessor = self._inlined_accessor(expr)
+        if not accessor or accessor[0] != 'set':
+            return None
+        self._check_arguments(expr)
+        obj = self._expr_to_string(expr.function).rpartition('.')[0]
+        return f'{obj}.{accessor[1]} = {self._args_to_string(expr)}'
+    
+    # ------------------------------------------------------------------------
+    # Mixins
+    # ------------------------------------------------------------------------
+    
+    def _apply_mixins(self, decl: ClassDecl) -> None:
+        """Flattens the fields and methods of a class's mixins into it, rejecting conflicts
+        between mixins and inherited members unless an 'override X from Y' picks the provider"""
+        if not (decl.mixins or decl.overrides) or decl.mixins_applied:
+            return
+        decl.mixins_applied = True
         
-    def transpile(self, program: Program) -> str:
-        """Transpiles the program to Go"""
-        self.output = []
-        self.indent_level = 0
+        # Base classes carry their own mixin members, so they are flattened first
+        ancestors = [cls for cls, _ in self._class_chain(decl.name)[1:]] if decl.name in self.classes else []
+        for ancestor in ancestors:
+            self._apply_mixins(ancestor)
+        inherited = {}
+        for ancestor in ancestors:
+            for member in ancestor.fields + ancestor.methods:
+                inherited.setdefault(member.name, (ancestor.name, member))
+        # Diamond: a mixin already used by a base class is inherited once, not flattened again
+        inherited_mixins = {name for ancestor in ancestors for name in ancestor.mixins}
         
-        # First pass: collect class information
-        self._collect_classes(program)
+        resolutions = {}
+        for override in decl.overrides:
+            if override.member in resolutions:
+                raise self._error(
+                    f"Duplicate override of {override.member} in class {decl.name}", override)
+            resolutions[override.member] = override
         
-        # Second pass: generate code
-        self._emit_program(program)
+        own = {member.name: member for member in decl.fields + decl.methods}
+        conflicts = []
+        provided: Dict[str, List[Tuple[str, ASTNode]]] = {}  # member name -> (mixin, member) in 'with' order
+        for mixin_name in decl.mixins:
+            mixin = self.mixins.get(mixin_name)
+            if not mixin:
+                raise TranspilerError(f"Class {decl.name} uses undefined mixin {mixin_name}")
+            if mixin_name in inherited_mixins:
+                continue
+            
+            for member in mixin.fields + mixin.methods:
+                node = own.get(member.name)
+                if node:
+                    # A class may override a mixin method with its own method
+                    if not (isinstance(member, MethodDecl) and isinstance(node, MethodDecl)):
+                        conflicts.append(f"{member.name} is defined by both {decl.name} ({self._position(node)}) "
+                                         f"and {mixin.name} ({self._position(member)})")
+                    continue
+                provided.setdefault(member.name, []).append((mixin.name, member))
         
-        return '\n'.join(self.output)
+        chosen = []
+        for name, providers in provided.items():
+            candidates = ([inherited[name]] if name in inherited else []) + providers
+            override = resolutions.pop(name, None)
+            if len(candidates) == 1:
+                if override:
+                    raise TranspilerError(f"override {name} from {override.source} does not resolve a conflict "
+                                          f"in class {decl.name} ({self._position(override)})")
+                chosen.append(candidates[0][1])
+            elif not override:
+                sources = ' and '.join(f"{owner} ({self._position(node)})" for owner, node in candidates)
+                conflicts.append(f"{name} is defined by {'both ' if len(candidates) == 2 else ''}{sources} "
+                                 f"(resolve with 'override {name} from {candidates[0][0]}')")
+            else:
+                winner = next((candidate for candidate in candidates
+                               if override.source in (candidate[0], candidate[0].rpartition('.')[2])), None)
+                if not winner:
+                    raise TranspilerError(
+                        f"override {name} from {override.source}: {override.source} does not define {name} "
+                        f"(it comes from {', '.join(owner for owner, _ in candidates)}) ({self._position(override)})")
+                # An inherited winner stays promoted from the base class; a mixin winner is flattened over it
+                if winner is not candidates[0] or name not in inherited:
+                    chosen.append(winner[1])
+        for name, override in resolutions.items():
+            raise TranspilerError(f"override {name} from {override.source} does not resolve a conflict "
+                                  f"in class {decl.name} ({self._position(override)})")
+        
+        if conflicts:
+            raise TranspilerError(f"Member conflict in class {decl.name}: " + '; '.join(conflicts))
+        
+        # Flattened mixin by mixin, in 'with' order
+        contributed = [member for providers in provided.values() for _, member in providers
+                       if any(member is c for c in chosen)]
+        decl.fields = decl.fields + [copy.deepcopy(m) for m in contributed if not isinstance(m, MethodDecl)]
+        decl.methods = decl.methods + [copy.deepcopy(m) for m in contributed if isinstance(m, MethodDecl)]
     
-    def _collect_classes(self, program: Program) -> None:
-        """Collects information about classes and exceptions"""
-        for decl in program.declarations:
-            if isinstance(decl, ClassDecl):
-                self.classes[decl.name] = decl
+    def _position(self, node: ASTNode) -> str:
+        """Formats a node's source position for diagnostics"""
+        return source_position(node)
+    
+    def _error(self, message: str, node: ASTNode) -> TranspilerError:
+        """The error of code at node that cannot be generated, which reads followed by its position"""
+        text = f"{message} ({self._position(node)})"
+        return TranspilerError(text, error_diagnostic(TranspilerError.code, message, node, text=text))
+    
+    # ------------------------------------------------------------------------
+    # Partial classes
+    # ------------------------------------------------------------------------
+    
+    def _register_partial(self, part: ClassDecl) -> None:
+        """Merges one part of a partial class into the consolidated class, rejecting duplicate members"""
+        name = part.name
+        merged = self.partial_classes.get(name)
+        if not merged:
+            if name in self.classes:
+                raise TranspilerError(
+                    f"Class {name} is declared both partial and non-partial (every part must be partial)")
+            merged = ClassDecl(name, None, [], [], None, type_params=part.type_params, is_partial=True)
+            merged.line, merged.column, merged.file = part.line, part.column, part.file
+            self.partial_classes[name] = merged
+            self.partial_parts[name] = []
+            self.classes[name] = merged
+        self.partial_parts[name].append(part)
         
-        # Detect exception usage
-        self._detect_exceptions(program)
+        def signature(type_params):
+            return [(tp.name, tp.constraint) for tp in type_params]
+        if signature(part.type_params) != signature(merged.type_params):
+            raise self._error(
+                f"Parts of partial class {name} declare different type parameters", part)
+        
+        if part.extends:
+            if merged.extends and merged.extends != part.extends:
+                raise self._error(
+                    f"Partial class {name} extends both {merged.extends} and {part.extends}", part)
+            merged.extends = part.extends
+        merged.implements += [i for i in part.implements if i not in merged.implements]
+        merged.mixins += [m for m in part.mixins if m not in merged.mixins]
+        merged.annotations += [a for a in part.annotations
+                               if not any(other.name == a.name for other in merged.annotations)]
+        merged.comments = [*merged.comments, *part.comments]
+        merged.static_blocks += part.static_blocks
+        merged.init_blocks += part.init_blocks
+        merged.events += part.events
+        merged.overrides += part.overrides
+        
+        def members(decl):
+            return decl.fields + decl.methods + decl.events + decl.constants
+        owners = {member.name: member for member in members(merged)}
+        duplicates = [f"{member.name} is declared at {self._position(owners[member.name])} "
+                      f"and {self._position(member)}"
+                      for member in members(part) if member.name in owners]
+        if part.constructor and merged.constructor:
+            duplicates.append(f"constructor is declared at {self._position(merged.constructor)} "
+                              f"and {self._position(part.constructor)}")
+        if part.destructor and merged.destructor:
+            duplicates.append(f"~{name}() is declared at {self._position(merged.destructor)} "
+                              f"and {self._position(part.destructor)}")
+        if duplicates:
+            raise TranspilerError(f"Duplicate member in partial class {name}: " + '; '.join(duplicates))
+        
+        merged.fields += part.fields
+        merged.methods += part.methods
+        merged.constants += part.constants
+        merged.conversions += part.conversions
+        merged.constructor = merged.constructor or part.constructor
+        merged.destructor = merged.destructor or part.destructor
+        if merged.destructor and any(m.name == 'Dispose' for m in merged.methods):
+            raise TranspilerError(f"Class {name} declares both ~{name}() and Dispose()")
+    
+    def _owned_class(self, decl: ClassDecl) -> Optional[ClassDecl]:
+        """Class whose Go type a declaration emits (one part of a partial class emits the whole type)"""
+        if not decl.is_partial:
+            return decl
+        parts = self.partial_parts[decl.name]
+        # The part with the constructor declares the type; otherwise the first registered part
+        primary = next((p for p in parts if p.constructor), parts[0])
+        return self.partial_classes[decl.name] if primary is decl else None
+    
+    def _emit_partial_class(self, part: ClassDecl) -> None:
+        """Emits the parts of a partial class declared in this file"""
+        parts = self.partial_parts[part.name]
+        local = [p for p in parts if any(p is decl for decl in self.current_program.declarations)]
+        if part is not local[0]:
+            return
+        
+        merged = next((self._owned_class(p) for p in local if self._owned_class(p)), None)
+        if merged:
+            # Methods of parts in other files of the package are emitted by those files
+            foreign = [m for p in parts if not any(p is own for own in local) for m in p.methods]
+            self._emit_class_decl(merged, foreign)
+            return
+        
+        self.current_class = part.name
+        for p in local:
+            for method in p.methods:
+                self._emit_from(method, self._emit_method, part.name, method)
+                self._emit_line()
+        self.current_class = None
+    
+    # ------------------------------------------------------------------------
+    # Records
+    # ------------------------------------------------------------------------
+    
+    def _check_record_assignment(self, target: Expression) -> None:
+        """Rejects assignments to record fields outside the record's constructor"""
+        if not isinstance(target, SelectorExpr):
+            return
+        if isinstance(target.object, ThisExpr) and self.current_receiver == 'obj':
+            return
+        
+        info = self._class_info(self._infer_type(target.object))
+        if info and info[0].is_record and any(f.name == target.field for f in info[0].fields):
+            raise TranspilerError(
+                f"Cannot assign to field {target.field} of record {info[0].name} (use a with expression)")
+    
+    def _check_value_receiver_assignment(self, target: Expression) -> None:
+        """Rejects assignments to this.x in the methods of a @valueclass, whose receiver is a copy"""
+        root = target
+        while isinstance(root, SelectorExpr):
+            root = root.object
+        if root is not target and isinstance(root, ThisExpr) and self.current_receiver != 'obj' and \
+                self._is_value_class(self.current_class):
+            raise self._error(
+                f"Cannot assign to {self._expr_to_string(target)} in a method of value class {self.current_class}: "
+                f"its receiver is a copy (return a new {self.current_class} instead)", target)
+    
+    def _lower_with(self, expr: WithExpr) -> str:
+        """p with { age: 30 } -> p.copyWith(func(c *Person) { c.age = 30 })"""
+        record_type = self._infer_type(expr.target)
+        info = self._class_info(record_type)
+        if not info or not info[0].is_record:
+            raise TranspilerError(f"with expression requires a record (got {record_type or 'unknown type'})")
+        
+        record = info[0]
+        assignments = []
+        for name, value in expr.updates:
+            if not any(f.name == name for f in record.fields):
+                raise TranspilerError(f"Record {record.name} has no field {name}")
+            assignments.append(f'c.{name} = {self._unparenthesized(value)}')
+        
+        target = self._expr_to_string(expr.target)
+        return f'{target}.copyWith(func(c *{record_type.lstrip("*")}) {{ ' + '; '.join(assignments) + ' })'
     
     def _detect_exceptions(self, node) -> None:
         """Recursively detects exception usage"""
@@ -49,6 +4501,21 @@ class Transpiler:
         elif isinstance(node, CallExpr) and isinstance(node.function, Identifier):
             if node.function.name == 'NewException':
                 self.exception_types.add('Exception')
+        elif isinstance(node, MethodDecl) and node.name == 'IndexLookup':
+            # The generated IndexGet throws KeyNotFound
+            self.exception_types.add('Exception')
+        elif isinstance(node, CastExpr) and not node.safe:
+            # Failed casts throw ClassCastException
+            self.exception_types.add('Exception')
+        elif isinstance(node, MatchExpr):
+            # A match without _ used as a value throws MatchError when no arm applies
+            self.exception_types.add('Exception')
+        elif isinstance(node, ClassDecl) and node.name in self.cast_classes:
+            # castX helpers are emitted next to the class
+            self.exception_types.add('Exception')
+        elif isinstance(node, ClassDecl) and self.generator.has_annotation(node, 'json'):
+            # Generated JSON methods throw FormatException
+            self.exception_types.add('Exception')
         
         # Recurse into all attributes that are lists or nodes
         for attr_name in dir(node):
@@ -63,11 +4530,48 @@ class Transpiler:
                 self._detect_exceptions(attr)
     
     def _emit(self, text: str) -> None:
-        """Emits text with indentation"""
-        if text.strip():
-            self.output.append('    ' * self.indent_level + text)
-        else:
-            self.output.append('')
+        """Emits text with indentation, attributed to the node being emitted (lines captured from an earlier
+        emission keep their own origin)"""
+        line = GoLine('    ' * self.indent_level + text if text.strip() else '')
+        line.origin = getattr(text, 'origin', None) or self.origin
+        self.output.append(line)
+    
+    def _emit_from(self, node: ASTNode, emit, *args) -> None:
+        """Runs an emitter with node as the origin of the lines it emits (the enclosing one if node has no
+        position), with the node's comments above its Go code and its trailing comment after it (a class places
+        its own, around its struct)"""
+        outer = self.origin
+        if node.line:
+            self.origin = node
+        try:
+            if not isinstance(node, ClassDecl):
+                self._emit_comments(node.comments)
+            start = len(self.output)
+            emit(*args)
+            if not isinstance(node, ClassDecl):
+                self._emit_trailing_comment(node.trailing_comment, start)
+        except TranspilerError as e:
+            # An error raised without a node is at the innermost statement generating it
+            for diagnostic in e.diagnostics:
+                if diagnostic.origin is None:
+                    diagnostic.origin = self.origin
+            raise
+        finally:
+            self.origin = outer
+    
+    def _emit_comments(self, comments: List[str]) -> None:
+        """Emits source comments at the current indentation, one Go line per line of a block comment"""
+        for comment in comments:
+            for line in comment.split('\n'):
+                self._emit(line)
+    
+    def _emit_trailing_comment(self, comment: Optional[str], start: int) -> None:
+        """Appends a source comment to the last Go line emitted from output index start on"""
+        last = next((i for i in range(len(self.output) - 1, start - 1, -1) if self.output[i].strip()), None)
+        if comment and last is not None:
+            line = GoLine(f'{self.output[last]} {comment}')
+            line.origin = self.output[last].origin
+            self.output[last] = line
     
     def _emit_line(self, text: str = '') -> None:
         """Emits a line"""
@@ -83,7 +4587,37 @@ class Transpiler:
     
     def _emit_program(self, program: Program) -> None:
         """Emits the program"""
-        # Package
+        # Declarations first, so the imports required by generated helpers are known
+        for decl in program.declarations:
+            self._emit_from(decl, self._emit_declaration, decl)
+            self._emit_line()
+        self._emit_static_init(program)
+        body = self.output
+        self.output = []
+        
+        # Generate types for exceptions (only if not in project mode)
+        if self.exception_types and not self.project_mode:
+            self._emit_exception_types()
+            self._emit_line()
+            if self.uses_goroutine_runtime:
+                self._emit_goroutine_runtime()
+                self._emit_line()
+        
+        if self.uses_class_metadata and self.emit_class_runtime:
+            self._emit_class_runtime()
+            self._emit_line()
+        
+        if self.uses_string_runtime and self.emit_string_runtime:
+            self._emit_string_runtime()
+            self._emit_line()
+        runtime = self.output
+        self.output = []
+        body = self._name_receivers(self._drop_plain_blocks(body))
+        if self.exceptions_package:
+            body = self._qualify_exception_runtime(body)
+        
+        # Package, after the file's header comments
+        self._emit_comments(program.comments)
         self._emit_line(f'package {program.package}')
         self._emit_line()
         
@@ -92,34 +4626,114 @@ class Transpiler:
         
         # User imports
         for imp in program.imports:
-            if imp.path.startswith('"') and imp.path.endswith('"'):
-                all_imports.add(imp.path)
-            else:
-                all_imports.add(f'"{imp.path}"')
+            all_imports.add((imp.path.strip('"'), imp.alias))
         
         # Required imports for exceptions
         if self.exception_types:
-            all_imports.add('"fmt"')
-            all_imports.add('"errors"')
+            all_imports.add(('fmt', None))
+        
+        # Required imports for generated helpers
+        for imp_path in self.required_imports:
+            all_imports.add((imp_path, None))
         
-        if all_imports:
+        # Only the imports the emitted code refers to: Go rejects unused ones
+        names = referenced_names('\n'.join(runtime + body))
+        used = sorted((imp for imp in all_imports if import_used(*imp, names)), key=lambda imp: (imp[0], imp[1] or ''))
+        if used:
             self._emit_line('import (')
             self._indent()
-            for imp_path in sorted(all_imports):
-                self._emit_line(imp_path)
+            for imp_path, alias in used:
+                self._emit_line(f'{alias} "{imp_path}"' if alias else f'"{imp_path}"')
             self._dedent()
             self._emit_line(')')
             self._emit_line()
         
-        # Generate types for exceptions (only if not in project mode)
-        if self.exception_types and not self.project_mode:
-            self._emit_exception_types()
-            self._emit_line()
+        self.output.extend(runtime)
+        self.output.extend(body)
+    
+    def _qualify_exception_runtime(self, lines: List[str]) -> List[str]:
+        """Refers to the exception runtime through the package declaring it (exceptions.NewException), keeping
+        the origins of the lines"""
+        qualified = iter(qualify('\n'.join(lines), self.EXCEPTION_RUNTIME_NAMES, self.exceptions_package).split('\n'))
+        result = []
+        for line in lines:
+            text = GoLine('\n'.join(next(qualified) for _ in range(line.count('\n') + 1)))
+            text.origin = getattr(line, 'origin', None)
+            result.append(text)
+        return result
+    
+    def _drop_plain_blocks(self, lines: List[str]) -> List[str]:
+        """Removes the braces of bare { } blocks that declare nothing directly inside them, which scope nothing
+        and only add a level of nesting; their lines move out one level, keeping their origins"""
+        pairs = {}
+        open_blocks = []  # [index of '{', its indentation, whether the block declares a name]
+        for i, line in enumerate(lines):
+            first = line.split('\n')[0]
+            text = first.strip()
+            indent = len(first) - len(first.lstrip(' '))
+            if open_blocks and indent == open_blocks[-1][1] + 4 and PLAIN_BLOCK_DECLARATION.match(text):
+                open_blocks[-1][2] = True
+            if text == '{':
+                open_blocks.append([i, indent, False])
+            elif text == '}' and open_blocks and open_blocks[-1][1] == indent:
+                start, _, declares = open_blocks.pop()
+                if not declares:
+                    pairs[start] = i
         
-        # Declarations
-        for decl in program.declarations:
-            self._emit_declaration(decl)
-            self._emit_line()
+        result = []
+        depth = 0
+        ends = []
+        for i, line in enumerate(lines):
+            if i in pairs:
+                ends.append(pairs[i])
+                depth += 1
+                continue
+            if ends and ends[-1] == i:
+                ends.pop()
+                depth -= 1
+                continue
+            if depth and line.startswith('    ' * depth):
+                text = GoLine(line[4 * depth:])
+                text.origin = getattr(line, 'origin', None)
+                line = text
+            result.append(line)
+        return result
+    
+    def _name_receivers(self, lines: List[str]) -> List[str]:
+        """Renames the receiver of generated methods (emitted as this) after the configured style, one name per
+        type. A name one of the type's methods already uses (a parameter or local p) is not taken: p2 is tried
+        next, then p3 and so on"""
+        if self.receiver == 'this':
+            return lines
+        methods = []  # (first line, last line, receiver type)
+        used: Dict[str, Set[str]] = {}
+        i = 0
+        while i < len(lines):
+            header = METHOD_HEADER.match(lines[i])
+            if header:
+                end = next((j for j in range(i, len(lines)) if lines[j] == '}'), len(lines) - 1)
+                methods.append((i, end, header.group(1)))
+                used.setdefault(header.group(1), set()).update(identifiers('\n'.join(lines[i:end + 1])))
+                i = end
+            i += 1
+        
+        names = {}
+        for type_name, taken in used.items():
+            base = self.receiver if self.receiver != 'initial' else (type_name.lstrip('_')[:1].lower() or 'this')
+            name, suffix = base, 1
+            while name in taken:
+                suffix += 1
+                name = f'{base}{suffix}'
+            names[type_name] = name
+        
+        result = list(lines)
+        for start, end, type_name in methods:
+            renamed = iter(rename('\n'.join(lines[start:end + 1]), 'this', names[type_name]).split('\n'))
+            for k in range(start, end + 1):
+                text = GoLine('\n'.join(next(renamed) for _ in range(lines[k].count('\n') + 1)))
+                text.origin = getattr(lines[k], 'origin', None)
+                result[k] = text
+        return result
     
     def _emit_import(self, imp: ImportDecl) -> None:
         """Emits import"""
@@ -167,6 +4781,126 @@ class Transpiler:
         self._dedent()
         self._emit_line('}')
     
+    def _emit_goroutine_runtime(self) -> None:
+        """Emits the global handler for exceptions escaping goroutines, and the recovery deferred by go statements"""
+        self._emit_line('// UncaughtExceptionHandler receives the exceptions that escape a goroutine (replace it to log or exit)')
+        self._emit_line('var UncaughtExceptionHandler = func(ex Exception) {')
+        self._indent()
+        self._emit_line('fmt.Fprintf(os.Stderr, "uncaught exception in goroutine: %s: %s\\n", ex.Type(), ex.Error())')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        self._emit_line('// RecoverGoroutine is deferred by go statements: it hands a panic to UncaughtExceptionHandler')
+        self._emit_line('func RecoverGoroutine() {')
+        self._indent()
+        self._emit_line('if r := recover(); r != nil {')
+        self._indent()
+        self._emit_line('ex, ok := r.(Exception)')
+        self._emit_line('if !ok {')
+        self._indent()
+        self._emit_line('ex = NewException("RuntimeError", fmt.Sprintf("%v", r))')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('UncaughtExceptionHandler(ex)')
+        self._dedent()
+        self._emit_line('}')
+        self._dedent()
+        self._emit_line('}')
+    
+    def _emit_class_runtime(self) -> None:
+        """Emits the class descriptor type and the package's class registry"""
+        self._emit_line('// Class metadata')
+        self._emit_line('type ClassInfo struct {')
+        self._indent()
+        self._emit_line('Name              string')
+        self._emit_line('Package           string')
+        self._emit_line('BaseName          string')
+        self._emit_line('Base              *ClassInfo')
+        self._emit_line('Interfaces        []string')
+        self._emit_line('Annotations       []AnnotationInfo')
+        self._emit_line('MethodAnnotations map[string][]AnnotationInfo')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('// AnnotationInfo is an annotation of a class or method with its constant arguments')
+        self._emit_line('type AnnotationInfo struct {')
+        self._indent()
+        self._emit_line('Name string')
+        self._emit_line('Args []interface{}')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('// Annotation finds an annotation of the class by name')
+        self._emit_line('func (c *ClassInfo) Annotation(name string) (AnnotationInfo, bool) {')
+        self._indent()
+        self._emit_line('return findAnnotation(c.Annotations, name)')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('// MethodAnnotation finds an annotation of one of the class\'s methods by name')
+        self._emit_line('func (c *ClassInfo) MethodAnnotation(method, name string) (AnnotationInfo, bool) {')
+        self._indent()
+        self._emit_line('return findAnnotation(c.MethodAnnotations[method], name)')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('func findAnnotation(annotations []AnnotationInfo, name string) (AnnotationInfo, bool) {')
+        self._indent()
+        self._emit_line('for _, annotation := range annotations {')
+        self._indent()
+        self._emit_line('if annotation.Name == name {')
+        self._indent()
+        self._emit_line('return annotation, true')
+        self._dedent()
+        self._emit_line('}')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('return AnnotationInfo{}, false')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('// IsSubclassOf reports whether the class is other or derives from it')
+        self._emit_line('func (c *ClassInfo) IsSubclassOf(other *ClassInfo) bool {')
+        self._indent()
+        self._emit_line('for current := c; current != nil; current = current.Base {')
+        self._indent()
+        self._emit_line('if current == other {')
+        self._indent()
+        self._emit_line('return true')
+        self._dedent()
+        self._emit_line('}')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('return false')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('var classRegistry = map[string]*ClassInfo{}')
+        self._emit_line()
+        
+        self._emit_line('// RegisterClass records a class descriptor under its qualified name (package.Name)')
+        self._emit_line('func RegisterClass(info *ClassInfo) *ClassInfo {')
+        self._indent()
+        self._emit_line('classRegistry[info.Package+"."+info.Name] = info')
+        self._emit_line('return info')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        
+        self._emit_line('// LookupClass finds a registered class by qualified name')
+        self._emit_line('func LookupClass(name string) (*ClassInfo, bool) {')
+        self._indent()
+        self._emit_line('info, ok := classRegistry[name]')
+        self._emit_line('return info, ok')
+        self._dedent()
+        self._emit_line('}')
+    
     def _emit_declaration(self, decl: Declaration) -> None:
         """Emits declaration"""
         if isinstance(decl, FuncDecl):
@@ -181,125 +4915,551 @@ class Transpiler:
             self._emit_struct_decl(decl)
         elif isinstance(decl, InterfaceDecl):
             self._emit_interface_decl(decl)
+        elif isinstance(decl, ClassDecl) and decl.is_partial:
+            self._emit_partial_class(decl)
         elif isinstance(decl, ClassDecl):
             self._emit_class_decl(decl)
+        elif isinstance(decl, EnumDecl):
+            self._emit_enum_decl(decl)
+        elif isinstance(decl, MixinDecl):
+            pass  # flattened into the classes that use it
+        elif isinstance(decl, ObjectDecl):
+            self._emit_object_decl(decl)
+        elif isinstance(decl, AnnotationDecl):
+            pass  # only metadata: recorded in the descriptors of the classes using it
         else:
             raise TranspilerError(f"Unsupported declaration: {type(decl)}")
     
     def _emit_func_decl(self, decl: FuncDecl) -> None:
         """Emits function declaration"""
         params = ', '.join(f'{p.name} {p.type}' for p in decl.params)
+        type_params = self._type_params_string(decl.type_params)
         
         if decl.return_type:
-            self._emit_line(f'func {decl.name}({params}) {decl.return_type} {{')
+            self._emit_line(f'func {decl.name}{type_params}({params}) {decl.return_type} {{')
         else:
-            self._emit_line(f'func {decl.name}({params}) {{')
+            self._emit_line(f'func {decl.name}{type_params}({params}) {{')
         
+        self._push_scope()
+        for param in decl.params:
+            self._declare(param.name, param.type, param.nullable or None)
+        
+        self._indent()
+        self._emit_body(decl.name, decl.return_type, decl.body)
+        self._dedent()
+        self._emit_line('}')
+        self._pop_scope()
+    
+    def _emit_body(self, name: str, return_type: Optional[str], body: BlockStmt) -> None:
+        """Emits a function or method body; iterator bodies are wrapped in a range-over-func closure"""
+        if return_type and return_type.startswith('iter.Seq'):
+            self.required_imports.add('iter')
+        if not self._contains_yield(body):
+            outer_return_type = self.current_return_type
+            self.current_return_type = return_type
+            self._emit_block_stmt(body)
+            self.current_return_type = outer_return_type
+            return
+        
+        seq, element_types = self._split_type_args(return_type or '')
+        if seq not in ('iter.Seq', 'iter.Seq2'):
+            raise TranspilerError(f"{name} uses yield but does not return an iterator (declare it as {name}() yield T)")
+        self._check_yield_placement(name, body)
+        
+        self._emit_line(f'return func(yield func({", ".join(element_types)}) bool) {{')
         self._indent()
-        self._emit_block_stmt(decl.body)
+        self.current_iterator = element_types
+        self._emit_block_stmt(body)
+        self.current_iterator = None
         self._dedent()
         self._emit_line('}')
     
+    def _contains_yield(self, node) -> bool:
+        """Checks whether a subtree contains a yield statement"""
+        if isinstance(node, YieldStmt):
+            return True
+        if isinstance(node, (list, tuple)):
+            return any(self._contains_yield(item) for item in node)
+        if isinstance(node, ASTNode):
+            return any(self._contains_yield(attr) for attr in field_values(node))
+        return False
+    
+    def _check_yield_placement(self, name: str, node) -> None:
+        """Rejects yield inside try/catch: the catch would also intercept exceptions thrown by the consuming loop"""
+        if isinstance(node, TryStmt) and node.catch_blocks and self._contains_yield(node.body):
+            raise TranspilerError(f"yield cannot appear in a try block with catch clauses (iterator {name})")
+        if isinstance(node, (list, tuple)):
+            for item in node:
+                self._check_yield_placement(name, item)
+        elif isinstance(node, ASTNode):
+            for attr in field_values(node):
+                self._check_yield_placement(name, attr)
+    
     def _emit_var_decl(self, decl: VarDecl) -> None:
         """Emits variable declaration"""
+        self._declare(decl.name, decl.type or (self._infer_type(decl.value) if decl.value else None),
+                      True if decl.nullable else self._declared_nil_state(decl))  # any function may reset it
         if decl.type and decl.value:
-            value = self._expr_to_string(decl.value)
+            value = self._value_to_string(decl.value, decl.type)
             self._emit_line(f'var {decl.name} {decl.type} = {value}')
         elif decl.type:
             self._emit_line(f'var {decl.name} {decl.type}')
         elif decl.value:
-            value = self._expr_to_string(decl.value)
+            value = self._unparenthesized(decl.value)
             self._emit_line(f'var {decl.name} = {value}')
         else:
             raise TranspilerError("Variable must have type or value")
     
-    def _emit_const_decl(self, decl: ConstDecl) -> None:
-        """Emits constant declaration"""
-        value = self._expr_to_string(decl.value)
+    def _emit_const_decl(self, decl: ConstDecl, owner: Optional[ClassDecl] = None) -> None:
+        """Emits constant declaration, folding computed values (class constants are prefixed: BoxMax)"""
+        name = f'{owner.name}{decl.name}' if owner else decl.name
+        constant = None
+        if isinstance(decl.value, (BinaryExpr, UnaryExpr, TernaryExpr)):
+            constant = self._declared_constant(decl, owner)
+        if constant:
+            value = self._constant_literal(constant, decl, converted=not decl.type)
+        else:
+            value = self._unparenthesized(decl.value)
         if decl.type:
-            self._emit_line(f'const {decl.name} {decl.type} = {value}')
+            self._emit_line(f'const {name} {decl.type} = {value}')
         else:
-            self._emit_line(f'const {decl.name} = {value}')
+            self._emit_line(f'const {name} = {value}')
     
     def _emit_type_decl(self, decl: TypeDecl) -> None:
         """Emits type declaration"""
-        self._emit_line(f'type {decl.name} {decl.type}')
+        if decl.variants:
+            self._emit_union_decl(decl)
+        elif decl.is_alias:
+            self._emit_line(f'type {decl.name} = {decl.type}')
+        else:
+            self._emit_line(f'type {decl.name} {decl.type}')
+    
+    def _emit_struct_decl(self, decl: StructDecl) -> None:
+        """Emits struct declaration"""
+        self._emit_line(f'type {decl.name} struct {{')
+        self._indent()
+        for field in decl.fields:
+            self._emit_comments(field.comments)
+            self._emit_line(f'{field.name} {field.type}')
+            self._emit_trailing_comment(field.trailing_comment, len(self.output) - 1)
+        self._dedent()
+        self._emit_line('}')
+    
+    def _emit_interface_decl(self, decl: InterfaceDecl) -> None:
+        """Emits interface declaration"""
+        self._emit_line(f'type {decl.name}{self._type_params_string(decl.type_params)} interface {{')
+        self._indent()
+        for element in decl.type_elements:
+            self._emit_line(element)
+        for method in decl.methods:
+            params = ', '.join(f'{p.name} {p.type}' for p in method.params)
+            if method.return_type:
+                if method.return_type.startswith('iter.Seq'):
+                    self.required_imports.add('iter')
+                self._emit_line(f'{method.name}({params}) {method.return_type}')
+            else:
+                self._emit_line(f'{method.name}({params})')
+        self._dedent()
+        self._emit_line('}')
+    
+    def _emit_class_decl(self, decl: ClassDecl, excluded: Sequence[MethodDecl] = ()) -> None:
+        """Emits class declaration (converted to struct + methods)"""
+        self.current_class = decl.name
+        
+        for constant in decl.constants:
+            self._emit_from(constant, self._emit_const_decl, constant, decl)
+        if decl.constants:
+            self._emit_line()
+        
+        # Struct for the class
+        self._emit_comments(decl.comments)
+        self._emit_deprecation(decl.annotations)
+        self._emit_line(f'type {decl.name}{self._type_params_string(decl.type_params)} struct {{')
+        self._indent()
+        
+        # Inheritance (embedding)
+        if decl.extends:
+            self._emit_line(f'*{decl.extends}' if self.embed_pointers else f'{decl.extends}')
+        
+        # Fields
+        for field in decl.fields:
+            self._emit_comments(field.comments)
+            tag = self._struct_tag(decl, field)
+            if tag:
+                self._emit_line(f'{field.name} {field.type} {tag}')
+            else:
+                # Fields with initial values are initialized in the constructor
+                self._emit_line(f'{field.name} {field.type}')
+            self._emit_trailing_comment(field.trailing_comment, len(self.output) - 1)
+        
+        self._dedent()
+        self._emit_line('}')
+        self._emit_trailing_comment(decl.trailing_comment, len(self.output) - 1)
+        self._emit_line()
+        
+        if decl.events:
+            self.required_imports.add('sync')
+            self._emit_line(f'var {self.generator.events_lock(decl)} sync.Mutex')
+            self._emit_line()
+        
+        # Constructor (anonymous classes and singletons are created at a single generated site)
+        if decl.constructor:
+            self._emit_from(decl.constructor, self._emit_constructor, decl.name, decl.constructor, decl.fields)
+            self._emit_line()
+        elif decl.is_anonymous or any(backing is decl for backing in self.objects.values()):
+            pass
+        else:
+            # Default constructor
+            self._emit_default_constructor(decl.name, decl.fields)
+            self._emit_line()
+        
+        # Methods
+        for method in decl.methods:
+            if any(method is other for other in excluded):
+                continue
+            self._emit_from(method, self._emit_method, decl.name, method)
+            self._emit_line()
+        
+        for conversion in decl.conversions:
+            self._emit_conversion(conversion)
+            self._emit_line()
+        
+        self._emit_key_not_found_getter(decl)
+        
+        if self._has_class_metadata(decl):
+            self._emit_class_metadata(decl)
+        
+        if decl.static_blocks:
+            self._emit_static_block(decl)
+        
+        if decl.name in self.type_checked and not decl.type_params and not self._is_value_class(decl.name):
+            self._emit_type_check_helpers(decl)
+        
+        if self._compare_to(decl):
+            self._emit_sort_helpers(decl)
+        
+        builder = self.generator.builders.get(decl.name)
+        if builder:
+            self._emit_class_decl(builder)
+            self.current_class = decl.name
+        
+        # Static assertions for declared interfaces (generic classes need a generic scope)
+        if decl.implements and decl.type_params:
+            self._emit_line(f'func _{self._type_params_string(decl.type_params)}() {{')
+            self._indent()
+            for iface_name in decl.implements:
+                self._emit_line(f'var _ {iface_name} = {self._zero_instance(decl.name)}')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line()
+        elif decl.implements:
+            for iface_name in decl.implements:
+                self._emit_line(f'var _ {iface_name} = {self._zero_instance(decl.name)}')
+            self._emit_line()
+        
+        self.current_class = None
+    
+    def _struct_tag(self, decl: ClassDecl, field: ClassField) -> str:
+        """Builds the Go struct tag of a field: @json("name", "omitempty") @db("full_name")"""
+        parts = []
+        # encoding/json ignores private fields: their json tags only drive the methods generated for @json
+        private_json = not field.name[:1].isupper()
+        for i, tag in enumerate(field.tags):
+            if any(other.name == tag.name for other in field.tags[:i]):
+                raise self._error(
+                    f"Duplicate struct tag @{tag.name} on field {decl.name}.{field.name}", tag)
+            if tag.name in ClassGenerator.FIELD_ANNOTATIONS:
+                continue
+            if not tag.args or not all(isinstance(a, Literal) and a.type == 'string' for a in tag.args):
+                raise self._error(
+                    f"Struct tag @{tag.name} on field {decl.name}.{field.name} expects string arguments", tag)
+            value = ','.join(a.value for a in tag.args)
+            if '`' in value or '"' in value:
+                raise self._error(
+                    f"Struct tag @{tag.name} on field {decl.name}.{field.name} cannot contain quotes", tag)
+            if not (private_json and tag.name == 'json'):
+                parts.append(f'{tag.name}:"{value}"')
+        return '`' + ' '.join(parts) + '`' if parts else ''
+    
+    def _has_class_metadata(self, decl: ClassDecl) -> bool:
+        """Generated builders and singleton backing types are not registered as classes"""
+        if any(builder is decl for builder in self.generator.builders.values()):
+            return False
+        return not any(backing is decl for backing in self.objects.values())
+    
+    def _emit_class_metadata(self, decl: ClassDecl) -> None:
+        """Emits the registered descriptor of a class and its GetType() method"""
+        self.uses_class_metadata = True
+        info = [f'Name: "{decl.name}"', f'Package: "{self.current_package}"']
+        if decl.extends:
+            base_name = self._split_type_args(decl.extends)[0]
+            info.append(f'BaseName: "{base_name}"')
+            if base_name in self.classes and '.' not in base_name:
+                # Descriptors of other packages live in their own registry
+                info.append(f'Base: {self._class_info_var(base_name)}')
+        if decl.implements:
+            interfaces = ', '.join(f'"{self._split_type_args(i)[0]}"' for i in decl.implements)
+            info.append(f'Interfaces: []string{{{interfaces}}}')
+        if decl.annotations:
+            info.append(f'Annotations: []AnnotationInfo{{{self._annotation_infos(decl.annotations)}}}')
+        annotated = [m for m in decl.methods if m.annotations]
+        if annotated:
+            methods = ', '.join(f'"{m.name}": {{{self._annotation_infos(m.annotations)}}}' for m in annotated)
+            info.append(f'MethodAnnotations: map[string][]AnnotationInfo{{{methods}}}')
+        
+        info_var = self._class_info_var(decl.name)
+        self._emit_line(f'var {info_var} = RegisterClass(&ClassInfo{{{", ".join(info)}}})')
+        self._emit_line()
+        if any(m.name == 'GetType' for m in decl.methods):
+            return
+        self._emit_line(f'func (this {self._instance_type(decl.name)}) GetType() *ClassInfo {{')
+        self._indent()
+        self._emit_line(f'return {info_var}')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+    
+    def _annotation_infos(self, annotations: List[Annotation]) -> str:
+        """Elements of an []AnnotationInfo literal: {Name: "route", Args: []interface{}{"GET", "/"}}"""
+        infos = []
+        for annotation in annotations:
+            if annotation.args:
+                args = ', '.join(self._unparenthesized(arg) for arg in annotation.args)
+                infos.append(f'{{Name: "{annotation.name}", Args: []interface{{}}{{{args}}}}}')
+            else:
+                infos.append(f'{{Name: "{annotation.name}"}}')
+        return ', '.join(infos)
+    
+    def _class_info_var(self, class_name: str) -> str:
+        """Package variable holding a class descriptor (Person -> personClass)"""
+        return f'{self._lower_first(class_name)}Class'
+    
+    def _emit_static_block(self, decl: ClassDecl) -> None:
+        """Emits the static initializer blocks of a class as one function called from init()"""
+        for block in decl.static_blocks:
+            if self._contains_this(block):
+                raise self._error(
+                    f"'this' is not available in the static block of class {decl.name}", block)
+        
+        self.current_class = None
+        self._emit_line(f'func {self._lower_first(decl.name)}StaticInit() {{')
+        self._indent()
+        self._push_scope()
+        for block in decl.static_blocks:
+            for stmt in block.statements:
+                self._emit_statement(stmt)
+        self._pop_scope()
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line()
+        self.current_class = decl.name
     
-    def _emit_struct_decl(self, decl: StructDecl) -> None:
-        """Emits struct declaration"""
-        self._emit_line(f'type {decl.name} struct {{')
+    def _contains_this(self, node) -> bool:
+        """Checks whether a subtree references this or super"""
+        if isinstance(node, (ThisExpr, SuperExpr)):
+            return True
+        if isinstance(node, (list, tuple)):
+            return any(self._contains_this(item) for item in node)
+        if isinstance(node, ASTNode):
+            return any(self._contains_this(attr) for attr in field_values(node))
+        return False
+    
+    def _emit_static_init(self, program: Program) -> None:
+        """Emits init() running static blocks once, parents before subclasses, otherwise in source order"""
+        owned = [self._owned_class(d) for d in program.declarations if isinstance(d, ClassDecl)]
+        classes = [d for d in owned if d and d.static_blocks]
+        if not classes:
+            return
+        
+        ordered = []
+        def visit(decl: ClassDecl) -> None:
+            if decl in ordered:
+                return
+            parent = self.classes.get(self._split_type_args(decl.extends)[0]) if decl.extends else None
+            if parent in classes:
+                visit(parent)
+            ordered.append(decl)
+        for decl in classes:
+            visit(decl)
+        
+        self._emit_line('func init() {')
         self._indent()
-        for field in decl.fields:
-            self._emit_line(f'{field.name} {field.type}')
+        for decl in ordered:
+            self._emit_line(f'{self._lower_first(decl.name)}StaticInit()')
         self._dedent()
         self._emit_line('}')
+        self._emit_line()
     
-    def _emit_interface_decl(self, decl: InterfaceDecl) -> None:
-        """Emits interface declaration"""
-        self._emit_line(f'type {decl.name} interface {{')
+    def _emit_object_decl(self, decl: ObjectDecl) -> None:
+        """Emits a singleton object: its struct, methods and a sync.Once guarded accessor"""
+        backing = self.objects[decl.name]
+        self._emit_class_decl(backing)
+        
+        instance = f'{self._lower_first(decl.name)}Instance'
+        once = f'{self._lower_first(decl.name)}Once'
+        self.required_imports.add('sync')
+        
+        self._emit_line('var (')
         self._indent()
-        for method in decl.methods:
-            params = ', '.join(f'{p.name} {p.type}' for p in method.params)
-            if method.return_type:
-                self._emit_line(f'{method.name}({params}) {method.return_type}')
-            else:
-                self._emit_line(f'{method.name}({params})')
+        self._emit_line(f'{instance} *{backing.name}')
+        self._emit_line(f'{once} sync.Once')
+        self._dedent()
+        self._emit_line(')')
+        self._emit_line()
+        
+        self._emit_line(f'// {decl.name} returns the {decl.name} singleton, creating it on first use')
+        self._emit_line(f'func {decl.name}() *{backing.name} {{')
+        self._indent()
+        self._emit_line(f'{once}.Do(func() {{')
+        self._indent()
+        self._emit_line(f'{instance} = &{backing.name}{{}}')
+        for f in backing.fields:
+            if f.value:
+                self._emit_line(f'{instance}.{f.name} = {self._unparenthesized(f.value)}')
+        self._dedent()
+        self._emit_line('})')
+        self._emit_line(f'return {instance}')
         self._dedent()
         self._emit_line('}')
+        self._emit_line()
     
-    def _emit_class_decl(self, decl: ClassDecl) -> None:
-        """Emits class declaration (converted to struct + methods)"""
-        self.current_class = decl.name
+    def _emit_enum_decl(self, decl: EnumDecl) -> None:
+        """Emits enum declaration (typed constants plus generated helper tables)"""
+        names_table = f'{self._lower_first(decl.name)}Names'
+        values_table = f'{self._lower_first(decl.name)}Table'
         
-        # Struct for the class
-        self._emit_line(f'type {decl.name} struct {{')
+        self._emit_line(f'type {decl.name} int')
+        self._emit_line()
+        
+        self._emit_line('const (')
         self._indent()
+        for i, member in enumerate(decl.members):
+            if i == 0:
+                self._emit_line(f'{decl.name}{member.name} {decl.name} = iota')
+            else:
+                self._emit_line(f'{decl.name}{member.name}')
+        self._dedent()
+        self._emit_line(')')
+        self._emit_line()
         
-        # Inheritance (embedding)
-        if decl.extends:
-            self._emit_line(f'{decl.extends}')
+        names = ', '.join(f'"{member.name}"' for member in decl.members)
+        self._emit_line(f'var {names_table} = [...]string{{{names}}}')
+        self._emit_line()
         
-        # Fields
-        for field in decl.fields:
-            if field.value:
-                # Fields with initial values will be initialized in the constructor
-                self._emit_line(f'{field.name} {field.type}')
-            else:
+        # Associated values live in a table indexed by the enum value
+        if decl.fields:
+            self._emit_line(f'var {values_table} = [...]struct {{')
+            self._indent()
+            for field in decl.fields:
                 self._emit_line(f'{field.name} {field.type}')
+            self._dedent()
+            self._emit_line('}{')
+            self._indent()
+            for member in decl.members:
+                values = ', '.join(self._folded(arg) for arg in member.args)
+                self._emit_line(f'{{{values}}},')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line()
         
+        if not any(m.name == 'String' for m in decl.methods):
+            self.required_imports.add('strconv')
+            self._emit_line(f'func (this {decl.name}) String() string {{')
+            self._indent()
+            self._emit_line(f'if this < 0 || int(this) >= len({names_table}) {{')
+            self._indent()
+            self._emit_line(f'return "{decl.name}(" + strconv.Itoa(int(this)) + ")"')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line(f'return {names_table}[this]')
+            self._dedent()
+            self._emit_line('}')
+            self._emit_line()
+        
+        members = ', '.join(f'{decl.name}{member.name}' for member in decl.members)
+        self._emit_line(f'func {decl.name}Values() []{decl.name} {{')
+        self._indent()
+        self._emit_line(f'return []{decl.name}{{{members}}}')
         self._dedent()
         self._emit_line('}')
         self._emit_line()
         
-        # Constructor
-        if decl.constructor:
-            self._emit_constructor(decl.name, decl.constructor, decl.fields)
-            self._emit_line()
-        else:
-            # Default constructor
-            self._emit_default_constructor(decl.name, decl.fields)
-            self._emit_line()
+        self._emit_line(f'func {decl.name}FromString(name string) ({decl.name}, bool) {{')
+        self._indent()
+        self._emit_line(f'for i, n := range {names_table} {{')
+        self._indent()
+        self._emit_line('if n == name {')
+        self._indent()
+        self._emit_line(f'return {decl.name}(i), true')
+        self._dedent()
+        self._emit_line('}')
+        self._dedent()
+        self._emit_line('}')
+        self._emit_line('return 0, false')
+        self._dedent()
+        self._emit_line('}')
         
-        # Methods
+        # Methods (value receivers)
+        self.current_enum = decl.name
         for method in decl.methods:
-            self._emit_method(decl.name, method)
             self._emit_line()
+            params = ', '.join(f'{p.name} {p.type}' for p in method.params)
+            result = f' {method.return_type}' if method.return_type else ''
+            self._emit_line(f'func (this {decl.name}) {method.name}({params}){result} {{')
+            
+            self._push_scope()
+            for param in method.params:
+                self._declare(param.name, param.type, param.nullable or None)
+            
+            self._indent()
+            self._emit_block_stmt(method.body)
+            self._dedent()
+            self._emit_line('}')
+            self._pop_scope()
+        self.current_enum = None
+    
+    def _lower_first(self, name: str) -> str:
+        """Lowercases the first letter of a name (for unexported generated helpers)"""
+        return name[:1].lower() + name[1:]
+    
+    def _enum_reference(self, expr: Expression) -> Optional[EnumDecl]:
+        """Returns the enum when an expression names an enum type (Color in Color.Red)"""
+        if isinstance(expr, Identifier) and expr.name in self.enums and not self._lookup(expr.name):
+            return self.enums[expr.name]
+        return None
+    
+    def _check_enum_switch(self, stmt: SwitchStmt) -> Optional[EnumDecl]:
+        """Checks that a switch over an enum without default covers every member"""
+        enum = self.enums.get(self._infer_type(stmt.expression)) if stmt.expression else None
+        if not enum:
+            return None
         
-        self.current_class = None
+        member_names = [member.name for member in enum.members]
+        covered = set()
+        for case in stmt.cases:
+            for value in case.values:
+                if isinstance(value, Identifier) and value.name in member_names:
+                    covered.add(value.name)
+                elif isinstance(value, SelectorExpr) and self._enum_reference(value.object) is enum:
+                    covered.add(value.field)
+        
+        missing = [name for name in member_names if name not in covered]
+        if missing and not stmt.default_case:
+            raise TranspilerError(
+                f"Switch on {enum.name} is not exhaustive: missing {', '.join(missing)} (add the cases or a default)")
+        return enum
     
     def _emit_constructor(self, class_name: str, constructor: ConstructorDecl, fields: List[ClassField]) -> None:
         """Emits constructor"""
         params = ', '.join(f'{p.name} {p.type}' for p in constructor.params)
-        self._emit_line(f'func New{class_name}({params}) *{class_name} {{')
+        type_params = self._type_params_string(self.classes[class_name].type_params) if class_name in self.classes else ''
+        self._emit_line(f'func New{class_name}{type_params}({params}) {self._instance_type(class_name)} {{')
         self._indent()
         
-        self._emit_line(f'obj := &{class_name}{{}}')
-        
-        # Inicializa campos com valores padrão
-        for field in fields:
-            if field.value:
-                value = self._expr_to_string(field.value)
-                self._emit_line(f'obj.{field.name} = {value}')
+        statements = constructor.body.statements
+        super_call = statements[:1] if statements and self._is_super_constructor_call(statements[0]) else []
+        self._emit_allocation(class_name, fields, super_call + self._init_block_statements(class_name) +
+                              statements[len(super_call):])
         
         # Constructor body (replaces 'this' with 'obj')
         old_class = self.current_class
@@ -307,108 +5467,300 @@ class Transpiler:
         self.current_class = class_name
         self.current_receiver = 'obj'
         
-        for stmt in constructor.body.statements:
+        self._push_scope()
+        for param in constructor.params:
+            self._declare(param.name, param.type, param.nullable or None)
+        
+        # init blocks run after the base class is constructed, so they can rely on inherited fields
+        if statements and self._is_super_constructor_call(statements[0]):
+            self._emit_statement(statements[0])
+            statements = statements[1:]
+        self._emit_init_blocks(class_name)
+        
+        for stmt in statements:
             self._emit_statement(stmt)
         
+        self._pop_scope()
         self.current_class = old_class
         self.current_receiver = old_receiver
         
+        self._emit_finalizer(class_name)
         self._emit_line('return obj')
         self._dedent()
         self._emit_line('}')
     
+    def _emit_allocation(self, class_name: str, fields: List[ClassField], statements: List[Statement]) -> None:
+        """Allocates the instance in a constructor and gives the fields their initial values: constant ones in
+        the composite literal (zero values left out), others stored in declaration order. A constant the
+        constructor's statements (its super call, init blocks and body) overwrite before anything could read it
+        is not stored at all"""
+        overwritten = self._overwritten_fields(statements)
+        initial = []
+        stores = []
+        for field in fields:
+            if not field.value:
+                continue
+            value = self._field_default(field, class_name)
+            if self._constant_value(field.value, self.classes.get(class_name)) is None:
+                stores.append(f'obj.{field.name} = {value}')
+            elif field.name not in overwritten and not self._is_zero_default(field, class_name):
+                initial.append(f'{field.name}: {value}')
+        
+        address = '' if self._is_value_class(class_name) else '&'
+        self._emit_line(f'obj := {address}{self._class_type(class_name)}{{{", ".join(initial)}}}')
+        if not (statements and self._is_super_constructor_call(statements[0])):
+            self._emit_base_allocation(class_name)
+        for store in stores:
+            self._emit_line(store)
+    
+    def _overwritten_fields(self, statements: List[Statement]) -> Set[str]:
+        """Fields that statements assign (this.name = n) before any statement could read a field or let the
+        instance escape: a leading run of statements not involving this (argument checks, locals) and plain
+        stores of values computed without it"""
+        assigned = set()
+        for stmt in statements:
+            if isinstance(stmt, AssignStmt) and stmt.operator == '=' and isinstance(stmt.target, SelectorExpr) and \
+                    isinstance(stmt.target.object, ThisExpr) and not self._contains_this(stmt.value):
+                assigned.add(stmt.target.field)
+            elif self._is_super_constructor_call(stmt) and not self._contains_this(stmt.expression.args):
+                continue  # initializes the embedded base only
+            elif self._contains_this(stmt):
+                break
+        return assigned
+    
+    def _is_zero_default(self, field: ClassField, class_name: str) -> bool:
+        """Whether a field's constant initial value is the zero value of its basic type (0, "", false), which
+        the allocation already gives it"""
+        value, kind, _ = self._constant_value(field.value, self.classes.get(class_name))
+        numeric = field.type in NUMBER_RANGES or field.type in SIZED_TYPES
+        return not value and (field.type == kind or numeric and kind in ('int', 'float'))
+    
+    def _field_default(self, field: ClassField, class_name: str) -> str:
+        """Initial value of a field: folded when constant, wrapped when the field holds a union"""
+        self._check_nil_default(field, class_name)
+        if field.type in self.unions:
+            return self._union_value(field.value, self.unions[field.type])
+        return self._folded(field.value, self.classes.get(class_name))
+    
+    def _emit_base_allocation(self, class_name: str) -> None:
+        """Without a super call, pointer-embedded bases start as zero values (like embedded values), at every level"""
+        if not self.embed_pointers:
+            return
+        path = 'obj'
+        for cls, mapping in self._class_chain(class_name):
+            if not cls.extends:
+                break
+            path += '.' + self._split_type_args(cls.extends)[0].rpartition('.')[2]
+            self._emit_line(f'{path} = &{self._substitute_type(cls.extends, mapping)}{{}}')
+    
     def _emit_default_constructor(self, class_name: str, fields: List[ClassField]) -> None:
         """Emits default constructor"""
-        self._emit_line(f'func New{class_name}() *{class_name} {{')
+        type_params = self._type_params_string(self.classes[class_name].type_params) if class_name in self.classes else ''
+        self._emit_line(f'func New{class_name}{type_params}() {self._instance_type(class_name)} {{')
         self._indent()
         
-        self._emit_line(f'obj := &{class_name}{{}}')
+        self._emit_allocation(class_name, fields, self._init_block_statements(class_name))
         
-        # Inicializa campos com valores padrão
-        for field in fields:
-            if field.value:
-                value = self._expr_to_string(field.value)
-                self._emit_line(f'obj.{field.name} = {value}')
+        old_class = self.current_class
+        old_receiver = self.current_receiver
+        self.current_class = class_name
+        self.current_receiver = 'obj'
+        self._push_scope()
+        self._emit_init_blocks(class_name)
+        self._pop_scope()
+        self.current_class = old_class
+        self.current_receiver = old_receiver
         
+        self._emit_finalizer(class_name)
         self._emit_line('return obj')
         self._dedent()
         self._emit_line('}')
     
+    def _emit_init_blocks(self, class_name: str) -> None:
+        """Inlines the init blocks of a class, in source order"""
+        for stmt in self._init_block_statements(class_name):
+            self._emit_statement(stmt)
+    
+    def _init_block_statements(self, class_name: str) -> List[Statement]:
+        """Statements of the init blocks of a class, in source order"""
+        decl = self.classes.get(class_name)
+        return [stmt for block in decl.init_blocks for stmt in block.statements] if decl else []
+    
+    def _is_super_constructor_call(self, stmt: Statement) -> bool:
+        """Checks for super.Parent(args)"""
+        return isinstance(stmt, ExpressionStmt) and isinstance(stmt.expression, CallExpr) and \
+            isinstance(stmt.expression.function, SelectorExpr) and \
+            isinstance(stmt.expression.function.object, SuperExpr)
+    
+    def _emit_finalizer(self, class_name: str) -> None:
+        """Registers Dispose as finalizer for classes with a destructor"""
+        decl = self.classes.get(class_name)
+        if decl and decl.destructor:
+            self.required_imports.add('runtime')
+            self._emit_line(f'runtime.SetFinalizer(obj, (*{self._class_type(class_name)}).Dispose)')
+    
     def _emit_method(self, class_name: str, method: MethodDecl) -> None:
         """Emits method"""
+        if method.type_params:
+            # Go does not allow methods to declare their own type parameters
+            raise TranspilerError(
+                f"Method {class_name}.{method.name} cannot declare type parameters; "
+                f"declare them on the class or use a generic function")
+        
         params = ', '.join(f'{p.name} {p.type}' for p in method.params)
+        instance_type = self._instance_type(class_name)
         
+        self._emit_deprecation(method.annotations)
         if method.return_type:
-            self._emit_line(f'func (this *{class_name}) {method.name}({params}) {method.return_type} {{')
+            self._emit_line(f'func (this {instance_type}) {method.name}({params}) {method.return_type} {{')
         else:
-            self._emit_line(f'func (this *{class_name}) {method.name}({params}) {{')
+            self._emit_line(f'func (this {instance_type}) {method.name}({params}) {{')
+        
+        self._push_scope()
+        for param in method.params:
+            self._declare(param.name, param.type, param.nullable or None)
         
         self._indent()
-        self._emit_block_stmt(method.body)
+        self._emit_body(f'{class_name}.{method.name}', method.return_type, method.body)
         self._dedent()
         self._emit_line('}')
+        self._pop_scope()
+    
+    def _emit_deprecation(self, annotations: List[Annotation]) -> None:
+        """Emits the Go deprecation notice (// Deprecated: ...) of a @deprecated class or method"""
+        deprecated = next((a for a in annotations if a.name == 'deprecated'), None)
+        if deprecated:
+            message = ' '.join(deprecated.args[0].value.split()) if deprecated.args else 'do not use.'
+            if self.output and self.output[-1].lstrip().startswith('//'):
+                # A paragraph of its own after the doc comment, as godoc expects
+                self._emit_line('//')
+            self._emit_line(f'// Deprecated: {message}')
     
     def _emit_block_stmt(self, block: BlockStmt) -> None:
         """Emits block of statements"""
         for stmt in block.statements:
             self._emit_statement(stmt)
+        self._emit_comments(block.end_comments)
+    
+    def _emit_nested_body(self, stmt: Statement) -> None:
+        """Emits the body of an if or a loop inside the braces the construct already opened: a block's statements
+        without braces of their own, any other statement as is"""
+        if not isinstance(stmt, BlockStmt):
+            self._emit_statement(stmt)
+            return
+        self._push_scope()
+        self._emit_from(stmt, self._emit_block_stmt, stmt)
+        self._pop_scope()
     
     def _emit_statement(self, stmt: Statement) -> None:
-        """Emits statement"""
+        """Emits statement, attributing its Go lines to it"""
+        self._emit_from(stmt, self._emit_statement_code, stmt)
+    
+    def _emit_statement_code(self, stmt: Statement) -> None:
+        """Emits the Go code of a statement"""
         if isinstance(stmt, BlockStmt):
             self._emit_line('{')
             self._indent()
+            self._push_scope()
             self._emit_block_stmt(stmt)
+            self._pop_scope()
             self._dedent()
             self._emit_line('}')
         
         elif isinstance(stmt, ExpressionStmt):
             # Special handling for parent class constructor calls
-            if isinstance(stmt.expression, CallExpr) and isinstance(stmt.expression.function, SelectorExpr):
-                if isinstance(stmt.expression.function.object, SuperExpr):
-                    # super.ClassName(args) -> parent struct initialization
-                    parent_class = stmt.expression.function.field
-                    args = ', '.join(self._expr_to_string(arg) for arg in stmt.expression.args)
-                    receiver = getattr(self, 'current_receiver', 'this')
-                    self._emit_line(f'{receiver}.{parent_class} = *New{parent_class}({args})')
-                    return
-            
-            expr = self._expr_to_string(stmt.expression)
+            if self._is_super_constructor_call(stmt):
+                # super.ClassName(args) -> parent struct initialization
+                parent_class = stmt.expression.function.field
+                args = ', '.join(self._unparenthesized(arg) for arg in stmt.expression.args)
+                receiver = getattr(self, 'current_receiver', 'this')
+                
+                # Use the declared parent type so generic/qualified parents get the right constructor
+                parent_type = parent_class
+                current = self.classes.get(self.current_class)
+                if current and current.extends:
+                    base, _ = self._split_type_args(current.extends)
+                    if base.rpartition('.')[2] == parent_class:
+                        parent_type = current.extends
+                
+                deref = '' if self.embed_pointers else '*'
+                self._emit_line(f'{receiver}.{parent_class} = {deref}{self._constructor_name(parent_type)}({args})')
+                return
+            
+            if isinstance(stmt.expression, MatchExpr):
+                self._emit_match(stmt.expression, lambda value: self._emit_statement(ExpressionStmt(value)),
+                                 value_needed=False)
+                return
+            
+            # receiver?.Notify() -> if receiver != nil { receiver.Notify() }
+            if self._innermost_optional(stmt.expression):
+                self._emit_optional_stmt(stmt.expression)
+                return
+            
+            expr = self._lower_setter_call(stmt.expression) or self._unparenthesized(stmt.expression)
             self._emit_line(expr)
         
         elif isinstance(stmt, VarStmt):
+            may_be_nil = self._declared_nil_state(stmt)
+            if isinstance(stmt.value, (TernaryExpr, MatchExpr)):
+                self._emit_conditional_assignment(Identifier(stmt.name), stmt.value, stmt.type)
+                self.nil_states[-1][stmt.name] = may_be_nil
+                return
+            if isinstance(stmt.value, ComprehensionExpr) and not self._references(stmt.value, stmt.name):
+                self._emit_comprehension_declaration(stmt.name, stmt.value, stmt.type)
+                return
+            
+            self._declare(stmt.name, stmt.type or (self._infer_type(stmt.value) if stmt.value else None), may_be_nil)
             if stmt.type and stmt.value:
-                value = self._expr_to_string(stmt.value)
+                value = self._value_to_string(stmt.value, stmt.type)
                 self._emit_line(f'var {stmt.name} {stmt.type} = {value}')
             elif stmt.type:
                 self._emit_line(f'var {stmt.name} {stmt.type}')
             elif stmt.value:
-                value = self._expr_to_string(stmt.value)
+                value = self._unparenthesized(stmt.value)
                 self._emit_line(f'{stmt.name} := {value}')
             else:
                 raise TranspilerError("Variável deve ter tipo ou valor")
         
         elif isinstance(stmt, AssignStmt):
-            target = self._expr_to_string(stmt.target)
-            value = self._expr_to_string(stmt.value)
-            self._emit_line(f'{target} {stmt.operator} {value}')
+            if isinstance(stmt.value, (TernaryExpr, MatchExpr)) and stmt.operator in ('=', ':=') and \
+                    not isinstance(stmt.target, TupleExpr):
+                declared = None if stmt.operator == '=' else ''
+                self._emit_conditional_assignment(stmt.target, stmt.value, declared)
+                return
+            if isinstance(stmt.value, ComprehensionExpr) and stmt.operator == ':=' and \
+                    isinstance(stmt.target, Identifier) and not self._references(stmt.value, stmt.target.name):
+                self._emit_comprehension_declaration(stmt.target.name, stmt.value, None)
+                return
+            self._emit_line(self._stmt_to_string(stmt))
         
         elif isinstance(stmt, IfStmt):
-            condition = self._expr_to_string(stmt.condition)
+            narrowing = self._narrowing(stmt.condition)
+            if narrowing:
+                self._emit_narrowed_if(stmt, *narrowing)
+                return
+            
+            condition = self._unparenthesized(stmt.condition)
             self._emit_line(f'if {condition} {{')
             self._indent()
-            self._emit_statement(stmt.then_stmt)
+            self._with_nil_tests(self._nil_tests(stmt.condition), lambda: self._emit_nested_body(stmt.then_stmt))
             self._dedent()
             
             if stmt.else_stmt:
                 self._emit_line('} else {')
                 self._indent()
-                self._emit_statement(stmt.else_stmt)
+                self._with_nil_tests(self._nil_tests(stmt.condition, holds=False),
+                                     lambda: self._emit_nested_body(stmt.else_stmt))
                 self._dedent()
             
             self._emit_line('}')
+            
+            # Guard clause: after if p == nil { return }, p is not nil for the rest of the block
+            if not stmt.else_stmt and self._leaves_block(stmt.then_stmt):
+                self._assume_not_nil(self._nil_tests(stmt.condition, holds=False))
         
         elif isinstance(stmt, ForStmt):
+            self._push_scope()
             parts = []
             if stmt.init:
                 # For init, we need to capture as string
@@ -418,41 +5770,60 @@ class Transpiler:
                 parts.append('')
             
             if stmt.condition:
-                parts.append(self._expr_to_string(stmt.condition))
+                parts.append(self._unparenthesized(stmt.condition))
             else:
                 parts.append('')
             
+            # The body and the update run while the condition holds (for n != nil; n = n.next)
+            tests = self._nil_tests(stmt.condition) if stmt.condition else []
             if stmt.update:
-                update_str = self._stmt_to_string(stmt.update)
+                update_str = self._with_nil_tests(tests, lambda: self._stmt_to_string(stmt.update))
                 parts.append(update_str)
             else:
                 parts.append('')
             
+            self._push_breakable('loop', stmt)
             self._emit_line(f'for {"; ".join(parts)} {{')
             self._indent()
-            self._emit_statement(stmt.body)
+            self._with_nil_tests(tests, lambda: self._emit_nested_body(stmt.body))
             self._dedent()
             self._emit_line('}')
+            self._pop_breakable()
+            self._pop_scope()
+        
+        elif isinstance(stmt, ForInStmt):
+            self._emit_for_in(stmt.variables, stmt.iterable, stmt, lambda: self._emit_nested_body(stmt.body))
+        
+        elif isinstance(stmt, DoWhileStmt):
+            self._emit_do_while(stmt)
         
         elif isinstance(stmt, RangeStmt):
+            self._push_scope()
+            self._declare_range_vars(stmt)
+            self._push_breakable('loop', stmt)
             if stmt.key and stmt.value:
-                iterable = self._expr_to_string(stmt.iterable)
+                iterable = self._unparenthesized(stmt.iterable)
                 self._emit_line(f'for {stmt.key}, {stmt.value} := range {iterable} {{')
             elif stmt.key:
-                iterable = self._expr_to_string(stmt.iterable)
+                iterable = self._unparenthesized(stmt.iterable)
                 self._emit_line(f'for {stmt.key} := range {iterable} {{')
             else:
-                iterable = self._expr_to_string(stmt.iterable)
+                iterable = self._unparenthesized(stmt.iterable)
                 self._emit_line(f'for range {iterable} {{')
             
             self._indent()
-            self._emit_statement(stmt.body)
+            self._emit_nested_body(stmt.body)
             self._dedent()
             self._emit_line('}')
+            self._pop_breakable()
+            self._pop_scope()
         
         elif isinstance(stmt, SwitchStmt):
+            enum = self._check_enum_switch(stmt)
+            
+            self._push_breakable('switch', stmt)
             if stmt.expression:
-                expr = self._expr_to_string(stmt.expression)
+                expr = self._unparenthesized(stmt.expression)
                 self._emit_line(f'switch {expr} {{')
             else:
                 self._emit_line('switch {')
@@ -460,7 +5831,12 @@ class Transpiler:
             self._indent()
             
             for case in stmt.cases:
-                values = ', '.join(self._expr_to_string(v) for v in case.values)
+                # Bare member names are allowed in cases of a switch over an enum
+                values = ', '.join(
+                    f'{enum.name}{v.name}' if enum and isinstance(v, Identifier) and
+                    any(m.name == v.name for m in enum.members) and not self._lookup(v.name)
+                    else self._unparenthesized(v)
+                    for v in case.values)
                 self._emit_line(f'case {values}:')
                 self._indent()
                 for case_stmt in case.body:
@@ -476,168 +5852,717 @@ class Transpiler:
             
             self._dedent()
             self._emit_line('}')
+            self._pop_breakable()
+        
+        elif isinstance(stmt, YieldStmt):
+            if self.current_iterator is None:
+                raise self._error("yield outside an iterator", stmt)
+            if len(stmt.values) != len(self.current_iterator):
+                raise self._error(
+                    f"yield expects {len(self.current_iterator)} value(s), got {len(stmt.values)}", stmt)
+            values = ', '.join(self._unparenthesized(v) for v in stmt.values)
+            # The consuming loop stopped (break, return or an exception): end the iteration
+            self._emit_line(f'if !yield({values}) {{')
+            self._indent()
+            self._emit_line('return')
+            self._dedent()
+            self._emit_line('}')
         
         elif isinstance(stmt, ReturnStmt):
-            if stmt.value:
-                value = self._expr_to_string(stmt.value)
+            if stmt.value and self.current_iterator is not None:
+                raise self._error("An iterator cannot return a value; use yield", stmt)
+            if isinstance(stmt.value, TernaryExpr):
+                self._ternary_type(stmt.value, self.current_return_type)
+                self._emit_ternary(stmt.value, lambda value: self._emit_line(f'return {self._unparenthesized(value)}'))
+            elif isinstance(stmt.value, MatchExpr):
+                self._match_type(stmt.value, self.current_return_type)
+                self._emit_match(stmt.value, lambda value: self._emit_line(f'return {self._unparenthesized(value)}'),
+                                 terminate=True)
+            elif stmt.value:
+                self._check_return_values(stmt)
+                value = self._value_to_string(stmt.value, self.current_return_type)
                 self._emit_line(f'return {value}')
             else:
                 self._emit_line('return')
         
-        elif isinstance(stmt, BreakStmt):
-            self._emit_line('break')
+        elif isinstance(stmt, (BreakStmt, ContinueStmt)):
+            self._emit_jump(stmt)
         
-        elif isinstance(stmt, ContinueStmt):
-            self._emit_line('continue')
+        elif isinstance(stmt, LabeledStmt):
+            self.pending_label = stmt
+            self._emit_statement(stmt.statement)
         
         elif isinstance(stmt, GoStmt):
-            call = self._expr_to_string(stmt.call)
-            self._emit_line(f'go {call}')
+            self._emit_go_stmt(stmt)
+        
+        elif isinstance(stmt, SendStmt):
+            self._emit_line(self._send_to_string(stmt))
+        
+        elif isinstance(stmt, SelectStmt):
+            self._emit_select_stmt(stmt)
         
         elif isinstance(stmt, DeferStmt):
-            call = self._expr_to_string(stmt.call)
+            if self.current_handler:
+                # The handler is itself a deferred function: the call would run as soon as the handler ends
+                raise self._error(
+                    f"defer in a {self.current_handler} block would run when the exception handler returns, after the "
+                    "recovery wrapper, not when the function does; move it before the try", stmt)
+            call = self._unparenthesized(stmt.call)
             self._emit_line(f'defer {call}')
         
         elif isinstance(stmt, TryStmt):
             self._emit_try_stmt(stmt)
         
+        elif isinstance(stmt, UsingStmt):
+            self._emit_using_stmt(stmt)
+        
+        elif isinstance(stmt, WithStmt):
+            self._emit_with_stmt(stmt)
+        
         elif isinstance(stmt, ThrowStmt):
-            expr = self._expr_to_string(stmt.expression)
+            expr = self._unparenthesized(stmt.expression)
             self._emit_line(f'panic({expr})')
         
+        elif isinstance(stmt, IncDecStmt):
+            self._emit_line(self._stmt_to_string(stmt))
+        
+        elif isinstance(stmt, RawStmt):
+            self.required_imports.update(stmt.imports)
+            for line in stmt.code.split('\n'):
+                self._emit_line(line)
+        
         else:
             raise TranspilerError(f"Unsupported statement: {type(stmt)}")
     
+    def _push_breakable(self, kind: str, node: ASTNode) -> None:
+        """Enters a loop, switch or select ('loop', 'switch', 'select'), emitting the label written before it"""
+        labeled = self.pending_label
+        self.pending_label = None
+        if labeled:
+            if any(entry['label'] == labeled.label for entry in self.breakables):
+                raise self._error(f"Label {labeled.label} is already defined by an enclosing statement", labeled)
+            self._emit_line(f'{labeled.label}:')
+        self.breakables.append({'kind': kind, 'label': labeled.label if labeled else None, 'node': labeled,
+                                'used': False, 'emitted': False, 'line': (self.output, len(self.output) - 1)})
+    
+    def _pop_breakable(self) -> None:
+        """Leaves the innermost loop, switch or select; Go rejects labels that no break or continue uses"""
+        entry = self.breakables.pop()
+        if entry['label'] and not entry['used']:
+            raise self._error(f"Label {entry['label']} is defined and not used", entry['node'])
+    
+    def _jump_target(self, stmt: Statement) -> int:
+        """Index in self.breakables of the statement a break or continue leaves"""
+        keyword = 'break' if isinstance(stmt, BreakStmt) else 'continue'
+        for i in range(len(self.breakables) - 1, -1, -1):
+            entry = self.breakables[i]
+            if stmt.label and entry['label'] == stmt.label:
+                if keyword == 'continue' and entry['kind'] != 'loop':
+                    raise self._error(f"Invalid continue label {stmt.label}: "
+                                      f"it marks a {entry['kind']}, not a loop", stmt)
+                entry['used'] = True
+                return i
+            if not stmt.label and (keyword == 'break' or entry['kind'] == 'loop'):
+                return i
+        if stmt.label:
+            raise self._error(f"Undefined label {stmt.label}", stmt)
+        where = 'a loop, switch or select' if keyword == 'break' else 'a loop'
+        raise self._error(f"{keyword} is not in {where}", stmt)
+    
+    def _emit_jump(self, stmt: Statement) -> None:
+        """Emits break/continue (optionally labeled). One leaving the function literal of a try or using body
+        cannot jump directly: the literal returns a jump code instead, which the code after the call turns back
+        into the jump (see _close_jump_frame)"""
+        target = self._jump_target(stmt)
+        keyword = 'break' if isinstance(stmt, BreakStmt) else 'continue'
+        frame = self.jump_frames[-1] if self.jump_frames else None
+        if not frame or target >= frame['depth']:
+            entry = self.breakables[target]
+            if keyword == 'continue' and 'next' in entry:
+                # A do-while checks its condition at the end of the body: continue jumps there
+                if not entry['next']:
+                    entry['next'] = self._do_label()
+                self._emit_line(f'goto {entry["next"]}')
+                return
+            entry['emitted'] = entry['emitted'] or bool(stmt.label)
+            self._emit_line(f'{keyword} {stmt.label}' if stmt.label else keyword)
+            return
+        
+        if (keyword, stmt.label) not in frame['jumps']:
+            frame['jumps'].append((keyword, stmt.label))
+        code = frame['jumps'].index((keyword, stmt.label)) + 1
+        if frame['in_handler']:
+            # catch/finally run in a deferred function: set the literal's result, then leave the handler
+            self._emit_line(f'{frame["name"]} = {code}')
+            self._emit_line('return')
+        else:
+            self._emit_line(f'return {code}')
+    
+    def _emit_do_while(self, stmt: DoWhileStmt) -> None:
+        """Emits do-while: for { body; if !cond { break } } (repeat-until: if cond { break }). The body stays in
+        its own block, so a continue (goto next) can reach the condition without jumping over declarations"""
+        self._push_breakable('loop', stmt)
+        entry = self.breakables[-1]
+        entry['next'] = None  # label before the condition, named once a continue targets this loop
+        self._emit_line('for {')
+        self._indent()
+        self._emit_statement(stmt.body)
+        if entry['next']:
+            self._emit_line(f'{entry["next"]}:')
+        condition = stmt.condition if stmt.until else UnaryExpr('!', stmt.condition)
+        self._emit_line(f'if {self._unparenthesized(condition)} {{')
+        self._indent()
+        self._emit_line('break')
+        self._dedent()
+        self._emit_line('}')
+        self._dedent()
+        self._emit_line('}')
+        self._pop_breakable()
+        if entry['label'] and not entry['emitted']:
+            # Only continue (now goto) used the label: Go rejects it unused
+            output, index = entry['line']
+            del output[index]
+    
+    def _do_label(self) -> str:
+        """Name for the label before a do-while condition (labels are function-wide in Go)"""
+        self.do_labels += 1
+        return 'next' if self.do_labels == 1 else f'next{self.do_labels}'
+    
+    def _open_jump_frame(self, node: ASTNode) -> Dict:
+        """Starts the function literal of a try or using body (func() {), recording the jumps that leave it"""
+        name = 'jump' if not self.jump_frames else f'jump{len(self.jump_frames) + 1}'
+        while self._lookup(name) or self._references(node, name):
+            name += '_'
+        frame = {'depth': len(self.breakables), 'jumps': [], 'in_handler': False, 'name': name,
+                 'output': self.output, 'header': len(self.output)}
+        self.jump_frames.append(frame)
+        self._emit_line('func() {')
+        self._indent()
+        return frame
+    
+    def _close_jump_frame(self, frame: Dict, body: BlockStmt) -> None:
+        """Ends the function literal (}()). When jumps left it, the literal returns their code instead:
+        if jump := func() (jump int) { ... }(); jump == 1 { break outer } else if jump == 2 { ... }"""
+        self.jump_frames.pop()
+        terminated = body.statements and isinstance(body.statements[-1], (ReturnStmt, ThrowStmt, BreakStmt, ContinueStmt))
+        if frame['jumps'] and not terminated:
+            self._emit_line('return 0')
+        self._dedent()
+        if not frame['jumps']:
+            self._emit_line('}()')
+            return
+        
+        name = frame['name']
+        header = frame['output'][frame['header']]
+        frame['output'][frame['header']] = header.replace('func() {', f'if {name} := func() ({name} int) {{', 1)
+        for code, (keyword, label) in enumerate(frame['jumps'], start=1):
+            self._emit_line(f'}}(); {name} == {code} {{' if code == 1 else f'}} else if {name} == {code} {{')
+            self._indent()
+            # Emitted as a jump again: it may leave an enclosing try as well
+            self._emit_jump(BreakStmt(label) if keyword == 'break' else ContinueStmt(label))
+            self._dedent()
+        self._emit_line('}')
+    
     def _emit_try_stmt(self, stmt: TryStmt) -> None:
-        """Emits try statement (converted to defer/recover)"""
+        """Emits try statement (converted to defer/recover)
+        
+        Deferred calls run last-registered first, so the finally block is deferred before the recovery wrapper
+        (catch runs first, then finally) and defers of the try body run before both, within the try's catch."""
         self.exception_types.add('Exception')
+        outer_handler = self.current_handler
         
         # Função anônima com defer/recover
-        self._emit_line('func() {')
-        self._indent()
+        frame = self._open_jump_frame(stmt)
+        frame['in_handler'] = True
+        
+        # Finally block
+        if stmt.finally_block:
+            self.current_handler = 'finally'
+            self._emit_line('defer func() {')
+            self._indent()
+            self._emit_block_stmt(stmt.finally_block.body)
+            self._dedent()
+            self._emit_line('}()')
         
         # defer com recover
         if stmt.catch_blocks:
+            self.current_handler = 'catch'
+            
+            # catch (Exception e) catches every exception, like a catch without a type; the catches after the
+            # first catch-all never run (GP2002) and are left out
+            catches = []
+            for catch in stmt.catch_blocks:
+                catches.append((None if catch.exception_type == 'Exception' else catch.exception_type, catch))
+                if not catches[-1][0]:
+                    break
+            
+            # The recovered value and the exception are declared around the catches: their names must not hide
+            # the variables the catches use, nor be the names the catches give the exception
+            taken = {catch.exception_var for _, catch in catches}
+            bodies = [catch.body for _, catch in catches]
+            recovered, exception = (self._unused_name(name, bodies, taken) for name in ('r', 'ex'))
+            
             self._emit_line('defer func() {')
             self._indent()
-            self._emit_line('if r := recover(); r != nil {')
+            self._emit_line(f'if {recovered} := recover(); {recovered} != nil {{')
             self._indent()
             
-            # Converte recover para Exception
-            self._emit_line('var ex Exception')
-            self._emit_line('if e, ok := r.(Exception); ok {')
-            self._indent()
-            self._emit_line('ex = e')
-            self._dedent()
-            self._emit_line('} else {')
-            self._indent()
-            self._emit_line('ex = NewException("RuntimeError", fmt.Sprintf("%v", r))')
-            self._dedent()
-            self._emit_line('}')
-            self._emit_line()
+            # Converte recover para Exception (unless only a catch-all that ignores it is left)
+            if any(kind or catch.exception_var and self._references(catch.body, catch.exception_var)
+                   for kind, catch in catches):
+                # A value that is no Exception asserts to nil
+                self._emit_line(f'{exception}, _ := {recovered}.(Exception)')
+                self._emit_line(f'if {exception} == nil {{')
+                self._indent()
+                self._emit_line(f'{exception} = NewException("RuntimeError", fmt.Sprintf("%v", {recovered}))')
+                self._dedent()
+                self._emit_line('}')
+                self._emit_line()
             
-            # Catch blocks
-            for i, catch in enumerate(stmt.catch_blocks):
-                if i > 0:
-                    self._emit_line('} else ')
-                
-                if catch.exception_type:
-                    self._emit_line(f'if ex.Type() == "{catch.exception_type}" {{')
-                else:
-                    self._emit_line('if true {')
+            # Catch blocks: an if on the exception type for each typed catch, the catch-all as its else (or on
+            # its own when it comes first)
+            chained = False
+            for kind, catch in catches:
+                if kind:
+                    self._emit_line(f'{"} else if" if chained else "if"} {exception}.Type() == "{kind}" {{')
+                    chained = True
+                elif chained:
+                    self._emit_line('} else {')
                 
-                self._indent()
+                if chained:
+                    self._indent()
+                self._push_scope()
                 
                 if catch.exception_var:
-                    self._emit_line(f'{catch.exception_var} := ex')
+                    self._declare(catch.exception_var, 'Exception')
+                    if self._references(catch.body, catch.exception_var):
+                        self._emit_line(f'{catch.exception_var} := {exception}')
                 
                 self._emit_block_stmt(catch.body)
-                self._dedent()
+                self._pop_scope()
+                if chained:
+                    self._dedent()
             
-            self._emit_line('}')
+            if catches[-1][0]:
+                # No catch matches: the exception goes on to the enclosing try (or ends the program)
+                self._emit_line('} else {')
+                self._indent()
+                self._emit_line(f'panic({recovered})')
+                self._dedent()
+            if chained:
+                self._emit_line('}')
             self._dedent()
             self._emit_line('}')
             self._dedent()
             self._emit_line('}()')
         
-        # Finally block
-        if stmt.finally_block:
-            self._emit_line('defer func() {')
-            self._indent()
-            self._emit_block_stmt(stmt.finally_block.body)
-            self._dedent()
-            self._emit_line('}()')
-        
         # Try body
+        self.current_handler = None
+        frame['in_handler'] = False
+        self._emit_block_stmt(stmt.body)
+        self.current_handler = outer_handler
+        
+        self._close_jump_frame(frame, stmt.body)
+    
+    def _emit_using_stmt(self, stmt: UsingStmt) -> None:
+        """Emits using statement (a function literal, so the deferred Dispose runs when the block ends)"""
+        resource_type = self._infer_type(stmt.value)
+        info = self._class_info(resource_type)
+        if info and 'Dispose' not in self._class_method_set(info[0].name, info[1]):
+            raise TranspilerError(f"using requires a Dispose() method ({resource_type} has none)")
+        
+        frame = self._open_jump_frame(stmt)
+        self._push_scope()
+        self._declare(stmt.name, resource_type)
+        self._emit_line(f'{stmt.name} := {self._unparenthesized(stmt.value)}')
+        self._emit_line(f'defer {stmt.name}.Dispose()')
+        self._emit_block_stmt(stmt.body)
+        self._pop_scope()
+        self._close_jump_frame(frame, stmt.body)
+    
+    def _emit_with_stmt(self, stmt: WithStmt) -> None:
+        """Emits with statement: the acquire runs first and its release is deferred inside a function literal,
+        so it happens when the block ends (also when it throws), not when the enclosing function returns"""
+        value = stmt.value
+        function = value.function if isinstance(value, CallExpr) else None
+        if not stmt.name and isinstance(function, SelectorExpr) and function.field in ('Lock', 'RLock'):
+            # mu.Lock() pairs with mu.Unlock(), mu.RLock() with mu.RUnlock()
+            acquire = self._unparenthesized(value)
+            release = f'{self._expr_to_string(function.object)}.{"R" if function.field == "RLock" else ""}Unlock()'
+            resource_type = None
+        else:
+            resource_type = self._infer_type(value)
+            info = self._class_info(resource_type)
+            methods = self._class_method_set(info[0].name, info[1]) if info else set()
+            method = next((name for name in ('Unlock', 'Close', 'Dispose') if name in methods), None)
+            if not method:
+                raise self._error("with requires a Lock()/RLock() call or a value with an Unlock(), Close() or "
+                                  f"Dispose() method ({resource_type or self._expr_to_string(value)} has none)", stmt)
+            acquire = f'{stmt.name} := {self._unparenthesized(value)}' if stmt.name else None
+            release = f'{stmt.name or self._expr_to_string(value)}.{method}()'
+        
+        frame = self._open_jump_frame(stmt)
+        self._push_scope()
+        if stmt.name:
+            self._declare(stmt.name, resource_type)
+        if acquire:
+            self._emit_line(acquire)
+        self._emit_line(f'defer {release}')
         self._emit_block_stmt(stmt.body)
+        self._pop_scope()
+        self._close_jump_frame(frame, stmt.body)
+    
+    def _emit_go_stmt(self, stmt: GoStmt) -> None:
+        """go w.Process(item) -> go func(w *Worker, item string) { defer RecoverGoroutine(); w.Process(item) }(w, item):
+        the receiver and arguments are evaluated before the goroutine starts, as in Go, and an exception escaping
+        the goroutine goes to UncaughtExceptionHandler instead of crashing the program"""
+        self.exception_types.add('Exception')
+        self.uses_goroutine_runtime = True
+        if not self.project_mode:
+            self.required_imports.add('os')
+        
+        call = copy.copy(stmt.call)
+        signature = self._call_signature(call)
+        param_types = self._argument_types(signature[0], signature[2], call) if signature else []
+        keep_names = not any(isinstance(arg, LambdaExpr) for arg in call.args)
+        params, values = [], []
+        
+        def capture(expr: Expression, name: str, declared: Optional[str]) -> Expression:
+            """Turns an operand into a parameter of the goroutine's function (left in place when its type is unknown)"""
+            value_type = declared or self._infer_type(expr)
+            if isinstance(expr, (Literal, LambdaExpr, ThisExpr)) or not value_type:
+                return expr
+            code = self._unparenthesized(expr)
+            if keep_names and isinstance(expr, Identifier) and re.fullmatch(r'\w+', code):
+                name = code
+            params.append((name, value_type))
+            values.append(code)
+            return Identifier(name)
+        
+        if isinstance(call.function, SelectorExpr) and isinstance(call.function.object, Identifier) and \
+                self._lookup(call.function.object.name):
+            receiver = capture(call.function.object, 'receiver', None)
+            call.function = SelectorExpr(receiver, call.function.field, call.function.optional)
+        call.args = [
+            capture(arg, f'arg{i}', param_types[i] if i < len(param_types) and param_types[i] and
+                    not (signature and self._unbound(param_types[i], signature[3])) else None)
+            for i, arg in enumerate(call.args)]
         
+        self._push_scope()
+        for name, value_type in params:
+            self._declare(name, value_type)
+        self._emit_line(f'go func({", ".join(f"{name} {value_type}" for name, value_type in params)}) {{')
+        self._indent()
+        self._emit_line('defer RecoverGoroutine()')
+        self._emit_line(self._unparenthesized(call))
         self._dedent()
-        self._emit_line('}()')
+        self._emit_line(f'}}({", ".join(values)})')
+        self._pop_scope()
+    
+    def _channel_element(self, type_name: Optional[str]) -> Optional[str]:
+        """Element type of chan T, <-chan T and chan<- T, or None for other types"""
+        for prefix in ('chan<- ', '<-chan ', 'chan '):
+            if type_name and type_name.startswith(prefix):
+                return type_name[len(prefix):]
+        return None
+    
+    def _check_channel(self, channel: Expression, operation: str, node: ASTNode) -> Optional[str]:
+        """Checks that a send or receive ('send to' / 'receive from') uses a channel that allows it,
+        and returns its element type (None when the channel's type is unknown)"""
+        channel_type = self._infer_type(channel)
+        if not channel_type:
+            return None
+        element = self._channel_element(channel_type)
+        if element is None:
+            problem = f'non-channel {self._expr_to_string(channel)} ({channel_type})'
+        elif channel_type.startswith('<-chan ' if operation == 'send to' else 'chan<- '):
+            direction = 'receive-only' if operation == 'send to' else 'send-only'
+            problem = f'{direction} channel {self._expr_to_string(channel)} ({channel_type})'
+        else:
+            return element
+        raise self._error(f"Invalid operation: cannot {operation} {problem}", node)
+    
+    def _send_to_string(self, stmt: SendStmt) -> str:
+        """ch <- v, with the value checked against (and lambdas typed from) the channel's element type"""
+        element = self._check_channel(stmt.channel, 'send to', stmt)
+        self._check_assignable(stmt.value, self._infer_type(stmt.value), element, 'send', stmt)
+        return f'{self._expr_to_string(stmt.channel)} <- {self._value_to_string(stmt.value, element)}'
+    
+    def _emit_select_stmt(self, stmt: SelectStmt) -> None:
+        """Emits a select statement as is; variables received in a case are scoped to it, and the case bodies
+        run inline, so a throw inside one reaches an enclosing try as from any other statement"""
+        self._push_breakable('select', stmt)
+        self._emit_line('select {')
+        for case in stmt.cases:
+            self._push_scope()
+            if case.comm is None:
+                self._emit_line('default:')
+            elif isinstance(case.comm, SendStmt):
+                self._emit_line(f'case {self._send_to_string(case.comm)}:')
+            else:
+                self._emit_line(f'case {self._stmt_to_string(case.comm)}:')
+            self._indent()
+            for case_stmt in case.body:
+                self._emit_statement(case_stmt)
+            self._dedent()
+            self._pop_scope()
+        self._emit_line('}')
+        self._pop_breakable()
+    
+    def _declare_tuple_targets(self, targets: TupleExpr, value: Expression) -> None:
+        """Declares the variables of a, b := ... from the value's result types"""
+        if isinstance(value, TupleExpr):
+            types = [self._infer_type(element) for element in value.elements]
+        else:
+            result = self._infer_type(value)
+            types = self._split_result_types(result) if result else []
+            if isinstance(value, IndexExpr) and len(targets.elements) == 2:
+                types = [result, 'bool']  # comma-ok map lookup
+            if isinstance(value, UnaryExpr) and value.operator == '<-' and len(targets.elements) == 2:
+                types = [result, 'bool']  # comma-ok receive (false once the channel is closed and drained)
+        
+        for target, type_name in zip(targets.elements, types):
+            if isinstance(target, Identifier):
+                self._declare(target.name, type_name)
+    
+    def _declare_range_vars(self, stmt: RangeStmt) -> None:
+        """Declares the key/value variables of a range loop from the iterable's type"""
+        iterable_type = self._infer_type(stmt.iterable)
+        key_type, value_type = None, None
+        if iterable_type:
+            if iterable_type.startswith('[]'):
+                key_type, value_type = 'int', iterable_type[2:]
+            elif iterable_type.startswith('map['):
+                key_type, value_type = self._split_map_type(iterable_type)
+            elif iterable_type == 'string':
+                key_type, value_type = 'int', 'rune'
+            elif self._channel_element(iterable_type):
+                key_type = self._channel_element(iterable_type)
+            elif iterable_type.startswith('iter.Seq'):
+                element_types = self._split_type_args(iterable_type)[1]
+                key_type, value_type = element_types[0], element_types[1] if len(element_types) > 1 else None
+        
+        if stmt.key:
+            self._declare(stmt.key, key_type)
+        if stmt.value:
+            self._declare(stmt.value, value_type)
     
     def _stmt_to_string(self, stmt: Statement) -> str:
         """Converts statement to string"""
         if isinstance(stmt, VarStmt):
+            may_be_nil = self._declared_nil_state(stmt)
+            self._declare(stmt.name, stmt.type or (self._infer_type(stmt.value) if stmt.value else None), may_be_nil)
             if stmt.type and stmt.value:
-                value = self._expr_to_string(stmt.value)
+                value = self._value_to_string(stmt.value, stmt.type)
                 return f'var {stmt.name} {stmt.type} = {value}'
             elif stmt.value:
-                value = self._expr_to_string(stmt.value)
+                value = self._unparenthesized(stmt.value)
                 return f'{stmt.name} := {value}'
             else:
                 return f'var {stmt.name} {stmt.type}'
         
         elif isinstance(stmt, AssignStmt):
+            if stmt.operator in ('=', ':='):
+                self._check_tuple_assignment(stmt)
+            if stmt.operator == ':=' and isinstance(stmt.target, Identifier):
+                self._declare(stmt.target.name, self._infer_type(stmt.value), self._inferred_nil_state(stmt.value))
+            elif stmt.operator == ':=' and isinstance(stmt.target, TupleExpr):
+                self._declare_tuple_targets(stmt.target, stmt.value)
+            
+            self._check_record_assignment(stmt.target)
+            self._check_value_receiver_assignment(stmt.target)
+            if self._innermost_optional(stmt.target):
+                raise self._error(
+                    "Cannot assign to an optional chain", self._innermost_optional(stmt.target))
+            
+            event = self._event_of(stmt.target)
+            if event:
+                return self._lower_event_subscription(stmt, event)
+            
+            indexed = self._lower_index_set(stmt)
+            if indexed:
+                return indexed
+            
             target = self._expr_to_string(stmt.target)
-            value = self._expr_to_string(stmt.value)
+            
+            # Compound assignment on a class with an overloaded operator: a += b -> a = a.Add(b)
+            if stmt.operator not in ('=', ':='):
+                lowered = self._lower_operator(BinaryExpr(stmt.target, stmt.operator[:-1], stmt.value))
+                if lowered:
+                    return f'{target} = {lowered}'
+            
+            expected = self._infer_type(stmt.target) if stmt.operator == '=' else None
+            value = self._value_to_string(stmt.value, expected)
+            if stmt.operator == '=':
+                self._check_nil_assignment(stmt.target, stmt.value, stmt)
+                self._track_assignment(stmt.target, stmt.value)
             return f'{target} {stmt.operator} {value}'
         
+        elif isinstance(stmt, IncDecStmt):
+            return self._lower_inc_dec(stmt)
+        
         elif isinstance(stmt, ExpressionStmt):
-            return self._expr_to_string(stmt.expression)
+            return self._lower_setter_call(stmt.expression) or self._unparenthesized(stmt.expression)
         
         else:
             raise TranspilerError(f"Statement cannot be converted to string: {type(stmt)}")
     
     def _expr_to_string(self, expr: Expression) -> str:
         """Converts expression to string"""
+        if self._innermost_optional(expr):
+            return self._lower_optional_chain(expr)
+        
         if isinstance(expr, BinaryExpr):
-            left = self._expr_to_string(expr.left)
-            right = self._expr_to_string(expr.right)
-            return f'({left} {expr.operator} {right})'
+            code, precedence = self._binary_to_string(expr)
+            return code if precedence is None else f'({code})'
+        
+        elif isinstance(expr, IncDecExpr):
+            raise self._error(
+                f"{self._expr_to_string(expr.operand)}{expr.operator} is a statement in Go "
+                "and cannot be used as a value", expr)
         
         elif isinstance(expr, UnaryExpr):
+            if expr.operator == '-':
+                negation = self._operator_method(self._infer_type(expr.operand), 'neg')
+                if negation:
+                    return f'{self._expr_to_string(expr.operand)}.{negation[0].name}()'
+                if isinstance(expr.operand, Literal) and expr.operand.go_type:
+                    return self._number_literal(expr.operand, negative=True)  # int8(-128), not -int8(128)
+            if expr.operator == '<-':
+                self._check_channel(expr.operand, 'receive from', expr)
+            
             operand = self._expr_to_string(expr.operand)
+            if operand.startswith(expr.operator[-1]) and expr.operator in ('-', '+', '<-'):
+                operand = f'({operand})'  # -(-a), which written together would read as a decrement
             return f'{expr.operator}{operand}'
         
         elif isinstance(expr, CallExpr):
+            lowered = self._lower_string_method(expr)
+            if lowered:
+                return lowered
+            self._check_arguments(expr)
+            args = self._args_to_string(expr)
+            # Calling an event raises it: car.OnLowFuel(level) -> car.RaiseOnLowFuel(level)
+            if self._event_of(expr.function):
+                return f'{self._expr_to_string(expr.function.object)}.Raise{expr.function.field}({args})'
             func = self._expr_to_string(expr.function)
-            args = ', '.join(self._expr_to_string(arg) for arg in expr.args)
+            # --inline-accessors: p.GetName() -> p.name
+            accessor = self._inlined_accessor(expr)
+            if accessor and accessor[0] == 'get':
+                return f'{func.rpartition(".")[0]}.{accessor[1]}'
             return f'{func}({args})'
         
         elif isinstance(expr, IndexExpr):
+            indexed = self._lower_index_get(expr)
+            if indexed:
+                return indexed
+            
             obj = self._expr_to_string(expr.object)
-            index = self._expr_to_string(expr.index)
+            index = self._unparenthesized(expr.index)
             return f'{obj}[{index}]'
         
         elif isinstance(expr, SelectorExpr):
+            # Class constants: Box.Max -> BoxMax
+            constant = self._class_constant(expr)
+            if constant:
+                return f'{constant[0].name}{expr.field}'
+            
+            # Enum members and helpers: Color.Red -> ColorRed, Color.Values() -> ColorValues()
+            enum = self._enum_reference(expr.object)
+            if enum:
+                if expr.field in ('Values', 'FromString') or any(m.name == expr.field for m in enum.members):
+                    return f'{enum.name}{expr.field}'
+                raise TranspilerError(f"Enum {enum.name} has no member {expr.field}")
+            
+            # Associated values: planet.mass -> planetTable[planet].mass
+            object_type = self._infer_type(expr.object)
+            if object_type in self.enums and any(f.name == expr.field for f in self.enums[object_type].fields):
+                obj = self._expr_to_string(expr.object)
+                return f'{self._lower_first(object_type)}Table[{obj}].{expr.field}'
+            
+            self._check_exported(expr, object_type)
+            self._check_member(expr, object_type)
+            self._check_nil_access(expr)
+            
             obj = self._expr_to_string(expr.object)
+            current = self.classes.get(self.current_class)
+            if isinstance(expr.object, SuperExpr) and current and current.extends:
+                # super.Speak() -> this.Animal.Speak(): through the embedded base, not the override
+                base, _ = self._split_type_args(current.extends)
+                obj = f'{obj}.{base.rpartition(".")[2]}'
             return f'{obj}.{expr.field}'
         
         elif isinstance(expr, Identifier):
-            return expr.name
+            if self._is_outer_reference(expr):
+                return f'{self.current_receiver}.outer'
+            if expr.name in self.objects and not self._lookup(expr.name):
+                return f'{expr.name}()'
+            constant = self._class_constant(expr)
+            if constant:
+                return f'{constant[0].name}{expr.name}'
+            return self.aliases.get(expr.name, expr.name)
+        
+        elif isinstance(expr, TypeExpr):
+            return expr.type
+        
+        elif isinstance(expr, TupleExpr):
+            return ', '.join(self._unparenthesized(element) for element in expr.elements)
         
         elif isinstance(expr, Literal):
             if expr.type == 'string':
-                # Escape special characters
-                escaped = expr.value.replace('\\', '\\\\').replace('"', '\\"').replace('\n', '\\n').replace('\t', '\\t')
-                return f'"{escaped}"'
+                return string_literal(expr.value, expr.raw)
             elif expr.type == 'bool':
                 return 'true' if expr.value else 'false'
+            elif expr.type == 'char':
+                return rune_literal(expr.value)
             else:
-                return str(expr.value)
+                return self._number_literal(expr)
+        
+        elif isinstance(expr, InterpolatedString):
+            return self._lower_interpolation(expr)
+        
+        elif isinstance(expr, TernaryExpr):
+            return self._lower_ternary(expr)
+        
+        elif isinstance(expr, CoalesceExpr):
+            return self._lower_coalesce(expr)
+        
+        elif isinstance(expr, MatchExpr):
+            return self._lower_match(expr)
+        
+        elif isinstance(expr, LambdaExpr):
+            return self._lower_lambda(expr)
+        
+        elif isinstance(expr, ComprehensionExpr):
+            return self._lower_comprehension(expr)
+        
+        elif isinstance(expr, (ArrayLiteral, MapLiteral)):
+            return self._composite_to_string(expr)
         
         elif isinstance(expr, NewExpr):
-            args = ', '.join(self._expr_to_string(arg) for arg in expr.args)
-            return f'New{expr.class_name}({args})'
+            if expr.body:
+                return self._anonymous_instance(expr)
+            if expr.class_name in self.objects:
+                raise TranspilerError(f"{expr.class_name} is an object; use {expr.class_name} directly instead of new")
+            self._check_arguments(expr)
+            args = self._args_to_string(expr)
+            inner = self._inner_construction(expr)
+            if inner:
+                class_name, outer = inner
+                return f'New{class_name}({outer}, {args})' if args else f'New{class_name}({outer})'
+            class_type = expr.class_name
+            if expr.type_args:
+                class_type += '[' + ', '.join(expr.type_args) + ']'
+            return f'{self._constructor_name(class_type)}({args})'
+        
+        elif isinstance(expr, WithExpr):
+            return self._lower_with(expr)
+        
+        elif isinstance(expr, IsExpr):
+            return self._lower_is(expr)
+        
+        elif isinstance(expr, CastExpr):
+            return self._lower_cast(expr)
         
         elif isinstance(expr, ThisExpr):
             return getattr(self, 'current_receiver', 'this')